@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// checkEnv is what the --check probes look at, so tests can fake the
+// operating system and the servers
+type checkEnv struct {
+	goos     string
+	stat     func(name string) (fs.FileInfo, error)
+	readDir  func(name string) ([]fs.DirEntry, error)
+	readFile func(name string) ([]byte, error)
+	lookPath func(file string) (string, error)
+	geteuid  func() int
+	ping     func(ctx context.Context, serverURL string) error
+}
+
+// osCheckEnv probes the running system and pings servers with the SDK
+func osCheckEnv(goos string) checkEnv {
+	return checkEnv{
+		goos:     goos,
+		stat:     os.Stat,
+		readDir:  os.ReadDir,
+		readFile: os.ReadFile,
+		lookPath: exec.LookPath,
+		geteuid:  os.Geteuid,
+		ping: func(ctx context.Context, serverURL string) error {
+			return agfs.NewClient(serverURL).Ping(ctx)
+		},
+	}
+}
+
+// checkResult is the outcome of one --check probe. Err is nil when it
+// passed, and Detail says what was found either way.
+type checkResult struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// checkFUSE checks that FUSE is installed: the device and fusermount on
+// Linux, macFUSE on macOS
+func checkFUSE(env checkEnv) checkResult {
+	r := checkResult{Name: "fuse"}
+	switch env.goos {
+	case "linux":
+		info, err := env.stat("/dev/fuse")
+		if err != nil {
+			r.Err = fmt.Errorf("/dev/fuse is missing, install fuse3 (or load the fuse module with modprobe fuse): %w", err)
+			return r
+		}
+		if info.Mode()&fs.ModeCharDevice == 0 {
+			r.Err = errors.New("/dev/fuse is not a character device")
+			return r
+		}
+		for _, name := range []string{"fusermount3", "fusermount"} {
+			if path, err := env.lookPath(name); err == nil {
+				r.Detail = "/dev/fuse and " + path + " found"
+				return r
+			}
+		}
+		r.Err = errors.New("neither fusermount3 nor fusermount is in PATH, install fuse3")
+	case "darwin":
+		for _, path := range []string{"/Library/Filesystems/macfuse.fs", "/Library/Filesystems/osxfuse.fs"} {
+			if _, err := env.stat(path); err == nil {
+				r.Detail = path + " found"
+				return r
+			}
+		}
+		r.Err = errors.New("macFUSE is not installed, install it with brew install --cask macfuse")
+	default:
+		r.Err = fmt.Errorf("FUSE is not supported on %s", env.goos)
+	}
+	return r
+}
+
+// checkMountpoint checks that the mount point is an empty directory
+func checkMountpoint(env checkEnv, mountpoint string) checkResult {
+	r := checkResult{Name: "mountpoint"}
+	info, err := env.stat(mountpoint)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		r.Err = fmt.Errorf("%s does not exist, create it with mkdir -p %s", mountpoint, mountpoint)
+		return r
+	case errors.Is(err, fs.ErrPermission):
+		r.Err = fmt.Errorf("%s is not accessible: %w", mountpoint, err)
+		return r
+	case err != nil:
+		// A dead FUSE mount fails stat with ENOTCONN
+		r.Err = fmt.Errorf("%s cannot be inspected, it may be a stale mount (unmount it with %s): %w", mountpoint, unmountHint(env.goos, mountpoint), err)
+		return r
+	case !info.IsDir():
+		r.Err = fmt.Errorf("%s is not a directory", mountpoint)
+		return r
+	}
+	entries, err := env.readDir(mountpoint)
+	if err != nil {
+		r.Err = fmt.Errorf("%s cannot be listed: %w", mountpoint, err)
+		return r
+	}
+	if len(entries) > 0 {
+		r.Err = fmt.Errorf("%s is not empty (%d entries), it may already be mounted", mountpoint, len(entries))
+		return r
+	}
+	r.Detail = mountpoint + " is an empty directory"
+	return r
+}
+
+// checkAllowOther checks that --allow-other, when given, is permitted: on
+// Linux non-root users need user_allow_other in /etc/fuse.conf
+func checkAllowOther(env checkEnv, allowOther bool) checkResult {
+	r := checkResult{Name: "allow-other"}
+	switch {
+	case !allowOther:
+		r.Detail = "not requested"
+	case env.goos != "linux":
+		r.Detail = "requested, macFUSE only honours it for non-root users if the administrator allowed it"
+	case env.geteuid() == 0:
+		r.Detail = "requested, running as root"
+	default:
+		conf, err := env.readFile("/etc/fuse.conf")
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			r.Err = fmt.Errorf("cannot read /etc/fuse.conf: %w", err)
+			return r
+		}
+		if !hasFuseConfOption(bytes.NewReader(conf), "user_allow_other") {
+			r.Err = errors.New("--allow-other needs user_allow_other in /etc/fuse.conf when not running as root")
+			return r
+		}
+		r.Detail = "requested, user_allow_other is set in /etc/fuse.conf"
+	}
+	return r
+}
+
+// hasFuseConfOption reports whether a fuse.conf sets option, ignoring comments
+func hasFuseConfOption(conf io.Reader, option string) bool {
+	scanner := bufio.NewScanner(conf)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		if strings.TrimSpace(line) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// checkServer checks that the server at serverURL answers
+func checkServer(env checkEnv, serverURL string) checkResult {
+	r := checkResult{Name: "server " + serverURL}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := env.ping(ctx, serverURL); err != nil {
+		r.Err = fmt.Errorf("not reachable: %w", err)
+		return r
+	}
+	r.Detail = "reachable"
+	return r
+}
+
+// runChecks runs every --check probe for mounting serverURLs at mountpoint
+func runChecks(env checkEnv, mountpoint string, allowOther bool, serverURLs []string) []checkResult {
+	results := []checkResult{
+		checkFUSE(env),
+		checkMountpoint(env, filepath.Clean(mountpoint)),
+		checkAllowOther(env, allowOther),
+	}
+	for _, url := range serverURLs {
+		results = append(results, checkServer(env, url))
+	}
+	return results
+}
+
+// printChecks writes a pass/fail line per result and reports whether all
+// of them passed
+func printChecks(w io.Writer, results []checkResult) bool {
+	ok := true
+	for _, r := range results {
+		if r.Err != nil {
+			ok = false
+			fmt.Fprintf(w, "FAIL  %-12s %v\n", r.Name, r.Err)
+			continue
+		}
+		fmt.Fprintf(w, "PASS  %-12s %s\n", r.Name, r.Detail)
+	}
+	if ok {
+		fmt.Fprintln(w, "\nReady to mount.")
+	} else {
+		fmt.Fprintln(w, "\nFix the failed checks before mounting.")
+	}
+	return ok
+}
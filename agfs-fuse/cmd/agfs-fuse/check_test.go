@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// fakeCheckEnv is a checkEnv over an in-memory file system, with the given
+// commands in PATH and servers answering pings
+func fakeCheckEnv(goos string, files fstest.MapFS, commands []string, up map[string]bool) checkEnv {
+	rel := func(name string) string { return strings.TrimPrefix(name, "/") }
+	return checkEnv{
+		goos:     goos,
+		stat:     func(name string) (fs.FileInfo, error) { return files.Stat(rel(name)) },
+		readDir:  func(name string) ([]fs.DirEntry, error) { return files.ReadDir(rel(name)) },
+		readFile: func(name string) ([]byte, error) { return files.ReadFile(rel(name)) },
+		lookPath: func(file string) (string, error) {
+			for _, c := range commands {
+				if c == file {
+					return "/usr/bin/" + file, nil
+				}
+			}
+			return "", exec.ErrNotFound
+		},
+		geteuid: func() int { return 1000 },
+		ping: func(ctx context.Context, serverURL string) error {
+			if !up[serverURL] {
+				return errors.New("connection refused")
+			}
+			return nil
+		},
+	}
+}
+
+func TestCheckFUSE(t *testing.T) {
+	device := fstest.MapFS{"dev/fuse": {Mode: fs.ModeDevice | fs.ModeCharDevice}}
+
+	if r := checkFUSE(fakeCheckEnv("linux", device, []string{"fusermount3"}, nil)); r.Err != nil || !strings.Contains(r.Detail, "fusermount3") {
+		t.Errorf("Expected FUSE to be found, got %+v", r)
+	}
+	if r := checkFUSE(fakeCheckEnv("linux", device, nil, nil)); r.Err == nil || !strings.Contains(r.Err.Error(), "fusermount") {
+		t.Errorf("Expected a missing fusermount to fail, got %+v", r)
+	}
+	if r := checkFUSE(fakeCheckEnv("linux", fstest.MapFS{}, []string{"fusermount"}, nil)); r.Err == nil || !strings.Contains(r.Err.Error(), "/dev/fuse") {
+		t.Errorf("Expected a missing device to fail, got %+v", r)
+	}
+	if r := checkFUSE(fakeCheckEnv("linux", fstest.MapFS{"dev/fuse": {}}, []string{"fusermount"}, nil)); r.Err == nil {
+		t.Errorf("Expected a regular file at /dev/fuse to fail, got %+v", r)
+	}
+
+	macfuse := fstest.MapFS{"Library/Filesystems/macfuse.fs": {Mode: fs.ModeDir}}
+	if r := checkFUSE(fakeCheckEnv("darwin", macfuse, nil, nil)); r.Err != nil {
+		t.Errorf("Expected macFUSE to be found, got %+v", r)
+	}
+	if r := checkFUSE(fakeCheckEnv("darwin", fstest.MapFS{}, nil, nil)); r.Err == nil || !strings.Contains(r.Err.Error(), "macfuse") {
+		t.Errorf("Expected a missing macFUSE to fail, got %+v", r)
+	}
+	if r := checkFUSE(fakeCheckEnv("windows", device, nil, nil)); r.Err == nil {
+		t.Errorf("Expected an unsupported platform to fail, got %+v", r)
+	}
+}
+
+func TestCheckMountpoint(t *testing.T) {
+	files := fstest.MapFS{
+		"mnt/empty":     {Mode: fs.ModeDir},
+		"mnt/busy/file": {},
+		"mnt/file":      {},
+	}
+	env := fakeCheckEnv("linux", files, nil, nil)
+
+	if r := checkMountpoint(env, "/mnt/empty"); r.Err != nil {
+		t.Errorf("Expected an empty directory to pass, got %+v", r)
+	}
+	for path, want := range map[string]string{
+		"/mnt/missing": "does not exist",
+		"/mnt/busy":    "not empty",
+		"/mnt/file":    "not a directory",
+	} {
+		if r := checkMountpoint(env, path); r.Err == nil || !strings.Contains(r.Err.Error(), want) {
+			t.Errorf("Expected %s to fail with %q, got %+v", path, want, r)
+		}
+	}
+}
+
+func TestCheckAllowOther(t *testing.T) {
+	env := fakeCheckEnv("linux", fstest.MapFS{}, nil, nil)
+	if r := checkAllowOther(env, false); r.Err != nil {
+		t.Errorf("Expected the check to pass when not requested, got %+v", r)
+	}
+	if r := checkAllowOther(env, true); r.Err == nil || !strings.Contains(r.Err.Error(), "user_allow_other") {
+		t.Errorf("Expected a missing fuse.conf to fail, got %+v", r)
+	}
+
+	env = fakeCheckEnv("linux", fstest.MapFS{"etc/fuse.conf": {Data: []byte("# user_allow_other\nmount_max = 1000\n")}}, nil, nil)
+	if r := checkAllowOther(env, true); r.Err == nil {
+		t.Errorf("Expected a commented-out option to fail, got %+v", r)
+	}
+	env.geteuid = func() int { return 0 }
+	if r := checkAllowOther(env, true); r.Err != nil {
+		t.Errorf("Expected root to pass, got %+v", r)
+	}
+
+	env = fakeCheckEnv("linux", fstest.MapFS{"etc/fuse.conf": {Data: []byte("user_allow_other  # for agfs\n")}}, nil, nil)
+	if r := checkAllowOther(env, true); r.Err != nil {
+		t.Errorf("Expected user_allow_other to pass, got %+v", r)
+	}
+}
+
+func TestRunChecks(t *testing.T) {
+	files := fstest.MapFS{
+		"dev/fuse": {Mode: fs.ModeDevice | fs.ModeCharDevice},
+		"mnt/agfs": {Mode: fs.ModeDir},
+	}
+	env := fakeCheckEnv("linux", files, []string{"fusermount3"}, map[string]bool{"http://up:8080": true})
+
+	var out bytes.Buffer
+	if !printChecks(&out, runChecks(env, "/mnt/agfs/", false, []string{"http://up:8080"})) {
+		t.Errorf("Expected every check to pass, got:\n%s", out.String())
+	}
+
+	out.Reset()
+	if printChecks(&out, runChecks(env, "/mnt/agfs", false, []string{"http://up:8080", "http://down:8080"})) {
+		t.Error("Expected an unreachable server to fail the checks")
+	}
+	if !strings.Contains(out.String(), "FAIL  server http://down:8080") || !strings.Contains(out.String(), "PASS  server http://up:8080") {
+		t.Errorf("Expected a line per server, got:\n%s", out.String())
+	}
+}
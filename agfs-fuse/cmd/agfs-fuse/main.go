@@ -1,32 +1,85 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
 	"os/signal"
-	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-sdk/go/cmdutil"
+	"github.com/dongxuny/agfs-fuse/pkg/cache"
+	"github.com/dongxuny/agfs-fuse/pkg/control"
 	"github.com/dongxuny/agfs-fuse/pkg/fusefs"
 	"github.com/dongxuny/agfs-fuse/pkg/version"
 	"github.com/hanwen/go-fuse/v2/fs"
-	"github.com/hanwen/go-fuse/v2/fuse"
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
 	var (
 		serverURL   = flag.String("agfs-server-url", "http://localhost:8080", "AGFS server URL")
+		token       = flag.String("token", os.Getenv("AGFS_TOKEN"), "Bearer token authenticating requests to the servers (default $AGFS_TOKEN)")
 		mountpoint  = flag.String("mount", "", "Mount point directory")
 		cacheTTL    = flag.Duration("cache-ttl", 5*time.Second, "Cache TTL duration")
+		adaptive    = flag.Bool("adaptive-cache", false, "Cache the attributes of each path longer the less often it is seen changing, within --cache-ttl-min and --cache-ttl-max")
+		cacheTTLMin = flag.Duration("cache-ttl-min", 0, "Shortest attribute TTL of --adaptive-cache (0 = --cache-ttl/10)")
+		cacheTTLMax = flag.Duration("cache-ttl-max", 0, "Longest attribute TTL of --adaptive-cache (0 = 10 * --cache-ttl)")
+		attrTTL     = flag.Duration("attr-timeout", 0, "How long the kernel caches file attributes (0 = --cache-ttl, or the path's TTL with --adaptive-cache)")
+		entryTTL    = flag.Duration("entry-timeout", 0, "How long the kernel caches which file a name refers to (0 = --cache-ttl)")
+		negativeTTL = flag.Duration("negative-timeout", 0, "How long the kernel remembers that a name doesn't exist (0 = not at all)")
+		subscribe   = flag.Bool("subscribe", false, "Subscribe to the server's change events, dropping cached data of changed paths right away rather than after --cache-ttl")
 		debug       = flag.Bool("debug", false, "Enable debug output")
-		logLevel    = flag.String("log-level", "info", "Log level (debug, info, warn, error)")
+		logLevel    = flag.String("log-level", "info", "Log level (trace, debug, info, warn, error)")
+		logFormat   = flag.String("log-format", "text", "Log format (text, json)")
 		allowOther  = flag.Bool("allow-other", false, "Allow other users to access the mount")
 		showVersion = flag.Bool("version", false, "Show version information")
+		check       = flag.Bool("check", false, "Check that FUSE, the mount point, --allow-other and the servers are ready, print a report and exit without mounting")
+		prefetch    = flag.Int("prefetch-concurrency", 0, "Concurrent stats used to prefetch directory children after a listing (0 = disabled)")
+		prefetchLs  = flag.Bool("prefetch-attrs", false, "Cache the attributes of directory children from their listing instead of statting them")
+		readdirSize = flag.Int("readdir-batch-size", 0, "Entries of a directory listing fetched at a time while the kernel reads it, so large directories aren't held in memory whole (0 = fetch whole listings)")
+		blockCache  = flag.Int("block-cache-size", 0, "MiB of file data cached in blocks shared by all open files (0 = disabled)")
+		blockSize   = flag.Int("block-size", 128, "Block cache block size in KiB")
+		cacheKind   = flag.String("cache-backend", "memory", "Where the block cache and directory listings are cached (memory, disk, none)")
+		cacheDir    = flag.String("cache-dir", "", "Directory of --cache-backend=disk (empty = a temporary directory)")
+		dirCache    = flag.Int("dir-cache-size", 64, "MiB of directory listings cached")
+		readahead   = flag.Int("readahead", 1024, "KiB a file read sequentially in small pieces is read ahead, at most (0 = disabled)")
+		ioPattern   = flag.String("io-pattern", "auto", "Whether files are read ahead: auto stops while a file's reads look random, sequential always reads ahead, random never does (auto, sequential, random)")
+		stalePolicy = flag.String("stale-policy", "reread", "What reads of cached data do once another client changed the file (reread, estale)")
+		directIO    = flag.Bool("direct-io", false, "Open every file as if with O_DIRECT: reads always go to the server, bypassing readahead, the block cache and streaming")
+		commitWin   = flag.Duration("write-commit-window", 0, "How long writes to an open file are held and merged before they are sent to the server (0 = send every write)")
+		commitSize  = flag.Int("write-commit-size", 4096, "KiB of writes an open file holds before sending them without waiting for --write-commit-window")
+		breakerFail = flag.Int("breaker-threshold", 5, "Consecutive server failures before requests fail fast with EIO (0 = disabled)")
+		breakerWait = flag.Duration("breaker-cooldown", 5*time.Second, "How long requests fail fast before probing the server again")
+		maxInflight = flag.Int("max-inflight", 64, "Maximum number of requests sent to the server at once; more wait for one to complete (0 = unlimited)")
+		bandwidth   = flag.Int64("bandwidth", 0, "KiB/s the mount sends to and receives from the servers, at most (0 = unlimited)")
+		handleBW    = flag.Int64("handle-bandwidth", 0, "KiB/s each open file reads and writes, at most (0 = unlimited)")
+		requestIDs  = flag.Bool("request-ids", true, "Send each operation's requests with a request ID, logged at debug level here and on the server")
+		streamWin   = flag.Int("stream-window", 1024, "KiB a streaming read may buffer ahead of the application")
+		streamWait  = flag.Duration("stream-read-timeout", 5*time.Second, "How long a streaming read waits for data before returning EOF")
+		streamFirst = flag.Duration("stream-first-read-timeout", time.Second, "How long a streaming read waits for a stream's first data before returning EOF")
+		maxHandles  = flag.Int("max-open-handles", 0, "Maximum number of open file handles (0 = unlimited)")
+		limitPolicy = flag.String("handle-limit-policy", "reject", "What opens do at --max-open-handles (reject, wait, evict)")
+		uid         = flag.Int("uid", -1, "Report every file as owned by this uid (-1 = current user)")
+		gid         = flag.Int("gid", -1, "Report every file as owned by this gid (-1 = current group)")
+		umask       = flag.String("umask", "", "Octal umask applied to every reported file mode (e.g. 022)")
+		volumeName  = flag.String("volume-name", "AGFS", "Volume name shown in Finder (macOS only)")
+		fsName      = flag.String("fs-name", "agfs", "Source of the mount shown in mount tables and df")
+		mountName   = flag.String("name", "agfs", "Subtype of the mount, shown as its type (fuse.NAME on Linux)")
+		waitServer  = flag.Duration("wait-for-server", 0, "Keep probing the server until it is ready or this duration elapses (0 = probe once)")
+		controlSock = flag.String("control-socket", "", "Serve control commands (stats, handles, flush, debug) on this Unix socket (empty = disabled)")
+		traceFile   = flag.String("trace-file", "", "Write OpenTelemetry spans for every FUSE operation to this file (empty = tracing disabled)")
+		servers     stringList
+		mountOpts   stringList
 	)
+	flag.Var(&servers, "server", "Mount an AGFS server at a path of the tree, as path=url; repeat to combine several servers in one mount (overrides --agfs-server-url)")
+	flag.Var(&mountOpts, "mount-option", "FUSE mount option, as key or key=value (e.g. noatime, max_read=131072, max_background=64); repeat for several")
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
@@ -37,6 +90,10 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  %s --agfs-server-url http://localhost:8080 --mount /mnt/agfs\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s --agfs-server-url http://localhost:8080 --mount /mnt/agfs --cache-ttl=10s\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s --agfs-server-url http://localhost:8080 --mount /mnt/agfs --debug\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --agfs-server-url http://localhost:8080 --mount /mnt/agfs --check\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --agfs-server-url http://localhost:8080 --mount /mnt/agfs --wait-for-server=30s\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --server /a=http://h1:8080 --server /b=http://h2:8080 --mount /mnt/agfs\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --agfs-server-url http://localhost:8080 --mount /mnt/agfs --mount-option noatime --mount-option max_background=64\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -47,24 +104,14 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Initialize logrus
-	level := log.InfoLevel
+	// Initialize logrus; --debug is a shorthand for --log-level=debug
 	if *debug {
-		level = log.DebugLevel
-	} else if *logLevel != "" {
-		if parsedLevel, err := log.ParseLevel(*logLevel); err == nil {
-			level = parsedLevel
-		}
+		*logLevel = "debug"
+	}
+	if err := cmdutil.ConfigureLogging(log.StandardLogger(), *logLevel, *logFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-		CallerPrettyfier: func(f *runtime.Frame) (string, string) {
-			filename := filepath.Base(f.File)
-			return "", fmt.Sprintf(" | %s:%d | ", filename, f.Line)
-		},
-	})
-	log.SetReportCaller(true)
-	log.SetLevel(level)
 
 	// Check required arguments
 	if *mountpoint == "" {
@@ -73,40 +120,181 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Create filesystem
-	root := fusefs.NewAGFSFS(fusefs.Config{
+	extraOpts, err := parseMountOptions(mountOpts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --mount-option: %v\n", err)
+		os.Exit(1)
+	}
+	for flagName, value := range map[string]string{"fs-name": *fsName, "name": *mountName} {
+		if err := validateMountName(flagName, value); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	mounts, err := fusefs.ParseServerMounts(servers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --server: %v\n", err)
+		os.Exit(1)
+	}
+	serverURLs := []string{*serverURL}
+	if len(mounts) > 0 {
+		serverURLs = serverURLs[:0]
+		for _, m := range mounts {
+			serverURLs = append(serverURLs, m.URL)
+		}
+	}
+
+	if *check {
+		if !printChecks(os.Stdout, runChecks(osCheckEnv(runtime.GOOS), *mountpoint, *allowOther, serverURLs)) {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Make sure the servers are reachable before mounting, otherwise the
+	// first operation on the mount fails with a confusing error
+	for _, url := range serverURLs {
+		health, err := waitForServer(url, *waitServer)
+		if err != nil {
+			log.Fatalf("AGFS server at %s is not reachable: %v", url, err)
+		}
+		log.Infof("Connected to AGFS server %s (version %s, commit %s)", url, health.Version, health.GitCommit)
+	}
+
+	fsConfig := fusefs.Config{
 		ServerURL: *serverURL,
+		Token:     *token,
+		Servers:   mounts,
 		CacheTTL:  *cacheTTL,
 		Debug:     *debug,
-	})
+		Logger:    log.StandardLogger(),
 
-	// Setup FUSE mount options
-	opts := &fs.Options{
-		AttrTimeout:  cacheTTL,
-		EntryTimeout: cacheTTL,
-		MountOptions: fuse.MountOptions{
-			Name:          "agfs",
-			FsName:        "agfs",
-			DisableXAttrs: true,
-			Debug:         *debug,
-		},
+		AdaptiveCache:          *adaptive,
+		CacheTTLMin:            *cacheTTLMin,
+		CacheTTLMax:            *cacheTTLMax,
+		AttrTimeout:            *attrTTL,
+		EntryTimeout:           *entryTTL,
+		NegativeTimeout:        *negativeTTL,
+		PrefetchConcurrency:    *prefetch,
+		PrefetchAttrs:          *prefetchLs,
+		ReaddirBatchSize:       *readdirSize,
+		BlockCacheSize:         int64(*blockCache) << 20,
+		BlockSize:              *blockSize << 10,
+		CacheDir:               *cacheDir,
+		DirCacheSize:           int64(*dirCache) << 20,
+		ReadaheadSize:          *readahead << 10,
+		DirectIO:               *directIO,
+		WriteCommitWindow:      *commitWin,
+		WriteCommitSize:        *commitSize << 10,
+		BreakerThreshold:       *breakerFail,
+		BreakerCoolDown:        *breakerWait,
+		MaxInflight:            *maxInflight,
+		Bandwidth:              *bandwidth << 10,
+		HandleBandwidth:        *handleBW << 10,
+		RequestIDs:             *requestIDs,
+		StreamWindow:           *streamWin << 10,
+		StreamReadTimeout:      *streamWait,
+		StreamFirstReadTimeout: *streamFirst,
+		MaxOpenHandles:         *maxHandles,
+		Subscribe:              *subscribe,
+	}
+	if *uid >= 0 {
+		u := uint32(*uid)
+		fsConfig.UID = &u
+	}
+	if *gid >= 0 {
+		g := uint32(*gid)
+		fsConfig.GID = &g
+	}
+	if *umask != "" {
+		m, err := strconv.ParseUint(*umask, 8, 32)
+		if err != nil || m > 0777 {
+			fmt.Fprintf(os.Stderr, "Error: invalid --umask %q, expected an octal value such as 022\n", *umask)
+			os.Exit(1)
+		}
+		fsConfig.Umask = uint32(m)
+	}
+	policy, err := fusefs.ParseHandleLimitPolicy(*limitPolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --handle-limit-policy: %v\n", err)
+		os.Exit(1)
+	}
+	fsConfig.HandleLimitPolicy = policy
+	stale, err := fusefs.ParseStalePolicy(*stalePolicy)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --stale-policy: %v\n", err)
+		os.Exit(1)
 	}
+	fsConfig.StalePolicy = stale
+	pattern, err := fusefs.ParseIOPattern(*ioPattern)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --io-pattern: %v\n", err)
+		os.Exit(1)
+	}
+	fsConfig.IOPattern = pattern
+	backend, err := cache.ParseBackendKind(*cacheKind)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid --cache-backend: %v\n", err)
+		os.Exit(1)
+	}
+	fsConfig.CacheBackend = backend
 
-	if *allowOther {
-		opts.MountOptions.AllowOther = true
+	if *traceFile != "" {
+		tracer, shutdown, err := cmdutil.NewFileTracer(*traceFile, "github.com/dongxuny/agfs-fuse", true)
+		if err != nil {
+			log.Fatalf("Tracing setup failed: %v", err)
+		}
+		defer shutdown()
+		fsConfig.Tracer = tracer
+		log.Infof("Writing traces to %s", *traceFile)
 	}
 
+	// Create filesystem
+	root := fusefs.NewAGFSFS(fsConfig)
+
+	// Setup FUSE mount options for this platform
+	attrTimeout, entryTimeout, negativeTimeout := fsConfig.KernelTimeouts()
+	opts := buildMountOptions(runtime.GOOS, mountConfig{
+		AttrTimeout:     attrTimeout,
+		EntryTimeout:    entryTimeout,
+		NegativeTimeout: negativeTimeout,
+		Debug:           *debug,
+		AllowOther:      *allowOther,
+		VolumeName:      *volumeName,
+		Name:            *mountName,
+		FsName:          *fsName,
+		Extra:           extraOpts,
+	})
+
 	// Mount the filesystem
 	server, err := fs.Mount(*mountpoint, root, opts)
 	if err != nil {
 		log.Fatalf("Mount failed: %v", err)
 	}
+	root.Mounted()
+
+	var ctl *control.Server
+	if *controlSock != "" {
+		ctl, err = control.Listen(*controlSock, root, server)
+		if err != nil {
+			server.Unmount()
+			log.Fatalf("Control socket failed: %v", err)
+		}
+		log.Infof("Control socket listening on %s", *controlSock)
+	}
 
 	log.Infof("AGFS mounted at %s", *mountpoint)
-	log.Infof("Server: %s", *serverURL)
+	if len(mounts) > 0 {
+		for _, m := range mounts {
+			log.Infof("Server: %s at %s", m.URL, m.Path)
+		}
+	} else {
+		log.Infof("Server: %s", *serverURL)
+	}
 	log.Infof("Cache TTL: %v", *cacheTTL)
 
-	if level > log.DebugLevel {
+	if !log.IsLevelEnabled(log.DebugLevel) {
 		log.Info("Press Ctrl+C to unmount")
 	}
 
@@ -118,9 +306,13 @@ func main() {
 		<-sigChan
 		log.Info("Unmounting...")
 
+		if ctl != nil {
+			ctl.Close()
+		}
+
 		// Unmount
 		if err := server.Unmount(); err != nil {
-			log.Errorf("Unmount failed: %v", err)
+			log.Errorf("Unmount failed: %v (unmount manually with: %s)", err, unmountHint(runtime.GOOS, *mountpoint))
 		}
 
 		// Close filesystem
@@ -131,6 +323,56 @@ func main() {
 
 	// Wait for the filesystem to be unmounted
 	server.Wait()
+	if ctl != nil {
+		ctl.Close()
+	}
 
 	log.Info("AGFS unmounted successfully")
 }
+
+// stringList is a flag that may be repeated, collecting every value
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// waitForServer probes the server health endpoint until it succeeds or the
+// wait duration elapses. A zero wait probes exactly once.
+func waitForServer(serverURL string, wait time.Duration) (*agfs.Health, error) {
+	client := agfs.NewClient(serverURL)
+	deadline := time.Now().Add(wait)
+	backoff := 100 * time.Millisecond
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		health, err := client.Health(ctx)
+		cancel()
+		if err == nil {
+			return health, nil
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if attempt > 1 {
+				return nil, fmt.Errorf("gave up after %d attempts: %w", attempt, err)
+			}
+			return nil, err
+		}
+
+		log.Infof("Waiting for AGFS server (attempt %d): %v", attempt, err)
+		if backoff > remaining {
+			backoff = remaining
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > 5*time.Second {
+			backoff = 5 * time.Second
+		}
+	}
+}
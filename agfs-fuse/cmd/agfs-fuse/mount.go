@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// mountConfig holds the command line settings that influence mount options
+type mountConfig struct {
+	AttrTimeout     time.Duration
+	EntryTimeout    time.Duration
+	NegativeTimeout time.Duration // 0 = names found missing aren't cached
+	Debug           bool
+	AllowOther      bool
+	VolumeName      string // Volume name shown in Finder (macOS only)
+	Name            string // Subtype of the mount, as in fuse.agfs ("" = agfs)
+	FsName          string // Source shown in mount tables ("" = agfs)
+	Extra           mountOptions
+}
+
+// defaultMaxWrite is the largest read or write the kernel sends in one
+// request unless max_read or max_write is given. Files are opened with
+// FOPEN_DIRECT_IO, so every request is a server round trip, and go-fuse's
+// 128 KiB default splits large sequential I/O into eight times as many as
+// this does. go-fuse lowers it to what the kernel supports, and requests are
+// only as large as the application's reads and writes, so small files cost
+// the same.
+const defaultMaxWrite = 1024 * 1024
+
+// mountOptions are the settings given with --mount-option
+type mountOptions struct {
+	MaxWrite      int
+	MaxReadAhead  int
+	MaxBackground int
+	Raw           []string // Passed to the kernel as they are
+}
+
+// mountOptionFields are the --mount-option keys that set a field of
+// fuse.MountOptions rather than being passed to the kernel. go-fuse always
+// sets max_read to MaxWrite, so both keys set it.
+var mountOptionFields = map[string]func(*mountOptions, int){
+	"max_read":       func(o *mountOptions, n int) { o.MaxWrite = n },
+	"max_write":      func(o *mountOptions, n int) { o.MaxWrite = n },
+	"max_readahead":  func(o *mountOptions, n int) { o.MaxReadAhead = n },
+	"max_background": func(o *mountOptions, n int) { o.MaxBackground = n },
+}
+
+// rawMountOptions are the --mount-option keys passed to the kernel, and
+// whether they take a value
+var rawMountOptions = map[string]bool{
+	"ro": false, "rw": false,
+	"atime": false, "noatime": false, "diratime": false, "nodiratime": false,
+	"relatime": false, "strictatime": false,
+	"dev": false, "nodev": false, "suid": false, "nosuid": false,
+	"exec": false, "noexec": false,
+	"sync": false, "async": false, "dirsync": false,
+	"default_permissions": false,
+	"blksize":             true,
+	// macFUSE
+	"noappledouble": false, "noapplexattr": false, "nobrowse": false,
+	"local": false, "volname": true, "iosize": true, "daemon_timeout": true,
+}
+
+// parseMountOptions parses --mount-option values, each key or key=value,
+// refusing keys it doesn't know rather than failing at mount time
+func parseMountOptions(options []string) (mountOptions, error) {
+	var parsed mountOptions
+	for _, option := range options {
+		key, value, hasValue := strings.Cut(option, "=")
+		if set, ok := mountOptionFields[key]; ok {
+			n, err := strconv.Atoi(value)
+			if !hasValue || err != nil || n <= 0 {
+				return mountOptions{}, fmt.Errorf("mount option %s needs a positive number, as %s=N", key, key)
+			}
+			set(&parsed, n)
+			continue
+		}
+		takesValue, ok := rawMountOptions[key]
+		if !ok {
+			return mountOptions{}, fmt.Errorf("unknown mount option %q (known: %s)", key, knownMountOptions())
+		}
+		if hasValue != takesValue || strings.Contains(value, ",") {
+			if takesValue {
+				return mountOptions{}, fmt.Errorf("mount option %s needs a value, as %s=VALUE", key, key)
+			}
+			return mountOptions{}, fmt.Errorf("mount option %s takes no value", key)
+		}
+		parsed.Raw = append(parsed.Raw, option)
+	}
+	return parsed, nil
+}
+
+// knownMountOptions lists the keys --mount-option accepts, sorted
+func knownMountOptions() string {
+	var keys []string
+	for key := range mountOptionFields {
+		keys = append(keys, key)
+	}
+	for key := range rawMountOptions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ", ")
+}
+
+// validateMountName checks a --name or --fs-name value, which ends up in the
+// comma-separated options given to the kernel
+func validateMountName(flag, name string) error {
+	if name == "" || strings.ContainsAny(name, ", \t\n") {
+		return fmt.Errorf("--%s must be non-empty and contain no commas or whitespace, got %q", flag, name)
+	}
+	return nil
+}
+
+// buildMountOptions constructs the FUSE mount options for the given platform.
+// goos is passed explicitly so the logic can be tested on any platform.
+func buildMountOptions(goos string, cfg mountConfig) *fs.Options {
+	attrTimeout, entryTimeout := cfg.AttrTimeout, cfg.EntryTimeout
+	name, fsName := cfg.Name, cfg.FsName
+	if name == "" {
+		name = "agfs"
+	}
+	if fsName == "" {
+		fsName = "agfs"
+	}
+	maxWrite := cfg.Extra.MaxWrite
+	if maxWrite == 0 {
+		maxWrite = defaultMaxWrite
+	}
+	opts := &fs.Options{
+		AttrTimeout:  &attrTimeout,
+		EntryTimeout: &entryTimeout,
+		MountOptions: fuse.MountOptions{
+			Name:          name,
+			FsName:        fsName,
+			DisableXAttrs: true,
+			EnableLocks:   true,
+			Debug:         cfg.Debug,
+			AllowOther:    cfg.AllowOther,
+			MaxWrite:      maxWrite,
+			MaxReadAhead:  cfg.Extra.MaxReadAhead,
+			MaxBackground: cfg.Extra.MaxBackground,
+		},
+	}
+	if cfg.NegativeTimeout > 0 {
+		negativeTimeout := cfg.NegativeTimeout
+		opts.NegativeTimeout = &negativeTimeout
+	}
+
+	if goos == "darwin" {
+		volumeName := cfg.VolumeName
+		if volumeName == "" {
+			volumeName = "AGFS"
+		}
+		// macFUSE specific options:
+		// - volname: name displayed in Finder
+		// - local: treat the mount as a local volume so it appears in the sidebar
+		// - noappledouble: don't create ._* AppleDouble files for metadata
+		opts.MountOptions.Options = append(opts.MountOptions.Options,
+			"volname="+volumeName,
+			"local",
+			"noappledouble",
+		)
+	}
+	opts.MountOptions.Options = append(opts.MountOptions.Options, cfg.Extra.Raw...)
+
+	return opts
+}
+
+// unmountHint returns the command a user can run to unmount manually
+func unmountHint(goos, mountpoint string) string {
+	if goos == "darwin" {
+		return fmt.Sprintf("umount %s (or: diskutil unmount force %s)", mountpoint, mountpoint)
+	}
+	return fmt.Sprintf("fusermount -u %s", mountpoint)
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func hasOption(options []string, want string) bool {
+	for _, o := range options {
+		if o == want {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuildMountOptionsLinux(t *testing.T) {
+	opts := buildMountOptions("linux", mountConfig{
+		AttrTimeout:  5 * time.Second,
+		EntryTimeout: time.Minute,
+		AllowOther:   true,
+	})
+
+	if len(opts.MountOptions.Options) != 0 {
+		t.Errorf("Expected no extra options on linux, got %v", opts.MountOptions.Options)
+	}
+	if !opts.MountOptions.AllowOther {
+		t.Error("Expected AllowOther to be set")
+	}
+	if !opts.MountOptions.EnableLocks {
+		t.Error("Expected locks to be enabled")
+	}
+	if opts.MountOptions.MaxWrite != defaultMaxWrite {
+		t.Errorf("Expected the default max write, got %d", opts.MountOptions.MaxWrite)
+	}
+	if *opts.AttrTimeout != 5*time.Second || *opts.EntryTimeout != time.Minute {
+		t.Errorf("Unexpected timeouts: attr=%v entry=%v", *opts.AttrTimeout, *opts.EntryTimeout)
+	}
+	if opts.NegativeTimeout != nil {
+		t.Errorf("Expected missing names not to be cached, got %v", *opts.NegativeTimeout)
+	}
+	opts = buildMountOptions("linux", mountConfig{NegativeTimeout: time.Second})
+	if opts.NegativeTimeout == nil || *opts.NegativeTimeout != time.Second {
+		t.Errorf("Expected a negative timeout of 1s, got %v", opts.NegativeTimeout)
+	}
+}
+
+func TestBuildMountOptionsDarwin(t *testing.T) {
+	opts := buildMountOptions("darwin", mountConfig{VolumeName: "MyAGFS"})
+
+	for _, want := range []string{"volname=MyAGFS", "local", "noappledouble"} {
+		if !hasOption(opts.MountOptions.Options, want) {
+			t.Errorf("Expected option %q in %v", want, opts.MountOptions.Options)
+		}
+	}
+
+	opts = buildMountOptions("darwin", mountConfig{})
+	if !hasOption(opts.MountOptions.Options, "volname=AGFS") {
+		t.Errorf("Expected default volume name, got %v", opts.MountOptions.Options)
+	}
+}
+
+func TestParseMountOptions(t *testing.T) {
+	opts, err := parseMountOptions([]string{"noatime", "max_read=131072", "max_readahead=65536", "max_background=64", "blksize=4096"})
+	if err != nil {
+		t.Fatalf("parseMountOptions failed: %v", err)
+	}
+	if opts.MaxWrite != 131072 || opts.MaxReadAhead != 65536 || opts.MaxBackground != 64 {
+		t.Errorf("Unexpected fields: %+v", opts)
+	}
+	if len(opts.Raw) != 2 || opts.Raw[0] != "noatime" || opts.Raw[1] != "blksize=4096" {
+		t.Errorf("Expected the kernel options passed through, got %v", opts.Raw)
+	}
+
+	for _, bad := range []string{"bogus", "max_read", "max_read=0", "max_background=lots", "noatime=1", "blksize", "volname=a,allow_other"} {
+		if _, err := parseMountOptions([]string{bad}); err == nil {
+			t.Errorf("Expected %q to be refused", bad)
+		}
+	}
+	if _, err := parseMountOptions([]string{"bogus"}); err == nil || !strings.Contains(err.Error(), `unknown mount option "bogus"`) {
+		t.Errorf("Expected an unknown option error, got %v", err)
+	}
+
+	built := buildMountOptions("linux", mountConfig{Name: "queues", FsName: "agfs-prod", Extra: opts})
+	mo := built.MountOptions
+	if mo.Name != "queues" || mo.FsName != "agfs-prod" || mo.MaxWrite != 131072 || mo.MaxBackground != 64 {
+		t.Errorf("Expected the options applied, got %+v", mo)
+	}
+	if !hasOption(mo.Options, "noatime") {
+		t.Errorf("Expected noatime in %v", mo.Options)
+	}
+	if mo = buildMountOptions("linux", mountConfig{}).MountOptions; mo.Name != "agfs" || mo.FsName != "agfs" {
+		t.Errorf("Expected default names, got %q and %q", mo.Name, mo.FsName)
+	}
+
+	if err := validateMountName("fs-name", "a,b"); err == nil {
+		t.Error("Expected a name with a comma to be refused")
+	}
+}
+
+func TestUnmountHint(t *testing.T) {
+	if hint := unmountHint("linux", "/mnt/agfs"); !strings.HasPrefix(hint, "fusermount -u") {
+		t.Errorf("Unexpected linux hint: %s", hint)
+	}
+	if hint := unmountHint("darwin", "/Volumes/agfs"); !strings.HasPrefix(hint, "umount") {
+		t.Errorf("Unexpected darwin hint: %s", hint)
+	}
+}
@@ -2,7 +2,19 @@ module github.com/dongxuny/agfs-fuse
 
 go 1.21.1
 
-require github.com/c4pt0r/agfs/agfs-sdk/go v0.0.0-00010101000000-000000000000
+require (
+	github.com/c4pt0r/agfs/agfs-sdk/go v0.0.0-00010101000000-000000000000
+	go.opentelemetry.io/otel v1.24.0
+	go.opentelemetry.io/otel/sdk v1.24.0
+	go.opentelemetry.io/otel/trace v1.24.0
+)
+
+require (
+	github.com/go-logr/logr v1.4.1 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.24.0 // indirect
+	go.opentelemetry.io/otel/metric v1.24.0 // indirect
+)
 
 require (
 	github.com/hanwen/go-fuse/v2 v2.9.0
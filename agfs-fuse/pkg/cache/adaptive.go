@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// DefaultAdaptivePaths is how many paths an AdaptiveTTL remembers when it
+// is created with a limit of 0
+const DefaultAdaptivePaths = 16384
+
+// AdaptiveTTL picks the TTL of each path from how often it was seen
+// changing. Every time a path is fetched from the server again, its TTL is
+// doubled if it is unchanged and halved if it changed, within bounds, so
+// paths that never change are cached longer and paths that change all the
+// time are revalidated sooner. Paths start at the base TTL.
+//
+// The history of at most a fixed number of paths is kept, the least
+// recently observed being forgotten first; a forgotten path starts over.
+type AdaptiveTTL struct {
+	mu       sync.Mutex
+	base     time.Duration
+	minTTL   time.Duration
+	maxTTL   time.Duration
+	maxPaths int
+	lru      *list.List // Front is the most recently observed path
+	paths    map[string]*list.Element
+}
+
+// pathHistory is what an AdaptiveTTL remembers of a path
+type pathHistory struct {
+	path    string
+	version string // Version of the path last fetched
+	ttl     time.Duration
+}
+
+// NewAdaptiveTTL creates an AdaptiveTTL starting paths at base, clamped to
+// [minTTL, maxTTL], and remembering at most maxPaths paths (0 =
+// DefaultAdaptivePaths). minTTL is at least a millisecond, so a TTL halved
+// down to it can still double.
+func NewAdaptiveTTL(base, minTTL, maxTTL time.Duration, maxPaths int) *AdaptiveTTL {
+	minTTL = max(minTTL, time.Millisecond)
+	maxTTL = max(maxTTL, minTTL)
+	base = min(max(base, minTTL), maxTTL)
+	if maxPaths <= 0 {
+		maxPaths = DefaultAdaptivePaths
+	}
+	return &AdaptiveTTL{
+		base:     base,
+		minTTL:   minTTL,
+		maxTTL:   maxTTL,
+		maxPaths: maxPaths,
+		lru:      list.New(),
+		paths:    make(map[string]*list.Element),
+	}
+}
+
+// Observe records that path was fetched from the server at version and
+// returns the TTL to cache it for
+func (a *AdaptiveTTL) Observe(path, version string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	elem, ok := a.paths[path]
+	if !ok {
+		elem = a.lru.PushFront(&pathHistory{path: path, version: version, ttl: a.base})
+		a.paths[path] = elem
+		for a.lru.Len() > a.maxPaths {
+			oldest := a.lru.Back()
+			a.lru.Remove(oldest)
+			delete(a.paths, oldest.Value.(*pathHistory).path)
+		}
+		return a.base
+	}
+
+	a.lru.MoveToFront(elem)
+	h := elem.Value.(*pathHistory)
+	if h.version == version {
+		h.ttl = min(2*h.ttl, a.maxTTL)
+	} else {
+		h.version = version
+		h.ttl = max(h.ttl/2, a.minTTL)
+	}
+	return h.ttl
+}
+
+// TTL returns the TTL path is cached for, the base TTL for paths without
+// history
+func (a *AdaptiveTTL) TTL(path string) time.Duration {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if elem, ok := a.paths[path]; ok {
+		return elem.Value.(*pathHistory).ttl
+	}
+	return a.base
+}
+
+// Len returns the number of paths with history
+func (a *AdaptiveTTL) Len() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.lru.Len()
+}
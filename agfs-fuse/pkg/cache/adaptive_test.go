@@ -0,0 +1,87 @@
+package cache
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+func TestAdaptiveTTLFollowsChangeFrequency(t *testing.T) {
+	a := NewAdaptiveTTL(time.Second, 100*time.Millisecond, 10*time.Second, 0)
+
+	for i := 0; i < 5; i++ {
+		a.Observe("/stable", "v1")
+		a.Observe("/busy", fmt.Sprintf("v%d", i))
+	}
+
+	stable, busy := a.TTL("/stable"), a.TTL("/busy")
+	if busy >= stable {
+		t.Errorf("Expected /busy to get a shorter TTL than /stable, got %v and %v", busy, stable)
+	}
+	if stable != 10*time.Second {
+		t.Errorf("Expected /stable capped at the max TTL, got %v", stable)
+	}
+	if busy != 100*time.Millisecond {
+		t.Errorf("Expected /busy floored at the min TTL, got %v", busy)
+	}
+	if got := a.TTL("/unseen"); got != time.Second {
+		t.Errorf("Expected the base TTL for an unseen path, got %v", got)
+	}
+
+	// A path that settles down is cached longer again
+	a.Observe("/busy", "v4")
+	if got := a.TTL("/busy"); got != 200*time.Millisecond {
+		t.Errorf("Expected /busy to double once unchanged, got %v", got)
+	}
+}
+
+func TestAdaptiveTTLForgetsOldestPaths(t *testing.T) {
+	a := NewAdaptiveTTL(time.Second, time.Millisecond, time.Minute, 2)
+
+	a.Observe("/a", "v1")
+	a.Observe("/a", "v1")
+	a.Observe("/b", "v1")
+	a.Observe("/a", "v1")
+	a.Observe("/c", "v1")
+
+	if a.Len() != 2 {
+		t.Errorf("Expected 2 paths remembered, got %d", a.Len())
+	}
+	if got := a.TTL("/a"); got != 4*time.Second {
+		t.Errorf("Expected /a, the most recently observed, to be kept, got %v", got)
+	}
+	a.Observe("/b", "v2")
+	if got := a.TTL("/b"); got != time.Second {
+		t.Errorf("Expected /b forgotten and started over, got %v", got)
+	}
+}
+
+func TestMetadataCacheAdaptive(t *testing.T) {
+	mc := NewAdaptiveMetadataCache(NewAdaptiveTTL(time.Second, 100*time.Millisecond, 10*time.Second, 0))
+	modTime := time.Now()
+
+	for i := 0; i < 4; i++ {
+		mc.Set("/stable", &agfs.FileInfo{Name: "stable", Size: 1, ModTime: modTime})
+		mc.Set("/busy", &agfs.FileInfo{Name: "busy", Size: int64(i), ModTime: modTime})
+	}
+	if busy, stable := mc.TTL("/busy"), mc.TTL("/stable"); busy >= stable {
+		t.Errorf("Expected /busy to get a shorter TTL than /stable, got %v and %v", busy, stable)
+	}
+	if _, ok := mc.Get("/stable"); !ok {
+		t.Error("Expected /stable to be cached")
+	}
+
+	// An etag confirmed unchanged counts as an unchanged observation
+	tagged := &agfs.FileInfo{Name: "tagged", Meta: agfs.MetaData{Content: map[string]string{agfs.MetaETag: "abc"}}}
+	mc.Set("/tagged", tagged)
+	mc.Refresh("/tagged")
+	if got := mc.TTL("/tagged"); got != 2*time.Second {
+		t.Errorf("Expected a refresh to double the TTL, got %v", got)
+	}
+
+	if got := NewMetadataCache(time.Second).TTL("/any"); got != time.Second {
+		t.Errorf("Expected the cache's TTL without adaptation, got %v", got)
+	}
+}
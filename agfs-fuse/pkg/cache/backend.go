@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"container/list"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a value stored in a Backend. Its size is the length of Data.
+type Entry struct {
+	Data    []byte
+	Expires time.Time // When the cache stops serving the entry unrevalidated
+	ETag    string    // Version of the file the entry was read from, if known
+	// Sum is the CRC-32 (IEEE) of Data when it was stored, if HasSum, for
+	// caches to detect entries whose bytes changed since
+	Sum    uint32
+	HasSum bool
+}
+
+// Backend stores the entries of a cache by key, within a bound on the bytes
+// of their data: putting an entry evicts the least recently used ones beyond
+// it, and an entry larger than the bound isn't stored. Caches decide
+// whether an entry is still valid themselves, so a backend may lose any
+// entry at any time, which Get reports as a miss.
+type Backend interface {
+	// Get returns the entry stored under key. Its Data must not be
+	// modified.
+	Get(key string) (Entry, bool)
+	// Put stores e under key, replacing the entry there. e.Data must not
+	// be modified afterwards.
+	Put(key string, e Entry)
+	// Invalidate drops the entry under key
+	Invalidate(key string)
+	// InvalidatePrefix drops the entries whose key starts with prefix
+	InvalidatePrefix(prefix string)
+	// Clear drops every entry
+	Clear()
+	// Size returns the number of entries and the bytes of their data
+	Size() (entries int, bytes int64)
+	// Close drops every entry and releases what the backend holds
+	Close() error
+}
+
+// BackendKind selects a Backend implementation
+type BackendKind int
+
+const (
+	// BackendMemory keeps entries in memory, see MemoryBackend
+	BackendMemory BackendKind = iota
+	// BackendDisk keeps entries in files, see DiskBackend
+	BackendDisk
+	// BackendNone keeps nothing, see NoneBackend
+	BackendNone
+)
+
+// ParseBackendKind parses "memory", "disk" or "none"
+func ParseBackendKind(s string) (BackendKind, error) {
+	switch s {
+	case "memory":
+		return BackendMemory, nil
+	case "disk":
+		return BackendDisk, nil
+	case "none":
+		return BackendNone, nil
+	}
+	return 0, fmt.Errorf("unknown cache backend %q (memory, disk, none)", s)
+}
+
+func (k BackendKind) String() string {
+	switch k {
+	case BackendDisk:
+		return "disk"
+	case BackendNone:
+		return "none"
+	}
+	return "memory"
+}
+
+// NewBackend creates a backend of the given kind holding at most maxBytes
+// of data. dir is the directory of a disk backend, ignored otherwise.
+func NewBackend(kind BackendKind, dir string, maxBytes int64) (Backend, error) {
+	switch kind {
+	case BackendDisk:
+		return NewDiskBackend(dir, maxBytes)
+	case BackendNone:
+		return NoneBackend{}, nil
+	}
+	return NewMemoryBackend(maxBytes), nil
+}
+
+// memoryEntry is an entry of a MemoryBackend
+type memoryEntry struct {
+	key   string
+	entry Entry
+}
+
+// MemoryBackend is a Backend keeping entries in memory, in LRU order
+type MemoryBackend struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List // Front is the most recently used entry
+	entries  map[string]*list.Element
+}
+
+// NewMemoryBackend creates a memory backend holding at most maxBytes of data
+func NewMemoryBackend(maxBytes int64) *MemoryBackend {
+	return &MemoryBackend{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+func (m *MemoryBackend) Get(key string) (Entry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	elem, ok := m.entries[key]
+	if !ok {
+		return Entry{}, false
+	}
+	m.lru.MoveToFront(elem)
+	return elem.Value.(*memoryEntry).entry, true
+}
+
+func (m *MemoryBackend) Put(key string, e Entry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+	if int64(len(e.Data)) > m.maxBytes {
+		return
+	}
+	m.entries[key] = m.lru.PushFront(&memoryEntry{key: key, entry: e})
+	m.size += int64(len(e.Data))
+	for m.size > m.maxBytes {
+		m.remove(m.lru.Back())
+	}
+}
+
+func (m *MemoryBackend) Invalidate(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if elem, ok := m.entries[key]; ok {
+		m.remove(elem)
+	}
+}
+
+func (m *MemoryBackend) InvalidatePrefix(prefix string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, elem := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			m.remove(elem)
+		}
+	}
+}
+
+func (m *MemoryBackend) Clear() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lru.Init()
+	m.entries = make(map[string]*list.Element)
+	m.size = 0
+}
+
+func (m *MemoryBackend) Size() (int, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries), m.size
+}
+
+func (m *MemoryBackend) Close() error {
+	m.Clear()
+	return nil
+}
+
+// remove drops an entry, the caller holds m.mu
+func (m *MemoryBackend) remove(elem *list.Element) {
+	e := m.lru.Remove(elem).(*memoryEntry)
+	delete(m.entries, e.key)
+	m.size -= int64(len(e.entry.Data))
+}
+
+// NoneBackend is a Backend that stores nothing, so every Get misses
+type NoneBackend struct{}
+
+func (NoneBackend) Get(string) (Entry, bool) { return Entry{}, false }
+func (NoneBackend) Put(string, Entry)        {}
+func (NoneBackend) Invalidate(string)        {}
+func (NoneBackend) InvalidatePrefix(string)  {}
+func (NoneBackend) Clear()                   {}
+func (NoneBackend) Size() (int, int64)       { return 0, 0 }
+func (NoneBackend) Close() error             { return nil }
@@ -0,0 +1,220 @@
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// backends are constructors of each backend that stores entries, by name
+var backends = map[string]func(t *testing.T, maxBytes int64) Backend{
+	"memory": func(t *testing.T, maxBytes int64) Backend { return NewMemoryBackend(maxBytes) },
+	"disk": func(t *testing.T, maxBytes int64) Backend {
+		b, err := NewDiskBackend(t.TempDir(), maxBytes)
+		if err != nil {
+			t.Fatalf("NewDiskBackend failed: %v", err)
+		}
+		t.Cleanup(func() { b.Close() })
+		return b
+	},
+}
+
+func TestBackendEntries(t *testing.T) {
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			b := newBackend(t, 1024)
+			expires := time.Now().Add(time.Minute).Round(0)
+			b.Put("/a\x000", Entry{Data: []byte("aaaa"), Expires: expires, ETag: "v1"})
+			b.Put("/a/b", Entry{Data: []byte("bb")})
+			b.Put("/ab", Entry{Data: []byte("ab")})
+
+			e, ok := b.Get("/a\x000")
+			if !ok || string(e.Data) != "aaaa" || e.ETag != "v1" || !e.Expires.Equal(expires) {
+				t.Errorf("Expected the entry put, got %+v (ok=%v)", e, ok)
+			}
+			if _, ok := b.Get("/missing"); ok {
+				t.Error("Expected a missing key to miss")
+			}
+			if entries, size := b.Size(); entries != 3 || size != 8 {
+				t.Errorf("Expected 3 entries of 8 bytes, got %d of %d", entries, size)
+			}
+
+			// Put replaces, and the size follows
+			b.Put("/ab", Entry{Data: []byte("abcdef")})
+			if e, ok := b.Get("/ab"); !ok || string(e.Data) != "abcdef" {
+				t.Errorf("Expected the entry replaced, got %q (ok=%v)", e.Data, ok)
+			}
+			if _, size := b.Size(); size != 12 {
+				t.Errorf("Expected 12 bytes, got %d", size)
+			}
+
+			b.InvalidatePrefix("/a/")
+			if _, ok := b.Get("/a/b"); ok {
+				t.Error("Expected the entry below the prefix dropped")
+			}
+			if _, ok := b.Get("/ab"); !ok {
+				t.Error("Expected /ab kept")
+			}
+			b.Invalidate("/ab")
+			if _, ok := b.Get("/ab"); ok {
+				t.Error("Expected the invalidated entry dropped")
+			}
+
+			b.Clear()
+			if entries, size := b.Size(); entries != 0 || size != 0 {
+				t.Errorf("Expected nothing left, got %d entries of %d bytes", entries, size)
+			}
+		})
+	}
+}
+
+func TestBackendEviction(t *testing.T) {
+	for name, newBackend := range backends {
+		t.Run(name, func(t *testing.T) {
+			b := newBackend(t, 8)
+			b.Put("a", Entry{Data: []byte("aaaa")})
+			b.Put("b", Entry{Data: []byte("bbbb")})
+			b.Get("a") // a is now the most recently used
+			b.Put("c", Entry{Data: []byte("cccc")})
+
+			if _, ok := b.Get("b"); ok {
+				t.Error("Expected the least recently used entry evicted")
+			}
+			for _, key := range []string{"a", "c"} {
+				if _, ok := b.Get(key); !ok {
+					t.Errorf("Expected %s kept", key)
+				}
+			}
+			if entries, size := b.Size(); entries != 2 || size != 8 {
+				t.Errorf("Expected 2 entries of 8 bytes, got %d of %d", entries, size)
+			}
+
+			// An entry larger than the bound isn't stored, and replaces
+			// nothing
+			b.Put("a", Entry{Data: []byte("far too large")})
+			if _, ok := b.Get("a"); ok {
+				t.Error("Expected the oversized entry not stored")
+			}
+			if entries, size := b.Size(); entries != 1 || size != 4 {
+				t.Errorf("Expected 1 entry of 4 bytes, got %d of %d", entries, size)
+			}
+		})
+	}
+}
+
+func TestNoneBackend(t *testing.T) {
+	b, err := NewBackend(BackendNone, "", 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Put("a", Entry{Data: []byte("aaaa")})
+	if _, ok := b.Get("a"); ok {
+		t.Error("Expected nothing stored")
+	}
+}
+
+func TestDiskBackendCorruption(t *testing.T) {
+	dir := t.TempDir()
+	b, err := NewDiskBackend(dir, 1024)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	defer b.Close()
+	for _, key := range []string{"flipped", "truncated", "removed", "swapped"} {
+		b.Put(key, Entry{Data: []byte("data of " + key)})
+	}
+
+	flipped, err := os.ReadFile(b.file("flipped"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flipped[len(flipped)-1] ^= 0xff
+	os.WriteFile(b.file("flipped"), flipped, 0600)
+	os.Truncate(b.file("truncated"), 10)
+	os.Remove(b.file("removed"))
+	// A file holding another key's entry isn't served either
+	swapped, _ := os.ReadFile(b.file("flipped"))
+	os.WriteFile(b.file("swapped"), swapped, 0600)
+
+	for _, key := range []string{"flipped", "truncated", "removed", "swapped"} {
+		if e, ok := b.Get(key); ok {
+			t.Errorf("Expected the damaged %s to miss, got %q", key, e.Data)
+		}
+	}
+	if entries, size := b.Size(); entries != 0 || size != 0 {
+		t.Errorf("Expected the damaged entries dropped, got %d of %d bytes", entries, size)
+	}
+	if _, err := os.Stat(b.file("flipped")); !os.IsNotExist(err) {
+		t.Errorf("Expected the corrupted file removed, got %v", err)
+	}
+}
+
+func TestDiskBackendFiles(t *testing.T) {
+	dir := t.TempDir()
+	keep := filepath.Join(dir, "notes.txt")
+	os.WriteFile(keep, []byte("not a cache file"), 0600)
+
+	b, err := NewDiskBackend(dir, 1024)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	b.Put("a", Entry{Data: []byte("aaaa")})
+
+	// A new backend on the directory starts empty
+	b2, err := NewDiskBackend(dir, 1024)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*.cache")); len(names) != 0 {
+		t.Errorf("Expected the files of the earlier backend removed, got %q", names)
+	}
+	b2.Put("b", Entry{Data: []byte("bbbb")})
+
+	if err := b2.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if names, _ := filepath.Glob(filepath.Join(dir, "*")); len(names) != 1 || names[0] != keep {
+		t.Errorf("Expected only the unrelated file left, got %q", names)
+	}
+}
+
+func TestCachesOnDisk(t *testing.T) {
+	blocks, err := NewDiskBackend(filepath.Join(t.TempDir(), "blocks"), 8)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	bc := NewBlockCacheWithBackend(4, blocks, time.Minute)
+	defer bc.Close()
+	gen := bc.Generation()
+	bc.Put("/a", 0, []byte("aaaa"), gen, "")
+	bc.Put("/a", 1, []byte("bbbb"), gen, "")
+	bc.Get("/a", 0, "")
+	bc.Put("/b", 0, []byte("cccc"), gen, "")
+	if _, ok := bc.Get("/a", 1, ""); ok {
+		t.Error("Expected least recently used block to be evicted")
+	}
+	if data, ok := bc.Get("/a", 0, ""); !ok || !bytes.Equal(data, []byte("aaaa")) {
+		t.Errorf("Expected /a block 0 to be kept, got %q (ok=%v)", data, ok)
+	}
+	bc.Invalidate("/a")
+	if _, ok := bc.Get("/a", 0, ""); ok {
+		t.Error("Expected the blocks of /a dropped")
+	}
+
+	dirs, err := NewDiskBackend(filepath.Join(t.TempDir(), "dirs"), 1<<20)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	dc := NewDirectoryCacheWithBackend(time.Minute, dirs)
+	defer dc.Close()
+	listing := []agfs.FileInfo{{Name: "f", Size: 3, Mode: 0644, Meta: agfs.MetaData{Content: map[string]string{"etag": "v1"}}}}
+	dc.Set("/dir", listing)
+	files, ok := dc.Get("/dir")
+	if !ok || len(files) != 1 || files[0].Name != "f" || files[0].Size != 3 || files[0].ETag() != "v1" {
+		t.Errorf("Expected the listing back from disk, got %+v (ok=%v)", files, ok)
+	}
+}
@@ -0,0 +1,181 @@
+package cache
+
+import (
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// BlockCache is a size-bounded LRU cache of fixed-size file blocks, shared by
+// every handle so repeated reads of a file region are served locally. The
+// blocks are kept in a Backend, in memory unless created with
+// NewBlockCacheWithBackend.
+//
+// A block shorter than the block size is the last block of the file. Blocks
+// expire after the TTL so changes made by other clients become visible. A
+// block stored with the file's etag is instead kept while the etag passed
+// to Get is unchanged, and dropped as soon as it differs.
+//
+// Every block is stored with a checksum of its bytes, verified when it is
+// served. A block that no longer matches, say corrupted in memory or
+// modified by a reader, is dropped and reported as a miss, so the reader
+// fetches the server's bytes again in its place.
+type BlockCache struct {
+	// mu orders invalidations and the Puts they must win over
+	mu        sync.Mutex
+	blockSize int
+	ttl       time.Duration
+	backend   Backend
+	// generation is bumped by every invalidation, so a block fetched before
+	// a write can't be stored after the write invalidated the path
+	generation  atomic.Uint64
+	hits        atomic.Uint64
+	misses      atomic.Uint64
+	revalidated atomic.Uint64
+	repaired    atomic.Uint64
+}
+
+// NewBlockCache creates a block cache holding at most maxBytes of data in
+// memory
+func NewBlockCache(blockSize int, maxBytes int64, ttl time.Duration) *BlockCache {
+	return NewBlockCacheWithBackend(blockSize, NewMemoryBackend(maxBytes), ttl)
+}
+
+// NewBlockCacheWithBackend creates a block cache keeping its blocks in
+// backend, whose bound is the cache's
+func NewBlockCacheWithBackend(blockSize int, backend Backend, ttl time.Duration) *BlockCache {
+	return &BlockCache{
+		blockSize: blockSize,
+		ttl:       ttl,
+		backend:   backend,
+	}
+}
+
+// blockKey returns the backend key of the block at index of path. A NUL
+// can't be part of a path, so the blocks of a path are exactly the keys
+// starting with its path and a NUL.
+func blockKey(path string, index int64) string {
+	return path + "\x00" + strconv.FormatInt(index, 10)
+}
+
+// BlockSize returns the size of a full block
+func (bc *BlockCache) BlockSize() int {
+	return bc.blockSize
+}
+
+// Generation returns the invalidation generation to pass to Put
+func (bc *BlockCache) Generation() uint64 {
+	return bc.generation.Load()
+}
+
+// Get returns the block at index of path. etag is the file's current etag,
+// or "" if unknown.
+func (bc *BlockCache) Get(path string, index int64, etag string) ([]byte, bool) {
+	key := blockKey(path, index)
+	generation := bc.generation.Load()
+	b, ok := bc.backend.Get(key)
+	if !ok {
+		bc.misses.Add(1)
+		return nil, false
+	}
+	switch {
+	case b.HasSum && crc32.ChecksumIEEE(b.Data) != b.Sum:
+		// The cached bytes aren't the ones read from the server
+		bc.backend.Invalidate(key)
+		bc.repaired.Add(1)
+		bc.misses.Add(1)
+		offset := index * int64(bc.blockSize)
+		log.Warnf("Block cache: dropped corrupt block of %s at offset %d-%d, reading it again", path, offset, offset+int64(len(b.Data)))
+		return nil, false
+	case etag != "" && b.ETag != "" && etag != b.ETag:
+		// The file changed since the block was read
+		bc.backend.Invalidate(key)
+		bc.misses.Add(1)
+		return nil, false
+	case !time.Now().After(b.Expires):
+	case etag != "" && etag == b.ETag:
+		// Expired but the file is unchanged
+		b.Expires = time.Now().Add(bc.ttl)
+		bc.put(key, b, generation)
+		bc.revalidated.Add(1)
+	default:
+		bc.backend.Invalidate(key)
+		bc.misses.Add(1)
+		return nil, false
+	}
+	bc.hits.Add(1)
+	return b.Data, true
+}
+
+// Put stores a block read from the server while the file's etag was etag
+// ("" if unknown). It is dropped if the cache was invalidated since
+// generation was obtained. data must not be modified afterwards.
+func (bc *BlockCache) Put(path string, index int64, data []byte, generation uint64, etag string) {
+	if len(data) > bc.blockSize {
+		return
+	}
+	bc.put(blockKey(path, index), Entry{
+		Data:    data,
+		Expires: time.Now().Add(bc.ttl),
+		ETag:    etag,
+		Sum:     crc32.ChecksumIEEE(data),
+		HasSum:  true,
+	}, generation)
+}
+
+// put stores b under key unless the cache was invalidated since generation
+func (bc *BlockCache) put(key string, b Entry, generation uint64) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	if generation != bc.generation.Load() {
+		return
+	}
+	bc.backend.Put(key, b)
+}
+
+// Invalidate drops the blocks of path and of every path below it
+func (bc *BlockCache) Invalidate(path string) {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.generation.Add(1)
+	bc.backend.InvalidatePrefix(path + "\x00")
+	bc.backend.InvalidatePrefix(strings.TrimSuffix(path, "/") + "/")
+}
+
+// Clear drops every block
+func (bc *BlockCache) Clear() {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.generation.Add(1)
+	bc.backend.Clear()
+}
+
+// Close drops every block and releases the backend
+func (bc *BlockCache) Close() error {
+	bc.mu.Lock()
+	defer bc.mu.Unlock()
+
+	bc.generation.Add(1)
+	return bc.backend.Close()
+}
+
+// Stats returns the block cache usage
+func (bc *BlockCache) Stats() Stats {
+	entries, size := bc.backend.Size()
+	return Stats{
+		Entries:     entries,
+		Bytes:       size,
+		Hits:        bc.hits.Load(),
+		Misses:      bc.misses.Load(),
+		Revalidated: bc.revalidated.Load(),
+		Repaired:    bc.repaired.Load(),
+	}
+}
@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"bytes"
+	"hash/crc32"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestBlockCacheLRUEviction(t *testing.T) {
+	bc := NewBlockCache(4, 8, time.Minute)
+	gen := bc.Generation()
+
+	bc.Put("/a", 0, []byte("aaaa"), gen, "")
+	bc.Put("/a", 1, []byte("bbbb"), gen, "")
+	bc.Get("/a", 0, "") // /a block 0 is now the most recently used
+	bc.Put("/b", 0, []byte("cccc"), gen, "")
+
+	if _, ok := bc.Get("/a", 1, ""); ok {
+		t.Error("Expected least recently used block to be evicted")
+	}
+	if data, ok := bc.Get("/a", 0, ""); !ok || !bytes.Equal(data, []byte("aaaa")) {
+		t.Errorf("Expected /a block 0 to be kept, got %q (ok=%v)", data, ok)
+	}
+	if stats := bc.Stats(); stats.Entries != 2 || stats.Bytes != 8 {
+		t.Errorf("Expected 2 blocks of 8 bytes, got %+v", stats)
+	}
+}
+
+func TestBlockCacheInvalidate(t *testing.T) {
+	bc := NewBlockCache(4, 1024, time.Minute)
+	gen := bc.Generation()
+	bc.Put("/dir/file", 0, []byte("data"), gen, "")
+	bc.Put("/dir2/file", 0, []byte("data"), gen, "")
+	bc.Put("/other", 0, []byte("data"), gen, "")
+
+	bc.Invalidate("/dir")
+
+	if _, ok := bc.Get("/dir/file", 0, ""); ok {
+		t.Error("Expected blocks below the invalidated path to be dropped")
+	}
+	if _, ok := bc.Get("/dir2/file", 0, ""); !ok {
+		t.Error("Expected /dir2/file to be kept")
+	}
+	if _, ok := bc.Get("/other", 0, ""); !ok {
+		t.Error("Expected /other to be kept")
+	}
+}
+
+func TestBlockCacheStaleGenerationDropped(t *testing.T) {
+	bc := NewBlockCache(4, 1024, time.Minute)
+
+	// A read that started before a write must not cache what it fetched
+	gen := bc.Generation()
+	bc.Invalidate("/file")
+	bc.Put("/file", 0, []byte("old!"), gen, "")
+
+	if _, ok := bc.Get("/file", 0, ""); ok {
+		t.Error("Expected block fetched before the invalidation to be dropped")
+	}
+}
+
+func TestBlockCacheTTL(t *testing.T) {
+	bc := NewBlockCache(4, 1024, 20*time.Millisecond)
+	bc.Put("/file", 0, []byte("data"), bc.Generation(), "")
+
+	time.Sleep(40 * time.Millisecond)
+	if _, ok := bc.Get("/file", 0, ""); ok {
+		t.Error("Expected block to expire")
+	}
+	if stats := bc.Stats(); stats.Entries != 0 || stats.Bytes != 0 {
+		t.Errorf("Expected expired block to be removed, got %+v", stats)
+	}
+}
+
+func TestBlockCacheETag(t *testing.T) {
+	bc := NewBlockCache(4, 1024, 20*time.Millisecond)
+	bc.Put("/file", 0, []byte("data"), bc.Generation(), "v1")
+	bc.Put("/file", 1, []byte("more"), bc.Generation(), "v1")
+
+	// An unchanged etag keeps serving the block past the TTL
+	time.Sleep(40 * time.Millisecond)
+	if data, ok := bc.Get("/file", 0, "v1"); !ok || !bytes.Equal(data, []byte("data")) {
+		t.Errorf("Expected expired block with an unchanged etag to be served, got %q (ok=%v)", data, ok)
+	}
+	if stats := bc.Stats(); stats.Revalidated != 1 {
+		t.Errorf("Expected 1 revalidated block, got %+v", stats)
+	}
+
+	// A changed etag drops the block even before it expires
+	if _, ok := bc.Get("/file", 0, "v2"); ok {
+		t.Error("Expected block with a changed etag to be dropped")
+	}
+	// Without a known etag the block expires with the TTL
+	if _, ok := bc.Get("/file", 1, ""); ok {
+		t.Error("Expected expired block to be dropped when the etag is unknown")
+	}
+}
+
+func TestBlockCacheChecksum(t *testing.T) {
+	bc := NewBlockCache(4, 1024, time.Minute)
+	bc.Put("/file", 0, []byte("data"), bc.Generation(), "v1")
+
+	// Bytes changed after they were stored fail the checksum
+	data, _ := bc.Get("/file", 0, "v1")
+	copy(data, "junk")
+	if data, ok := bc.Get("/file", 0, "v1"); ok {
+		t.Errorf("Expected corrupt block to be dropped, got %q", data)
+	}
+	if stats := bc.Stats(); stats.Entries != 0 || stats.Repaired != 1 {
+		t.Errorf("Expected corrupt block to be evicted and counted, got %+v", stats)
+	}
+
+	// Blocks whose checksum is 0, as that of these bytes, are checked too
+	bc.Put("/file", 1, []byte("\x9d\n\xd9m"), bc.Generation(), "v1")
+	data, _ = bc.Get("/file", 1, "v1")
+	copy(data, "junk")
+	if data, ok := bc.Get("/file", 1, "v1"); ok {
+		t.Errorf("Expected corrupt block with a zero checksum to be dropped, got %q", data)
+	}
+}
+
+func TestBlockCacheChecksumDisk(t *testing.T) {
+	backend, err := NewDiskBackend(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatalf("NewDiskBackend failed: %v", err)
+	}
+	defer backend.Close()
+	bc := NewBlockCacheWithBackend(4, backend, time.Minute)
+	bc.Put("/file", 0, []byte("data"), bc.Generation(), "v1")
+	if e, ok := backend.Get(blockKey("/file", 0)); !ok || !e.HasSum || e.Sum != crc32.ChecksumIEEE([]byte("data")) {
+		t.Fatalf("Expected the block's checksum stored on disk, got %+v (ok=%v)", e, ok)
+	}
+
+	// Bytes changed on disk fail the block's checksum, not only the file's
+	file := backend.file(blockKey("/file", 0))
+	content, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(content[len(content)-4:], "junk")
+	os.WriteFile(file, content, 0600)
+	if data, ok := bc.Get("/file", 0, "v1"); ok {
+		t.Errorf("Expected corrupt block to be dropped, got %q", data)
+	}
+	if stats := bc.Stats(); stats.Entries != 0 || stats.Repaired != 1 {
+		t.Errorf("Expected corrupt block to be evicted and counted, got %+v", stats)
+	}
+}
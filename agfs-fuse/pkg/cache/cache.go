@@ -1,7 +1,11 @@
 package cache
 
 import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
@@ -11,6 +15,11 @@ import (
 type entry struct {
 	value      interface{}
 	expiration time.Time
+	// ttl is the entry's own TTL (0 = the cache's)
+	ttl time.Duration
+	// retain keeps the entry this long after it expires, so it can be
+	// revalidated instead of fetched again (0 = dropped once expired)
+	retain time.Duration
 }
 
 // isExpired checks if the entry has expired
@@ -23,6 +32,23 @@ type Cache struct {
 	mu      sync.RWMutex
 	entries map[string]*entry
 	ttl     time.Duration
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+	// revalidated counts expired entries refreshed with Refresh
+	revalidated atomic.Uint64
+}
+
+// Stats is a snapshot of cache usage
+type Stats struct {
+	Entries int    `json:"entries"`         // Entries currently stored, including expired ones not yet cleaned up
+	Bytes   int64  `json:"bytes,omitempty"` // Bytes stored, for caches bounded by size
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	// Revalidated counts expired entries the server confirmed unchanged
+	Revalidated uint64 `json:"revalidated,omitempty"`
+	// Repaired counts entries dropped for failing their checksum, to be
+	// fetched again from the server
+	Repaired uint64 `json:"repaired,omitempty"`
 }
 
 // NewCache creates a new cache with the given TTL
@@ -49,8 +75,35 @@ func (c *Cache) Set(key string, value interface{}) {
 	}
 }
 
-// Get retrieves a value from the cache
-func (c *Cache) Get(key string) (interface{}, bool) {
+// SetRetained stores a value that is kept for retain after it expires, so
+// that GetStale can return it for revalidation
+func (c *Cache) SetRetained(key string, value interface{}, retain time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = &entry{
+		value:      value,
+		expiration: time.Now().Add(c.ttl),
+		retain:     retain,
+	}
+}
+
+// SetTTL stores a value with its own TTL instead of the cache's, kept for
+// retain after it expires like SetRetained
+func (c *Cache) SetTTL(key string, value interface{}, ttl, retain time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = &entry{
+		value:      value,
+		expiration: time.Now().Add(ttl),
+		ttl:        ttl,
+		retain:     retain,
+	}
+}
+
+// GetStale retrieves a value from the cache even if it expired
+func (c *Cache) GetStale(key string) (interface{}, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -58,14 +111,62 @@ func (c *Cache) Get(key string) (interface{}, bool) {
 	if !ok {
 		return nil, false
 	}
+	return e.value, true
+}
 
-	if e.isExpired() {
+// Refresh restarts the TTL of an expired entry after it was revalidated
+func (c *Cache) Refresh(key string) {
+	c.RefreshTTL(key, 0)
+}
+
+// RefreshTTL is Refresh, giving the entry ttl as its own TTL from now on
+// (0 = keep the TTL it has)
+func (c *Cache) RefreshTTL(key string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		if ttl > 0 {
+			e.ttl = ttl
+		}
+		if e.ttl > 0 {
+			e.expiration = time.Now().Add(e.ttl)
+		} else {
+			e.expiration = time.Now().Add(c.ttl)
+		}
+		c.revalidated.Add(1)
+	}
+}
+
+// Get retrieves a value from the cache
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	e, ok := c.entries[key]
+	if !ok || e.isExpired() {
+		c.misses.Add(1)
 		return nil, false
 	}
 
+	c.hits.Add(1)
 	return e.value, true
 }
 
+// Stats returns the cache usage since it was created
+func (c *Cache) Stats() Stats {
+	c.mu.RLock()
+	n := len(c.entries)
+	c.mu.RUnlock()
+
+	return Stats{
+		Entries:     n,
+		Hits:        c.hits.Load(),
+		Misses:      c.misses.Load(),
+		Revalidated: c.revalidated.Load(),
+	}
+}
+
 // Delete removes a value from the cache
 func (c *Cache) Delete(key string) {
 	c.mu.Lock()
@@ -103,7 +204,7 @@ func (c *Cache) cleanup() {
 		c.mu.Lock()
 		now := time.Now()
 		for key, e := range c.entries {
-			if now.After(e.expiration) {
+			if now.After(e.expiration.Add(e.retain)) {
 				delete(c.entries, key)
 			}
 		}
@@ -111,9 +212,23 @@ func (c *Cache) cleanup() {
 	}
 }
 
-// MetadataCache caches file metadata
+// etagRetainTTLs is how many TTLs file info with an etag is kept after it
+// expires, to be revalidated rather than fetched again
+const etagRetainTTLs = 10
+
+// MetadataCache caches file metadata. File info carrying an etag (see
+// agfs.FileInfo.ETag) outlives its TTL: GetStale returns it to be
+// revalidated with the server, and Refresh extends it when it is unchanged.
+//
+// With an AdaptiveTTL, every path is cached for the TTL it picks from how
+// often the path was seen changing, rather than for the cache's TTL.
 type MetadataCache struct {
-	cache *Cache
+	cache    *Cache
+	adaptive *AdaptiveTTL // nil = every path is cached for the cache's TTL
+
+	// epoch counts invalidations, for SetAt
+	epochMu sync.Mutex
+	epoch   uint64
 }
 
 // NewMetadataCache creates a new metadata cache
@@ -123,6 +238,32 @@ func NewMetadataCache(ttl time.Duration) *MetadataCache {
 	}
 }
 
+// NewAdaptiveMetadataCache creates a metadata cache whose TTLs adaptive
+// picks per path
+func NewAdaptiveMetadataCache(adaptive *AdaptiveTTL) *MetadataCache {
+	return &MetadataCache{
+		cache:    NewCache(adaptive.base),
+		adaptive: adaptive,
+	}
+}
+
+// fileVersion identifies the version of a file for AdaptiveTTL: its etag,
+// or else its modification time, size and mode
+func fileVersion(info *agfs.FileInfo) string {
+	if etag := info.ETag(); etag != "" {
+		return etag
+	}
+	return fmt.Sprintf("%x-%x-%o-%t", info.ModTime.UnixNano(), info.Size, info.Mode, info.IsDir)
+}
+
+// TTL returns the TTL path is cached for
+func (mc *MetadataCache) TTL(path string) time.Duration {
+	if mc.adaptive == nil {
+		return mc.cache.ttl
+	}
+	return mc.adaptive.TTL(path)
+}
+
 // Get retrieves file info from cache
 func (mc *MetadataCache) Get(path string) (*agfs.FileInfo, bool) {
 	value, ok := mc.cache.Get(path)
@@ -135,62 +276,175 @@ func (mc *MetadataCache) Get(path string) (*agfs.FileInfo, bool) {
 
 // Set stores file info in cache
 func (mc *MetadataCache) Set(path string, info *agfs.FileInfo) {
+	if mc.adaptive != nil {
+		ttl := mc.adaptive.Observe(path, fileVersion(info))
+		var retain time.Duration
+		if info.ETag() != "" {
+			retain = etagRetainTTLs * ttl
+		}
+		mc.cache.SetTTL(path, info, ttl, retain)
+		return
+	}
+	if info.ETag() != "" {
+		mc.cache.SetRetained(path, info, etagRetainTTLs*mc.cache.ttl)
+		return
+	}
 	mc.cache.Set(path, info)
 }
 
+// Epoch returns the number of invalidations so far, to pass to SetAt
+func (mc *MetadataCache) Epoch() uint64 {
+	mc.epochMu.Lock()
+	defer mc.epochMu.Unlock()
+	return mc.epoch
+}
+
+// SetAt stores file info fetched at epoch, unless the cache was invalidated
+// since: the info may predate the change that invalidated it. It returns
+// whether the info was stored.
+func (mc *MetadataCache) SetAt(path string, info *agfs.FileInfo, epoch uint64) bool {
+	mc.epochMu.Lock()
+	defer mc.epochMu.Unlock()
+	if mc.epoch != epoch {
+		return false
+	}
+	mc.Set(path, info)
+	return true
+}
+
+// invalidated starts a new epoch around an invalidation
+func (mc *MetadataCache) invalidated(invalidate func()) {
+	mc.epochMu.Lock()
+	defer mc.epochMu.Unlock()
+	mc.epoch++
+	invalidate()
+}
+
+// GetStale retrieves file info from cache even if it expired. Only file
+// info with an etag is kept past its TTL.
+func (mc *MetadataCache) GetStale(path string) (*agfs.FileInfo, bool) {
+	value, ok := mc.cache.GetStale(path)
+	if !ok {
+		return nil, false
+	}
+	info, ok := value.(*agfs.FileInfo)
+	return info, ok
+}
+
+// Refresh restarts the TTL of path's file info once the server confirmed
+// its etag is unchanged
+func (mc *MetadataCache) Refresh(path string) {
+	if mc.adaptive != nil {
+		if info, ok := mc.GetStale(path); ok {
+			mc.cache.RefreshTTL(path, mc.adaptive.Observe(path, fileVersion(info)))
+			return
+		}
+	}
+	mc.cache.Refresh(path)
+}
+
 // Invalidate removes file info from cache
 func (mc *MetadataCache) Invalidate(path string) {
-	mc.cache.Delete(path)
+	mc.invalidated(func() { mc.cache.Delete(path) })
 }
 
 // InvalidatePrefix invalidates all paths with the given prefix
 func (mc *MetadataCache) InvalidatePrefix(prefix string) {
-	mc.cache.DeletePrefix(prefix)
+	mc.invalidated(func() { mc.cache.DeletePrefix(prefix) })
 }
 
 // Clear clears all cached metadata
 func (mc *MetadataCache) Clear() {
-	mc.cache.Clear()
+	mc.invalidated(mc.cache.Clear)
 }
 
-// DirectoryCache caches directory listings
+// Stats returns the metadata cache usage
+func (mc *MetadataCache) Stats() Stats {
+	return mc.cache.Stats()
+}
+
+// DefaultDirCacheSize bounds the bytes of the listings a DirectoryCache
+// created with NewDirectoryCache holds
+const DefaultDirCacheSize = 64 << 20
+
+// DirectoryCache caches directory listings, encoded with gob in a Backend
+// so they can be kept on disk as well as in memory
 type DirectoryCache struct {
-	cache *Cache
+	ttl     time.Duration
+	backend Backend
+	hits    atomic.Uint64
+	misses  atomic.Uint64
 }
 
-// NewDirectoryCache creates a new directory cache
+// NewDirectoryCache creates a new directory cache holding at most
+// DefaultDirCacheSize bytes of listings in memory
 func NewDirectoryCache(ttl time.Duration) *DirectoryCache {
+	return NewDirectoryCacheWithBackend(ttl, NewMemoryBackend(DefaultDirCacheSize))
+}
+
+// NewDirectoryCacheWithBackend creates a directory cache keeping its
+// listings in backend
+func NewDirectoryCacheWithBackend(ttl time.Duration, backend Backend) *DirectoryCache {
 	return &DirectoryCache{
-		cache: NewCache(ttl),
+		ttl:     ttl,
+		backend: backend,
 	}
 }
 
 // Get retrieves directory listing from cache
 func (dc *DirectoryCache) Get(path string) ([]agfs.FileInfo, bool) {
-	value, ok := dc.cache.Get(path)
-	if !ok {
+	e, ok := dc.backend.Get(path)
+	if !ok || time.Now().After(e.Expires) {
+		dc.misses.Add(1)
 		return nil, false
 	}
-	files, ok := value.([]agfs.FileInfo)
-	return files, ok
+	var files []agfs.FileInfo
+	if err := gob.NewDecoder(bytes.NewReader(e.Data)).Decode(&files); err != nil {
+		dc.backend.Invalidate(path)
+		dc.misses.Add(1)
+		return nil, false
+	}
+	dc.hits.Add(1)
+	return files, true
 }
 
 // Set stores directory listing in cache
 func (dc *DirectoryCache) Set(path string, files []agfs.FileInfo) {
-	dc.cache.Set(path, files)
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(files); err != nil {
+		dc.backend.Invalidate(path)
+		return
+	}
+	dc.backend.Put(path, Entry{Data: buf.Bytes(), Expires: time.Now().Add(dc.ttl)})
 }
 
 // Invalidate removes directory listing from cache
 func (dc *DirectoryCache) Invalidate(path string) {
-	dc.cache.Delete(path)
+	dc.backend.Invalidate(path)
 }
 
 // InvalidatePrefix invalidates all directories with the given prefix
 func (dc *DirectoryCache) InvalidatePrefix(prefix string) {
-	dc.cache.DeletePrefix(prefix)
+	dc.backend.InvalidatePrefix(prefix)
 }
 
 // Clear clears all cached directories
 func (dc *DirectoryCache) Clear() {
-	dc.cache.Clear()
+	dc.backend.Clear()
+}
+
+// Close clears the cache and releases the backend
+func (dc *DirectoryCache) Close() error {
+	return dc.backend.Close()
+}
+
+// Stats returns the directory cache usage
+func (dc *DirectoryCache) Stats() Stats {
+	entries, size := dc.backend.Size()
+	return Stats{
+		Entries: entries,
+		Bytes:   size,
+		Hits:    dc.hits.Load(),
+		Misses:  dc.misses.Load(),
+	}
 }
@@ -100,6 +100,45 @@ func TestMetadataCache(t *testing.T) {
 	if ok {
 		t.Error("Expected /test.txt to be invalidated")
 	}
+
+	// Info fetched before an invalidation isn't stored
+	epoch := mc.Epoch()
+	mc.Invalidate("/other.txt")
+	if mc.SetAt("/test.txt", info, epoch) {
+		t.Error("Expected SetAt to skip info fetched before an invalidation")
+	}
+	if !mc.SetAt("/test.txt", info, mc.Epoch()) {
+		t.Error("Expected SetAt to store info fetched at the current epoch")
+	}
+	if _, ok := mc.Get("/test.txt"); !ok {
+		t.Error("Expected /test.txt to be cached by SetAt")
+	}
+}
+
+func TestMetadataCacheRetainsETag(t *testing.T) {
+	mc := NewMetadataCache(20 * time.Millisecond)
+	mc.Set("/tagged", &agfs.FileInfo{Name: "tagged", Meta: agfs.MetaData{Content: map[string]string{agfs.MetaETag: "v1"}}})
+	mc.Set("/plain", &agfs.FileInfo{Name: "plain"})
+
+	time.Sleep(60 * time.Millisecond) // Past the TTL and a cleanup
+	if _, ok := mc.Get("/tagged"); ok {
+		t.Error("Expected /tagged to expire")
+	}
+	stale, ok := mc.GetStale("/tagged")
+	if !ok || stale.ETag() != "v1" {
+		t.Fatalf("Expected /tagged to be kept for revalidation, got %+v (ok=%v)", stale, ok)
+	}
+	if _, ok := mc.GetStale("/plain"); ok {
+		t.Error("Expected /plain without an etag to be cleaned up")
+	}
+
+	mc.Refresh("/tagged")
+	if _, ok := mc.Get("/tagged"); !ok {
+		t.Error("Expected refreshed /tagged to be fresh")
+	}
+	if stats := mc.Stats(); stats.Revalidated != 1 {
+		t.Errorf("Expected 1 revalidated entry, got %+v", stats)
+	}
 }
 
 func TestDirectoryCache(t *testing.T) {
@@ -152,3 +191,17 @@ func TestCacheConcurrency(t *testing.T) {
 
 	// If we got here without panic, concurrency is safe
 }
+
+func TestCacheStats(t *testing.T) {
+	c := NewCache(time.Minute)
+
+	c.Set("key1", "value1")
+	c.Get("key1")
+	c.Get("key1")
+	c.Get("missing")
+
+	stats := c.Stats()
+	if stats.Entries != 1 || stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("Expected 1 entry, 2 hits, 1 miss, got %+v", stats)
+	}
+}
@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// diskMagic starts every file of a DiskBackend
+var diskMagic = []byte("AGFC")
+
+// diskHeaderSize is the size of the fixed part of a file of a DiskBackend:
+// the magic, a CRC-32 of the rest of the file, the expiration time, the
+// lengths of the key and the etag, which precede the data, and the entry's
+// Sum and HasSum. The CRC-32 leaves out the data of entries with a sum,
+// which the cache checks itself, so it can report the corruption.
+const diskHeaderSize = 4 + 4 + 8 + 4 + 4 + 4 + 1
+
+// diskEntry is what a DiskBackend keeps in memory of an entry
+type diskEntry struct {
+	key  string
+	size int64  // Bytes of data
+	seq  uint64 // Identifies the Put that wrote the file
+}
+
+// DiskBackend is a Backend keeping entries in files of a directory, one per
+// entry, so a cache can be larger than what it's worth holding in memory.
+// Only the keys and sizes of the entries are kept in memory, in LRU order.
+//
+// Every file carries a checksum and its key: a file that is truncated,
+// corrupted or missing is a miss, and is dropped. Entries with a Sum keep
+// it, and corrupted data of theirs is left for their cache to find. The files don't outlive
+// the backend, whose entries nothing could revalidate: the cache files left
+// in the directory by an earlier backend are removed when it is created, and
+// those of the backend when it is closed.
+type DiskBackend struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	size     int64
+	seq      uint64
+	lru      *list.List // Front is the most recently used entry
+	entries  map[string]*list.Element
+}
+
+// NewDiskBackend creates a disk backend in dir, which is created if
+// missing, holding at most maxBytes of data
+func NewDiskBackend(dir string, maxBytes int64) (*DiskBackend, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	d := &DiskBackend{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+	if err := d.removeFiles(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// file returns the path of the file of key
+func (d *DiskBackend) file(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:])+".cache")
+}
+
+func (d *DiskBackend) Get(key string) (Entry, bool) {
+	d.mu.Lock()
+	elem, ok := d.entries[key]
+	if !ok {
+		d.mu.Unlock()
+		return Entry{}, false
+	}
+	d.lru.MoveToFront(elem)
+	seq := elem.Value.(*diskEntry).seq
+	d.mu.Unlock()
+
+	data, err := os.ReadFile(d.file(key))
+	if err == nil {
+		var e Entry
+		if e, err = decodeDiskEntry(key, data); err == nil {
+			return e, true
+		}
+	}
+
+	// Drop the entry, unless a Put replaced it meanwhile
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if elem, ok := d.entries[key]; ok && elem.Value.(*diskEntry).seq == seq {
+		d.remove(elem)
+	}
+	return Entry{}, false
+}
+
+func (d *DiskBackend) Put(key string, e Entry) {
+	if int64(len(e.Data)) > d.maxBytes {
+		d.Invalidate(key)
+		return
+	}
+
+	// The file is written aside and renamed into place, so a Get never
+	// reads it half written
+	tmp, err := os.CreateTemp(d.dir, "put-*.tmp")
+	if err != nil {
+		d.Invalidate(key)
+		return
+	}
+	_, err = tmp.Write(encodeDiskEntry(key, e))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.entries[key]; ok {
+		d.remove(elem)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.file(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	d.seq++
+	d.entries[key] = d.lru.PushFront(&diskEntry{key: key, size: int64(len(e.Data)), seq: d.seq})
+	d.size += int64(len(e.Data))
+	for d.size > d.maxBytes {
+		d.remove(d.lru.Back())
+	}
+}
+
+func (d *DiskBackend) Invalidate(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if elem, ok := d.entries[key]; ok {
+		d.remove(elem)
+	}
+}
+
+func (d *DiskBackend) InvalidatePrefix(prefix string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, elem := range d.entries {
+		if strings.HasPrefix(key, prefix) {
+			d.remove(elem)
+		}
+	}
+}
+
+func (d *DiskBackend) Clear() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, elem := range d.entries {
+		d.remove(elem)
+	}
+}
+
+func (d *DiskBackend) Size() (int, int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries), d.size
+}
+
+// Close removes the files of the backend, and its directory if that leaves
+// it empty
+func (d *DiskBackend) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.lru.Init()
+	d.entries = make(map[string]*list.Element)
+	d.size = 0
+	if err := d.removeFiles(); err != nil {
+		return err
+	}
+	os.Remove(d.dir)
+	return nil
+}
+
+// remove drops an entry and its file, the caller holds d.mu
+func (d *DiskBackend) remove(elem *list.Element) {
+	e := d.lru.Remove(elem).(*diskEntry)
+	delete(d.entries, e.key)
+	d.size -= e.size
+	os.Remove(d.file(e.key))
+}
+
+// removeFiles removes the cache files and the files of interrupted Puts
+// from the directory, leaving any other file alone
+func (d *DiskBackend) removeFiles() error {
+	for _, pattern := range []string{"*.cache", "put-*.tmp"} {
+		names, err := filepath.Glob(filepath.Join(d.dir, pattern))
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// encodeDiskEntry returns the content of the file of the entry e under key
+func encodeDiskEntry(key string, e Entry) []byte {
+	buf := make([]byte, diskHeaderSize, diskHeaderSize+len(key)+len(e.ETag)+len(e.Data))
+	copy(buf, diskMagic)
+	var expires int64
+	if !e.Expires.IsZero() {
+		expires = e.Expires.UnixNano()
+	}
+	binary.BigEndian.PutUint64(buf[8:], uint64(expires))
+	binary.BigEndian.PutUint32(buf[16:], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[20:], uint32(len(e.ETag)))
+	binary.BigEndian.PutUint32(buf[24:], e.Sum)
+	if e.HasSum {
+		buf[28] = 1
+	}
+	buf = append(buf, key...)
+	buf = append(buf, e.ETag...)
+	checked := len(buf)
+	buf = append(buf, e.Data...)
+	if !e.HasSum {
+		checked = len(buf)
+	}
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(buf[8:checked]))
+	return buf
+}
+
+// errCorrupt is returned for cache files that don't hold the expected entry
+var errCorrupt = errors.New("corrupted cache file")
+
+// decodeDiskEntry returns the entry of the file content data, which must
+// be stored under key
+func decodeDiskEntry(key string, data []byte) (Entry, error) {
+	if len(data) < diskHeaderSize || !bytes.Equal(data[:4], diskMagic) {
+		return Entry{}, errCorrupt
+	}
+	keyLen := int64(binary.BigEndian.Uint32(data[16:]))
+	etagLen := int64(binary.BigEndian.Uint32(data[20:]))
+	hasSum := data[28] == 1
+	rest := data[diskHeaderSize:]
+	if keyLen+etagLen > int64(len(rest)) {
+		return Entry{}, errCorrupt
+	}
+	checked := int64(len(data))
+	if hasSum {
+		checked = diskHeaderSize + keyLen + etagLen
+	}
+	if binary.BigEndian.Uint32(data[4:]) != crc32.ChecksumIEEE(data[8:checked]) || string(rest[:keyLen]) != key {
+		return Entry{}, errCorrupt
+	}
+	e := Entry{
+		ETag:   string(rest[keyLen : keyLen+etagLen]),
+		Data:   rest[keyLen+etagLen:],
+		Sum:    binary.BigEndian.Uint32(data[24:]),
+		HasSum: hasSum,
+	}
+	if expires := int64(binary.BigEndian.Uint64(data[8:])); expires != 0 {
+		e.Expires = time.Unix(0, expires)
+	}
+	return e, nil
+}
@@ -0,0 +1,239 @@
+// Package control serves a line-based control protocol on a Unix socket, so
+// operators can inspect and manage a running mount without unmounting it.
+//
+// Clients send one command per line and get one JSON response per line:
+//
+//	$ echo stats | socat - UNIX-CONNECT:/run/agfs-fuse.sock
+//	{"ok":true,"result":{"handles":{"open":0,...},...}}
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dongxuny/agfs-fuse/pkg/fusefs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+)
+
+// Response is written as a single JSON line for every command
+type Response struct {
+	OK     bool        `json:"ok"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// command handles the arguments following the command name
+type command struct {
+	usage string
+	run   func(s *Server, args []string) (interface{}, error)
+}
+
+var commands = map[string]command{
+	"stats": {"stats", func(s *Server, args []string) (interface{}, error) {
+		return s.fs.Stats(), nil
+	}},
+	"handles": {"handles", func(s *Server, args []string) (interface{}, error) {
+		return s.fs.OpenHandles(), nil
+	}},
+	"flush": {"flush", func(s *Server, args []string) (interface{}, error) {
+		s.fs.FlushCaches()
+		return "caches flushed", nil
+	}},
+	"debug": {"debug on|off", func(s *Server, args []string) (interface{}, error) {
+		if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+			return nil, errors.New("usage: debug on|off")
+		}
+		s.SetDebug(args[0] == "on")
+		return map[string]bool{"debug": args[0] == "on"}, nil
+	}},
+	"log-level": {"log-level debug|info|warn|error", func(s *Server, args []string) (interface{}, error) {
+		if len(args) != 1 {
+			return nil, errors.New("usage: log-level debug|info|warn|error")
+		}
+		level, err := log.ParseLevel(args[0])
+		if err != nil {
+			return nil, err
+		}
+		log.SetLevel(level)
+		return map[string]string{"level": level.String()}, nil
+	}},
+}
+
+// usages lists the usage of every command
+func usages() []string {
+	list := []string{"help"}
+	for _, c := range commands {
+		list = append(list, c.usage)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Server accepts control connections for a mounted filesystem
+type Server struct {
+	path     string
+	listener net.Listener
+	fs       *fusefs.AGFSFS
+	fuse     *fuse.Server
+
+	mu     sync.Mutex
+	conns  map[net.Conn]struct{}
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// Listen starts serving the control protocol on a Unix socket at path. The
+// fuse server is optional; when set, "debug" also toggles FUSE request logging.
+// A stale socket left behind by a previous process is replaced.
+func Listen(path string, root *fusefs.AGFSFS, server *fuse.Server) (*Server, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("listen on control socket: %w", err)
+	}
+	// The socket can flush caches and change logging, keep it to the owner
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("chmod control socket: %w", err)
+	}
+
+	s := &Server{
+		path:     path,
+		listener: listener,
+		fs:       root,
+		fuse:     server,
+		conns:    make(map[net.Conn]struct{}),
+	}
+	s.wg.Add(1)
+	go s.acceptLoop()
+	return s, nil
+}
+
+// removeStaleSocket removes a socket at path that nobody is listening on
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("control socket path %s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close()
+		return fmt.Errorf("control socket %s is in use by another process", path)
+	}
+	return os.Remove(path)
+}
+
+// SetDebug switches debug logging on or off, including FUSE request logging
+// when the server was given a fuse server
+func (s *Server) SetDebug(debug bool) {
+	if s.fuse != nil {
+		s.fuse.SetDebug(debug)
+	}
+	if debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.InfoLevel)
+	}
+	log.Infof("Debug logging turned %s via control socket", map[bool]string{true: "on", false: "off"}[debug])
+}
+
+func (s *Server) acceptLoop() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if !closed {
+				log.Errorf("Control socket accept failed: %v", err)
+			}
+			return
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+// serve answers commands on conn until the client disconnects
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	enc := json.NewEncoder(conn)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if err := enc.Encode(s.execute(fields[0], fields[1:])); err != nil {
+			return
+		}
+	}
+}
+
+// execute runs a single command
+func (s *Server) execute(name string, args []string) Response {
+	if name == "help" {
+		return Response{OK: true, Result: usages()}
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		return Response{Error: fmt.Sprintf("unknown command %q, try \"help\"", name)}
+	}
+	result, err := cmd.run(s, args)
+	if err != nil {
+		return Response{Error: err.Error()}
+	}
+	return Response{OK: true, Result: result}
+}
+
+// Close stops accepting connections, disconnects clients and removes the socket
+func (s *Server) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	err := s.listener.Close()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	os.Remove(s.path)
+	return err
+}
@@ -0,0 +1,126 @@
+package control
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dongxuny/agfs-fuse/pkg/fusefs"
+	log "github.com/sirupsen/logrus"
+)
+
+func startServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	testServer := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(testServer.Close)
+
+	root := fusefs.NewAGFSFS(fusefs.Config{ServerURL: testServer.URL, CacheTTL: time.Minute})
+	t.Cleanup(func() { root.Close() })
+
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	s, err := Listen(path, root, nil)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, path
+}
+
+func send(t *testing.T, conn net.Conn, reader *bufio.Reader, line string) Response {
+	t.Helper()
+	if _, err := conn.Write([]byte(line + "\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := reader.ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	var resp Response
+	if err := json.Unmarshal(data, &resp); err != nil {
+		t.Fatalf("Invalid response %q: %v", data, err)
+	}
+	return resp
+}
+
+func TestControlCommands(t *testing.T) {
+	_, path := startServer(t)
+	defer log.SetLevel(log.GetLevel())
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	if resp := send(t, conn, reader, "stats"); !resp.OK {
+		t.Errorf("Expected stats to succeed, got %q", resp.Error)
+	} else if stats, ok := resp.Result.(map[string]interface{}); !ok || stats["breaker"] != "closed" {
+		t.Errorf("Unexpected stats result %v", resp.Result)
+	}
+	if resp := send(t, conn, reader, "handles"); !resp.OK {
+		t.Errorf("Expected handles to succeed, got %q", resp.Error)
+	}
+	if resp := send(t, conn, reader, "flush"); !resp.OK {
+		t.Errorf("Expected flush to succeed, got %q", resp.Error)
+	}
+	if resp := send(t, conn, reader, "log-level warn"); !resp.OK || log.GetLevel() != log.WarnLevel {
+		t.Errorf("Expected log level warn, got %v (%q)", log.GetLevel(), resp.Error)
+	}
+	if resp := send(t, conn, reader, "debug maybe"); resp.OK {
+		t.Error("Expected invalid debug argument to fail")
+	}
+	if resp := send(t, conn, reader, "bogus"); resp.OK || resp.Error == "" {
+		t.Error("Expected unknown command to fail")
+	}
+}
+
+func TestControlCloseRemovesSocket(t *testing.T) {
+	s, path := startServer(t)
+
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// Close must not wait for idle clients to hang up
+	done := make(chan error, 1)
+	go func() { done <- s.Close() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Close failed: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on an idle connection")
+	}
+
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket to be removed, got %v", err)
+	}
+}
+
+func TestControlReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ctl.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Listen failed: %v", err)
+	}
+	// Leave the socket file behind like a crashed process would
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+
+	if err := removeStaleSocket(path); err != nil {
+		t.Fatalf("Expected stale socket to be removed: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected socket to be gone, got %v", err)
+	}
+}
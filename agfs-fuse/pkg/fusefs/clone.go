@@ -0,0 +1,133 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"math"
+	"syscall"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+var _ = (fs.NodeCopyFileRanger)((*AGFSNode)(nil))
+
+// copyChunk is the size of the reads and writes of copies the server can't
+// make itself
+const copyChunk = 1 << 20
+
+// CopyFileRange serves copy_file_range(2) without the data passing through
+// the kernel. A whole file copied into an empty one, as cp does, is cloned on
+// the server, copy-on-write where the plugin can, so cp --reflink=auto, cp's
+// default, shares blocks. Other ranges are copied by the server, and read and
+// written through the handles when it can't copy ranges.
+//
+// As with copy_file_range(2), a copy stops at the end of the source, copying
+// nothing past it, and ranges of the same file can't overlap (EINVAL).
+// Streams, which can't be read twice, fail with EOPNOTSUPP and the kernel
+// copies them through read and write.
+//
+// FICLONE ioctls, which cp --reflink=always relies on, never reach FUSE file
+// systems: the kernel fails them itself.
+func (n *AGFSNode) CopyFileRange(ctx context.Context, fhIn fs.FileHandle, offIn uint64, out *fs.Inode, fhOut fs.FileHandle, offOut uint64, length uint64, flags uint64) (uint32, syscall.Errno) {
+	dst, ok := out.Operations().(*AGFSNode)
+	if !ok || dst.root != n.root {
+		return 0, syscall.EXDEV
+	}
+	in, okIn := fhIn.(*AGFSFileHandle)
+	outFile, okOut := fhOut.(*AGFSFileHandle)
+	if !okIn || !okOut {
+		return 0, syscall.EBADF
+	}
+	if offIn > math.MaxInt64 || offOut > math.MaxInt64 {
+		return 0, syscall.EINVAL
+	}
+	// The reply can't count more than 4GB; the kernel asks again for the rest
+	length = min(length, math.MaxUint32)
+	srcPath, dstPath := n.getPath(), dst.getPath()
+	if srcPath == dstPath && offIn < offOut+length && offOut < offIn+length {
+		return 0, syscall.EINVAL
+	}
+	ctx, span := n.root.startSpan(ctx, "CopyFileRange", srcPath)
+	defer span.End()
+
+	// The server copies what it has, so the writes either handle holds for
+	// its commit window go first
+	for _, h := range []uint64{in.handle, outFile.handle} {
+		if err := n.root.handles.Flush(ctx, h); err != nil {
+			return 0, ToErrno(err)
+		}
+	}
+
+	client := n.root.clientFor(ctx)
+	src, err := client.Stat(srcPath)
+	if err != nil {
+		return 0, ToErrno(err)
+	}
+	if access := src.Access(); access == agfs.AccessStream || access == agfs.AccessConsumeOnce {
+		return 0, syscall.EOPNOTSUPP
+	}
+	if offIn >= uint64(src.Size) {
+		return 0, 0
+	}
+	length = min(length, uint64(src.Size)-offIn)
+
+	if n.root.clones && offIn == 0 && offOut == 0 && length == uint64(src.Size) && srcPath != dstPath {
+		existing, err := client.Stat(dstPath)
+		if err != nil {
+			return 0, ToErrno(err)
+		}
+		if existing.Size == 0 {
+			n.root.logger.Debugf("[node] Cloning %s to %s", srcPath, dstPath)
+			if err := client.Clone(srcPath, dstPath); err != nil {
+				return 0, ToErrno(err)
+			}
+			n.root.copied(dstPath)
+			return uint32(length), 0
+		}
+	}
+
+	if n.root.copyRanges {
+		n.root.logger.Debugf("[node] Copying %d bytes of %s at %d to %s at %d", length, srcPath, offIn, dstPath, offOut)
+		copied, err := client.CopyRange(srcPath, int64(offIn), dstPath, int64(offOut), int64(length))
+		if err == nil {
+			n.root.copied(dstPath)
+			return uint32(copied), 0
+		}
+		if !errors.Is(err, agfs.ErrNotSupported) {
+			return 0, ToErrno(err)
+		}
+	}
+
+	// Without server-side copies the range is read and written here, which
+	// still saves the round trips through the kernel
+	var copied uint64
+	for copied < length {
+		data, err := n.root.handles.Read(ctx, in.handle, int64(offIn+copied), int(min(length-copied, copyChunk)))
+		if err != nil {
+			if copied > 0 {
+				break
+			}
+			return 0, ToErrno(err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		written, err := n.root.handles.Write(ctx, outFile.handle, data, int64(offOut+copied))
+		copied += uint64(written)
+		if err != nil {
+			if copied > 0 {
+				break
+			}
+			return 0, ToErrno(err)
+		}
+	}
+	n.root.metaCache.Invalidate(dstPath)
+	return uint32(copied), 0
+}
+
+// copied drops what is cached of dst once the server changed its content
+func (root *AGFSFS) copied(dst string) {
+	root.invalidateCache(dst)
+	root.handles.truncated(dst)
+}
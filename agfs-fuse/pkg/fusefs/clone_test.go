@@ -0,0 +1,123 @@
+package fusefs
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-sdk/go/agfstest"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// openForCopy looks up and opens /src, holding "original", and an empty
+// /dst on srv, as cp does before copying
+func openForCopy(t *testing.T, srv *agfstest.Server) (src *AGFSNode, dst *fs.Inode, in, out fs.FileHandle) {
+	t.Helper()
+	client := agfs.NewClient(srv.URL)
+	if _, err := client.Write("/src", []byte("original")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := client.Create("/dst"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	root := NewAGFSFS(Config{ServerURL: srv.URL, CacheTTL: time.Minute})
+	t.Cleanup(func() { root.Close() })
+	fs.NewNodeFS(root, &fs.Options{})
+	ctx := context.Background()
+
+	open := func(name string, flags uint32) (*fs.Inode, fs.FileHandle) {
+		var entry fuse.EntryOut
+		child, errno := root.Lookup(ctx, name, &entry)
+		if errno != 0 {
+			t.Fatalf("Lookup %s failed: %v", name, errno)
+		}
+		root.AddChild(name, child, false)
+		fh, _, errno := child.Operations().(fs.NodeOpener).Open(ctx, flags)
+		if errno != 0 {
+			t.Fatalf("Open %s failed: %v", name, errno)
+		}
+		t.Cleanup(func() { fh.(fs.FileReleaser).Release(ctx) })
+		return child, fh
+	}
+	srcInode, in := open("src", syscall.O_RDONLY)
+	dst, out = open("dst", syscall.O_WRONLY)
+	return srcInode.Operations().(*AGFSNode), dst, in, out
+}
+
+func TestCopyFileRangeClones(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	src, dst, in, out := openForCopy(t, srv)
+	ctx := context.Background()
+
+	n, errno := src.CopyFileRange(ctx, in, 0, dst, out, 0, 1<<30, 0)
+	if errno != 0 || n != 8 {
+		t.Fatalf("Expected the 8 bytes cloned, got %d (%v)", n, errno)
+	}
+	client := agfs.NewClient(srv.URL)
+	if data, err := client.Read("/dst", 0, -1); err != nil || string(data) != "original" {
+		t.Errorf("Expected the clone to read %q, got %q (%v)", "original", data, err)
+	}
+
+	// The files are independent afterwards
+	if _, errno := out.(fs.FileWriter).Write(ctx, []byte("modified"), 0); errno != 0 {
+		t.Fatalf("Write failed: %v", errno)
+	}
+	if errno := out.(fs.FileFlusher).Flush(ctx); errno != 0 {
+		t.Fatalf("Flush failed: %v", errno)
+	}
+	if data, _ := client.Read("/src", 0, -1); string(data) != "original" {
+		t.Errorf("Expected /src unchanged by writing its clone, got %q", data)
+	}
+}
+
+// checkCopyRanges copies ranges of /src, holding "original", into /dst and
+// checks the results follow copy_file_range(2)
+func checkCopyRanges(t *testing.T, srv *agfstest.Server) {
+	t.Helper()
+	src, dst, in, out := openForCopy(t, srv)
+	ctx := context.Background()
+	client := agfs.NewClient(srv.URL)
+
+	// A range copied past the end of the empty file leaves a hole before it
+	n, errno := src.CopyFileRange(ctx, in, 2, dst, out, 3, 4, 0)
+	if errno != 0 || n != 4 {
+		t.Fatalf("Expected 4 bytes copied, got %d (%v)", n, errno)
+	}
+	if data, _ := client.Read("/dst", 0, -1); string(data) != "\x00\x00\x00igin" {
+		t.Errorf("Expected the range at offset 3, got %q", data)
+	}
+
+	// Copies stop at the end of the source, and copy nothing past it
+	if n, errno := src.CopyFileRange(ctx, in, 5, dst, out, 0, 1<<30, 0); errno != 0 || n != 3 {
+		t.Errorf("Expected the last 3 bytes copied, got %d (%v)", n, errno)
+	}
+	if data, _ := client.Read("/dst", 0, -1); string(data) != "naligin" {
+		t.Errorf("Expected the tail over the start of /dst, got %q", data)
+	}
+	if n, errno := src.CopyFileRange(ctx, in, 8, dst, out, 0, 4, 0); errno != 0 || n != 0 {
+		t.Errorf("Expected nothing copied past the end, got %d (%v)", n, errno)
+	}
+
+	// Ranges of the same file can't overlap
+	if _, errno := src.CopyFileRange(ctx, in, 0, src.EmbeddedInode(), in, 2, 4, 0); errno != syscall.EINVAL {
+		t.Errorf("Expected EINVAL for overlapping ranges, got %v", errno)
+	}
+}
+
+func TestCopyFileRangeOnServer(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	checkCopyRanges(t, srv)
+}
+
+func TestCopyFileRangeWithoutServerCopies(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	srv.Disable(agfs.FeatureClone, agfs.FeatureCopyRange)
+	checkCopyRanges(t, srv)
+}
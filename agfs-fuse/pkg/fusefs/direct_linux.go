@@ -0,0 +1,6 @@
+package fusefs
+
+import "syscall"
+
+// oDirect is the open flag asking for a direct handle
+const oDirect = syscall.O_DIRECT
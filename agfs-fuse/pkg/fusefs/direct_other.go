@@ -0,0 +1,7 @@
+//go:build !linux
+
+package fusefs
+
+// oDirect is the open flag asking for a direct handle, which only Linux
+// passes to FUSE; elsewhere only Config.DirectIO makes handles direct
+const oDirect = 0
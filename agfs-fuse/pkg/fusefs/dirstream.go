@@ -0,0 +1,143 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"syscall"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// readDir lists the directory at path, from the cache if it holds it. With
+// a batch size, it is listed a page at a time as the kernel reads it.
+func (root *AGFSFS) readDir(ctx context.Context, path string) (fs.DirStream, syscall.Errno) {
+	if cached, ok := root.dirCache.Get(path); ok {
+		return root.listDirStream(path, cached), 0
+	}
+
+	var files []agfs.FileInfo
+	var err error
+	epoch := root.metaCache.Epoch()
+	if root.readdirBatch > 0 {
+		var next string
+		files, next, err = root.clientFor(ctx).ReadDirPage(path, "", root.readdirBatch)
+		if err == nil && next != "" {
+			s := &pagedDirStream{root: root, path: path}
+			s.setPage(files, next)
+			return s, 0
+		}
+	} else {
+		files, err = root.clientFor(ctx).ReadDir(path)
+	}
+	if err != nil {
+		return nil, ToErrno(err)
+	}
+
+	root.dirCache.Set(path, files)
+	root.prefetchChildren(path, files, epoch)
+	return root.listDirStream(path, files), 0
+}
+
+// listDirStream returns the entries of the directory at path listed in files
+func (root *AGFSFS) listDirStream(path string, files []agfs.FileInfo) fs.DirStream {
+	entries := make([]fuse.DirEntry, 0, len(files))
+	for i := range files {
+		entries = append(entries, root.dirEntry(path, &files[i]))
+	}
+	return fs.NewListDirStream(entries)
+}
+
+// dirEntry returns the entry of f in the directory at path
+func (root *AGFSFS) dirEntry(path string, f *agfs.FileInfo) fuse.DirEntry {
+	return fuse.DirEntry{
+		Name: f.Name,
+		Mode: root.maskMode(getStableMode(f)),
+		Ino:  root.ino(filepath.Join(path, f.Name), f),
+	}
+}
+
+// pagedDirStream lists a directory with ReadDirPage, fetching the next page
+// once the kernel has read the current one, so at most a page of a huge
+// directory is held in memory. Pages follow each other in name order, so an
+// entry added or removed mid-listing is at worst missed, never repeated.
+type pagedDirStream struct {
+	root *AGFSFS
+	path string
+
+	page  []agfs.FileInfo // Entries fetched and not returned yet
+	after string          // Cursor of the next page
+	more  bool            // There are pages left to fetch
+	last  string          // Name of the last entry returned
+	off   uint64          // Entries returned so far
+	errno syscall.Errno   // Failure fetching the next page, returned by Next
+}
+
+var _ = (fs.FileSeekdirer)((*pagedDirStream)(nil))
+
+func (s *pagedDirStream) HasNext() bool {
+	for len(s.page) == 0 && s.more && s.errno == 0 {
+		s.fetch()
+	}
+	return len(s.page) > 0 || s.errno != 0
+}
+
+func (s *pagedDirStream) Next() (fuse.DirEntry, syscall.Errno) {
+	if len(s.page) == 0 {
+		errno := s.errno
+		s.errno, s.more = 0, false
+		return fuse.DirEntry{}, errno
+	}
+	f := &s.page[0]
+	s.page = s.page[1:]
+	s.last = f.Name
+	s.off++
+	entry := s.root.dirEntry(s.path, f)
+	entry.Off = s.off
+	return entry, 0
+}
+
+// Seekdir supports rewinding the listing, and seeking to where it is
+func (s *pagedDirStream) Seekdir(ctx context.Context, off uint64) syscall.Errno {
+	switch off {
+	case s.off:
+	case 0:
+		s.page, s.after, s.more, s.last, s.off, s.errno = nil, "", true, "", 0, 0
+	default:
+		return syscall.ENOTSUP
+	}
+	return 0
+}
+
+func (s *pagedDirStream) Close() {
+	s.page = nil
+}
+
+// fetch gets the next page. The kernel reads pages outside of any FUSE
+// operation of ours, so they aren't traced. A directory removed
+// mid-listing ends it.
+func (s *pagedDirStream) fetch() {
+	files, next, err := s.root.client.ReadDirPage(s.path, s.after, s.root.readdirBatch)
+	if errors.Is(err, agfs.ErrNotFound) {
+		s.more = false
+		return
+	}
+	if err != nil {
+		s.errno = ToErrno(err)
+		return
+	}
+	s.setPage(files, next)
+}
+
+// setPage makes files the current page, dropping any entry up to the last
+// one returned, should the server list it again
+func (s *pagedDirStream) setPage(files []agfs.FileInfo, next string) {
+	for s.off > 0 && len(files) > 0 && files[0].Name <= s.last {
+		files = files[1:]
+	}
+	s.page = files
+	s.more = next != "" && next != s.after
+	s.after = next
+}
@@ -0,0 +1,181 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/hanwen/go-fuse/v2/fs"
+)
+
+// dirServer serves a directory of the given names, in pages like the AGFS
+// server, and records the largest listing it sent
+type dirServer struct {
+	mu      sync.Mutex
+	names   []string // Sorted
+	largest int
+}
+
+func newDirServer(tb testing.TB, names []string) (*dirServer, *httptest.Server) {
+	d := &dirServer{names: append([]string(nil), names...)}
+	sort.Strings(d.names)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/directories" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		d.mu.Lock()
+		names := d.names
+		if limit, _ := strconv.Atoi(r.URL.Query().Get("limit")); limit > 0 {
+			after := r.URL.Query().Get("after")
+			names = names[sort.SearchStrings(names, after+"\x00"):]
+			if len(names) > limit {
+				names = names[:limit]
+			}
+		}
+		var list agfs.ListResponse
+		list.Files = make([]agfs.FileInfoResponse, 0, len(names))
+		for _, name := range names {
+			list.Files = append(list.Files, agfs.FileInfoResponse{Name: name, Mode: 0644})
+		}
+		if len(names) > 0 && names[len(names)-1] != d.names[len(d.names)-1] && r.URL.Query().Get("limit") != "" {
+			list.Next = names[len(names)-1]
+		}
+		d.largest = max(d.largest, len(names))
+		d.mu.Unlock()
+		json.NewEncoder(w).Encode(list)
+	}))
+	tb.Cleanup(server.Close)
+	return d, server
+}
+
+// set replaces the directory's entries
+func (d *dirServer) set(names ...string) {
+	d.mu.Lock()
+	d.names = append([]string(nil), names...)
+	sort.Strings(d.names)
+	d.mu.Unlock()
+}
+
+// listAll reads every entry of stream, calling fn after each
+func listAll(t testing.TB, stream fs.DirStream, fn func(name string)) []string {
+	var names []string
+	for stream.HasNext() {
+		entry, errno := stream.Next()
+		if errno != 0 {
+			t.Fatalf("Next failed: %v", errno)
+		}
+		names = append(names, entry.Name)
+		if fn != nil {
+			fn(entry.Name)
+		}
+	}
+	stream.Close()
+	return names
+}
+
+func TestReaddirBatches(t *testing.T) {
+	var names []string
+	for i := 0; i < 10; i++ {
+		names = append(names, fmt.Sprintf("f%d", i))
+	}
+	dir, server := newDirServer(t, names)
+	root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Minute, ReaddirBatchSize: 3})
+	defer root.Close()
+	fs.NewNodeFS(root, &fs.Options{})
+
+	// Entries changing mid-listing are missed or listed, but nothing fails
+	// and no entry is listed twice
+	stream, errno := root.Readdir(context.Background())
+	if errno != 0 {
+		t.Fatalf("Readdir failed: %v", errno)
+	}
+	got := listAll(t, stream, func(name string) {
+		if name == "f2" {
+			dir.set("f0", "f1", "f2", "f3", "f4", "f40", "f6", "f7", "f8", "f9")
+		}
+	})
+	want := "[f0 f1 f2 f3 f4 f40 f6 f7 f8 f9]"
+	if fmt.Sprint(got) != want {
+		t.Errorf("Expected %s, got %v", want, got)
+	}
+	if dir.largest != 3 {
+		t.Errorf("Expected pages of 3 entries, got one of %d", dir.largest)
+	}
+
+	// Listings longer than a page aren't cached, so they are read again
+	stream, _ = root.Readdir(context.Background())
+	seekdir := stream.(fs.FileSeekdirer)
+	stream.HasNext()
+	stream.Next()
+	if errno := seekdir.Seekdir(context.Background(), 0); errno != 0 {
+		t.Fatalf("Rewinding failed: %v", errno)
+	}
+	if got := listAll(t, stream, nil); fmt.Sprint(got) != want {
+		t.Errorf("Expected the rewound listing to be whole, got %v", got)
+	}
+}
+
+func TestReaddirSinglePageCached(t *testing.T) {
+	dir, server := newDirServer(t, []string{"a", "b"})
+	root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Minute, ReaddirBatchSize: 3})
+	defer root.Close()
+	fs.NewNodeFS(root, &fs.Options{})
+
+	stream, _ := root.Readdir(context.Background())
+	listAll(t, stream, nil)
+	dir.set("a", "b", "c")
+	stream, _ = root.Readdir(context.Background())
+	if got := listAll(t, stream, nil); fmt.Sprint(got) != "[a b]" {
+		t.Errorf("Expected a listing of one page to be cached, got %v", got)
+	}
+}
+
+// BenchmarkReaddir500k lists a directory of 500k entries whole and in
+// batches, reporting the peak heap used while the kernel reads it
+func BenchmarkReaddir500k(b *testing.B) {
+	names := make([]string, 500000)
+	for i := range names {
+		names[i] = fmt.Sprintf("file-%07d", i)
+	}
+	_, server := newDirServer(b, names)
+
+	for _, batch := range []int{0, 1000} {
+		b.Run(fmt.Sprintf("batch=%d", batch), func(b *testing.B) {
+			var peak uint64
+			for i := 0; i < b.N; i++ {
+				root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Minute, ReaddirBatchSize: batch})
+				fs.NewNodeFS(root, &fs.Options{})
+				runtime.GC()
+				var m runtime.MemStats
+				runtime.ReadMemStats(&m)
+				base := m.HeapAlloc
+
+				stream, errno := root.Readdir(context.Background())
+				if errno != 0 {
+					b.Fatalf("Readdir failed: %v", errno)
+				}
+				n := 0
+				listAll(b, stream, func(string) {
+					if n++; n%10000 == 0 {
+						runtime.ReadMemStats(&m)
+						if m.HeapAlloc > base {
+							peak = max(peak, m.HeapAlloc-base)
+						}
+					}
+				})
+				root.Close()
+			}
+			b.ReportMetric(float64(peak)/(1<<20), "peak-heap-MB")
+		})
+	}
+}
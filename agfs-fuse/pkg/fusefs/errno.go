@@ -0,0 +1,51 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"syscall"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// ToErrno maps an error from the AGFS client to the errno reported to the
+// kernel. Errors the server classified (see agfs.StatusError) keep their
+// meaning, an errno anywhere in the chain is passed through, and everything
+// else, including transport failures and an open circuit breaker, is EIO.
+func ToErrno(err error) syscall.Errno {
+	if err == nil {
+		return 0
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		return errno
+	}
+
+	switch {
+	case errors.Is(err, agfs.ErrNotFound):
+		return syscall.ENOENT
+	case errors.Is(err, agfs.ErrAlreadyExists):
+		return syscall.EEXIST
+	case errors.Is(err, agfs.ErrPermissionDenied):
+		return syscall.EACCES
+	case errors.Is(err, agfs.ErrInvalidArgument):
+		return syscall.EINVAL
+	case errors.Is(err, agfs.ErrNotSupported):
+		return syscall.ENOTSUP
+	case errors.Is(err, agfs.ErrQuotaExceeded):
+		return syscall.EDQUOT
+	case errors.Is(err, agfs.ErrLocked):
+		return syscall.EAGAIN
+	case errors.Is(err, agfs.ErrRateLimited):
+		// The plugin's rate limit was exceeded, callers can retry
+		return syscall.EAGAIN
+	case errors.Is(err, agfs.ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		// The operation outlived its timeout, e.g. waiting for a busy plugin
+		return syscall.ETIMEDOUT
+	case errors.Is(err, context.Canceled):
+		// The kernel interrupted the operation
+		return syscall.EINTR
+	}
+	return syscall.EIO
+}
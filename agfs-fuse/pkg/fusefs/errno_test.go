@@ -0,0 +1,47 @@
+package fusefs
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"syscall"
+	"testing"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+func TestToErrno(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want syscall.Errno
+	}{
+		{"nil", nil, 0},
+		{"not found", &agfs.StatusError{StatusCode: http.StatusNotFound, Message: "no such file"}, syscall.ENOENT},
+		{"already exists", fmt.Errorf("%w: /lock", agfs.ErrAlreadyExists), syscall.EEXIST},
+		{"conflict", &agfs.StatusError{StatusCode: http.StatusConflict, Message: "exists"}, syscall.EEXIST},
+		{"permission denied", &agfs.StatusError{StatusCode: http.StatusForbidden, Message: "read-only"}, syscall.EACCES},
+		{"invalid argument", &agfs.StatusError{StatusCode: http.StatusBadRequest, Message: "bad path"}, syscall.EINVAL},
+		{"not supported", agfs.ErrNotSupported, syscall.ENOTSUP},
+		{"quota exceeded", &agfs.StatusError{StatusCode: http.StatusInsufficientStorage, Message: "full"}, syscall.EDQUOT},
+		{"locked", &agfs.StatusError{StatusCode: http.StatusLocked, Message: "lock held by another owner"}, syscall.EAGAIN},
+		{"rate limited", fmt.Errorf("failed to execute request: %w", agfs.ErrRateLimited), syscall.EAGAIN},
+		{"timed out", &agfs.StatusError{StatusCode: http.StatusGatewayTimeout, Message: "timeout waiting for available WASM instance"}, syscall.ETIMEDOUT},
+		{"circuit open", fmt.Errorf("failed to execute request: %w", agfs.ErrCircuitOpen), syscall.EIO},
+		{"server error", &agfs.StatusError{StatusCode: http.StatusInternalServerError, Message: "boom"}, syscall.EIO},
+		{"untyped", errors.New("connection reset"), syscall.EIO},
+		{"errno", fmt.Errorf("stat: %w", syscall.ENOTDIR), syscall.ENOTDIR},
+	}
+	for _, tt := range tests {
+		if got := ToErrno(tt.err); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+		// Wrapping doesn't change the mapping
+		if tt.err != nil {
+			wrapped := fmt.Errorf("failed to stat /file: %w", tt.err)
+			if got := ToErrno(wrapped); got != tt.want {
+				t.Errorf("%s (wrapped): expected %v, got %v", tt.name, tt.want, got)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package fusefs
+
+import (
+	"context"
+	"errors"
+	"path"
+	"strings"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// Bounds of the wait before subscribing again once the subscription to the
+// server's change events is lost; the wait doubles on every failed attempt
+var (
+	minResubscribe = time.Second
+	maxResubscribe = 30 * time.Second
+)
+
+// watchChanges subscribes to the changes made on the server and drops what
+// is cached of every changed path as they are reported, until ctx is done.
+// While the subscription is down, caches expire after the cache TTL as
+// usual, and everything cached is dropped when it is lost and restored, as
+// changes made meanwhile weren't reported. It gives up if the server
+// doesn't report changes.
+func (root *AGFSFS) watchChanges(ctx context.Context) {
+	wait := minResubscribe
+	for reconnect := false; ; reconnect = true {
+		events, err := root.client.Subscribe(ctx, "/")
+		if errors.Is(err, agfs.ErrNotSupported) {
+			root.logger.Infof("AGFS server doesn't report changes, caches expire after the cache TTL")
+			return
+		}
+		if err == nil {
+			if reconnect {
+				root.FlushCaches()
+				root.logger.Infof("Subscribed to changes again")
+			}
+			wait = minResubscribe
+			root.subscribed.Store(true)
+			for event := range events {
+				root.invalidateChanged(event)
+			}
+			root.subscribed.Store(false)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		root.FlushCaches()
+		if err != nil {
+			root.logger.Warnf("Subscribing to changes failed, retrying in %v: %v", wait, err)
+		} else {
+			root.logger.Warnf("Lost the subscription to changes, subscribing again in %v", wait)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+		wait *= 2
+		if wait > maxResubscribe {
+			wait = maxResubscribe
+		}
+	}
+}
+
+// invalidateChanged drops what is cached of a path changed on the server,
+// and of everything under it, in this process and in the kernel
+func (root *AGFSFS) invalidateChanged(event agfs.InvalidationEvent) {
+	p := path.Clean("/" + event.Path)
+	root.logger.Debugf("Server reported %s of %s", event.Op, p)
+	if p == "/" {
+		root.FlushCaches()
+		return
+	}
+
+	root.invalidateCache(p)
+	root.metaCache.InvalidatePrefix(p + "/")
+	root.dirCache.Invalidate(p)
+	root.dirCache.InvalidatePrefix(p + "/")
+	root.notifyKernel(p)
+}
+
+// Mounted tells the filesystem that the kernel serves it, once fs.Mount
+// returned, so changes reported by the server also drop the kernel's
+// cached attributes, entries and data of the changed paths
+func (root *AGFSFS) Mounted() {
+	for _, m := range root.mounts {
+		m.fs.Mounted()
+	}
+	root.mounted.Store(true)
+}
+
+// notifyKernel drops the kernel's cache of p, if the kernel looked it up
+func (root *AGFSFS) notifyKernel(p string) {
+	if !root.mounted.Load() {
+		return
+	}
+	parent := &root.Inode
+	names := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for _, name := range names[:len(names)-1] {
+		if parent = parent.GetChild(name); parent == nil {
+			return
+		}
+	}
+	name := names[len(names)-1]
+	if child := parent.GetChild(name); child != nil {
+		child.NotifyContent(0, 0)
+	}
+	parent.NotifyEntry(name)
+}
@@ -0,0 +1,136 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// eventServer serves stats, counting them, and change events sent on events;
+// a send on drop ends an event stream, as a lost connection does
+type eventServer struct {
+	*httptest.Server
+	stats  atomic.Int64
+	events chan agfs.InvalidationEvent
+	drop   chan struct{}
+}
+
+func newEventServer(t *testing.T, supported bool) *eventServer {
+	s := &eventServer{events: make(chan agfs.InvalidationEvent), drop: make(chan struct{})}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			s.stats.Add(1)
+			json.NewEncoder(w).Encode(agfs.FileInfoResponse{Name: "file", Size: 4, Mode: 0644})
+		case r.URL.Path == "/api/v1/events" && supported:
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			for {
+				select {
+				case event := <-s.events:
+					data, _ := json.Marshal(event)
+					fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+					w.(http.Flusher).Flush()
+				case <-s.drop:
+					return
+				case <-r.Context().Done():
+					return
+				}
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// waitFor polls cond until it holds, failing after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSubscribeInvalidates(t *testing.T) {
+	defer func(d time.Duration) { minResubscribe = d }(minResubscribe)
+	minResubscribe = 10 * time.Millisecond
+
+	server := newEventServer(t, true)
+	root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Hour, Subscribe: true})
+	defer root.Close()
+	ctx := context.Background()
+	waitFor(t, "the subscription", root.subscribed.Load)
+
+	stat := func(path string) {
+		t.Helper()
+		if _, err := root.statCached(ctx, path); err != nil {
+			t.Fatalf("Stat %s failed: %v", path, err)
+		}
+	}
+	stat("/dir/file")
+	stat("/other")
+	stat("/dir/file")
+	if n := server.stats.Load(); n != 2 {
+		t.Fatalf("Expected the second stat of /dir/file to be cached, got %d stats", n)
+	}
+
+	// A change of /dir drops everything cached under it, and only that
+	server.events <- agfs.InvalidationEvent{Op: "rename", Path: "/dir"}
+	waitFor(t, "the invalidation", func() bool {
+		_, ok := root.metaCache.Get("/dir/file")
+		return !ok
+	})
+	stat("/dir/file")
+	stat("/other")
+	if n := server.stats.Load(); n != 3 {
+		t.Errorf("Expected only /dir/file to be stat again, got %d stats", n)
+	}
+	if !root.Stats().Subscribed {
+		t.Error("Expected stats to report the subscription")
+	}
+
+	// A lost subscription drops everything, as changes may have been missed,
+	// and is restored
+	server.drop <- struct{}{}
+	waitFor(t, "the caches to be flushed", func() bool {
+		_, ok := root.metaCache.Get("/other")
+		return !ok
+	})
+	stat("/other")
+	server.events <- agfs.InvalidationEvent{Op: "write", Path: "/other"}
+	waitFor(t, "the invalidation after subscribing again", func() bool {
+		_, ok := root.metaCache.Get("/other")
+		return !ok
+	})
+}
+
+func TestSubscribeNotSupported(t *testing.T) {
+	server := newEventServer(t, false)
+	root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Hour, Subscribe: true})
+	defer root.Close()
+
+	// Caches still work, expiring after the TTL
+	if _, err := root.statCached(context.Background(), "/file"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if _, ok := root.metaCache.Get("/file"); !ok {
+		t.Error("Expected the stat to be cached")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if root.Stats().Subscribed {
+		t.Error("Expected no subscription to a server without change events")
+	}
+}
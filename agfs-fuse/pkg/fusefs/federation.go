@@ -0,0 +1,177 @@
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// ServerMount is an AGFS server mounted at a path of a federated tree
+type ServerMount struct {
+	Path string // Absolute path in the FUSE tree, e.g. "/a"
+	URL  string // AGFS server URL
+}
+
+// serverMount is a server of a federated root and the filesystem serving it
+type serverMount struct {
+	path string
+	fs   *AGFSFS
+}
+
+// ParseServerMounts parses "path=url" server mounts, such as
+// "/a=http://h1:8080", sorted by path. Paths must be distinct and not nested
+// in one another, and "/" is reserved for the directories leading to them.
+func ParseServerMounts(specs []string) ([]ServerMount, error) {
+	mounts := make([]ServerMount, 0, len(specs))
+	for _, spec := range specs {
+		p, url, ok := strings.Cut(spec, "=")
+		if !ok || url == "" {
+			return nil, fmt.Errorf("invalid server mount %q, expected path=url", spec)
+		}
+		if !strings.HasPrefix(p, "/") {
+			return nil, fmt.Errorf("invalid server mount %q: path must be absolute", spec)
+		}
+		p = path.Clean(p)
+		if p == "/" {
+			return nil, fmt.Errorf("invalid server mount %q: a server can't be mounted at the root of a federated tree", spec)
+		}
+		mounts = append(mounts, ServerMount{Path: p, URL: url})
+	}
+
+	sort.Slice(mounts, func(i, j int) bool { return mounts[i].Path < mounts[j].Path })
+	for i := 1; i < len(mounts); i++ {
+		prev, cur := mounts[i-1].Path, mounts[i].Path
+		if prev == cur {
+			return nil, fmt.Errorf("server mount path %s is used twice", cur)
+		}
+		if strings.HasPrefix(cur, prev+"/") {
+			return nil, fmt.Errorf("server mount path %s is nested in %s", cur, prev)
+		}
+	}
+	return mounts, nil
+}
+
+// newFederatedFS creates the root of a tree of several AGFS servers. Each
+// server is served by its own AGFSFS, with its own client, handles and
+// caches, grafted at its mount path when the root is added to the tree.
+func newFederatedFS(config Config) *AGFSFS {
+	uid, gid := configOwner(config)
+	root := &AGFSFS{
+		uid:    uid,
+		gid:    gid,
+		umask:  config.Umask & 0777,
+		tracer: config.Tracer,
+		logger: config.Logger,
+	}
+
+	config.bandwidth = agfs.NewBandwidthLimiter(config.Bandwidth)
+	for i, m := range config.Servers {
+		sub := config
+		sub.ServerURL = m.URL
+		sub.Servers = nil
+		if config.CacheDir != "" {
+			sub.CacheDir = filepath.Join(config.CacheDir, strconv.Itoa(i))
+		}
+		root.mounts = append(root.mounts, serverMount{path: path.Clean(m.Path), fs: NewAGFSFS(sub)})
+	}
+	sort.Slice(root.mounts, func(i, j int) bool { return root.mounts[i].path < root.mounts[j].path })
+	return root
+}
+
+// Route returns the filesystem serving path and the path on its server. A
+// root serving a single server routes every path to itself; a federated root
+// routes by the longest mount path prefix, like MountableFS does for plugins
+// on the server, and reports false for paths outside every mount.
+func (root *AGFSFS) Route(p string) (*AGFSFS, string, bool) {
+	if root.mounts == nil {
+		return root, p, true
+	}
+	p = path.Clean("/" + p)
+	var best *serverMount
+	for i := range root.mounts {
+		m := &root.mounts[i]
+		if (p == m.path || strings.HasPrefix(p, m.path+"/")) && (best == nil || len(m.path) > len(best.path)) {
+			best = m
+		}
+	}
+	if best == nil {
+		return nil, "", false
+	}
+	return best.fs, "/" + strings.TrimPrefix(strings.TrimPrefix(p, best.path), "/"), true
+}
+
+var _ = (fs.NodeOnAdder)((*AGFSFS)(nil))
+
+// OnAdd builds the directories of a federated root leading to its servers
+func (root *AGFSFS) OnAdd(ctx context.Context) {
+	for _, m := range root.mounts {
+		parent := &root.Inode
+		names := strings.Split(strings.TrimPrefix(m.path, "/"), "/")
+		for i, name := range names {
+			child := parent.GetChild(name)
+			if child == nil {
+				var node fs.InodeEmbedder = &mountDir{root: root}
+				if i == len(names)-1 {
+					node = m.fs
+				}
+				child = parent.NewPersistentInode(ctx, node, fs.StableAttr{Mode: syscall.S_IFDIR})
+				parent.AddChild(name, child, false)
+			}
+			parent = child
+		}
+	}
+}
+
+// lookupMount looks up a directory of a federated root
+func lookupMount(ctx context.Context, parent *fs.Inode, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	child := parent.GetChild(name)
+	if child == nil {
+		return nil, syscall.ENOENT
+	}
+	if ga, ok := child.Operations().(fs.NodeGetattrer); ok {
+		var attr fuse.AttrOut
+		if errno := ga.Getattr(ctx, nil, &attr); errno == 0 {
+			out.Attr = attr.Attr
+		}
+	}
+	return child, 0
+}
+
+// readMountDir lists a directory of a federated root
+func readMountDir(dir *fs.Inode) fs.DirStream {
+	children := dir.Children()
+	names := make([]string, 0, len(children))
+	for name := range children {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]fuse.DirEntry, 0, len(names))
+	for _, name := range names {
+		entries = append(entries, fuse.DirEntry{Name: name, Mode: syscall.S_IFDIR})
+	}
+	return fs.NewListDirStream(entries)
+}
+
+// mountDir is a directory of a federated root leading to mounted servers,
+// such as "shard" for servers mounted at /shard/a and /shard/b
+type mountDir struct {
+	fs.Inode
+	root *AGFSFS
+}
+
+var _ = (fs.NodeGetattrer)((*mountDir)(nil))
+
+// Getattr reports the directory like the root
+func (d *mountDir) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
+	return d.root.Getattr(ctx, f, out)
+}
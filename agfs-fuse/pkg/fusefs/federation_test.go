@@ -0,0 +1,162 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestParseServerMounts(t *testing.T) {
+	mounts, err := ParseServerMounts([]string{"/b=http://h2", "/shard/a/=http://h1"})
+	if err != nil {
+		t.Fatalf("ParseServerMounts failed: %v", err)
+	}
+	want := []ServerMount{{Path: "/b", URL: "http://h2"}, {Path: "/shard/a", URL: "http://h1"}}
+	if len(mounts) != len(want) || mounts[0] != want[0] || mounts[1] != want[1] {
+		t.Errorf("Expected %+v, got %+v", want, mounts)
+	}
+
+	invalid := map[string][]string{
+		"no url":    {"/a"},
+		"relative":  {"a=http://h1"},
+		"root":      {"/=http://h1"},
+		"duplicate": {"/a=http://h1", "/a/=http://h2"},
+		"nested":    {"/a=http://h1", "/a/b=http://h2"},
+	}
+	for name, specs := range invalid {
+		if _, err := ParseServerMounts(specs); err == nil {
+			t.Errorf("%s: expected %v to be rejected", name, specs)
+		}
+	}
+}
+
+// newNamedServer returns a server whose stat answers report its name
+func newNamedServer(t *testing.T, name string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/stat" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		path := r.URL.Query().Get("path")
+		json.NewEncoder(w).Encode(agfs.FileInfoResponse{
+			Name: name + ":" + path,
+			Mode: 0644,
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFederatedRouting(t *testing.T) {
+	a := newNamedServer(t, "a")
+	b := newNamedServer(t, "b")
+	root := NewAGFSFS(Config{
+		CacheTTL: time.Second,
+		Servers:  []ServerMount{{Path: "/shard/a", URL: a.URL}, {Path: "/b", URL: b.URL}},
+	})
+	t.Cleanup(func() { root.Close() })
+
+	routes := []struct {
+		path, server, serverPath string
+	}{
+		{"/shard/a/dir/file", "a", "/dir/file"},
+		{"/shard/a", "a", "/"},
+		{"/b/file", "b", "/file"},
+		{"b/x/", "b", "/x"},
+	}
+	for _, r := range routes {
+		sub, serverPath, ok := root.Route(r.path)
+		if !ok || serverPath != r.serverPath {
+			t.Errorf("Route(%q) = %q, %v, expected %q", r.path, serverPath, ok, r.serverPath)
+			continue
+		}
+		info, err := sub.statCached(context.Background(), serverPath)
+		if err != nil {
+			t.Fatalf("Stat %s failed: %v", r.path, err)
+		}
+		if want := r.server + ":" + r.serverPath; info.Name != want {
+			t.Errorf("Expected %s to be served by %s, got %s", r.path, want, info.Name)
+		}
+	}
+
+	for _, path := range []string{"/", "/shard", "/shard/ab", "/bb", "/c/file"} {
+		if _, _, ok := root.Route(path); ok {
+			t.Errorf("Expected %s not to be routed to a server", path)
+		}
+	}
+}
+
+func TestFederatedTree(t *testing.T) {
+	a := newNamedServer(t, "a")
+	b := newNamedServer(t, "b")
+	c := newNamedServer(t, "c")
+	root := NewAGFSFS(Config{
+		CacheTTL: time.Second,
+		Servers: []ServerMount{
+			{Path: "/shard/a", URL: a.URL},
+			{Path: "/shard/b", URL: b.URL},
+			{Path: "/c", URL: c.URL},
+		},
+	})
+	t.Cleanup(func() { root.Close() })
+	fs.NewNodeFS(root, &fs.Options{}) // Adds the root, building the tree
+
+	ctx := context.Background()
+	var out fuse.EntryOut
+	shard, errno := root.Lookup(ctx, "shard", &out)
+	if errno != 0 || out.Attr.Mode&syscall.S_IFDIR == 0 {
+		t.Fatalf("Expected the shard directory, got %v, mode %o", errno, out.Attr.Mode)
+	}
+	if _, errno := root.Lookup(ctx, "missing", &out); errno != syscall.ENOENT {
+		t.Errorf("Expected ENOENT outside the mounts, got %v", errno)
+	}
+
+	handles := make(map[*HandleManager]bool)
+	for _, name := range []string{"a", "b"} {
+		child := shard.GetChild(name)
+		if child == nil {
+			t.Fatalf("Expected shard/%s", name)
+		}
+		sub, ok := child.Operations().(*AGFSFS)
+		if !ok {
+			t.Fatalf("Expected shard/%s to be served by its own filesystem", name)
+		}
+		if routed, _, _ := root.Route("/shard/" + name); routed != sub {
+			t.Errorf("Expected /shard/%s to route to the filesystem in the tree", name)
+		}
+		info, err := sub.statCached(ctx, "/f")
+		if err != nil || info.Name != name+":/f" {
+			t.Errorf("Expected shard/%s to be served by server %s, got %+v, %v", name, name, info, err)
+		}
+		handles[sub.handles] = true
+	}
+	if len(handles) != 2 {
+		t.Error("Expected each server to have its own handle manager")
+	}
+
+	stream, errno := root.Readdir(ctx)
+	if errno != 0 {
+		t.Fatalf("Readdir failed: %v", errno)
+	}
+	var names []string
+	for stream.HasNext() {
+		entry, _ := stream.Next()
+		names = append(names, entry.Name)
+	}
+	if strings.Join(names, ",") != "c,shard" {
+		t.Errorf("Expected c and shard at the root, got %v", names)
+	}
+
+	if stats := root.Stats(); len(stats.Mounts) != 3 {
+		t.Errorf("Expected stats for 3 servers, got %+v", stats.Mounts)
+	}
+}
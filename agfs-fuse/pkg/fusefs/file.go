@@ -2,58 +2,103 @@ package fusefs
 
 import (
 	"context"
+	"errors"
+	"sync"
 	"syscall"
 
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
-	log "github.com/sirupsen/logrus"
 )
 
 // AGFSFileHandle represents an open file handle
 type AGFSFileHandle struct {
 	node   *AGFSNode
 	handle uint64
+
+	// Owners that took locks through the handle, released with it
+	mu         sync.Mutex
+	lockOwners map[uint64]struct{}
+	// Where the last read ended, how many reads in a row looked like the
+	// page faults of a memory mapping, whether the handle is marked as
+	// mapped, and whether it can't be, guarded by mu
+	next   int64
+	faults int
+	mmap   bool
+	noMmap bool
 }
 
 var _ = (fs.FileReader)((*AGFSFileHandle)(nil))
 var _ = (fs.FileWriter)((*AGFSFileHandle)(nil))
 var _ = (fs.FileFsyncer)((*AGFSFileHandle)(nil))
+var _ = (fs.FileFlusher)((*AGFSFileHandle)(nil))
 var _ = (fs.FileReleaser)((*AGFSFileHandle)(nil))
 var _ = (fs.FileGetattrer)((*AGFSFileHandle)(nil))
 
 // Read reads data from the file
 func (fh *AGFSFileHandle) Read(ctx context.Context, dest []byte, off int64) (fuse.ReadResult, syscall.Errno) {
-	data, err := fh.node.root.handles.Read(fh.handle, off, len(dest))
+	ctx, span := fh.startSpan(ctx, "Read")
+	defer span.End()
+
+	fh.detectMmap(off, len(dest))
+
+	// A live stream with no data yet is read again until it produces some
+	// or ends, or the read is interrupted, like a read(2) of a pipe
+	result, err := fh.node.root.handles.ReadResult(ctx, fh.handle, off, len(dest))
+	for errors.Is(err, ErrStreamPending) {
+		if ctx.Err() != nil {
+			return nil, syscall.EINTR
+		}
+		result, err = fh.node.root.handles.ReadResult(ctx, fh.handle, off, len(dest))
+	}
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, ToErrno(err)
 	}
 
-	return fuse.ReadResultData(data), 0
+	return result, 0
 }
 
 // Write writes data to the file
 func (fh *AGFSFileHandle) Write(ctx context.Context, data []byte, off int64) (written uint32, errno syscall.Errno) {
 	path := fh.node.getPath()
-	log.Debugf("[file] Write called: path=%s, len=%d, off=%d, handle=%d", path, len(data), off, fh.handle)
+	fh.node.root.logger.Debugf("[file] Write called: path=%s, len=%d, off=%d, handle=%d", path, len(data), off, fh.handle)
 
-	n, err := fh.node.root.handles.Write(fh.handle, data, off)
+	ctx, span := fh.node.root.startSpan(ctx, "Write", path)
+	defer span.End()
+
+	n, err := fh.node.root.handles.Write(ctx, fh.handle, data, off)
 	if err != nil {
-		log.Errorf("[file] Write failed: path=%s, err=%v", path, err)
-		return 0, syscall.EIO
+		fh.node.root.logger.Errorf("[file] Write failed: path=%s, err=%v", path, err)
+		return 0, ToErrno(err)
 	}
 
 	// Invalidate metadata cache since file size may have changed
 	fh.node.root.metaCache.Invalidate(path)
 
-	log.Debugf("[file] Write success: path=%s, written=%d", path, n)
+	fh.node.root.logger.Debugf("[file] Write success: path=%s, written=%d", path, n)
 	return uint32(n), 0
 }
 
 // Fsync syncs file data to storage
 func (fh *AGFSFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno {
-	err := fh.node.root.handles.Sync(fh.handle)
+	ctx, span := fh.startSpan(ctx, "Fsync")
+	defer span.End()
+
+	err := fh.node.root.handles.Sync(ctx, fh.handle)
 	if err != nil {
-		return syscall.EIO
+		return ToErrno(err)
+	}
+
+	return 0
+}
+
+// Flush writes what the handle holds for its commit window, on every close
+// of a file descriptor of it, so close reports a failed write
+func (fh *AGFSFileHandle) Flush(ctx context.Context) syscall.Errno {
+	ctx, span := fh.startSpan(ctx, "Flush")
+	defer span.End()
+
+	if err := fh.node.root.handles.Flush(ctx, fh.handle); err != nil {
+		return ToErrno(err)
 	}
 
 	return 0
@@ -61,9 +106,14 @@ func (fh *AGFSFileHandle) Fsync(ctx context.Context, flags uint32) syscall.Errno
 
 // Release releases the file handle
 func (fh *AGFSFileHandle) Release(ctx context.Context) syscall.Errno {
-	err := fh.node.root.handles.Close(fh.handle)
+	ctx, span := fh.startSpan(ctx, "Release")
+	defer span.End()
+
+	fh.releaseLocks(ctx)
+
+	err := fh.node.root.handles.Close(ctx, fh.handle)
 	if err != nil {
-		return syscall.EIO
+		return ToErrno(err)
 	}
 
 	return 0
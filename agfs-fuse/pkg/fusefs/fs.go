@@ -2,15 +2,27 @@ package fusefs
 
 import (
 	"context"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
 	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
 	"github.com/dongxuny/agfs-fuse/pkg/cache"
+	"github.com/dongxuny/agfs-fuse/pkg/version"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // AGFSFS is the root of the FUSE file system
@@ -22,7 +34,65 @@ type AGFSFS struct {
 	metaCache *cache.MetadataCache
 	dirCache  *cache.DirectoryCache
 	cacheTTL  time.Duration
+	uid       uint32 // Owner reported for every file
+	gid       uint32 // Group reported for every file
+	umask     uint32 // Permission bits cleared from every reported mode
+	inoSeed   string // Mixed into inode numbers, unique per server
+	tracer    trace.Tracer
+	logger    *log.Logger
 	mu        sync.RWMutex
+
+	// attrTimeout is how long the kernel keeps attributes, overriding the
+	// TTL of the metadata cache (0 = that TTL)
+	attrTimeout time.Duration
+
+	// bound is true when requests in flight or their bandwidth are limited,
+	// or requests carry request IDs, in which case requests are bound to the
+	// context of their operation
+	bound bool
+
+	// requestIDs is true when each operation gets a request ID, sent with
+	// the requests it makes so the server's logs can be correlated with it
+	requestIDs bool
+
+	// locks is false when the server doesn't support advisory locks, in
+	// which case the kernel falls back to locking within this mount
+	locks bool
+
+	// clones is false when the server can't clone files, in which case the
+	// kernel copies them through read and write
+	clones bool
+
+	// copyRanges is false when the server can't copy file ranges, in which
+	// case copy_file_range reads and writes them through the handles
+	copyRanges bool
+
+	// tempCacheDir is the directory of the disk caches, removed by Close,
+	// when Config.CacheDir didn't name one ("" = none)
+	tempCacheDir string
+
+	// readdirBatch is the page size of streamed listings (0 = listings are
+	// fetched whole)
+	readdirBatch int
+
+	// prefetch caches the attributes of the children of a listed directory
+	// from the listing
+	prefetch bool
+
+	// Background stats of the children of a listed directory (nil
+	// prefetchSem = disabled)
+	prefetchSem    chan struct{}
+	prefetchCtx    context.Context
+	prefetchCancel context.CancelFunc
+
+	// stopWatch stops watchChanges (nil = not subscribed to changes)
+	stopWatch  context.CancelFunc
+	subscribed atomic.Bool // The subscription to changes is up
+	mounted    atomic.Bool // The kernel serves the filesystem, see Mounted
+
+	// Servers of a federated root, sorted by path (nil = this root serves a
+	// single server). A federated root has no client of its own.
+	mounts []serverMount
 }
 
 // Config contains filesystem configuration
@@ -30,37 +100,619 @@ type Config struct {
 	ServerURL string
 	CacheTTL  time.Duration
 	Debug     bool
+	UID       *uint32 // Owner reported for every file (nil = current user)
+	GID       *uint32 // Group reported for every file (nil = current group)
+	Umask     uint32  // Permission bits cleared from every reported mode
+
+	// Token authenticates requests to the servers as a bearer token, which
+	// a server requiring one serves in the mount namespace of the token's
+	// principal (empty = none)
+	Token string
+
+	// AdaptiveCache caches the attributes of each path for a TTL picked from
+	// how often the path was seen changing, instead of CacheTTL: starting
+	// at CacheTTL, it doubles every time the path is fetched again unchanged
+	// and halves every time it changed, within [CacheTTLMin, CacheTTLMax]
+	// (default CacheTTL/10 and 10*CacheTTL). The history of the most
+	// recently fetched cache.DefaultAdaptivePaths paths is kept.
+	AdaptiveCache bool
+	CacheTTLMin   time.Duration
+	CacheTTLMax   time.Duration
+
+	// AttrTimeout is how long the kernel keeps the attributes of a file, and
+	// EntryTimeout how long it keeps the file a name refers to, before
+	// asking again (0 = CacheTTL, or the path's TTL with AdaptiveCache for
+	// attributes), so names in mostly static trees can be kept longer than
+	// attributes. NegativeTimeout is how long the kernel remembers that a
+	// name doesn't exist (0 = not at all, so files created by other clients
+	// show up at once). See KernelTimeouts.
+	AttrTimeout     time.Duration
+	EntryTimeout    time.Duration
+	NegativeTimeout time.Duration
+
+	// Servers mounts several AGFS servers into one tree, each subtree served
+	// by its own client, handles and caches with this configuration
+	// (nil = serve ServerURL at the root). ServerURL is ignored when set.
+	// See ParseServerMounts for the rules on paths.
+	Servers []ServerMount
+
+	// Logger receives the filesystem's log output; its level decides which
+	// debug messages are emitted (nil = logrus standard logger)
+	Logger *log.Logger
+
+	// Tracer records a root span per FUSE operation and a child span per
+	// server request (nil = tracing disabled)
+	Tracer trace.Tracer
+
+	// PrefetchConcurrency is the maximum number of concurrent Stat calls used
+	// to prefetch the children of a directory after it is listed (0 = disabled)
+	PrefetchConcurrency int
+
+	// PrefetchAttrs caches the attributes of the children of a directory
+	// from its listing instead, so the lookups that usually follow don't
+	// ask the server for them at all. It takes precedence over
+	// PrefetchConcurrency.
+	PrefetchAttrs bool
+
+	// ReaddirBatchSize streams directory listings to the kernel in pages of
+	// that many entries as they arrive from the server, instead of holding
+	// the whole listing in memory first (0 = disabled). A listing that fits
+	// in one page is cached and prefetched as usual; longer ones are not.
+	// Entries added or removed while a directory is listed may be missed,
+	// but the others are listed once.
+	ReaddirBatchSize int
+
+	// BlockCacheSize bounds the bytes of file data cached in BlockSize blocks
+	// and shared by all remote handles, so repeated reads of a region are
+	// served locally (0 = disabled). Only files reporting a non-zero size are
+	// cached. Blocks expire after CacheTTL and are dropped when the file is
+	// written, truncated, renamed or removed through this mount. For files
+	// whose plugin reports an etag, expired attributes and blocks are
+	// revalidated with the server and kept while the etag is unchanged.
+	// BlockSize defaults to 128KB.
+	BlockCacheSize int64
+	BlockSize      int
+
+	// CacheBackend is where the block cache and the directory listings
+	// cache keep their data: in memory (the default), on disk in CacheDir,
+	// so a large block cache doesn't take memory away from applications,
+	// or nowhere, disabling both caches. A disk cache starts empty and its
+	// files are removed at unmount; a file found corrupted is a miss.
+	// CacheDir defaults to a new temporary directory, and a federated root
+	// gives each server a numbered subdirectory of it. DirCacheSize bounds
+	// the bytes of cached listings (default cache.DefaultDirCacheSize).
+	CacheBackend cache.BackendKind
+	CacheDir     string
+	DirCacheSize int64
+
+	// ReadaheadSize bounds how far a remote handle reads ahead when a file
+	// is read sequentially in small pieces, so each piece isn't a round
+	// trip (0 = disabled). The window starts at 64KB, doubles while reads
+	// stay sequential and is reset by a seek. Like the block cache, which
+	// replaces it for the handles it serves, it only applies to files
+	// reporting a non-zero size. IOPattern decides whether handles whose
+	// recent reads mostly seek, as a database's do, read ahead: not while
+	// that lasts (IOPatternAuto, the default), always, or never.
+	ReadaheadSize int
+	IOPattern     IOPattern
+
+	// DirectIO opens every file as a direct handle, as opening it with
+	// O_DIRECT does: reads always go to the server with ranged requests,
+	// bypassing readahead, the block cache and streaming, and writes go
+	// straight through. This trades throughput for reads that always see
+	// the server's data, for applications such as databases that cache
+	// data themselves.
+	DirectIO bool
+
+	// WriteCommitWindow holds the writes of a remote handle for up to that
+	// long before writing them, merging writes to adjacent or overlapping
+	// ranges, so many small writes reach the server as a few large ones
+	// (0 = every write goes straight to the server). A handle holding
+	// WriteCommitSize bytes (default 4MB) is written at once, and so is one
+	// that is synced, flushed by closing a descriptor, closed, read, or
+	// whose file is stat'ed or truncated through this mount. Other clients
+	// see the writes up to the window late. A failed write is reported by
+	// the handle's next write, fsync or close. Direct, O_SYNC and O_APPEND
+	// handles, and files served without server-side handles, write through.
+	WriteCommitWindow time.Duration
+	WriteCommitSize   int
+
+	// StalePolicy decides what reads of a handle serving cached data, from
+	// the block cache, readahead or a local handle's buffer, do once the
+	// file's etag shows it changed on the server since the data was cached,
+	// as when another mount wrote it: read the file again (the default), or
+	// fail with ESTALE until it is reopened. Files without an etag are
+	// never detected as changed, and changes made through this mount don't
+	// count.
+	StalePolicy StalePolicy
+
+	// Subscribe subscribes to the changes made on the server, including by
+	// other clients, and drops what is cached of a changed path as soon as
+	// the server reports it, rather than when the cache TTL expires, so a
+	// long TTL doesn't serve stale data. Servers that don't report changes
+	// fall back to the TTL, as does a lost subscription until it is
+	// restored.
+	Subscribe bool
+
+	// BreakerThreshold is the number of consecutive server failures after which
+	// requests fail fast with EIO for BreakerCoolDown (0 = disabled)
+	BreakerThreshold int
+	BreakerCoolDown  time.Duration
+
+	// MaxInflight is the number of requests sent to the server at once;
+	// more wait for one to complete, so a burst of operations such as a
+	// find or rsync doesn't overwhelm the server (0 = unlimited). Streams
+	// aren't counted.
+	MaxInflight int
+
+	// RequestIDs gives each FUSE operation a request ID, logged at debug
+	// level and sent in the X-Request-ID header of the requests it makes so
+	// they can be found in the server's logs
+	RequestIDs bool
+
+	// StreamWindow bounds the bytes a streaming handle reads ahead of the
+	// application (default 1MB). Once that much is buffered the stream is
+	// not read until the application catches up, so a plugin that produces
+	// data continuously is throttled by TCP flow control instead of being
+	// buffered in memory. StreamReadTimeout is how long a read waits for a
+	// stream to produce data before returning EOF (default 5s), and
+	// StreamFirstReadTimeout how long it waits while the stream hasn't
+	// produced anything yet (default 1s, at most StreamReadTimeout). Reads
+	// of files the plugin reports as live streams never return EOF on a
+	// timeout: they wait again until data arrives, the stream ends or the
+	// read is interrupted.
+	StreamWindow           int
+	StreamReadTimeout      time.Duration
+	StreamFirstReadTimeout time.Duration
+
+	// MaxOpenHandles bounds the handles open at once, including opens in
+	// progress (0 = unlimited). When it is reached, HandleLimitPolicy decides
+	// whether Open fails with EMFILE, waits up to HandleLimitWait (default
+	// 1s) for a handle to be closed, or evicts the least recently used one.
+	MaxOpenHandles    int
+	HandleLimitPolicy HandleLimitPolicy
+	HandleLimitWait   time.Duration
+
+	// Bandwidth bounds the bytes per second the mount sends to and receives
+	// from the server, across all servers of a federated mount, and
+	// HandleBandwidth the bytes per second each handle reads and writes
+	// (0 = unlimited). Operations wait for their data to pass; an
+	// interrupted one gives back what it was waiting for.
+	Bandwidth       int64
+	HandleBandwidth int64
+
+	// bandwidth is the limiter of Bandwidth, shared by the servers of a
+	// federated mount
+	bandwidth *agfs.BandwidthLimiter
+}
+
+// defaultBlockSize is the block cache block size when Config.BlockSize is unset
+const defaultBlockSize = 128 * 1024
+
+// cacheBackends creates the backends of the caches of a filesystem
+type cacheBackends struct {
+	kind    cache.BackendKind
+	dir     string
+	tempDir string // dir, when it was created for the filesystem
+	logger  *log.Logger
+}
+
+// newCacheBackends prepares the backends config asks for, creating a
+// temporary directory for disk caches if it names none. Caches fall back to
+// memory when the directory can't be created.
+func newCacheBackends(config Config) *cacheBackends {
+	b := &cacheBackends{kind: config.CacheBackend, dir: config.CacheDir, logger: config.Logger}
+	if b.kind == cache.BackendDisk && b.dir == "" {
+		dir, err := os.MkdirTemp("", "agfs-fuse-cache-")
+		if err != nil {
+			b.logger.Warnf("Failed to create a cache directory, caching in memory: %v", err)
+			b.kind = cache.BackendMemory
+		}
+		b.dir, b.tempDir = dir, dir
+	}
+	return b
+}
+
+// new creates the backend of the cache called name, holding at most
+// maxBytes
+func (b *cacheBackends) new(name string, maxBytes int64) cache.Backend {
+	backend, err := cache.NewBackend(b.kind, filepath.Join(b.dir, name), maxBytes)
+	if err != nil {
+		b.logger.Warnf("Failed to create the %s cache in %s, caching in memory: %v", name, b.dir, err)
+		return cache.NewMemoryBackend(maxBytes)
+	}
+	return backend
 }
 
 // NewAGFSFS creates a new AGFS FUSE filesystem
 func NewAGFSFS(config Config) *AGFSFS {
+	if config.Logger == nil {
+		config.Logger = log.StandardLogger()
+	}
+	if len(config.Servers) > 0 {
+		return newFederatedFS(config)
+	}
+
 	// Use longer timeout for FUSE operations (streams may block)
 	httpClient := &http.Client{
 		Timeout: 60 * time.Second,
 	}
-	client := agfs.NewClientWithHTTPClient(config.ServerURL, httpClient)
+	clientOpts := []agfs.ClientOption{agfs.WithToken(config.Token)}
+	if config.Tracer != nil {
+		clientOpts = append(clientOpts, agfs.WithTracer(config.Tracer))
+	}
+	client := agfs.NewClientWithHTTPClient(config.ServerURL, httpClient, clientOpts...)
+	if config.BreakerThreshold > 0 {
+		client.EnableCircuitBreaker(agfs.BreakerConfig{
+			FailureThreshold: config.BreakerThreshold,
+			CoolDown:         config.BreakerCoolDown,
+		})
+	}
+	if config.MaxInflight > 0 {
+		client.EnableInflightLimit(agfs.InflightConfig{Max: config.MaxInflight})
+	}
+	if config.bandwidth == nil {
+		config.bandwidth = agfs.NewBandwidthLimiter(config.Bandwidth)
+	}
+	if config.bandwidth != nil {
+		client.EnableBandwidthLimit(config.bandwidth)
+	}
+	logger := config.Logger
+	handles := NewHandleManager(client)
+	handles.bound = config.Tracer != nil || config.MaxInflight > 0 || config.RequestIDs || config.Bandwidth > 0
+	handles.handleBandwidth = config.HandleBandwidth
+	handles.logger = logger
+	if config.StreamWindow > 0 {
+		handles.streamWindow = int64(config.StreamWindow)
+	}
+	if config.StreamReadTimeout > 0 {
+		handles.streamTimeout = config.StreamReadTimeout
+	}
+	if config.StreamFirstReadTimeout > 0 {
+		handles.streamFirstTimeout = config.StreamFirstReadTimeout
+	}
+	handles.maxHandles = config.MaxOpenHandles
+	handles.limitPolicy = config.HandleLimitPolicy
+	if config.HandleLimitWait > 0 {
+		handles.limitWait = config.HandleLimitWait
+	}
+	handles.readaheadSize = config.ReadaheadSize
+	handles.ioPattern = config.IOPattern
+	handles.direct = config.DirectIO
+	handles.commitWindow = config.WriteCommitWindow
+	handles.commitSize = config.WriteCommitSize
+	if handles.commitSize <= 0 {
+		handles.commitSize = defaultWriteCommitSize
+	}
+	handles.stalePolicy = config.StalePolicy
+	backends := newCacheBackends(config)
+	if config.BlockCacheSize > 0 && backends.kind != cache.BackendNone {
+		blockSize := config.BlockSize
+		if blockSize <= 0 {
+			blockSize = defaultBlockSize
+		}
+		handles.blocks = cache.NewBlockCacheWithBackend(blockSize, backends.new("blocks", config.BlockCacheSize), config.CacheTTL)
+	}
+	dirCacheSize := config.DirCacheSize
+	if dirCacheSize <= 0 {
+		dirCacheSize = cache.DefaultDirCacheSize
+	}
 
-	return &AGFSFS{
-		client:    client,
-		handles:   NewHandleManager(client),
-		metaCache: cache.NewMetadataCache(config.CacheTTL),
-		dirCache:  cache.NewDirectoryCache(config.CacheTTL),
-		cacheTTL:  config.CacheTTL,
+	// One-time capability handshake so handles don't probe per file
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	info, err := client.ServerInfo(ctx)
+	cancel()
+	locks, clones, copyRanges := true, true, true
+	if err != nil {
+		logger.Warnf("Capability handshake with %s failed, probing features per file: %v", config.ServerURL, err)
+	} else {
+		logger.Infof("AGFS server version %s, features: %v", info.Version, sortedFeatures(info))
+		checkServerVersion(logger, info)
+		handles.configure(info)
+		locks = info.Supports(agfs.FeatureLocks)
+		clones = info.Supports(agfs.FeatureClone)
+		copyRanges = info.Supports(agfs.FeatureCopyRange)
 	}
+
+	uid, gid := configOwner(config)
+	root := &AGFSFS{
+		client:     client,
+		handles:    handles,
+		metaCache:  newMetadataCache(config),
+		dirCache:   cache.NewDirectoryCacheWithBackend(config.CacheTTL, backends.new("dirs", dirCacheSize)),
+		uid:        uid,
+		gid:        gid,
+		umask:      config.Umask & 0777,
+		inoSeed:    config.ServerURL,
+		tracer:     config.Tracer,
+		bound:      config.MaxInflight > 0 || config.RequestIDs || config.Bandwidth > 0,
+		requestIDs: config.RequestIDs,
+		logger:     logger,
+		locks:      locks,
+		clones:     clones,
+
+		copyRanges:   copyRanges,
+		tempCacheDir: backends.tempDir,
+		readdirBatch: config.ReaddirBatchSize,
+		attrTimeout:  config.AttrTimeout,
+	}
+
+	root.prefetch = config.PrefetchAttrs
+	root.prefetchCtx, root.prefetchCancel = context.WithCancel(context.Background())
+	if config.PrefetchConcurrency > 0 {
+		root.prefetchSem = make(chan struct{}, config.PrefetchConcurrency)
+	}
+	handles.stat = root.statCached
+	handles.etag = root.fileETag
+	handles.written = root.metaCache.Invalidate
+
+	if config.Subscribe {
+		if info != nil && info.Known() && !info.Supports(agfs.FeatureEvents) {
+			logger.Infof("AGFS server doesn't report changes, caches expire after the cache TTL")
+		} else {
+			var watchCtx context.Context
+			watchCtx, root.stopWatch = context.WithCancel(context.Background())
+			go root.watchChanges(watchCtx)
+		}
+	}
+
+	return root
+}
+
+// KernelTimeouts returns the attribute, entry and negative entry timeouts
+// to mount with, the unset attribute and entry timeouts being CacheTTL
+func (c Config) KernelTimeouts() (attr, entry, negative time.Duration) {
+	attr, entry = c.AttrTimeout, c.EntryTimeout
+	if attr <= 0 {
+		attr = c.CacheTTL
+	}
+	if entry <= 0 {
+		entry = c.CacheTTL
+	}
+	return attr, entry, c.NegativeTimeout
+}
+
+// newMetadataCache returns the metadata cache config asks for
+func newMetadataCache(config Config) *cache.MetadataCache {
+	if !config.AdaptiveCache {
+		return cache.NewMetadataCache(config.CacheTTL)
+	}
+	minTTL, maxTTL := config.CacheTTLMin, config.CacheTTLMax
+	if minTTL <= 0 {
+		minTTL = config.CacheTTL / 10
+	}
+	if maxTTL <= 0 {
+		maxTTL = 10 * config.CacheTTL
+	}
+	return cache.NewAdaptiveMetadataCache(cache.NewAdaptiveTTL(config.CacheTTL, minTTL, maxTTL, 0))
+}
+
+// configOwner returns the owner reported for every file
+func configOwner(config Config) (uid, gid uint32) {
+	uid = uint32(syscall.Getuid())
+	if config.UID != nil {
+		uid = *config.UID
+	}
+	gid = uint32(syscall.Getgid())
+	if config.GID != nil {
+		gid = *config.GID
+	}
+	return uid, gid
+}
+
+// attrTimeoutOf returns how long the kernel may keep the attributes of path
+func (root *AGFSFS) attrTimeoutOf(path string) time.Duration {
+	if root.attrTimeout > 0 {
+		return root.attrTimeout
+	}
+	return root.metaCache.TTL(path)
+}
+
+// statCached returns the attributes of path from the metadata cache, asking
+// the server and caching the answer on a miss
+func (root *AGFSFS) statCached(ctx context.Context, path string) (*agfs.FileInfo, error) {
+	if info, ok := root.metaCache.Get(path); ok {
+		return info, nil
+	}
+	if stale, ok := root.metaCache.GetStale(path); ok && stale.ETag() != "" {
+		return root.revalidate(ctx, path, stale)
+	}
+	info, err := root.clientFor(ctx).Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	root.metaCache.Set(path, info)
+	return info, nil
+}
+
+// revalidate asks the server whether path still has the etag of its expired
+// cached attributes, keeping them if so instead of fetching them again
+func (root *AGFSFS) revalidate(ctx context.Context, path string, stale *agfs.FileInfo) (*agfs.FileInfo, error) {
+	info, err := root.clientFor(ctx).StatIfChanged(path, stale.ETag())
+	if errors.Is(err, agfs.ErrNotModified) {
+		root.metaCache.Refresh(path)
+		return stale, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	root.metaCache.Set(path, info)
+	return info, nil
+}
+
+// fileETag returns the etag of path for validating its cached blocks, or ""
+// if it is unknown. It only asks the server to revalidate expired
+// attributes that have an etag, so reads of files without one don't cost
+// an extra request.
+func (root *AGFSFS) fileETag(ctx context.Context, path string) string {
+	if info, ok := root.metaCache.Get(path); ok {
+		return info.ETag()
+	}
+	stale, ok := root.metaCache.GetStale(path)
+	if !ok || stale.ETag() == "" {
+		return ""
+	}
+	info, err := root.revalidate(ctx, path, stale)
+	if err != nil {
+		return ""
+	}
+	return info.ETag()
+}
+
+// sortedFeatures returns the advertised server features in a stable order
+func sortedFeatures(info *agfs.ServerInfo) []string {
+	features := make([]string, 0, len(info.Features))
+	for f, ok := range info.Features {
+		if ok {
+			features = append(features, f)
+		}
+	}
+	sort.Strings(features)
+	return features
+}
+
+// checkServerVersion warns when the server and this client are likely skewed
+func checkServerVersion(logger *log.Logger, info *agfs.ServerInfo) {
+	if !info.Known() {
+		logger.Warnf("AGFS server does not report capabilities (version %s); it may be older than this client", info.Version)
+		return
+	}
+
+	clientMajor, ok1 := majorVersion(version.GetVersion())
+	serverMajor, ok2 := majorVersion(info.Version)
+	if ok1 && ok2 && clientMajor != serverMajor {
+		logger.Warnf("Version mismatch: agfs-fuse %s, AGFS server %s", version.GetVersion(), info.Version)
+	}
+}
+
+// majorVersion extracts the major component of a "vX.Y.Z" or "X.Y.Z" version
+func majorVersion(v string) (int, bool) {
+	v = strings.TrimPrefix(v, "v")
+	major, _, _ := strings.Cut(v, ".")
+	n, err := strconv.Atoi(major)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
 }
 
 // Close closes the filesystem and releases resources
 func (root *AGFSFS) Close() error {
+	if root.mounts != nil {
+		var firstErr error
+		for _, m := range root.mounts {
+			if err := m.fs.Close(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+
+	if root.stopWatch != nil {
+		root.stopWatch()
+	}
+
+	// Stop any in-flight prefetching
+	root.prefetchCancel()
+
 	// Close all open handles
 	if err := root.handles.CloseAll(); err != nil {
 		return err
 	}
 
-	// Clear caches
+	// Release the locks still held, rather than leaving them to expire
+	if root.locks {
+		if err := root.client.ReleaseLocks(); err != nil {
+			root.logger.Debugf("Failed to release locks: %v", err)
+		}
+	}
+
+	// Clear caches, removing the files of disk caches
 	root.metaCache.Clear()
-	root.dirCache.Clear()
+	err := root.dirCache.Close()
+	if root.handles.blocks != nil {
+		if blocksErr := root.handles.blocks.Close(); err == nil {
+			err = blocksErr
+		}
+	}
+	if root.tempCacheDir != "" {
+		if removeErr := os.RemoveAll(root.tempCacheDir); err == nil {
+			err = removeErr
+		}
+	}
+	return err
+}
+
+// Stats is a snapshot of the state of a mounted filesystem
+type Stats struct {
+	Handles    HandleStats  `json:"handles"`
+	MetaCache  cache.Stats  `json:"meta_cache"`
+	DirCache   cache.Stats  `json:"dir_cache"`
+	BlockCache *cache.Stats `json:"block_cache,omitempty"`
+	Breaker    string       `json:"breaker"`
+	InFlight   int          `json:"in_flight"`  // Requests sent to the server and not yet answered, if limited
+	Subscribed bool         `json:"subscribed"` // Caches are dropped as the server reports changes
+	// Mounts is the state of each server of a federated root by mount
+	// path; the other fields of a federated root are then empty
+	Mounts map[string]Stats `json:"mounts,omitempty"`
+}
+
+// Stats returns the current handle, cache, circuit breaker and in-flight
+// request state
+func (root *AGFSFS) Stats() Stats {
+	if root.mounts != nil {
+		stats := Stats{Mounts: make(map[string]Stats, len(root.mounts))}
+		for _, m := range root.mounts {
+			stats.Mounts[m.path] = m.fs.Stats()
+		}
+		return stats
+	}
 
-	return nil
+	stats := Stats{
+		Handles:    root.handles.Stats(),
+		MetaCache:  root.metaCache.Stats(),
+		DirCache:   root.dirCache.Stats(),
+		Breaker:    root.client.BreakerState().String(),
+		InFlight:   root.client.InFlight(),
+		Subscribed: root.subscribed.Load(),
+	}
+	if root.handles.blocks != nil {
+		blocks := root.handles.blocks.Stats()
+		stats.BlockCache = &blocks
+	}
+	return stats
+}
+
+// OpenHandles lists the open file handles. Handles of a federated root are
+// listed by server, with paths in the FUSE tree.
+func (root *AGFSFS) OpenHandles() []HandleStatus {
+	if root.mounts != nil {
+		var list []HandleStatus
+		for _, m := range root.mounts {
+			for _, h := range m.fs.OpenHandles() {
+				h.Path = filepath.Join(m.path, h.Path)
+				list = append(list, h)
+			}
+		}
+		return list
+	}
+	return root.handles.List()
+}
+
+// FlushCaches drops all cached metadata, directory listings and file blocks,
+// so the next lookups and reads go to the server
+func (root *AGFSFS) FlushCaches() {
+	for _, m := range root.mounts {
+		m.fs.FlushCaches()
+	}
+	if root.mounts != nil {
+		return
+	}
+	root.metaCache.Clear()
+	root.dirCache.Clear()
+	if root.handles.blocks != nil {
+		root.handles.blocks.Clear()
+	}
 }
 
 // Statfs returns filesystem statistics
@@ -81,6 +733,7 @@ func (root *AGFSFS) Statfs(ctx context.Context, out *fuse.StatfsOut) syscall.Err
 // invalidateCache invalidates cache for a path and its parent directory
 func (root *AGFSFS) invalidateCache(path string) {
 	root.metaCache.Invalidate(path)
+	root.handles.InvalidateBlocks(path)
 
 	// Invalidate parent directory listing
 	parent := getParentPath(path)
@@ -89,6 +742,82 @@ func (root *AGFSFS) invalidateCache(path string) {
 	}
 }
 
+// prefetchChildren caches the attributes of the children of a freshly
+// listed directory so the per-entry lookups that usually follow hit the
+// cache: from the listing, fetched at epoch of the metadata cache, with
+// PrefetchAttrs, or else by statting them in the background. Children
+// already cached are left alone, and nothing is cached if the cache was
+// invalidated while the directory was listed.
+func (root *AGFSFS) prefetchChildren(dirPath string, files []agfs.FileInfo, epoch uint64) {
+	if !root.prefetch {
+		if root.prefetchSem != nil && len(files) > 0 {
+			go root.statChildren(dirPath, files)
+		}
+		return
+	}
+	for i := range files {
+		childPath := filepath.Join(dirPath, files[i].Name)
+		if _, ok := root.metaCache.Get(childPath); ok {
+			continue
+		}
+		info := files[i]
+		if !root.metaCache.SetAt(childPath, &info, epoch) {
+			return
+		}
+	}
+}
+
+// statChildren stats the children of dirPath that aren't cached yet. The
+// semaphore is shared by all directories to cap load on the server, and
+// the stats are cancelled when the filesystem is closed. Failed stats
+// aren't cached, the real lookup will retry, and a stat that raced an
+// invalidation isn't either.
+func (root *AGFSFS) statChildren(dirPath string, files []agfs.FileInfo) {
+	client := root.client.WithContext(root.prefetchCtx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for _, f := range files {
+		childPath := filepath.Join(dirPath, f.Name)
+		if _, ok := root.metaCache.Get(childPath); ok {
+			continue
+		}
+
+		select {
+		case root.prefetchSem <- struct{}{}:
+		case <-root.prefetchCtx.Done():
+			return
+		}
+
+		wg.Add(1)
+		go func(childPath string) {
+			defer wg.Done()
+			defer func() { <-root.prefetchSem }()
+
+			epoch := root.metaCache.Epoch()
+			info, err := client.Stat(childPath)
+			if err != nil {
+				return
+			}
+			root.metaCache.SetAt(childPath, info, epoch)
+		}(childPath)
+	}
+}
+
+// statErrno maps a Stat failure to an errno. Plugins don't always report a
+// missing file as not found, so failures ToErrno can't classify are ENOENT
+// rather than EIO, except while the circuit breaker is open: then the server
+// is unreachable, not the file missing.
+func statErrno(err error) syscall.Errno {
+	if errors.Is(err, agfs.ErrCircuitOpen) {
+		return syscall.EIO
+	}
+	if errno := ToErrno(err); errno != syscall.EIO {
+		return errno
+	}
+	return syscall.ENOENT
+}
+
 // getParentPath returns the parent directory path
 func getParentPath(path string) string {
 	if path == "" || path == "/" {
@@ -107,14 +836,21 @@ func getParentPath(path string) string {
 	return "/"
 }
 
-// modeToFileMode converts AGFS mode to os.FileMode
+// modeToFileMode converts an AGFS mode to the permission bits of a FUSE
+// mode, keeping the setuid, setgid and sticky bits
 func modeToFileMode(mode uint32) uint32 {
-	return mode
+	return mode & 07777
 }
 
-// fileModeToMode converts os.FileMode to AGFS mode
+// fileModeToMode converts a FUSE mode to an AGFS mode, dropping the file
+// type bits the kernel includes on create
 func fileModeToMode(mode uint32) uint32 {
-	return mode
+	return mode & 07777
+}
+
+// maskMode clears the configured umask bits from a mode
+func (root *AGFSFS) maskMode(mode uint32) uint32 {
+	return mode &^ root.umask
 }
 
 // getStableMode returns mode with file type bits for StableAttr
@@ -130,6 +866,39 @@ func getStableMode(info *agfs.FileInfo) uint32 {
 	return mode
 }
 
+// ino returns the inode number of the file at path. Files whose plugin
+// reports a stable identifier keep their inode across renames and share it
+// with their hard links; other files get a hash of their path, so a path has
+// the same inode on every lookup. Both are mixed with the server so servers
+// of a federated tree don't collide, and kept between the root's inode (1)
+// and those go-fuse assigns itself (from 1<<63).
+func (root *AGFSFS) ino(path string, info *agfs.FileInfo) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(root.inoSeed))
+	if info.Ino != 0 {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], info.Ino)
+		h.Write([]byte{0})
+		h.Write(b[:])
+	} else {
+		h.Write([]byte{1})
+		h.Write([]byte(path))
+	}
+	ino := h.Sum64() &^ (1 << 63)
+	if ino < 2 {
+		ino += 2
+	}
+	return ino
+}
+
+// stableAttr returns the attributes identifying the file at path to go-fuse
+func (root *AGFSFS) stableAttr(path string, info *agfs.FileInfo) fs.StableAttr {
+	return fs.StableAttr{
+		Mode: getStableMode(info),
+		Ino:  root.ino(path, info),
+	}
+}
+
 // Interface assertions for root node
 var _ = (fs.NodeGetattrer)((*AGFSFS)(nil))
 var _ = (fs.NodeLookuper)((*AGFSFS)(nil))
@@ -138,36 +907,31 @@ var _ = (fs.NodeReaddirer)((*AGFSFS)(nil))
 // Getattr returns attributes for the root directory
 func (root *AGFSFS) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
 	// Root is always a directory
-	out.Mode = 0755 | syscall.S_IFDIR
+	out.Mode = root.maskMode(0755) | syscall.S_IFDIR
 	out.Size = 4096
+	out.Uid = root.uid
+	out.Gid = root.gid
 	return 0
 }
 
 // Lookup looks up a child node in the root directory
 func (root *AGFSFS) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
+	if root.mounts != nil {
+		return lookupMount(ctx, &root.Inode, name, out)
+	}
 	childPath := "/" + name
+	ctx, span := root.startSpan(ctx, "Lookup", childPath)
+	defer span.End()
 
-	// Try cache first
-	var info *agfs.FileInfo
-	if cached, ok := root.metaCache.Get(childPath); ok {
-		info = cached
-	} else {
-		// Fetch from server
-		var err error
-		info, err = root.client.Stat(childPath)
-		if err != nil {
-			return nil, syscall.ENOENT
-		}
-		// Cache the result
-		root.metaCache.Set(childPath, info)
+	info, err := root.statCached(ctx, childPath)
+	if err != nil {
+		return nil, statErrno(err)
 	}
 
-	fillAttr(&out.Attr, info)
+	root.fillAttr(&out.Attr, info)
 
 	// Create child node
-	stable := fs.StableAttr{
-		Mode: getStableMode(info),
-	}
+	stable := root.stableAttr(childPath, info)
 
 	child := &AGFSNode{
 		root: root,
@@ -179,32 +943,10 @@ func (root *AGFSFS) Lookup(ctx context.Context, name string, out *fuse.EntryOut)
 
 // Readdir reads root directory contents
 func (root *AGFSFS) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
-	rootPath := "/"
-
-	// Try cache first
-	var files []agfs.FileInfo
-	if cached, ok := root.dirCache.Get(rootPath); ok {
-		files = cached
-	} else {
-		// Fetch from server
-		var err error
-		files, err = root.client.ReadDir(rootPath)
-		if err != nil {
-			return nil, syscall.EIO
-		}
-		// Cache the result
-		root.dirCache.Set(rootPath, files)
+	if root.mounts != nil {
+		return readMountDir(&root.Inode), 0
 	}
-
-	// Convert to FUSE entries
-	entries := make([]fuse.DirEntry, 0, len(files))
-	for _, f := range files {
-		entry := fuse.DirEntry{
-			Name: f.Name,
-			Mode: getStableMode(&f),
-		}
-		entries = append(entries, entry)
-	}
-
-	return fs.NewListDirStream(entries), 0
+	ctx, span := root.startSpan(ctx, "Readdir", "/")
+	defer span.End()
+	return root.readDir(ctx, "/")
 }
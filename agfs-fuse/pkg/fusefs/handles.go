@@ -5,11 +5,16 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/rand"
+	"net/http"
+	"sort"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
 	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/dongxuny/agfs-fuse/pkg/cache"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -22,7 +27,30 @@ const (
 	handleTypeLocal                          // Server doesn't support HandleFS, use local wrapper
 )
 
+func (t handleType) String() string {
+	switch t {
+	case handleTypeRemote:
+		return "remote"
+	case handleTypeRemoteStream:
+		return "stream"
+	case handleTypeLocal:
+		return "local"
+	default:
+		return "unknown"
+	}
+}
+
 // handleInfo stores information about an open handle
+//
+// Locking: htype, agfsHandle, path, flags, mode, direct, cacheBlocks and the
+// stream's context are set before the handle is published in
+// HandleManager.handles and never change, so an operation holding a
+// reference from acquire may read them without hm.mu. Every other field is
+// guarded by hm.mu. An operation that releases hm.mu and takes it again
+// must check closing before installing anything in the handle, since it may
+// have been closed or evicted meanwhile. closeResources runs once no
+// operation holds a reference, and still takes hm.mu to clear the buffers
+// the stream pump may have touched.
 type handleInfo struct {
 	htype      handleType
 	agfsHandle int64 // For remote handles: server-side handle ID
@@ -31,14 +59,113 @@ type handleInfo struct {
 	mode       uint32
 	// Read buffer for local handles - caches first read to avoid multiple server requests
 	readBuffer []byte
+	// readFill is closed once the first read in flight is done, so reads
+	// racing it wait instead of fetching (and consuming) the file again.
+	// readGen counts the drops of readBuffer, so a first read doesn't
+	// install data fetched before its file was truncated.
+	readFill chan struct{}
+	readGen  uint64
 	// Stream reader for streaming handles
 	streamReader io.ReadCloser
+	// The plugin reports the file as a live stream (agfs.AccessStream), so
+	// a read finding no data in time is ErrStreamPending rather than EOF
+	streamLive bool
 	// Buffer for stream reads (sliding window to prevent memory leak)
 	streamBuffer []byte
 	streamBase   int64 // Base offset of streamBuffer[0] in the logical stream
+	streamEOF    bool  // Stream has ended, streamBuffer holds everything left
+	streamErr    error // Stream failed, streamBuffer holds everything received
+	// The file was truncated after the stream started, so what it streams
+	// is stale: reads are ranged reads of the handle instead
+	streamStale bool
+	// Offset up to which the stream has been read (or skipped) by the caller.
+	// The pump stops reading once streamWindow bytes past it are buffered.
+	streamConsumed int64
+	// streamData is closed and replaced whenever the pump appends data or the
+	// stream ends; streamSpace wakes the pump when the caller consumed data
+	streamData  chan struct{}
+	streamSpace chan struct{}
+	// Reads go through the shared block cache
+	cacheBlocks bool
+	// The file is mapped into memory: reads fetch whole pages, see SetMmap
+	mmap bool
+	// Every read is a ranged read of the server and nothing is buffered or
+	// cached, for applications that cache data themselves
+	direct bool
+	// Sequential read heuristic of remote handles without the block cache
+	ra readahead
+	// Writes held for the commit window
+	wb writeBuffer
+	// Version (etag) of the file the handle's cached data was read at
+	// ("" = unknown), and whether it changed under StaleError
+	etag  string
+	stale bool
 	// Context for cancelling background goroutines
 	streamCtx    context.Context
 	streamCancel context.CancelFunc
+	// In-flight operations and close state, guarded by HandleManager.mu.
+	// idle is closed once a closing handle has no operations left.
+	refs    int
+	closing bool
+	idle    chan struct{}
+	// When an operation last started on the handle, for eviction
+	lastUsed time.Time
+	// Paces the data read and written through the handle (nil = unlimited)
+	bandwidth *agfs.BandwidthLimiter
+}
+
+// errHandleClosing is returned for operations on a handle that is being closed
+var errHandleClosing = errors.New("handle closing")
+
+// ErrStreamPending is returned by Read for a live stream that produced no
+// data at the offset within the stream read timeout but hasn't ended: the
+// caller should read again, as a read(2) of a pipe would keep waiting.
+// It wraps EAGAIN for callers that can't wait.
+var ErrStreamPending = fmt.Errorf("stream has no data yet: %w", syscall.EAGAIN)
+
+// errTooManyHandles is returned by Open when MaxOpenHandles is reached
+var errTooManyHandles = fmt.Errorf("too many open handles: %w", syscall.EMFILE)
+
+// errHandleEvicted is returned for operations on a handle that was evicted
+// to make room for another under MaxOpenHandles
+var errHandleEvicted = fmt.Errorf("handle evicted: %w", syscall.EBADF)
+
+// HandleLimitPolicy decides what Open does when MaxOpenHandles is reached
+type HandleLimitPolicy int
+
+const (
+	// HandleLimitReject fails the open with EMFILE
+	HandleLimitReject HandleLimitPolicy = iota
+	// HandleLimitWait waits for a handle to be closed, failing with EMFILE
+	// if none is closed in time
+	HandleLimitWait
+	// HandleLimitEvict closes the least recently used handle, preferring
+	// handles with no operation in flight. Later operations on the evicted
+	// handle fail with EBADF.
+	HandleLimitEvict
+)
+
+// ParseHandleLimitPolicy parses "reject", "wait" or "evict"
+func ParseHandleLimitPolicy(s string) (HandleLimitPolicy, error) {
+	switch s {
+	case "reject":
+		return HandleLimitReject, nil
+	case "wait":
+		return HandleLimitWait, nil
+	case "evict":
+		return HandleLimitEvict, nil
+	}
+	return 0, fmt.Errorf("unknown handle limit policy %q (reject, wait, evict)", s)
+}
+
+func (p HandleLimitPolicy) String() string {
+	switch p {
+	case HandleLimitWait:
+		return "wait"
+	case HandleLimitEvict:
+		return "evict"
+	}
+	return "reject"
 }
 
 // HandleManager manages the mapping between FUSE handles and AGFS handles
@@ -49,139 +176,611 @@ type HandleManager struct {
 	handles map[uint64]*handleInfo
 	// Counter for generating unique FUSE handle IDs
 	nextHandle uint64
+	// Most capable handle type the server supports, learned from the
+	// capability handshake. handleTypeRemoteStream means "try everything".
+	defaultType handleType
+	// Looks up the file being opened for its access hint, through the
+	// metadata cache when the FS sets it (nil = always ask the server)
+	stat func(ctx context.Context, path string) (*agfs.FileInfo, error)
+	// Returns the file's etag to validate cached blocks with, set by the
+	// FS (nil = blocks expire with the TTL)
+	etag func(ctx context.Context, path string) string
+	// Bind requests to the caller's context, so their spans join its trace
+	// and an interrupted operation stops waiting for an in-flight slot or
+	// bandwidth
+	bound  bool
+	logger *log.Logger
+	// Blocks of remote handle reads shared by all handles (nil = disabled)
+	blocks *cache.BlockCache
+	// Largest window a remote handle reads ahead of sequential reads
+	// (0 = disabled)
+	readaheadSize int
+	// Whether handles read ahead, by the pattern of their reads
+	ioPattern IOPattern
+	// How long, and up to how many bytes, the writes of a remote handle are
+	// held to be merged before they are written (0 = write through)
+	commitWindow time.Duration
+	commitSize   int
+	// Called once held writes of path reached the server, set by the FS to
+	// drop its cached attributes (nil = nothing to drop)
+	written func(path string)
+	// Every handle is opened direct, as OpenDirect does
+	direct bool
+	// What reads of a handle do once its file changed on the server
+	stalePolicy StalePolicy
+	// Block fetches in flight, closed when the fetch is done
+	fetchMu  sync.Mutex
+	fetching map[blockFetchKey]chan struct{}
+	// Bytes a stream may buffer ahead of the reader, and how long a read
+	// waits for a stream to produce data before reporting EOF
+	streamWindow  int64
+	streamTimeout time.Duration
+	// How long the first read waits when the stream hasn't produced any
+	// data yet, so reading an idle stream such as an empty queue doesn't
+	// hang for the full timeout (capped at streamTimeout)
+	streamFirstTimeout time.Duration
+	// How often a stream that failed mid-read is reopened before the
+	// failure reaches the reader, and the delay before the first attempt
+	// (doubling for each further attempt)
+	streamReconnects     int
+	streamReconnectDelay time.Duration
+	// Bytes per second each handle reads and writes (0 = unlimited)
+	handleBandwidth int64
+	// Open handles allowed, counting opens in progress (0 = unlimited), and
+	// what Open does when they are all in use
+	maxHandles  int
+	limitPolicy HandleLimitPolicy
+	limitWait   time.Duration
+	opening     int
+	// Closed and replaced whenever a handle or reservation goes away
+	handleFreed chan struct{}
+	// Evicted handles the kernel hasn't released yet, and their closes
+	evicted  map[uint64]struct{}
+	evicting sync.WaitGroup
+	nEvicted int64
+	rejected int64
+}
+
+// blockFetchKey identifies a block fetch in flight
+type blockFetchKey struct {
+	path  string
+	index int64
 }
 
 // NewHandleManager creates a new handle manager
 func NewHandleManager(client *agfs.Client) *HandleManager {
 	return &HandleManager{
-		client:     client,
-		handles:    make(map[uint64]*handleInfo),
-		nextHandle: 1,
+		client:               client,
+		handles:              make(map[uint64]*handleInfo),
+		nextHandle:           1,
+		defaultType:          handleTypeRemoteStream,
+		logger:               log.StandardLogger(),
+		fetching:             make(map[blockFetchKey]chan struct{}),
+		streamWindow:         defaultStreamWindow,
+		streamTimeout:        defaultStreamReadTimeout,
+		streamFirstTimeout:   defaultStreamFirstReadTimeout,
+		streamReconnects:     defaultStreamReconnects,
+		streamReconnectDelay: defaultStreamReconnectDelay,
+		limitWait:            defaultHandleLimitWait,
+		handleFreed:          make(chan struct{}),
+		evicted:              make(map[uint64]struct{}),
+	}
+}
+
+// defaultHandleLimitWait is how long HandleLimitWait waits for a free handle
+// when Config.HandleLimitWait is unset
+const defaultHandleLimitWait = time.Second
+
+// clientFor returns the client to use for a request made on behalf of ctx
+func (hm *HandleManager) clientFor(ctx context.Context) *agfs.Client {
+	if !hm.bound {
+		return hm.client
+	}
+	return hm.client.WithContext(ctx)
+}
+
+// configure picks the default handle type from the server capabilities so
+// Open doesn't have to probe unsupported features on every file
+func (hm *HandleManager) configure(info *agfs.ServerInfo) {
+	if !info.Known() {
+		// Server didn't advertise features, keep probing per file
+		return
+	}
+
+	switch {
+	case !info.Supports(agfs.FeatureHandles):
+		hm.defaultType = handleTypeLocal
+	case !info.Supports(agfs.FeatureStream):
+		hm.defaultType = handleTypeRemote
+	default:
+		hm.defaultType = handleTypeRemoteStream
 	}
 }
 
 // Open opens a file and returns a FUSE handle ID
 // If the server supports HandleFS, it uses server-side handles
 // Otherwise, it falls back to local handle management
-func (hm *HandleManager) Open(path string, flags agfs.OpenFlag, mode uint32) (uint64, error) {
-	// Try to open handle on server first
-	agfsHandle, err := hm.client.OpenHandle(path, flags, mode)
+func (hm *HandleManager) Open(ctx context.Context, path string, flags agfs.OpenFlag, mode uint32) (uint64, error) {
+	return hm.open(ctx, path, flags, mode, hm.direct)
+}
 
-	// Generate FUSE handle ID
-	fuseHandle := atomic.AddUint64(&hm.nextHandle, 1)
+// OpenDirect opens a file like Open, as a direct handle: reads always go to
+// the server with ranged requests, bypassing the readahead window, the block
+// cache and streaming, and writes go straight through. Files that change
+// with every read, such as queue files, are still read once and buffered, as
+// reading them again would consume more data.
+func (hm *HandleManager) OpenDirect(ctx context.Context, path string, flags agfs.OpenFlag, mode uint32) (uint64, error) {
+	return hm.open(ctx, path, flags, mode, true)
+}
 
-	hm.mu.Lock()
-	defer hm.mu.Unlock()
+// openFor returns the open function for the flags of a FUSE open: OpenDirect
+// for O_DIRECT, Open otherwise
+func (hm *HandleManager) openFor(flags uint32) func(context.Context, string, agfs.OpenFlag, uint32) (uint64, error) {
+	if flags&oDirect != 0 {
+		return hm.OpenDirect
+	}
+	return hm.Open
+}
 
+func (hm *HandleManager) open(ctx context.Context, path string, flags agfs.OpenFlag, mode uint32, direct bool) (uint64, error) {
+	if flags&agfs.OpenFlagTruncate != 0 {
+		defer hm.truncated(path)
+	}
+	if err := hm.reserveHandle(ctx); err != nil {
+		hm.logger.Debugf("Failed to open %s: %v", path, err)
+		return 0, err
+	}
+
+	// Server is known not to support HandleFS, skip the round trip. Files
+	// that change with every read are read once and buffered locally, so
+	// reads at increasing offsets can't consume a message per request.
+	stat := hm.statForOpen(ctx, path, flags)
+	access, etag := "", ""
+	if stat != nil {
+		access, etag = stat.Access(), stat.ETag()
+	}
+	if access == agfs.AccessConsumeOnce {
+		return hm.openLocal(ctx, path, flags, mode, false, "")
+	}
+	if hm.defaultType == handleTypeLocal {
+		return hm.openLocal(ctx, path, flags, mode, direct, etag)
+	}
+
+	// Try to open handle on server first
+	agfsHandle, err := hm.clientFor(ctx).OpenHandle(path, flags, mode)
 	if err != nil {
 		// Check if error is because HandleFS is not supported
 		if errors.Is(err, agfs.ErrNotSupported) {
 			// Fall back to local handle management
-			log.Debugf("HandleFS not supported for %s, using local handle", path)
-			hm.handles[fuseHandle] = &handleInfo{
-				htype: handleTypeLocal,
-				path:  path,
-				flags: flags,
-				mode:  mode,
-			}
-			return fuseHandle, nil
+			hm.logger.Debugf("HandleFS not supported for %s, using local handle", path)
+			return hm.openLocal(ctx, path, flags, mode, direct, etag)
 		}
-		log.Debugf("Failed to open handle for %s: %v", path, err)
+		hm.unreserveHandle()
+		hm.logger.Debugf("Failed to open handle for %s: %v", path, err)
 		return 0, fmt.Errorf("failed to open handle: %w", err)
 	}
 
-	log.Debugf("Opened remote handle for %s (handle=%d)", path, agfsHandle)
+	// Generate FUSE handle ID
+	fuseHandle := atomic.AddUint64(&hm.nextHandle, 1)
 
-	// Try to open streaming connection for read handles
-	if flags&agfs.OpenFlagWriteOnly == 0 {
-		streamReader, streamErr := hm.client.ReadHandleStream(agfsHandle)
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	hm.logger.Debugf("Opened remote handle for %s (handle=%d)", path, agfsHandle)
+
+	// Try to open streaming connection for read handles, unless the plugin
+	// says the file is served by ranged reads. An empty file that isn't a
+	// stream has nothing to stream, and reading it through a stream would
+	// only wait out the stream timeout before reporting EOF.
+	empty := stat != nil && !stat.IsDir && stat.Size == 0 && access != agfs.AccessStream
+	if flags&agfs.OpenFlagWriteOnly == 0 && hm.defaultType == handleTypeRemoteStream && access != agfs.AccessRandom && !empty && !direct {
+		streamReader, streamErr := hm.clientFor(ctx).ReadHandleStream(agfsHandle)
 		if streamErr == nil {
-			ctx, cancel := context.WithCancel(context.Background())
-			log.Debugf("Opened stream for handle %d on %s", agfsHandle, path)
-			hm.handles[fuseHandle] = &handleInfo{
-				htype:        handleTypeRemoteStream,
-				agfsHandle:   agfsHandle,
-				path:         path,
-				flags:        flags,
-				mode:         mode,
-				streamReader: streamReader,
-				streamCtx:    ctx,
-				streamCancel: cancel,
+			hm.logger.Debugf("Opened stream for handle %d on %s", agfsHandle, path)
+			info := &handleInfo{
+				htype:      handleTypeRemoteStream,
+				agfsHandle: agfsHandle,
+				path:       path,
+				flags:      flags,
+				mode:       mode,
+				streamLive: access == agfs.AccessStream,
 			}
+			hm.addHandle(fuseHandle, info)
+			hm.startStream(info, streamReader)
 			return fuseHandle, nil
 		}
-		log.Debugf("Failed to open stream for %s, using regular handle: %v", path, streamErr)
+		hm.logger.Debugf("Failed to open stream for %s, using regular handle: %v", path, streamErr)
 	}
 
 	// Server supports HandleFS but not streaming (or write handle)
-	hm.handles[fuseHandle] = &handleInfo{
+	hm.addHandle(fuseHandle, &handleInfo{
 		htype:      handleTypeRemote,
 		agfsHandle: agfsHandle,
 		path:       path,
 		flags:      flags,
 		mode:       mode,
+		direct:     direct,
+		ra:         readahead{enabled: !direct && stat != nil && !stat.IsDir && stat.Size > 0},
+		etag:       etag,
+	})
+
+	return fuseHandle, nil
+}
+
+// statForOpen returns the attributes Open uses to pick how a file is read,
+// its access hint and size, or nil when there is nothing to act on: for
+// write-only opens, and for files that can't be stat'ed, such as files about
+// to be created.
+func (hm *HandleManager) statForOpen(ctx context.Context, path string, flags agfs.OpenFlag) *agfs.FileInfo {
+	if flags&agfs.OpenFlagWriteOnly != 0 {
+		return nil
+	}
+	stat := hm.stat
+	if stat == nil {
+		stat = func(ctx context.Context, path string) (*agfs.FileInfo, error) {
+			return hm.clientFor(ctx).Stat(path)
+		}
+	}
+	info, err := stat(ctx, path)
+	if err != nil {
+		return nil
+	}
+	return info
+}
+
+// openLocal opens a handle managed by agfs-fuse for servers without HandleFS.
+// There is no server-side open to enforce O_EXCL or O_TRUNC, so an exclusive
+// open creates the file with the server's exclusive create first, and a
+// truncating open truncates it to zero before the first write. A direct
+// local handle reads ranges of the file instead of buffering all of it.
+// etag is the file's version when it was opened ("" = unknown).
+// The caller must have reserved the handle with reserveHandle.
+func (hm *HandleManager) openLocal(ctx context.Context, path string, flags agfs.OpenFlag, mode uint32, direct bool, etag string) (uint64, error) {
+	if flags&agfs.OpenFlagCreate != 0 && flags&agfs.OpenFlagExclusive != 0 {
+		if err := hm.clientFor(ctx).CreateExclusive(path); err != nil {
+			hm.unreserveHandle()
+			hm.logger.Debugf("Exclusive create failed for %s: %v", path, err)
+			return 0, fmt.Errorf("failed to create file: %w", err)
+		}
+	}
+	if flags&agfs.OpenFlagTruncate != 0 && flags&(agfs.OpenFlagWriteOnly|agfs.OpenFlagReadWrite) != 0 {
+		// A file about to be created has nothing to truncate, and a plugin
+		// without truncate still has its content replaced by the first write
+		err := hm.clientFor(ctx).Truncate(path, 0)
+		missing := flags&agfs.OpenFlagCreate != 0 && errors.Is(err, agfs.ErrNotFound)
+		if err != nil && !missing && !errors.Is(err, agfs.ErrNotSupported) {
+			hm.unreserveHandle()
+			hm.logger.Debugf("Truncate on open failed for %s: %v", path, err)
+			return 0, fmt.Errorf("failed to truncate file: %w", err)
+		}
 	}
 
+	fuseHandle := atomic.AddUint64(&hm.nextHandle, 1)
+	hm.mu.Lock()
+	hm.addHandle(fuseHandle, &handleInfo{
+		htype:  handleTypeLocal,
+		path:   path,
+		flags:  flags,
+		mode:   mode,
+		direct: direct,
+		etag:   etag,
+	})
+	hm.mu.Unlock()
 	return fuseHandle, nil
 }
 
-// Close closes a handle
-func (hm *HandleManager) Close(fuseHandle uint64) error {
+// reserveHandle makes room for a handle being opened, applying the limit
+// policy once MaxOpenHandles handles are open or being opened. The
+// reservation is taken over by addHandle or given back with unreserveHandle.
+func (hm *HandleManager) reserveHandle(ctx context.Context) error {
+	var timeout <-chan time.Time
 	hm.mu.Lock()
-	info, ok := hm.handles[fuseHandle]
-	if !ok {
+	for hm.maxHandles > 0 && len(hm.handles)+hm.opening >= hm.maxHandles {
+		if hm.limitPolicy == HandleLimitEvict {
+			if id, info := hm.evictionCandidate(); info != nil {
+				hm.evict(id, info)
+				continue
+			}
+			// Every handle is already closing, wait for one of them
+		} else if hm.limitPolicy == HandleLimitReject {
+			hm.rejected++
+			hm.mu.Unlock()
+			return errTooManyHandles
+		}
+
+		freed := hm.handleFreed
 		hm.mu.Unlock()
-		return fmt.Errorf("handle %d not found", fuseHandle)
+		if timeout == nil {
+			timer := time.NewTimer(hm.limitWait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-freed:
+		case <-timeout:
+			hm.mu.Lock()
+			hm.rejected++
+			hm.mu.Unlock()
+			return errTooManyHandles
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		hm.mu.Lock()
+	}
+	hm.opening++
+	hm.mu.Unlock()
+	return nil
+}
+
+// unreserveHandle gives back a reservation whose open failed
+func (hm *HandleManager) unreserveHandle() {
+	hm.mu.Lock()
+	hm.opening--
+	hm.signalFreed()
+	hm.mu.Unlock()
+}
+
+// addHandle registers an opened handle, taking over its reservation
+// Must be called with hm.mu held
+func (hm *HandleManager) addHandle(fuseHandle uint64, info *handleInfo) {
+	hm.opening--
+	info.lastUsed = time.Now()
+	info.bandwidth = agfs.NewBandwidthLimiter(hm.handleBandwidth)
+	hm.handles[fuseHandle] = info
+}
+
+// signalFreed wakes opens waiting for a free handle
+// Must be called with hm.mu held
+func (hm *HandleManager) signalFreed() {
+	close(hm.handleFreed)
+	hm.handleFreed = make(chan struct{})
+}
+
+// evictionCandidate returns the least recently used handle, preferring
+// handles with no operation in flight. Must be called with hm.mu held
+func (hm *HandleManager) evictionCandidate() (uint64, *handleInfo) {
+	var bestID uint64
+	var best *handleInfo
+	for id, info := range hm.handles {
+		if info.closing {
+			continue
+		}
+		if best == nil {
+			bestID, best = id, info
+			continue
+		}
+		idle, bestIdle := info.refs == 0, best.refs == 0
+		if idle && !bestIdle || idle == bestIdle && info.lastUsed.Before(best.lastUsed) {
+			bestID, best = id, info
+		}
 	}
+	return bestID, best
+}
+
+// evict closes a handle to make room for another. It is removed from the
+// table right away so its slot is free; the server-side close happens in the
+// background once its in-flight operations finish. Must be called with
+// hm.mu held
+func (hm *HandleManager) evict(fuseHandle uint64, info *handleInfo) {
+	hm.logger.Debugf("Evicting handle %d on %s to stay within %d open handles", fuseHandle, info.path, hm.maxHandles)
+	idle := hm.beginClose(info)
 	delete(hm.handles, fuseHandle)
+	hm.evicted[fuseHandle] = struct{}{}
+	hm.nEvicted++
+	hm.evicting.Add(1)
+	go func() {
+		defer hm.evicting.Done()
+		<-idle
+		if err := hm.closeResources(hm.client, info); err != nil {
+			hm.logger.Warnf("Failed to close evicted handle %d on %s: %v", fuseHandle, info.path, err)
+		}
+	}()
+}
+
+// acquire looks up a handle and counts an in-flight operation on it, which
+// must be ended with release. Must be called with hm.mu held
+func (hm *HandleManager) acquire(fuseHandle uint64) (*handleInfo, error) {
+	info, ok := hm.handles[fuseHandle]
+	if !ok {
+		if _, evicted := hm.evicted[fuseHandle]; evicted {
+			return nil, fmt.Errorf("handle %d: %w", fuseHandle, errHandleEvicted)
+		}
+		return nil, fmt.Errorf("handle %d not found", fuseHandle)
+	}
+	if info.closing {
+		return nil, fmt.Errorf("handle %d: %w", fuseHandle, errHandleClosing)
+	}
+	info.refs++
+	info.lastUsed = time.Now()
+	return info, nil
+}
+
+// release ends an in-flight operation started by acquire
+// Must be called without hm.mu held
+func (hm *HandleManager) release(info *handleInfo) {
+	hm.mu.Lock()
+	info.refs--
+	if info.refs == 0 && info.closing {
+		close(info.idle)
+	}
 	hm.mu.Unlock()
+}
 
-	// Cancel context to stop any background goroutines
+// beginClose marks a handle as closing so no new operations start on it and
+// cancels its stream so blocked reads return. The returned channel is closed
+// once in-flight operations have finished. Must be called with hm.mu held
+func (hm *HandleManager) beginClose(info *handleInfo) <-chan struct{} {
+	info.closing = true
+	info.idle = make(chan struct{})
+	if info.refs == 0 {
+		close(info.idle)
+	}
 	if info.streamCancel != nil {
 		info.streamCancel()
 	}
+	return info.idle
+}
 
-	// Close stream reader if present
+// closeResources releases a closed handle's stream, buffers and server-side
+// handle. Must only be called once no operation uses the handle
+func (hm *HandleManager) closeResources(client *agfs.Client, info *handleInfo) error {
+	// Clear buffers to release memory
+	hm.mu.Lock()
 	if info.streamReader != nil {
 		info.streamReader.Close()
 	}
-
-	// Clear buffer to release memory
 	info.streamBuffer = nil
+	info.readBuffer = nil
+	info.ra.drop()
+	hm.mu.Unlock()
 
-	// Remote handles: close on server
+	// Remote handles: write what is held for the commit window, then close
+	// on server
 	if info.htype == handleTypeRemote || info.htype == handleTypeRemoteStream {
-		if err := hm.client.CloseHandle(info.agfsHandle); err != nil {
+		flushErr := hm.commitWrites(client, info)
+		if err := client.CloseHandle(info.agfsHandle); err != nil {
 			return fmt.Errorf("failed to close handle: %w", err)
 		}
-		return nil
+		return flushErr
 	}
 
 	// Local handles: nothing to do on close since writes are sent immediately
 	return nil
 }
 
-// Read reads data from a handle
-func (hm *HandleManager) Read(fuseHandle uint64, offset int64, size int) ([]byte, error) {
+// Close closes a handle, waiting for in-flight operations on it to finish
+func (hm *HandleManager) Close(ctx context.Context, fuseHandle uint64) error {
 	hm.mu.Lock()
 	info, ok := hm.handles[fuseHandle]
 	if !ok {
+		_, evicted := hm.evicted[fuseHandle]
+		delete(hm.evicted, fuseHandle)
 		hm.mu.Unlock()
-		return nil, fmt.Errorf("handle %d not found", fuseHandle)
+		if evicted {
+			// Already closed when it was evicted
+			return nil
+		}
+		return fmt.Errorf("handle %d not found", fuseHandle)
+	}
+	if info.closing {
+		// CloseAll got there first
+		hm.mu.Unlock()
+		return fmt.Errorf("handle %d: %w", fuseHandle, errHandleClosing)
+	}
+	idle := hm.beginClose(info)
+	hm.mu.Unlock()
+
+	<-idle
+
+	hm.mu.Lock()
+	if hm.handles[fuseHandle] == info {
+		delete(hm.handles, fuseHandle)
+	}
+	hm.signalFreed()
+	hm.mu.Unlock()
+
+	return hm.closeResources(hm.clientFor(ctx), info)
+}
+
+// Read reads up to size bytes from a handle at offset
+//
+// Files are opened with FOPEN_DIRECT_IO, so the result is handed to the
+// application as-is and follows read(2) semantics:
+//   - a read entirely past EOF returns 0 bytes and no error, which is the
+//     only way EOF is signalled
+//   - a read straddling EOF returns only the bytes before EOF; results are
+//     never padded to size
+//   - a short result is not EOF by itself, the application reads again
+//   - holes in sparse files are returned as zeros by the server and passed
+//     through like any other data
+//
+// Streaming handles return whatever is buffered at offset as soon as any of
+// it is available, and EOF once the stream ended and everything before it
+// was read. A live stream (agfs.AccessStream) that produces nothing within
+// the stream read timeout fails with ErrStreamPending, and the caller reads
+// again; other streams that do so, such as an idle queue, are reported as
+// EOF. Offsets the stream has already moved past, as
+// after a seek back, are read from the server with a ranged read; sources
+// that can't seek fail them with ESPIPE.
+//
+// Under a per-handle bandwidth limit the data read is paced to it.
+func (hm *HandleManager) Read(ctx context.Context, fuseHandle uint64, offset int64, size int) ([]byte, error) {
+	return hm.readPaced(ctx, fuseHandle, offset, size, newBytes)
+}
+
+// readPaced reads under the handle's bandwidth limit, with alloc providing
+// the buffers of data copied out of the handle's own buffers
+func (hm *HandleManager) readPaced(ctx context.Context, fuseHandle uint64, offset int64, size int, alloc func(int) []byte) ([]byte, error) {
+	data, err := hm.read(ctx, fuseHandle, offset, size, alloc)
+	if waitErr := hm.bandwidthOf(fuseHandle).WaitN(ctx, len(data)); waitErr != nil {
+		return nil, waitErr
+	}
+	return data, err
+}
+
+func (hm *HandleManager) read(ctx context.Context, fuseHandle uint64, offset int64, size int, alloc func(int) []byte) ([]byte, error) {
+	hm.mu.Lock()
+	info, err := hm.acquire(fuseHandle)
+	if err != nil {
+		hm.mu.Unlock()
+		return nil, err
+	}
+	defer hm.release(info)
+	if hm.buffersWrites(info) {
+		// Read what the handle wrote: flush what it holds, and wait for
+		// the flush in progress
+		hm.mu.Unlock()
+		hm.flushInBackground(hm.clientFor(ctx), info)
+		hm.mu.Lock()
+		if info.closing {
+			hm.mu.Unlock()
+			return nil, fmt.Errorf("handle %d: %w", fuseHandle, errHandleClosing)
+		}
+	}
+	if err := hm.checkVersion(ctx, info); err != nil {
+		hm.mu.Unlock()
+		return nil, err
 	}
 
 	// Streaming handle: read from stream
 	if info.htype == handleTypeRemoteStream && info.streamReader != nil {
-		return hm.readFromStream(info, offset, size)
+		return hm.readFromStream(ctx, info, offset, size, alloc)
 	}
 
 	if info.htype == handleTypeRemote {
+		cacheBlocks := info.cacheBlocks
+		if !cacheBlocks && info.mmap {
+			hm.mu.Unlock()
+			return hm.readPages(ctx, info.agfsHandle, offset, size)
+		}
+		if !cacheBlocks && info.ra.enabled && hm.readaheadSize > 0 {
+			return hm.readAhead(ctx, info, offset, size)
+		}
 		hm.mu.Unlock()
+		if cacheBlocks {
+			return hm.readBlocks(ctx, info.path, info.agfsHandle, offset, size, alloc)
+		}
 		// Use server-side handle
-		data, err := hm.client.ReadHandle(info.agfsHandle, offset, size)
+		data, err := hm.clientFor(ctx).ReadHandle(info.agfsHandle, offset, size)
 		if err != nil {
 			return nil, fmt.Errorf("failed to read handle: %w", err)
 		}
+		if len(data) > size {
+			data = data[:size]
+		}
+		return data, nil
+	}
+
+	if info.direct {
+		path := info.path
+		hm.mu.Unlock()
+		data, err := hm.clientFor(ctx).Read(path, offset, int64(size))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
+		if len(data) > size {
+			data = data[:size]
+		}
 		return data, nil
 	}
 
@@ -189,24 +788,44 @@ func (hm *HandleManager) Read(fuseHandle uint64, offset int64, size int) ([]byte
 	// This is critical for special filesystems like queuefs where each read
 	// should be an independent atomic operation (e.g., each read from dequeue
 	// should consume only one message, not multiple)
+	for info.readBuffer == nil && info.readFill != nil {
+		// Another read is fetching the file, wait for it rather than
+		// consuming the file again
+		fill := info.readFill
+		hm.mu.Unlock()
+		select {
+		case <-fill:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		hm.mu.Lock()
+		if info.closing {
+			hm.mu.Unlock()
+			return nil, fmt.Errorf("handle %d: %w", fuseHandle, errHandleClosing)
+		}
+	}
 	if info.readBuffer == nil {
 		// First read: fetch ALL data from server and cache (use size=-1 to read all)
 		path := info.path
+		fill := make(chan struct{})
+		info.readFill = fill
+		gen := info.readGen
 		hm.mu.Unlock()
 
-		data, err := hm.client.Read(path, 0, -1) // Read all data
-		if err != nil {
-			return nil, fmt.Errorf("failed to read file: %w", err)
-		}
+		data, err := hm.clientFor(ctx).Read(path, 0, -1) // Read all data
 
-		// Cache the data
+		// Cache the data, unless the handle was closed or the file
+		// truncated meanwhile
 		hm.mu.Lock()
-		// Re-check if handle still exists
-		info, ok = hm.handles[fuseHandle]
-		if ok {
+		info.readFill = nil
+		close(fill)
+		if err == nil && gen == info.readGen && !info.closing {
 			info.readBuffer = data
 		}
 		hm.mu.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read file: %w", err)
+		}
 
 		// Return requested portion
 		if offset >= int64(len(data)) {
@@ -239,118 +858,516 @@ func (hm *HandleManager) Read(fuseHandle uint64, offset int64, size int) ([]byte
 	return []byte{}, nil
 }
 
-// streamReadResult holds the result of a stream read operation
-type streamReadResult struct {
-	n   int
-	err error
-	buf []byte
+// readBlocks serves a remote handle read from the shared block cache. The
+// missing blocks from the first uncached one to the end of the range are
+// fetched in a single request.
+func (hm *HandleManager) readBlocks(ctx context.Context, path string, agfsHandle int64, offset int64, size int, alloc func(int) []byte) ([]byte, error) {
+	if size <= 0 {
+		return []byte{}, nil
+	}
+	bs := int64(hm.blocks.BlockSize())
+	first := offset / bs
+	last := (offset + int64(size) - 1) / bs
+
+	etag := ""
+	if hm.etag != nil {
+		etag = hm.etag(ctx, path)
+	}
+	blocks, err := hm.readBlockRun(ctx, path, agfsHandle, first, last-first+1, hm.blocks.Generation(), etag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Single block reads are served without copying
+	skip := offset - first*bs
+	if len(blocks) == 1 {
+		data := blocks[0]
+		if skip >= int64(len(data)) {
+			return []byte{}, nil
+		}
+		data = data[skip:]
+		if len(data) > size {
+			data = data[:size]
+		}
+		return data, nil
+	}
+
+	result := alloc(size)[:0]
+	for i, data := range blocks {
+		if i == 0 {
+			if skip >= int64(len(data)) {
+				break
+			}
+			data = data[skip:]
+		}
+		if n := size - len(result); len(data) > n {
+			data = data[:n]
+		}
+		result = append(result, data...)
+	}
+	return result, nil
+}
+
+// fetchBlocks reads count blocks starting at index from the server and caches
+// them. Concurrent readers missing the same block wait for a single fetch
+// instead of all going to the server.
+func (hm *HandleManager) fetchBlocks(ctx context.Context, path string, agfsHandle int64, index, count int64, generation uint64, etag string) ([][]byte, error) {
+	key := blockFetchKey{path, index}
+	hm.fetchMu.Lock()
+	done, busy := hm.fetching[key]
+	if !busy {
+		done = make(chan struct{})
+		hm.fetching[key] = done
+	}
+	hm.fetchMu.Unlock()
+
+	if busy {
+		// Continue from the cache, the other fetch may have covered fewer blocks
+		<-done
+		return hm.readBlockRun(ctx, path, agfsHandle, index, count, generation, etag)
+	}
+	defer func() {
+		hm.fetchMu.Lock()
+		delete(hm.fetching, key)
+		hm.fetchMu.Unlock()
+		close(done)
+	}()
+
+	bs := int64(hm.blocks.BlockSize())
+	data, err := readHandleFull(hm.clientFor(ctx), agfsHandle, index*bs, int(count*bs))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handle: %w", err)
+	}
+	return hm.splitBlocks(path, index, count, data, generation, etag), nil
 }
 
-// Maximum buffer size before trimming (1MB sliding window)
-const maxStreamBufferSize = 1 * 1024 * 1024
+// readHandleFull reads size bytes of a remote handle from offset, reading on
+// after a short response until they are all read or the server returns
+// nothing. Plugins may return less than asked anywhere in a file, which
+// data kept for later reads mustn't mistake for its end: a short result of
+// readHandleFull is.
+func readHandleFull(client *agfs.Client, handle int64, offset int64, size int) ([]byte, error) {
+	data, err := client.ReadHandle(handle, offset, size)
+	for err == nil && len(data) > 0 && len(data) < size {
+		var more []byte
+		more, err = client.ReadHandle(handle, offset+int64(len(data)), size-len(data))
+		if len(more) == 0 {
+			break
+		}
+		data = append(data, more...)
+	}
+	if len(data) > size {
+		data = data[:size]
+	}
+	return data, err
+}
 
-// readFromStream reads data from a streaming handle
-// Must be called with hm.mu held
-// Uses sliding window buffer to prevent memory leak
-func (hm *HandleManager) readFromStream(info *handleInfo, offset int64, size int) ([]byte, error) {
-	// Convert absolute offset to relative offset in buffer
-	relOffset := offset - info.streamBase
+// readBlockRun returns up to count blocks starting at index, from the cache
+// where possible. It stops early at the last block of the file. etag is the
+// file's current etag, "" if unknown.
+func (hm *HandleManager) readBlockRun(ctx context.Context, path string, agfsHandle int64, index, count int64, generation uint64, etag string) ([][]byte, error) {
+	var blocks [][]byte
+	for i := int64(0); i < count; i++ {
+		data, ok := hm.blocks.Get(path, index+i, etag)
+		if !ok {
+			fetched, err := hm.fetchBlocks(ctx, path, agfsHandle, index+i, count-i, generation, etag)
+			if err != nil {
+				return nil, err
+			}
+			return append(blocks, fetched...), nil
+		}
+		blocks = append(blocks, data)
+		if len(data) < hm.blocks.BlockSize() {
+			break
+		}
+	}
+	return blocks, nil
+}
 
-	// Fast path: if we already have data at the requested offset, return immediately
-	if relOffset >= 0 && relOffset < int64(len(info.streamBuffer)) {
-		end := relOffset + int64(size)
-		if end > int64(len(info.streamBuffer)) {
-			end = int64(len(info.streamBuffer))
+// splitBlocks splits data read from the start of block index into at most
+// count blocks and caches them. A block shorter than the block size, possibly
+// empty, ends the file.
+func (hm *HandleManager) splitBlocks(path string, index, count int64, data []byte, generation uint64, etag string) [][]byte {
+	bs := hm.blocks.BlockSize()
+	var blocks [][]byte
+	for i := int64(0); i < count; i++ {
+		n := len(data)
+		if n > bs {
+			n = bs
+		}
+		// Copy so each cached block holds only its own bytes
+		block := append([]byte(nil), data[:n]...)
+		hm.blocks.Put(path, index+i, block, generation, etag)
+		blocks = append(blocks, block)
+		if n < bs {
+			break
 		}
-		result := make([]byte, end-relOffset)
-		copy(result, info.streamBuffer[relOffset:end])
+		data = data[n:]
+	}
+	return blocks
+}
 
-		// Trim old data if buffer is too large (sliding window)
-		hm.trimStreamBuffer(info, offset+int64(size))
+// CacheBlocks makes reads of a remote handle go through the block cache. Only
+// use it for regular files whose content doesn't change by reading it.
+// It returns false if the block cache is disabled or the handle isn't remote,
+// or is direct.
+func (hm *HandleManager) CacheBlocks(fuseHandle uint64) bool {
+	if hm.blocks == nil {
+		return false
+	}
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	info, ok := hm.handles[fuseHandle]
+	if !ok || info.htype != handleTypeRemote || info.direct {
+		return false
+	}
+	info.cacheBlocks = true
+	return true
+}
 
-		hm.mu.Unlock()
-		return result, nil
+// InvalidateBlocks drops the cached blocks of path and everything below it,
+// and the data handles of them read ahead
+func (hm *HandleManager) InvalidateBlocks(path string) {
+	if hm.blocks != nil {
+		hm.blocks.Invalidate(path)
 	}
+	hm.dropReadahead(path)
+	hm.forgetVersions(path)
+}
 
-	// Check if requested offset is before our buffer (data already trimmed)
-	if relOffset < 0 {
-		hm.mu.Unlock()
-		log.Warnf("Requested offset %d is before buffer base %d (data already trimmed)", offset, info.streamBase)
-		return []byte{}, nil
+// truncated drops everything cached of path's content once it was truncated:
+// its blocks, what local handles of it buffered on their first read, and
+// what streaming handles of it streamed, so their next read sees the
+// truncated file
+func (hm *HandleManager) truncated(path string) {
+	hm.InvalidateBlocks(path)
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	for _, info := range hm.handles {
+		if info.path != path {
+			continue
+		}
+		switch info.htype {
+		case handleTypeLocal:
+			hm.dropReadBuffer(info)
+		case handleTypeRemoteStream:
+			// Wake the reads waiting for the stream to read the file
+			info.streamStale = true
+			info.streamBuffer = nil
+			close(info.streamData)
+			info.streamData = make(chan struct{})
+		}
 	}
+}
 
-	// No data at offset yet, need to read from stream
-	hm.mu.Unlock()
+// dropReadBuffer discards what a local handle buffered on its first read,
+// and what a first read in flight will fetch. Must be called with hm.mu held
+func (hm *HandleManager) dropReadBuffer(info *handleInfo) {
+	info.readBuffer = nil
+	info.readGen++
+}
+
+// Bytes a stream may buffer ahead of the reader when Config.StreamWindow is unset
+const defaultStreamWindow = 1 * 1024 * 1024
+
+// How long a read waits for stream data when Config.StreamReadTimeout is unset
+const defaultStreamReadTimeout = 5 * time.Second
 
-	// Use context for cancellation
+// How long a read waits for the first data of a stream when
+// Config.StreamFirstReadTimeout is unset
+const defaultStreamFirstReadTimeout = time.Second
+
+// How often, and after how long at first, a failed stream is reopened
+const (
+	defaultStreamReconnects     = 5
+	defaultStreamReconnectDelay = 100 * time.Millisecond
+	maxStreamReconnectDelay     = 5 * time.Second
+)
+
+// Maximum buffer size before trimming (1MB sliding window)
+const maxStreamBufferSize = 1 * 1024 * 1024
+
+// Size of each read from a stream
+const streamChunkSize = 64 * 1024
+
+// streamChunkPool recycles the read buffers of stream pumps
+var streamChunkPool = sync.Pool{
+	New: func() interface{} {
+		buf := make([]byte, streamChunkSize)
+		return &buf
+	},
+}
+
+// startStream attaches reader to info and starts the goroutine that fills
+// its buffer. Must be called with hm.mu held
+func (hm *HandleManager) startStream(info *handleInfo, reader io.ReadCloser) {
+	ctx, cancel := context.WithCancel(context.Background())
+	info.streamReader = reader
+	info.streamCtx = ctx
+	info.streamCancel = cancel
+	info.streamData = make(chan struct{})
+	info.streamSpace = make(chan struct{}, 1)
+	go hm.pumpStream(info)
+}
+
+// pumpStream reads the stream into streamBuffer until it ends, fails or the
+// handle is closed. It stops reading while streamWindow bytes are buffered
+// but not yet consumed, so a slow reader applies backpressure to the server
+// instead of growing the buffer. A stream failing mid-read is reopened by
+// reconnectStream, and only fails the handle once that gives up.
+func (hm *HandleManager) pumpStream(info *handleInfo) {
 	ctx := info.streamCtx
-	if ctx == nil {
-		ctx = context.Background()
-	}
+	bufPtr := streamChunkPool.Get().(*[]byte)
+	defer streamChunkPool.Put(bufPtr)
 
-	readTimeout := 5 * time.Second
-	buf := make([]byte, 64*1024) // 64KB chunks
-	resultCh := make(chan streamReadResult, 1)
+	hm.mu.Lock()
+	reader := info.streamReader
+	hm.mu.Unlock()
 
-	go func() {
-		n, err := info.streamReader.Read(buf)
+	for {
+		hm.mu.Lock()
+		for ctx.Err() == nil && info.streamBase+int64(len(info.streamBuffer))-info.streamConsumed >= hm.streamWindow {
+			hm.mu.Unlock()
+			select {
+			case <-info.streamSpace:
+			case <-ctx.Done():
+			}
+			hm.mu.Lock()
+		}
+		if ctx.Err() != nil {
+			// Handle closed, its buffers are being released
+			hm.mu.Unlock()
+			return
+		}
+		hm.mu.Unlock()
+
+		n, err := reader.Read(*bufPtr)
+
+		hm.mu.Lock()
+		if ctx.Err() != nil || info.streamStale {
+			// Handle closed, its buffers are being released, or its file
+			// truncated and the rest of the stream useless
+			hm.mu.Unlock()
+			return
+		}
+		if n > 0 {
+			info.streamBuffer = append(info.streamBuffer, (*bufPtr)[:n]...)
+		}
+		received := info.streamBase + int64(len(info.streamBuffer))
+		hm.mu.Unlock()
+
+		if err != nil && err != io.EOF {
+			reader, err = hm.reconnectStream(info, reader, received, err)
+		}
+
+		hm.mu.Lock()
+		if ctx.Err() != nil {
+			hm.mu.Unlock()
+			return
+		}
+		if err == io.EOF {
+			info.streamEOF = true
+		} else if err != nil {
+			info.streamErr = fmt.Errorf("failed to read from stream: %w", err)
+		}
+		close(info.streamData)
+		info.streamData = make(chan struct{})
+		hm.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// reconnectStream replaces reader, which failed with cause after received
+// bytes of the stream, by a new stream of the handle resuming there. Lost
+// connections and server errors are retried with exponential backoff and
+// jitter, up to streamReconnects attempts; errors reopening can't help
+// with, such as the handle or its file being gone, fail at once.
+func (hm *HandleManager) reconnectStream(info *handleInfo, reader io.ReadCloser, received int64, cause error) (io.ReadCloser, error) {
+	ctx := info.streamCtx
+	reader.Close()
+
+	err := cause
+	wait := hm.streamReconnectDelay
+	for attempt := 1; attempt <= hm.streamReconnects && !fatalStreamError(err); attempt++ {
+		// Jitter spreads out the handles that lost the same connection
+		delay := wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+		hm.logger.Warnf("Stream of %s failed at offset %d, reconnecting in %v (attempt %d/%d): %v",
+			info.path, received, delay, attempt, hm.streamReconnects, err)
 		select {
-		case resultCh <- streamReadResult{n: n, err: err, buf: buf}:
 		case <-ctx.Done():
-			// Context cancelled, goroutine exits cleanly
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+		wait *= 2
+		if wait > maxStreamReconnectDelay {
+			wait = maxStreamReconnectDelay
 		}
-	}()
 
-	var n int
-	var err error
-	var readBuf []byte
-	select {
-	case result := <-resultCh:
-		n = result.n
-		err = result.err
-		readBuf = result.buf
-	case <-time.After(readTimeout):
-		// Timeout - no data available
-		return []byte{}, nil
-	case <-ctx.Done():
-		// Handle closed
-		return []byte{}, nil
+		reader, err = hm.reopenStream(info, received)
+		if err != nil {
+			continue
+		}
+		hm.mu.Lock()
+		if ctx.Err() != nil {
+			hm.mu.Unlock()
+			reader.Close()
+			return nil, ctx.Err()
+		}
+		info.streamReader = reader
+		hm.mu.Unlock()
+		hm.logger.Infof("Reconnected stream of %s at offset %d", info.path, received)
+		return reader, nil
 	}
+	return nil, err
+}
 
-	hm.mu.Lock()
-	if n > 0 {
-		info.streamBuffer = append(info.streamBuffer, readBuf[:n]...)
+// reopenStream opens a new stream of the handle from offset. The server
+// streams from the handle's position, which is past offset when data was
+// lost with the connection, so it is moved back first. Live streams don't
+// seek and carry on from wherever the server is.
+func (hm *HandleManager) reopenStream(info *handleInfo, offset int64) (io.ReadCloser, error) {
+	if _, err := hm.client.SeekHandle(info.agfsHandle, offset, io.SeekStart); err != nil {
+		if !fatalStreamError(err) || errors.Is(err, agfs.ErrNotFound) {
+			return nil, err
+		}
+		hm.logger.Debugf("Cannot seek stream of %s, resuming where the server is: %v", info.path, err)
 	}
+	return hm.client.ReadHandleStream(info.agfsHandle)
+}
 
-	if err != nil && err != io.EOF {
-		hm.mu.Unlock()
-		return nil, fmt.Errorf("failed to read from stream: %w", err)
+// fatalStreamError tells whether the server refused a request for a reason
+// reopening the stream won't fix, rather than the connection failing or the
+// server being unavailable
+func fatalStreamError(err error) bool {
+	var statusErr *agfs.StatusError
+	if !errors.As(err, &statusErr) {
+		return false
+	}
+	return statusErr.StatusCode < http.StatusInternalServerError && statusErr.StatusCode != http.StatusTooManyRequests
+}
+
+// consumeStream records that the reader is done with the stream up to offset
+// and wakes the pump if that frees room in the window. Must be called with
+// hm.mu held
+func (hm *HandleManager) consumeStream(info *handleInfo, offset int64) {
+	if offset <= info.streamConsumed {
+		return
+	}
+	info.streamConsumed = offset
+	select {
+	case info.streamSpace <- struct{}{}:
+	default:
 	}
+}
 
-	// Recalculate relative offset after potential buffer changes
-	relOffset = offset - info.streamBase
+// readFromStream reads data from a streaming handle
+// Must be called with hm.mu held
+// Uses sliding window buffer to prevent memory leak
+//
+// It returns data, or no data and no error at the end of the stream. A read
+// that times out is ErrStreamPending for live streams and EOF for the
+// others; one interrupted by ctx or the handle closing fails with that.
+func (hm *HandleManager) readFromStream(ctx context.Context, info *handleInfo, offset int64, size int, alloc func(int) []byte) ([]byte, error) {
+	var timeout <-chan time.Time
+	for {
+		// Convert absolute offset to relative offset in buffer
+		relOffset := offset - info.streamBase
+
+		// Check if requested offset is before our buffer (data already
+		// trimmed), or the buffer is stale
+		if relOffset < 0 || info.streamStale {
+			hm.mu.Unlock()
+			return hm.readStreamRange(ctx, info, offset, size)
+		}
 
-	// Return whatever data we have at the requested offset
-	if relOffset < 0 || relOffset >= int64(len(info.streamBuffer)) {
+		// Data available at the offset, or the stream has ended
+		if relOffset < int64(len(info.streamBuffer)) || info.streamEOF || info.streamErr != nil {
+			break
+		}
+
+		// Skipping forward: data before the offset will never be returned
+		hm.trimStreamBuffer(info, offset)
+		hm.consumeStream(info, offset)
+
+		// No data at offset yet, wait for the pump
+		data := info.streamData
+		wait := hm.streamTimeout
+		if info.streamBase+int64(len(info.streamBuffer)) == 0 && hm.streamFirstTimeout < wait {
+			wait = hm.streamFirstTimeout
+		}
 		hm.mu.Unlock()
-		return []byte{}, nil // EOF or no data at this offset
+		if timeout == nil {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-data:
+		case <-timeout:
+			// No data yet: only a live stream is expected to produce more
+			if info.streamLive {
+				return nil, ErrStreamPending
+			}
+			return []byte{}, nil
+		case <-info.streamCtx.Done():
+			return nil, errHandleClosing
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		hm.mu.Lock()
+		if info.closing {
+			hm.mu.Unlock()
+			return nil, errHandleClosing
+		}
 	}
 
+	relOffset := offset - info.streamBase
+	if relOffset >= int64(len(info.streamBuffer)) {
+		// Past the end of a finished or failed stream
+		err := info.streamErr
+		hm.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+		return []byte{}, nil
+	}
+
+	// Return whatever data we have at the requested offset
 	end := relOffset + int64(size)
 	if end > int64(len(info.streamBuffer)) {
 		end = int64(len(info.streamBuffer))
 	}
 
-	result := make([]byte, end-relOffset)
+	result := alloc(int(end - relOffset))
 	copy(result, info.streamBuffer[relOffset:end])
+	hm.consumeStream(info, info.streamBase+end)
 
-	// Trim old data if buffer is too large
-	hm.trimStreamBuffer(info, offset+int64(size))
+	// Trim old data if buffer is too large (sliding window), up to what
+	// the read returned
+	hm.trimStreamBuffer(info, info.streamBase+end)
 
 	hm.mu.Unlock()
 	return result, nil
 }
 
+// readStreamRange serves a read below the stream buffer with a ranged read
+// of the same server handle, leaving the stream where it is. Handles that
+// don't support random access are refused with ESPIPE.
+func (hm *HandleManager) readStreamRange(ctx context.Context, info *handleInfo, offset int64, size int) ([]byte, error) {
+	hm.logger.Debugf("Offset %d of %s is before stream buffer base, reading range from server", offset, info.path)
+	data, err := hm.clientFor(ctx).ReadHandle(info.agfsHandle, offset, size)
+	if errors.Is(err, agfs.ErrNotSupported) {
+		return nil, fmt.Errorf("%s cannot seek back to offset %d: %w", info.path, offset, syscall.ESPIPE)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 // trimStreamBuffer removes old data from the buffer to prevent memory leak
 // Must be called with hm.mu held
 func (hm *HandleManager) trimStreamBuffer(info *handleInfo, consumedUpTo int64) {
@@ -363,6 +1380,9 @@ func (hm *HandleManager) trimStreamBuffer(info *handleInfo, consumedUpTo int64)
 	if trimPoint <= 0 {
 		return
 	}
+	if trimPoint > int64(len(info.streamBuffer)) {
+		trimPoint = int64(len(info.streamBuffer))
+	}
 
 	// Keep at least 64KB of already-read data for potential re-reads
 	margin := int64(64 * 1024)
@@ -373,31 +1393,68 @@ func (hm *HandleManager) trimStreamBuffer(info *handleInfo, consumedUpTo int64)
 	}
 
 	if trimPoint > 0 && trimPoint < int64(len(info.streamBuffer)) {
-		// Trim the buffer
-		newBuffer := make([]byte, int64(len(info.streamBuffer))-trimPoint)
-		copy(newBuffer, info.streamBuffer[trimPoint:])
-		info.streamBuffer = newBuffer
+		// Trim the buffer in place, keeping its capacity for later appends
+		n := copy(info.streamBuffer, info.streamBuffer[trimPoint:])
+		info.streamBuffer = info.streamBuffer[:n]
 		info.streamBase += trimPoint
-		log.Debugf("Trimmed stream buffer: new base=%d, new size=%d", info.streamBase, len(info.streamBuffer))
+		hm.logger.Debugf("Trimmed stream buffer: new base=%d, new size=%d", info.streamBase, len(info.streamBuffer))
+	}
+}
+
+// Write writes data to a handle, once a per-handle bandwidth limit allows
+func (hm *HandleManager) Write(ctx context.Context, fuseHandle uint64, data []byte, offset int64) (int, error) {
+	if err := hm.bandwidthOf(fuseHandle).WaitN(ctx, len(data)); err != nil {
+		return 0, err
 	}
+	return hm.write(ctx, fuseHandle, data, offset)
 }
 
-// Write writes data to a handle
-func (hm *HandleManager) Write(fuseHandle uint64, data []byte, offset int64) (int, error) {
+// bandwidthOf returns the bandwidth limiter of a handle (nil = unlimited)
+func (hm *HandleManager) bandwidthOf(fuseHandle uint64) *agfs.BandwidthLimiter {
 	hm.mu.Lock()
-	info, ok := hm.handles[fuseHandle]
-	if !ok {
+	defer hm.mu.Unlock()
+	if info, ok := hm.handles[fuseHandle]; ok {
+		return info.bandwidth
+	}
+	return nil
+}
+
+func (hm *HandleManager) write(ctx context.Context, fuseHandle uint64, data []byte, offset int64) (int, error) {
+	hm.mu.Lock()
+	info, err := hm.acquire(fuseHandle)
+	if err != nil {
 		hm.mu.Unlock()
-		return 0, fmt.Errorf("handle %d not found", fuseHandle)
+		return 0, err
 	}
+	defer hm.release(info)
+	// Drop cached blocks once the write is done, even a failed one may have
+	// partially landed
+	defer hm.InvalidateBlocks(info.path)
 
-	if info.htype == handleTypeRemote {
+	// Handles opened with O_SYNC or O_DSYNC have the server sync every write
+	// before acknowledging it
+	opts := agfs.WriteOptions{Durable: info.flags&agfs.OpenFlagSync != 0}
+
+	if hm.buffersWrites(info) {
+		return hm.bufferWrite(hm.clientFor(ctx), info, data, offset)
+	}
+	if info.htype == handleTypeRemote || info.htype == handleTypeRemoteStream {
 		hm.mu.Unlock()
-		// Use server-side handle (write directly)
-		written, err := hm.client.WriteHandle(info.agfsHandle, data, offset)
+		// Use server-side handle (write directly), streaming handles
+		// opened for reading and writing too
+		written, err := hm.clientFor(ctx).WriteHandleWithOptions(info.agfsHandle, data, offset, opts)
 		if err != nil {
 			return 0, fmt.Errorf("failed to write handle: %w", err)
 		}
+		// A short write is reported as it is, so the kernel writes the
+		// rest from where it stopped; one taking nothing would be retried
+		// forever
+		if written <= 0 && len(data) > 0 {
+			return 0, fmt.Errorf("failed to write handle: %w", io.ErrShortWrite)
+		}
+		if written > len(data) {
+			written = len(data)
+		}
 		return written, nil
 	}
 
@@ -408,32 +1465,41 @@ func (hm *HandleManager) Write(fuseHandle uint64, data []byte, offset int64) (in
 	path := info.path
 	hm.mu.Unlock()
 
-	log.Debugf("[handles] Local handle write: path=%s, len=%d, offset=%d", path, len(data), offset)
+	hm.logger.Debugf("[handles] Local handle write: path=%s, len=%d, offset=%d", path, len(data), offset)
 
 	// Send directly to server
-	_, err := hm.client.Write(path, data)
+	_, err = hm.clientFor(ctx).WriteWithOptions(path, data, opts)
 	if err != nil {
-		log.Errorf("[handles] Write failed for %s: %v", path, err)
+		hm.logger.Errorf("[handles] Write failed for %s: %v", path, err)
 		return 0, fmt.Errorf("failed to write to server: %w", err)
 	}
 
-	log.Debugf("[handles] Write success for %s: %d bytes", path, len(data))
+	hm.logger.Debugf("[handles] Write success for %s: %d bytes", path, len(data))
 	return len(data), nil
 }
 
 // Sync syncs a handle
-func (hm *HandleManager) Sync(fuseHandle uint64) error {
+func (hm *HandleManager) Sync(ctx context.Context, fuseHandle uint64) error {
 	hm.mu.Lock()
-	info, ok := hm.handles[fuseHandle]
-	if !ok {
+	info, err := hm.acquire(fuseHandle)
+	if err != nil {
 		hm.mu.Unlock()
-		return fmt.Errorf("handle %d not found", fuseHandle)
+		return err
 	}
+	defer hm.release(info)
 
-	// Remote handles: sync on server
-	if info.htype == handleTypeRemote {
+	// Remote handles: write what is held for the commit window, then sync
+	// on server
+	if info.htype == handleTypeRemote || info.htype == handleTypeRemoteStream {
+		buffered := hm.buffersWrites(info)
 		hm.mu.Unlock()
-		if err := hm.client.SyncHandle(info.agfsHandle); err != nil {
+		client := hm.clientFor(ctx)
+		if buffered {
+			if err := hm.commitWrites(client, info); err != nil {
+				return err
+			}
+		}
+		if err := client.SyncHandle(info.agfsHandle); err != nil {
 			return fmt.Errorf("failed to sync handle: %w", err)
 		}
 		return nil
@@ -444,34 +1510,45 @@ func (hm *HandleManager) Sync(fuseHandle uint64) error {
 	return nil
 }
 
-// CloseAll closes all open handles
+// CloseAll closes all open handles, waiting for in-flight operations on
+// them to finish and for evicted handles to be closed. Operations that start
+// meanwhile fail with errHandleClosing.
+// It is safe to call repeatedly and concurrently with other operations;
+// handles already being closed are left to their closer.
 func (hm *HandleManager) CloseAll() error {
 	hm.mu.Lock()
 	handles := make(map[uint64]*handleInfo)
-	for k, v := range hm.handles {
-		handles[k] = v
+	idle := make(map[uint64]<-chan struct{})
+	for id, info := range hm.handles {
+		if info.closing {
+			continue
+		}
+		handles[id] = info
+		idle[id] = hm.beginClose(info)
+	}
+	hm.mu.Unlock()
+
+	for _, ch := range idle {
+		<-ch
 	}
-	hm.handles = make(map[uint64]*handleInfo)
+
+	hm.mu.Lock()
+	for id, info := range handles {
+		if hm.handles[id] == info {
+			delete(hm.handles, id)
+		}
+	}
+	hm.evicted = make(map[uint64]struct{})
+	hm.signalFreed()
 	hm.mu.Unlock()
 
 	var lastErr error
 	for _, info := range handles {
-		// Cancel context to stop background goroutines
-		if info.streamCancel != nil {
-			info.streamCancel()
-		}
-		// Close stream reader if present
-		if info.streamReader != nil {
-			info.streamReader.Close()
-		}
-		// Clear buffer to release memory
-		info.streamBuffer = nil
-		if info.htype == handleTypeRemote || info.htype == handleTypeRemoteStream {
-			if err := hm.client.CloseHandle(info.agfsHandle); err != nil {
-				lastErr = err
-			}
+		if err := hm.closeResources(hm.client, info); err != nil {
+			lastErr = err
 		}
 	}
+	hm.evicting.Wait()
 
 	return lastErr
 }
@@ -483,3 +1560,72 @@ func (hm *HandleManager) Count() int {
 	return len(hm.handles)
 }
 
+// HandleStats counts open handles by type, against the MaxOpenHandles limit
+type HandleStats struct {
+	Open   int `json:"open"`
+	Remote int `json:"remote"`
+	Stream int `json:"stream"`
+	Local  int `json:"local"`
+	// Max is MaxOpenHandles (0 = unlimited). Evicted and Rejected count
+	// handles evicted and opens refused with EMFILE to stay within it.
+	Max      int   `json:"max"`
+	Evicted  int64 `json:"evicted"`
+	Rejected int64 `json:"rejected"`
+}
+
+// Stats returns the number of open handles of each type
+func (hm *HandleManager) Stats() HandleStats {
+	hm.mu.RLock()
+	defer hm.mu.RUnlock()
+
+	stats := HandleStats{
+		Open:     len(hm.handles),
+		Max:      hm.maxHandles,
+		Evicted:  hm.nEvicted,
+		Rejected: hm.rejected,
+	}
+	for _, info := range hm.handles {
+		switch info.htype {
+		case handleTypeRemote:
+			stats.Remote++
+		case handleTypeRemoteStream:
+			stats.Stream++
+		case handleTypeLocal:
+			stats.Local++
+		}
+	}
+	return stats
+}
+
+// HandleStatus describes an open handle
+type HandleStatus struct {
+	ID         uint64        `json:"id"`
+	Type       string        `json:"type"`
+	Path       string        `json:"path"`
+	Flags      agfs.OpenFlag `json:"flags"`
+	AGFSHandle int64         `json:"agfs_handle,omitempty"` // Server-side handle ID for remote handles
+	Direct     bool          `json:"direct,omitempty"`
+}
+
+// List returns the open handles ordered by ID
+func (hm *HandleManager) List() []HandleStatus {
+	hm.mu.RLock()
+	list := make([]HandleStatus, 0, len(hm.handles))
+	for id, info := range hm.handles {
+		status := HandleStatus{
+			ID:     id,
+			Type:   info.htype.String(),
+			Path:   info.path,
+			Flags:  info.flags,
+			Direct: info.direct,
+		}
+		if info.htype != handleTypeLocal {
+			status.AGFSHandle = info.agfsHandle
+		}
+		list = append(list, status)
+	}
+	hm.mu.RUnlock()
+
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
@@ -1,12 +1,27 @@
 package fusefs
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
+	"time"
 
 	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-sdk/go/agfstest"
+	"github.com/dongxuny/agfs-fuse/pkg/cache"
+	log "github.com/sirupsen/logrus"
 )
 
 func TestHandleManagerBasicOperations(t *testing.T) {
@@ -20,9 +35,66 @@ func TestHandleManagerBasicOperations(t *testing.T) {
 	if count := hm.Count(); count != 0 {
 		t.Errorf("Expected 0 handles, got %d", count)
 	}
+}
+
+func TestHandleManagerOpenReadWriteClose(t *testing.T) {
+	for _, disabled := range [][]string{nil, {agfs.FeatureStream}, {agfs.FeatureHandles}} {
+		srv := agfstest.NewServer()
+		defer srv.Close()
+		srv.Disable(disabled...)
+
+		client := agfs.NewClient(srv.URL)
+		hm := NewHandleManager(client)
+		info, err := client.ServerInfo(context.Background())
+		if err != nil {
+			t.Fatalf("ServerInfo failed: %v", err)
+		}
+		hm.configure(info)
+		ctx := context.Background()
+
+		fh, err := hm.Open(ctx, "/file", agfs.OpenFlagWriteOnly|agfs.OpenFlagCreate, 0644)
+		if err != nil {
+			t.Fatalf("Open for writing failed without %v: %v", disabled, err)
+		}
+		if _, err := hm.Write(ctx, fh, []byte("hello world"), 0); err != nil {
+			t.Fatalf("Write failed without %v: %v", disabled, err)
+		}
+		if err := hm.Close(ctx, fh); err != nil {
+			t.Fatalf("Close failed without %v: %v", disabled, err)
+		}
 
-	// Note: We can't actually test Open/Close without a running server
-	// Those would be integration tests
+		fh, err = hm.Open(ctx, "/file", agfs.OpenFlagReadOnly, 0)
+		if err != nil {
+			t.Fatalf("Open for reading failed without %v: %v", disabled, err)
+		}
+		var got []byte
+		for {
+			data, err := hm.Read(ctx, fh, int64(len(got)), 4)
+			if err != nil {
+				t.Fatalf("Read failed without %v: %v", disabled, err)
+			}
+			if len(data) == 0 {
+				break
+			}
+			got = append(got, data...)
+		}
+		if string(got) != "hello world" {
+			t.Errorf("Expected to read back the file without %v, got %q", disabled, got)
+		}
+		if err := hm.Close(ctx, fh); err != nil {
+			t.Fatalf("Close failed without %v: %v", disabled, err)
+		}
+
+		if _, err := hm.Open(ctx, "/file", agfs.OpenFlagWriteOnly|agfs.OpenFlagCreate|agfs.OpenFlagExclusive, 0644); !errors.Is(err, agfs.ErrAlreadyExists) {
+			t.Errorf("Expected an exclusive open to fail with ErrAlreadyExists without %v, got %v", disabled, err)
+		}
+		if n := hm.Count(); n != 0 {
+			t.Errorf("Expected no handles left without %v, got %d", disabled, n)
+		}
+		if n := srv.OpenHandles(); n != 0 {
+			t.Errorf("Expected every server handle closed without %v, got %d", disabled, n)
+		}
+	}
 }
 
 func TestHandleManagerConcurrency(t *testing.T) {
@@ -71,7 +143,7 @@ func TestHandleManager_OpenHandleNotSupportedFallback(t *testing.T) {
 	hm := NewHandleManager(client)
 
 	// Attempt to open a handle
-	fuseHandle, err := hm.Open("/test/path", 0, 0)
+	fuseHandle, err := hm.Open(context.Background(), "/test/path", 0, 0)
 	if err != nil {
 		t.Fatalf("Expected nil error during Open, but got: %v", err)
 	}
@@ -90,7 +162,7 @@ func TestHandleManager_OpenHandleNotSupportedFallback(t *testing.T) {
 	}
 
 	// Test closing the local handle
-	err = hm.Close(fuseHandle)
+	err = hm.Close(context.Background(), fuseHandle)
 	if err != nil {
 		t.Errorf("Error closing local handle: %v", err)
 	}
@@ -98,3 +170,2597 @@ func TestHandleManager_OpenHandleNotSupportedFallback(t *testing.T) {
 		t.Errorf("Expected 0 handles after close, got %d", count)
 	}
 }
+
+func TestHandleManager_ConfigureSkipsUnsupportedHandles(t *testing.T) {
+	openCalls := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/capabilities":
+			json.NewEncoder(w).Encode(agfs.CapabilitiesResponse{
+				Version:  "1.4.0",
+				Features: []string{agfs.FeatureGrep},
+			})
+		case "/api/v1/handles/open":
+			openCalls++
+			w.WriteHeader(http.StatusNotImplemented)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer testServer.Close()
+
+	client := agfs.NewClient(testServer.URL)
+	hm := NewHandleManager(client)
+
+	info, err := client.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerInfo failed: %v", err)
+	}
+	hm.configure(info)
+
+	fuseHandle, err := hm.Open(context.Background(), "/test/path", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if openCalls != 0 {
+		t.Errorf("Expected no OpenHandle requests, got %d", openCalls)
+	}
+	if info := hm.handles[fuseHandle]; info.htype != handleTypeLocal {
+		t.Errorf("Expected local handle, got %v", info.htype)
+	}
+}
+
+func TestHandleManager_ConfigureSkipsUnsupportedStream(t *testing.T) {
+	streamCalls := 0
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/handles/open":
+			json.NewEncoder(w).Encode(agfs.HandleResponse{HandleID: 7})
+		case "/api/v1/handles/7/stream":
+			streamCalls++
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.configure(&agfs.ServerInfo{
+		Version:  "1.4.0",
+		Features: map[string]bool{agfs.FeatureHandles: true},
+	})
+
+	fuseHandle, err := hm.Open(context.Background(), "/test/path", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if streamCalls != 0 {
+		t.Errorf("Expected no stream requests, got %d", streamCalls)
+	}
+	if info := hm.handles[fuseHandle]; info.htype != handleTypeRemote {
+		t.Errorf("Expected remote handle, got %v", info.htype)
+	}
+}
+
+func TestMajorVersion(t *testing.T) {
+	tests := []struct {
+		in    string
+		major int
+		ok    bool
+	}{
+		{"1.4.0", 1, true},
+		{"v2.0.1", 2, true},
+		{"dev", 0, false},
+		{"unknown", 0, false},
+	}
+	for _, tt := range tests {
+		major, ok := majorVersion(tt.in)
+		if major != tt.major || ok != tt.ok {
+			t.Errorf("majorVersion(%q) = %d, %v; want %d, %v", tt.in, major, ok, tt.major, tt.ok)
+		}
+	}
+}
+
+// patternReader is an endless stream where byte i is byte(i*seed)
+type patternReader struct {
+	seed byte
+	pos  int64
+}
+
+func (r *patternReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = byte(r.pos) * r.seed
+		r.pos++
+	}
+	return len(p), nil
+}
+
+func (r *patternReader) Close() error { return nil }
+
+// addStreamHandle registers a streaming handle backed by reader without a server
+func addStreamHandle(hm *HandleManager, reader io.ReadCloser) uint64 {
+	fuseHandle := atomic.AddUint64(&hm.nextHandle, 1)
+	hm.mu.Lock()
+	info := &handleInfo{htype: handleTypeRemoteStream}
+	hm.handles[fuseHandle] = info
+	hm.startStream(info, reader)
+	hm.mu.Unlock()
+	return fuseHandle
+}
+
+func TestHandleManager_StreamBuffersNotSharedAcrossHandles(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+
+	var wg sync.WaitGroup
+	for seed := byte(1); seed <= 8; seed++ {
+		fuseHandle := addStreamHandle(hm, &patternReader{seed: seed})
+		wg.Add(1)
+		go func(fuseHandle uint64, seed byte) {
+			defer wg.Done()
+			var offset int64
+			for offset < 4*1024*1024 {
+				// Results come from a pool shared by all handles
+				result, err := hm.ReadResult(context.Background(), fuseHandle, offset, 128*1024)
+				if err != nil {
+					t.Errorf("Read failed: %v", err)
+					return
+				}
+				data, _ := result.Bytes(nil)
+				for i, b := range data {
+					if want := byte(offset+int64(i)) * seed; b != want {
+						t.Errorf("handle %d: byte at %d = %d, expected %d", fuseHandle, offset+int64(i), b, want)
+						return
+					}
+				}
+				offset += int64(len(data))
+				result.Done()
+			}
+		}(fuseHandle, seed)
+	}
+	wg.Wait()
+}
+
+func BenchmarkHandleManager_StreamRead(b *testing.B) {
+	b.Run("Read", func(b *testing.B) {
+		hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+		fuseHandle := addStreamHandle(hm, &patternReader{seed: 1})
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		var offset int64
+		for i := 0; i < b.N; i++ {
+			data, err := hm.Read(context.Background(), fuseHandle, offset, 128*1024)
+			if err != nil {
+				b.Fatal(err)
+			}
+			offset += int64(len(data))
+		}
+	})
+	b.Run("ReadResult", func(b *testing.B) {
+		hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+		fuseHandle := addStreamHandle(hm, &patternReader{seed: 1})
+
+		b.ReportAllocs()
+		b.ResetTimer()
+		var offset int64
+		for i := 0; i < b.N; i++ {
+			result, err := hm.ReadResult(context.Background(), fuseHandle, offset, 128*1024)
+			if err != nil {
+				b.Fatal(err)
+			}
+			offset += int64(result.Size())
+			result.Done()
+		}
+	})
+}
+
+// sparseContent is a file with a 4KB hole between two data extents
+var sparseContent = append(append([]byte("head"), make([]byte, 4096)...), []byte("tail")...)
+
+// readSemanticsCases covers EOF, straddling EOF and reading a hole
+var readSemanticsCases = []struct {
+	name   string
+	offset int64
+	size   int
+	want   []byte
+}{
+	{"within", 0, 4, []byte("head")},
+	{"hole", 4, 4096, make([]byte, 4096)},
+	{"straddle EOF", int64(len(sparseContent)) - 2, 100, []byte("il")},
+	{"at EOF", int64(len(sparseContent)), 100, []byte{}},
+	{"past EOF", int64(len(sparseContent)) + 100, 100, []byte{}},
+}
+
+// serveRange writes content[offset:offset+size] the way the server does
+func serveRange(w http.ResponseWriter, r *http.Request, content []byte) {
+	offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	end := int64(len(content))
+	if sizeStr := r.URL.Query().Get("size"); sizeStr != "" {
+		size, _ := strconv.ParseInt(sizeStr, 10, 64)
+		if size >= 0 && offset+size < end {
+			end = offset + size
+		}
+	}
+	if offset > end {
+		offset = end
+	}
+	w.Write(content[offset:end])
+}
+
+func checkReadSemantics(t *testing.T, hm *HandleManager, fuseHandle uint64) {
+	t.Helper()
+	for _, tc := range readSemanticsCases {
+		data, err := hm.Read(context.Background(), fuseHandle, tc.offset, tc.size)
+		if err != nil {
+			t.Errorf("%s: Read failed: %v", tc.name, err)
+			continue
+		}
+		if !bytes.Equal(data, tc.want) {
+			t.Errorf("%s: expected %d bytes %q, got %d bytes %q", tc.name, len(tc.want), tc.want, len(data), data)
+		}
+	}
+}
+
+func TestHandleManager_ReadSemanticsRemote(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/handles/7/read" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		serveRange(w, r, sparseContent)
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/sparse"}
+	checkReadSemantics(t, hm, 1)
+}
+
+func TestHandleManager_ReadSemanticsLocal(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/files" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		serveRange(w, r, sparseContent)
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.handles[1] = &handleInfo{htype: handleTypeLocal, path: "/sparse"}
+	checkReadSemantics(t, hm, 1)
+}
+
+func TestHandleManager_ReadSemanticsStream(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+	fuseHandle := addStreamHandle(hm, io.NopCloser(bytes.NewReader(sparseContent)))
+	checkReadSemantics(t, hm, fuseHandle)
+}
+
+// TestHandleManager_StreamHandleWritesAtOffset checks that a file opened for
+// reading and writing, which gets a streaming handle, writes at the offsets
+// it is given rather than replacing the file
+func TestHandleManager_StreamHandleWritesAtOffset(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	client := agfs.NewClient(srv.URL)
+	if _, err := client.Write("/file", []byte("hello world")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	hm := NewHandleManager(client)
+	ctx := context.Background()
+
+	fh, err := hm.Open(ctx, "/file", agfs.OpenFlagReadWrite, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fh)
+	if htype := hm.handles[fh].htype; htype != handleTypeRemoteStream {
+		t.Fatalf("Expected a streaming handle, got %v", htype)
+	}
+	if _, err := hm.Write(ctx, fh, []byte("WORLD"), 6); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := hm.Sync(ctx, fh); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if data, err := client.Read("/file", 0, -1); err != nil || string(data) != "hello WORLD" {
+		t.Errorf("Expected the write to land at its offset, got %q (%v)", data, err)
+	}
+}
+
+func TestHandleManager_StreamSkipForward(t *testing.T) {
+	content := make([]byte, 256*1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+	fuseHandle := addStreamHandle(hm, io.NopCloser(bytes.NewReader(content)))
+
+	// The offset is several chunks ahead of anything read so far
+	offset := int64(200 * 1024)
+	data, err := hm.Read(context.Background(), fuseHandle, offset, 4096)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(data, content[offset:offset+4096]) {
+		t.Errorf("Expected 4096 bytes from offset %d, got %d bytes", offset, len(data))
+	}
+}
+
+// rangeServer serves ranged handle reads of content, or 501 if content is nil
+func rangeServer(t *testing.T, content []byte) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			// Handle close
+			return
+		}
+		if content == nil {
+			w.WriteHeader(http.StatusNotImplemented)
+			json.NewEncoder(w).Encode(agfs.ErrorResponse{Error: "queue handles can't seek"})
+			return
+		}
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		end := offset + size
+		if end > len(content) {
+			end = len(content)
+		}
+		w.Write(content[offset:end])
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandleManager_StreamSeekBack(t *testing.T) {
+	content := make([]byte, 3*1024*1024)
+	for i := range content {
+		content[i] = byte(i * 7)
+	}
+	server := rangeServer(t, content)
+
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	fuseHandle := addStreamHandle(hm, io.NopCloser(bytes.NewReader(content)))
+	defer hm.Close(context.Background(), fuseHandle)
+
+	// Read forward far enough for the head of the stream to be trimmed
+	var offset int64
+	for offset < 2*1024*1024 {
+		data, err := hm.Read(context.Background(), fuseHandle, offset, 128*1024)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		offset += int64(len(data))
+	}
+
+	// Seek back to re-read the header, then carry on where the stream was
+	for _, off := range []int64{0, 4096, offset} {
+		data, err := hm.Read(context.Background(), fuseHandle, off, 4096)
+		if err != nil {
+			t.Fatalf("Read at %d failed: %v", off, err)
+		}
+		if !bytes.Equal(data, content[off:off+int64(len(data))]) || len(data) == 0 {
+			t.Errorf("Read at %d returned %d wrong bytes", off, len(data))
+		}
+	}
+}
+
+func TestHandleManager_StreamSeekBackNotSeekable(t *testing.T) {
+	server := rangeServer(t, nil)
+
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	fuseHandle := addStreamHandle(hm, io.NopCloser(bytes.NewReader(make([]byte, 2*1024*1024))))
+	defer hm.Close(context.Background(), fuseHandle)
+
+	for offset := int64(0); offset < 2*1024*1024; offset += 128 * 1024 {
+		if _, err := hm.Read(context.Background(), fuseHandle, offset, 128*1024); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+	_, err := hm.Read(context.Background(), fuseHandle, 0, 4096)
+	if ToErrno(err) != syscall.ESPIPE {
+		t.Errorf("Expected ESPIPE seeking back on a non-seekable stream, got %v", err)
+	}
+}
+
+// countingReader is an endless stream that counts the bytes handed out
+type countingReader struct {
+	read atomic.Int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	r.read.Add(int64(len(p)))
+	return len(p), nil
+}
+
+func (r *countingReader) Close() error { return nil }
+
+func TestHandleManager_StreamBackpressure(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+	hm.streamWindow = 256 * 1024
+	reader := &countingReader{}
+	fuseHandle := addStreamHandle(hm, reader)
+	defer hm.Close(context.Background(), fuseHandle)
+
+	// A slow consumer: the producer may only run a window ahead of it
+	var offset int64
+	for i := 0; i < 20; i++ {
+		data, err := hm.Read(context.Background(), fuseHandle, offset, 4096)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		offset += int64(len(data))
+		time.Sleep(5 * time.Millisecond)
+
+		limit := offset + hm.streamWindow + streamChunkSize
+		if read := reader.read.Load(); read > limit {
+			t.Fatalf("Expected at most %d bytes read from the stream after consuming %d, got %d", limit, offset, read)
+		}
+	}
+
+	// Skipping forward counts as consuming, the producer catches up
+	offset += 2 * hm.streamWindow
+	if _, err := hm.Read(context.Background(), fuseHandle, offset, 4096); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if read := reader.read.Load(); read < offset {
+		t.Errorf("Expected the stream to be read past %d, got %d", offset, read)
+	}
+}
+
+func TestHandleManager_StreamReadTimeout(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+	hm.streamTimeout = 50 * time.Millisecond
+	reader := &blockingReader{closed: make(chan struct{})}
+	fuseHandle := addStreamHandle(hm, reader)
+	defer hm.Close(context.Background(), fuseHandle)
+
+	start := time.Now()
+	data, err := hm.Read(context.Background(), fuseHandle, 0, 4096)
+	if err != nil || len(data) != 0 {
+		t.Errorf("Expected an empty read from an idle stream, got %d bytes, err=%v", len(data), err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the read to give up after the configured timeout, took %v", elapsed)
+	}
+}
+
+func TestHandleManager_StreamFirstReadTimeout(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+	hm.streamFirstTimeout = 50 * time.Millisecond
+	reader := &blockingReader{closed: make(chan struct{})}
+	fuseHandle := addStreamHandle(hm, reader)
+	defer hm.Close(context.Background(), fuseHandle)
+
+	start := time.Now()
+	data, err := hm.Read(context.Background(), fuseHandle, 0, 4096)
+	if err != nil || len(data) != 0 {
+		t.Errorf("Expected an empty read from a stream with no data yet, got %d bytes, err=%v", len(data), err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the first read to give up after the first read timeout, took %v", elapsed)
+	}
+}
+
+func TestHandleManager_StreamPending(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+	hm.streamTimeout = 20 * time.Millisecond
+	hm.streamFirstTimeout = 20 * time.Millisecond
+	pr, pw := io.Pipe()
+	fuseHandle := addStreamHandle(hm, pr)
+	hm.handles[fuseHandle].streamLive = true
+	defer hm.Close(context.Background(), fuseHandle)
+	ctx := context.Background()
+
+	// A live stream that is slow but not done asks to be read again
+	if _, err := hm.Read(ctx, fuseHandle, 0, 4096); !errors.Is(err, ErrStreamPending) || ToErrno(err) != syscall.EAGAIN {
+		t.Fatalf("Expected ErrStreamPending from a slow stream, got %v", err)
+	}
+	go pw.Write([]byte("hello"))
+	var data []byte
+	var err error
+	for data, err = hm.Read(ctx, fuseHandle, 0, 4096); errors.Is(err, ErrStreamPending); {
+		data, err = hm.Read(ctx, fuseHandle, 0, 4096)
+	}
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Expected the data once it arrived, got %q, err=%v", data, err)
+	}
+
+	// A finished stream is EOF once everything was read
+	pw.Close()
+	for data, err = hm.Read(ctx, fuseHandle, 5, 4096); errors.Is(err, ErrStreamPending); {
+		data, err = hm.Read(ctx, fuseHandle, 5, 4096)
+	}
+	if err != nil || len(data) != 0 {
+		t.Errorf("Expected EOF from a finished stream, got %q, err=%v", data, err)
+	}
+}
+
+func TestFileHandle_ReadWaitsForLiveStream(t *testing.T) {
+	root := NewAGFSFS(Config{
+		ServerURL:              "http://localhost:8080",
+		CacheTTL:               time.Minute,
+		StreamReadTimeout:      10 * time.Millisecond,
+		StreamFirstReadTimeout: 10 * time.Millisecond,
+	})
+	pr, pw := io.Pipe()
+	fuseHandle := addStreamHandle(root.handles, pr)
+	root.handles.handles[fuseHandle].streamLive = true
+	defer root.handles.Close(context.Background(), fuseHandle)
+	fh := &AGFSFileHandle{node: &AGFSNode{root: root}, handle: fuseHandle}
+
+	// The read outlasts several stream timeouts instead of reporting EOF
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		pw.Write([]byte("late"))
+	}()
+	result, errno := fh.Read(context.Background(), make([]byte, 4096), 0)
+	if errno != 0 {
+		t.Fatalf("Read failed: %v", errno)
+	}
+	if data, _ := result.Bytes(make([]byte, 4096)); string(data) != "late" {
+		t.Errorf("Expected the late data, got %q", data)
+	}
+
+	// An interrupted read gives up with EINTR
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	if _, errno := fh.Read(ctx, make([]byte, 4096), 4); errno != syscall.EINTR {
+		t.Errorf("Expected EINTR from an interrupted read, got %v", errno)
+	}
+
+	pw.Close()
+	result, errno = fh.Read(context.Background(), make([]byte, 4096), 4)
+	if data, _ := result.Bytes(make([]byte, 4096)); errno != 0 || len(data) != 0 {
+		t.Errorf("Expected EOF once the stream ended, got %q, errno=%v", data, errno)
+	}
+}
+
+func TestHandleManager_EmptyFileSkipsStream(t *testing.T) {
+	var streams atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			json.NewEncoder(w).Encode(agfs.FileInfoResponse{Name: "empty", Size: 0})
+		case r.URL.Path == "/api/v1/handles/open":
+			json.NewEncoder(w).Encode(agfs.HandleResponse{HandleID: 7})
+		case r.URL.Path == "/api/v1/handles/7/stream":
+			// A stream of an empty file never produces anything
+			streams.Add(1)
+			<-r.Context().Done()
+		case r.URL.Path == "/api/v1/handles/7/read":
+		case r.Method == http.MethodDelete:
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	ctx := context.Background()
+
+	start := time.Now()
+	fuseHandle, err := hm.Open(ctx, "/empty", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+	data, err := hm.Read(ctx, fuseHandle, 0, 4096)
+	if err != nil || len(data) != 0 {
+		t.Errorf("Expected EOF from an empty file, got %d bytes, err=%v", len(data), err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected reading an empty file to return promptly, took %v", elapsed)
+	}
+	if n := streams.Load(); n != 0 {
+		t.Errorf("Expected no stream for an empty file, got %d", n)
+	}
+}
+
+// droppingStreamServer serves handle 7 as a stream of content from the
+// handle's position. The first stream drops the connection after sending
+// dropAt bytes; later ones fail with status once status is set.
+func droppingStreamServer(t *testing.T, content []byte, dropAt int, status *atomic.Int64) (*httptest.Server, *atomic.Int64) {
+	var pos, streams atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			json.NewEncoder(w).Encode(agfs.FileInfoResponse{Name: "file", Size: int64(len(content))})
+		case r.URL.Path == "/api/v1/handles/open":
+			json.NewEncoder(w).Encode(agfs.HandleResponse{HandleID: 7})
+		case r.URL.Path == "/api/v1/handles/7/seek":
+			offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+			pos.Store(offset)
+			json.NewEncoder(w).Encode(map[string]int64{"offset": offset})
+		case r.URL.Path == "/api/v1/handles/7/stream":
+			if code := status.Load(); code != 0 && streams.Load() > 0 {
+				w.WriteHeader(int(code))
+				json.NewEncoder(w).Encode(agfs.ErrorResponse{Error: http.StatusText(int(code))})
+				return
+			}
+			start := pos.Load()
+			end := int64(len(content))
+			if streams.Add(1) == 1 {
+				end = int64(dropAt)
+			}
+			w.Write(content[start:end])
+			// The server's cursor moved past what reached the client
+			pos.Store(int64(len(content)))
+			if end < int64(len(content)) {
+				w.(http.Flusher).Flush()
+				panic(http.ErrAbortHandler)
+			}
+		case r.Method == http.MethodDelete:
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &streams
+}
+
+func TestHandleManager_StreamReconnects(t *testing.T) {
+	content := make([]byte, 256*1024)
+	for i := range content {
+		content[i] = byte(i * 13)
+	}
+	var status atomic.Int64
+	server, streams := droppingStreamServer(t, content, 100*1024, &status)
+
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	hm.streamReconnectDelay = time.Millisecond
+	ctx := context.Background()
+	fuseHandle, err := hm.Open(ctx, "/file", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+
+	var got []byte
+	for {
+		data, err := hm.Read(ctx, fuseHandle, int64(len(got)), 64*1024)
+		if err != nil {
+			t.Fatalf("Read at %d failed: %v", len(got), err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		got = append(got, data...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Errorf("Expected the whole file read across the reconnect, got %d bytes", len(got))
+	}
+	if n := streams.Load(); n != 2 {
+		t.Errorf("Expected the stream opened twice, got %d", n)
+	}
+}
+
+func TestHandleManager_StreamReconnectFatal(t *testing.T) {
+	content := make([]byte, 256*1024)
+	var status atomic.Int64
+	status.Store(http.StatusNotFound)
+	server, _ := droppingStreamServer(t, content, 100*1024, &status)
+
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	hm.streamReconnectDelay = time.Millisecond
+	ctx := context.Background()
+	fuseHandle, err := hm.Open(ctx, "/file", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+
+	// Everything received before the drop is still read
+	var offset int64
+	for {
+		data, err := hm.Read(ctx, fuseHandle, offset, 64*1024)
+		if err != nil {
+			if !errors.Is(err, agfs.ErrNotFound) {
+				t.Errorf("Expected the handle gone to fail the read, got %v", err)
+			}
+			break
+		}
+		if len(data) == 0 {
+			t.Fatal("Expected the failed stream to report an error, got EOF")
+		}
+		offset += int64(len(data))
+	}
+	if offset != 100*1024 {
+		t.Errorf("Expected the %d bytes received read, got %d", 100*1024, offset)
+	}
+}
+
+// TestHandleManager_Bandwidth checks that writes are paced to the handle's
+// bandwidth limit, and to the client's limit shared by every handle, with
+// the first second's worth passing at once
+func TestHandleManager_Bandwidth(t *testing.T) {
+	const rate = 1 << 20
+	for _, tc := range []struct {
+		name   string
+		handle bool
+		files  []string
+	}{
+		{"handle", true, []string{"/a"}},
+		{"shared", false, []string{"/a", "/b"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := agfstest.NewServer()
+			defer srv.Close()
+			client := agfs.NewClient(srv.URL)
+			hm := NewHandleManager(client)
+			if tc.handle {
+				hm.handleBandwidth = rate
+			} else {
+				client.EnableBandwidthLimit(agfs.NewBandwidthLimiter(rate))
+			}
+			ctx := context.Background()
+
+			// 1.5s worth of data, half a second over the burst
+			chunk := make([]byte, 64<<10)
+			total := rate * 3 / 2
+			start := time.Now()
+			for _, name := range tc.files {
+				fh, err := hm.Open(ctx, name, agfs.OpenFlagWriteOnly|agfs.OpenFlagCreate, 0644)
+				if err != nil {
+					t.Fatalf("Open failed: %v", err)
+				}
+				for off := 0; off < total/len(tc.files); off += len(chunk) {
+					if _, err := hm.Write(ctx, fh, chunk, int64(off)); err != nil {
+						t.Fatalf("Write failed: %v", err)
+					}
+				}
+				hm.Close(ctx, fh)
+			}
+			if elapsed := time.Since(start); elapsed < 400*time.Millisecond || elapsed > 1500*time.Millisecond {
+				t.Errorf("Writing %d bytes at %d bytes/s took %v, expected about 500ms", total, rate, elapsed)
+			}
+		})
+	}
+}
+
+// TestHandleManager_BandwidthCancelled checks that a write interrupted while
+// waiting for bandwidth gives its share back
+func TestHandleManager_BandwidthCancelled(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	hm := NewHandleManager(agfs.NewClient(srv.URL))
+	hm.handleBandwidth = 64 << 10
+	ctx := context.Background()
+
+	fh, err := hm.Open(ctx, "/a", agfs.OpenFlagWriteOnly|agfs.OpenFlagCreate, 0644)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fh)
+
+	// A write of 10s worth can't pass before the interrupt
+	cancelled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := hm.Write(cancelled, fh, make([]byte, 640<<10), 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the write to be interrupted, got %v", err)
+	}
+
+	// The burst is still there for the next one
+	start := time.Now()
+	if _, err := hm.Write(ctx, fh, make([]byte, 32<<10), 0); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 250*time.Millisecond {
+		t.Errorf("Write after an interrupted one waited %v", elapsed)
+	}
+}
+
+func TestHandleManager_StatsAndList(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient("http://localhost:8080"))
+	hm.handles[2] = &handleInfo{htype: handleTypeLocal, path: "/b", agfsHandle: -1}
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, path: "/a", agfsHandle: 7}
+	hm.handles[3] = &handleInfo{htype: handleTypeRemoteStream, path: "/c", agfsHandle: 9}
+
+	stats := hm.Stats()
+	if stats != (HandleStats{Open: 3, Remote: 1, Stream: 1, Local: 1}) {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	list := hm.List()
+	if len(list) != 3 {
+		t.Fatalf("Expected 3 handles, got %d", len(list))
+	}
+	for i, want := range []HandleStatus{
+		{ID: 1, Type: "remote", Path: "/a", AGFSHandle: 7},
+		{ID: 2, Type: "local", Path: "/b"},
+		{ID: 3, Type: "stream", Path: "/c", AGFSHandle: 9},
+	} {
+		if list[i] != want {
+			t.Errorf("Handle %d: expected %+v, got %+v", i, want, list[i])
+		}
+	}
+}
+
+func TestHandleManager_LogsToConfiguredLogger(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotImplemented)
+	}))
+	defer testServer.Close()
+
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+
+	root := NewAGFSFS(Config{ServerURL: testServer.URL, CacheTTL: time.Minute, Logger: logger})
+	defer root.Close()
+
+	// Debug output is dropped at info level...
+	logger.SetLevel(log.InfoLevel)
+	buf.Reset()
+	root.handles.handles[1] = &handleInfo{htype: handleTypeLocal, path: "/file"}
+	root.handles.Write(context.Background(), 1, []byte("x"), 0)
+	if strings.Contains(buf.String(), "Local handle write") {
+		t.Errorf("Expected no debug output at info level, got %q", buf.String())
+	}
+
+	// ...and emitted once the level is lowered
+	logger.SetLevel(log.DebugLevel)
+	root.handles.Write(context.Background(), 1, []byte("x"), 0)
+	if !strings.Contains(buf.String(), "Local handle write") {
+		t.Errorf("Expected debug output at debug level, got %q", buf.String())
+	}
+}
+
+func TestHandleManager_ReadSemanticsBlockCache(t *testing.T) {
+	var requests int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		serveRange(w, r, sparseContent)
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.blocks = cache.NewBlockCache(1000, 1<<20, time.Minute)
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/sparse", cacheBlocks: true}
+
+	checkReadSemantics(t, hm, 1)
+	cold := atomic.LoadInt32(&requests)
+
+	// The second pass is served entirely from the cache
+	checkReadSemantics(t, hm, 1)
+	if got := atomic.LoadInt32(&requests); got != cold {
+		t.Errorf("Expected no requests for cached reads, got %d", got-cold)
+	}
+}
+
+func TestHandleManager_BlockCacheSharedAndCoherent(t *testing.T) {
+	var mu sync.Mutex
+	content := []byte("original content")
+	var reads int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/read"):
+			atomic.AddInt32(&reads, 1)
+			serveRange(w, r, content)
+		case strings.HasSuffix(r.URL.Path, "/write"):
+			data, _ := io.ReadAll(r.Body)
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			copy(content[offset:], data)
+			json.NewEncoder(w).Encode(map[string]int{"bytes_written": len(data)})
+		}
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.blocks = cache.NewBlockCache(8, 1<<20, time.Minute)
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/shared"}
+	hm.handles[2] = &handleInfo{htype: handleTypeRemote, agfsHandle: 8, path: "/shared"}
+	hm.CacheBlocks(1)
+	hm.CacheBlocks(2)
+
+	read := func(fuseHandle uint64) string {
+		t.Helper()
+		data, err := hm.Read(context.Background(), fuseHandle, 0, 100)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := read(1); got != "original content" {
+		t.Fatalf("Expected original content, got %q", got)
+	}
+	cold := atomic.LoadInt32(&reads)
+	if got := read(2); got != "original content" || atomic.LoadInt32(&reads) != cold {
+		t.Errorf("Expected the second handle to hit the cache, got %q after %d reads", got, atomic.LoadInt32(&reads)-cold)
+	}
+
+	// A write through one handle is visible through the other
+	if _, err := hm.Write(context.Background(), 2, []byte("modified"), 0); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if got := read(1); got != "modified content" {
+		t.Errorf("Expected modified content after write, got %q", got)
+	}
+}
+
+func TestHandleManager_BlockCacheReadRepair(t *testing.T) {
+	content := []byte("0123456789abcdef")
+	var reads int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/read") {
+			atomic.AddInt32(&reads, 1)
+			serveRange(w, r, content)
+		}
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.blocks = cache.NewBlockCache(8, 1<<20, time.Minute)
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/repair"}
+	hm.CacheBlocks(1)
+
+	if _, err := hm.Read(context.Background(), 1, 0, 16); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	// Poison the second block behind the cache's back
+	poisoned, ok := hm.blocks.Get("/repair", 1, "")
+	if !ok {
+		t.Fatal("Expected the second block to be cached")
+	}
+	copy(poisoned, "XXXXXXXX")
+
+	before := atomic.LoadInt32(&reads)
+	data, err := hm.Read(context.Background(), 1, 4, 8)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "456789ab" {
+		t.Errorf("Expected the server's bytes, got %q", data)
+	}
+	if got := atomic.LoadInt32(&reads) - before; got != 1 {
+		t.Errorf("Expected the poisoned block to be read again, got %d reads", got)
+	}
+	if stats := hm.blocks.Stats(); stats.Repaired != 1 {
+		t.Errorf("Expected 1 repaired block, got %+v", stats)
+	}
+	// The poisoned entry was replaced by the server's bytes
+	if block, ok := hm.blocks.Get("/repair", 1, ""); !ok || string(block) != "89abcdef" {
+		t.Errorf("Expected the repaired block to be cached, got %q (ok=%v)", block, ok)
+	}
+}
+
+func TestHandleManager_MmapReadsAligned(t *testing.T) {
+	content := make([]byte, 8*pageSize)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+	var mu sync.Mutex
+	var fetched [][2]int64
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/read") {
+			offset, _ := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+			size, _ := strconv.ParseInt(r.URL.Query().Get("size"), 10, 64)
+			mu.Lock()
+			fetched = append(fetched, [2]int64{offset, size})
+			mu.Unlock()
+			serveRange(w, r, content)
+		}
+	}))
+	defer testServer.Close()
+
+	for _, unit := range []int64{pageSize, 2 * pageSize} {
+		t.Run(fmt.Sprintf("unit=%d", unit), func(t *testing.T) {
+			hm := NewHandleManager(agfs.NewClient(testServer.URL))
+			hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/mapped"}
+			if unit != pageSize {
+				// Blocks larger than a page align reads to the block,
+				// without marking the handle
+				hm.blocks = cache.NewBlockCache(int(unit), 1<<20, time.Minute)
+				hm.CacheBlocks(1)
+				if hm.SetMmap(1, true) {
+					t.Fatal("Expected a handle using the block cache not to be marked")
+				}
+			} else if !hm.SetMmap(1, true) {
+				t.Fatal("Expected a remote handle to be marked")
+			}
+			mu.Lock()
+			fetched = nil
+			mu.Unlock()
+
+			for _, offset := range []int64{100, 3*pageSize + 1, pageSize - 1} {
+				data, err := hm.Read(context.Background(), 1, offset, int(pageSize))
+				if err != nil {
+					t.Fatalf("Read at %d failed: %v", offset, err)
+				}
+				if !bytes.Equal(data, content[offset:offset+pageSize]) {
+					t.Errorf("Read at %d returned the wrong bytes", offset)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(fetched) == 0 {
+				t.Fatal("Expected reads of the server")
+			}
+			for _, f := range fetched {
+				if f[0]%unit != 0 || f[1]%unit != 0 {
+					t.Errorf("Expected fetches aligned to %d bytes, got %d bytes at %d", unit, f[1], f[0])
+				}
+			}
+		})
+	}
+}
+
+func TestFileHandle_DetectsMmap(t *testing.T) {
+	root := NewAGFSFS(Config{ServerURL: "http://localhost:8080", CacheTTL: time.Minute})
+	root.handles.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/mapped"}
+	fh := &AGFSFileHandle{node: &AGFSNode{root: root}, handle: 1}
+	mapped := func() bool {
+		root.handles.mu.Lock()
+		defer root.handles.mu.Unlock()
+		return root.handles.handles[1].mmap
+	}
+	window := int64(128 * 1024)
+	fault := func(i int64) { fh.detectMmap(i*4*window, int(window)) }
+
+	// Whole pages read in sequence, and unaligned reads anywhere, are read(2)
+	fh.detectMmap(0, int(window))
+	fh.detectMmap(window, int(window))
+	fh.detectMmap(100, 4000)
+	if mapped() {
+		t.Fatal("Expected sequential and unaligned reads not to mark the handle")
+	}
+
+	// Reads around scattered faults mark it
+	for i := int64(1); i <= mmapFaults; i++ {
+		fault(i)
+	}
+	if !mapped() {
+		t.Fatal("Expected scattered page windows to mark the handle")
+	}
+
+	// A sequential read unmarks it, as does an unaligned one
+	fh.detectMmap(fh.next, int(window))
+	if mapped() {
+		t.Error("Expected a sequential read to unmark the handle")
+	}
+	for i := int64(1); i <= mmapFaults; i++ {
+		fault(10 + i)
+	}
+	fh.detectMmap(3*window+1, 512)
+	if mapped() {
+		t.Error("Expected an unaligned read to unmark the handle")
+	}
+}
+
+func TestFileHandle_RandomPreadsNotMmap(t *testing.T) {
+	root := NewAGFSFS(Config{ServerURL: "http://localhost:8080", CacheTTL: time.Minute})
+	root.handles.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/db"}
+	fh := &AGFSFileHandle{node: &AGFSNode{root: root}, handle: 1}
+
+	// A database reading its pages at random offsets with pread(2)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		page := rnd.Int63n(1 << 16)
+		fh.detectMmap(page*4096, 4096)
+	}
+	root.handles.mu.Lock()
+	defer root.handles.mu.Unlock()
+	if root.handles.handles[1].mmap {
+		t.Error("Expected random aligned preads not to mark the handle")
+	}
+}
+
+func TestHandleManager_BlockCacheRevalidatesETag(t *testing.T) {
+	var mu sync.Mutex
+	content, etag := []byte("original content"), "v1"
+	var stats, notModified, reads int32
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			atomic.AddInt32(&stats, 1)
+			w.Header().Set("ETag", strconv.Quote(etag))
+			if r.Header.Get("If-None-Match") == strconv.Quote(etag) {
+				atomic.AddInt32(&notModified, 1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			json.NewEncoder(w).Encode(agfs.FileInfoResponse{
+				Name: "file", Size: int64(len(content)), Mode: 0644,
+				Meta: agfs.MetaData{Content: map[string]string{agfs.MetaETag: etag}},
+			})
+		case strings.HasSuffix(r.URL.Path, "/read"):
+			atomic.AddInt32(&reads, 1)
+			serveRange(w, r, content)
+		}
+	}))
+	defer testServer.Close()
+
+	ttl := 30 * time.Millisecond
+	root := NewAGFSFS(Config{ServerURL: testServer.URL, CacheTTL: ttl, BlockCacheSize: 1 << 20, BlockSize: 8})
+	defer root.Close()
+	hm := root.handles
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file", cacheBlocks: true}
+	// Opening the file caches its attributes
+	if _, err := root.statCached(context.Background(), "/file"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	read := func() string {
+		t.Helper()
+		data, err := hm.Read(context.Background(), 1, 0, 100)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return string(data)
+	}
+
+	if got := read(); got != "original content" {
+		t.Fatalf("Expected original content, got %q", got)
+	}
+	coldReads := atomic.LoadInt32(&reads)
+
+	// Past the TTL an unchanged etag keeps the cached blocks
+	time.Sleep(2 * ttl)
+	if got := read(); got != "original content" {
+		t.Errorf("Expected original content, got %q", got)
+	}
+	if got := atomic.LoadInt32(&reads); got != coldReads {
+		t.Errorf("Expected no reads for an unchanged file, got %d", got-coldReads)
+	}
+	if atomic.LoadInt32(&notModified) != 1 {
+		t.Errorf("Expected the attributes to be revalidated once, got %d", notModified)
+	}
+
+	// A changed etag drops them
+	mu.Lock()
+	content, etag = []byte("modified content"), "v2"
+	mu.Unlock()
+	time.Sleep(2 * ttl)
+	if got := read(); got != "modified content" {
+		t.Errorf("Expected modified content after the etag changed, got %q", got)
+	}
+}
+
+func TestHandleManager_ReadSemanticsReadahead(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveRange(w, r, sparseContent)
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.readaheadSize = 1 << 20
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/sparse", ra: readahead{enabled: true}}
+
+	checkReadSemantics(t, hm, 1)
+	checkReadSemantics(t, hm, 1)
+}
+
+func TestHandleManager_ReadaheadSequential(t *testing.T) {
+	var mu sync.Mutex
+	content := bytes.Repeat([]byte("0123456789abcdef"), 20*1024) // 320KB
+	var sizes []int
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		sizes = append(sizes, size)
+		serveRange(w, r, content)
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.readaheadSize = 128 * 1024
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file", ra: readahead{enabled: true}}
+	read := func(offset int64, size int) []byte {
+		t.Helper()
+		data, err := hm.Read(context.Background(), 1, offset, size)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		return data
+	}
+	requests := func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), sizes...)
+	}
+
+	var got []byte
+	for offset := int64(0); ; offset += 512 {
+		data := read(offset, 512)
+		if len(data) == 0 {
+			break
+		}
+		got = append(got, data...)
+	}
+	if !bytes.Equal(got, content) {
+		t.Fatalf("Expected the file content, got %d bytes", len(got))
+	}
+	// The first read, then windows of 64KB and 128KB until EOF, the window
+	// the file ends in being confirmed short by a read at its end
+	want := []int{512, 64 * 1024, 128 * 1024, 128 * 1024, 512}
+	if sizes := requests(); fmt.Sprint(sizes) != fmt.Sprint(want) {
+		t.Errorf("Expected requests of %v bytes, got %v", want, sizes)
+	}
+
+	// A seek back resets the window, a read continuing it grows it again
+	n := len(requests())
+	read(1000, 512)
+	read(1512, 512)
+	if sizes := requests()[n:]; fmt.Sprint(sizes) != fmt.Sprint([]int{512, 64 * 1024}) {
+		t.Errorf("Expected a plain read then a 64KB window after the seek, got %v", sizes)
+	}
+
+	// Data read ahead is dropped once the file changes
+	mu.Lock()
+	copy(content[2024:], "changed!")
+	mu.Unlock()
+	hm.InvalidateBlocks("/file")
+	if data := read(2024, 8); string(data) != "changed!" {
+		t.Errorf("Expected the changed content, got %q", data)
+	}
+}
+
+func TestHandleManager_ReadaheadIOPattern(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1MB
+	const page = 4096
+	// A database-like pattern: pages read in pairs at scattered offsets
+	var random []int64
+	for i := int64(0); i < 16; i++ {
+		offset := (i * 37 % 64) * 4 * page
+		random = append(random, offset, offset+page)
+	}
+
+	// run reads offsets, then the first 64KB sequentially, through a new
+	// handle under pattern, and returns the sizes the server was asked for
+	// by each part
+	run := func(pattern IOPattern, offsets []int64) (randomSizes, sequentialSizes []int) {
+		var mu sync.Mutex
+		var sizes []int
+		testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+			sizes = append(sizes, size)
+			mu.Unlock()
+			serveRange(w, r, content)
+		}))
+		defer testServer.Close()
+
+		hm := NewHandleManager(agfs.NewClient(testServer.URL))
+		hm.readaheadSize = 1 << 20
+		hm.ioPattern = pattern
+		hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/db", ra: readahead{enabled: true}}
+		read := func(offset int64) {
+			t.Helper()
+			data, err := hm.Read(context.Background(), 1, offset, page)
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if !bytes.Equal(data, content[offset:offset+page]) {
+				t.Fatalf("Expected the content at %d", offset)
+			}
+		}
+		for _, offset := range offsets {
+			read(offset)
+		}
+		mu.Lock()
+		n := len(sizes)
+		mu.Unlock()
+		for offset := int64(0); offset < 16*page; offset += page {
+			read(offset)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return sizes[:n], sizes[n:]
+	}
+	fetched := func(sizes []int) int {
+		total := 0
+		for _, size := range sizes {
+			total += size
+		}
+		return total
+	}
+
+	// Auto: the first pairs read ahead, then reads are plain once they look
+	// random, until sequential reads read ahead again
+	randomSizes, sequentialSizes := run(IOPatternAuto, random)
+	for i, size := range randomSizes[2*randomSeeks:] {
+		if size != page {
+			t.Errorf("Expected plain reads once the pattern is random, got %d bytes at read %d", size, 2*randomSeeks+i)
+		}
+	}
+	if fetched(sequentialSizes) <= 16*page || len(sequentialSizes) >= 16 {
+		t.Errorf("Expected sequential reads to read ahead again, got requests of %v bytes", sequentialSizes)
+	}
+
+	// Sequential: every pair reads ahead
+	seqRandom, _ := run(IOPatternSequential, random)
+	if fetched(seqRandom) <= 2*fetched(randomSizes) {
+		t.Errorf("Expected %v bytes read ahead of every pair, against %d with detection", seqRandom, fetched(randomSizes))
+	}
+
+	// Random: nothing is read ahead
+	_, randomSequential := run(IOPatternRandom, nil)
+	if fetched(randomSequential) != 16*page || len(randomSequential) != 16 {
+		t.Errorf("Expected only plain reads, got requests of %v bytes", randomSequential)
+	}
+}
+
+func TestParseIOPattern(t *testing.T) {
+	for _, pattern := range []IOPattern{IOPatternAuto, IOPatternSequential, IOPatternRandom} {
+		if got, err := ParseIOPattern(pattern.String()); err != nil || got != pattern {
+			t.Errorf("Expected %v to round trip, got %v (%v)", pattern, got, err)
+		}
+	}
+	if _, err := ParseIOPattern("mixed"); err == nil {
+		t.Error("Expected an unknown pattern to be rejected")
+	}
+}
+
+// BenchmarkHandleManager_SharedFileRead reads a shared file through many
+// handles, like many processes reading the same file, and reports how many
+// server requests each pass costs with and without the block cache. Every
+// pass starts with an empty cache.
+func BenchmarkHandleManager_SharedFileRead(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1MB
+	const handles = 16
+	const readSize = 128 * 1024
+
+	for _, bc := range []struct {
+		name    string
+		enabled bool
+	}{
+		{"uncached", false},
+		{"blockcache", true},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var requests int64
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&requests, 1)
+				serveRange(w, r, content)
+			}))
+			defer testServer.Close()
+
+			hm := NewHandleManager(agfs.NewClient(testServer.URL))
+			if bc.enabled {
+				hm.blocks = cache.NewBlockCache(128*1024, 64<<20, time.Minute)
+			}
+			for i := uint64(1); i <= handles; i++ {
+				hm.handles[i] = &handleInfo{htype: handleTypeRemote, agfsHandle: int64(i), path: "/shared", cacheBlocks: bc.enabled}
+			}
+
+			b.SetBytes(int64(len(content)) * handles)
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				hm.InvalidateBlocks("/shared")
+				var wg sync.WaitGroup
+				for i := uint64(1); i <= handles; i++ {
+					wg.Add(1)
+					go func(fuseHandle uint64) {
+						defer wg.Done()
+						for off := int64(0); off < int64(len(content)); off += readSize {
+							if _, err := hm.Read(context.Background(), fuseHandle, off, readSize); err != nil {
+								b.Error(err)
+								return
+							}
+						}
+					}(i)
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&requests))/float64(b.N), "requests/op")
+		})
+	}
+}
+
+// BenchmarkHandleManager_SequentialSmallReads reads a file 512 bytes at a
+// time through one handle and reports how many server requests each pass
+// costs with and without readahead
+func BenchmarkHandleManager_SequentialSmallReads(b *testing.B) {
+	content := bytes.Repeat([]byte("0123456789abcdef"), 64*1024) // 1MB
+	const readSize = 512
+
+	for _, bc := range []struct {
+		name string
+		size int
+	}{
+		{"no-readahead", 0},
+		{"readahead", 1 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			var requests int64
+			testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&requests, 1)
+				serveRange(w, r, content)
+			}))
+			defer testServer.Close()
+
+			hm := NewHandleManager(agfs.NewClient(testServer.URL))
+			hm.readaheadSize = bc.size
+
+			b.SetBytes(int64(len(content)))
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file", ra: readahead{enabled: true}}
+				for off := int64(0); off < int64(len(content)); off += readSize {
+					if _, err := hm.Read(context.Background(), 1, off, readSize); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&requests))/float64(b.N), "requests/op")
+		})
+	}
+}
+
+// handleKinds lists the features to disable on agfstest to get each kind of
+// handle: remote handles reading through streams, remote handles reading
+// with plain reads, and local handles
+var handleKinds = []struct {
+	name     string
+	disabled []string
+}{
+	{"stream", nil},
+	{"remote", []string{agfs.FeatureStream}},
+	{"local", []string{agfs.FeatureHandles}},
+}
+
+// newKindManager returns an agfstest server without the disabled features,
+// and a HandleManager configured for it, whose requests go through observe
+// first if it is set
+func newKindManager(t *testing.T, disabled []string, observe func(r *http.Request)) (*agfstest.Server, *HandleManager) {
+	t.Helper()
+	srv := agfstest.NewServer()
+	t.Cleanup(srv.Close)
+	srv.Disable(disabled...)
+
+	client := agfs.NewClient(srv.URL)
+	if observe != nil {
+		client = observedClient(t, srv, observe)
+	}
+	hm := NewHandleManager(client)
+	info, err := client.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerInfo failed: %v", err)
+	}
+	hm.configure(info)
+	return srv, hm
+}
+
+func TestHandleManager_ExclusiveOpenRace(t *testing.T) {
+	for _, kind := range handleKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			_, hm := newKindManager(t, kind.disabled, nil)
+
+			const openers = 10
+			var wg sync.WaitGroup
+			var winners, exists int32
+			flags := agfs.OpenFlagWriteOnly | agfs.OpenFlagCreate | agfs.OpenFlagExclusive
+			for i := 0; i < openers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					_, err := hm.Open(context.Background(), "/lock", flags, 0644)
+					switch {
+					case err == nil:
+						atomic.AddInt32(&winners, 1)
+					case ToErrno(err) == syscall.EEXIST:
+						atomic.AddInt32(&exists, 1)
+					default:
+						t.Errorf("Unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if winners != 1 || exists != openers-1 {
+				t.Errorf("Expected 1 winner and %d EEXIST, got %d and %d", openers-1, winners, exists)
+			}
+		})
+	}
+}
+
+func TestHandleManager_TruncateOnOpen(t *testing.T) {
+	for _, kind := range handleKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			srv, hm := newKindManager(t, kind.disabled, nil)
+			if _, err := agfs.NewClient(srv.URL).Write("/file", []byte("old content")); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			ctx := context.Background()
+
+			// A handle that read the file before it was truncated
+			reader, err := hm.Open(ctx, "/file", agfs.OpenFlagReadOnly, 0)
+			if err != nil {
+				t.Fatalf("Open failed: %v", err)
+			}
+			if data, err := hm.Read(ctx, reader, 0, 64); err != nil || string(data) != "old content" {
+				t.Fatalf("Expected the old content, got %q, %v", data, err)
+			}
+
+			fh, err := hm.Open(ctx, "/file", agfs.OpenFlagReadWrite|agfs.OpenFlagTruncate, 0644)
+			if err != nil {
+				t.Fatalf("Open with truncate failed: %v", err)
+			}
+			if data, err := hm.Read(ctx, fh, 0, 64); err != nil || len(data) != 0 {
+				t.Errorf("Expected the file to be empty before the first write, got %q, %v", data, err)
+			}
+			if data, err := hm.Read(ctx, reader, 0, 64); err != nil || len(data) != 0 {
+				t.Errorf("Expected the earlier handle to see the truncated file, got %q, %v", data, err)
+			}
+		})
+	}
+}
+
+func TestHandleManager_DurableWrites(t *testing.T) {
+	for _, kind := range handleKinds {
+		t.Run(kind.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var synced []bool
+			_, hm := newKindManager(t, kind.disabled, func(r *http.Request) {
+				if r.Method == http.MethodPut && (r.URL.Path == "/api/v1/files" || strings.HasSuffix(r.URL.Path, "/write")) {
+					mu.Lock()
+					synced = append(synced, r.URL.Query().Get("flags") == "sync" || r.URL.Query().Get("sync") == "true")
+					mu.Unlock()
+				}
+			})
+			ctx := context.Background()
+
+			for _, flags := range []uint32{syscall.O_WRONLY | syscall.O_CREAT, syscall.O_WRONLY | syscall.O_SYNC, syscall.O_WRONLY | syscall.O_DSYNC} {
+				fh, err := hm.Open(ctx, "/file", convertOpenFlags(flags), 0644)
+				if err != nil {
+					t.Fatalf("Open failed: %v", err)
+				}
+				if _, err := hm.Write(ctx, fh, []byte("data"), 0); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(synced) != 3 || synced[0] || !synced[1] || !synced[2] {
+				t.Errorf("Expected only O_SYNC and O_DSYNC writes to be durable, got %v", synced)
+			}
+		})
+	}
+}
+
+// blockingReader is a stream that produces nothing until it is closed
+type blockingReader struct {
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (r *blockingReader) Read(p []byte) (int, error) {
+	<-r.closed
+	return 0, io.EOF
+}
+
+func (r *blockingReader) Close() error {
+	r.once.Do(func() { close(r.closed) })
+	return nil
+}
+
+func TestHandleManager_CloseAllDuringOperations(t *testing.T) {
+	var serverCloses sync.Map
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			id := strings.TrimPrefix(r.URL.Path, "/api/v1/handles/")
+			if n, loaded := serverCloses.LoadOrStore(id, 1); loaded {
+				serverCloses.Store(id, n.(int)+1)
+			}
+		case strings.HasSuffix(r.URL.Path, "/read") || r.URL.Path == "/api/v1/files" && r.Method == http.MethodGet:
+			w.Write([]byte("data"))
+		default:
+			io.Copy(io.Discard, r.Body)
+			w.Write([]byte(`{"bytes_written":4}`))
+		}
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	var ids []uint64
+	for i := 1; i <= 4; i++ {
+		ids = append(ids, uint64(i))
+		hm.handles[uint64(i)] = &handleInfo{htype: handleTypeRemote, agfsHandle: int64(100 + i), path: "/remote"}
+	}
+	hm.handles[5] = &handleInfo{htype: handleTypeLocal, path: "/local"}
+	ids = append(ids, 5)
+	hm.nextHandle = 5
+	streams := []*blockingReader{{closed: make(chan struct{})}, {closed: make(chan struct{})}}
+	for _, reader := range streams {
+		ids = append(ids, addStreamHandle(hm, reader))
+	}
+
+	// Hammer every handle until it is gone
+	var wg sync.WaitGroup
+	var ops int64
+	for _, id := range ids {
+		wg.Add(1)
+		go func(id uint64) {
+			defer wg.Done()
+			for {
+				var err error
+				if _, err = hm.Read(context.Background(), id, 0, 4); err == nil {
+					_, err = hm.Write(context.Background(), id, []byte("data"), 0)
+				}
+				if err != nil {
+					if !errors.Is(err, errHandleClosing) && !strings.Contains(err.Error(), "not found") {
+						t.Errorf("handle %d: unexpected error %v", id, err)
+					}
+					return
+				}
+				atomic.AddInt64(&ops, 1)
+			}
+		}(id)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	var closers sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		closers.Add(1)
+		go func() {
+			defer closers.Done()
+			if err := hm.CloseAll(); err != nil {
+				t.Errorf("CloseAll failed: %v", err)
+			}
+		}()
+	}
+	closers.Wait()
+	wg.Wait()
+
+	if err := hm.CloseAll(); err != nil {
+		t.Errorf("repeated CloseAll failed: %v", err)
+	}
+	if count := hm.Count(); count != 0 {
+		t.Errorf("Expected 0 handles, got %d", count)
+	}
+	if atomic.LoadInt64(&ops) == 0 {
+		t.Error("Expected operations to run before CloseAll")
+	}
+	for i := 1; i <= 4; i++ {
+		id := strconv.Itoa(100 + i)
+		if n, _ := serverCloses.Load(id); n != 1 {
+			t.Errorf("Expected server handle %s closed once, got %v", id, n)
+		}
+	}
+	for i, reader := range streams {
+		select {
+		case <-reader.closed:
+		default:
+			t.Errorf("Expected stream %d to be closed", i)
+		}
+	}
+}
+
+// TestHandleManager_LockingStress interleaves reads, writes, truncations,
+// closes and CloseAll on the same handles of every type; run it with -race
+func TestHandleManager_LockingStress(t *testing.T) {
+	for _, disabled := range [][]string{nil, {agfs.FeatureStream}, {agfs.FeatureHandles}} {
+		srv := agfstest.NewServer()
+		defer srv.Close()
+		srv.Disable(disabled...)
+
+		client := agfs.NewClient(srv.URL)
+		hm := NewHandleManager(client)
+		info, err := client.ServerInfo(context.Background())
+		if err != nil {
+			t.Fatalf("ServerInfo failed: %v", err)
+		}
+		hm.configure(info)
+		hm.streamTimeout = 10 * time.Millisecond
+		hm.streamFirstTimeout = 10 * time.Millisecond
+		ctx := context.Background()
+		if _, err := client.Write("/file", bytes.Repeat([]byte("x"), 4096)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+
+		for round := 0; round < 5; round++ {
+			var ids []uint64
+			for i := 0; i < 4; i++ {
+				fh, err := hm.Open(ctx, "/file", agfs.OpenFlagReadWrite, 0)
+				if err != nil {
+					t.Fatalf("Open failed without %v: %v", disabled, err)
+				}
+				ids = append(ids, fh)
+			}
+
+			var wg sync.WaitGroup
+			for _, id := range ids {
+				for _, write := range []bool{false, true} {
+					wg.Add(1)
+					go func(id uint64, write bool) {
+						defer wg.Done()
+						for i := 0; ; i++ {
+							var err error
+							if write {
+								_, err = hm.Write(ctx, id, []byte("data"), int64(i%16)*4)
+							} else {
+								_, err = hm.Read(ctx, id, int64(i%16)*256, 256)
+							}
+							if err != nil {
+								if !errors.Is(err, errHandleClosing) && !strings.Contains(err.Error(), "not found") {
+									t.Errorf("handle %d without %v: unexpected error %v", id, disabled, err)
+								}
+								return
+							}
+							if i%8 == 0 {
+								hm.truncated("/file")
+							}
+						}
+					}(id, write)
+				}
+			}
+
+			time.Sleep(5 * time.Millisecond)
+			wg.Add(3)
+			go func() {
+				defer wg.Done()
+				if err := hm.Close(ctx, ids[0]); err != nil && !errors.Is(err, errHandleClosing) {
+					t.Errorf("Close failed without %v: %v", disabled, err)
+				}
+			}()
+			for i := 0; i < 2; i++ {
+				go func() {
+					defer wg.Done()
+					if err := hm.CloseAll(); err != nil {
+						t.Errorf("CloseAll failed without %v: %v", disabled, err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if n := hm.Count(); n != 0 {
+				t.Errorf("Expected no handles left without %v, got %d", disabled, n)
+			}
+			if n := srv.OpenHandles(); n != 0 {
+				t.Errorf("Expected every server handle closed without %v, got %d", disabled, n)
+			}
+		}
+	}
+}
+
+// TestHandleManager_ConcurrentFirstReadsLocal checks that reads racing the
+// first read of a local handle wait for it rather than consuming another
+// message of a queue-like file
+func TestHandleManager_ConcurrentFirstReadsLocal(t *testing.T) {
+	var reads atomic.Int64
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/files" {
+			http.NotFound(w, r)
+			return
+		}
+		n := reads.Add(1)
+		select {
+		case started <- struct{}{}:
+		default:
+		}
+		<-release
+		fmt.Fprintf(w, "message %d", n)
+	}))
+	defer server.Close()
+
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	hm.defaultType = handleTypeLocal
+	ctx := context.Background()
+	fuseHandle, err := hm.Open(ctx, "/queue/dequeue", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	results := make(chan []byte, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			data, err := hm.Read(ctx, fuseHandle, 0, 64)
+			if err != nil {
+				t.Errorf("Read failed: %v", err)
+			}
+			results <- data
+		}()
+	}
+	<-started
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	for i := 0; i < 2; i++ {
+		if data := <-results; string(data) != "message 1" {
+			t.Errorf("Expected both reads to return the first message, got %q", data)
+		}
+	}
+	if n := reads.Load(); n != 1 {
+		t.Errorf("Expected the file to be read once, got %d reads", n)
+	}
+	if err := hm.Close(ctx, fuseHandle); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+}
+
+// handleServer fakes a server that opens numbered handles and records closes
+func handleServer(t *testing.T) (*httptest.Server, *sync.Map) {
+	var next int64
+	var closed sync.Map
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/handles/open":
+			json.NewEncoder(w).Encode(agfs.HandleResponse{HandleID: atomic.AddInt64(&next, 1)})
+		case r.URL.Path == "/api/v1/stat":
+			// No access hints
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(agfs.ErrorResponse{Error: "not found"})
+		case r.Method == http.MethodDelete:
+			closed.Store(strings.TrimPrefix(r.URL.Path, "/api/v1/handles/"), true)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &closed
+}
+
+func newLimitedHandleManager(t *testing.T, max int, policy HandleLimitPolicy) (*HandleManager, *sync.Map) {
+	server, closed := handleServer(t)
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	hm.defaultType = handleTypeRemote
+	hm.maxHandles = max
+	hm.limitPolicy = policy
+	return hm, closed
+}
+
+func TestHandleManager_MaxOpenHandlesReject(t *testing.T) {
+	hm, _ := newLimitedHandleManager(t, 2, HandleLimitReject)
+	ctx := context.Background()
+
+	first, err := hm.Open(ctx, "/a", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := hm.Open(ctx, "/b", agfs.OpenFlagReadOnly, 0); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	if _, err := hm.Open(ctx, "/c", agfs.OpenFlagReadOnly, 0); ToErrno(err) != syscall.EMFILE {
+		t.Fatalf("Expected EMFILE beyond the limit, got %v", err)
+	}
+	if stats := hm.Stats(); stats.Open != 2 || stats.Max != 2 || stats.Rejected != 1 {
+		t.Errorf("Expected 2 of 2 open and 1 rejected, got %+v", stats)
+	}
+
+	// Closing a handle makes room again
+	if err := hm.Close(ctx, first); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := hm.Open(ctx, "/c", agfs.OpenFlagReadOnly, 0); err != nil {
+		t.Errorf("Expected open to succeed after a close, got %v", err)
+	}
+}
+
+func TestHandleManager_MaxOpenHandlesWait(t *testing.T) {
+	hm, _ := newLimitedHandleManager(t, 1, HandleLimitWait)
+	hm.limitWait = 50 * time.Millisecond
+	ctx := context.Background()
+
+	first, err := hm.Open(ctx, "/a", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// Nothing is closed in time
+	start := time.Now()
+	if _, err := hm.Open(ctx, "/b", agfs.OpenFlagReadOnly, 0); ToErrno(err) != syscall.EMFILE {
+		t.Fatalf("Expected EMFILE after waiting, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected open to wait for a free handle, returned after %v", elapsed)
+	}
+
+	// A handle closed while waiting is handed over
+	hm.limitWait = 5 * time.Second
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		hm.Close(ctx, first)
+	}()
+	if _, err := hm.Open(ctx, "/b", agfs.OpenFlagReadOnly, 0); err != nil {
+		t.Fatalf("Expected open to succeed once a handle was closed, got %v", err)
+	}
+	if stats := hm.Stats(); stats.Open != 1 || stats.Rejected != 1 {
+		t.Errorf("Expected 1 open and 1 rejected, got %+v", stats)
+	}
+}
+
+func TestHandleManager_MaxOpenHandlesEvict(t *testing.T) {
+	hm, closed := newLimitedHandleManager(t, 2, HandleLimitEvict)
+	ctx := context.Background()
+
+	busy, err := hm.Open(ctx, "/busy", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	idle, err := hm.Open(ctx, "/idle", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	// The busy handle is least recently used, but has an operation in flight
+	hm.mu.Lock()
+	busyInfo, _ := hm.acquire(busy)
+	busyInfo.lastUsed = time.Now().Add(-time.Hour)
+	hm.mu.Unlock()
+
+	if _, err := hm.Open(ctx, "/new", agfs.OpenFlagReadOnly, 0); err != nil {
+		t.Fatalf("Expected open to evict a handle, got %v", err)
+	}
+	hm.release(busyInfo)
+
+	if _, err := hm.Read(ctx, idle, 0, 10); ToErrno(err) != syscall.EBADF {
+		t.Errorf("Expected EBADF reading the evicted handle, got %v", err)
+	}
+	hm.mu.Lock()
+	if _, ok := hm.handles[busy]; !ok {
+		t.Error("Expected the busy handle to be kept")
+	}
+	hm.mu.Unlock()
+	if stats := hm.Stats(); stats.Open != 2 || stats.Evicted != 1 {
+		t.Errorf("Expected 2 open and 1 evicted, got %+v", stats)
+	}
+
+	// The kernel releasing the evicted handle is not an error, and the
+	// server-side handle was closed
+	if err := hm.Close(ctx, idle); err != nil {
+		t.Errorf("Expected closing an evicted handle to succeed, got %v", err)
+	}
+	if err := hm.CloseAll(); err != nil {
+		t.Fatalf("CloseAll failed: %v", err)
+	}
+	if _, ok := closed.Load("2"); !ok {
+		t.Error("Expected the evicted handle to be closed on the server")
+	}
+}
+
+func TestParseHandleLimitPolicy(t *testing.T) {
+	for _, policy := range []HandleLimitPolicy{HandleLimitReject, HandleLimitWait, HandleLimitEvict} {
+		if got, err := ParseHandleLimitPolicy(policy.String()); err != nil || got != policy {
+			t.Errorf("Expected %v to round trip, got %v, %v", policy, got, err)
+		}
+	}
+	if _, err := ParseHandleLimitPolicy("lru"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+// accessServer serves content for a file whose stat reports the given access
+// hint, through handles, streams and whole-file reads, and counts the
+// requests of each kind
+func accessServer(t *testing.T, access string, content []byte) (*httptest.Server, *sync.Map) {
+	var requests sync.Map
+	count := func(kind string) {
+		n, _ := requests.LoadOrStore(kind, new(atomic.Int64))
+		n.(*atomic.Int64).Add(1)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			count("stat")
+			json.NewEncoder(w).Encode(agfs.FileInfoResponse{
+				Name: "file",
+				Size: int64(len(content)),
+				Meta: agfs.MetaData{Content: map[string]string{agfs.MetaAccess: access}},
+			})
+		case r.URL.Path == "/api/v1/handles/open":
+			count("open")
+			json.NewEncoder(w).Encode(agfs.HandleResponse{HandleID: 7})
+		case r.URL.Path == "/api/v1/handles/7/stream":
+			count("stream")
+			w.Write(content)
+		case r.URL.Path == "/api/v1/handles/7/read":
+			count("ranged")
+			serveRange(w, r, content)
+		case r.URL.Path == "/api/v1/files" && r.Method == http.MethodGet:
+			count("whole")
+			serveRange(w, r, content)
+		case r.Method == http.MethodDelete:
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func requestCount(requests *sync.Map, kind string) int64 {
+	if n, ok := requests.Load(kind); ok {
+		return n.(*atomic.Int64).Load()
+	}
+	return 0
+}
+
+func TestHandleManager_AccessRandomUsesRangedReads(t *testing.T) {
+	server, requests := accessServer(t, agfs.AccessRandom, sparseContent)
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	ctx := context.Background()
+
+	fuseHandle, err := hm.Open(ctx, "/file", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+	checkReadSemantics(t, hm, fuseHandle)
+
+	if n := requestCount(requests, "stream"); n != 0 {
+		t.Errorf("Expected no stream for a random access file, got %d", n)
+	}
+	if n := requestCount(requests, "ranged"); n != int64(len(readSemanticsCases)) {
+		t.Errorf("Expected a ranged read per read, got %d", n)
+	}
+}
+
+func TestHandleManager_AccessConsumeOnceBuffersOneRead(t *testing.T) {
+	message := []byte(`{"id":"1","data":"hello"}`)
+	server, requests := accessServer(t, agfs.AccessConsumeOnce, message)
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	ctx := context.Background()
+
+	fuseHandle, err := hm.Open(ctx, "/queue/dequeue", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+
+	// Small reads at increasing offsets, as the kernel issues them
+	var got []byte
+	for offset := int64(0); ; offset += 8 {
+		data, err := hm.Read(ctx, fuseHandle, offset, 8)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if len(data) == 0 {
+			break
+		}
+		got = append(got, data...)
+	}
+	if !bytes.Equal(got, message) {
+		t.Errorf("Expected %q, got %q", message, got)
+	}
+
+	if n := requestCount(requests, "whole"); n != 1 {
+		t.Errorf("Expected the file to be read once, got %d reads", n)
+	}
+	if n := requestCount(requests, "open"); n != 0 {
+		t.Errorf("Expected no server-side handle for a consume-once file, got %d", n)
+	}
+}
+
+func TestHandleManager_AccessHintIgnoredForWrites(t *testing.T) {
+	server, requests := accessServer(t, agfs.AccessConsumeOnce, nil)
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	ctx := context.Background()
+
+	fuseHandle, err := hm.Open(ctx, "/queue/enqueue", agfs.OpenFlagWriteOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+
+	if n := requestCount(requests, "stat"); n != 0 {
+		t.Errorf("Expected no stat for a write-only open, got %d", n)
+	}
+	if n := requestCount(requests, "open"); n != 1 {
+		t.Errorf("Expected a server-side handle, got %d opens", n)
+	}
+}
+
+func TestHandleManager_DirectRemoteBypassesCaches(t *testing.T) {
+	server, requests := accessServer(t, "", sparseContent)
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	hm.blocks = cache.NewBlockCache(1000, 1<<20, time.Minute)
+	hm.readaheadSize = 1 << 20
+	ctx := context.Background()
+
+	fuseHandle, err := hm.OpenDirect(ctx, "/file", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("OpenDirect failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+	if hm.CacheBlocks(fuseHandle) {
+		t.Error("Expected a direct handle to refuse the block cache")
+	}
+
+	// Reading twice, the second pass must not be served locally
+	checkReadSemantics(t, hm, fuseHandle)
+	checkReadSemantics(t, hm, fuseHandle)
+
+	if n := requestCount(requests, "stream"); n != 0 {
+		t.Errorf("Expected no stream for a direct handle, got %d", n)
+	}
+	if n := requestCount(requests, "ranged"); n != 2*int64(len(readSemanticsCases)) {
+		t.Errorf("Expected a ranged read per read, got %d", n)
+	}
+	if stats := hm.blocks.Stats(); stats.Entries != 0 {
+		t.Errorf("Expected a direct handle not to populate the block cache, got %d blocks", stats.Entries)
+	}
+	if info := hm.handles[fuseHandle]; info.ra.data != nil || info.streamBuffer != nil {
+		t.Error("Expected a direct handle not to buffer data")
+	}
+}
+
+func TestHandleManager_DirectLocalReadsRanges(t *testing.T) {
+	server, requests := accessServer(t, "", sparseContent)
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	hm.defaultType = handleTypeLocal
+	hm.direct = true
+	ctx := context.Background()
+
+	fuseHandle, err := hm.Open(ctx, "/file", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+	checkReadSemantics(t, hm, fuseHandle)
+
+	if n := requestCount(requests, "whole"); n != int64(len(readSemanticsCases)) {
+		t.Errorf("Expected a ranged read per read, got %d", n)
+	}
+	if info := hm.handles[fuseHandle]; !info.direct || info.readBuffer != nil {
+		t.Errorf("Expected Config.DirectIO to open a direct handle without a read buffer")
+	}
+}
+
+func TestHandleManager_DirectConsumeOnceStillBuffered(t *testing.T) {
+	message := []byte(`{"id":"1","data":"hello"}`)
+	server, requests := accessServer(t, agfs.AccessConsumeOnce, message)
+	hm := NewHandleManager(agfs.NewClient(server.URL))
+	ctx := context.Background()
+
+	fuseHandle, err := hm.OpenDirect(ctx, "/queue/dequeue", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("OpenDirect failed: %v", err)
+	}
+	defer hm.Close(ctx, fuseHandle)
+	for offset := int64(0); offset < int64(len(message))+8; offset += 8 {
+		if _, err := hm.Read(ctx, fuseHandle, offset, 8); err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+	}
+	if n := requestCount(requests, "whole"); n != 1 {
+		t.Errorf("Expected a consume-once file to be read once, got %d reads", n)
+	}
+}
+
+func TestParseStalePolicy(t *testing.T) {
+	for _, policy := range []StalePolicy{StaleReread, StaleError} {
+		if got, err := ParseStalePolicy(policy.String()); err != nil || got != policy {
+			t.Errorf("Expected %v to round trip, got %v, %v", policy, got, err)
+		}
+	}
+	if _, err := ParseStalePolicy("ignore"); err == nil {
+		t.Error("Expected an error for an unknown policy")
+	}
+}
+
+// versionedFile is a file another client may change on the server, with an
+// etag bumped on every change
+type versionedFile struct {
+	mu      sync.Mutex
+	content []byte
+	version int
+}
+
+func (f *versionedFile) change(content string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.content = []byte(content)
+	f.version++
+}
+
+func (f *versionedFile) etag(ctx context.Context, path string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return fmt.Sprintf("v%d", f.version)
+}
+
+func testStalePolicy(t *testing.T, policy StalePolicy) {
+	file := &versionedFile{content: []byte("version one")}
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file.mu.Lock()
+		defer file.mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/write") {
+			data, _ := io.ReadAll(r.Body)
+			file.content = data
+			file.version++
+			json.NewEncoder(w).Encode(map[string]int{"bytes_written": len(data)})
+			return
+		}
+		serveRange(w, r, file.content)
+	}))
+	defer testServer.Close()
+
+	hm := NewHandleManager(agfs.NewClient(testServer.URL))
+	hm.blocks = cache.NewBlockCache(1000, 1<<20, time.Minute)
+	hm.etag = file.etag
+	hm.stalePolicy = policy
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file", cacheBlocks: true, etag: "v0"}
+	ctx := context.Background()
+
+	if data, err := hm.Read(ctx, 1, 0, 100); err != nil || string(data) != "version one" {
+		t.Fatalf("Expected the first version, got %q, %v", data, err)
+	}
+
+	// A write through this mount isn't a change made by someone else
+	if _, err := hm.Write(ctx, 1, []byte("version two"), 0); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, err := hm.Read(ctx, 1, 0, 100); err != nil || string(data) != "version two" {
+		t.Fatalf("Expected the written version, got %q, %v", data, err)
+	}
+
+	// Another mount changes the file
+	file.change("version three")
+	data, err := hm.Read(ctx, 1, 0, 100)
+	if policy == StaleReread {
+		if err != nil || string(data) != "version three" {
+			t.Errorf("Expected the file to be read again, got %q, %v", data, err)
+		}
+		return
+	}
+	if !errors.Is(err, syscall.ESTALE) || ToErrno(err) != syscall.ESTALE {
+		t.Fatalf("Expected ESTALE, got %q, %v", data, err)
+	}
+	if _, err := hm.Read(ctx, 1, 0, 100); !errors.Is(err, syscall.ESTALE) {
+		t.Errorf("Expected the handle to stay stale, got %v", err)
+	}
+}
+
+func TestHandleManager_StaleReread(t *testing.T) {
+	testStalePolicy(t, StaleReread)
+}
+
+func TestHandleManager_StaleError(t *testing.T) {
+	testStalePolicy(t, StaleError)
+}
+
+// countingServer serves srv, counting the handle writes it receives
+func countingServer(t *testing.T, srv *agfstest.Server, writes *atomic.Int32) *agfs.Client {
+	return observedClient(t, srv, func(r *http.Request) {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/write") {
+			writes.Add(1)
+		}
+	})
+}
+
+// observedClient returns a client of srv whose requests are passed to
+// observe before srv serves them
+func observedClient(t *testing.T, srv *agfstest.Server, observe func(r *http.Request)) *agfs.Client {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		observe(r)
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(proxy.Close)
+	return agfs.NewClient(proxy.URL)
+}
+
+func TestWriteBufferMerge(t *testing.T) {
+	var wb writeBuffer
+	wb.add(10, []byte("cd"))
+	wb.add(0, []byte("xx"))
+	wb.add(12, []byte("ef"))  // Continues the run at 10
+	wb.add(8, []byte("ab"))   // Adjacent to it from below
+	wb.add(11, []byte("DE"))  // Rewrites its middle
+	wb.add(20, []byte("zzz")) // Separate
+	wb.add(1, []byte("yyyy")) // Overlaps the first run and extends it
+
+	want := []writeRun{{0, []byte("xyyyy")}, {8, []byte("abcDEf")}, {20, []byte("zzz")}}
+	if len(wb.runs) != len(want) {
+		t.Fatalf("Expected %d runs, got %d", len(want), len(wb.runs))
+	}
+	for i, r := range wb.runs {
+		if r.offset != want[i].offset || !bytes.Equal(r.data, want[i].data) {
+			t.Errorf("Run %d: expected %q at %d, got %q at %d", i, want[i].data, want[i].offset, r.data, r.offset)
+		}
+	}
+	if wb.size != 14 {
+		t.Errorf("Expected 14 bytes held, got %d", wb.size)
+	}
+
+	// A write spanning several runs merges them all
+	wb.add(4, bytes.Repeat([]byte("-"), 17))
+	if len(wb.runs) != 1 || string(wb.runs[0].data) != "xyyy-----------------zz" || wb.size != 23 {
+		t.Errorf("Expected a single merged run, got %+v", wb.runs)
+	}
+}
+
+func TestHandleManager_WriteCommitWindow(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	var writes atomic.Int32
+	client := countingServer(t, srv, &writes)
+	if err := client.Create("/file"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	hm := NewHandleManager(client)
+	hm.commitWindow = time.Hour
+	hm.commitSize = defaultWriteCommitSize
+	ctx := context.Background()
+
+	fh, err := hm.Open(ctx, "/file", agfs.OpenFlagWriteOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	var want []byte
+	for i := 0; i < 100; i++ {
+		piece := []byte(fmt.Sprintf("line %02d\n", i))
+		if n, err := hm.Write(ctx, fh, piece, int64(len(want))); err != nil || n != len(piece) {
+			t.Fatalf("Write %d failed: %d, %v", i, n, err)
+		}
+		want = append(want, piece...)
+	}
+	if n := writes.Load(); n != 0 {
+		t.Fatalf("Expected the writes held for the window, got %d server writes", n)
+	}
+
+	if err := hm.Close(ctx, fh); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if n := writes.Load(); n != 1 {
+		t.Errorf("Expected a single coalesced server write, got %d", n)
+	}
+	if data, err := client.Read("/file", 0, -1); err != nil || !bytes.Equal(data, want) {
+		t.Errorf("Expected the written data, got %q (%v)", data, err)
+	}
+}
+
+func TestHandleManager_WriteCommitSync(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	var writes atomic.Int32
+	client := countingServer(t, srv, &writes)
+	if _, err := client.Write("/file", []byte("0123456789")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// Streaming handles read what the stream had when it was opened
+	srv.Disable(agfs.FeatureStream)
+	hm := NewHandleManager(client)
+	hm.commitWindow = time.Hour
+	hm.commitSize = defaultWriteCommitSize
+	ctx := context.Background()
+
+	fh, err := hm.Open(ctx, "/file", agfs.OpenFlagReadWrite, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fh)
+	for i, piece := range []string{"ab", "cd"} {
+		if _, err := hm.Write(ctx, fh, []byte(piece), int64(2*i)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	// Sync writes what is held right away
+	if err := hm.Sync(ctx, fh); err != nil {
+		t.Fatalf("Sync failed: %v", err)
+	}
+	if n := writes.Load(); n != 1 {
+		t.Errorf("Expected Sync to flush a single write, got %d", n)
+	}
+	if data, err := client.Read("/file", 0, -1); err != nil || string(data) != "abcd456789" {
+		t.Errorf("Expected the synced data on the server, got %q (%v)", data, err)
+	}
+
+	// So do Flush and reads through the handle, which see the writes
+	if _, err := hm.Write(ctx, fh, []byte("EF"), 4); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, err := hm.Read(ctx, fh, 0, 10); err != nil || string(data) != "abcdEF6789" {
+		t.Errorf("Expected the read to see the held write, got %q (%v)", data, err)
+	}
+	if _, err := hm.Write(ctx, fh, []byte("GH"), 6); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := hm.Flush(ctx, fh); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if n := writes.Load(); n != 3 {
+		t.Errorf("Expected 3 server writes, got %d", n)
+	}
+	if err := hm.Flush(ctx, fh); err != nil || writes.Load() != 3 {
+		t.Errorf("Expected a flush with nothing held to write nothing, got %d writes (%v)", writes.Load(), err)
+	}
+}
+
+func TestHandleManager_WriteCommitTriggers(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	var writes atomic.Int32
+	client := countingServer(t, srv, &writes)
+	if err := client.Create("/file"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	hm := NewHandleManager(client)
+	hm.commitWindow = 20 * time.Millisecond
+	hm.commitSize = 64
+	ctx := context.Background()
+
+	fh, err := hm.Open(ctx, "/file", agfs.OpenFlagWriteOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer hm.Close(ctx, fh)
+
+	// Reaching the size threshold writes at once
+	for i := 0; i < 8; i++ {
+		if _, err := hm.Write(ctx, fh, bytes.Repeat([]byte{'a' + byte(i)}, 8), int64(8*i)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if n := writes.Load(); n != 1 {
+		t.Errorf("Expected 64 bytes to be written at once, got %d server writes", n)
+	}
+
+	// The window elapsing writes the rest
+	if _, err := hm.Write(ctx, fh, []byte("tail"), 64); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for writes.Load() != 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if data, err := client.Read("/file", 64, -1); err != nil || string(data) != "tail" {
+		t.Errorf("Expected the window to write the held data, got %q (%v) after %d writes", data, err, writes.Load())
+	}
+}
+
+func TestHandleManager_WriteCommitWriteThrough(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	var writes atomic.Int32
+	client := countingServer(t, srv, &writes)
+	if err := client.Create("/file"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	hm := NewHandleManager(client)
+	hm.commitWindow = time.Hour
+	hm.commitSize = defaultWriteCommitSize
+	ctx := context.Background()
+
+	for _, flags := range []agfs.OpenFlag{agfs.OpenFlagWriteOnly | agfs.OpenFlagSync, agfs.OpenFlagWriteOnly | agfs.OpenFlagAppend} {
+		fh, err := hm.Open(ctx, "/file", flags, 0)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		before := writes.Load()
+		if _, err := hm.Write(ctx, fh, []byte("x"), 0); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if writes.Load() != before+1 {
+			t.Errorf("Expected flags %v to write through", flags)
+		}
+		hm.Close(ctx, fh)
+	}
+
+	fh, err := hm.OpenDirect(ctx, "/file", agfs.OpenFlagWriteOnly, 0)
+	if err != nil {
+		t.Fatalf("OpenDirect failed: %v", err)
+	}
+	defer hm.Close(ctx, fh)
+	before := writes.Load()
+	if _, err := hm.Write(ctx, fh, []byte("x"), 0); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if writes.Load() != before+1 {
+		t.Error("Expected a direct handle to write through")
+	}
+}
+
+// partialServer serves a file from content, answering every read with at
+// most readChunk bytes and taking at most writeChunk bytes of every write,
+// as plugins may
+func partialServer(t *testing.T, content []byte, readChunk, writeChunk int) *httptest.Server {
+	var mu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/read"):
+			size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+			if size > readChunk {
+				size = readChunk
+			}
+			end := offset + size
+			if end > len(content) {
+				end = len(content)
+			}
+			if offset < end {
+				w.Write(content[offset:end])
+			}
+		case strings.HasSuffix(r.URL.Path, "/write"):
+			data, _ := io.ReadAll(r.Body)
+			if len(data) > writeChunk {
+				data = data[:writeChunk]
+			}
+			copy(content[offset:], data)
+			json.NewEncoder(w).Encode(map[string]int{"bytes_written": len(data)})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHandleManager_PartialReads(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100)
+	hm := NewHandleManager(agfs.NewClient(partialServer(t, content, 100, 100).URL))
+	hm.blocks = cache.NewBlockCache(256, 1<<20, time.Minute)
+	hm.readaheadSize = 1 << 20
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/plain"}
+	hm.handles[2] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/blocks", cacheBlocks: true}
+	hm.handles[3] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/readahead", ra: readahead{enabled: true}}
+
+	for fuseHandle, name := range map[uint64]string{1: "plain", 2: "block cache", 3: "readahead"} {
+		// Read like the kernel does, on from where the last read stopped
+		var got []byte
+		for reads := 0; ; reads++ {
+			data, err := hm.Read(context.Background(), fuseHandle, int64(len(got)), 300)
+			if err != nil {
+				t.Fatalf("%s: Read failed: %v", name, err)
+			}
+			if len(data) == 0 || reads > 100 {
+				break
+			}
+			if fuseHandle == 1 && len(data) != 100 {
+				t.Errorf("%s: Expected the 100 bytes the server returned reported, got %d", name, len(data))
+			}
+			got = append(got, data...)
+		}
+		if !bytes.Equal(got, content) {
+			t.Errorf("%s: Expected the whole file read, got %d bytes", name, len(got))
+		}
+	}
+}
+
+func TestHandleManager_PartialWrites(t *testing.T) {
+	content := make([]byte, 1000)
+	hm := NewHandleManager(agfs.NewClient(partialServer(t, content, 1000, 100).URL))
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file", flags: agfs.OpenFlagWriteOnly}
+	ctx := context.Background()
+	data := bytes.Repeat([]byte("abcdefghij"), 30)
+
+	// A write through reports what the server took, the kernel writes the rest
+	n, err := hm.Write(ctx, 1, data, 0)
+	if err != nil || n != 100 {
+		t.Fatalf("Expected 100 bytes written, got %d, %v", n, err)
+	}
+	for off := n; off < len(data); off += n {
+		if n, err = hm.Write(ctx, 1, data[off:], int64(off)); err != nil || n <= 0 {
+			t.Fatalf("Write at %d failed: %d, %v", off, n, err)
+		}
+	}
+	if !bytes.Equal(content[:300], data) {
+		t.Errorf("Expected the data written, got %q", content[:300])
+	}
+
+	// Held writes were acknowledged whole, so flushing them writes them all
+	hm.commitWindow = time.Hour
+	hm.commitSize = defaultWriteCommitSize
+	data = bytes.Repeat([]byte("ABCDEFGHIJ"), 30)
+	if n, err := hm.Write(ctx, 1, data, 300); err != nil || n != len(data) {
+		t.Fatalf("Expected the held write acknowledged, got %d, %v", n, err)
+	}
+	if err := hm.Flush(ctx, 1); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if !bytes.Equal(content[300:600], data) {
+		t.Errorf("Expected the held data written, got %q", content[300:600])
+	}
+}
+
+func TestHandleManager_WriteTakingNothingFails(t *testing.T) {
+	hm := NewHandleManager(agfs.NewClient(partialServer(t, make([]byte, 10), 10, 0).URL))
+	hm.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file", flags: agfs.OpenFlagWriteOnly}
+	_, err := hm.Write(context.Background(), 1, []byte("data"), 0)
+	if !errors.Is(err, io.ErrShortWrite) || ToErrno(err) != syscall.EIO {
+		t.Errorf("Expected a short write failing with EIO, got %v", err)
+	}
+}
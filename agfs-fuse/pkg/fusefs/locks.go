@@ -0,0 +1,140 @@
+package fusefs
+
+import (
+	"context"
+	"math"
+	"syscall"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+var _ = (fs.FileGetlker)((*AGFSFileHandle)(nil))
+var _ = (fs.FileSetlker)((*AGFSFileHandle)(nil))
+var _ = (fs.FileSetlkwer)((*AGFSFileHandle)(nil))
+
+// Getlk reports a lock that would conflict with lk, or F_UNLCK if there is
+// none. The pid of the holder isn't known across clients and is reported
+// as 0.
+func (fh *AGFSFileHandle) Getlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32, out *fuse.FileLock) syscall.Errno {
+	if !fh.node.root.locks {
+		return syscall.ENOSYS
+	}
+	ctx, span := fh.startSpan(ctx, "Getlk")
+	defer span.End()
+
+	typ, ok := lockType(lk.Typ)
+	if !ok {
+		return syscall.EINVAL
+	}
+	conflict, err := fh.node.root.client.WithContext(ctx).TestLock(fh.node.getPath(), owner, typ, lockRange(lk))
+	if err != nil {
+		return ToErrno(err)
+	}
+
+	*out = fuse.FileLock{Typ: syscall.F_UNLCK}
+	if conflict != nil {
+		out.Start = uint64(conflict.Start)
+		out.End = uint64(conflict.End)
+		out.Typ = syscall.F_RDLCK
+		if conflict.Type == agfs.LockExclusive {
+			out.Typ = syscall.F_WRLCK
+		}
+	}
+	return 0
+}
+
+// Setlk sets or releases a lock, failing with EAGAIN if another owner
+// holds a conflicting one
+func (fh *AGFSFileHandle) Setlk(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	return fh.setlk(ctx, "Setlk", owner, lk, false)
+}
+
+// Setlkw sets or releases a lock, waiting for conflicting locks to be
+// released. The wait is interrupted by signals.
+func (fh *AGFSFileHandle) Setlkw(ctx context.Context, owner uint64, lk *fuse.FileLock, flags uint32) syscall.Errno {
+	return fh.setlk(ctx, "Setlkw", owner, lk, true)
+}
+
+func (fh *AGFSFileHandle) setlk(ctx context.Context, op string, owner uint64, lk *fuse.FileLock, wait bool) syscall.Errno {
+	if !fh.node.root.locks {
+		return syscall.ENOSYS
+	}
+	ctx, span := fh.startSpan(ctx, op)
+	defer span.End()
+
+	typ, ok := lockType(lk.Typ)
+	if !ok {
+		return syscall.EINVAL
+	}
+	// Record the owner before the lock is granted, so that a Release racing
+	// with a blocked Setlkw still releases it
+	if typ != agfs.LockUnlock {
+		fh.addLockOwner(owner)
+	}
+	err := fh.node.root.client.WithContext(ctx).Lock(fh.node.getPath(), owner, typ, lockRange(lk), wait)
+	if err != nil {
+		if ctx.Err() != nil {
+			return syscall.EINTR
+		}
+		return ToErrno(err)
+	}
+	return 0
+}
+
+func (fh *AGFSFileHandle) addLockOwner(owner uint64) {
+	fh.mu.Lock()
+	defer fh.mu.Unlock()
+	if fh.lockOwners == nil {
+		fh.lockOwners = make(map[uint64]struct{})
+	}
+	fh.lockOwners[owner] = struct{}{}
+}
+
+// releaseLocks releases the locks taken through the handle. The kernel
+// unlocks POSIX locks when the file is closed, but flock locks are only
+// dropped with the last reference to the file, which is the release.
+func (fh *AGFSFileHandle) releaseLocks(ctx context.Context) {
+	fh.mu.Lock()
+	owners := fh.lockOwners
+	fh.lockOwners = nil
+	fh.mu.Unlock()
+
+	if len(owners) == 0 {
+		return
+	}
+	path := fh.node.getPath()
+	client := fh.node.root.clientFor(ctx)
+	for owner := range owners {
+		if err := client.Unlock(path, owner, agfs.WholeFile); err != nil {
+			// The server drops them once the mount stops renewing its session
+			fh.node.root.logger.Warnf("[file] Failed to release locks on %s: %v", path, err)
+		}
+	}
+}
+
+// lockType maps a fcntl lock type to an AGFS lock type
+func lockType(typ uint32) (agfs.LockType, bool) {
+	switch typ {
+	case syscall.F_RDLCK:
+		return agfs.LockShared, true
+	case syscall.F_WRLCK:
+		return agfs.LockExclusive, true
+	case syscall.F_UNLCK:
+		return agfs.LockUnlock, true
+	}
+	return "", false
+}
+
+// lockRange returns the range of lk, whose End is inclusive like AGFS's
+func lockRange(lk *fuse.FileLock) agfs.LockRange {
+	rng := agfs.LockRange{Start: math.MaxInt64, End: math.MaxInt64}
+	if lk.Start < math.MaxInt64 {
+		rng.Start = int64(lk.Start)
+	}
+	if lk.End < math.MaxInt64 {
+		rng.End = int64(lk.End)
+	}
+	return rng
+}
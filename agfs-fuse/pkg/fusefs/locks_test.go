@@ -0,0 +1,141 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// newLockTestServer returns a server with a whole-file lock table keyed by
+// session and owner, ignoring ranges
+func newLockTestServer(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	held := make(map[string]agfs.LockType) // "session/owner" -> type
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		holder := q.Get("session") + "/" + q.Get("owner")
+		typ := agfs.LockType(q.Get("type"))
+
+		mu.Lock()
+		defer mu.Unlock()
+		conflict := func() *agfs.LockInfo {
+			for h, t := range held {
+				if h != holder && typ != agfs.LockUnlock && (typ == agfs.LockExclusive || t == agfs.LockExclusive) {
+					return &agfs.LockInfo{Type: t, Start: 0, End: 1<<63 - 1}
+				}
+			}
+			return nil
+		}
+
+		switch {
+		case r.URL.Path == "/api/v1/capabilities":
+			json.NewEncoder(w).Encode(agfs.CapabilitiesResponse{Version: "1.4.0", Features: []string{agfs.FeatureLocks}})
+		case r.URL.Path == "/api/v1/locks/renew":
+			json.NewEncoder(w).Encode(map[string]int{"locks": len(held), "ttl": 30})
+		case r.URL.Path == "/api/v1/locks" && r.Method == http.MethodGet:
+			json.NewEncoder(w).Encode(agfs.LockResponse{Lock: conflict(), TTL: 30})
+		case r.URL.Path == "/api/v1/locks" && r.Method == http.MethodPost:
+			if c := conflict(); c != nil {
+				w.WriteHeader(http.StatusLocked)
+				json.NewEncoder(w).Encode(map[string]interface{}{"error": "lock held by another owner", "lock": c})
+				return
+			}
+			if typ == agfs.LockUnlock {
+				delete(held, holder)
+			} else {
+				held[holder] = typ
+			}
+			json.NewEncoder(w).Encode(agfs.LockResponse{TTL: 30})
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// openLockTestHandle mounts a client of server and opens a handle on its root
+func openLockTestHandle(t *testing.T, server *httptest.Server) *AGFSFileHandle {
+	root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Second})
+	t.Cleanup(func() { root.Close() })
+
+	handle, err := root.handles.Open(context.Background(), "/", agfs.OpenFlagReadOnly, 0)
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	return &AGFSFileHandle{node: &AGFSNode{root: root}, handle: handle}
+}
+
+func TestFileHandleLocksConflictAcrossClients(t *testing.T) {
+	server := newLockTestServer(t)
+	a := openLockTestHandle(t, server)
+	b := openLockTestHandle(t, server)
+	ctx := context.Background()
+
+	whole := &fuse.FileLock{Start: 0, End: 1<<63 - 1, Typ: syscall.F_RDLCK}
+	if errno := a.Setlk(ctx, 1, whole, 0); errno != 0 {
+		t.Fatalf("Setlk failed: %v", errno)
+	}
+	if errno := b.Setlk(ctx, 1, whole, 0); errno != 0 {
+		t.Errorf("Expected shared locks not to conflict, got %v", errno)
+	}
+
+	exclusive := &fuse.FileLock{Start: 0, End: 1<<63 - 1, Typ: syscall.F_WRLCK}
+	if errno := b.Setlk(ctx, 2, exclusive, 0); errno != syscall.EAGAIN {
+		t.Errorf("Expected EAGAIN for a conflicting lock, got %v", errno)
+	}
+	var out fuse.FileLock
+	if errno := b.Getlk(ctx, 2, exclusive, 0, &out); errno != 0 || out.Typ != syscall.F_RDLCK {
+		t.Errorf("Expected Getlk to report the shared lock, got %+v, %v", out, errno)
+	}
+
+	// A blocking lock is interrupted by the kernel cancelling the request
+	waitCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if errno := b.Setlkw(waitCtx, 2, exclusive, 0); errno != syscall.EINTR {
+		t.Errorf("Expected EINTR for an interrupted wait, got %v", errno)
+	}
+}
+
+func TestFileHandleReleaseUnlocks(t *testing.T) {
+	server := newLockTestServer(t)
+	a := openLockTestHandle(t, server)
+	b := openLockTestHandle(t, server)
+	ctx := context.Background()
+
+	exclusive := &fuse.FileLock{Start: 0, End: 1<<63 - 1, Typ: syscall.F_WRLCK}
+	if errno := a.Setlk(ctx, 1, exclusive, 0); errno != 0 {
+		t.Fatalf("Setlk failed: %v", errno)
+	}
+
+	// b blocks until a's handle is closed
+	done := make(chan syscall.Errno, 1)
+	go func() { done <- b.Setlkw(ctx, 1, exclusive, 0) }()
+
+	select {
+	case errno := <-done:
+		t.Fatalf("Expected Setlkw to block, got %v", errno)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if errno := a.Release(ctx); errno != 0 {
+		t.Fatalf("Release failed: %v", errno)
+	}
+	select {
+	case errno := <-done:
+		if errno != 0 {
+			t.Errorf("Expected the lock once a's handle was closed, got %v", errno)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Setlkw still blocked after a's handle was closed")
+	}
+}
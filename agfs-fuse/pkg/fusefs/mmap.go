@@ -0,0 +1,107 @@
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// pageSize is the unit in which the kernel reads files mapped into memory
+var pageSize = int64(os.Getpagesize())
+
+// isPageRead reports whether a read of size bytes at offset covers whole
+// pages, as the kernel's reads through the page cache do
+func isPageRead(offset int64, size int) bool {
+	return size > 0 && offset%pageSize == 0 && int64(size)%pageSize == 0
+}
+
+// SetMmap makes reads of a remote handle fetch whole pages, for files mapped
+// into memory, or stops it. Page faults read a few pages at scattered
+// offsets, which readahead would take for seeks; a read is instead widened
+// to the pages it touches. It returns false if the handle isn't remote, is
+// direct, or uses the block cache, whose reads already cover whole blocks.
+func (hm *HandleManager) SetMmap(fuseHandle uint64, mmap bool) bool {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	info, ok := hm.handles[fuseHandle]
+	if !ok || info.htype != handleTypeRemote || info.direct || info.cacheBlocks {
+		return false
+	}
+	if info.mmap != mmap {
+		info.mmap = mmap
+		if mmap {
+			hm.logger.Debugf("Reading %s (handle=%d) in pages, as it is mapped into memory", info.path, fuseHandle)
+		} else {
+			hm.logger.Debugf("Reading %s (handle=%d) as a file again", info.path, fuseHandle)
+		}
+	}
+	return true
+}
+
+// readPages reads size bytes of a remote handle from offset, fetching the
+// pages the range touches in a single request and returning the part asked
+func (hm *HandleManager) readPages(ctx context.Context, agfsHandle int64, offset int64, size int) ([]byte, error) {
+	if size <= 0 {
+		return []byte{}, nil
+	}
+	start := offset - offset%pageSize
+	end := offset + int64(size)
+	if rem := end % pageSize; rem != 0 {
+		end += pageSize - rem
+	}
+	data, err := readHandleFull(hm.clientFor(ctx), agfsHandle, start, int(end-start))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handle: %w", err)
+	}
+	skip := offset - start
+	if skip >= int64(len(data)) {
+		return []byte{}, nil
+	}
+	data = data[skip:]
+	if len(data) > size {
+		data = data[:size]
+	}
+	return data, nil
+}
+
+// Page faults of a memory mapping are read around the faulting page, in
+// windows of the kernel's readahead size (128 KiB by default). Reads of
+// whole pages, at least faultReadMin bytes long and not continuing the
+// previous one, look like faults; mmapFaults of them in a row mark the
+// handle as mapped.
+const (
+	faultReadMin = 64 * 1024
+	mmapFaults   = 3
+)
+
+// detectMmap marks the handle as mapped into memory once its reads look like
+// page faults, and unmarks it at the first read that doesn't: files are
+// opened with FOPEN_DIRECT_IO, so the kernel only reads them through the page
+// cache to fault in the pages of a mapping, while read(2) asks for any size.
+// Small random reads of whole pages, like a database's, never mark it, and
+// sequential reads go back to readahead. Handles that can't be marked stop
+// being watched.
+func (fh *AGFSFileHandle) detectMmap(off int64, size int) {
+	fh.mu.Lock()
+	if fh.noMmap {
+		fh.mu.Unlock()
+		return
+	}
+	fault := isPageRead(off, size) && size >= faultReadMin && off != fh.next
+	fh.next = off + int64(size)
+	if fault {
+		fh.faults++
+	} else {
+		fh.faults = 0
+	}
+	mmap := fh.faults >= mmapFaults
+	changed := mmap != fh.mmap
+	fh.mmap = mmap
+	fh.mu.Unlock()
+
+	if changed && !fh.node.root.handles.SetMmap(fh.handle, mmap) {
+		fh.mu.Lock()
+		fh.noMmap = true
+		fh.mu.Unlock()
+	}
+}
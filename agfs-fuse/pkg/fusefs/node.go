@@ -8,7 +8,6 @@ import (
 	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
 	"github.com/hanwen/go-fuse/v2/fs"
 	"github.com/hanwen/go-fuse/v2/fuse"
-	log "github.com/sirupsen/logrus"
 )
 
 // AGFSNode represents a file or directory node
@@ -62,24 +61,18 @@ var _ = (fs.NodeSymlinker)((*AGFSNode)(nil))
 // Getattr returns file attributes
 func (n *AGFSNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrOut) syscall.Errno {
 	path := n.getPath()
+	ctx, span := n.root.startSpan(ctx, "Getattr", path)
+	defer span.End()
 
-	// Try cache first
-	if cached, ok := n.root.metaCache.Get(path); ok {
-		fillAttr(&out.Attr, cached)
-		out.SetTimeout(n.root.cacheTTL)
-		return 0
-	}
+	// Report the size of the writes held for the commit window
+	n.root.handles.FlushPath(ctx, path)
 
-	// Fetch from server
-	info, err := n.root.client.Stat(path)
+	info, err := n.root.statCached(ctx, path)
 	if err != nil {
-		return syscall.ENOENT
+		return statErrno(err)
 	}
-
-	// Cache the result
-	n.root.metaCache.Set(path, info)
-
-	fillAttr(&out.Attr, info)
+	n.root.fillAttr(&out.Attr, info)
+	out.SetTimeout(n.root.attrTimeoutOf(path))
 
 	return 0
 }
@@ -88,28 +81,18 @@ func (n *AGFSNode) Getattr(ctx context.Context, f fs.FileHandle, out *fuse.AttrO
 func (n *AGFSNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	path := n.getPath()
 	childPath := filepath.Join(path, name)
+	ctx, span := n.root.startSpan(ctx, "Lookup", childPath)
+	defer span.End()
 
-	// Try cache first
-	var info *agfs.FileInfo
-	if cached, ok := n.root.metaCache.Get(childPath); ok {
-		info = cached
-	} else {
-		// Fetch from server
-		var err error
-		info, err = n.root.client.Stat(childPath)
-		if err != nil {
-			return nil, syscall.ENOENT
-		}
-		// Cache the result
-		n.root.metaCache.Set(childPath, info)
+	info, err := n.root.statCached(ctx, childPath)
+	if err != nil {
+		return nil, statErrno(err)
 	}
 
-	fillAttr(&out.Attr, info)
+	n.root.fillAttr(&out.Attr, info)
 
 	// Create child node
-	stable := fs.StableAttr{
-		Mode: getStableMode(info),
-	}
+	stable := n.root.stableAttr(childPath, info)
 
 	child := &AGFSNode{
 		root: n.root,
@@ -122,59 +105,36 @@ func (n *AGFSNode) Lookup(ctx context.Context, name string, out *fuse.EntryOut)
 // Readdir reads directory contents
 func (n *AGFSNode) Readdir(ctx context.Context) (fs.DirStream, syscall.Errno) {
 	path := n.getPath()
-
-	// Try cache first
-	var files []agfs.FileInfo
-	if cached, ok := n.root.dirCache.Get(path); ok {
-		files = cached
-	} else {
-		// Fetch from server
-		var err error
-		files, err = n.root.client.ReadDir(path)
-		if err != nil {
-			return nil, syscall.EIO
-		}
-		// Cache the result
-		n.root.dirCache.Set(path, files)
-	}
-
-	// Convert to FUSE entries
-	entries := make([]fuse.DirEntry, 0, len(files))
-	for _, f := range files {
-		entry := fuse.DirEntry{
-			Name: f.Name,
-			Mode: getStableMode(&f),
-		}
-		entries = append(entries, entry)
-	}
-
-	return fs.NewListDirStream(entries), 0
+	ctx, span := n.root.startSpan(ctx, "Readdir", path)
+	defer span.End()
+	return n.root.readDir(ctx, path)
 }
 
 // Mkdir creates a directory
 func (n *AGFSNode) Mkdir(ctx context.Context, name string, mode uint32, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	path := n.getPath()
 	childPath := filepath.Join(path, name)
+	ctx, span := n.root.startSpan(ctx, "Mkdir", childPath)
+	defer span.End()
+	client := n.root.clientFor(ctx)
 
-	err := n.root.client.Mkdir(childPath, mode)
+	err := client.Mkdir(childPath, fileModeToMode(mode))
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, ToErrno(err)
 	}
 
 	// Invalidate caches
 	n.root.invalidateCache(childPath)
 
 	// Fetch new file info
-	info, err := n.root.client.Stat(childPath)
+	info, err := client.Stat(childPath)
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, ToErrno(err)
 	}
 
-	fillAttr(&out.Attr, info)
+	n.root.fillAttr(&out.Attr, info)
 
-	stable := fs.StableAttr{
-		Mode: getStableMode(info),
-	}
+	stable := n.root.stableAttr(childPath, info)
 
 	child := &AGFSNode{
 		root: n.root,
@@ -188,10 +148,13 @@ func (n *AGFSNode) Mkdir(ctx context.Context, name string, mode uint32, out *fus
 func (n *AGFSNode) Rmdir(ctx context.Context, name string) syscall.Errno {
 	path := n.getPath()
 	childPath := filepath.Join(path, name)
+	ctx, span := n.root.startSpan(ctx, "Rmdir", childPath)
+	defer span.End()
+	client := n.root.clientFor(ctx)
 
-	err := n.root.client.Remove(childPath)
+	err := client.Remove(childPath)
 	if err != nil {
-		return syscall.EIO
+		return ToErrno(err)
 	}
 
 	// Invalidate caches
@@ -204,10 +167,13 @@ func (n *AGFSNode) Rmdir(ctx context.Context, name string) syscall.Errno {
 func (n *AGFSNode) Unlink(ctx context.Context, name string) syscall.Errno {
 	path := n.getPath()
 	childPath := filepath.Join(path, name)
+	ctx, span := n.root.startSpan(ctx, "Unlink", childPath)
+	defer span.End()
+	client := n.root.clientFor(ctx)
 
-	err := n.root.client.Remove(childPath)
+	err := client.Remove(childPath)
 	if err != nil {
-		return syscall.EIO
+		return ToErrno(err)
 	}
 
 	// Invalidate caches
@@ -233,10 +199,13 @@ func (n *AGFSNode) Rename(ctx context.Context, name string, newParent fs.InodeEm
 		return syscall.EINVAL
 	}
 	newPath := filepath.Join(newParentPath, newName)
+	ctx, span := n.root.startSpan(ctx, "Rename", oldPath)
+	defer span.End()
+	client := n.root.clientFor(ctx)
 
-	err := n.root.client.Rename(oldPath, newPath)
+	err := client.Rename(oldPath, newPath)
 	if err != nil {
-		return syscall.EIO
+		return ToErrno(err)
 	}
 
 	// Invalidate caches
@@ -250,44 +219,47 @@ func (n *AGFSNode) Rename(ctx context.Context, name string, newParent fs.InodeEm
 func (n *AGFSNode) Create(ctx context.Context, name string, flags uint32, mode uint32, out *fuse.EntryOut) (node *fs.Inode, fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	path := n.getPath()
 	childPath := filepath.Join(path, name)
+	ctx, span := n.root.startSpan(ctx, "Create", childPath)
+	defer span.End()
+	client := n.root.clientFor(ctx)
 
-	log.Debugf("[node] Create called: path=%s, name=%s, childPath=%s", path, name, childPath)
+	n.root.logger.Debugf("[node] Create called: path=%s, name=%s, childPath=%s", path, name, childPath)
 
-	// Create the file
-	err := n.root.client.Create(childPath)
-	if err != nil {
-		log.Errorf("[node] Create failed for %s: %v", childPath, err)
-		return nil, nil, 0, syscall.EIO
+	// Create the file. With O_EXCL the open below creates it, so the server
+	// can fail it if the file already exists.
+	if flags&syscall.O_EXCL == 0 {
+		err := client.Create(childPath)
+		if err != nil {
+			n.root.logger.Errorf("[node] Create failed for %s: %v", childPath, err)
+			return nil, nil, 0, ToErrno(err)
+		}
+		n.root.logger.Debugf("[node] Create succeeded, opening handle for %s", childPath)
 	}
 
-	log.Debugf("[node] Create succeeded, opening handle for %s", childPath)
-
 	// Invalidate caches
 	n.root.invalidateCache(childPath)
 
 	// Open the file with the requested flags
-	openFlags := convertOpenFlags(flags)
-	fuseHandle, err := n.root.handles.Open(childPath, openFlags, mode)
+	openFlags := convertOpenFlags(flags) | agfs.OpenFlagCreate
+	fuseHandle, err := n.root.handles.openFor(flags)(ctx, childPath, openFlags, fileModeToMode(mode))
 	if err != nil {
-		log.Errorf("[node] Open handle failed for %s: %v", childPath, err)
-		return nil, nil, 0, syscall.EIO
+		n.root.logger.Errorf("[node] Open handle failed for %s: %v", childPath, err)
+		return nil, nil, 0, ToErrno(err)
 	}
 
-	log.Debugf("[node] Handle opened: %d for %s", fuseHandle, childPath)
+	n.root.logger.Debugf("[node] Handle opened: %d for %s", fuseHandle, childPath)
 
 	// Fetch file info
-	info, err := n.root.client.Stat(childPath)
+	info, err := client.Stat(childPath)
 	if err != nil {
-		log.Errorf("[node] Stat failed for %s: %v", childPath, err)
-		n.root.handles.Close(fuseHandle)
-		return nil, nil, 0, syscall.EIO
+		n.root.logger.Errorf("[node] Stat failed for %s: %v", childPath, err)
+		n.root.handles.Close(ctx, fuseHandle)
+		return nil, nil, 0, ToErrno(err)
 	}
 
-	fillAttr(&out.Attr, info)
+	n.root.fillAttr(&out.Attr, info)
 
-	stable := fs.StableAttr{
-		Mode: getStableMode(info),
-	}
+	stable := n.root.stableAttr(childPath, info)
 
 	child := &AGFSNode{
 		root: n.root,
@@ -307,31 +279,50 @@ func (n *AGFSNode) Create(ctx context.Context, name string, flags uint32, mode u
 // Open opens a file
 func (n *AGFSNode) Open(ctx context.Context, flags uint32) (fh fs.FileHandle, fuseFlags uint32, errno syscall.Errno) {
 	path := n.getPath()
+	ctx, span := n.root.startSpan(ctx, "Open", path)
+	defer span.End()
 	openFlags := convertOpenFlags(flags)
-	fuseHandle, err := n.root.handles.Open(path, openFlags, 0644)
+	fuseHandle, err := n.root.handles.openFor(flags)(ctx, path, openFlags, 0644)
 	if err != nil {
-		return nil, 0, syscall.EIO
+		return nil, 0, ToErrno(err)
 	}
 
 	fileHandle := &AGFSFileHandle{
 		node:   n,
 		handle: fuseHandle,
 	}
+	if n.root.handles.blocks != nil && n.cacheableSize(ctx, path) {
+		n.root.handles.CacheBlocks(fuseHandle)
+	}
 
 	// Use DIRECT_IO for files with unknown/dynamic size (like queuefs control files)
 	// This tells FUSE to ignore cached size and always read from the filesystem
 	return fileHandle, fuse.FOPEN_DIRECT_IO, 0
 }
 
+// cacheableSize reports whether path looks like a regular file whose reads
+// can be cached. Files with dynamic content, like queuefs control files,
+// report a size of 0.
+func (n *AGFSNode) cacheableSize(ctx context.Context, path string) bool {
+	info, err := n.root.statCached(ctx, path)
+	if err != nil {
+		return false
+	}
+	return !info.IsDir && info.Size > 0
+}
+
 // Setattr sets file attributes
 func (n *AGFSNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAttrIn, out *fuse.AttrOut) syscall.Errno {
 	path := n.getPath()
+	ctx, span := n.root.startSpan(ctx, "Setattr", path)
+	defer span.End()
+	client := n.root.clientFor(ctx)
 
 	// Handle chmod
 	if mode, ok := in.GetMode(); ok {
-		err := n.root.client.Chmod(path, mode)
+		err := client.Chmod(path, fileModeToMode(mode))
 		if err != nil {
-			return syscall.EIO
+			return ToErrno(err)
 		}
 
 		// Invalidate cache
@@ -340,9 +331,12 @@ func (n *AGFSNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAtt
 
 	// Handle truncate (size change)
 	if size, ok := in.GetSize(); ok {
-		err := n.root.client.Truncate(path, int64(size))
+		// Writes held for the commit window come before the truncate
+		n.root.handles.FlushPath(ctx, path)
+		err := client.Truncate(path, int64(size))
+		n.root.handles.truncated(path)
 		if err != nil {
-			return syscall.EIO
+			return ToErrno(err)
 		}
 
 		// Invalidate cache
@@ -356,9 +350,12 @@ func (n *AGFSNode) Setattr(ctx context.Context, f fs.FileHandle, in *fuse.SetAtt
 // Readlink reads the target of a symbolic link
 func (n *AGFSNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 	path := n.getPath()
-	target, err := n.root.client.Readlink(path)
+	ctx, span := n.root.startSpan(ctx, "Readlink", path)
+	defer span.End()
+	client := n.root.clientFor(ctx)
+	target, err := client.Readlink(path)
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, ToErrno(err)
 	}
 	return []byte(target), 0
 }
@@ -367,26 +364,27 @@ func (n *AGFSNode) Readlink(ctx context.Context) ([]byte, syscall.Errno) {
 func (n *AGFSNode) Symlink(ctx context.Context, target, name string, out *fuse.EntryOut) (*fs.Inode, syscall.Errno) {
 	path := n.getPath()
 	linkPath := filepath.Join(path, name)
+	ctx, span := n.root.startSpan(ctx, "Symlink", linkPath)
+	defer span.End()
+	client := n.root.clientFor(ctx)
 
-	err := n.root.client.Symlink(target, linkPath)
+	err := client.Symlink(target, linkPath)
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, ToErrno(err)
 	}
 
 	// Invalidate caches
 	n.root.invalidateCache(linkPath)
 
 	// Fetch file info for the new symlink
-	info, err := n.root.client.Stat(linkPath)
+	info, err := client.Stat(linkPath)
 	if err != nil {
-		return nil, syscall.EIO
+		return nil, ToErrno(err)
 	}
 
-	fillAttr(&out.Attr, info)
+	n.root.fillAttr(&out.Attr, info)
 
-	stable := fs.StableAttr{
-		Mode: getStableMode(info),
-	}
+	stable := n.root.stableAttr(linkPath, info)
 
 	child := &AGFSNode{
 		root: n.root,
@@ -396,10 +394,14 @@ func (n *AGFSNode) Symlink(ctx context.Context, target, name string, out *fuse.E
 	return n.NewInode(ctx, child, stable), 0
 }
 
-// fillAttr fills FUSE attributes from AGFS FileInfo
-func fillAttr(out *fuse.Attr, info *agfs.FileInfo) {
-	out.Mode = modeToFileMode(info.Mode)
+// fillAttr fills FUSE attributes from AGFS FileInfo, applying the
+// configured owner and umask overrides
+func (root *AGFSFS) fillAttr(out *fuse.Attr, info *agfs.FileInfo) {
+	out.Mode = root.maskMode(modeToFileMode(info.Mode))
 	out.Size = uint64(info.Size)
+	// du counts allocated blocks, fewer than the size for a sparse file
+	out.Blocks = uint64(info.AllocatedBlocks())
+	out.Blksize = uint32(info.BlockSize)
 	out.Mtime = uint64(info.ModTime.Unix())
 	out.Mtimensec = uint32(info.ModTime.Nanosecond())
 	out.Atime = out.Mtime
@@ -407,9 +409,10 @@ func fillAttr(out *fuse.Attr, info *agfs.FileInfo) {
 	out.Ctime = out.Mtime
 	out.Ctimensec = out.Mtimensec
 
-	// Set owner to current user so they have proper read/write permissions
-	out.Uid = uint32(syscall.Getuid())
-	out.Gid = uint32(syscall.Getgid())
+	// Plugins rarely model ownership, so report the configured owner
+	// (the current user by default) to get proper read/write permissions
+	out.Uid = root.uid
+	out.Gid = root.gid
 
 	if info.IsSymlink {
 		out.Mode |= syscall.S_IFLNK
@@ -447,7 +450,8 @@ func convertOpenFlags(flags uint32) agfs.OpenFlag {
 	if flags&syscall.O_TRUNC != 0 {
 		openFlag |= agfs.OpenFlagTruncate
 	}
-	if flags&syscall.O_SYNC != 0 {
+	// O_SYNC includes the O_DSYNC bit; both make every write durable
+	if flags&syscall.O_DSYNC != 0 {
 		openFlag |= agfs.OpenFlagSync
 	}
 
@@ -0,0 +1,416 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-sdk/go/agfstest"
+	"github.com/hanwen/go-fuse/v2/fs"
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+func TestFillAttrOwnerAndUmaskOverride(t *testing.T) {
+	root := &AGFSFS{uid: 1000, gid: 2000, umask: 0027}
+
+	info := &agfs.FileInfo{
+		Name:    "file.txt",
+		Size:    42,
+		Mode:    0666,
+		ModTime: time.Unix(1700000000, 0),
+	}
+
+	var attr fuse.Attr
+	root.fillAttr(&attr, info)
+
+	if attr.Uid != 1000 || attr.Gid != 2000 {
+		t.Errorf("Expected uid/gid 1000/2000, got %d/%d", attr.Uid, attr.Gid)
+	}
+	if attr.Mode != syscall.S_IFREG|0640 {
+		t.Errorf("Expected mode %o, got %o", syscall.S_IFREG|0640, attr.Mode)
+	}
+	if attr.Size != 42 {
+		t.Errorf("Expected size 42, got %d", attr.Size)
+	}
+
+	dirInfo := &agfs.FileInfo{Name: "dir", Mode: 0777, IsDir: true}
+	root.fillAttr(&attr, dirInfo)
+	if attr.Mode != syscall.S_IFDIR|0750 {
+		t.Errorf("Expected mode %o, got %o", syscall.S_IFDIR|0750, attr.Mode)
+	}
+	if got := root.maskMode(getStableMode(dirInfo)); got != syscall.S_IFDIR|0750 {
+		t.Errorf("Expected readdir mode %o, got %o", syscall.S_IFDIR|0750, got)
+	}
+}
+
+// TestConfigKernelTimeouts checks that explicit attribute and entry timeouts
+// override the cache TTL they otherwise share
+func TestConfigKernelTimeouts(t *testing.T) {
+	tests := []struct {
+		name                  string
+		config                Config
+		attr, entry, negative time.Duration
+	}{
+		{"shared", Config{CacheTTL: 5 * time.Second}, 5 * time.Second, 5 * time.Second, 0},
+		{"attr", Config{CacheTTL: 5 * time.Second, AttrTimeout: time.Second}, time.Second, 5 * time.Second, 0},
+		{"entry", Config{CacheTTL: 5 * time.Second, EntryTimeout: time.Minute}, 5 * time.Second, time.Minute, 0},
+		{"all", Config{CacheTTL: 5 * time.Second, AttrTimeout: time.Second, EntryTimeout: time.Minute, NegativeTimeout: 2 * time.Second},
+			time.Second, time.Minute, 2 * time.Second},
+	}
+	for _, tt := range tests {
+		attr, entry, negative := tt.config.KernelTimeouts()
+		if attr != tt.attr || entry != tt.entry || negative != tt.negative {
+			t.Errorf("%s: expected attr=%v entry=%v negative=%v, got attr=%v entry=%v negative=%v",
+				tt.name, tt.attr, tt.entry, tt.negative, attr, entry, negative)
+		}
+	}
+}
+
+// TestFillAttrBlocks checks that a sparse file reports the blocks its plugin
+// says it has allocated, and a dense one those its size needs
+func TestFillAttrBlocks(t *testing.T) {
+	root := &AGFSFS{}
+
+	var attr fuse.Attr
+	root.fillAttr(&attr, &agfs.FileInfo{Name: "sparse", Size: 1 << 20, Blocks: 8, BlockSize: 4096})
+	if attr.Size != 1<<20 || attr.Blocks != 8 || attr.Blksize != 4096 {
+		t.Errorf("Expected a 1MiB sparse file with 8 blocks of 4KiB I/O, got size %d, %d blocks, blksize %d", attr.Size, attr.Blocks, attr.Blksize)
+	}
+
+	root.fillAttr(&attr, &agfs.FileInfo{Name: "dense", Size: 1000})
+	if attr.Size != 1000 || attr.Blocks != 2 {
+		t.Errorf("Expected a dense file of 1000 bytes to take 2 blocks, got %d", attr.Blocks)
+	}
+}
+
+func TestFillAttrSpecialBits(t *testing.T) {
+	root := &AGFSFS{}
+
+	var attr fuse.Attr
+	root.fillAttr(&attr, &agfs.FileInfo{Name: "tool", Mode: 04755})
+	if attr.Mode != syscall.S_IFREG|04755 {
+		t.Errorf("Expected mode %o, got %o", syscall.S_IFREG|04755, attr.Mode)
+	}
+
+	tmp := &agfs.FileInfo{Name: "tmp", Mode: 01777, IsDir: true}
+	root.fillAttr(&attr, tmp)
+	if attr.Mode != syscall.S_IFDIR|01777 {
+		t.Errorf("Expected mode %o, got %o", syscall.S_IFDIR|01777, attr.Mode)
+	}
+	if got := getStableMode(tmp); got != syscall.S_IFDIR|01777 {
+		t.Errorf("Expected readdir mode %o, got %o", syscall.S_IFDIR|01777, got)
+	}
+
+	// Create passes the file type along with the permission bits
+	if got := fileModeToMode(syscall.S_IFREG | 02755); got != 02755 {
+		t.Errorf("Expected mode %o, got %o", 02755, got)
+	}
+}
+
+// statCounter counts the stats a prefetchServer answers
+type statCounter struct {
+	total, inFlight, maxInFlight atomic.Int32
+}
+
+// prefetchServer serves a directory /dir of n files, counting the stats it
+// answers, each delayed by latency
+func prefetchServer(tb testing.TB, n int, latency time.Duration) (url string, stats *statCounter) {
+	tb.Helper()
+	srv := agfstest.NewServer()
+	tb.Cleanup(srv.Close)
+	client := agfs.NewClient(srv.URL)
+	if err := client.Mkdir("/dir", 0755); err != nil {
+		tb.Fatal(err)
+	}
+	for i := 0; i < n; i++ {
+		if _, err := client.Write(fmt.Sprintf("/dir/f%d", i), []byte("x")); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	stats = new(statCounter)
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/stat" {
+			stats.total.Add(1)
+			n := stats.inFlight.Add(1)
+			defer stats.inFlight.Add(-1)
+			for {
+				m := stats.maxInFlight.Load()
+				if n <= m || stats.maxInFlight.CompareAndSwap(m, n) {
+					break
+				}
+			}
+		}
+		time.Sleep(latency)
+		srv.Config.Handler.ServeHTTP(w, r)
+	}))
+	tb.Cleanup(slow.Close)
+	return slow.URL, stats
+}
+
+func TestPrefetchChildrenBoundedConcurrency(t *testing.T) {
+	url, stats := prefetchServer(t, 10, 10*time.Millisecond)
+	root := NewAGFSFS(Config{ServerURL: url, CacheTTL: time.Minute, PrefetchConcurrency: 2})
+	defer root.Close()
+
+	// Already cached entries must not be re-fetched
+	root.metaCache.Set("/dir/f0", &agfs.FileInfo{Name: "f0"})
+
+	if _, errno := root.readDir(context.Background(), "/dir"); errno != 0 {
+		t.Fatalf("readDir failed: %v", errno)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		if _, ok := root.metaCache.Get("/dir/f9"); ok && stats.inFlight.Load() == 0 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for i := 1; i < 10; i++ {
+		if _, ok := root.metaCache.Get(fmt.Sprintf("/dir/f%d", i)); !ok {
+			t.Errorf("Expected /dir/f%d to be prefetched", i)
+		}
+	}
+	if got := stats.total.Load(); got != 9 {
+		t.Errorf("Expected 9 stat calls, got %d", got)
+	}
+	if got := stats.maxInFlight.Load(); got > 2 {
+		t.Errorf("Expected at most 2 concurrent stats, got %d", got)
+	}
+}
+
+func TestPrefetchChildrenCancelledOnClose(t *testing.T) {
+	url, stats := prefetchServer(t, 50, 20*time.Millisecond)
+	root := NewAGFSFS(Config{ServerURL: url, CacheTTL: time.Minute, PrefetchConcurrency: 1})
+
+	if _, errno := root.readDir(context.Background(), "/dir"); errno != 0 {
+		t.Fatalf("readDir failed: %v", errno)
+	}
+	time.Sleep(30 * time.Millisecond)
+	root.Close()
+	started := stats.total.Load()
+	time.Sleep(100 * time.Millisecond)
+	if got := stats.total.Load(); got != started || got >= 50 {
+		t.Errorf("Expected prefetching to stop at Close after %d stats, got %d", started, got)
+	}
+}
+
+func TestPrefetchChildrenFromListing(t *testing.T) {
+	url, stats := prefetchServer(t, 10, 0)
+	root := NewAGFSFS(Config{ServerURL: url, CacheTTL: time.Minute, PrefetchAttrs: true})
+	defer root.Close()
+
+	// Already cached entries are kept
+	cached := &agfs.FileInfo{Name: "f0", Size: 42}
+	root.metaCache.Set("/dir/f0", cached)
+
+	if _, errno := root.readDir(context.Background(), "/dir"); errno != 0 {
+		t.Fatalf("readDir failed: %v", errno)
+	}
+	for i := 0; i < 10; i++ {
+		info, err := root.statCached(context.Background(), fmt.Sprintf("/dir/f%d", i))
+		if err != nil {
+			t.Fatalf("stat of f%d failed: %v", i, err)
+		}
+		if i == 0 && info != cached {
+			t.Errorf("Expected the cached attributes of f0 kept, got %+v", info)
+		}
+		if i > 0 && info.Size != 1 {
+			t.Errorf("Expected the listed attributes of f%d, got %+v", i, info)
+		}
+	}
+	if got := stats.total.Load(); got != 0 {
+		t.Errorf("Expected the lookups served from the listing, got %d stats", got)
+	}
+
+	// A listing that raced an invalidation caches nothing
+	root.metaCache.Clear()
+	epoch := root.metaCache.Epoch()
+	root.invalidateCache("/dir/f3")
+	root.prefetchChildren("/dir", []agfs.FileInfo{{Name: "f3", Size: 7}}, epoch)
+	if _, ok := root.metaCache.Get("/dir/f3"); ok {
+		t.Error("Expected attributes listed before an invalidation not to be cached")
+	}
+}
+
+// BenchmarkLookupAfterReadDir measures what ls -l costs on a directory of
+// 200 files over a link with 2ms of latency: a listing, then a lookup per
+// entry
+func BenchmarkLookupAfterReadDir(b *testing.B) {
+	const files = 200
+	url, _ := prefetchServer(b, files, 2*time.Millisecond)
+	for _, tc := range []struct {
+		name   string
+		config Config
+	}{
+		{"none", Config{}},
+		{"stats", Config{PrefetchConcurrency: 16}},
+		{"listing", Config{PrefetchAttrs: true}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			config := tc.config
+			config.ServerURL, config.CacheTTL = url, time.Minute
+			root := NewAGFSFS(config)
+			defer root.Close()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				root.dirCache.Clear()
+				root.metaCache.Clear()
+				b.StartTimer()
+				if _, errno := root.readDir(context.Background(), "/dir"); errno != 0 {
+					b.Fatalf("readDir failed: %v", errno)
+				}
+				for j := 0; j < files; j++ {
+					if _, err := root.statCached(context.Background(), fmt.Sprintf("/dir/f%d", j)); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStatErrno(t *testing.T) {
+	if got := statErrno(fmt.Errorf("failed to execute request: %w", agfs.ErrCircuitOpen)); got != syscall.EIO {
+		t.Errorf("Expected EIO for open breaker, got %v", got)
+	}
+	if got := statErrno(fmt.Errorf("HTTP 404: not found")); got != syscall.ENOENT {
+		t.Errorf("Expected ENOENT, got %v", got)
+	}
+	if got := statErrno(fmt.Errorf("failed to execute request: %w", agfs.ErrRateLimited)); got != syscall.EAGAIN {
+		t.Errorf("Expected EAGAIN when rate limited, got %v", got)
+	}
+	if got := statErrno(&agfs.StatusError{StatusCode: 403, Message: "denied"}); got != syscall.EACCES {
+		t.Errorf("Expected EACCES, got %v", got)
+	}
+}
+
+func TestInodeStability(t *testing.T) {
+	files := map[string]agfs.FileInfoResponse{
+		"/plain": {Name: "plain", Mode: 0644},
+		"/a":     {Name: "a", Mode: 0644, Ino: 7},
+		"/b":     {Name: "b", Mode: 0644, Ino: 7}, // A hard link to /a
+		"/c":     {Name: "c", Mode: 0644, Ino: 8},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/stat":
+			info, ok := files[r.URL.Query().Get("path")]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(agfs.ErrorResponse{Error: "not found"})
+				return
+			}
+			json.NewEncoder(w).Encode(info)
+		case "/api/v1/directories":
+			var list agfs.ListResponse
+			for _, info := range files {
+				list.Files = append(list.Files, info)
+			}
+			json.NewEncoder(w).Encode(list)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Millisecond})
+	defer root.Close()
+	fs.NewNodeFS(root, &fs.Options{})
+	ctx := context.Background()
+
+	inos := make(map[string]uint64)
+	for _, name := range []string{"plain", "a", "b", "c"} {
+		var out fuse.EntryOut
+		child, errno := root.Lookup(ctx, name, &out)
+		if errno != 0 {
+			t.Fatalf("Lookup %s failed: %v", name, errno)
+		}
+		inos[name] = child.StableAttr().Ino
+		if inos[name] < 2 || inos[name] >= 1<<63 {
+			t.Errorf("Expected %s to have an inode below go-fuse's, got %d", name, inos[name])
+		}
+	}
+
+	// Lookups after the cached attributes expired find the same inodes
+	time.Sleep(5 * time.Millisecond)
+	for name, ino := range inos {
+		var out fuse.EntryOut
+		child, errno := root.Lookup(ctx, name, &out)
+		if errno != 0 || child.StableAttr().Ino != ino {
+			t.Errorf("Expected %s to keep inode %d, got %d (%v)", name, ino, child.StableAttr().Ino, errno)
+		}
+	}
+	if inos["a"] != inos["b"] {
+		t.Errorf("Expected hard links to share an inode, got %d and %d", inos["a"], inos["b"])
+	}
+	if inos["a"] == inos["c"] || inos["a"] == inos["plain"] {
+		t.Errorf("Expected distinct files to have distinct inodes, got %v", inos)
+	}
+
+	stream, errno := root.Readdir(ctx)
+	if errno != 0 {
+		t.Fatalf("Readdir failed: %v", errno)
+	}
+	for stream.HasNext() {
+		entry, _ := stream.Next()
+		if entry.Ino != inos[entry.Name] {
+			t.Errorf("Expected readdir to report inode %d for %s, got %d", inos[entry.Name], entry.Name, entry.Ino)
+		}
+	}
+}
+
+// TestMetadataOpsDontOpen checks that stat(2) and friends, which the kernel
+// serves with Lookup and Getattr, cost a single stat and never open a
+// handle or stream on the server
+func TestMetadataOpsDontOpen(t *testing.T) {
+	var stats, opens atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			stats.Add(1)
+			json.NewEncoder(w).Encode(agfs.FileInfoResponse{Name: "file", Size: 4, Mode: 0644})
+		case strings.HasPrefix(r.URL.Path, "/api/v1/handles"), r.URL.Path == "/api/v1/files":
+			opens.Add(1)
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	root := NewAGFSFS(Config{ServerURL: server.URL, CacheTTL: time.Minute})
+	defer root.Close()
+	fs.NewNodeFS(root, &fs.Options{})
+	ctx := context.Background()
+
+	var entry fuse.EntryOut
+	child, errno := root.Lookup(ctx, "file", &entry)
+	if errno != 0 {
+		t.Fatalf("Lookup failed: %v", errno)
+	}
+	root.AddChild("file", child, false) // As the bridge does after a lookup
+	var attr fuse.AttrOut
+	if errno := child.Operations().(fs.NodeGetattrer).Getattr(ctx, nil, &attr); errno != 0 {
+		t.Fatalf("Getattr failed: %v", errno)
+	}
+	if attr.Size != 4 {
+		t.Errorf("Expected size 4, got %d", attr.Size)
+	}
+
+	if n := stats.Load(); n != 1 {
+		t.Errorf("Expected a single stat, got %d", n)
+	}
+	if n := opens.Load(); n != 0 {
+		t.Errorf("Expected no handle, stream or read requests, got %d", n)
+	}
+}
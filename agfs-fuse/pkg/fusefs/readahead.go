@@ -0,0 +1,197 @@
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"math/bits"
+	"strings"
+)
+
+// minReadahead is the first readahead window of a handle read sequentially.
+// It doubles on every sequential read that misses the window, up to the
+// configured size.
+const minReadahead = 64 * 1024
+
+// randomSeeks is the number of seeks among the last 8 reads of a handle from
+// which IOPatternAuto takes its reads as random and stops reading ahead. A
+// seek is a read going backward or skipping more than minReadahead.
+const randomSeeks = 4
+
+// IOPattern tells how the files of a mount are expected to be read, which
+// decides whether handles read ahead
+type IOPattern int
+
+const (
+	// IOPatternAuto reads ahead of a handle while its recent reads are
+	// mostly sequential, and stops while they look random, as those of a
+	// database do, so data that is never used isn't fetched
+	IOPatternAuto IOPattern = iota
+	// IOPatternSequential reads ahead of every read continuing the
+	// previous one, however many seeks preceded it
+	IOPatternSequential
+	// IOPatternRandom never reads ahead
+	IOPatternRandom
+)
+
+// ParseIOPattern parses "auto", "sequential" or "random"
+func ParseIOPattern(s string) (IOPattern, error) {
+	switch s {
+	case "auto":
+		return IOPatternAuto, nil
+	case "sequential":
+		return IOPatternSequential, nil
+	case "random":
+		return IOPatternRandom, nil
+	}
+	return 0, fmt.Errorf("unknown I/O pattern %q (auto, sequential, random)", s)
+}
+
+func (p IOPattern) String() string {
+	switch p {
+	case IOPatternSequential:
+		return "sequential"
+	case IOPatternRandom:
+		return "random"
+	}
+	return "auto"
+}
+
+// readahead coalesces small sequential reads of a remote handle into larger
+// ones. It is per handle, unlike the block cache, and guarded by
+// HandleManager.mu.
+type readahead struct {
+	// Only regular files reporting a size read ahead, like the block cache,
+	// as reading a file whose content changes on every read could change it
+	enabled bool
+	next    int64  // Offset just past the last read, where a sequential read starts
+	window  int    // Size of the next fetch ahead, 0 until reads are sequential
+	seeks   uint8  // Which of the last 8 reads were seeks, the latest in bit 0
+	base    int64  // Offset of data in the file
+	data    []byte // Data read ahead
+	eof     bool   // data ends at the end of the file
+	// gen is bumped when the window is dropped, so a fetch that started
+	// before the file changed doesn't install stale data
+	gen uint64
+}
+
+// drop discards the data read ahead, keeping the access pattern
+func (ra *readahead) drop() {
+	ra.data = nil
+	ra.eof = false
+	ra.gen++
+}
+
+// record records whether a read at offset is a seek
+func (ra *readahead) record(offset int64) {
+	ra.seeks <<= 1
+	if ra.next > 0 && (offset < ra.next || offset > ra.next+minReadahead) {
+		ra.seeks |= 1
+	}
+}
+
+// random reports whether the recent reads look random
+func (ra *readahead) random() bool {
+	return bits.OnesCount8(ra.seeks) >= randomSeeks
+}
+
+// grow returns the next window of a sequential read, at most limit
+func (ra *readahead) grow(limit int) int {
+	window := ra.window * 2
+	if window == 0 {
+		window = minReadahead
+	}
+	if window > limit {
+		window = limit
+	}
+	return window
+}
+
+// readAhead serves a read of a remote handle from its readahead window,
+// reading further ahead on a miss while reads stay sequential. A read that
+// doesn't continue the previous one is a seek and resets the window, and
+// reads don't go ahead while the handle's I/O pattern is random.
+// Must be called with hm.mu held, which it releases.
+func (hm *HandleManager) readAhead(ctx context.Context, info *handleInfo, offset int64, size int) ([]byte, error) {
+	ra := &info.ra
+	ra.record(offset)
+	end := ra.base + int64(len(ra.data))
+	if ra.data != nil && offset >= ra.base && (offset+int64(size) <= end || (ra.eof && offset <= end)) {
+		data := ra.data[offset-ra.base:]
+		if len(data) > size {
+			data = data[:size]
+		}
+		ra.next = offset + int64(len(data))
+		hm.mu.Unlock()
+		return data, nil
+	}
+
+	fetch := size
+	if ra.next > 0 && offset == ra.next && hm.readsAhead(ra) {
+		ra.window = ra.grow(hm.readaheadSize)
+		if ra.window > size {
+			fetch = ra.window
+		}
+	} else {
+		ra.window = 0
+	}
+	ra.drop()
+	gen := ra.gen
+	hm.mu.Unlock()
+
+	// A window the server answers short of is read on, so that only the
+	// end of the file makes it short
+	client := hm.clientFor(ctx)
+	var (
+		data []byte
+		err  error
+	)
+	if fetch > size {
+		data, err = readHandleFull(client, info.agfsHandle, offset, fetch)
+	} else {
+		data, err = client.ReadHandle(info.agfsHandle, offset, fetch)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read handle: %w", err)
+	}
+	if len(data) > fetch {
+		data = data[:fetch]
+	}
+
+	hm.mu.Lock()
+	if fetch > size && ra.gen == gen && !info.closing {
+		ra.base, ra.data, ra.eof = offset, data, len(data) < fetch
+	}
+	if len(data) > size {
+		data = data[:size]
+	}
+	ra.next = offset + int64(len(data))
+	hm.mu.Unlock()
+	return data, nil
+}
+
+// readsAhead reports whether a sequential read of a handle reads ahead
+func (hm *HandleManager) readsAhead(ra *readahead) bool {
+	switch hm.ioPattern {
+	case IOPatternSequential:
+		return true
+	case IOPatternRandom:
+		return false
+	}
+	return !ra.random()
+}
+
+// dropReadahead discards the data read ahead by the handles of path and
+// every path below it, once it changed
+func (hm *HandleManager) dropReadahead(path string) {
+	if hm.readaheadSize <= 0 {
+		return
+	}
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	for _, info := range hm.handles {
+		if info.path == path || strings.HasPrefix(info.path, prefix) {
+			info.ra.drop()
+		}
+	}
+}
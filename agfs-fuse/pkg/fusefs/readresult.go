@@ -0,0 +1,64 @@
+package fusefs
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hanwen/go-fuse/v2/fuse"
+)
+
+// readResultPool recycles the results of ReadResult
+var readResultPool = sync.Pool{
+	New: func() interface{} { return new(pooledResult) },
+}
+
+// pooledResult is a read result whose buffer holds the data a read copied
+// out of the handle's own buffers, such as a stream's window or several
+// cached blocks. go-fuse calls Done once the kernel got the data, which
+// puts it back in readResultPool with its buffer for the next read.
+type pooledResult struct {
+	buf  []byte
+	data []byte
+}
+
+var _ = (fuse.ReadResult)((*pooledResult)(nil))
+
+// alloc returns a buffer of n bytes for the read to copy its data into
+func (r *pooledResult) alloc(n int) []byte {
+	if cap(r.buf) < n {
+		r.buf = make([]byte, n)
+	}
+	return r.buf[:n]
+}
+
+func (r *pooledResult) Bytes([]byte) ([]byte, fuse.Status) {
+	return r.data, fuse.OK
+}
+
+func (r *pooledResult) Size() int {
+	return len(r.data)
+}
+
+func (r *pooledResult) Done() {
+	r.data = nil
+	readResultPool.Put(r)
+}
+
+// newBytes allocates the buffers of reads whose data the caller keeps
+func newBytes(n int) []byte {
+	return make([]byte, n)
+}
+
+// ReadResult reads like Read, for a FUSE read reply: data copied out of the
+// handle's buffers goes in a pooled buffer, reused once go-fuse sent the
+// reply and called Done, instead of a new allocation per read.
+func (hm *HandleManager) ReadResult(ctx context.Context, fuseHandle uint64, offset int64, size int) (fuse.ReadResult, error) {
+	r := readResultPool.Get().(*pooledResult)
+	data, err := hm.readPaced(ctx, fuseHandle, offset, size, r.alloc)
+	if err != nil {
+		r.Done()
+		return nil, err
+	}
+	r.data = data
+	return r, nil
+}
@@ -0,0 +1,111 @@
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+// StalePolicy decides what reads of a handle serving cached data do once
+// the file changed on the server since the handle cached it, as when
+// another mount wrote it. A change is detected by the file's etag, which
+// plugins report as its version.
+type StalePolicy int
+
+const (
+	// StaleReread drops the handle's cached data and reads the file again
+	StaleReread StalePolicy = iota
+	// StaleError fails the read and every later one on the handle with
+	// ESTALE, so the application learns the data it read may be outdated
+	// and reopens the file
+	StaleError
+)
+
+// ParseStalePolicy parses "reread" or "estale"
+func ParseStalePolicy(s string) (StalePolicy, error) {
+	switch s {
+	case "reread":
+		return StaleReread, nil
+	case "estale":
+		return StaleError, nil
+	}
+	return 0, fmt.Errorf("unknown stale policy %q (reread, estale)", s)
+}
+
+func (p StalePolicy) String() string {
+	if p == StaleError {
+		return "estale"
+	}
+	return "reread"
+}
+
+// errStale is returned for reads of a handle whose file changed on the
+// server under StaleError
+var errStale = fmt.Errorf("file changed on the server: %w", syscall.ESTALE)
+
+// servesCached reports whether reads of a handle may be served from data
+// cached on the client. Must be called with hm.mu held
+func (hm *HandleManager) servesCached(info *handleInfo) bool {
+	switch info.htype {
+	case handleTypeRemote:
+		return info.cacheBlocks || info.ra.enabled && hm.readaheadSize > 0
+	case handleTypeLocal:
+		return !info.direct && info.readBuffer != nil
+	}
+	return false
+}
+
+// checkVersion compares the version of a handle's file with the one its
+// cached data was read at, before a read of the handle, and applies the
+// stale policy if it changed. Files whose version is unknown are never
+// stale. Must be called with hm.mu held, which it releases while asking
+// for the version and holds again when it returns.
+func (hm *HandleManager) checkVersion(ctx context.Context, info *handleInfo) error {
+	if info.stale {
+		return errStale
+	}
+	if hm.etag == nil || !hm.servesCached(info) {
+		return nil
+	}
+	path := info.path
+	hm.mu.Unlock()
+	etag := hm.etag(ctx, path)
+	hm.mu.Lock()
+	if info.closing {
+		return errHandleClosing
+	}
+
+	switch {
+	case etag == "" || etag == info.etag:
+	case info.etag == "":
+		info.etag = etag
+	case hm.stalePolicy == StaleError:
+		hm.logger.Debugf("File %s changed on the server (etag %s, was %s), failing reads with ESTALE", path, etag, info.etag)
+		info.stale = true
+		return errStale
+	default:
+		hm.logger.Debugf("File %s changed on the server (etag %s, was %s), reading it again", path, etag, info.etag)
+		info.etag = etag
+		info.ra.drop()
+		hm.dropReadBuffer(info)
+		if hm.blocks != nil {
+			hm.blocks.Invalidate(path)
+		}
+	}
+	return nil
+}
+
+// forgetVersions makes the handles of path and every path below it learn
+// their file's version again on their next read, once it was changed
+// through this mount, so the change isn't taken for another mount's
+func (hm *HandleManager) forgetVersions(path string) {
+	prefix := strings.TrimSuffix(path, "/") + "/"
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+	for _, info := range hm.handles {
+		if info.path == path || strings.HasPrefix(info.path, prefix) {
+			info.etag = ""
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package fusefs
+
+import (
+	"context"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// noopSpan is returned by startSpan when tracing is disabled
+var noopSpan = trace.SpanFromContext(context.Background())
+
+// startSpan starts the root span of a FUSE operation on path, and gives the
+// operation a request ID if enabled. Without a tracer it returns a no-op
+// span, so callers can always End it.
+func (root *AGFSFS) startSpan(ctx context.Context, op, path string) (context.Context, trace.Span) {
+	var id string
+	if root.requestIDs {
+		id = agfs.NewRequestID()
+		ctx = agfs.WithRequestID(ctx, id)
+		root.logger.WithField("request_id", id).Debugf("%s %s", op, path)
+	}
+	if root.tracer == nil {
+		return ctx, noopSpan
+	}
+	attrs := []attribute.KeyValue{attribute.String("agfs.path", path)}
+	if id != "" {
+		attrs = append(attrs, attribute.String("agfs.request_id", id))
+	}
+	return root.tracer.Start(ctx, "fuse."+op,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...))
+}
+
+// startSpan starts the root span of an operation on an open file. The path
+// is only resolved when tracing or request IDs are enabled.
+func (fh *AGFSFileHandle) startSpan(ctx context.Context, op string) (context.Context, trace.Span) {
+	root := fh.node.root
+	if root.tracer == nil && !root.requestIDs {
+		return ctx, noopSpan
+	}
+	return root.startSpan(ctx, op, fh.node.getPath())
+}
+
+// clientFor returns the client to use for requests made on behalf of ctx,
+// bound to ctx when tracing so request spans are children of the FUSE span,
+// when limiting requests in flight so an interrupted operation stops waiting
+// for a slot, and when requests carry the request ID of their operation
+func (root *AGFSFS) clientFor(ctx context.Context) *agfs.Client {
+	if root.tracer == nil && !root.bound {
+		return root.client
+	}
+	return root.client.WithContext(ctx)
+}
@@ -0,0 +1,138 @@
+package fusefs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingRequestSpansNestUnderFuseSpan(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/stat":
+			json.NewEncoder(w).Encode(agfs.FileInfo{Name: "file", Size: 5, Mode: 0644})
+		case "/api/v1/handles/7/read":
+			w.Write([]byte("hello"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	root := NewAGFSFS(Config{
+		ServerURL: testServer.URL,
+		CacheTTL:  time.Minute,
+		Tracer:    provider.Tracer("test"),
+	})
+	defer root.Close()
+	root.handles.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file"}
+	exporter.Reset()
+
+	ctx, span := root.startSpan(context.Background(), "Read", "/file")
+	if _, err := root.clientFor(ctx).Stat("/file"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if _, err := root.handles.Read(ctx, 1, 0, 5); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("Expected 3 spans, got %d", len(spans))
+	}
+	fuseSpan := spans[2]
+	if fuseSpan.Name != "fuse.Read" {
+		t.Errorf("Expected fuse.Read as the last span, got %q", fuseSpan.Name)
+	}
+	for _, s := range spans[:2] {
+		if s.Parent.SpanID() != fuseSpan.SpanContext.SpanID() {
+			t.Errorf("Expected %q to be a child of the FUSE span", s.Name)
+		}
+	}
+}
+
+func TestTracingDisabledUsesSharedClient(t *testing.T) {
+	root := &AGFSFS{client: agfs.NewClient("http://localhost:8080")}
+	ctx, span := root.startSpan(context.Background(), "Getattr", "/")
+	defer span.End()
+	if span.SpanContext().IsValid() {
+		t.Error("Expected a no-op span without a tracer")
+	}
+	if root.clientFor(ctx) != root.client {
+		t.Error("Expected the shared client without a tracer")
+	}
+}
+
+func TestRequestIDsFlowToServer(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get(agfs.RequestIDHeader))
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/api/v1/stat":
+			if r.URL.Query().Get("path") == "/missing" {
+				w.WriteHeader(http.StatusInternalServerError)
+				json.NewEncoder(w).Encode(map[string]string{"error": "backend down"})
+				return
+			}
+			json.NewEncoder(w).Encode(agfs.FileInfo{Name: "file", Size: 5, Mode: 0644})
+		case "/api/v1/handles/7/read":
+			w.Write([]byte("hello"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer testServer.Close()
+
+	root := NewAGFSFS(Config{ServerURL: testServer.URL, CacheTTL: time.Minute, RequestIDs: true})
+	defer root.Close()
+	root.handles.handles[1] = &handleInfo{htype: handleTypeRemote, agfsHandle: 7, path: "/file"}
+	mu.Lock()
+	ids = nil
+	mu.Unlock()
+
+	ctx, span := root.startSpan(context.Background(), "Read", "/file")
+	defer span.End()
+	id := agfs.RequestIDFromContext(ctx)
+	if id == "" {
+		t.Fatal("Expected the operation to get a request ID")
+	}
+	if _, err := root.clientFor(ctx).Stat("/file"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if _, err := root.handles.Read(ctx, 1, 0, 5); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	mu.Lock()
+	got := append([]string(nil), ids...)
+	mu.Unlock()
+	if len(got) != 2 || got[0] != id || got[1] != id {
+		t.Errorf("Expected both requests sent with %q, got %q", id, got)
+	}
+
+	// Another operation gets another ID, which its errors report
+	ctx, span = root.startSpan(context.Background(), "Getattr", "/missing")
+	defer span.End()
+	other := agfs.RequestIDFromContext(ctx)
+	if other == "" || other == id {
+		t.Fatalf("Expected a new request ID, got %q", other)
+	}
+	_, err := root.clientFor(ctx).Stat("/missing")
+	if err == nil || !strings.Contains(err.Error(), other) {
+		t.Errorf("Expected the error to name request %s, got %v", other, err)
+	}
+}
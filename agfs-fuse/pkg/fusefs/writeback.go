@@ -0,0 +1,249 @@
+package fusefs
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// defaultWriteCommitSize is the bytes a handle holds within its commit
+// window when Config.WriteCommitSize is unset
+const defaultWriteCommitSize = 4 * 1024 * 1024
+
+// writeBuffer holds the writes of a remote handle for the commit window, so
+// many small writes reach the server as a few large ones. Writes to
+// overlapping or adjacent ranges are merged into a single run. It is
+// guarded by HandleManager.mu, apart from flushMu.
+type writeBuffer struct {
+	runs []writeRun // Sorted by offset, neither overlapping nor adjacent
+	size int        // Bytes held in runs
+	// Flushes the runs once the window elapses, nil while there are none
+	timer *time.Timer
+	// Failure of a flush no caller waited for, reported by the next write,
+	// sync, flush or close of the handle
+	err error
+	// flushMu orders flushes, so the runs taken by one land on the server
+	// before the ones taken by the next
+	flushMu sync.Mutex
+}
+
+// writeRun is data to write at offset
+type writeRun struct {
+	offset int64
+	data   []byte
+}
+
+func (r writeRun) end() int64 {
+	return r.offset + int64(len(r.data))
+}
+
+// add merges a copy of data written at offset into the runs, the new data
+// replacing what it overlaps
+func (wb *writeBuffer) add(offset int64, data []byte) {
+	start, end := offset, offset+int64(len(data))
+	i := sort.Search(len(wb.runs), func(i int) bool { return wb.runs[i].end() >= start })
+	j := i
+	for j < len(wb.runs) && wb.runs[j].offset <= end {
+		j++
+	}
+
+	// Writes continuing or rewriting a single run, as sequential writes do,
+	// extend it in place
+	if j == i+1 && wb.runs[i].offset <= start {
+		r := &wb.runs[i]
+		n := copy(r.data[start-r.offset:], data)
+		r.data = append(r.data, data[n:]...)
+		wb.size += len(data) - n
+		return
+	}
+
+	if i < j {
+		start = min(start, wb.runs[i].offset)
+		end = max(end, wb.runs[j-1].end())
+	}
+	merged := make([]byte, end-start)
+	for _, r := range wb.runs[i:j] {
+		copy(merged[r.offset-start:], r.data)
+		wb.size -= len(r.data)
+	}
+	copy(merged[offset-start:], data)
+	wb.size += len(merged)
+
+	runs := append(wb.runs[:i:i], writeRun{offset: start, data: merged})
+	wb.runs = append(runs, wb.runs[j:]...)
+}
+
+// take removes and returns the runs, stopping the timer
+func (wb *writeBuffer) take() []writeRun {
+	runs := wb.runs
+	wb.runs = nil
+	wb.size = 0
+	if wb.timer != nil {
+		wb.timer.Stop()
+		wb.timer = nil
+	}
+	return runs
+}
+
+// buffersWrites reports whether the writes of info are held for the commit
+// window. Direct, O_SYNC and O_APPEND handles write through, and so do
+// local handles, whose every write is a separate operation of the file.
+func (hm *HandleManager) buffersWrites(info *handleInfo) bool {
+	if hm.commitWindow <= 0 || info.direct {
+		return false
+	}
+	if info.flags&(agfs.OpenFlagWriteOnly|agfs.OpenFlagReadWrite) == 0 {
+		return false
+	}
+	if info.htype != handleTypeRemote && info.htype != handleTypeRemoteStream {
+		return false
+	}
+	return info.flags&(agfs.OpenFlagSync|agfs.OpenFlagAppend) == 0
+}
+
+// bufferWrite holds a write of info for the commit window, flushing the
+// handle once it holds commitSize bytes. It reports the failure of an
+// earlier flush instead of taking the write.
+// Must be called with hm.mu held, which it releases.
+func (hm *HandleManager) bufferWrite(client *agfs.Client, info *handleInfo, data []byte, offset int64) (int, error) {
+	wb := &info.wb
+	if err := wb.err; err != nil {
+		wb.err = nil
+		hm.mu.Unlock()
+		return 0, err
+	}
+	wb.add(offset, data)
+	full := wb.size >= hm.commitSize
+	if !full && wb.timer == nil {
+		wb.timer = time.AfterFunc(hm.commitWindow, func() { hm.flushInBackground(hm.client, info) })
+	}
+	hm.mu.Unlock()
+
+	if full {
+		if err := hm.flushWrites(client, info); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// flushWrites writes what info holds to the server, after the flushes
+// taken before it. The runs are gone once taken, so a failed flush loses
+// them, like a failed write.
+func (hm *HandleManager) flushWrites(client *agfs.Client, info *handleInfo) error {
+	wb := &info.wb
+	wb.flushMu.Lock()
+	defer wb.flushMu.Unlock()
+
+	hm.mu.Lock()
+	runs := wb.take()
+	hm.mu.Unlock()
+	if len(runs) == 0 {
+		return nil
+	}
+	defer hm.InvalidateBlocks(info.path)
+	if hm.written != nil {
+		defer hm.written(info.path)
+	}
+
+	for _, r := range runs {
+		hm.logger.Debugf("[handles] Flushing %d bytes at %d of %s", len(r.data), r.offset, info.path)
+		if err := writeHandleFull(client, info.agfsHandle, r.data, r.offset); err != nil {
+			return fmt.Errorf("failed to write handle: %w", err)
+		}
+	}
+	return nil
+}
+
+// writeHandleFull writes all of data to a remote handle at offset, writing
+// the rest again after a short write: the application was told the held
+// writes succeeded, so none of them may be dropped. A write the server
+// takes none of fails with io.ErrShortWrite.
+func writeHandleFull(client *agfs.Client, handle int64, data []byte, offset int64) error {
+	for len(data) > 0 {
+		n, err := client.WriteHandle(handle, data, offset)
+		if err != nil {
+			return err
+		}
+		if n <= 0 {
+			return io.ErrShortWrite
+		}
+		if n > len(data) {
+			n = len(data)
+		}
+		data, offset = data[n:], offset+int64(n)
+	}
+	return nil
+}
+
+// flushInBackground flushes info for a caller that doesn't wait for the
+// result, keeping a failure for the handle's next write, sync, flush or
+// close
+func (hm *HandleManager) flushInBackground(client *agfs.Client, info *handleInfo) {
+	err := hm.flushWrites(client, info)
+	if err == nil {
+		return
+	}
+	hm.logger.Errorf("[handles] Flush of %s failed: %v", info.path, err)
+	hm.mu.Lock()
+	if info.wb.err == nil {
+		info.wb.err = err
+	}
+	hm.mu.Unlock()
+}
+
+// commitWrites flushes info and reports the failure of its flushes since
+// the last one reported
+func (hm *HandleManager) commitWrites(client *agfs.Client, info *handleInfo) error {
+	err := hm.flushWrites(client, info)
+	hm.mu.Lock()
+	if err == nil {
+		err = info.wb.err
+	}
+	info.wb.err = nil
+	hm.mu.Unlock()
+	return err
+}
+
+// Flush writes what the handle holds for its commit window to the server,
+// as closing a file descriptor of it does, and reports the failure of the
+// flushes since the last one reported
+func (hm *HandleManager) Flush(ctx context.Context, fuseHandle uint64) error {
+	hm.mu.Lock()
+	info, err := hm.acquire(fuseHandle)
+	if err != nil {
+		hm.mu.Unlock()
+		return err
+	}
+	defer hm.release(info)
+	buffered := hm.buffersWrites(info)
+	hm.mu.Unlock()
+
+	if !buffered {
+		return nil
+	}
+	return hm.commitWrites(hm.clientFor(ctx), info)
+}
+
+// FlushPath writes what the handles of path hold for their commit window to
+// the server, so the server's attributes and data of the file include them.
+// Failures are reported by the handles' next write, sync, flush or close.
+func (hm *HandleManager) FlushPath(ctx context.Context, path string) {
+	hm.mu.RLock()
+	var dirty []*handleInfo
+	for _, info := range hm.handles {
+		if info.path == path && len(info.wb.runs) > 0 {
+			dirty = append(dirty, info)
+		}
+	}
+	hm.mu.RUnlock()
+
+	for _, info := range dirty {
+		hm.flushInBackground(hm.clientFor(ctx), info)
+	}
+}
@@ -0,0 +1,815 @@
+// Package agfstest provides an in-memory AGFS server for tests, in the manner
+// of net/http/httptest. It speaks the same HTTP API as agfs-server, including
+// stateful handles and streaming reads, so code built on the SDK can be
+// tested end to end without starting a real server:
+//
+//	srv := agfstest.NewServer()
+//	defer srv.Close()
+//	client := agfs.NewClient(srv.URL)
+//
+// The server keeps its files in memory, like the server's memfs plugin. It
+// doesn't report etags, access hints or content types, and offers none of
+// the optional features beyond handles, streaming, clones and range copies.
+package agfstest
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// Version is the server version reported by the capabilities and health
+// endpoints
+const Version = "agfstest"
+
+// Flag bits of the handle API, which differ from agfs.OpenFlag
+const (
+	flagAccess = 3 // O_RDONLY, O_WRONLY or O_RDWR
+	flagAppend = 1 << 3
+	flagCreate = 1 << 4
+	flagExcl   = 1 << 5
+	flagTrunc  = 1 << 6
+
+	accessReadOnly  = 0
+	accessWriteOnly = 1
+)
+
+// maxHandleRead bounds handle reads of size -1, as on agfs-server
+const maxHandleRead = 1024 * 1024
+
+// Server is an in-memory AGFS server listening on a local address
+type Server struct {
+	*httptest.Server // URL is the base URL to pass to agfs.NewClient
+
+	mu       sync.Mutex
+	nodes    map[string]*node
+	handles  map[int64]*handle
+	nextID   int64
+	nextIno  uint64
+	disabled map[string]bool
+}
+
+// node is a file, directory or symlink
+type node struct {
+	data    []byte
+	mode    uint32
+	isDir   bool
+	target  string // Symlink target, "" if not a symlink
+	modTime time.Time
+	ino     uint64
+}
+
+// handle is an open file handle. It keeps the node it was opened on, so it
+// can still be used after the file was renamed or removed.
+type handle struct {
+	id    int64
+	path  string
+	flags int
+	node  *node
+	pos   int64
+}
+
+// NewServer starts and returns a new server with an empty root directory.
+// The caller should call Close when finished, to shut it down.
+func NewServer() *Server {
+	s := &Server{
+		nodes:    make(map[string]*node),
+		handles:  make(map[int64]*handle),
+		disabled: make(map[string]bool),
+	}
+	s.nodes["/"] = s.newNode(0755, true)
+	s.Server = httptest.NewServer(s.routes())
+	return s
+}
+
+// Disable turns off optional features, such as agfs.FeatureHandles or
+// agfs.FeatureStream: they are no longer advertised, and their endpoints
+// answer 501 Not Implemented, as on a server whose plugin lacks them.
+func (s *Server) Disable(features ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range features {
+		s.disabled[f] = true
+	}
+}
+
+// OpenHandles returns the number of handles opened and not closed yet
+func (s *Server) OpenHandles() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.handles)
+}
+
+func (s *Server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/health", s.health)
+	mux.HandleFunc("/api/v1/capabilities", s.capabilities)
+	mux.HandleFunc("/api/v1/files", s.files)
+	mux.HandleFunc("/api/v1/directories", s.directories)
+	mux.HandleFunc("/api/v1/stat", s.stat)
+	mux.HandleFunc("/api/v1/rename", s.rename)
+	mux.HandleFunc("/api/v1/clone", s.clone)
+	mux.HandleFunc("/api/v1/copyrange", s.copyRange)
+	mux.HandleFunc("/api/v1/chmod", s.chmod)
+	mux.HandleFunc("/api/v1/truncate", s.truncate)
+	mux.HandleFunc("/api/v1/symlink", s.symlink)
+	mux.HandleFunc("/api/v1/readlink", s.readlink)
+	mux.HandleFunc("/api/v1/handles/", s.handleRoutes)
+	return mux
+}
+
+// Errors are reported with the status agfs-server maps them to
+var (
+	errNotFound  = &statusError{http.StatusNotFound, "no such file or directory"}
+	errExists    = &statusError{http.StatusConflict, "file already exists"}
+	errIsDir     = &statusError{http.StatusBadRequest, "is a directory"}
+	errNotDir    = &statusError{http.StatusBadRequest, "not a directory"}
+	errNotEmpty  = &statusError{http.StatusBadRequest, "directory not empty"}
+	errReadOnly  = &statusError{http.StatusForbidden, "handle not open for writing"}
+	errWriteOnly = &statusError{http.StatusForbidden, "handle not open for reading"}
+)
+
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	var se *statusError
+	if errors.As(err, &se) {
+		status = se.status
+	}
+	writeJSON(w, status, agfs.ErrorResponse{Error: err.Error()})
+}
+
+func badRequest(message string) error {
+	return &statusError{http.StatusBadRequest, message}
+}
+
+func methodNotAllowed(w http.ResponseWriter) {
+	writeJSON(w, http.StatusMethodNotAllowed, agfs.ErrorResponse{Error: "method not allowed"})
+}
+
+// cleanPath returns the path query parameter in canonical form
+func cleanPath(r *http.Request) (string, error) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		return "", badRequest("path parameter is required")
+	}
+	return pathpkg.Clean("/" + p), nil
+}
+
+// intParam parses an integer query parameter, returning def if it is absent
+func intParam(r *http.Request, name string, def int64) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, badRequest("invalid " + name + " parameter")
+	}
+	return n, nil
+}
+
+// modeParam parses the octal mode query parameter
+func modeParam(r *http.Request, def uint32) (uint32, error) {
+	v := r.URL.Query().Get("mode")
+	if v == "" {
+		return def, nil
+	}
+	m, err := strconv.ParseUint(v, 8, 32)
+	if err != nil {
+		return 0, badRequest("invalid mode parameter")
+	}
+	return uint32(m), nil
+}
+
+func (s *Server) newNode(mode uint32, isDir bool) *node {
+	s.nextIno++
+	return &node{mode: mode, isDir: isDir, modTime: time.Now(), ino: s.nextIno}
+}
+
+// lookup returns the node at p. The caller must hold s.mu.
+func (s *Server) lookup(p string) (*node, error) {
+	n, ok := s.nodes[p]
+	if !ok {
+		return nil, errNotFound
+	}
+	return n, nil
+}
+
+// lookupFile returns the regular file at p. The caller must hold s.mu.
+func (s *Server) lookupFile(p string) (*node, error) {
+	n, err := s.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if n.isDir {
+		return nil, errIsDir
+	}
+	return n, nil
+}
+
+// add adds n at p, whose parent must be a directory. The caller must hold
+// s.mu.
+func (s *Server) add(p string, n *node) error {
+	if _, ok := s.nodes[p]; ok {
+		return errExists
+	}
+	parent, err := s.lookup(pathpkg.Dir(p))
+	if err != nil {
+		return err
+	}
+	if !parent.isDir {
+		return errNotDir
+	}
+	s.nodes[p] = n
+	parent.modTime = time.Now()
+	return nil
+}
+
+// children returns the paths of the entries below dir, in no particular
+// order, at any depth if all is set. The caller must hold s.mu.
+func (s *Server) children(dir string, all bool) []string {
+	prefix := dir + "/"
+	if dir == "/" {
+		prefix = "/"
+	}
+	var paths []string
+	for p := range s.nodes {
+		if p == "/" || !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		if all || !strings.Contains(p[len(prefix):], "/") {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func fileInfo(p string, n *node) agfs.FileInfoResponse {
+	info := agfs.FileInfoResponse{
+		Name:    pathpkg.Base(p),
+		Size:    int64(len(n.data)),
+		Mode:    n.mode,
+		ModTime: n.modTime.Format(time.RFC3339Nano),
+		IsDir:   n.isDir,
+		Ino:     n.ino,
+	}
+	if n.target != "" {
+		info.Size = int64(len(n.target))
+		info.Meta = agfs.MetaData{Type: "symlink"}
+	}
+	return info
+}
+
+// writeAt writes data to n at offset, zero-filling any gap
+func (n *node) writeAt(data []byte, offset int64) {
+	if end := offset + int64(len(data)); end > int64(len(n.data)) {
+		n.resize(end)
+	}
+	copy(n.data[offset:], data)
+	n.modTime = time.Now()
+}
+
+// readAt returns up to size bytes of n at offset, all of them if size is
+// negative
+func (n *node) readAt(offset, size int64) []byte {
+	if offset >= int64(len(n.data)) {
+		return []byte{}
+	}
+	end := int64(len(n.data))
+	if size >= 0 && offset+size < end {
+		end = offset + size
+	}
+	return append([]byte(nil), n.data[offset:end]...)
+}
+
+func (n *node) resize(size int64) {
+	if size <= int64(len(n.data)) {
+		n.data = n.data[:size]
+	} else {
+		n.data = append(n.data, make([]byte, size-int64(len(n.data)))...)
+	}
+	n.modTime = time.Now()
+}
+
+func (s *Server) health(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, agfs.Health{Status: "healthy", Version: Version})
+}
+
+func (s *Server) capabilities(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	features := []string{}
+	for _, f := range []string{agfs.FeatureHandles, agfs.FeatureStream, agfs.FeatureClone, agfs.FeatureCopyRange} {
+		if !s.disabled[f] {
+			features = append(features, f)
+		}
+	}
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, agfs.CapabilitiesResponse{Version: Version, Features: features})
+}
+
+// files serves /files: GET reads, PUT writes, POST creates and DELETE removes
+func (s *Server) files(w http.ResponseWriter, r *http.Request) {
+	p, err := cleanPath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		err = s.readFile(w, r, p)
+	case http.MethodPut:
+		var data []byte
+		if data, err = io.ReadAll(r.Body); err == nil {
+			err = s.writeFile(p, data)
+		}
+		if err == nil {
+			writeJSON(w, http.StatusOK, agfs.SuccessResponse{Message: "Written " + strconv.Itoa(len(data)) + " bytes"})
+		}
+	case http.MethodPost:
+		if err = s.createFile(p, r.URL.Query().Get("exclusive") == "true"); err == nil {
+			writeJSON(w, http.StatusCreated, agfs.SuccessResponse{Message: "file created"})
+		}
+	case http.MethodDelete:
+		if err = s.remove(p, r.URL.Query().Get("recursive") == "true"); err == nil {
+			writeJSON(w, http.StatusOK, agfs.SuccessResponse{Message: "deleted"})
+		}
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *Server) readFile(w http.ResponseWriter, r *http.Request, p string) error {
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		return err
+	}
+	size, err := intParam(r, "size", -1)
+	if err != nil {
+		return err
+	}
+	stream := r.URL.Query().Get("stream") == "true"
+	if stream && s.isDisabled(agfs.FeatureStream) {
+		return &statusError{http.StatusNotImplemented, "streaming not supported"}
+	}
+
+	s.mu.Lock()
+	n, err := s.lookupFile(p)
+	var data []byte
+	if err == nil {
+		data = n.readAt(offset, size)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	return nil
+}
+
+func (s *Server) writeFile(p string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.lookupFile(p)
+	if errors.Is(err, errNotFound) {
+		n = s.newNode(0644, false)
+		err = s.add(p, n)
+	}
+	if err != nil {
+		return err
+	}
+	n.data = append([]byte(nil), data...)
+	n.modTime = time.Now()
+	return nil
+}
+
+func (s *Server) createFile(p string, exclusive bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n, ok := s.nodes[p]; ok {
+		if exclusive {
+			return errExists
+		}
+		if n.isDir {
+			return errIsDir
+		}
+		n.resize(0)
+		return nil
+	}
+	return s.add(p, s.newNode(0644, false))
+}
+
+func (s *Server) remove(p string, recursive bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n, err := s.lookup(p)
+	if err != nil {
+		return err
+	}
+	if p == "/" {
+		return badRequest("cannot remove the root directory")
+	}
+	if n.isDir {
+		children := s.children(p, true)
+		if len(children) > 0 && !recursive {
+			return errNotEmpty
+		}
+		for _, c := range children {
+			delete(s.nodes, c)
+		}
+	}
+	delete(s.nodes, p)
+	if parent, ok := s.nodes[pathpkg.Dir(p)]; ok {
+		parent.modTime = time.Now()
+	}
+	return nil
+}
+
+// directories serves /directories: GET lists and POST creates
+func (s *Server) directories(w http.ResponseWriter, r *http.Request) {
+	p, err := cleanPath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		var resp *agfs.ListResponse
+		if resp, err = s.list(r, p); err == nil {
+			writeJSON(w, http.StatusOK, resp)
+		}
+	case http.MethodPost:
+		var mode uint32
+		if mode, err = modeParam(r, 0755); err == nil {
+			err = s.mkdir(p, mode, r.URL.Query().Get("parents") == "true")
+		}
+		if err == nil {
+			writeJSON(w, http.StatusCreated, agfs.SuccessResponse{Message: "directory created"})
+		}
+	default:
+		methodNotAllowed(w)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+// list lists a directory in name order, a page of it if limit is set
+func (s *Server) list(r *http.Request, p string) (*agfs.ListResponse, error) {
+	limit, err := intParam(r, "limit", 0)
+	if err != nil {
+		return nil, err
+	}
+	after := r.URL.Query().Get("after")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir, err := s.lookup(p)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.isDir {
+		return nil, errNotDir
+	}
+
+	children := s.children(p, false)
+	sort.Strings(children)
+	resp := &agfs.ListResponse{Files: []agfs.FileInfoResponse{}}
+	for _, c := range children {
+		name := pathpkg.Base(c)
+		if after != "" && name <= after {
+			continue
+		}
+		if limit > 0 && int64(len(resp.Files)) == limit {
+			resp.Next = resp.Files[len(resp.Files)-1].Name
+			break
+		}
+		resp.Files = append(resp.Files, fileInfo(c, s.nodes[c]))
+	}
+	return resp, nil
+}
+
+func (s *Server) mkdir(p string, mode uint32, parents bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !parents {
+		return s.add(p, s.newNode(mode, true))
+	}
+	for _, dir := range ancestors(p) {
+		if n, ok := s.nodes[dir]; ok {
+			if !n.isDir {
+				return errNotDir
+			}
+			continue
+		}
+		if err := s.add(dir, s.newNode(mode, true)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ancestors returns p and the directories above it, outermost first
+func ancestors(p string) []string {
+	var dirs []string
+	for ; p != "/"; p = pathpkg.Dir(p) {
+		dirs = append([]string{p}, dirs...)
+	}
+	return dirs
+}
+
+// stat serves GET and HEAD /stat
+func (s *Server) stat(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		methodNotAllowed(w)
+		return
+	}
+	p, err := cleanPath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	n, err := s.lookup(p)
+	var info agfs.FileInfoResponse
+	if err == nil {
+		info = fileInfo(p, n)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, info)
+}
+
+// post runs op for a POST request with a path and an optional JSON body
+// decoded into req
+func (s *Server) post(w http.ResponseWriter, r *http.Request, req interface{}, op func(p string) error) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	p, err := cleanPath(r)
+	if err == nil && req != nil {
+		if json.NewDecoder(r.Body).Decode(req) != nil {
+			err = badRequest("invalid request body")
+		}
+	}
+	if err == nil {
+		err = op(p)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, agfs.SuccessResponse{Message: "ok"})
+}
+
+func (s *Server) rename(w http.ResponseWriter, r *http.Request) {
+	var req agfs.RenameRequest
+	s.post(w, r, &req, func(p string) error {
+		if req.NewPath == "" {
+			return badRequest("newPath is required")
+		}
+		newPath := pathpkg.Clean("/" + req.NewPath)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		n, err := s.lookup(p)
+		if err != nil {
+			return err
+		}
+		if newPath == p {
+			return nil
+		}
+		if strings.HasPrefix(newPath, p+"/") {
+			return badRequest("cannot move a directory into itself")
+		}
+		if old, ok := s.nodes[newPath]; ok {
+			if old.isDir && len(s.children(newPath, false)) > 0 {
+				return errNotEmpty
+			}
+			delete(s.nodes, newPath)
+		}
+		if err := s.add(newPath, n); err != nil {
+			return err
+		}
+		delete(s.nodes, p)
+		for _, c := range s.children(p, true) {
+			s.nodes[newPath+c[len(p):]] = s.nodes[c]
+			delete(s.nodes, c)
+		}
+		return nil
+	})
+}
+
+// clone copies the file, there being no blocks to share in memory
+func (s *Server) clone(w http.ResponseWriter, r *http.Request) {
+	if s.isDisabled(agfs.FeatureClone) {
+		writeError(w, &statusError{http.StatusNotImplemented, "clone not supported"})
+		return
+	}
+	var req agfs.CloneRequest
+	s.post(w, r, &req, func(p string) error {
+		if req.NewPath == "" {
+			return badRequest("newPath is required")
+		}
+		newPath := pathpkg.Clean("/" + req.NewPath)
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		n, err := s.lookupFile(p)
+		if err != nil {
+			return err
+		}
+		if old, ok := s.nodes[newPath]; ok {
+			if old.isDir {
+				return errIsDir
+			}
+			delete(s.nodes, newPath)
+		}
+		c := s.newNode(n.mode, false)
+		c.data = append([]byte(nil), n.data...)
+		return s.add(newPath, c)
+	})
+}
+
+// copyRange copies a range of a file into another, reporting how many bytes
+// it copied
+func (s *Server) copyRange(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		methodNotAllowed(w)
+		return
+	}
+	if s.isDisabled(agfs.FeatureCopyRange) {
+		writeError(w, &statusError{http.StatusNotImplemented, "copy range not supported"})
+		return
+	}
+	p, err := cleanPath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	var req agfs.CopyRangeRequest
+	if json.NewDecoder(r.Body).Decode(&req) != nil {
+		writeError(w, badRequest("invalid request body"))
+		return
+	}
+	if req.DstPath == "" || req.Offset < 0 || req.DstOffset < 0 || req.Length < 0 {
+		writeError(w, badRequest("dstPath and non-negative offsets and length are required"))
+		return
+	}
+	dstPath := pathpkg.Clean("/" + req.DstPath)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	src, err := s.lookupFile(p)
+	var dst *node
+	if err == nil {
+		dst, err = s.lookupFile(dstPath)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	length := req.Length
+	if remaining := int64(len(src.data)) - req.Offset; remaining < length {
+		length = remaining
+	}
+	if length <= 0 {
+		writeJSON(w, http.StatusOK, agfs.CopyRangeResponse{})
+		return
+	}
+	if p == dstPath && req.Offset < req.DstOffset+length && req.DstOffset < req.Offset+length {
+		writeError(w, badRequest("the ranges overlap"))
+		return
+	}
+	dst.writeAt(append([]byte(nil), src.data[req.Offset:req.Offset+length]...), req.DstOffset)
+	writeJSON(w, http.StatusOK, agfs.CopyRangeResponse{Copied: length})
+}
+
+func (s *Server) chmod(w http.ResponseWriter, r *http.Request) {
+	var req agfs.ChmodRequest
+	s.post(w, r, &req, func(p string) error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		n, err := s.lookup(p)
+		if err != nil {
+			return err
+		}
+		n.mode = req.Mode & 07777
+		return nil
+	})
+}
+
+func (s *Server) truncate(w http.ResponseWriter, r *http.Request) {
+	s.post(w, r, nil, func(p string) error {
+		size, err := intParam(r, "size", -1)
+		if err != nil {
+			return err
+		}
+		if size < 0 {
+			return badRequest("size parameter is required")
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		n, err := s.lookupFile(p)
+		if err != nil {
+			return err
+		}
+		n.resize(size)
+		return nil
+	})
+}
+
+func (s *Server) symlink(w http.ResponseWriter, r *http.Request) {
+	var req agfs.SymlinkRequest
+	s.post(w, r, &req, func(p string) error {
+		if req.Target == "" {
+			return badRequest("target is required")
+		}
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		n := s.newNode(0777, false)
+		n.target = req.Target
+		return s.add(p, n)
+	})
+}
+
+func (s *Server) readlink(w http.ResponseWriter, r *http.Request) {
+	p, err := cleanPath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	n, err := s.lookup(p)
+	target := ""
+	if err == nil {
+		if target = n.target; target == "" {
+			err = badRequest("not a symbolic link")
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, agfs.ReadlinkResponse{Target: target})
+}
+
+func (s *Server) isDisabled(feature string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.disabled[feature]
+}
@@ -0,0 +1,185 @@
+package agfstest
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+func newClient(t *testing.T) (*Server, *agfs.Client) {
+	t.Helper()
+	srv := NewServer()
+	t.Cleanup(srv.Close)
+	return srv, agfs.NewClient(srv.URL)
+}
+
+func TestServerFiles(t *testing.T) {
+	_, client := newClient(t)
+
+	if err := client.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping failed: %v", err)
+	}
+	if err := client.MkdirAll("/a/b", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if _, err := client.Write("/a/b/file", []byte("hello world")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	data, err := client.Read("/a/b/file", 6, 3)
+	if err != nil || string(data) != "wor" {
+		t.Errorf("Expected a ranged read of %q, got %q (%v)", "wor", data, err)
+	}
+	info, err := client.Stat("/a/b/file")
+	if err != nil || info.Size != 11 || info.IsDir {
+		t.Errorf("Expected an 11-byte file, got %+v (%v)", info, err)
+	}
+
+	if err := client.Rename("/a/b", "/a/c"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	files, err := client.ReadDir("/a/c")
+	if err != nil || len(files) != 1 || files[0].Name != "file" {
+		t.Errorf("Expected the file to move with its directory, got %+v (%v)", files, err)
+	}
+	if err := client.Truncate("/a/c/file", 5); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+	if data, _ := client.Read("/a/c/file", 0, -1); string(data) != "hello" {
+		t.Errorf("Expected the truncated content, got %q", data)
+	}
+	if err := client.Clone("/a/c/file", "/a/clone"); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if _, err := client.Write("/a/clone", []byte("bye")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, _ := client.Read("/a/c/file", 0, -1); string(data) != "hello" {
+		t.Errorf("Expected the original unchanged by writing its clone, got %q", data)
+	}
+	if n, err := client.CopyRange("/a/c/file", 1, "/a/clone", 3, 10); err != nil || n != 4 {
+		t.Fatalf("Expected CopyRange to stop at the end of the source, got %d (%v)", n, err)
+	}
+	if data, _ := client.Read("/a/clone", 0, -1); string(data) != "byeello" {
+		t.Errorf("Expected the range appended to the clone, got %q", data)
+	}
+	if err := client.Symlink("/a/c/file", "/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if target, err := client.Readlink("/link"); err != nil || target != "/a/c/file" {
+		t.Errorf("Expected the link target, got %q (%v)", target, err)
+	}
+
+	// Error paths map to the SDK's errors
+	if _, err := client.Stat("/a/b/file"); !errors.Is(err, agfs.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for the old path, got %v", err)
+	}
+	if err := client.CreateExclusive("/a/c/file"); !errors.Is(err, agfs.ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists, got %v", err)
+	}
+	if err := client.Remove("/a"); err == nil {
+		t.Error("Expected removing a non-empty directory to fail")
+	}
+	if err := client.RemoveAll("/a"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if ok, err := client.Exists("/a/c/file"); err != nil || ok {
+		t.Errorf("Expected the file to be removed, got %v (%v)", ok, err)
+	}
+}
+
+func TestServerReadDirPage(t *testing.T) {
+	_, client := newClient(t)
+	for _, name := range []string{"c", "a", "d", "b", "e"} {
+		if err := client.Create("/" + name); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	var names []string
+	after := ""
+	for pages := 0; pages < 5; pages++ {
+		files, next, err := client.ReadDirPage("/", after, 2)
+		if err != nil {
+			t.Fatalf("ReadDirPage failed: %v", err)
+		}
+		for _, f := range files {
+			names = append(names, f.Name)
+		}
+		if after = next; after == "" {
+			break
+		}
+	}
+	if got := len(names); got != 5 || names[0] != "a" || names[4] != "e" {
+		t.Errorf("Expected a to e in order, got %v", names)
+	}
+}
+
+func TestServerHandles(t *testing.T) {
+	srv, client := newClient(t)
+
+	id, err := client.OpenHandle("/file", agfs.OpenFlagReadWrite|agfs.OpenFlagCreate, 0644)
+	if err != nil {
+		t.Fatalf("OpenHandle failed: %v", err)
+	}
+	if n, err := client.WriteHandle(id, []byte("hello"), 0); err != nil || n != 5 {
+		t.Fatalf("Expected 5 bytes written, got %d (%v)", n, err)
+	}
+	if _, err := client.WriteHandle(id, []byte(" world"), 5); err != nil {
+		t.Fatalf("WriteHandle failed: %v", err)
+	}
+	if data, err := client.ReadHandle(id, 6, 100); err != nil || string(data) != "world" {
+		t.Errorf("Expected a short read at the end, got %q (%v)", data, err)
+	}
+	if data, err := client.ReadHandle(id, 11, 100); err != nil || len(data) != 0 {
+		t.Errorf("Expected an empty read at EOF, got %q (%v)", data, err)
+	}
+	if info, err := client.StatHandle(id); err != nil || info.Size != 11 {
+		t.Errorf("Expected an 11-byte file, got %+v (%v)", info, err)
+	}
+
+	stream, err := client.ReadHandleStream(id)
+	if err != nil {
+		t.Fatalf("ReadHandleStream failed: %v", err)
+	}
+	data, err := io.ReadAll(stream)
+	stream.Close()
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("Expected the stream to return the file, got %q (%v)", data, err)
+	}
+
+	if err := client.CloseHandle(id); err != nil {
+		t.Fatalf("CloseHandle failed: %v", err)
+	}
+	if _, err := client.ReadHandle(id, 0, 1); !errors.Is(err, agfs.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a closed handle, got %v", err)
+	}
+	if n := srv.OpenHandles(); n != 0 {
+		t.Errorf("Expected no open handles, got %d", n)
+	}
+
+	if _, err := client.OpenHandle("/file", agfs.OpenFlagWriteOnly|agfs.OpenFlagCreate|agfs.OpenFlagExclusive, 0644); !errors.Is(err, agfs.ErrAlreadyExists) {
+		t.Errorf("Expected ErrAlreadyExists for an exclusive open, got %v", err)
+	}
+	if _, err := client.OpenHandle("/missing", agfs.OpenFlagReadOnly, 0); !errors.Is(err, agfs.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestServerDisable(t *testing.T) {
+	srv, client := newClient(t)
+	srv.Disable(agfs.FeatureHandles)
+
+	info, err := client.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerInfo failed: %v", err)
+	}
+	if info.Supports(agfs.FeatureHandles) || !info.Supports(agfs.FeatureStream) {
+		t.Errorf("Expected only streaming to be advertised, got %v", info.Features)
+	}
+	if _, err := client.OpenHandle("/file", agfs.OpenFlagCreate, 0644); !errors.Is(err, agfs.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+}
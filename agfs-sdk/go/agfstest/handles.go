@@ -0,0 +1,270 @@
+package agfstest
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// streamChunk is the size of the chunks a handle stream is sent in
+const streamChunk = 64 * 1024
+
+var errNoHandle = &statusError{http.StatusNotFound, "handle not found"}
+
+// handleRoutes serves /handles/open and /handles/<id>[/<op>]
+func (s *Server) handleRoutes(w http.ResponseWriter, r *http.Request) {
+	if s.isDisabled(agfs.FeatureHandles) {
+		writeError(w, &statusError{http.StatusNotImplemented, "file handles not supported"})
+		return
+	}
+
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/handles/")
+	if rest == "open" {
+		if r.Method != http.MethodPost {
+			methodNotAllowed(w)
+			return
+		}
+		s.openHandle(w, r)
+		return
+	}
+
+	idStr, op, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		writeError(w, badRequest("invalid handle ID: must be a number"))
+		return
+	}
+	s.mu.Lock()
+	h, ok := s.handles[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, errNoHandle)
+		return
+	}
+
+	switch {
+	case op == "" && (r.Method == http.MethodGet || r.Method == http.MethodDelete):
+		s.handleInfo(w, r, h)
+	case op == "read" && r.Method == http.MethodGet:
+		err = s.handleRead(w, r, h)
+	case op == "write" && r.Method == http.MethodPut:
+		err = s.handleWrite(w, r, h)
+	case op == "seek" && r.Method == http.MethodPost:
+		err = s.handleSeek(w, r, h)
+	case op == "sync" && r.Method == http.MethodPost:
+		writeJSON(w, http.StatusOK, agfs.SuccessResponse{Message: "synced"})
+	case op == "stat" && r.Method == http.MethodGet:
+		s.mu.Lock()
+		info := fileInfo(h.path, h.node)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, info)
+	case op == "stream" && r.Method == http.MethodGet:
+		err = s.handleStream(w, r, h)
+	default:
+		methodNotAllowed(w)
+	}
+	if err != nil {
+		writeError(w, err)
+	}
+}
+
+func (s *Server) openHandle(w http.ResponseWriter, r *http.Request) {
+	p, err := cleanPath(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	flags, err := intParam(r, "flags", 0)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	mode, err := modeParam(r, 0644)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.mu.Lock()
+	h, err := s.open(p, int(flags), mode)
+	s.mu.Unlock()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, agfs.HandleResponse{HandleID: h.id})
+}
+
+// open opens a handle on p. The caller must hold s.mu.
+func (s *Server) open(p string, flags int, mode uint32) (*handle, error) {
+	n, err := s.lookupFile(p)
+	switch {
+	case err == errNotFound && flags&flagCreate != 0:
+		n = s.newNode(mode, false)
+		if err := s.add(p, n); err != nil {
+			return nil, err
+		}
+	case err != nil:
+		return nil, err
+	case flags&flagCreate != 0 && flags&flagExcl != 0:
+		return nil, errExists
+	case flags&flagTrunc != 0 && flags&flagAccess != accessReadOnly:
+		n.resize(0)
+	}
+
+	s.nextID++
+	h := &handle{id: s.nextID, path: p, flags: flags, node: n}
+	s.handles[h.id] = h
+	return h, nil
+}
+
+// handleInfo serves GET /handles/<id>, and DELETE to close the handle
+func (s *Server) handleInfo(w http.ResponseWriter, r *http.Request, h *handle) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, http.StatusOK, agfs.HandleInfo{ID: h.id, Path: h.path, Flags: agfs.OpenFlag(h.flags)})
+	case http.MethodDelete:
+		s.mu.Lock()
+		delete(s.handles, h.id)
+		s.mu.Unlock()
+		writeJSON(w, http.StatusOK, agfs.SuccessResponse{Message: "handle closed"})
+	}
+}
+
+// handleRead reads at offset if it is given, or else from the handle's
+// position, which it advances
+func (s *Server) handleRead(w http.ResponseWriter, r *http.Request, h *handle) error {
+	if h.flags&flagAccess == accessWriteOnly {
+		return errWriteOnly
+	}
+	size, err := intParam(r, "size", 4096)
+	if err != nil {
+		return err
+	}
+	if size < 0 {
+		size = maxHandleRead
+	}
+	offset, err := intParam(r, "offset", -1)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	sequential := offset < 0
+	if sequential {
+		offset = h.pos
+	}
+	data := h.node.readAt(offset, size)
+	if sequential {
+		h.pos += int64(len(data))
+	}
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("X-Bytes-Read", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+	return nil
+}
+
+// handleWrite writes at offset if it is given, or else at the handle's
+// position, which it advances. Handles opened for appending always write
+// at the end.
+func (s *Server) handleWrite(w http.ResponseWriter, r *http.Request, h *handle) error {
+	if h.flags&flagAccess == accessReadOnly {
+		return errReadOnly
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return badRequest("failed to read request body")
+	}
+	offset, err := intParam(r, "offset", -1)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	sequential := offset < 0
+	switch {
+	case h.flags&flagAppend != 0:
+		offset = int64(len(h.node.data))
+	case sequential:
+		offset = h.pos
+	}
+	h.node.writeAt(data, offset)
+	if sequential {
+		h.pos = offset + int64(len(data))
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]int{"bytes_written": len(data)})
+	return nil
+}
+
+// handleSeek moves the handle's position. Like agfs-server, it reports the
+// new position as "position".
+func (s *Server) handleSeek(w http.ResponseWriter, r *http.Request, h *handle) error {
+	offset, err := intParam(r, "offset", 0)
+	if err != nil {
+		return err
+	}
+	whence, err := intParam(r, "whence", io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	pos := offset
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		pos += h.pos
+	case io.SeekEnd:
+		pos += int64(len(h.node.data))
+	default:
+		s.mu.Unlock()
+		return badRequest("invalid whence parameter (must be 0, 1, or 2)")
+	}
+	if pos < 0 {
+		s.mu.Unlock()
+		return badRequest("negative position")
+	}
+	h.pos = pos
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]int64{"position": pos})
+	return nil
+}
+
+// handleStream sends the file from the handle's position to its end in
+// chunks, advancing the position
+func (s *Server) handleStream(w http.ResponseWriter, r *http.Request, h *handle) error {
+	if s.isDisabled(agfs.FeatureStream) {
+		return &statusError{http.StatusNotImplemented, "streaming not supported"}
+	}
+	if h.flags&flagAccess == accessWriteOnly {
+		return errWriteOnly
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	for {
+		s.mu.Lock()
+		data := h.node.readAt(h.pos, streamChunk)
+		h.pos += int64(len(data))
+		s.mu.Unlock()
+		if len(data) == 0 {
+			return nil
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
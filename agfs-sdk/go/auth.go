@@ -0,0 +1,20 @@
+package agfs
+
+import "net/http"
+
+// WithToken makes the client authenticate every request with token, as a
+// bearer token. A server authenticating requests serves them in the mount
+// namespace of the principal the token belongs to, and rejects requests
+// without a token it accepts with ErrUnauthorized.
+func WithToken(token string) ClientOption {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// authorize adds the client's token to req
+func (c *Client) authorize(req *http.Request) {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+}
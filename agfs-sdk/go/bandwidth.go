@@ -0,0 +1,105 @@
+package agfs
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// BandwidthLimiter paces data to a rate in bytes per second with a token
+// bucket. It may be shared, e.g. by the clients of several servers, to cap
+// their throughput together. A nil limiter is unlimited.
+type BandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// NewBandwidthLimiter returns a limiter of bytesPerSec, passing up to a
+// second's worth of data at once, or nil (unlimited) if bytesPerSec <= 0
+func NewBandwidthLimiter(bytesPerSec int64) *BandwidthLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	rate := float64(bytesPerSec)
+	return &BandwidthLimiter{rate: rate, burst: rate, tokens: rate, last: time.Now(), now: time.Now}
+}
+
+// WaitN waits until n bytes may pass under the limit. Bytes beyond what the
+// bucket holds are taken on credit, so a transfer larger than a second's
+// worth waits for its whole size, and those queued behind it wait their
+// turn. If ctx is done first, the bytes are given back and ctx's error
+// returned, so a cancelled transfer doesn't hold up the others.
+func (l *BandwidthLimiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	now := l.now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens = math.Min(l.burst, l.tokens+float64(n))
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
+
+// EnableBandwidthLimit paces the data the client sends and receives,
+// request and response bodies alike, streams included, to limiter. Bodies
+// are paced as they are read, so a request waits for its data under the
+// limit of its context.
+func (c *Client) EnableBandwidthLimit(limiter *BandwidthLimiter) {
+	c.bandwidth = limiter
+}
+
+// limitRequest paces the body of req to the client's bandwidth limit
+func (c *Client) limitRequest(req *http.Request) {
+	if c.bandwidth != nil && req.Body != nil && req.Body != http.NoBody {
+		req.Body = &limitedBody{ReadCloser: req.Body, ctx: req.Context(), limiter: c.bandwidth}
+	}
+}
+
+// limitResponse paces the body of resp to the client's bandwidth limit
+func (c *Client) limitResponse(ctx context.Context, resp *http.Response) {
+	if c.bandwidth != nil {
+		resp.Body = &limitedBody{ReadCloser: resp.Body, ctx: ctx, limiter: c.bandwidth}
+	}
+}
+
+// limitedBody passes the data read from it once limiter allows
+type limitedBody struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *BandwidthLimiter
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if waitErr := b.limiter.WaitN(b.ctx, n); waitErr != nil {
+		return 0, waitErr
+	}
+	return n, err
+}
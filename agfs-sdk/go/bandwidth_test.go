@@ -0,0 +1,40 @@
+package agfs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBandwidthLimiter_WaitN(t *testing.T) {
+	now := time.Unix(0, 0)
+	l := NewBandwidthLimiter(1000)
+	l.now = func() time.Time { return now }
+	l.last = now
+
+	// The burst passes at once
+	if err := l.WaitN(context.Background(), 1000); err != nil {
+		t.Fatalf("WaitN within burst: %v", err)
+	}
+
+	// Bytes beyond it wait, and are given back once ctx is done
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := l.WaitN(ctx, 500); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("WaitN over the limit: got %v, want DeadlineExceeded", err)
+	}
+	if l.tokens != 0 {
+		t.Errorf("tokens after cancelled wait = %v, want 0", l.tokens)
+	}
+
+	// Half a second refills half the bucket
+	now = now.Add(500 * time.Millisecond)
+	if err := l.WaitN(context.Background(), 500); err != nil {
+		t.Fatalf("WaitN after refill: %v", err)
+	}
+
+	if err := (*BandwidthLimiter)(nil).WaitN(context.Background(), 1<<30); err != nil {
+		t.Errorf("nil limiter: %v", err)
+	}
+}
@@ -0,0 +1,242 @@
+package agfs
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the server while the circuit breaker is open
+var ErrCircuitOpen = fmt.Errorf("circuit breaker open: server unavailable")
+
+// BreakerState is the state of the client circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed lets every request through
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fast-fails every request until the cool-down elapses
+	BreakerOpen
+	// BreakerHalfOpen lets a limited number of probe requests through
+	BreakerHalfOpen
+)
+
+// String returns the state name
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("BreakerState(%d)", int(s))
+	}
+}
+
+// BreakerConfig configures the client circuit breaker
+type BreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures that trips the breaker
+	FailureThreshold int
+	// CoolDown is how long the breaker stays open before allowing probes
+	CoolDown time.Duration
+	// HalfOpenProbes is the number of concurrent probe requests allowed while half-open (default 1)
+	HalfOpenProbes int
+	// Now returns the current time (default time.Now), overridable for tests
+	Now func() time.Time
+}
+
+// circuitBreaker tracks consecutive request failures against the server.
+// Transport errors and 5xx responses count as failures; anything else
+// (including 4xx) proves the server is up and counts as a success.
+type circuitBreaker struct {
+	cfg BreakerConfig
+
+	mu       sync.Mutex
+	state    BreakerState
+	gen      uint64 // Incremented on every state change
+	failures int
+	openedAt time.Time
+	probes   int // in-flight probes while half-open
+}
+
+// breakerToken is what allow hands an allowed request, to report its
+// outcome with
+type breakerToken struct {
+	gen   uint64 // Generation of the breaker when the request was allowed
+	probe bool   // Whether the request holds a half-open probe slot
+}
+
+func newCircuitBreaker(cfg BreakerConfig) *circuitBreaker {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 1
+	}
+	if cfg.HalfOpenProbes <= 0 {
+		cfg.HalfOpenProbes = 1
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &circuitBreaker{cfg: cfg}
+}
+
+// setState moves the breaker to state, starting a new generation
+func (b *circuitBreaker) setState(state BreakerState) {
+	b.state = state
+	b.gen++
+	b.probes = 0
+}
+
+// currentState returns the state, moving from open to half-open once the cool-down has elapsed
+func (b *circuitBreaker) currentState() BreakerState {
+	if b.state == BreakerOpen && b.cfg.Now().Sub(b.openedAt) >= b.cfg.CoolDown {
+		b.setState(BreakerHalfOpen)
+	}
+	return b.state
+}
+
+// allow reports whether a request may be sent. Every allowed request must be
+// followed by exactly one call to record or cancel with the token returned.
+func (b *circuitBreaker) allow() (breakerToken, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := b.currentState()
+	tok := breakerToken{gen: b.gen}
+	switch state {
+	case BreakerOpen:
+		return tok, ErrCircuitOpen
+	case BreakerHalfOpen:
+		if b.probes >= b.cfg.HalfOpenProbes {
+			return tok, ErrCircuitOpen
+		}
+		b.probes++
+		tok.probe = true
+	}
+	return tok, nil
+}
+
+// record reports the outcome of an allowed request. Outcomes of requests
+// allowed before the last state change are ignored: a request sent while
+// the breaker was closed says nothing about the probes that followed.
+func (b *circuitBreaker) record(tok breakerToken, success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if tok.gen != b.gen {
+		return
+	}
+	if tok.probe {
+		b.probes--
+	}
+
+	if success {
+		b.failures = 0
+		if b.state != BreakerClosed {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.cfg.FailureThreshold {
+		b.setState(BreakerOpen)
+		b.openedAt = b.cfg.Now()
+	}
+}
+
+// cancel reports that an allowed request was abandoned by the caller, which
+// says nothing about the server's health
+func (b *circuitBreaker) cancel(tok breakerToken) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if tok.probe && tok.gen == b.gen {
+		b.probes--
+	}
+}
+
+// State returns the current breaker state
+func (b *circuitBreaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState()
+}
+
+// EnableCircuitBreaker makes the client fast-fail requests with ErrCircuitOpen
+// after cfg.FailureThreshold consecutive failures, for cfg.CoolDown, before
+// letting probe requests through again. It must be called before the client is used.
+func (c *Client) EnableCircuitBreaker(cfg BreakerConfig) {
+	c.breaker = newCircuitBreaker(cfg)
+}
+
+// BreakerState returns the state of the circuit breaker (BreakerClosed if it isn't enabled)
+func (c *Client) BreakerState() BreakerState {
+	if c.breaker == nil {
+		return BreakerClosed
+	}
+	return c.breaker.State()
+}
+
+// do sends req through the in-flight and bandwidth limits, tracer and circuit breaker. A
+// 429 response is consumed and returned as an error wrapping ErrRateLimited.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.authorize(req)
+	c.tagRequest(req)
+	c.setTimeout(req)
+	c.limitRequest(req)
+	release, err := c.acquireInflight(req)
+	if err != nil {
+		return nil, err
+	}
+	var resp *http.Response
+	if c.tracer != nil {
+		resp, err = c.doTraced(req)
+	} else {
+		resp, err = c.doBreaker(req)
+	}
+	if err != nil {
+		release()
+		return nil, err
+	}
+	if c.inflight != nil {
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: release}
+	}
+	c.limitResponse(req.Context(), resp)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		defer resp.Body.Close()
+		var errResp ErrorResponse
+		if json.NewDecoder(resp.Body).Decode(&errResp) != nil || errResp.Error == "" {
+			return nil, ErrRateLimited
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRateLimited, errResp.Error)
+	}
+	return resp, err
+}
+
+// doBreaker sends req through the circuit breaker
+func (c *Client) doBreaker(req *http.Request) (*http.Response, error) {
+	if c.breaker == nil {
+		return c.httpClient.Do(req)
+	}
+
+	tok, err := c.breaker.allow()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	switch {
+	case err != nil && req.Context().Err() != nil:
+		c.breaker.cancel(tok)
+	case err != nil:
+		c.breaker.record(tok, false)
+	default:
+		// 501 means the endpoint isn't supported, not that the server is unhealthy
+		c.breaker.record(tok, resp.StatusCode < 500 || resp.StatusCode == http.StatusNotImplemented)
+	}
+	return resp, err
+}
@@ -0,0 +1,200 @@
+package agfs
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// fakeClock is a manually advanced clock for breaker tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func TestCircuitBreaker_StateTransitions(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b := newCircuitBreaker(BreakerConfig{
+		FailureThreshold: 3,
+		CoolDown:         10 * time.Second,
+		Now:              clock.Now,
+	})
+
+	// Failures below the threshold keep the breaker closed
+	for i := 0; i < 2; i++ {
+		tok, err := b.allow()
+		if err != nil {
+			t.Fatalf("expected request %d to be allowed, got %v", i, err)
+		}
+		b.record(tok, false)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected closed, got %s", b.State())
+	}
+
+	// A success resets the consecutive failure count
+	tok, _ := b.allow()
+	b.record(tok, true)
+	for i := 0; i < 2; i++ {
+		tok, _ := b.allow()
+		b.record(tok, false)
+	}
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected closed after reset, got %s", b.State())
+	}
+
+	// Third consecutive failure trips it
+	tok, _ = b.allow()
+	b.record(tok, false)
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected open, got %s", b.State())
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen while open, got %v", err)
+	}
+
+	// Still open just before the cool-down elapses
+	clock.Advance(10*time.Second - time.Millisecond)
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected open before cool-down, got %s", b.State())
+	}
+
+	// Half-open after the cool-down, with a single probe allowed
+	clock.Advance(time.Millisecond)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open, got %s", b.State())
+	}
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected second concurrent probe to be rejected, got %v", err)
+	}
+
+	// A failed probe reopens immediately
+	b.record(probe, false)
+	if b.State() != BreakerOpen {
+		t.Fatalf("expected open after failed probe, got %s", b.State())
+	}
+
+	// A successful probe closes it
+	clock.Advance(10 * time.Second)
+	probe, err = b.allow()
+	if err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	b.record(probe, true)
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected closed after successful probe, got %s", b.State())
+	}
+}
+
+func TestCircuitBreaker_CancelReleasesProbe(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 1, CoolDown: time.Second, Now: clock.Now})
+
+	tok, _ := b.allow()
+	b.record(tok, false)
+	clock.Advance(time.Second)
+
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+	b.cancel(probe)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open after cancelled probe, got %s", b.State())
+	}
+	if _, err := b.allow(); err != nil {
+		t.Fatalf("expected a new probe after cancel, got %v", err)
+	}
+}
+
+func TestCircuitBreaker_IgnoresRequestsFromEarlierState(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	b := newCircuitBreaker(BreakerConfig{FailureThreshold: 1, CoolDown: time.Second, Now: clock.Now})
+
+	// A slow request sent while closed is still in flight when another
+	// trips the breaker and the cool-down elapses
+	slow, _ := b.allow()
+	tok, _ := b.allow()
+	b.record(tok, false)
+	clock.Advance(time.Second)
+
+	probe, err := b.allow()
+	if err != nil {
+		t.Fatalf("expected probe to be allowed, got %v", err)
+	}
+
+	// Its success neither frees the probe slot nor closes the breaker
+	b.record(slow, true)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open after a stale success, got %s", b.State())
+	}
+	if _, err := b.allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected a second probe to be rejected, got %v", err)
+	}
+
+	// Nor does its failure or cancellation count against the probe
+	b.record(slow, false)
+	b.cancel(slow)
+	if b.State() != BreakerHalfOpen {
+		t.Fatalf("expected half-open after a stale failure, got %s", b.State())
+	}
+
+	// The probe decides
+	b.record(probe, true)
+	if b.State() != BreakerClosed {
+		t.Fatalf("expected closed after successful probe, got %s", b.State())
+	}
+}
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	requests := 0
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(status)
+		w.Write([]byte(`{"error":"boom"}`))
+	}))
+	defer server.Close()
+
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	client := NewClient(server.URL)
+	if client.BreakerState() != BreakerClosed {
+		t.Fatalf("expected closed when disabled, got %s", client.BreakerState())
+	}
+	client.EnableCircuitBreaker(BreakerConfig{FailureThreshold: 2, CoolDown: 5 * time.Second, Now: clock.Now})
+
+	for i := 0; i < 2; i++ {
+		if _, err := client.Stat("/file"); err == nil {
+			t.Fatal("expected error from failing server")
+		}
+	}
+	if client.BreakerState() != BreakerOpen {
+		t.Fatalf("expected open, got %s", client.BreakerState())
+	}
+
+	if _, err := client.Stat("/file"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected ErrCircuitOpen, got %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("expected fast-fail without contacting the server, got %d requests", requests)
+	}
+
+	// Not found proves the server is up
+	status = http.StatusNotFound
+	clock.Advance(5 * time.Second)
+	if _, err := client.Stat("/file"); err == nil || errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected probe to reach the server, got %v", err)
+	}
+	if client.BreakerState() != BreakerClosed {
+		t.Errorf("expected closed after successful probe, got %s", client.BreakerState())
+	}
+}
@@ -2,46 +2,164 @@ package agfs
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
 	"net/http"
 	"net/url"
+	pathpkg "path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Common errors
 var (
 	// ErrNotSupported is returned when the server or endpoint does not support the requested operation (HTTP 501)
 	ErrNotSupported = fmt.Errorf("operation not supported")
+
+	// ErrAlreadyExists is returned when an exclusive create finds the file already exists (HTTP 409)
+	ErrAlreadyExists = fmt.Errorf("already exists")
+
+	// ErrRateLimited is returned when the server rejects a request because a plugin's rate limit was exceeded (HTTP 429)
+	ErrRateLimited = fmt.Errorf("rate limited")
+
+	// ErrNotFound is matched by errors for requests on a path that does not exist (HTTP 404)
+	ErrNotFound = fmt.Errorf("not found")
+
+	// ErrUnauthorized is matched by errors for requests without a token the
+	// server accepts (HTTP 401)
+	ErrUnauthorized = fmt.Errorf("unauthorized")
+
+	// ErrPermissionDenied is matched by errors for requests the plugin refused (HTTP 403)
+	ErrPermissionDenied = fmt.Errorf("permission denied")
+
+	// ErrInvalidArgument is matched by errors for malformed requests (HTTP 400)
+	ErrInvalidArgument = fmt.Errorf("invalid argument")
+
+	// ErrQuotaExceeded is matched by errors for writes that would exceed a storage quota (HTTP 507)
+	ErrQuotaExceeded = fmt.Errorf("quota exceeded")
+
+	// ErrLocked is matched by errors for locks held by another owner and for
+	// writes conflicting with another in progress (HTTP 423)
+	ErrLocked = fmt.Errorf("locked")
+
+	// ErrTimeout is matched by errors for requests the server gave up on
+	// because the client wouldn't wait any longer (HTTP 504)
+	ErrTimeout = fmt.Errorf("timed out")
+
+	// ErrNotModified is returned by conditional requests for files that still
+	// have the given etag (HTTP 304)
+	ErrNotModified = fmt.Errorf("not modified")
 )
 
+// StatusError is returned when the server rejects a request. errors.Is
+// matches it against the common error for its status code, e.g. ErrNotFound.
+type StatusError struct {
+	StatusCode int
+	Message    string
+	// RequestID identifies the request in the server's logs (empty if the
+	// server doesn't report it)
+	RequestID string
+}
+
+func (e *StatusError) Error() string {
+	if e.RequestID != "" {
+		return fmt.Sprintf("HTTP %d: %s (request %s)", e.StatusCode, e.Message, e.RequestID)
+	}
+	return fmt.Sprintf("HTTP %d: %s", e.StatusCode, e.Message)
+}
+
+// Unwrap returns the common error matching the status code, if any
+func (e *StatusError) Unwrap() error {
+	switch e.StatusCode {
+	case http.StatusNotModified:
+		return ErrNotModified
+	case http.StatusBadRequest:
+		return ErrInvalidArgument
+	case http.StatusUnauthorized:
+		return ErrUnauthorized
+	case http.StatusForbidden:
+		return ErrPermissionDenied
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusConflict:
+		return ErrAlreadyExists
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusNotImplemented:
+		return ErrNotSupported
+	case http.StatusInsufficientStorage:
+		return ErrQuotaExceeded
+	case http.StatusLocked:
+		return ErrLocked
+	case http.StatusGatewayTimeout:
+		return ErrTimeout
+	}
+	return nil
+}
+
 // Client is a Go client for AGFS HTTP API
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
+
+	// serverInfo caches the result of the first successful ServerInfo call.
+	// It is shared by copies made with WithContext.
+	serverInfo *serverInfoCache
+
+	// locks is the session advisory locks are held in, shared by copies
+	// made with WithContext
+	locks *lockSession
+
+	// ctx is the context requests are made with (nil = context.Background())
+	ctx context.Context
+	// tracer records a span per request (nil = tracing disabled)
+	tracer trace.Tracer
+
+	// breaker fast-fails requests while the server is down (nil = disabled)
+	breaker *circuitBreaker
+
+	// token authenticates requests as a bearer token (empty = none)
+	token string
+
+	// inflight limits the requests in flight (nil = unlimited)
+	inflight *inflightLimiter
+
+	// bandwidth paces request and response bodies (nil = unlimited)
+	bandwidth *BandwidthLimiter
 }
 
 // NewClient creates a new AGFS client
 // baseURL can be either full URL with "/api/v1" or just the base.
 // If "/api/v1" is not present, it will be automatically appended.
 // e.g., "http://localhost:8080" or "http://localhost:8080/api/v1"
-func NewClient(baseURL string) *Client {
-	return &Client{
-		baseURL: normalizeBaseURL(baseURL),
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
-	}
+func NewClient(baseURL string, opts ...ClientOption) *Client {
+	return NewClientWithHTTPClient(baseURL, &http.Client{
+		Timeout: 10 * time.Second,
+	}, opts...)
 }
 
 // NewClientWithHTTPClient creates a new AGFS client with custom HTTP client
-func NewClientWithHTTPClient(baseURL string, httpClient *http.Client) *Client {
-	return &Client{
+func NewClientWithHTTPClient(baseURL string, httpClient *http.Client, opts ...ClientOption) *Client {
+	c := &Client{
 		baseURL:    normalizeBaseURL(baseURL),
 		httpClient: httpClient,
+		serverInfo: &serverInfoCache{},
+		locks:      newLockSession(),
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
 // normalizeBaseURL ensures the base URL ends with /api/v1
@@ -79,12 +197,15 @@ type SuccessResponse struct {
 
 // FileInfoResponse represents file info response from the API
 type FileInfoResponse struct {
-	Name    string   `json:"name"`
-	Size    int64    `json:"size"`
-	Mode    uint32   `json:"mode"`
-	ModTime string   `json:"modTime"`
-	IsDir   bool     `json:"isDir"`
-	Meta    MetaData `json:"meta,omitempty"`
+	Name      string   `json:"name"`
+	Size      int64    `json:"size"`
+	Mode      uint32   `json:"mode"`
+	ModTime   string   `json:"modTime"`
+	IsDir     bool     `json:"isDir"`
+	Meta      MetaData `json:"meta,omitempty"`
+	Ino       uint64   `json:"ino,omitempty"`
+	Blocks    int64    `json:"blocks,omitempty"`
+	BlockSize int64    `json:"blockSize,omitempty"`
 }
 
 // IsSymlink checks if the file info represents a symbolic link
@@ -105,6 +226,7 @@ type ReadlinkResponse struct {
 // ListResponse represents directory listing response from the API
 type ListResponse struct {
 	Files []FileInfoResponse `json:"files"`
+	Next  string             `json:"next,omitempty"` // Cursor of the next page, if the listing was cut short by limit
 }
 
 // RenameRequest represents a rename request
@@ -112,18 +234,40 @@ type RenameRequest struct {
 	NewPath string `json:"newPath"`
 }
 
+// CloneRequest represents a clone request
+type CloneRequest struct {
+	NewPath string `json:"newPath"`
+}
+
+// CopyRangeRequest represents a copy range request
+type CopyRangeRequest struct {
+	Offset    int64  `json:"offset"`
+	DstPath   string `json:"dstPath"`
+	DstOffset int64  `json:"dstOffset"`
+	Length    int64  `json:"length"`
+}
+
+// CopyRangeResponse represents a copy range response
+type CopyRangeResponse struct {
+	Copied int64 `json:"copied"`
+}
+
 // ChmodRequest represents a chmod request
 type ChmodRequest struct {
 	Mode uint32 `json:"mode"`
 }
 
 func (c *Client) doRequest(method, endpoint string, query url.Values, body io.Reader) (*http.Response, error) {
+	return c.doRequestContext(c.context(), method, endpoint, query, body)
+}
+
+func (c *Client) doRequestContext(ctx context.Context, method, endpoint string, query url.Values, body io.Reader) (*http.Response, error) {
 	u := c.baseURL + endpoint
 	if len(query) > 0 {
 		u += "?" + query.Encode()
 	}
 
-	req, err := http.NewRequest(method, u, body)
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -132,7 +276,7 @@ func (c *Client) doRequest(method, endpoint string, query url.Values, body io.Re
 		req.Header.Set("Content-Type", "application/json")
 	}
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -153,10 +297,10 @@ func (c *Client) handleErrorResponse(resp *http.Response) error {
 
 	var errResp ErrorResponse
 	if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-		return fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+		return newStatusError(resp, "failed to decode error response")
 	}
 
-	return fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+	return newStatusError(resp, errResp.Error)
 }
 
 // Create creates a new file
@@ -172,6 +316,25 @@ func (c *Client) Create(path string) error {
 	return c.handleErrorResponse(resp)
 }
 
+// CreateExclusive creates a new file, failing with an error wrapping
+// ErrAlreadyExists if it already exists
+func (c *Client) CreateExclusive(path string) error {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("exclusive", "true")
+
+	resp, err := c.doRequest(http.MethodPost, "/files", query, nil)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode == http.StatusConflict {
+		defer resp.Body.Close()
+		return fmt.Errorf("%w: %s", ErrAlreadyExists, path)
+	}
+	return c.handleErrorResponse(resp)
+}
+
 // Mkdir creates a new directory
 func (c *Client) Mkdir(path string, perm uint32) error {
 	query := url.Values{}
@@ -186,6 +349,22 @@ func (c *Client) Mkdir(path string, perm uint32) error {
 	return c.handleErrorResponse(resp)
 }
 
+// MkdirAll creates a directory along with any missing parents. It succeeds
+// if the directory already exists.
+func (c *Client) MkdirAll(path string, perm uint32) error {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("mode", fmt.Sprintf("%o", perm))
+	query.Set("parents", "true")
+
+	resp, err := c.doRequest(http.MethodPost, "/directories", query, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.handleErrorResponse(resp)
+}
+
 // Remove removes a file or empty directory
 func (c *Client) Remove(path string) error {
 	query := url.Values{}
@@ -219,6 +398,19 @@ func (c *Client) RemoveAll(path string) error {
 // size: number of bytes to read (-1 means read all)
 // Returns io.EOF if offset+size >= file size (reached end of file)
 func (c *Client) Read(path string, offset int64, size int64) ([]byte, error) {
+	data, _, err := c.read(path, offset, size, "")
+	return data, err
+}
+
+// ReadIfChanged reads file content like Read unless the file still has the
+// given etag (see FileInfo.ETag), in which case it returns ErrNotModified
+// without transferring the data. It also returns the file's current etag,
+// if the plugin reports one.
+func (c *Client) ReadIfChanged(path string, offset int64, size int64, etag string) ([]byte, string, error) {
+	return c.read(path, offset, size, etag)
+}
+
+func (c *Client) read(path string, offset int64, size int64, etag string) ([]byte, string, error) {
 	query := url.Values{}
 	query.Set("path", path)
 	if offset > 0 {
@@ -228,38 +420,111 @@ func (c *Client) Read(path string, offset int64, size int64) ([]byte, error) {
 		query.Set("size", fmt.Sprintf("%d", size))
 	}
 
-	resp, err := c.doRequest(http.MethodGet, "/files", query, nil)
+	resp, err := c.conditionalGet("/files", query, etag)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, ErrNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, "", newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, "", newStatusError(resp, errResp.Error)
 	}
 
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, "", fmt.Errorf("failed to read response body: %w", err)
 	}
 
-	return data, nil
+	return data, responseETag(resp), nil
+}
+
+// conditionalGet sends a GET request, with If-None-Match unless etag is empty
+func (c *Client) conditionalGet(endpoint string, query url.Values, etag string) (*http.Response, error) {
+	if etag == "" {
+		return c.doRequest(http.MethodGet, endpoint, query, nil)
+	}
+
+	req, err := http.NewRequestWithContext(c.context(), http.MethodGet, c.baseURL+endpoint+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("If-None-Match", strconv.Quote(etag))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	return resp, nil
+}
+
+// responseETag returns the unquoted ETag header of resp, or "" if it has none
+func responseETag(resp *http.Response) string {
+	etag, err := strconv.Unquote(resp.Header.Get("ETag"))
+	if err != nil {
+		return ""
+	}
+	return etag
+}
+
+// WriteOptions control how the server acknowledges a write
+type WriteOptions struct {
+	// Durable makes the server sync the data to stable storage before
+	// acknowledging the write, so it survives a server crash. That adds the
+	// latency of an fsync, often several milliseconds, to every write; by
+	// default a write is acknowledged once the plugin accepted it. Plugins
+	// without stable storage, such as memfs, treat both alike.
+	Durable bool
+
+	// IdempotencyKey identifies the write to the server, which applies it
+	// only once for retries with the same key within a few minutes. Writes
+	// that retry get a random key by default, so a retry after a lost
+	// response doesn't write twice; set it to make retries across clients
+	// or restarts safe too. It doesn't apply to handle writes.
+	IdempotencyKey string
+}
+
+// randomID returns a random hex identifier
+func randomID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
 
 // Write writes data to a file, creating it if necessary
 // Automatically retries on network errors and timeouts (max 3 retries with exponential backoff)
 func (c *Client) Write(path string, data []byte) ([]byte, error) {
-	return c.WriteWithRetry(path, data, 3)
+	return c.write(path, data, 3, WriteOptions{})
+}
+
+// WriteWithOptions writes data to a file like Write, as opts requests
+func (c *Client) WriteWithOptions(path string, data []byte, opts WriteOptions) ([]byte, error) {
+	return c.write(path, data, 3, opts)
 }
 
 // WriteWithRetry writes data to a file with configurable retry attempts
 func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byte, error) {
+	return c.write(path, data, maxRetries, WriteOptions{})
+}
+
+func (c *Client) write(path string, data []byte, maxRetries int, opts WriteOptions) ([]byte, error) {
 	query := url.Values{}
 	query.Set("path", path)
+	if opts.Durable {
+		query.Set("flags", "sync")
+	}
+	if opts.IdempotencyKey == "" && maxRetries > 0 {
+		opts.IdempotencyKey = randomID()
+	}
+	if opts.IdempotencyKey != "" {
+		query.Set("idempotency_key", opts.IdempotencyKey)
+	}
 
 	var lastErr error
 
@@ -288,10 +553,10 @@ func (c *Client) WriteWithRetry(path string, data []byte, maxRetries int) ([]byt
 		if resp.StatusCode != http.StatusOK {
 			var errResp ErrorResponse
 			if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-				return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+				return nil, newStatusError(resp, "failed to decode error response")
 			}
 
-			lastErr = fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+			lastErr = newStatusError(resp, errResp.Error)
 
 			// Retry on server errors (5xx)
 			if resp.StatusCode >= 500 && resp.StatusCode < 600 && attempt < maxRetries {
@@ -330,6 +595,11 @@ func isRetryableError(err error) bool {
 		return false
 	}
 
+	// Retrying against an open breaker would only fail fast again
+	if errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
 	// Check for timeout errors
 	if netErr, ok := err.(interface{ Timeout() bool }); ok && netErr.Timeout() {
 		return true
@@ -348,28 +618,83 @@ func isRetryableError(err error) bool {
 		strings.Contains(errStr, "timeout")
 }
 
+// Enqueue appends msg to a queue-style file, where every write adds one
+// message, such as /queuefs/jobs/enqueue. Unlike Write it is never retried,
+// so a timed-out request can't enqueue the message twice.
+func (c *Client) Enqueue(path string, msg []byte) error {
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := c.doRequest(http.MethodPut, "/files", query, bytes.NewReader(msg))
+	if err != nil {
+		return err
+	}
+
+	return c.handleErrorResponse(resp)
+}
+
+// Dequeue removes and returns the next message from a queue-style file, where
+// every read of the whole file consumes one message, such as
+// /queuefs/jobs/dequeue. ok is false when the queue is empty, which queuefs
+// reports as an empty JSON object.
+//
+// The file is read in a single request: a ranged read or a read through a
+// handle would consume a message per request. agfs-fuse does the same for
+// files it opens without a server-side handle, buffering the first read so
+// that one open and read consumes exactly one message.
+func (c *Client) Dequeue(path string) (msg []byte, ok bool, err error) {
+	data, err := c.Read(path, 0, -1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) == 0 || string(trimmed) == "{}" {
+		return nil, false, nil
+	}
+	return data, true, nil
+}
+
 // ReadDir lists the contents of a directory
 func (c *Client) ReadDir(path string) ([]FileInfo, error) {
 	query := url.Values{}
 	query.Set("path", path)
 
+	files, _, err := c.readDir(query)
+	return files, err
+}
+
+// ReadDirPage lists up to limit entries of a directory, in name order,
+// starting after the entry named after ("" for the first page). It returns
+// the cursor to pass as after for the next page, or "" after the last one.
+// Entries added or removed between pages may be missed, but the others are
+// listed once. Servers without paging return the whole listing as one page.
+func (c *Client) ReadDirPage(path string, after string, limit int) ([]FileInfo, string, error) {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("limit", strconv.Itoa(limit))
+	if after != "" {
+		query.Set("after", after)
+	}
+	return c.readDir(query)
+}
+
+func (c *Client) readDir(query url.Values) ([]FileInfo, string, error) {
 	resp, err := c.doRequest(http.MethodGet, "/directories", query, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, "", newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, "", newStatusError(resp, errResp.Error)
 	}
 
 	var listResp ListResponse
 	if err := json.NewDecoder(resp.Body).Decode(&listResp); err != nil {
-		return nil, fmt.Errorf("failed to decode list response: %w", err)
+		return nil, "", fmt.Errorf("failed to decode list response: %w", err)
 	}
 
 	files := make([]FileInfo, 0, len(listResp.Files))
@@ -383,29 +708,46 @@ func (c *Client) ReadDir(path string) ([]FileInfo, error) {
 			IsDir:     f.IsDir,
 			IsSymlink: f.IsSymlink(),
 			Meta:      f.Meta,
+			Ino:       f.Ino,
+			Blocks:    f.Blocks,
+			BlockSize: f.BlockSize,
 		})
 	}
 
-	return files, nil
+	return files, listResp.Next, nil
 }
 
 // Stat returns file information
 func (c *Client) Stat(path string) (*FileInfo, error) {
+	return c.stat(path, "")
+}
+
+// StatIfChanged returns file information like Stat unless the file still
+// has the given etag (see FileInfo.ETag), in which case it returns
+// ErrNotModified, so a cached FileInfo can be kept
+func (c *Client) StatIfChanged(path string, etag string) (*FileInfo, error) {
+	return c.stat(path, etag)
+}
+
+func (c *Client) stat(path string, etag string) (*FileInfo, error) {
 	query := url.Values{}
 	query.Set("path", path)
 
-	resp, err := c.doRequest(http.MethodGet, "/stat", query, nil)
+	resp, err := c.conditionalGet("/stat", query, etag)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, ErrNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	var fileInfo FileInfoResponse
@@ -423,9 +765,66 @@ func (c *Client) Stat(path string) (*FileInfo, error) {
 		IsDir:     fileInfo.IsDir,
 		IsSymlink: fileInfo.IsSymlink(),
 		Meta:      fileInfo.Meta,
+		Ino:       fileInfo.Ino,
+		Blocks:    fileInfo.Blocks,
+		BlockSize: fileInfo.BlockSize,
 	}, nil
 }
 
+// ContentType returns the MIME type of path: the one its plugin reports, or
+// else one guessed from its extension or sniffed from its first 512 bytes,
+// like the server's HTTP gateways do. Files that can't be read twice, such as
+// a queue's dequeue file, aren't sniffed and are application/octet-stream.
+func (c *Client) ContentType(path string) (string, error) {
+	info, err := c.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if ctype := info.Meta.ContentType(); ctype != "" {
+		return ctype, nil
+	}
+	if ctype := mime.TypeByExtension(pathpkg.Ext(path)); ctype != "" {
+		return ctype, nil
+	}
+	if info.IsDir || info.Size == 0 || info.Access() == AccessStream || info.Access() == AccessConsumeOnce {
+		return "application/octet-stream", nil
+	}
+	data, err := c.Read(path, 0, 512)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(data), nil
+}
+
+// Exists reports whether path exists. It asks the server with a HEAD request,
+// which transfers no file information, falling back to Stat on servers that
+// don't answer HEAD.
+func (c *Client) Exists(path string) (bool, error) {
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := c.doRequest(http.MethodHead, "/stat", query, nil)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	case http.StatusMethodNotAllowed:
+		_, err := c.Stat(path)
+		if errors.Is(err, ErrNotFound) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+	// A HEAD response has no body to read the error message from
+	return false, newStatusError(resp, http.StatusText(resp.StatusCode))
+}
+
 // Rename renames/moves a file or directory
 func (c *Client) Rename(oldPath, newPath string) error {
 	query := url.Values{}
@@ -445,6 +844,61 @@ func (c *Client) Rename(oldPath, newPath string) error {
 	return c.handleErrorResponse(resp)
 }
 
+// Clone makes newPath a copy of the file at path, replacing it if it exists.
+// The server clones it copy-on-write where the backend can, so the copy
+// shares its blocks with the original until either is modified, and copies
+// the content otherwise; either way the files are independent afterwards.
+// Servers that don't advertise FeatureClone lack the endpoint.
+func (c *Client) Clone(path, newPath string) error {
+	query := url.Values{}
+	query.Set("path", path)
+
+	reqBody := CloneRequest{NewPath: newPath}
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return fmt.Errorf("failed to marshal clone request: %w", err)
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/clone", query, bytes.NewReader(jsonData))
+	if err != nil {
+		return err
+	}
+
+	return c.handleErrorResponse(resp)
+}
+
+// CopyRange copies up to length bytes of the file at srcPath, from srcOff,
+// into the existing file at dstPath at dstOff, on the server, like
+// copy_file_range(2). It returns the number of bytes copied, fewer than
+// length if the source ends first and 0 past its end. Overlapping ranges of
+// the same file are rejected. Servers that don't advertise FeatureCopyRange
+// lack the endpoint.
+func (c *Client) CopyRange(srcPath string, srcOff int64, dstPath string, dstOff, length int64) (int64, error) {
+	query := url.Values{}
+	query.Set("path", srcPath)
+
+	reqBody := CopyRangeRequest{Offset: srcOff, DstPath: dstPath, DstOffset: dstOff, Length: length}
+	jsonData, err := json.Marshal(reqBody)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal copy range request: %w", err)
+	}
+
+	resp, err := c.doRequest(http.MethodPost, "/copyrange", query, bytes.NewReader(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var copyResp CopyRangeResponse
+	if err := json.NewDecoder(resp.Body).Decode(&copyResp); err != nil {
+		return 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return copyResp.Copied, nil
+}
+
 // Chmod changes file permissions
 func (c *Client) Chmod(path string, mode uint32) error {
 	query := url.Values{}
@@ -480,19 +934,42 @@ func (c *Client) Truncate(path string, size int64) error {
 	return c.handleErrorResponse(resp)
 }
 
-// Health checks the health of the AGFS server
-func (c *Client) Health() error {
-	resp, err := c.doRequest(http.MethodGet, "/health", nil, nil)
+// Health represents the health report returned by the server
+type Health struct {
+	Status    string `json:"status"`
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	BuildTime string `json:"buildTime"`
+}
+
+// Ping checks that the AGFS server is reachable and reports itself healthy
+func (c *Client) Ping(ctx context.Context) error {
+	_, err := c.Health(ctx)
+	return err
+}
+
+// Health retrieves the health report of the AGFS server
+func (c *Client) Health(ctx context.Context) (*Health, error) {
+	resp, err := c.doRequestContext(ctx, http.MethodGet, "/health", nil, nil)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("health check failed with status: %d", resp.StatusCode)
+		return nil, fmt.Errorf("health check failed with status: %d", resp.StatusCode)
 	}
 
-	return nil
+	var health Health
+	if err := json.NewDecoder(resp.Body).Decode(&health); err != nil {
+		return nil, fmt.Errorf("failed to decode health response: %w", err)
+	}
+
+	if health.Status != "" && health.Status != "healthy" {
+		return &health, fmt.Errorf("server reported status %q", health.Status)
+	}
+
+	return &health, nil
 }
 
 // CapabilitiesResponse represents the server capabilities
@@ -519,9 +996,9 @@ func (c *Client) GetCapabilities() (*CapabilitiesResponse, error) {
 		}
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	var caps CapabilitiesResponse
@@ -532,6 +1009,87 @@ func (c *Client) GetCapabilities() (*CapabilitiesResponse, error) {
 	return &caps, nil
 }
 
+// Server feature names reported by the capabilities endpoint
+const (
+	FeatureHandles   = "handlefs"  // Stateful file handles
+	FeatureStream    = "stream"    // Streaming reads
+	FeatureGrep      = "grep"      // Server-side grep
+	FeatureDigest    = "digest"    // Server-side checksums
+	FeatureTouch     = "touch"     // Touch/update timestamp
+	FeatureXAttr     = "xattr"     // Extended attributes
+	FeatureLocks     = "locks"     // Advisory byte-range locks
+	FeatureEvents    = "events"    // Change events, see Subscribe
+	FeatureBatch     = "batch"     // Batches of metadata operations, see Pipeline
+	FeatureClone     = "clone"     // Copy-on-write clones of files, see Clone
+	FeatureCopyRange = "copyrange" // Server-side range copies, see CopyRange
+)
+
+// ServerInfo describes the server version and the optional features it supports
+type ServerInfo struct {
+	Version string
+	// Features is nil when the server predates the capabilities endpoint,
+	// in which case callers have to probe for support themselves
+	Features map[string]bool
+}
+
+// Known reports whether the server advertised its feature set
+func (s *ServerInfo) Known() bool {
+	return s != nil && s.Features != nil
+}
+
+// Supports reports whether the server advertised the given feature
+func (s *ServerInfo) Supports(feature string) bool {
+	return s.Known() && s.Features[feature]
+}
+
+// serverInfoCache holds the cached ServerInfo result
+type serverInfoCache struct {
+	mu   sync.Mutex
+	info *ServerInfo
+}
+
+// ServerInfo performs a version/capability handshake with the server.
+// The result is cached, so only the first successful call reaches the server.
+func (c *Client) ServerInfo(ctx context.Context) (*ServerInfo, error) {
+	c.serverInfo.mu.Lock()
+	defer c.serverInfo.mu.Unlock()
+
+	if c.serverInfo.info != nil {
+		return c.serverInfo.info, nil
+	}
+
+	resp, err := c.doRequestContext(ctx, http.MethodGet, "/capabilities", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	info := &ServerInfo{Version: "unknown"}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var caps CapabilitiesResponse
+		if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		info.Version = caps.Version
+		info.Features = make(map[string]bool, len(caps.Features))
+		for _, f := range caps.Features {
+			info.Features[f] = true
+		}
+	case http.StatusNotFound:
+		// Older servers don't have this endpoint
+	default:
+		var errResp ErrorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
+			return nil, newStatusError(resp, "failed to decode error response")
+		}
+		return nil, newStatusError(resp, errResp.Error)
+	}
+
+	c.serverInfo.info = info
+	return info, nil
+}
+
 // ReadStream opens a streaming connection to read from a file
 // Returns an io.ReadCloser that streams data from the server
 // The caller is responsible for closing the reader
@@ -550,6 +1108,10 @@ func (c *Client) ReadStream(path string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	// The stream outlives the caller's context, only link it to the trace
+	c.injectTraceContext(req)
+	c.authorize(req)
+	c.tagRequest(req)
 
 	resp, err := streamClient.Do(req)
 	if err != nil {
@@ -560,9 +1122,9 @@ func (c *Client) ReadStream(path string) (io.ReadCloser, error) {
 		defer resp.Body.Close()
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	// Return the response body as a ReadCloser
@@ -570,6 +1132,52 @@ func (c *Client) ReadStream(path string) (io.ReadCloser, error) {
 	return resp.Body, nil
 }
 
+// writeStreamChunk is the size of the handle writes WriteStream makes
+const writeStreamChunk = 1024 * 1024
+
+// WriteStream writes everything read from r to a file, creating or
+// truncating it, and returns the number of bytes written. The data is sent
+// in chunks through a file handle, so a large file is never held in memory;
+// servers without file handles get it in a single write. A failure leaves
+// the file with what was written before it.
+func (c *Client) WriteStream(path string, r io.Reader) (int64, error) {
+	handle, err := c.OpenHandle(path, OpenFlagWriteOnly|OpenFlagCreate|OpenFlagTruncate, 0644)
+	if errors.Is(err, ErrNotSupported) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := c.Write(path, data); err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, writeStreamChunk)
+	var written int64
+	for {
+		n, readErr := io.ReadFull(r, buf)
+		if n > 0 {
+			if _, err := c.WriteHandle(handle, buf[:n], written); err != nil {
+				c.CloseHandle(handle)
+				return written, err
+			}
+			written += int64(n)
+		}
+		if readErr == io.EOF || readErr == io.ErrUnexpectedEOF {
+			break
+		}
+		if readErr != nil {
+			c.CloseHandle(handle)
+			return written, readErr
+		}
+	}
+	return written, c.CloseHandle(handle)
+}
+
 // GrepRequest represents a grep search request
 type GrepRequest struct {
 	Path            string `json:"path"`
@@ -619,13 +1227,13 @@ func (c *Client) Grep(path, pattern string, recursive, caseInsensitive bool) (*G
 	}
 
 	reqURL := fmt.Sprintf("%s/grep", c.baseURL)
-	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(c.context(), http.MethodPost, reqURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -634,9 +1242,9 @@ func (c *Client) Grep(path, pattern string, recursive, caseInsensitive bool) (*G
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	var grepResp GrepResponse
@@ -660,13 +1268,13 @@ func (c *Client) Digest(path, algorithm string) (*DigestResponse, error) {
 	}
 
 	reqURL := fmt.Sprintf("%s/digest", c.baseURL)
-	req, err := http.NewRequest(http.MethodPost, reqURL, bytes.NewReader(body))
+	req, err := http.NewRequestWithContext(c.context(), http.MethodPost, reqURL, bytes.NewReader(body))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to execute request: %w", err)
 	}
@@ -675,9 +1283,9 @@ func (c *Client) Digest(path, algorithm string) (*DigestResponse, error) {
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	var digestResp DigestResponse
@@ -692,7 +1300,7 @@ func (c *Client) Digest(path, algorithm string) (*DigestResponse, error) {
 func (c *Client) OpenHandle(path string, flags OpenFlag, mode uint32) (int64, error) {
 	query := url.Values{}
 	query.Set("path", path)
-	query.Set("flags", fmt.Sprintf("%d", flags))
+	query.Set("flags", fmt.Sprintf("%d", flags.wireFlags()))
 	query.Set("mode", fmt.Sprintf("%o", mode))
 
 	resp, err := c.doRequest(http.MethodPost, "/handles/open", query, nil)
@@ -705,11 +1313,14 @@ func (c *Client) OpenHandle(path string, flags OpenFlag, mode uint32) (int64, er
 		if resp.StatusCode == http.StatusNotImplemented {
 			return 0, ErrNotSupported
 		}
+		if resp.StatusCode == http.StatusConflict && flags&OpenFlagExclusive != 0 {
+			return 0, fmt.Errorf("%w: %s", ErrAlreadyExists, path)
+		}
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return 0, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return 0, newStatusError(resp, "failed to decode error response")
 		}
-		return 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return 0, newStatusError(resp, errResp.Error)
 	}
 
 	var handleResp HandleResponse
@@ -733,9 +1344,9 @@ func (c *Client) CloseHandle(handleID int64) error {
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return newStatusError(resp, "failed to decode error response")
 		}
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return newStatusError(resp, errResp.Error)
 	}
 
 	return nil
@@ -757,9 +1368,9 @@ func (c *Client) ReadHandle(handleID int64, offset int64, size int) ([]byte, err
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	data, err := io.ReadAll(resp.Body)
@@ -786,6 +1397,10 @@ func (c *Client) ReadHandleStream(handleID int64) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
+	// The stream outlives the caller's context, only link it to the trace
+	c.injectTraceContext(req)
+	c.authorize(req)
+	c.tagRequest(req)
 
 	resp, err := streamClient.Do(req)
 	if err != nil {
@@ -796,28 +1411,38 @@ func (c *Client) ReadHandleStream(handleID int64) (io.ReadCloser, error) {
 		defer resp.Body.Close()
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
+	c.limitResponse(req.Context(), resp)
 	return resp.Body, nil
 }
 
 // WriteHandle writes data to a file handle
 func (c *Client) WriteHandle(handleID int64, data []byte, offset int64) (int, error) {
+	return c.WriteHandleWithOptions(handleID, data, offset, WriteOptions{})
+}
+
+// WriteHandleWithOptions writes data to a file handle as opts requests. A
+// durable write saves a separate SyncHandle call.
+func (c *Client) WriteHandleWithOptions(handleID int64, data []byte, offset int64, opts WriteOptions) (int, error) {
 	endpoint := fmt.Sprintf("/handles/%d/write", handleID)
 	query := url.Values{}
 	query.Set("offset", fmt.Sprintf("%d", offset))
+	if opts.Durable {
+		query.Set("sync", "true")
+	}
 
 	// Note: For binary data, we don't use JSON
-	req, err := http.NewRequest(http.MethodPut, c.baseURL+endpoint+"?"+query.Encode(), bytes.NewReader(data))
+	req, err := http.NewRequestWithContext(c.context(), http.MethodPut, c.baseURL+endpoint+"?"+query.Encode(), bytes.NewReader(data))
 	if err != nil {
 		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 	req.Header.Set("Content-Type", "application/octet-stream")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return 0, fmt.Errorf("write handle request failed: %w", err)
 	}
@@ -826,9 +1451,9 @@ func (c *Client) WriteHandle(handleID int64, data []byte, offset int64) (int, er
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return 0, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return 0, newStatusError(resp, "failed to decode error response")
 		}
-		return 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return 0, newStatusError(resp, errResp.Error)
 	}
 
 	// Parse bytes written from response
@@ -856,9 +1481,9 @@ func (c *Client) SyncHandle(handleID int64) error {
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return newStatusError(resp, "failed to decode error response")
 		}
-		return fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return newStatusError(resp, errResp.Error)
 	}
 
 	return nil
@@ -880,9 +1505,9 @@ func (c *Client) SeekHandle(handleID int64, offset int64, whence int) (int64, er
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return 0, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return 0, newStatusError(resp, "failed to decode error response")
 		}
-		return 0, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return 0, newStatusError(resp, errResp.Error)
 	}
 
 	var result struct {
@@ -908,9 +1533,9 @@ func (c *Client) GetHandle(handleID int64) (*HandleInfo, error) {
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	var handleInfo HandleInfo
@@ -934,9 +1559,9 @@ func (c *Client) StatHandle(handleID int64) (*FileInfo, error) {
 	if resp.StatusCode != http.StatusOK {
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return nil, fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return nil, newStatusError(resp, "failed to decode error response")
 		}
-		return nil, fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return nil, newStatusError(resp, errResp.Error)
 	}
 
 	var fileInfo FileInfoResponse
@@ -954,6 +1579,9 @@ func (c *Client) StatHandle(handleID int64) (*FileInfo, error) {
 		IsDir:     fileInfo.IsDir,
 		IsSymlink: fileInfo.IsSymlink(),
 		Meta:      fileInfo.Meta,
+		Ino:       fileInfo.Ino,
+		Blocks:    fileInfo.Blocks,
+		BlockSize: fileInfo.BlockSize,
 	}, nil
 }
 
@@ -993,9 +1621,9 @@ func (c *Client) Readlink(linkPath string) (string, error) {
 		}
 		var errResp ErrorResponse
 		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil {
-			return "", fmt.Errorf("HTTP %d: failed to decode error response", resp.StatusCode)
+			return "", newStatusError(resp, "failed to decode error response")
 		}
-		return "", fmt.Errorf("HTTP %d: %s", resp.StatusCode, errResp.Error)
+		return "", newStatusError(resp, errResp.Error)
 	}
 
 	var readlinkResp ReadlinkResponse
@@ -1,11 +1,18 @@
 package agfs
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestClient_Create(t *testing.T) {
@@ -81,6 +88,126 @@ func TestClient_Write(t *testing.T) {
 	}
 }
 
+func TestClient_DurableWrites(t *testing.T) {
+	var synced []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("flags") == "sync" || r.URL.Query().Get("sync") == "true" {
+			synced = append(synced, r.URL.Path)
+		}
+		if strings.HasPrefix(r.URL.Path, "/api/v1/handles/") {
+			json.NewEncoder(w).Encode(map[string]int{"bytes_written": 4})
+			return
+		}
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "OK"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if _, err := client.Write("/f", []byte("data")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := client.WriteHandle(1, []byte("data"), 0); err != nil {
+		t.Fatalf("WriteHandle failed: %v", err)
+	}
+	if len(synced) != 0 {
+		t.Fatalf("Expected default writes not to sync, got %v", synced)
+	}
+
+	if _, err := client.WriteWithOptions("/f", []byte("data"), WriteOptions{Durable: true}); err != nil {
+		t.Fatalf("Durable write failed: %v", err)
+	}
+	if _, err := client.WriteHandleWithOptions(1, []byte("data"), 0, WriteOptions{Durable: true}); err != nil {
+		t.Fatalf("Durable handle write failed: %v", err)
+	}
+	if len(synced) != 2 || synced[0] != "/api/v1/files" || synced[1] != "/api/v1/handles/1/write" {
+		t.Errorf("Expected both durable writes to request a sync, got %v", synced)
+	}
+}
+
+func TestClient_WriteIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.URL.Query().Get("idempotency_key"))
+		if len(keys) == 1 {
+			// The write is lost on its way back
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "bad gateway"})
+			return
+		}
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "OK"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if _, err := client.Write("/f", []byte("data")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if len(keys) != 2 || keys[0] == "" || keys[0] != keys[1] {
+		t.Fatalf("Expected a retry to reuse the write's key, got %q", keys)
+	}
+	if _, err := client.Write("/f", []byte("data")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if keys[2] == keys[0] {
+		t.Errorf("Expected every write to get its own key")
+	}
+
+	if _, err := client.WriteWithOptions("/f", []byte("data"), WriteOptions{IdempotencyKey: "job-42"}); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := client.WriteWithRetry("/f", []byte("data"), 0); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if keys[3] != "job-42" || keys[4] != "" {
+		t.Errorf("Expected the given key and none for a write that isn't retried, got %q", keys[3:])
+	}
+}
+
+func TestClient_Exists(t *testing.T) {
+	var methods []string
+	headAllowed := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methods = append(methods, r.Method)
+		if r.Method == http.MethodHead && !headAllowed {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		if r.URL.Query().Get("path") != "/file" {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "not found"})
+			return
+		}
+		json.NewEncoder(w).Encode(FileInfoResponse{Name: "file"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	for _, headAllowed = range []bool{true, false} {
+		methods = nil
+		for path, want := range map[string]bool{"/file": true, "/missing": false} {
+			exists, err := client.Exists(path)
+			if err != nil {
+				t.Fatalf("Exists %s failed: %v", path, err)
+			}
+			if exists != want {
+				t.Errorf("Expected Exists(%s) = %v, got %v", path, want, exists)
+			}
+		}
+		gets := 0
+		for _, m := range methods {
+			if m == http.MethodGet {
+				gets++
+			}
+		}
+		if headAllowed && gets != 0 {
+			t.Errorf("Expected only HEAD requests, got %v", methods)
+		}
+		if !headAllowed && gets != 2 {
+			t.Errorf("Expected a Stat fallback when HEAD isn't allowed, got %v", methods)
+		}
+	}
+}
+
 func TestClient_Mkdir(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -104,6 +231,22 @@ func TestClient_Mkdir(t *testing.T) {
 	}
 }
 
+func TestClient_MkdirAll(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("parents") != "true" {
+			t.Errorf("expected parents=true, got %s", r.URL.Query().Get("parents"))
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "directory created"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.MkdirAll("/a/b/c", 0755); err != nil {
+		t.Errorf("MkdirAll failed: %v", err)
+	}
+}
+
 func TestClient_ErrorHandling(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusNotFound)
@@ -139,6 +282,78 @@ func TestClient_OpenHandleNotSupported(t *testing.T) {
 	}
 }
 
+func TestClient_WriteStream(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		content []byte
+		writes  int
+		closed  bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case r.URL.Path == "/api/v1/handles/open":
+			if flags, _ := strconv.Atoi(r.URL.Query().Get("flags")); flags != (OpenFlagWriteOnly | OpenFlagCreate | OpenFlagTruncate).wireFlags() {
+				t.Errorf("expected write, create and truncate flags, got %d", flags)
+			}
+			json.NewEncoder(w).Encode(HandleResponse{HandleID: 7})
+		case r.URL.Path == "/api/v1/handles/7/write":
+			offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+			if offset != len(content) {
+				t.Errorf("expected a write at %d, got %d", len(content), offset)
+			}
+			data, _ := io.ReadAll(r.Body)
+			content = append(content, data...)
+			writes++
+			json.NewEncoder(w).Encode(map[string]int{"bytes_written": len(data)})
+		case r.URL.Path == "/api/v1/handles/7" && r.Method == http.MethodDelete:
+			closed = true
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	data := []byte(strings.Repeat("0123456789", writeStreamChunk/4))
+	client := NewClient(server.URL)
+	n, err := client.WriteStream("/big", strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatalf("WriteStream failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if n != int64(len(data)) || string(content) != string(data) {
+		t.Errorf("expected %d bytes written, got %d (%d received)", len(data), n, len(content))
+	}
+	if writes != 3 || !closed {
+		t.Errorf("expected 3 chunked writes and the handle closed, got %d writes, closed=%v", writes, closed)
+	}
+}
+
+func TestClient_WriteStreamWithoutHandles(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/v1/handles/open":
+			w.WriteHeader(http.StatusNotImplemented)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "filesystem does not support file handles"})
+		case "/api/v1/files":
+			body, _ = io.ReadAll(r.Body)
+			json.NewEncoder(w).Encode(SuccessResponse{Message: "written"})
+		default:
+			t.Errorf("unexpected request to %s", r.URL.Path)
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	n, err := client.WriteStream("/file", strings.NewReader("hello"))
+	if err != nil || n != 5 || string(body) != "hello" {
+		t.Errorf("expected a single write of hello, got %d, %q (%v)", n, body, err)
+	}
+}
+
 func TestClient_OpenHandleModeOctalFormat(t *testing.T) {
 	tests := []struct {
 		name         string
@@ -262,3 +477,486 @@ func TestNormalizeBaseURL(t *testing.T) {
 		})
 	}
 }
+
+func TestClient_Health(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/health" {
+			t.Errorf("expected /api/v1/health, got %s", r.URL.Path)
+		}
+		json.NewEncoder(w).Encode(Health{Status: "healthy", Version: "1.4.0", GitCommit: "abc123"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	health, err := client.Health(context.Background())
+	if err != nil {
+		t.Fatalf("Health failed: %v", err)
+	}
+	if health.Version != "1.4.0" || health.GitCommit != "abc123" {
+		t.Errorf("unexpected health response: %+v", health)
+	}
+	if err := client.Ping(context.Background()); err != nil {
+		t.Errorf("Ping failed: %v", err)
+	}
+}
+
+func TestClient_PingUnhealthy(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("expected error for unhealthy server, got nil")
+	}
+}
+
+func TestClient_PingUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	url := server.URL
+	server.Close()
+
+	client := NewClient(url)
+	if err := client.Ping(context.Background()); err == nil {
+		t.Error("expected error for unreachable server, got nil")
+	}
+}
+
+func TestClient_ServerInfoCached(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/capabilities" {
+			t.Errorf("expected /api/v1/capabilities, got %s", r.URL.Path)
+		}
+		requests++
+		json.NewEncoder(w).Encode(CapabilitiesResponse{
+			Version:  "1.4.0",
+			Features: []string{FeatureHandles, FeatureGrep},
+		})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	for i := 0; i < 3; i++ {
+		info, err := client.ServerInfo(context.Background())
+		if err != nil {
+			t.Fatalf("ServerInfo failed: %v", err)
+		}
+		if info.Version != "1.4.0" {
+			t.Errorf("expected version 1.4.0, got %s", info.Version)
+		}
+		if !info.Supports(FeatureHandles) || info.Supports(FeatureStream) {
+			t.Errorf("unexpected features: %v", info.Features)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("expected 1 request to the server, got %d", requests)
+	}
+}
+
+func TestClient_ServerInfoOldServer(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	info, err := client.ServerInfo(context.Background())
+	if err != nil {
+		t.Fatalf("ServerInfo failed: %v", err)
+	}
+	if info.Known() {
+		t.Errorf("expected unknown feature set for old server, got %v", info.Features)
+	}
+	if info.Supports(FeatureHandles) {
+		t.Error("unknown feature set must not report support")
+	}
+}
+
+func TestClient_RateLimited(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "s3fs: rate limited"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.EnableCircuitBreaker(BreakerConfig{FailureThreshold: 1, CoolDown: time.Minute})
+	for i := 0; i < 3; i++ {
+		_, err := client.Read("/file", 0, -1)
+		if !errors.Is(err, ErrRateLimited) {
+			t.Fatalf("expected ErrRateLimited, got %v", err)
+		}
+		if !strings.Contains(err.Error(), "s3fs: rate limited") {
+			t.Errorf("expected server message in error, got %v", err)
+		}
+	}
+
+	// Rate limiting is not a server failure
+	if state := client.BreakerState(); state != BreakerClosed {
+		t.Errorf("expected breaker closed, got %s", state)
+	}
+}
+
+func TestClient_CreateExclusive(t *testing.T) {
+	exists := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("exclusive") != "true" {
+			t.Errorf("expected exclusive=true, got %q", r.URL.RawQuery)
+		}
+		if exists {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "file already exists: /lock"})
+			return
+		}
+		exists = true
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(SuccessResponse{Message: "file created"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.CreateExclusive("/lock"); err != nil {
+		t.Fatalf("first CreateExclusive failed: %v", err)
+	}
+	if err := client.CreateExclusive("/lock"); !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+}
+
+func TestClient_OpenHandleExclusive(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// O_WRONLY|O_CREATE|O_EXCL in the server's encoding
+		if got := r.URL.Query().Get("flags"); got != "49" {
+			t.Errorf("expected wire flags 49, got %s", got)
+		}
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "file already exists: /lock"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	_, err := client.OpenHandle("/lock", OpenFlagWriteOnly|OpenFlagCreate|OpenFlagExclusive, 0644)
+	if !errors.Is(err, ErrAlreadyExists) {
+		t.Errorf("expected ErrAlreadyExists, got %v", err)
+	}
+}
+
+func TestOpenFlagWireFlags(t *testing.T) {
+	tests := []struct {
+		flags OpenFlag
+		wire  int
+	}{
+		{OpenFlagReadOnly, 0},
+		{OpenFlagReadWrite | OpenFlagAppend, 2 | 8},
+		{OpenFlagWriteOnly | OpenFlagCreate | OpenFlagTruncate, 1 | 16 | 64},
+		{OpenFlagWriteOnly | OpenFlagSync, 1},
+	}
+	for _, tt := range tests {
+		if got := tt.flags.wireFlags(); got != tt.wire {
+			t.Errorf("wireFlags(%d) = %d, expected %d", tt.flags, got, tt.wire)
+		}
+	}
+}
+
+func TestClient_WithToken(t *testing.T) {
+	var mu sync.Mutex
+	auth := map[string]string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		auth[r.URL.Path+" "+r.URL.Query().Get("path")] = r.Header.Get("Authorization")
+		mu.Unlock()
+		if r.URL.Path == "/api/v1/stat" {
+			json.NewEncoder(w).Encode(FileInfoResponse{Name: "file"})
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithToken("s3cret"))
+	if _, err := client.Stat("/file"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	stream, err := client.ReadStream("/file")
+	if err != nil {
+		t.Fatalf("ReadStream failed: %v", err)
+	}
+	stream.Close()
+	if _, err := NewClient(server.URL).Stat("/other"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, request := range []string{"/api/v1/stat /file", "/api/v1/files /file"} {
+		if auth[request] != "Bearer s3cret" {
+			t.Errorf("Expected the token sent with %s, got %q", request, auth[request])
+		}
+	}
+	if got := auth["/api/v1/stat /other"]; got != "" {
+		t.Errorf("Expected no token from a client without one, got %q", got)
+	}
+}
+
+func TestClient_RequestID(t *testing.T) {
+	var mu sync.Mutex
+	var ids []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		ids = append(ids, r.Header.Get(RequestIDHeader))
+		mu.Unlock()
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "no such file"})
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL).WithContext(WithRequestID(context.Background(), "op-1"))
+	_, err := client.Stat("/missing")
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.RequestID != "op-1" || !strings.Contains(err.Error(), "op-1") {
+		t.Errorf("Expected the request ID in the error, got %v", err)
+	}
+	stream, err := client.ReadStream("/missing")
+	if err == nil {
+		stream.Close()
+	}
+	if _, err := NewClient(server.URL).Stat("/missing"); err == nil || strings.Contains(err.Error(), "request") {
+		t.Errorf("Expected no request ID without one in the context, got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"op-1", "op-1", ""}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("Expected the request IDs %q sent, got %q", want, ids)
+	}
+}
+
+func TestClient_StatusErrors(t *testing.T) {
+	tests := []struct {
+		status int
+		want   error
+	}{
+		{http.StatusBadRequest, ErrInvalidArgument},
+		{http.StatusUnauthorized, ErrUnauthorized},
+		{http.StatusForbidden, ErrPermissionDenied},
+		{http.StatusNotFound, ErrNotFound},
+		{http.StatusConflict, ErrAlreadyExists},
+		{http.StatusInsufficientStorage, ErrQuotaExceeded},
+	}
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "nope"})
+		}))
+
+		client := NewClient(server.URL)
+		_, err := client.Stat("/file")
+		if !errors.Is(err, tt.want) {
+			t.Errorf("HTTP %d: expected %v, got %v", tt.status, tt.want, err)
+		}
+		var statusErr *StatusError
+		if !errors.As(err, &statusErr) || statusErr.StatusCode != tt.status || statusErr.Message != "nope" {
+			t.Errorf("HTTP %d: expected StatusError, got %#v", tt.status, err)
+		}
+		server.Close()
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "boom"})
+	}))
+	defer server.Close()
+	err := NewClient(server.URL).Mkdir("/dir", 0755)
+	if err == nil || err.Error() != "HTTP 500: boom" {
+		t.Errorf("expected HTTP 500: boom, got %v", err)
+	}
+	if errors.Is(err, ErrNotFound) || errors.Is(err, ErrNotSupported) {
+		t.Errorf("expected a 500 not to match a common error, got %v", err)
+	}
+}
+
+// queueServer mimics queuefs: each PUT to /q/enqueue adds a message and each
+// GET of /q/dequeue removes one, returning {} when the queue is empty
+func queueServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	var mu sync.Mutex
+	var queue [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch path := r.URL.Query().Get("path"); {
+		case r.Method == http.MethodPut && path == "/q/enqueue":
+			data, _ := io.ReadAll(r.Body)
+			queue = append(queue, data)
+			json.NewEncoder(w).Encode(SuccessResponse{Message: "enqueued"})
+		case r.Method == http.MethodGet && path == "/q/dequeue":
+			if r.URL.Query().Get("offset") != "" || r.URL.Query().Get("size") != "" {
+				t.Errorf("expected a whole-file read, got %s", r.URL.RawQuery)
+			}
+			if len(queue) == 0 {
+				w.Write([]byte("{}"))
+				return
+			}
+			w.Write(queue[0])
+			queue = queue[1:]
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "no such file"})
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClient_EnqueueDequeue(t *testing.T) {
+	client := NewClient(queueServer(t).URL)
+
+	for _, msg := range []string{"first", "second"} {
+		if err := client.Enqueue("/q/enqueue", []byte(msg)); err != nil {
+			t.Fatalf("Enqueue failed: %v", err)
+		}
+	}
+	for _, want := range []string{"first", "second"} {
+		msg, ok, err := client.Dequeue("/q/dequeue")
+		if err != nil || !ok || string(msg) != want {
+			t.Errorf("expected %q, got %q, ok=%v, err=%v", want, msg, ok, err)
+		}
+	}
+
+	msg, ok, err := client.Dequeue("/q/dequeue")
+	if err != nil || ok || msg != nil {
+		t.Errorf("expected empty queue, got %q, ok=%v, err=%v", msg, ok, err)
+	}
+
+	if _, _, err := client.Dequeue("/q/missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if err := client.Enqueue("/q/missing", []byte("x")); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestClient_IfChanged(t *testing.T) {
+	etag := "v1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"`+etag+`"`)
+		if r.Header.Get("If-None-Match") == `"`+etag+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if r.URL.Path == "/api/v1/stat" {
+			json.NewEncoder(w).Encode(FileInfoResponse{Name: "f", Meta: MetaData{Content: map[string]string{MetaETag: etag}}})
+			return
+		}
+		w.Write([]byte("data-" + etag))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	info, err := client.Stat("/f")
+	if err != nil || info.ETag() != "v1" {
+		t.Fatalf("expected etag v1, got %+v, %v", info, err)
+	}
+	if _, err := client.StatIfChanged("/f", info.ETag()); !errors.Is(err, ErrNotModified) {
+		t.Errorf("expected ErrNotModified, got %v", err)
+	}
+	if _, _, err := client.ReadIfChanged("/f", 0, -1, "v1"); !errors.Is(err, ErrNotModified) {
+		t.Errorf("expected ErrNotModified, got %v", err)
+	}
+
+	etag = "v2"
+	if info, err := client.StatIfChanged("/f", "v1"); err != nil || info.ETag() != "v2" {
+		t.Errorf("expected the changed file info, got %+v, %v", info, err)
+	}
+	data, newETag, err := client.ReadIfChanged("/f", 0, -1, "v1")
+	if err != nil || string(data) != "data-v2" || newETag != "v2" {
+		t.Errorf("expected the changed data and etag, got %q, %q, %v", data, newETag, err)
+	}
+}
+
+func TestClient_ContentType(t *testing.T) {
+	var reads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Query().Get("path")
+		if r.URL.Path == "/api/v1/stat" {
+			info := FileInfoResponse{Name: name, Size: 9}
+			switch name {
+			case "/typed.html":
+				info.Meta.Content = map[string]string{MetaContentType: "application/x-custom"}
+			case "/queue/dequeue":
+				info.Meta.Content = map[string]string{MetaAccess: AccessConsumeOnce}
+			}
+			json.NewEncoder(w).Encode(info)
+			return
+		}
+		reads++
+		w.Write([]byte("<p>hi</p>"))
+	}))
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	tests := []struct {
+		path  string
+		ctype string
+	}{
+		{"/typed.html", "application/x-custom"},
+		{"/page.json", "application/json"},
+		{"/untyped", "text/html; charset=utf-8"},
+		{"/queue/dequeue", "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		if ctype, err := client.ContentType(tt.path); err != nil || ctype != tt.ctype {
+			t.Errorf("%s: expected %q, got %q, %v", tt.path, tt.ctype, ctype, err)
+		}
+	}
+	if reads != 1 {
+		t.Errorf("expected only the untyped file to be read, got %d reads", reads)
+	}
+}
+
+func TestClient_ReadDirPage(t *testing.T) {
+	names := []string{"a", "b", "c"}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var list ListResponse
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		for _, name := range names {
+			if name <= r.URL.Query().Get("after") {
+				continue
+			}
+			if len(list.Files) == limit {
+				list.Next = list.Files[limit-1].Name
+				break
+			}
+			list.Files = append(list.Files, FileInfoResponse{Name: name})
+		}
+		json.NewEncoder(w).Encode(list)
+	}))
+	defer server.Close()
+	client := NewClient(server.URL)
+
+	var got []string
+	after := ""
+	for pages := 0; ; pages++ {
+		if pages > len(names) {
+			t.Fatalf("expected the listing to end, got %v", got)
+		}
+		files, next, err := client.ReadDirPage("/dir", after, 2)
+		if err != nil {
+			t.Fatalf("ReadDirPage failed: %v", err)
+		}
+		for _, f := range files {
+			got = append(got, f.Name)
+		}
+		if next == "" {
+			break
+		}
+		after = next
+	}
+	if strings.Join(got, ",") != "a,b,c" {
+		t.Errorf("expected a,b,c, got %v", got)
+	}
+}
@@ -0,0 +1,42 @@
+// Package cmdutil holds the setup shared by the agfs commands, agfs-server
+// and agfs-fuse, so both are configured the same way.
+package cmdutil
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// ConfigureLogging sets the level and format of logger. format is "text" or "json".
+func ConfigureLogging(logger *log.Logger, level, format string) error {
+	lvl, err := log.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	switch format {
+	case "text", "":
+		logger.SetFormatter(&log.TextFormatter{
+			FullTimestamp: true,
+			CallerPrettyfier: func(f *runtime.Frame) (string, string) {
+				filename := filepath.Base(f.File)
+				return "", fmt.Sprintf(" | %s:%d | ", filename, f.Line)
+			},
+		})
+	case "json":
+		logger.SetFormatter(&log.JSONFormatter{
+			CallerPrettyfier: func(f *runtime.Frame) (string, string) {
+				return "", fmt.Sprintf("%s:%d", filepath.Base(f.File), f.Line)
+			},
+		})
+	default:
+		return fmt.Errorf("invalid log format %q, expected text or json", format)
+	}
+
+	logger.SetReportCaller(true)
+	logger.SetLevel(lvl)
+	return nil
+}
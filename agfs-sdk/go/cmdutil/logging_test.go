@@ -0,0 +1,41 @@
+package cmdutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestConfigureLoggingJSON(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+
+	if err := ConfigureLogging(logger, "warn", "json"); err != nil {
+		t.Fatalf("ConfigureLogging failed: %v", err)
+	}
+	logger.Info("dropped")
+	logger.Warn("kept")
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a single JSON entry, got %q: %v", buf.String(), err)
+	}
+	if entry["msg"] != "kept" || entry["level"] != "warning" {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+	if _, ok := entry["file"]; !ok {
+		t.Errorf("Expected caller file in entry %v", entry)
+	}
+}
+
+func TestConfigureLoggingInvalid(t *testing.T) {
+	if err := ConfigureLogging(log.New(), "loud", "text"); err == nil {
+		t.Error("Expected error for invalid level")
+	}
+	if err := ConfigureLogging(log.New(), "info", "xml"); err == nil {
+		t.Error("Expected error for invalid format")
+	}
+}
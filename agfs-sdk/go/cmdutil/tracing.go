@@ -0,0 +1,38 @@
+package cmdutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// NewFileTracer returns a tracer named name that writes finished spans as
+// JSON lines to path. With batch, spans are exported in the background and
+// pending ones are only written by the returned shutdown function, which
+// also closes the file; without it, each span is written as it ends, for
+// commands that exit without calling shutdown.
+func NewFileTracer(path, name string, batch bool) (trace.Tracer, func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("open trace file: %w", err)
+	}
+	exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("create trace exporter: %w", err)
+	}
+	export := sdktrace.WithSyncer(exporter)
+	if batch {
+		export = sdktrace.WithBatcher(exporter)
+	}
+	provider := sdktrace.NewTracerProvider(export)
+	shutdown := func() {
+		provider.Shutdown(context.Background())
+		f.Close()
+	}
+	return provider.Tracer(name), shutdown, nil
+}
@@ -0,0 +1,49 @@
+package cmdutil
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewFileTracer(t *testing.T) {
+	for _, batch := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "trace.jsonl")
+		tracer, shutdown, err := NewFileTracer(path, "test", batch)
+		if err != nil {
+			t.Fatalf("NewFileTracer failed: %v", err)
+		}
+		_, span := tracer.Start(context.Background(), "first")
+		span.End()
+		_, span = tracer.Start(context.Background(), "second")
+		span.End()
+		shutdown()
+
+		f, err := os.Open(path)
+		if err != nil {
+			t.Fatalf("Failed to open trace file: %v", err)
+		}
+		var names []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var span struct{ Name string }
+			if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+				t.Fatalf("Expected a JSON span per line, got %q: %v", scanner.Text(), err)
+			}
+			names = append(names, span.Name)
+		}
+		f.Close()
+		if len(names) != 2 || names[0] != "first" || names[1] != "second" {
+			t.Errorf("batch=%v: expected spans first and second, got %v", batch, names)
+		}
+	}
+}
+
+func TestNewFileTracerInvalidPath(t *testing.T) {
+	if _, _, err := NewFileTracer(filepath.Join(t.TempDir(), "missing", "trace.jsonl"), "test", false); err == nil {
+		t.Error("Expected error for a file in a missing directory")
+	}
+}
@@ -0,0 +1,109 @@
+package agfs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// InvalidationEvent reports that a path changed on the server, so anything
+// cached of it is stale. Op is the operation that changed it, such as
+// "write", "remove" or "rename"; removals and renames of a directory change
+// everything under it.
+type InvalidationEvent struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+// InvalidationReset is the Op of the event for the subscribed prefix sent
+// once the server dropped events because the subscriber fell behind.
+// Everything under the prefix must then be treated as changed.
+const InvalidationReset = "reset"
+
+// eventsIdleTimeout is how long a subscription waits without hearing from
+// the server, which sends a keep-alive every 15s, before it considers the
+// connection lost
+var eventsIdleTimeout = 45 * time.Second
+
+// Subscribe reports the changes made on the server under prefix, or the
+// whole tree for "/", over a long-lived server-sent events connection. The
+// channel is closed once ctx is done or the connection is lost; as changes
+// may have been missed in between, callers that resubscribe should treat
+// everything cached as stale. It returns ErrNotSupported if the server
+// doesn't report changes.
+func (c *Client) Subscribe(ctx context.Context, prefix string) (<-chan InvalidationEvent, error) {
+	query := url.Values{}
+	query.Set("path", prefix)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/events?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", "text/event-stream")
+	c.injectTraceContext(req)
+	c.authorize(req)
+	c.tagRequest(req)
+
+	// The stream outlives the client's request timeout
+	streamClient := &http.Client{Transport: c.httpClient.Transport}
+	resp, err := streamClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusNotImplemented:
+		// Older servers don't have the endpoint
+		resp.Body.Close()
+		return nil, ErrNotSupported
+	default:
+		return nil, c.handleErrorResponse(resp)
+	}
+
+	events := make(chan InvalidationEvent, 64)
+	go readEvents(ctx, resp.Body, events)
+	return events, nil
+}
+
+// readEvents sends the events of an event stream to events, closing it once
+// the stream ends, goes silent for eventsIdleTimeout or ctx is done
+func readEvents(ctx context.Context, body io.ReadCloser, events chan<- InvalidationEvent) {
+	defer close(events)
+	defer body.Close()
+	idle := time.AfterFunc(eventsIdleTimeout, func() { body.Close() })
+	defer idle.Stop()
+
+	var data strings.Builder
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		idle.Reset(eventsIdleTimeout)
+		line := scanner.Text()
+		if value, ok := strings.CutPrefix(line, "data:"); ok {
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(value, " "))
+			continue
+		}
+		if line != "" || data.Len() == 0 {
+			continue // Other fields and comments, such as keep-alives
+		}
+
+		var event InvalidationEvent
+		err := json.Unmarshal([]byte(data.String()), &event)
+		data.Reset()
+		if err != nil {
+			continue
+		}
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package agfs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// receive returns the next event, failing once the channel is closed
+func receive(t *testing.T, events <-chan InvalidationEvent) InvalidationEvent {
+	t.Helper()
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatal("Expected an event, the subscription ended")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for an event")
+	}
+	return InvalidationEvent{}
+}
+
+func TestClient_Subscribe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/events" || r.URL.Query().Get("path") != "/data" {
+			t.Errorf("Unexpected subscription %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, ": keep-alive\n\n")
+		fmt.Fprint(w, "event: change\ndata: {\"op\":\"write\",\"path\":\"/data/a\"}\n\n")
+		fmt.Fprint(w, "event: change\ndata: {\"op\":\"rename\",\n")
+		fmt.Fprint(w, "data: \"path\":\"/data/b\"}\n\n")
+		// Returning ends the stream, as a lost connection does
+	}))
+	defer server.Close()
+
+	events, err := NewClient(server.URL).Subscribe(context.Background(), "/data")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	for _, want := range []InvalidationEvent{{"write", "/data/a"}, {"rename", "/data/b"}} {
+		if event := receive(t, events); event != want {
+			t.Errorf("Expected %+v, got %+v", want, event)
+		}
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected no more events")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected the subscription to end with the connection")
+	}
+}
+
+func TestClient_SubscribeIdle(t *testing.T) {
+	defer func(d time.Duration) { eventsIdleTimeout = d }(eventsIdleTimeout)
+	eventsIdleTimeout = 100 * time.Millisecond
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-done // A connection gone silent
+	}))
+	defer server.Close()
+	defer close(done)
+
+	events, err := NewClient(server.URL).Subscribe(context.Background(), "/")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("Expected no events")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("Expected a silent subscription to end")
+	}
+}
+
+func TestClient_SubscribeNotSupported(t *testing.T) {
+	for _, status := range []int{http.StatusNotFound, http.StatusNotImplemented} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		_, err := NewClient(server.URL).Subscribe(context.Background(), "/")
+		server.Close()
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported for status %d, got %v", status, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package agfs
+
+import (
+	"errors"
+	pathpkg "path"
+	"sort"
+	"strings"
+)
+
+// Glob returns the paths matching pattern, in name order, or nil if none
+// match. Patterns use the syntax of path.Match, matched one path component
+// at a time, so "/logs/*/app-*.log" lists /logs and each of its
+// subdirectories. Paths that don't exist, and components listed that turn
+// out not to be directories, are no match rather than an error; the only
+// errors are path.ErrBadPattern and failures to list a directory.
+func (c *Client) Glob(pattern string) ([]string, error) {
+	if _, err := pathpkg.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	pattern = pathpkg.Clean("/" + pattern)
+	if !hasGlobMeta(pattern) {
+		if _, err := c.Stat(pattern); err != nil {
+			if errors.Is(err, ErrNotFound) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		return []string{pattern}, nil
+	}
+
+	matches := []string{"/"}
+	parts := strings.Split(strings.TrimPrefix(pattern, "/"), "/")
+	for i, part := range parts {
+		var next []string
+		for _, dir := range matches {
+			if !hasGlobMeta(part) {
+				next = append(next, pathpkg.Join(dir, part))
+				continue
+			}
+			entries, err := c.ReadDir(dir)
+			if errors.Is(err, ErrNotFound) || errors.Is(err, ErrInvalidArgument) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, entry := range entries {
+				// Only directories can match the components that follow
+				if i < len(parts)-1 && !entry.IsDir {
+					continue
+				}
+				if ok, _ := pathpkg.Match(part, entry.Name); ok {
+					next = append(next, pathpkg.Join(dir, entry.Name))
+				}
+			}
+		}
+		matches = next
+	}
+
+	// Components after the last pattern weren't looked up
+	if !hasGlobMeta(parts[len(parts)-1]) {
+		found := matches[:0]
+		for _, m := range matches {
+			if _, err := c.Stat(m); err == nil {
+				found = append(found, m)
+			} else if !errors.Is(err, ErrNotFound) {
+				return nil, err
+			}
+		}
+		matches = found
+	}
+	if len(matches) == 0 {
+		return nil, nil
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// hasGlobMeta reports whether s has any of the special characters of
+// path.Match
+func hasGlobMeta(s string) bool {
+	return strings.ContainsAny(s, `*?[\`)
+}
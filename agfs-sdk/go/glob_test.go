@@ -0,0 +1,58 @@
+package agfs_test
+
+import (
+	"errors"
+	"path"
+	"reflect"
+	"testing"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-sdk/go/agfstest"
+)
+
+func TestClient_Glob(t *testing.T) {
+	srv := agfstest.NewServer()
+	defer srv.Close()
+	client := agfs.NewClient(srv.URL)
+	for _, dir := range []string{"/logs/a", "/logs/b", "/data"} {
+		if err := client.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+	}
+	for _, file := range []string{"/logs/a/app-1.log", "/logs/a/app-2.log", "/logs/a/db.log", "/logs/b/app-1.log", "/logs/c.log", "/data/x"} {
+		if _, err := client.Write(file, []byte("x")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"/logs/*/app-*.log", []string{"/logs/a/app-1.log", "/logs/a/app-2.log", "/logs/b/app-1.log"}},
+		{"/logs/a/app-?.log", []string{"/logs/a/app-1.log", "/logs/a/app-2.log"}},
+		{"/logs/*", []string{"/logs/a", "/logs/b", "/logs/c.log"}},
+		{"/*/a/db.log", []string{"/logs/a/db.log"}},
+		{"/logs/[ab]/app-1.log", []string{"/logs/a/app-1.log", "/logs/b/app-1.log"}},
+		{"/logs/*/*/x", nil},    // Nothing that deep
+		{"/logs/c.log/*", nil},  // Not a directory
+		{"/missing/*.log", nil}, // Not there
+		{"/data/x", []string{"/data/x"}},
+		{"/data/y", nil},
+		{"data/../data/*", []string{"/data/x"}},
+	}
+	for _, tt := range tests {
+		got, err := client.Glob(tt.pattern)
+		if err != nil {
+			t.Errorf("Glob(%q) failed: %v", tt.pattern, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Glob(%q) = %q, expected %q", tt.pattern, got, tt.want)
+		}
+	}
+
+	if _, err := client.Glob("/logs/[a"); !errors.Is(err, path.ErrBadPattern) {
+		t.Errorf("Expected ErrBadPattern, got %v", err)
+	}
+}
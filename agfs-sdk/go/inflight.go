@@ -0,0 +1,158 @@
+package agfs
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrOverloaded is returned without contacting the server for requests that
+// waited longer than InflightConfig.Wait for a slot
+var ErrOverloaded = fmt.Errorf("too many requests in flight")
+
+// InflightConfig configures the limit of requests the client has in flight
+type InflightConfig struct {
+	// Max is the number of requests in flight at once; more wait for one
+	// to complete (0 = unlimited)
+	Max int
+	// PerOperation limits the requests in flight by operation, the first
+	// element of the endpoint path, e.g. "stat", "files", "directories" or
+	// "handles". These wait for a slot of their operation as well as of Max.
+	PerOperation map[string]int
+	// Wait is how long a request waits for a slot before failing with
+	// ErrOverloaded (0 = as long as its context allows)
+	Wait time.Duration
+}
+
+// inflightLimiter is a semaphore for the requests in flight, and one per
+// limited operation. A request holds its slots until its response body is
+// closed or read to the end.
+type inflightLimiter struct {
+	cfg    InflightConfig
+	global chan struct{} // nil = unlimited
+	ops    map[string]chan struct{}
+	count  atomic.Int64
+}
+
+func newInflightLimiter(cfg InflightConfig) *inflightLimiter {
+	l := &inflightLimiter{cfg: cfg, ops: make(map[string]chan struct{})}
+	if cfg.Max > 0 {
+		l.global = make(chan struct{}, cfg.Max)
+	}
+	for op, n := range cfg.PerOperation {
+		if n > 0 {
+			l.ops[op] = make(chan struct{}, n)
+		}
+	}
+	return l
+}
+
+// acquire waits for the slots of req, which are released by calling the
+// returned func. The slot of its operation is taken first, so a request
+// queued behind others of its operation doesn't hold one of Max. It fails
+// with ErrOverloaded once the wait exceeds cfg.Wait, or with the error of
+// the request's context once that is done.
+func (l *inflightLimiter) acquire(req *http.Request) (func(), error) {
+	ctx := req.Context()
+	var timeout <-chan time.Time
+	if l.cfg.Wait > 0 {
+		timer := time.NewTimer(l.cfg.Wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	var held []chan struct{}
+	release := func() {
+		for _, sem := range held {
+			<-sem
+		}
+	}
+	for _, sem := range []chan struct{}{l.ops[operation(req)], l.global} {
+		if sem == nil {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+			held = append(held, sem)
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		case <-timeout:
+			release()
+			return nil, ErrOverloaded
+		}
+	}
+
+	l.count.Add(1)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.count.Add(-1)
+			release()
+		})
+	}, nil
+}
+
+// operation returns the operation of req, the path element following
+// /api/v1
+func operation(req *http.Request) string {
+	p := req.URL.Path
+	if i := strings.Index(p, "/api/v1/"); i >= 0 {
+		p = p[i+len("/api/v1/"):]
+	}
+	op, _, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	return op
+}
+
+// releasingBody releases the slots of its request once closed or read to
+// the end, whichever comes first
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releasingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil {
+		b.release()
+	}
+	return n, err
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// EnableInflightLimit makes the client wait before sending a request while
+// cfg.Max requests, or cfg.PerOperation of its operation, are in flight, so
+// a burst of operations doesn't overwhelm the server. A request is in
+// flight until its response is read or closed; streams of ReadStream,
+// ReadHandleStream and Subscribe aren't counted. It must be called before
+// the client is used.
+func (c *Client) EnableInflightLimit(cfg InflightConfig) {
+	c.inflight = newInflightLimiter(cfg)
+}
+
+// InFlight returns the number of requests in flight, as counted by the
+// limit of EnableInflightLimit (0 if it isn't enabled)
+func (c *Client) InFlight() int {
+	if c.inflight == nil {
+		return 0
+	}
+	return int(c.inflight.count.Load())
+}
+
+// acquireInflight waits for the slots of req under the in-flight limit, and
+// returns the func releasing them
+func (c *Client) acquireInflight(req *http.Request) (func(), error) {
+	if c.inflight == nil {
+		return func() {}, nil
+	}
+	return c.inflight.acquire(req)
+}
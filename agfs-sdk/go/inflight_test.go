@@ -0,0 +1,132 @@
+package agfs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// inflightServer serves stat and directory requests slowly, recording how
+// many were served at once, in total and of stat requests
+type inflightServer struct {
+	delay   time.Duration
+	current atomic.Int64
+	peak    atomic.Int64
+	stats   atomic.Int64
+	peakOp  atomic.Int64
+}
+
+func raise(peak *atomic.Int64, n int64) {
+	for {
+		p := peak.Load()
+		if n <= p || peak.CompareAndSwap(p, n) {
+			return
+		}
+	}
+}
+
+func (s *inflightServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	raise(&s.peak, s.current.Add(1))
+	defer s.current.Add(-1)
+	if r.URL.Path == "/api/v1/stat" {
+		raise(&s.peakOp, s.stats.Add(1))
+		defer s.stats.Add(-1)
+	}
+	select {
+	case <-time.After(s.delay):
+	case <-r.Context().Done():
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/api/v1/stat" {
+		w.Write([]byte(`{"name":"f","size":1,"mode":420,"modTime":"2024-01-01T00:00:00Z"}`))
+	} else {
+		w.Write([]byte(`{"files":[]}`))
+	}
+}
+
+func TestClient_InflightLimit(t *testing.T) {
+	srv := &inflightServer{delay: 10 * time.Millisecond}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.EnableInflightLimit(InflightConfig{Max: 4, PerOperation: map[string]int{"stat": 2}})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			if i%2 == 0 {
+				_, err = client.Stat("/f")
+			} else {
+				_, err = client.ReadDir("/")
+			}
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+	}
+
+	if peak := srv.peak.Load(); peak > 4 || peak < 2 {
+		t.Errorf("Expected at most 4 requests in flight, got %d", peak)
+	}
+	if peak := srv.peakOp.Load(); peak > 2 {
+		t.Errorf("Expected at most 2 stat requests in flight, got %d", peak)
+	}
+	if n := client.InFlight(); n != 0 {
+		t.Errorf("Expected no requests in flight once done, got %d", n)
+	}
+}
+
+func TestClient_InflightLimitWait(t *testing.T) {
+	srv := &inflightServer{delay: time.Minute}
+	server := httptest.NewServer(srv)
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	client.EnableInflightLimit(InflightConfig{Max: 1, Wait: 20 * time.Millisecond})
+
+	// Hold the only slot with a request the server doesn't answer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := client.WithContext(ctx).Stat("/f")
+		done <- err
+	}()
+	for client.InFlight() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, err := client.Stat("/f"); !errors.Is(err, ErrOverloaded) {
+		t.Errorf("Expected ErrOverloaded past the wait, got %v", err)
+	}
+	waiting, stop := context.WithCancel(context.Background())
+	stop()
+	if _, err := client.WithContext(waiting).Stat("/f"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected a cancelled wait to fail, got %v", err)
+	}
+
+	// Cancelling the request in flight releases its slot
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected the cancelled request to fail, got %v", err)
+	}
+	if n := client.InFlight(); n != 0 {
+		t.Errorf("Expected the slot of the cancelled request released, got %d in flight", n)
+	}
+}
@@ -0,0 +1,252 @@
+package agfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// LockType is the type of an advisory byte-range lock
+type LockType string
+
+const (
+	// LockShared locks can be held by several owners at once, like F_RDLCK
+	LockShared LockType = "shared"
+	// LockExclusive locks conflict with every other lock, like F_WRLCK
+	LockExclusive LockType = "exclusive"
+	// LockUnlock releases a range, like F_UNLCK
+	LockUnlock LockType = "unlock"
+)
+
+// LockRange is a byte range of a file. Start and End are inclusive.
+type LockRange struct {
+	Start int64
+	End   int64
+}
+
+// WholeFile is the range covering the whole file, however large it grows
+var WholeFile = LockRange{Start: 0, End: math.MaxInt64}
+
+// LockInfo describes a lock held on the server
+type LockInfo struct {
+	Owner uint64   `json:"owner"`
+	Type  LockType `json:"type"`
+	Start int64    `json:"start"`
+	End   int64    `json:"end"`
+}
+
+// LockResponse is the response for lock requests
+type LockResponse struct {
+	Lock *LockInfo `json:"lock,omitempty"`
+	TTL  int       `json:"ttl"`
+}
+
+// lockRenewResponse is the response for session renewal
+type lockRenewResponse struct {
+	Locks int `json:"locks"`
+	TTL   int `json:"ttl"`
+}
+
+// defaultLockTTL is assumed when the server doesn't report its lock TTL
+const defaultLockTTL = 30 * time.Second
+
+// maxLockWait is the longest the server waits on a single lock request
+const maxLockWait = 20 * time.Second
+
+// lockSession identifies the client to the server's lock table. The server
+// releases a session's locks when it stops hearing from it, so while the
+// session holds locks it is renewed in the background.
+type lockSession struct {
+	id string
+
+	mu       sync.Mutex
+	renewing bool
+	gen      uint64 // incremented on every granted lock
+}
+
+func newLockSession() *lockSession {
+	return &lockSession{id: randomID()}
+}
+
+// Lock sets a lock of type typ on rng of path for owner, an ID of the
+// process or open file taking it, or releases the range with LockUnlock.
+// Locks are advisory: they only conflict with other locks, not with reads
+// and writes. If another owner holds a conflicting lock, Lock returns an
+// error matching ErrLocked, or with wait blocks until the lock is released
+// or the client context is done.
+//
+// The locks are held until they are unlocked, ReleaseLocks is called, or
+// the client stops renewing them for the server's lock TTL, e.g. because
+// the process exited.
+func (c *Client) Lock(path string, owner uint64, typ LockType, rng LockRange, wait bool) error {
+	ctx := c.context()
+	for {
+		var waitFor time.Duration
+		if wait {
+			waitFor = c.lockWaitChunk()
+		}
+		err := c.setLock(ctx, path, owner, typ, rng, waitFor)
+		if !wait || !errors.Is(err, ErrLocked) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+// Unlock releases owner's locks on rng of path
+func (c *Client) Unlock(path string, owner uint64, rng LockRange) error {
+	return c.setLock(c.context(), path, owner, LockUnlock, rng, 0)
+}
+
+// TestLock returns a lock of another owner that would conflict with owner
+// taking a lock of type typ on rng of path, or nil if the lock is free
+func (c *Client) TestLock(path string, owner uint64, typ LockType, rng LockRange) (*LockInfo, error) {
+	resp, err := c.doRequest(http.MethodGet, "/locks", c.lockQuery(path, owner, typ, rng), nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var lockResp LockResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lockResp); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return lockResp.Lock, nil
+}
+
+// ReleaseLocks releases every lock held by the client
+func (c *Client) ReleaseLocks() error {
+	query := url.Values{}
+	query.Set("session", c.locks.id)
+
+	resp, err := c.doRequest(http.MethodDelete, "/locks", query, nil)
+	if err != nil {
+		return err
+	}
+	return c.handleErrorResponse(resp)
+}
+
+func (c *Client) lockQuery(path string, owner uint64, typ LockType, rng LockRange) url.Values {
+	query := url.Values{}
+	query.Set("path", path)
+	query.Set("session", c.locks.id)
+	query.Set("owner", fmt.Sprintf("%d", owner))
+	query.Set("type", string(typ))
+	query.Set("start", fmt.Sprintf("%d", rng.Start))
+	query.Set("end", fmt.Sprintf("%d", rng.End))
+	return query
+}
+
+func (c *Client) setLock(ctx context.Context, path string, owner uint64, typ LockType, rng LockRange, waitFor time.Duration) error {
+	query := c.lockQuery(path, owner, typ, rng)
+	if waitFor > 0 {
+		query.Set("wait", fmt.Sprintf("%d", waitFor.Milliseconds()))
+	}
+
+	resp, err := c.doRequestContext(ctx, http.MethodPost, "/locks", query, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var lockResp LockResponse
+	if err := json.NewDecoder(resp.Body).Decode(&lockResp); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	if typ != LockUnlock {
+		c.keepLocksAlive(time.Duration(lockResp.TTL) * time.Second)
+	}
+	return nil
+}
+
+// lockWaitChunk returns how long a single blocking lock request may wait,
+// leaving room within the HTTP client timeout
+func (c *Client) lockWaitChunk() time.Duration {
+	chunk := maxLockWait
+	if timeout := c.httpClient.Timeout; timeout > 0 && timeout/2 < chunk {
+		chunk = timeout / 2
+	}
+	return chunk
+}
+
+// keepLocksAlive starts renewing the lock session, unless it already is
+func (c *Client) keepLocksAlive(ttl time.Duration) {
+	s := c.locks
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gen++
+	if s.renewing {
+		return
+	}
+	s.renewing = true
+	if ttl <= 0 {
+		ttl = defaultLockTTL
+	}
+	go c.renewLocks(ttl)
+}
+
+// renewLocks renews the lock session every third of the TTL until it holds
+// no more locks, or renewal has failed for longer than the TTL, by which
+// time the server has dropped them anyway
+func (c *Client) renewLocks(ttl time.Duration) {
+	s := c.locks
+	lastRenewed := time.Now()
+	for {
+		s.mu.Lock()
+		gen := s.gen
+		s.mu.Unlock()
+
+		time.Sleep(ttl / 3)
+		n, newTTL, err := c.renewLockSession()
+
+		s.mu.Lock()
+		if err == nil {
+			lastRenewed = time.Now()
+			if newTTL > 0 {
+				ttl = newTTL
+			}
+		}
+		// A lock granted during the renewal isn't counted yet
+		if (err == nil && n == 0 && s.gen == gen) || (err != nil && time.Since(lastRenewed) > ttl) {
+			s.renewing = false
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+	}
+}
+
+// renewLockSession renews the lock session and returns how many locks it holds
+func (c *Client) renewLockSession() (int, time.Duration, error) {
+	query := url.Values{}
+	query.Set("session", c.locks.id)
+
+	resp, err := c.doRequestContext(context.Background(), http.MethodPost, "/locks/renew", query, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, 0, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var renewResp lockRenewResponse
+	if err := json.NewDecoder(resp.Body).Decode(&renewResp); err != nil {
+		return 0, 0, fmt.Errorf("failed to decode response: %w", err)
+	}
+	return renewResp.Locks, time.Duration(renewResp.TTL) * time.Second, nil
+}
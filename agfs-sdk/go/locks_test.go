@@ -0,0 +1,116 @@
+package agfs
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_LockConflict(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("session") == "" || q.Get("owner") != "3" || q.Get("start") != "10" || q.Get("end") != "19" {
+			t.Errorf("Unexpected lock query: %s", r.URL.RawQuery)
+		}
+		switch r.Method {
+		case http.MethodPost:
+			w.WriteHeader(http.StatusLocked)
+			w.Write([]byte(`{"error":"lock held by another owner","lock":{"owner":1,"type":"exclusive","start":0,"end":99}}`))
+		case http.MethodGet:
+			w.Write([]byte(`{"lock":{"owner":1,"type":"exclusive","start":0,"end":99},"ttl":30}`))
+		}
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	rng := LockRange{Start: 10, End: 19}
+	if err := client.Lock("/f", 3, LockShared, rng, false); !errors.Is(err, ErrLocked) {
+		t.Errorf("Expected ErrLocked, got %v", err)
+	}
+
+	conflict, err := client.TestLock("/f", 3, LockShared, rng)
+	if err != nil {
+		t.Fatalf("TestLock failed: %v", err)
+	}
+	if conflict == nil || conflict.Owner != 1 || conflict.Type != LockExclusive {
+		t.Errorf("Expected the conflicting lock, got %+v", conflict)
+	}
+}
+
+func TestClient_LockWaitRetries(t *testing.T) {
+	var attempts atomic.Int32
+	var held atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/locks/renew" {
+			w.Write([]byte(`{"locks":0,"ttl":30}`))
+			return
+		}
+		if r.URL.Query().Get("wait") == "" {
+			t.Errorf("Expected a blocking lock request to wait")
+		}
+		// The server gives up waiting twice before the lock is free
+		if attempts.Add(1) < 3 || held.Load() {
+			w.WriteHeader(http.StatusLocked)
+			w.Write([]byte(`{"error":"lock held by another owner"}`))
+			return
+		}
+		w.Write([]byte(`{"ttl":30}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.Lock("/f", 1, LockExclusive, WholeFile, true); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	// A blocking lock gives up with the client context
+	held.Store(true)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := client.WithContext(ctx).Lock("/f", 1, LockExclusive, WholeFile, true); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the context error, got %v", err)
+	}
+}
+
+func TestClient_LockKeepalive(t *testing.T) {
+	var renewals atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/locks/renew" {
+			// Report the lock as held twice, then as released
+			if renewals.Add(1) < 3 {
+				w.Write([]byte(`{"locks":1,"ttl":1}`))
+			} else {
+				w.Write([]byte(`{"locks":0,"ttl":1}`))
+			}
+			return
+		}
+		w.Write([]byte(`{"ttl":1}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL)
+	if err := client.Lock("/f", 1, LockShared, WholeFile, false); err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		client.locks.mu.Lock()
+		renewing := client.locks.renewing
+		client.locks.mu.Unlock()
+		if !renewing {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if n := renewals.Load(); n != 3 {
+		t.Errorf("Expected renewals to stop once no locks are held, got %d renewals", n)
+	}
+}
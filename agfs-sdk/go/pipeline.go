@@ -0,0 +1,310 @@
+package agfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// maxPipelineOps is the most operations sent in one batch request, the
+// server's limit; longer pipelines are sent in several
+const maxPipelineOps = 1024
+
+// BatchOp is an operation of a batch request: the name of the operation
+// and the query parameters and JSON body of its endpoint
+type BatchOp struct {
+	Op     string            `json:"op"`
+	Params map[string]string `json:"params,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchRequest represents a batch request
+type BatchRequest struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+// BatchResult is the status and JSON body an operation of a batch request
+// answered with
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// BatchResponse represents a batch response, with a result per operation
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// PipelineResult is the outcome of an operation queued on a Pipeline. Err
+// is set if the operation failed; otherwise the field matching the
+// operation is set: Info for Stat, Files for ReadDir and Target for
+// Readlink.
+type PipelineResult struct {
+	Op   string
+	Path string
+
+	Info   *FileInfo
+	Files  []FileInfo
+	Target string
+	Err    error
+}
+
+// pipelineOp is an operation queued on a Pipeline
+type pipelineOp struct {
+	BatchOp
+	method   string // Endpoint the operation runs as, when not batched
+	endpoint string
+	decode   func(result *PipelineResult, body []byte) error // Decodes a successful response
+}
+
+// Pipeline queues metadata operations, such as stats, and sends them to the
+// server in a single request when flushed, saving a round trip per
+// operation. The server runs them one after the other, in order. A
+// Pipeline is not safe for concurrent use.
+type Pipeline struct {
+	c   *Client
+	ops []pipelineOp
+}
+
+// Pipeline returns an empty pipeline of operations on the server
+func (c *Client) Pipeline() *Pipeline {
+	return &Pipeline{c: c}
+}
+
+// Len returns the number of operations queued
+func (p *Pipeline) Len() int {
+	return len(p.ops)
+}
+
+func (p *Pipeline) add(op, method, endpoint string, params map[string]string, body interface{}, decode func(*PipelineResult, []byte) error) {
+	var raw json.RawMessage
+	if body != nil {
+		raw, _ = json.Marshal(body)
+	}
+	p.ops = append(p.ops, pipelineOp{
+		BatchOp:  BatchOp{Op: op, Params: params, Body: raw},
+		method:   method,
+		endpoint: endpoint,
+		decode:   decode,
+	})
+}
+
+// Stat queues a Stat of path
+func (p *Pipeline) Stat(path string) {
+	p.add("stat", http.MethodGet, "/stat", map[string]string{"path": path}, nil, func(r *PipelineResult, body []byte) error {
+		var info FileInfoResponse
+		if err := json.Unmarshal(body, &info); err != nil {
+			return fmt.Errorf("failed to decode file info response: %w", err)
+		}
+		fileInfo := toFileInfo(info)
+		r.Info = &fileInfo
+		return nil
+	})
+}
+
+// ReadDir queues a ReadDir of path
+func (p *Pipeline) ReadDir(path string) {
+	p.add("list", http.MethodGet, "/directories", map[string]string{"path": path}, nil, func(r *PipelineResult, body []byte) error {
+		var list ListResponse
+		if err := json.Unmarshal(body, &list); err != nil {
+			return fmt.Errorf("failed to decode list response: %w", err)
+		}
+		r.Files = make([]FileInfo, 0, len(list.Files))
+		for _, f := range list.Files {
+			r.Files = append(r.Files, toFileInfo(f))
+		}
+		return nil
+	})
+}
+
+// Readlink queues a Readlink of linkPath
+func (p *Pipeline) Readlink(linkPath string) {
+	p.add("readlink", http.MethodGet, "/readlink", map[string]string{"path": linkPath}, nil, func(r *PipelineResult, body []byte) error {
+		var readlinkResp ReadlinkResponse
+		if err := json.Unmarshal(body, &readlinkResp); err != nil {
+			return fmt.Errorf("failed to decode readlink response: %w", err)
+		}
+		r.Target = readlinkResp.Target
+		return nil
+	})
+}
+
+// Create queues a Create of path
+func (p *Pipeline) Create(path string) {
+	p.add("create", http.MethodPost, "/files", map[string]string{"path": path}, nil, nil)
+}
+
+// Mkdir queues a Mkdir of path
+func (p *Pipeline) Mkdir(path string, perm uint32) {
+	p.add("mkdir", http.MethodPost, "/directories", map[string]string{"path": path, "mode": fmt.Sprintf("%o", perm)}, nil, nil)
+}
+
+// MkdirAll queues a MkdirAll of path
+func (p *Pipeline) MkdirAll(path string, perm uint32) {
+	p.add("mkdir", http.MethodPost, "/directories", map[string]string{"path": path, "mode": fmt.Sprintf("%o", perm), "parents": "true"}, nil, nil)
+}
+
+// Remove queues a Remove of path
+func (p *Pipeline) Remove(path string) {
+	p.add("remove", http.MethodDelete, "/files", map[string]string{"path": path, "recursive": "false"}, nil, nil)
+}
+
+// RemoveAll queues a RemoveAll of path
+func (p *Pipeline) RemoveAll(path string) {
+	p.add("remove", http.MethodDelete, "/files", map[string]string{"path": path, "recursive": "true"}, nil, nil)
+}
+
+// Rename queues a Rename of oldPath to newPath
+func (p *Pipeline) Rename(oldPath, newPath string) {
+	p.add("rename", http.MethodPost, "/rename", map[string]string{"path": oldPath}, RenameRequest{NewPath: newPath}, nil)
+}
+
+// Chmod queues a Chmod of path
+func (p *Pipeline) Chmod(path string, mode uint32) {
+	p.add("chmod", http.MethodPost, "/chmod", map[string]string{"path": path}, ChmodRequest{Mode: mode}, nil)
+}
+
+// Truncate queues a Truncate of path
+func (p *Pipeline) Truncate(path string, size int64) {
+	p.add("truncate", http.MethodPost, "/truncate", map[string]string{"path": path, "size": fmt.Sprintf("%d", size)}, nil, nil)
+}
+
+// Symlink queues a Symlink at linkPath pointing to targetPath
+func (p *Pipeline) Symlink(targetPath, linkPath string) {
+	p.add("symlink", http.MethodPost, "/symlink", map[string]string{"path": linkPath}, SymlinkRequest{Target: targetPath}, nil)
+}
+
+// Flush sends the queued operations and returns their results, in the order
+// they were queued, emptying the pipeline. Operations fail independently:
+// the error of each is in its result, and operations queued after a
+// failed one still run. Flush itself only fails if the batch couldn't be
+// sent or its response read, in which case some operations may have run.
+// Servers without batch requests get the operations one request at a time.
+func (p *Pipeline) Flush(ctx context.Context) ([]PipelineResult, error) {
+	ops := p.ops
+	p.ops = nil
+
+	results := make([]PipelineResult, 0, len(ops))
+	for len(ops) > 0 {
+		n := len(ops)
+		if n > maxPipelineOps {
+			n = maxPipelineOps
+		}
+		batch, err := p.c.batch(ctx, ops[:n])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, batch...)
+		ops = ops[n:]
+	}
+	return results, nil
+}
+
+// batch sends ops in a batch request, or one at a time to servers without
+// batch requests
+func (c *Client) batch(ctx context.Context, ops []pipelineOp) ([]PipelineResult, error) {
+	req := BatchRequest{Ops: make([]BatchOp, len(ops))}
+	for i, op := range ops {
+		req.Ops[i] = op.BatchOp
+	}
+	jsonData, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal batch request: %w", err)
+	}
+
+	resp, err := c.doRequestContext(ctx, http.MethodPost, "/batch", nil, bytes.NewReader(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		// Older servers don't have the endpoint
+		resp.Body.Close()
+		return c.sequential(ctx, ops), nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, c.handleErrorResponse(resp)
+	}
+	defer resp.Body.Close()
+
+	var batchResp BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batchResp); err != nil {
+		return nil, fmt.Errorf("failed to decode batch response: %w", err)
+	}
+	if len(batchResp.Results) != len(ops) {
+		return nil, fmt.Errorf("batch response has %d results for %d operations", len(batchResp.Results), len(ops))
+	}
+
+	results := make([]PipelineResult, len(ops))
+	for i, op := range ops {
+		results[i] = op.result(batchResp.Results[i].Status, batchResp.Results[i].Body)
+	}
+	return results, nil
+}
+
+// sequential runs ops with a request each
+func (c *Client) sequential(ctx context.Context, ops []pipelineOp) []PipelineResult {
+	results := make([]PipelineResult, len(ops))
+	for i, op := range ops {
+		query := url.Values{}
+		for k, v := range op.Params {
+			query.Set(k, v)
+		}
+		var body io.Reader
+		if op.Body != nil {
+			body = bytes.NewReader(op.Body)
+		}
+		resp, err := c.doRequestContext(ctx, op.method, op.endpoint, query, body)
+		if err == nil {
+			var data []byte
+			data, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err == nil {
+				results[i] = op.result(resp.StatusCode, data)
+				continue
+			}
+		}
+		results[i] = PipelineResult{Op: op.Op, Path: op.Params["path"], Err: err}
+	}
+	return results
+}
+
+// result decodes the status and body an operation answered with
+func (op pipelineOp) result(status int, body []byte) PipelineResult {
+	result := PipelineResult{Op: op.Op, Path: op.Params["path"]}
+	switch {
+	case status == http.StatusNotImplemented:
+		result.Err = ErrNotSupported
+	case status < 200 || status >= 300:
+		var errResp ErrorResponse
+		if err := json.Unmarshal(body, &errResp); err != nil {
+			result.Err = &StatusError{StatusCode: status, Message: "failed to decode error response"}
+		} else {
+			result.Err = &StatusError{StatusCode: status, Message: errResp.Error}
+		}
+	case op.decode != nil:
+		result.Err = op.decode(&result, body)
+	}
+	return result
+}
+
+// toFileInfo converts the file information of a response
+func toFileInfo(f FileInfoResponse) FileInfo {
+	modTime, _ := time.Parse(time.RFC3339Nano, f.ModTime)
+	return FileInfo{
+		Name:      f.Name,
+		Size:      f.Size,
+		Mode:      f.Mode,
+		ModTime:   modTime,
+		IsDir:     f.IsDir,
+		IsSymlink: f.IsSymlink(),
+		Meta:      f.Meta,
+		Ino:       f.Ino,
+		Blocks:    f.Blocks,
+		BlockSize: f.BlockSize,
+	}
+}
@@ -0,0 +1,134 @@
+package agfs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sync/atomic"
+	"testing"
+)
+
+// statServer answers stats of any path but /missing, in batches too unless
+// batch is false, and counts the requests it gets
+func statServer(tb testing.TB, batch bool) (*httptest.Server, *atomic.Int64) {
+	var requests atomic.Int64
+	stat := func(p string) (int, interface{}) {
+		if p == "/missing" {
+			return http.StatusNotFound, ErrorResponse{Error: "not found"}
+		}
+		return http.StatusOK, FileInfoResponse{Name: path.Base(p), Size: 4, Mode: 0644}
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch {
+		case r.URL.Path == "/api/v1/stat":
+			status, body := stat(r.URL.Query().Get("path"))
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(body)
+		case r.URL.Path == "/api/v1/batch" && batch:
+			var req BatchRequest
+			json.NewDecoder(r.Body).Decode(&req)
+			var resp BatchResponse
+			for _, op := range req.Ops {
+				if op.Op != "stat" {
+					tb.Errorf("Unexpected batch operation %q", op.Op)
+				}
+				status, body := stat(op.Params["path"])
+				data, _ := json.Marshal(body)
+				resp.Results = append(resp.Results, BatchResult{Status: status, Body: data})
+			}
+			json.NewEncoder(w).Encode(resp)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	tb.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestPipeline(t *testing.T) {
+	for _, batch := range []bool{true, false} {
+		server, requests := statServer(t, batch)
+		p := NewClient(server.URL).Pipeline()
+		for _, name := range []string{"/a", "/missing", "/b"} {
+			p.Stat(name)
+		}
+		results, err := p.Flush(context.Background())
+		if err != nil {
+			t.Fatalf("Flush failed: %v", err)
+		}
+		if p.Len() != 0 {
+			t.Errorf("Expected Flush to empty the pipeline, %d operations left", p.Len())
+		}
+
+		if len(results) != 3 {
+			t.Fatalf("Expected 3 results, got %d", len(results))
+		}
+		if results[0].Err != nil || results[0].Info == nil || results[0].Info.Name != "a" {
+			t.Errorf("Expected the stat of /a, got %+v", results[0])
+		}
+		if !errors.Is(results[1].Err, ErrNotFound) || results[1].Path != "/missing" {
+			t.Errorf("Expected /missing to fail with ErrNotFound, got %+v", results[1])
+		}
+		if results[2].Err != nil || results[2].Info == nil || results[2].Info.Name != "b" {
+			t.Errorf("Expected the stat of /b after a failure, got %+v", results[2])
+		}
+
+		// A server without batches gets a failed batch and then a request each
+		want := int64(1)
+		if !batch {
+			want = 4
+		}
+		if n := requests.Load(); n != want {
+			t.Errorf("Expected %d requests with batch=%v, got %d", want, batch, n)
+		}
+	}
+}
+
+func TestPipelineSplit(t *testing.T) {
+	server, requests := statServer(t, true)
+	p := NewClient(server.URL).Pipeline()
+	for i := 0; i < maxPipelineOps+1; i++ {
+		p.Stat(fmt.Sprintf("/f%d", i))
+	}
+	results, err := p.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if len(results) != maxPipelineOps+1 || results[maxPipelineOps].Info.Name != fmt.Sprintf("f%d", maxPipelineOps) {
+		t.Errorf("Expected %d results in order, got %d", maxPipelineOps+1, len(results))
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("Expected the pipeline to be sent in 2 batches, got %d requests", n)
+	}
+}
+
+func BenchmarkStat1000(b *testing.B) {
+	server, _ := statServer(b, true)
+	client := NewClient(server.URL)
+
+	b.Run("sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 1000; j++ {
+				if _, err := client.Stat(fmt.Sprintf("/f%d", j)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	b.Run("pipelined", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			p := client.Pipeline()
+			for j := 0; j < 1000; j++ {
+				p.Stat(fmt.Sprintf("/f%d", j))
+			}
+			if _, err := p.Flush(context.Background()); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,54 @@
+package agfs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+// RequestIDHeader is the header a request ID is sent in. Servers log it with
+// the lines the request causes, and return it in the response, generating
+// one for requests without.
+const RequestIDHeader = "X-Request-ID"
+
+// requestIDKey is the context key of the request ID
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying id, which every request made
+// with it (see Client.WithContext) is sent with, so the server's log lines
+// can be told apart by the operation they were made for
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, or "" if
+// there is none
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a new random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// tagRequest adds the request ID of the client's context to req
+func (c *Client) tagRequest(req *http.Request) {
+	if id := RequestIDFromContext(c.context()); id != "" {
+		req.Header.Set(RequestIDHeader, id)
+	}
+}
+
+// newStatusError returns the error for resp, a response rejecting a
+// request, with the request ID the server or the request reports
+func newStatusError(resp *http.Response, message string) *StatusError {
+	id := resp.Header.Get(RequestIDHeader)
+	if id == "" && resp.Request != nil {
+		id = resp.Request.Header.Get(RequestIDHeader)
+	}
+	return &StatusError{StatusCode: resp.StatusCode, Message: message, RequestID: id}
+}
@@ -0,0 +1,26 @@
+package agfs
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TimeoutHeader is the header a request tells the server how long the
+// client waits for the response in, in milliseconds, so the server gives up
+// on reads the client won't wait for and reports it (HTTP 504, ErrTimeout)
+const TimeoutHeader = "X-AGFS-Timeout"
+
+// setTimeout sends req with the time the client waits for it: until its
+// context's deadline or the HTTP client's timeout, whichever comes first
+func (c *Client) setTimeout(req *http.Request) {
+	timeout := c.httpClient.Timeout
+	if deadline, ok := req.Context().Deadline(); ok {
+		if left := time.Until(deadline); timeout == 0 || left < timeout {
+			timeout = left
+		}
+	}
+	if ms := timeout.Milliseconds(); ms > 0 {
+		req.Header.Set(TimeoutHeader, strconv.FormatInt(ms, 10))
+	}
+}
@@ -0,0 +1,81 @@
+package agfs
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ClientOption configures optional Client behaviour
+type ClientOption func(*Client)
+
+// WithTracer makes the client record a span for every request and pass its
+// context to the server in a W3C traceparent header. Without it the client
+// doesn't touch OpenTelemetry at all.
+func WithTracer(tracer trace.Tracer) ClientOption {
+	return func(c *Client) {
+		c.tracer = tracer
+	}
+}
+
+// WithContext returns a copy of the client whose requests are made with ctx.
+// Requests are cancelled with ctx and, when tracing is enabled, their spans
+// are children of the span in ctx. The copy shares the HTTP client, circuit
+// breaker and ServerInfo cache with c.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	cc := *c
+	cc.ctx = ctx
+	return &cc
+}
+
+// context returns the context requests are made with
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
+}
+
+// traceContext propagates spans in the W3C trace context format
+var traceContext = propagation.TraceContext{}
+
+// injectTraceContext adds a traceparent header for the span in the client context
+func (c *Client) injectTraceContext(req *http.Request) {
+	if c.tracer == nil {
+		return
+	}
+	traceContext.Inject(c.context(), propagation.HeaderCarrier(req.Header))
+}
+
+// doTraced sends req inside a client span
+func (c *Client) doTraced(req *http.Request) (*http.Response, error) {
+	endpoint := strings.TrimPrefix(req.URL.Path, "/api/v1")
+	ctx, span := c.tracer.Start(req.Context(), "agfs "+req.Method+" "+endpoint,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("http.method", req.Method),
+			attribute.String("agfs.endpoint", endpoint),
+			attribute.String("agfs.path", req.URL.Query().Get("path")),
+		))
+	defer span.End()
+
+	req = req.WithContext(ctx)
+	traceContext.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	resp, err := c.doBreaker(req)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return resp, err
+	}
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+	if resp.StatusCode >= 500 {
+		span.SetStatus(codes.Error, resp.Status)
+	}
+	return resp, nil
+}
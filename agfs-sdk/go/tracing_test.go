@@ -0,0 +1,96 @@
+package agfs
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestClient_TracingSpanParentage(t *testing.T) {
+	var serverSpan trace.SpanContext
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := propagation.TraceContext{}.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		serverSpan = trace.SpanContextFromContext(ctx)
+		json.NewEncoder(w).Encode(FileInfoResponse{Name: "file", Size: 1})
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	tracer := provider.Tracer("test")
+
+	client := NewClient(server.URL, WithTracer(tracer))
+
+	ctx, parent := tracer.Start(context.Background(), "parent")
+	if _, err := client.WithContext(ctx).Stat("/file"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	request, root := spans[0], spans[1]
+	if request.Name != "agfs GET /stat" {
+		t.Errorf("unexpected span name %q", request.Name)
+	}
+	if request.Parent.SpanID() != root.SpanContext.SpanID() {
+		t.Errorf("request span parent %s, expected %s", request.Parent.SpanID(), root.SpanContext.SpanID())
+	}
+	if request.SpanKind != trace.SpanKindClient {
+		t.Errorf("expected client span, got %s", request.SpanKind)
+	}
+
+	// The server sees the request span as its remote parent
+	if !serverSpan.IsRemote() || serverSpan.TraceID() != root.SpanContext.TraceID() {
+		t.Errorf("traceparent not propagated: got trace %s", serverSpan.TraceID())
+	}
+	if serverSpan.SpanID() != request.SpanContext.SpanID() {
+		t.Errorf("server parent span %s, expected %s", serverSpan.SpanID(), request.SpanContext.SpanID())
+	}
+}
+
+func TestClient_NoTracerNoHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := r.Header.Get("traceparent"); h != "" {
+			t.Errorf("unexpected traceparent header %q", h)
+		}
+		json.NewEncoder(w).Encode(FileInfoResponse{Name: "file"})
+	}))
+	defer server.Close()
+
+	// A span in the context must not leak into requests when tracing is off
+	tracer := sdktrace.NewTracerProvider().Tracer("test")
+	ctx, span := tracer.Start(context.Background(), "parent")
+	defer span.End()
+
+	if _, err := NewClient(server.URL).WithContext(ctx).Stat("/file"); err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+}
+
+func TestClient_WithContextCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(FileInfoResponse{Name: "file"})
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	client := NewClient(server.URL)
+	if _, err := client.WithContext(ctx).Stat("/file"); err == nil {
+		t.Error("expected error for cancelled context")
+	}
+	if _, err := client.Stat("/file"); err != nil {
+		t.Errorf("original client must not be bound to the context: %v", err)
+	}
+}
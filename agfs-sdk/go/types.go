@@ -13,13 +13,86 @@ type MetaData struct {
 type FileInfo struct {
 	Name      string
 	Size      int64
-	Mode      uint32
+	Mode      uint32 // POSIX permission bits, including setuid/setgid/sticky (07777)
 	ModTime   time.Time
 	IsDir     bool
 	IsSymlink bool     // True if this is a symbolic link
 	Meta      MetaData // Structured metadata for additional information
+	// Ino identifies the file across renames and hard links, unique on the
+	// server (0 = the plugin has no stable identifier)
+	Ino uint64
+	// Blocks is the number of 512-byte blocks the file has allocated, fewer
+	// than its size needs for a sparse file, and BlockSize its preferred I/O
+	// size (0 = the plugin doesn't know, see AllocatedBlocks)
+	Blocks    int64
+	BlockSize int64
 }
 
+// AllocatedBlocks returns the 512-byte blocks the file has allocated: those
+// the plugin reports, or as many as its size needs if it reports none
+func (f *FileInfo) AllocatedBlocks() int64 {
+	if f.Blocks > 0 {
+		return f.Blocks
+	}
+	return (f.Size + 511) / 512
+}
+
+// MetaAccess is the MetaData.Content key under which a plugin reports how a
+// file's content can be read. Files without it report no hint.
+const (
+	MetaAccess = "access"
+	// AccessRandom files can be read at any offset, in any number of requests
+	AccessRandom = "random"
+	// AccessStream files produce data as it is read and can't seek
+	AccessStream = "stream"
+	// AccessConsumeOnce files return new content on every read, like a
+	// queue's dequeue file, and must be read whole in a single request
+	AccessConsumeOnce = "consume-once"
+)
+
+// Get returns the value of key in m.Content, or "" if it isn't set
+func (m MetaData) Get(key string) string {
+	return m.Content[key]
+}
+
+// ContentType returns the MIME type reported under MetaContentType, or "" if
+// the plugin reports none
+func (m MetaData) ContentType() string {
+	return m.Get(MetaContentType)
+}
+
+// ETag returns the etag reported under MetaETag, or "" if the plugin reports
+// none
+func (m MetaData) ETag() string {
+	return m.Get(MetaETag)
+}
+
+// AccessSemantics returns the access hint reported under MetaAccess, or ""
+// if the plugin reports none
+func (m MetaData) AccessSemantics() string {
+	return m.Get(MetaAccess)
+}
+
+// Access returns the access hint the plugin reports for the file, or "" if
+// it reports none
+func (f *FileInfo) Access() string {
+	return f.Meta.AccessSemantics()
+}
+
+// MetaETag is the MetaData.Content key under which a plugin reports a token
+// that changes whenever the file's content or attributes change
+const MetaETag = "etag"
+
+// ETag returns the etag the plugin reports for the file, or "" if it reports
+// none. Pass it to StatIfChanged or ReadIfChanged to revalidate cached data.
+func (f *FileInfo) ETag() string {
+	return f.Meta.ETag()
+}
+
+// MetaContentType is the MetaData.Content key under which a plugin reports
+// the file's MIME type
+const MetaContentType = "content-type"
+
 // OpenFlag represents file open flags
 type OpenFlag int
 
@@ -34,6 +107,25 @@ const (
 	OpenFlagSync      OpenFlag = 1052672
 )
 
+// wireFlags converts f to the flag bits the server's handle API expects,
+// which differ from the os package values used by OpenFlag
+func (f OpenFlag) wireFlags() int {
+	wire := int(f & 3) // access mode is the same in both
+	if f&OpenFlagAppend != 0 {
+		wire |= 1 << 3
+	}
+	if f&OpenFlagCreate != 0 {
+		wire |= 1 << 4
+	}
+	if f&OpenFlagExclusive != 0 {
+		wire |= 1 << 5
+	}
+	if f&OpenFlagTruncate != 0 {
+		wire |= 1 << 6
+	}
+	return wire
+}
+
 // HandleInfo represents an open file handle
 type HandleInfo struct {
 	ID    int64    `json:"id"`
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+func runLs(c *cli, args []string) error {
+	fs := c.flags()
+	long := fs.Bool("l", false, "Print the mode, size and modification time of every entry")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	paths := fs.Args()
+	if len(paths) == 0 {
+		paths = []string{"/"}
+	}
+	headers := len(paths) > 1 || strings.ContainsAny(paths[0], `*?[\`)
+
+	return c.eachPath(paths, func(p string) error {
+		info, err := c.client.Stat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			c.printEntry(p, info, *long)
+			return nil
+		}
+		entries, err := c.client.ReadDir(p)
+		if err != nil {
+			return err
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		if headers {
+			fmt.Fprintf(c.stdout, "%s:\n", p)
+		}
+		for i := range entries {
+			c.printEntry(entries[i].Name, &entries[i], *long)
+		}
+		return nil
+	})
+}
+
+// printEntry prints a line of ls for info, listed as name
+func (c *cli) printEntry(name string, info *agfs.FileInfo, long bool) {
+	if !long {
+		fmt.Fprintln(c.stdout, name)
+		return
+	}
+	mode := os.FileMode(info.Mode & 0777)
+	switch {
+	case info.IsDir:
+		mode |= os.ModeDir
+	case info.IsSymlink:
+		mode |= os.ModeSymlink
+	}
+	fmt.Fprintf(c.stdout, "%s %12d %s %s\n", mode, info.Size, info.ModTime.Local().Format("2006-01-02 15:04"), name)
+}
+
+func runCat(c *cli, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	return c.eachPath(fs.Args(), func(p string) error {
+		_, err := c.download(p, c.stdout)
+		return err
+	})
+}
+
+func runRm(c *cli, args []string) error {
+	fs := c.flags()
+	recursive := fs.Bool("r", false, "Remove directories and everything in them")
+	force := fs.Bool("f", false, "Ignore paths and patterns that match nothing")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	if *force {
+		c.ignore = func(err error) bool {
+			return errors.Is(err, agfs.ErrNotFound) || errors.Is(err, errNoMatches)
+		}
+	}
+	return c.eachPath(fs.Args(), func(p string) error {
+		if *recursive {
+			return c.client.RemoveAll(p)
+		}
+		info, err := c.client.Stat(p)
+		if err != nil {
+			return err
+		}
+		if info.IsDir {
+			return errors.New("is a directory, remove it with -r")
+		}
+		return c.client.Remove(p)
+	})
+}
+
+func runMkdir(c *cli, args []string) error {
+	fs := c.flags()
+	parents := fs.Bool("p", false, "Create missing parents, and accept directories that exist")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errUsage
+	}
+	failed := false
+	for _, p := range fs.Args() {
+		var err error
+		if *parents {
+			err = c.client.MkdirAll(p, 0755)
+		} else {
+			err = c.client.Mkdir(p, 0755)
+		}
+		if err != nil {
+			c.errorf("%s: %v", p, err)
+			failed = true
+		}
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
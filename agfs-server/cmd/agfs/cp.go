@@ -0,0 +1,318 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// readChunk is the size of the ranged reads cat and cp make
+const readChunk = 1024 * 1024
+
+// progressMin is the size from which cp reports the progress of a copy
+var progressMin int64 = 8 * 1024 * 1024
+
+// location is a file of cp, on the server or on the local disk
+type location struct {
+	path   string
+	remote bool
+}
+
+// parseLocation parses an argument of cp: server paths start with a colon
+func parseLocation(arg string) location {
+	if strings.HasPrefix(arg, ":") {
+		return location{path: path.Clean("/" + arg[1:]), remote: true}
+	}
+	return location{path: arg}
+}
+
+func (l location) String() string {
+	if l.remote {
+		return ":" + l.path
+	}
+	return l.path
+}
+
+func runCp(c *cli, args []string) error {
+	fs := c.flags()
+	quiet := fs.Bool("q", false, "Don't report the progress of large copies")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return errUsage
+	}
+	args = fs.Args()
+	rawDst := args[len(args)-1]
+	dst := parseLocation(rawDst)
+
+	failed := false
+	var sources []location
+	for _, arg := range args[:len(args)-1] {
+		src := parseLocation(arg)
+		if !src.remote {
+			sources = append(sources, src)
+			continue
+		}
+		paths, err := c.expand(src.path)
+		if err != nil {
+			c.errorf("%v", err)
+			failed = true
+			continue
+		}
+		for _, p := range paths {
+			sources = append(sources, location{path: p, remote: true})
+		}
+	}
+
+	intoDir, err := c.isDir(dst, rawDst)
+	if err != nil {
+		return err
+	}
+	if len(sources) > 1 && !intoDir {
+		return fmt.Errorf("%s is not a directory", dst)
+	}
+	for _, src := range sources {
+		target := dst
+		if intoDir {
+			target.path = joinLocation(dst, baseName(src))
+		}
+		if err := c.copyFile(src, target, !*quiet); err != nil {
+			c.errorf("%s: %v", src, err)
+			failed = true
+		}
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
+
+// isDir reports whether the destination of cp is a directory to copy into:
+// one that exists, or any path written with a trailing slash
+func (c *cli) isDir(l location, raw string) (bool, error) {
+	if strings.HasSuffix(raw, "/") {
+		return true, nil
+	}
+	if l.remote {
+		info, err := c.client.Stat(l.path)
+		if errors.Is(err, agfs.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return info.IsDir, nil
+	}
+	info, err := os.Stat(l.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.IsDir(), nil
+}
+
+func baseName(l location) string {
+	if l.remote {
+		return path.Base(l.path)
+	}
+	return filepath.Base(l.path)
+}
+
+func joinLocation(dir location, name string) string {
+	if dir.remote {
+		return path.Join(dir.path, name)
+	}
+	return filepath.Join(dir.path, name)
+}
+
+// copyFile copies a file to dst, at least one of them on the server,
+// reporting the progress of large copies if showProgress is set
+func (c *cli) copyFile(src, dst location, showProgress bool) error {
+	if !src.remote && !dst.remote {
+		return errors.New("neither file is on the server, write server paths as :path")
+	}
+	size, err := c.sourceSize(src)
+	if err != nil {
+		return err
+	}
+	var p *progress
+	if showProgress && size >= progressMin {
+		p = &progress{w: c.stderr, name: src.String(), total: size}
+		defer p.finish()
+	}
+
+	if !dst.remote {
+		f, err := os.Create(dst.path)
+		if err != nil {
+			return err
+		}
+		_, err = c.readSource(src, p.wrap(f))
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+
+	// WriteStream reads the source as it is downloaded or read from disk
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := c.readSource(src, pw)
+		pw.CloseWithError(err)
+	}()
+	_, err = c.client.WriteStream(dst.path, p.reader(pr))
+	pr.CloseWithError(err)
+	return err
+}
+
+// sourceSize returns the size of a file cp copies, failing for directories
+func (c *cli) sourceSize(src location) (int64, error) {
+	if src.remote {
+		info, err := c.client.Stat(src.path)
+		if err != nil {
+			return 0, err
+		}
+		if info.IsDir {
+			return 0, errors.New("is a directory")
+		}
+		return info.Size, nil
+	}
+	info, err := os.Stat(src.path)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return 0, errors.New("is a directory")
+	}
+	return info.Size(), nil
+}
+
+// readSource writes the content of src to w
+func (c *cli) readSource(src location, w io.Writer) (int64, error) {
+	if src.remote {
+		return c.download(src.path, w)
+	}
+	f, err := os.Open(src.path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return io.Copy(w, f)
+}
+
+// download writes the content of the server file p to w, in ranged reads
+// for files that can be read at any offset. Streams are streamed, and files
+// that change on every read, or report no size, are read in one request.
+func (c *cli) download(p string, w io.Writer) (int64, error) {
+	info, err := c.client.Stat(p)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir {
+		return 0, errors.New("is a directory")
+	}
+
+	switch {
+	case info.Access() == agfs.AccessStream:
+		r, err := c.client.ReadStream(p)
+		if err != nil {
+			return 0, err
+		}
+		defer r.Close()
+		return io.Copy(w, r)
+	case info.Access() == agfs.AccessConsumeOnce || info.Size == 0:
+		data, err := c.client.Read(p, 0, -1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		n, err := w.Write(data)
+		return int64(n), err
+	}
+
+	var offset int64
+	for {
+		data, err := c.client.Read(p, offset, readChunk)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return offset, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return offset, err
+		}
+		offset += int64(len(data))
+		if len(data) < readChunk {
+			return offset, nil
+		}
+	}
+}
+
+// progress reports how much of a copy is done, on a line it rewrites
+type progress struct {
+	w       io.Writer
+	name    string
+	total   int64
+	done    int64
+	printed time.Time
+}
+
+// wrap returns w counting what is written to it, or w itself if p is nil
+func (p *progress) wrap(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return io.MultiWriter(w, p)
+}
+
+// reader returns r counting what is read from it, or r itself if p is nil
+func (p *progress) reader(r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return io.TeeReader(r, p)
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if time.Since(p.printed) >= 200*time.Millisecond {
+		p.print()
+	}
+	return len(b), nil
+}
+
+func (p *progress) print() {
+	percent := int64(100)
+	if p.total > 0 {
+		percent = p.done * 100 / p.total
+	}
+	fmt.Fprintf(p.w, "\r%s: %s / %s (%d%%)", p.name, formatSize(p.done), formatSize(p.total), percent)
+	p.printed = time.Now()
+}
+
+// finish prints the final state of the copy and ends its line
+func (p *progress) finish() {
+	p.print()
+	fmt.Fprintln(p.w)
+}
+
+// formatSize formats a byte count in binary units
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// runFsck checks a tree on the server. It fails with errFailed if issues
+// were found, and with errAborted if the check itself failed.
+func runFsck(c *cli, args []string) error {
+	fs := c.flags()
+	concurrency := fs.Int("concurrency", 4, "Number of directories checked in parallel")
+	verifySizes := fs.Bool("verify-sizes", false, "Read every file to compare its size with the bytes readable (reads have side effects on some plugins, e.g. queuefs)")
+	timeout := fs.Duration("timeout", 0, "Abort the check after this duration (0 = no limit)")
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: agfs %s\n\n", c.usage)
+		fmt.Fprintf(c.stderr, "Report dangling symlinks, entries where stat and readdir disagree,\n")
+		fmt.Fprintf(c.stderr, "and (with -verify-sizes) files whose size differs from their content.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errUsage
+	}
+	root := fs.Arg(0)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	report, err := filesystem.Check(c.fileSystem(), root, filesystem.CheckOptions{
+		Context:     ctx,
+		Concurrency: *concurrency,
+		VerifySizes: *verifySizes,
+	})
+	if report == nil {
+		c.errorf("%v", err)
+		return errAborted
+	}
+
+	for _, issue := range report.Issues {
+		fmt.Fprintln(c.stdout, issue)
+	}
+	fmt.Fprintf(c.stdout, "%s: %d directories, %d files, %d symlinks, %d issues (%v)\n",
+		root, report.Dirs, report.Files, report.Symlinks, len(report.Issues), time.Since(start).Round(time.Millisecond))
+
+	if err != nil {
+		c.errorf("check incomplete: %v", err)
+		return errAborted
+	}
+	if !report.OK() {
+		return errFailed
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+// Command agfs works with the files of an AGFS server from the command line
+// and scripts, without mounting it:
+//
+//	agfs ls -l /data
+//	agfs cat /logs/app.log
+//	agfs cp report.csv :/data/
+//	agfs cp ':/logs/*.log' ./logs/
+//	agfs rm -r /tmp/scratch
+//	agfs mkdir -p /data/2024/01
+//	agfs export /data backup.tar
+//	agfs import /restored < backup.tar
+//	agfs fsck -verify-sizes /memfs
+//
+// Paths are paths on the server, except for cp, where server paths start
+// with a colon and the others are local, and for the archives of export and
+// import, which are local files. Server paths may be glob patterns,
+// quoted so the shell doesn't expand them.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/proxyfs"
+)
+
+// command is a subcommand of agfs
+type command struct {
+	usage   string
+	summary string
+	run     func(c *cli, args []string) error
+}
+
+var commands = map[string]command{
+	"ls":     {"ls [-l] [path...]", "List directories, or describe files", runLs},
+	"cat":    {"cat path...", "Write files to standard output", runCat},
+	"cp":     {"cp [-q] source... destination", "Copy files between the local disk (plain paths) and the server (:path)", runCp},
+	"rm":     {"rm [-r] [-f] path...", "Remove files, or directories with -r", runRm},
+	"mkdir":  {"mkdir [-p] path...", "Create directories, with their parents with -p", runMkdir},
+	"export": {"export path [archive]", "Write the tree under path as a tar archive (default standard output)", runExport},
+	"import": {"import path [archive]", "Create the entries of a tar archive (default standard input) under path", runImport},
+	"fsck":   {"fsck [-verify-sizes] path", "Check the tree under path for inconsistencies", runFsck},
+}
+
+// errUsage makes a command exit with status 2 once it printed its usage
+var errUsage = errors.New("usage")
+
+// errFailed makes a command exit with status 1 once it reported its errors
+var errFailed = errors.New("failed")
+
+// errAborted makes a command exit with status 2 once it reported why it
+// couldn't finish
+var errAborted = errors.New("aborted")
+
+// errNoMatches is the error of a glob pattern matching nothing
+var errNoMatches = errors.New("no matches")
+
+// cli is what commands run with
+type cli struct {
+	client *agfs.Client
+	server string // URL and token of the server, for fileSystem
+	token  string
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	name   string // Command being run, prefixed to its errors
+	usage  string // Its usage line
+	// Errors eachPath doesn't report, such as missing files for rm -f
+	// (nil = report them all)
+	ignore func(error) bool
+}
+
+// fileSystem returns the server as a filesystem.FileSystem, for the
+// commands built on the filesystem package
+func (c *cli) fileSystem() filesystem.FileSystem {
+	return proxyfs.NewProxyFS(c.server, "agfs", agfs.WithToken(c.token))
+}
+
+// errorf reports an error of the command, for the commands that carry on
+// with their other arguments
+func (c *cli) errorf(format string, args ...interface{}) {
+	fmt.Fprintf(c.stderr, "agfs %s: %s\n", c.name, fmt.Sprintf(format, args...))
+}
+
+// flags returns the flag set of the command, printing its usage to stderr
+func (c *cli) flags() *flag.FlagSet {
+	fs := flag.NewFlagSet(c.name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(c.stderr, "Usage: agfs %s\n", c.usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parse parses the flags of the command
+func (c *cli) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	return nil
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the agfs command line args and returns its exit status
+func run(args []string, stdout, stderr io.Writer) int {
+	global := flag.NewFlagSet("agfs", flag.ContinueOnError)
+	global.SetOutput(stderr)
+	server := global.String("server", defaultServerURL(), "AGFS server URL (default $AGFS_SERVER_URL, or http://localhost:8080)")
+	token := global.String("token", os.Getenv("AGFS_TOKEN"), "Bearer token authenticating requests (default $AGFS_TOKEN)")
+	global.Usage = func() { printUsage(stderr, global) }
+	if err := global.Parse(args); err != nil {
+		return 2
+	}
+	if global.NArg() == 0 {
+		printUsage(stderr, global)
+		return 2
+	}
+
+	name := global.Arg(0)
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(stderr, "agfs: unknown command %q\n", name)
+		printUsage(stderr, global)
+		return 2
+	}
+	c := &cli{client: agfs.NewClient(*server, agfs.WithToken(*token)), server: *server, token: *token, stdin: os.Stdin, stdout: stdout, stderr: stderr, name: name, usage: cmd.usage}
+	switch err := cmd.run(c, global.Args()[1:]); {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUsage), errors.Is(err, errAborted):
+		return 2
+	case errors.Is(err, errFailed):
+		return 1
+	default:
+		c.errorf("%v", err)
+		return 1
+	}
+}
+
+func defaultServerURL() string {
+	if url := os.Getenv("AGFS_SERVER_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:8080"
+}
+
+func printUsage(w io.Writer, global *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: agfs [-server url] [-token token] command [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-32s %s\n", commands[name].usage, commands[name].summary)
+	}
+	fmt.Fprintf(w, "\nFlags:\n")
+	global.PrintDefaults()
+}
+
+// expand returns the server paths pattern matches, or pattern itself if it
+// isn't a glob pattern, so the command reports a missing file as such
+func (c *cli) expand(pattern string) ([]string, error) {
+	if !strings.ContainsAny(pattern, `*?[\`) {
+		return []string{pattern}, nil
+	}
+	matches, err := c.client.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: %w", pattern, errNoMatches)
+	}
+	return matches, nil
+}
+
+// eachPath runs fn on the server paths args match, reporting each failure
+// and carrying on with the others, and returns errFailed if any failed
+func (c *cli) eachPath(args []string, fn func(path string) error) error {
+	failed := false
+	for _, arg := range args {
+		paths, err := c.expand(arg)
+		if err != nil {
+			if c.ignore == nil || !c.ignore(err) {
+				c.errorf("%v", err)
+				failed = true
+			}
+			continue
+		}
+		for _, p := range paths {
+			if err := fn(p); err != nil && (c.ignore == nil || !c.ignore(err)) {
+				c.errorf("%s: %v", p, err)
+				failed = true
+			}
+		}
+	}
+	if failed {
+		return errFailed
+	}
+	return nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-sdk/go/agfstest"
+)
+
+// newServer starts a fake server holding a few files
+func newServer(t *testing.T) (*agfstest.Server, *agfs.Client) {
+	t.Helper()
+	srv := agfstest.NewServer()
+	t.Cleanup(srv.Close)
+	client := agfs.NewClient(srv.URL)
+	for _, dir := range []string{"/logs/old", "/data"} {
+		if err := client.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("MkdirAll failed: %v", err)
+		}
+	}
+	for name, data := range map[string]string{
+		"/logs/app.log":     "app\n",
+		"/logs/db.log":      "db\n",
+		"/logs/old/app.log": "old\n",
+		"/logs/readme.txt":  "readme\n",
+		"/data/report.csv":  "a,b\n1,2\n",
+		"/data/empty":       "",
+	} {
+		if _, err := client.Write(name, []byte(data)); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	return srv, client
+}
+
+// agfsRun runs the command line args against srv
+func agfsRun(srv *agfstest.Server, args ...string) (code int, stdout, stderr string) {
+	var out, errOut bytes.Buffer
+	code = run(append([]string{"-server", srv.URL}, args...), &out, &errOut)
+	return code, out.String(), errOut.String()
+}
+
+func TestLs(t *testing.T) {
+	srv, _ := newServer(t)
+
+	if code, out, errOut := agfsRun(srv, "ls", "/logs"); code != 0 || out != "app.log\ndb.log\nold\nreadme.txt\n" {
+		t.Errorf("Unexpected listing (%d): %q %s", code, out, errOut)
+	}
+	if code, out, _ := agfsRun(srv, "ls", "/logs/*.log"); code != 0 || out != "/logs/app.log\n/logs/db.log\n" {
+		t.Errorf("Expected the matching files, got (%d) %q", code, out)
+	}
+	if code, out, _ := agfsRun(srv, "ls", "/logs", "/data"); code != 0 || !strings.Contains(out, "/logs:\n") || !strings.Contains(out, "/data:\nempty\nreport.csv\n") {
+		t.Errorf("Expected a header per directory, got (%d) %q", code, out)
+	}
+
+	code, out, _ := agfsRun(srv, "ls", "-l", "/data")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if code != 0 || len(lines) != 2 || !strings.HasPrefix(lines[1], "-rw") || !strings.Contains(lines[1], " 8 ") || !strings.HasSuffix(lines[1], " report.csv") {
+		t.Errorf("Unexpected long listing (%d): %q", code, out)
+	}
+	if _, out, _ := agfsRun(srv, "ls", "-l", "/logs"); !strings.HasPrefix(strings.Split(out, "\n")[2], "drwx") {
+		t.Errorf("Expected the directory marked as such, got %q", out)
+	}
+
+	if code, _, errOut := agfsRun(srv, "ls", "/missing", "/data"); code != 1 || !strings.Contains(errOut, "agfs ls: /missing:") {
+		t.Errorf("Expected a missing path to fail, got (%d) %q", code, errOut)
+	}
+	if code, _, errOut := agfsRun(srv, "ls", "/logs/*.gz"); code != 1 || !strings.Contains(errOut, "no matches") {
+		t.Errorf("Expected a pattern matching nothing to fail, got (%d) %q", code, errOut)
+	}
+}
+
+func TestCat(t *testing.T) {
+	srv, client := newServer(t)
+
+	if code, out, _ := agfsRun(srv, "cat", "/logs/app.log", "/logs/db.log"); code != 0 || out != "app\ndb\n" {
+		t.Errorf("Unexpected output (%d): %q", code, out)
+	}
+	if code, out, _ := agfsRun(srv, "cat", "/logs/*.log"); code != 0 || out != "app\ndb\n" {
+		t.Errorf("Expected the matching files, got (%d) %q", code, out)
+	}
+
+	// Files larger than a read are read in ranges
+	big := bytes.Repeat([]byte("0123456789abcdef"), readChunk/16*2+100)
+	if _, err := client.Write("/big", big); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if code, out, _ := agfsRun(srv, "cat", "/big"); code != 0 || out != string(big) {
+		t.Errorf("Expected %d bytes, got %d (%d)", len(big), len(out), code)
+	}
+
+	if code, _, errOut := agfsRun(srv, "cat", "/logs"); code != 1 || !strings.Contains(errOut, "is a directory") {
+		t.Errorf("Expected a directory to fail, got (%d) %q", code, errOut)
+	}
+	if code, _, _ := agfsRun(srv, "cat"); code != 2 {
+		t.Errorf("Expected a usage error, got %d", code)
+	}
+}
+
+func TestCp(t *testing.T) {
+	srv, client := newServer(t)
+	dir := t.TempDir()
+
+	// Server to local
+	local := filepath.Join(dir, "report.csv")
+	if code, _, errOut := agfsRun(srv, "cp", ":/data/report.csv", local); code != 0 {
+		t.Fatalf("cp failed (%d): %s", code, errOut)
+	}
+	if data, err := os.ReadFile(local); err != nil || string(data) != "a,b\n1,2\n" {
+		t.Errorf("Unexpected local copy %q (%v)", data, err)
+	}
+
+	// Local to server, into a directory
+	if err := os.WriteFile(local, []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := agfsRun(srv, "cp", local, ":/logs"); code != 0 {
+		t.Fatalf("cp failed (%d): %s", code, errOut)
+	}
+	if data, err := client.Read("/logs/report.csv", 0, -1); err != nil || string(data) != "changed\n" {
+		t.Errorf("Unexpected server copy %q (%v)", data, err)
+	}
+
+	// Several matches into a local directory
+	logs := filepath.Join(dir, "logs") + "/"
+	if err := os.Mkdir(logs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := agfsRun(srv, "cp", ":/logs/*.log", logs); code != 0 {
+		t.Fatalf("cp failed (%d): %s", code, errOut)
+	}
+	for name, want := range map[string]string{"app.log": "app\n", "db.log": "db\n"} {
+		if data, err := os.ReadFile(filepath.Join(logs, name)); err != nil || string(data) != want {
+			t.Errorf("Unexpected copy of %s: %q (%v)", name, data, err)
+		}
+	}
+
+	// Server to server, and empty files
+	if code, _, errOut := agfsRun(srv, "cp", ":/logs/old/app.log", ":/data/empty", ":/data/"); code != 0 {
+		t.Fatalf("cp failed (%d): %s", code, errOut)
+	}
+	if data, err := client.Read("/data/app.log", 0, -1); err != nil || string(data) != "old\n" {
+		t.Errorf("Unexpected server-side copy %q (%v)", data, err)
+	}
+
+	if code, _, errOut := agfsRun(srv, "cp", ":/logs/*.log", ":/data/report.csv"); code != 1 || !strings.Contains(errOut, "not a directory") {
+		t.Errorf("Expected several files into a file to fail, got (%d) %q", code, errOut)
+	}
+	if code, _, errOut := agfsRun(srv, "cp", local, filepath.Join(dir, "other")); code != 1 || !strings.Contains(errOut, "server paths") {
+		t.Errorf("Expected a local copy to fail, got (%d) %q", code, errOut)
+	}
+	if code, _, _ := agfsRun(srv, "cp", local); code != 2 {
+		t.Errorf("Expected a usage error, got %d", code)
+	}
+}
+
+func TestCpProgress(t *testing.T) {
+	srv, client := newServer(t)
+	defer func(min int64) { progressMin = min }(progressMin)
+	progressMin = 1024
+
+	big := bytes.Repeat([]byte("x"), 3*readChunk+5)
+	local := filepath.Join(t.TempDir(), "big")
+	if err := os.WriteFile(local, big, 0644); err != nil {
+		t.Fatal(err)
+	}
+	code, _, errOut := agfsRun(srv, "cp", local, ":/big")
+	if code != 0 || !strings.Contains(errOut, "3.0 MiB / 3.0 MiB (100%)") {
+		t.Errorf("Expected the progress of the copy, got (%d) %q", code, errOut)
+	}
+	if info, err := client.Stat("/big"); err != nil || info.Size != int64(len(big)) {
+		t.Errorf("Expected %d bytes on the server, got %+v (%v)", len(big), info, err)
+	}
+
+	if code, _, errOut := agfsRun(srv, "cp", "-q", ":/big", local); code != 0 || errOut != "" {
+		t.Errorf("Expected a quiet copy, got (%d) %q", code, errOut)
+	}
+	if code, _, errOut := agfsRun(srv, "cp", ":/data/report.csv", local); code != 0 || errOut != "" {
+		t.Errorf("Expected no progress for a small file, got (%d) %q", code, errOut)
+	}
+}
+
+func TestRmMkdir(t *testing.T) {
+	srv, client := newServer(t)
+
+	if code, _, errOut := agfsRun(srv, "rm", "/logs/*.log"); code != 0 {
+		t.Fatalf("rm failed (%d): %s", code, errOut)
+	}
+	if matches, _ := client.Glob("/logs/*"); len(matches) != 2 {
+		t.Errorf("Expected the logs removed, left %q", matches)
+	}
+	if code, _, errOut := agfsRun(srv, "rm", "/logs/old"); code != 1 || !strings.Contains(errOut, "-r") {
+		t.Errorf("Expected a directory to need -r, got (%d) %q", code, errOut)
+	}
+	if code, _, errOut := agfsRun(srv, "rm", "-r", "/logs"); code != 0 {
+		t.Errorf("rm -r failed (%d): %s", code, errOut)
+	}
+	if code, _, _ := agfsRun(srv, "rm", "/logs"); code != 1 {
+		t.Errorf("Expected a missing file to fail, got %d", code)
+	}
+	if code, _, errOut := agfsRun(srv, "rm", "-f", "/logs", "/data/*.gz"); code != 0 {
+		t.Errorf("Expected -f to ignore what matches nothing, got (%d) %q", code, errOut)
+	}
+
+	if code, _, _ := agfsRun(srv, "mkdir", "/a/b"); code != 1 {
+		t.Errorf("Expected mkdir without a parent to fail, got %d", code)
+	}
+	if code, _, errOut := agfsRun(srv, "mkdir", "-p", "/a/b", "/c"); code != 0 {
+		t.Errorf("mkdir -p failed (%d): %s", code, errOut)
+	}
+	if info, err := client.Stat("/a/b"); err != nil || !info.IsDir {
+		t.Errorf("Expected /a/b created, got %+v (%v)", info, err)
+	}
+}
+
+// treeOf describes the tree under root, an entry per line
+func treeOf(t *testing.T, client *agfs.Client, root string) []string {
+	t.Helper()
+	var tree []string
+	var walk func(dir string)
+	walk = func(dir string) {
+		entries, err := client.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir %s failed: %v", dir, err)
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+		for _, entry := range entries {
+			p := path.Join(dir, entry.Name)
+			name := strings.TrimPrefix(p, root)
+			switch {
+			case entry.IsSymlink:
+				target, err := client.Readlink(p)
+				if err != nil {
+					t.Fatalf("Readlink %s failed: %v", p, err)
+				}
+				tree = append(tree, fmt.Sprintf("%s -> %s", name, target))
+			case entry.IsDir:
+				tree = append(tree, fmt.Sprintf("%s/ %o", name, entry.Mode))
+				walk(p)
+			default:
+				data, err := client.Read(p, 0, -1)
+				if err != nil && !errors.Is(err, io.EOF) {
+					t.Fatalf("Read %s failed: %v", p, err)
+				}
+				tree = append(tree, fmt.Sprintf("%s %o %q", name, entry.Mode, data))
+			}
+		}
+	}
+	walk(root)
+	return tree
+}
+
+func TestExportImport(t *testing.T) {
+	srv, client := newServer(t)
+	if err := client.Chmod("/logs/readme.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	for link, target := range map[string]string{"/logs/latest": "app.log", "/logs/up": "..", "/logs/gone": "/nowhere"} {
+		if err := client.Symlink(target, link); err != nil {
+			t.Fatalf("Symlink %s failed: %v", link, err)
+		}
+	}
+	big := bytes.Repeat([]byte("0123456789"), readChunk/10+1000)
+	if _, err := client.Write("/logs/old/big", big); err != nil {
+		t.Fatal(err)
+	}
+
+	archive := filepath.Join(t.TempDir(), "logs.tar")
+	if code, _, errOut := agfsRun(srv, "export", "/logs", archive); code != 0 {
+		t.Fatalf("export failed (%d): %s", code, errOut)
+	}
+	if code, _, errOut := agfsRun(srv, "import", "/restored/logs", archive); code != 0 {
+		t.Fatalf("import failed (%d): %s", code, errOut)
+	}
+	want, got := treeOf(t, client, "/logs"), treeOf(t, client, "/restored/logs")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Imported tree differs:\n got %q\nwant %q", got, want)
+	}
+
+	// Importing again replaces what is there
+	if _, err := client.Write("/restored/logs/app.log", []byte("changed\n")); err != nil {
+		t.Fatal(err)
+	}
+	if code, _, errOut := agfsRun(srv, "import", "/restored/logs", archive); code != 0 {
+		t.Fatalf("import failed (%d): %s", code, errOut)
+	}
+	if got := treeOf(t, client, "/restored/logs"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Imported tree differs:\n got %q\nwant %q", got, want)
+	}
+
+	// The archive goes to standard output without a file
+	code, out, _ := agfsRun(srv, "export", "/data")
+	hdr, err := tar.NewReader(strings.NewReader(out)).Next()
+	if code != 0 || err != nil || hdr.Name != "empty" {
+		t.Errorf("Expected the archive on standard output, got (%d) %+v (%v)", code, hdr, err)
+	}
+
+	if code, _, _ := agfsRun(srv, "export", "/missing", archive); code != 1 {
+		t.Errorf("Expected a missing tree to fail, got %d", code)
+	}
+	if code, _, _ := agfsRun(srv, "import"); code != 2 {
+		t.Errorf("Expected a usage error, got %d", code)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	srv, client := newServer(t)
+	if code, out, errOut := agfsRun(srv, "fsck", "/logs"); code != 0 || !strings.Contains(out, "0 issues") {
+		t.Errorf("Expected a clean tree, got (%d) %q %s", code, out, errOut)
+	}
+
+	if err := client.Symlink("/nowhere", "/logs/gone"); err != nil {
+		t.Fatal(err)
+	}
+	if code, out, _ := agfsRun(srv, "fsck", "/logs"); code != 1 || !strings.Contains(out, "/logs/gone") {
+		t.Errorf("Expected the dangling symlink reported, got (%d) %q", code, out)
+	}
+	if code, _, _ := agfsRun(srv, "fsck", "/missing"); code != 2 {
+		t.Errorf("Expected a failed check to exit with 2, got %d", code)
+	}
+	if code, _, _ := agfsRun(srv, "fsck"); code != 2 {
+		t.Errorf("Expected a usage error, got %d", code)
+	}
+}
+
+func TestUsage(t *testing.T) {
+	srv, _ := newServer(t)
+	if code, _, errOut := agfsRun(srv); code != 2 || !strings.Contains(errOut, "Commands:") {
+		t.Errorf("Expected the usage, got (%d) %q", code, errOut)
+	}
+	if code, _, errOut := agfsRun(srv, "mv", "/a", "/b"); code != 2 || !strings.Contains(errOut, `unknown command "mv"`) {
+		t.Errorf("Expected an unknown command to fail, got (%d) %q", code, errOut)
+	}
+}
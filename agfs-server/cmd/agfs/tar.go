@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func runExport(c *cli, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errUsage
+	}
+	root := path.Clean("/" + fs.Arg(0))
+
+	if name := fs.Arg(1); name != "" && name != "-" {
+		f, err := os.Create(name)
+		if err != nil {
+			return err
+		}
+		err = filesystem.TarExport(c.fileSystem(), root, f)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		return err
+	}
+	return filesystem.TarExport(c.fileSystem(), root, c.stdout)
+}
+
+func runImport(c *cli, args []string) error {
+	fs := c.flags()
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		fs.Usage()
+		return errUsage
+	}
+	root := path.Clean("/" + fs.Arg(0))
+
+	in := c.stdin
+	if name := fs.Arg(1); name != "" && name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		in = f
+	}
+	return filesystem.TarImport(c.fileSystem(), root, in)
+}
@@ -1,15 +1,18 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/http"
-	"path/filepath"
-	"runtime"
+	"os"
+	"strings"
 	"time"
 
+	"github.com/c4pt0r/agfs/agfs-sdk/go/cmdutil"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/config"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/handlers"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/httpgw"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
@@ -23,6 +26,7 @@ import (
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/proxyfs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/queuefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/routerfs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/s3fs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/serverinfofs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/sqlfs"
@@ -30,7 +34,10 @@ import (
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/streamfs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/streamrotatefs"
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/vectorfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/s3gw"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/webdav"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -49,6 +56,7 @@ var availablePlugins = map[string]PluginFactory{
 	"serverinfofs":   func() plugin.ServicePlugin { return serverinfofs.NewServerInfoFSPlugin() },
 	"memfs":          func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() },
 	"queuefs":        func() plugin.ServicePlugin { return queuefs.NewQueueFSPlugin() },
+	"routerfs":       func() plugin.ServicePlugin { return routerfs.NewRouterFSPlugin() },
 	"kvfs":           func() plugin.ServicePlugin { return kvfs.NewKVFSPlugin() },
 	"hellofs":        func() plugin.ServicePlugin { return hellofs.NewHelloFSPlugin() },
 	"heartbeatfs":    func() plugin.ServicePlugin { return heartbeatfs.NewHeartbeatFSPlugin() },
@@ -69,7 +77,14 @@ const sampleConfig = `# AGFS Server Configuration File
 
 server:
   address: ":8080"          # Server listen address
-  log_level: "info"         # Log level: debug, info, warn, error
+  log_level: "info"         # Log level: trace, debug, info, warn, error
+  log_format: "text"        # Log format: text, json
+  # trace_file: "/var/log/agfs/traces.jsonl"  # Write OpenTelemetry spans to this file
+  # gateway_prefix: "/files/"  # Serve files by URL under this prefix for browsers
+  # webdav_prefix: "/dav/"      # Serve files over WebDAV under this prefix for OS file managers
+  # s3_address: ":9000"         # Serve mounts as read-only S3 buckets on this address
+  # usage_refresh_interval: 60  # Seconds the usage counted by walking a mount is cached
+  # symlink_compact_interval: 600  # Seconds between sweeps for symlinks left by gone mounts
 
 # Plugin configurations
 plugins:
@@ -163,6 +178,9 @@ plugins:
 func main() {
 	configFile := flag.String("c", "config.yaml", "Path to configuration file")
 	addr := flag.String("addr", "", "Server listen address (will override addr in config file)")
+	logLevelFlag := flag.String("log-level", "", "Log level: trace, debug, info, warn, error (will override log_level in config file)")
+	logFormatFlag := flag.String("log-format", "", "Log format: text, json (will override log_format in config file)")
+	traceFile := flag.String("trace-file", "", "Write OpenTelemetry spans to this file (will override trace_file in config file)")
 	printSampleConfig := flag.Bool("print-sample-config", false, "Print a sample configuration file and exit")
 	version := flag.Bool("version", false, "Print version information and exit")
 	flag.Parse()
@@ -188,21 +206,19 @@ func main() {
 	}
 
 	// Configure logrus
-	logLevel := log.InfoLevel
-	if cfg.Server.LogLevel != "" {
-		if level, err := log.ParseLevel(cfg.Server.LogLevel); err == nil {
-			logLevel = level
-		}
+	if *logLevelFlag != "" {
+		cfg.Server.LogLevel = *logLevelFlag // Command line override
+	}
+	if *logFormatFlag != "" {
+		cfg.Server.LogFormat = *logFormatFlag // Command line override
+	}
+	if cfg.Server.LogLevel == "" {
+		cfg.Server.LogLevel = "info"
+	}
+	if err := cmdutil.ConfigureLogging(log.StandardLogger(), cfg.Server.LogLevel, cfg.Server.LogFormat); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
-	log.SetFormatter(&log.TextFormatter{
-		FullTimestamp: true,
-		CallerPrettyfier: func(f *runtime.Frame) (string, string) {
-			filename := filepath.Base(f.File)
-			return "", fmt.Sprintf(" | %s:%d | ", filename, f.Line)
-		},
-	})
-	log.SetReportCaller(true)
-	log.SetLevel(logLevel)
 
 	// Determine server address
 	serverAddr := cfg.Server.Address
@@ -213,18 +229,49 @@ func main() {
 		serverAddr = ":8080" // Default
 	}
 
+	// Set up tracing if a trace file is configured
+	if *traceFile != "" {
+		cfg.Server.TraceFile = *traceFile // Command line override
+	}
+	var tracer trace.Tracer
+	if cfg.Server.TraceFile != "" {
+		// The server never shuts down cleanly, so spans are written as they end
+		t, _, err := cmdutil.NewFileTracer(cfg.Server.TraceFile, "github.com/c4pt0r/agfs/agfs-server", false)
+		if err != nil {
+			log.Fatalf("Tracing setup failed: %v", err)
+		}
+		tracer = t
+		log.Infof("Writing traces to %s", cfg.Server.TraceFile)
+	}
+
 	// Create WASM instance pool configuration from config
 	wasmConfig := cfg.GetWASMConfig()
 	poolConfig := api.PoolConfig{
-		MaxInstances:        wasmConfig.InstancePoolSize,
-		InstanceMaxLifetime: time.Duration(wasmConfig.InstanceMaxLifetime) * time.Second,
-		InstanceMaxRequests: int64(wasmConfig.InstanceMaxRequests),
-		HealthCheckInterval: time.Duration(wasmConfig.HealthCheckInterval) * time.Second,
-		EnableStatistics:    wasmConfig.EnablePoolStatistics,
+		MaxInstances:         wasmConfig.InstancePoolSize,
+		BurstMax:             wasmConfig.InstanceBurstMax,
+		InstanceMaxLifetime:  time.Duration(wasmConfig.InstanceMaxLifetime) * time.Second,
+		InstanceMaxRequests:  int64(wasmConfig.InstanceMaxRequests),
+		HealthCheckInterval:  time.Duration(wasmConfig.HealthCheckInterval) * time.Second,
+		EnableStatistics:     wasmConfig.EnablePoolStatistics,
+		Tracer:               tracer,
+		RateLimit:            toRateLimit(wasmConfig.RateLimit),
+		AffinityMaxInstances: wasmConfig.AffinityInstances,
+		AffinityIdleTimeout:  time.Duration(wasmConfig.AffinityIdleTimeout) * time.Second,
+		UnhealthyAfter:       wasmConfig.UnhealthyAfter,
+		RecoveryInterval:     time.Duration(wasmConfig.RecoveryInterval) * time.Second,
+		UnreadyAfter:         time.Duration(wasmConfig.UnreadyAfter) * time.Second,
+	}
+	if len(wasmConfig.PluginRateLimits) > 0 {
+		poolConfig.PluginRateLimits = make(map[string]api.RateLimit, len(wasmConfig.PluginRateLimits))
+		for name, limit := range wasmConfig.PluginRateLimits {
+			poolConfig.PluginRateLimits[name] = toRateLimit(limit)
+		}
 	}
 
 	// Create mountable file system
 	mfs := mountablefs.NewMountableFS(poolConfig)
+	mfs.SetUsageRefreshInterval(time.Duration(cfg.Server.UsageRefreshInterval) * time.Second)
+	go mfs.RunSymlinkCompaction(context.Background(), time.Duration(cfg.Server.SymlinkCompactInterval)*time.Second)
 
 	// Create traffic monitor early so it can be injected into plugins during mounting
 	trafficMonitor := handlers.NewTrafficMonitor()
@@ -238,8 +285,11 @@ func main() {
 		})
 	}
 
-	// mountPlugin initializes and mounts a plugin asynchronously
-	mountPlugin := func(pluginName, instanceName, mountPath string, pluginConfig map[string]interface{}) {
+	// mountPlugin plans the mount of a plugin, recording the outcome in
+	// mounts once the planned mounts are set up
+	var mounts startupMounts
+	var planned []mountablefs.PlannedMount
+	mountPlugin := func(pluginName, instanceName, mountPath string, pluginConfig map[string]interface{}, opts mountablefs.MountOptions, dependsOn []string) {
 		// Get plugin factory (try built-in first, then external)
 		factory, ok := availablePlugins[pluginName]
 		var p plugin.ServicePlugin
@@ -248,7 +298,7 @@ func main() {
 			// Try to get external plugin from mfs
 			p = mfs.CreatePlugin(pluginName)
 			if p == nil {
-				log.Warnf("Unknown plugin: %s, skipping instance '%s'", pluginName, instanceName)
+				mounts.record(pluginName, instanceName, mountPath, fmt.Errorf("unknown plugin %s", pluginName))
 				return
 			}
 		} else {
@@ -263,43 +313,29 @@ func main() {
 			}
 		}
 
+		// Special handling for routerfs: inject the plugin factories
+		if pluginName == "routerfs" {
+			if routerPlugin, ok := p.(*routerfs.RouterFSPlugin); ok {
+				routerPlugin.SetPluginFactory(mfs.CreatePlugin)
+			}
+		}
+
 		// Special handling for serverinfofs: inject traffic monitor
 		if pluginName == "serverinfofs" {
 			if serverInfoPlugin, ok := p.(*serverinfofs.ServerInfoFSPlugin); ok {
 				serverInfoPlugin.SetTrafficMonitor(trafficMonitor)
+				serverInfoPlugin.SetUsageProvider(mfs)
 			}
 		}
 
-		// Mount asynchronously
-		go func() {
-			// Inject mount_path into config
-			configWithPath := make(map[string]interface{})
-			for k, v := range pluginConfig {
-				configWithPath[k] = v
-			}
-			configWithPath["mount_path"] = mountPath
-
-			// Validate plugin configuration
-			if err := p.Validate(configWithPath); err != nil {
-				log.Errorf("Failed to validate %s instance '%s': %v", pluginName, instanceName, err)
-				return
-			}
-
-			// Initialize plugin
-			if err := p.Initialize(configWithPath); err != nil {
-				log.Errorf("Failed to initialize %s instance '%s': %v", pluginName, instanceName, err)
-				return
-			}
-
-			// Mount plugin
-			if err := mfs.Mount(mountPath, p); err != nil {
-				log.Errorf("Failed to mount %s instance '%s' at %s: %v", pluginName, instanceName, mountPath, err)
-				return
-			}
-
-			// Log success
-			log.Infof("%s instance '%s' mounted at %s", pluginName, instanceName, mountPath)
-		}()
+		mounts.begin(pluginName, instanceName, mountPath)
+		planned = append(planned, mountablefs.PlannedMount{
+			Path:      mountPath,
+			Plugin:    p,
+			Config:    pluginConfig,
+			Options:   opts,
+			DependsOn: dependsOn,
+		})
 	}
 
 	// Load external plugins if enabled
@@ -362,6 +398,13 @@ func main() {
 					Enabled: pluginCfg.Enabled,
 					Path:    pluginCfg.Path,
 					Config:  pluginCfg.Config,
+
+					DefaultFileMode: pluginCfg.DefaultFileMode,
+					DefaultDirMode:  pluginCfg.DefaultDirMode,
+					Trash:           pluginCfg.Trash,
+					TrashRetention:  pluginCfg.TrashRetention,
+					WriteConflicts:  pluginCfg.WriteConflicts,
+					DependsOn:       pluginCfg.DependsOn,
 				},
 			}
 		}
@@ -373,22 +416,76 @@ func main() {
 				continue
 			}
 
-			mountPlugin(pluginName, instance.Name, instance.Path, instance.Config)
+			opts, err := mountablefs.ParseMountOptions(instance.DefaultFileMode, instance.DefaultDirMode)
+			if err == nil {
+				opts.Trash = instance.Trash
+				opts.TrashRetention, err = mountablefs.ParseTrashRetention(instance.TrashRetention)
+			}
+			if err == nil {
+				opts.WriteConflicts, err = mountablefs.ParseWriteConflictPolicy(instance.WriteConflicts)
+			}
+			if err != nil {
+				mounts.record(pluginName, instance.Name, instance.Path, fmt.Errorf("invalid mount options: %w", err))
+				continue
+			}
+			mountPlugin(pluginName, instance.Name, instance.Path, instance.Config, opts, instance.DependsOn)
+		}
+	}
+
+	// Mounts are set up concurrently, each after the mounts it depends on
+	go mfs.MountAll(planned, func(m mountablefs.PlannedMount, err error) {
+		mounts.finish(m.Path, err)
+	})
+
+	// With strict_mounts the server only starts with every mount up;
+	// otherwise it serves the mounts that came up
+	if cfg.Server.StrictMounts {
+		if failed := mounts.wait(); failed > 0 {
+			log.Fatalf("%d configured mount(s) failed and strict_mounts is set", failed)
 		}
+	} else {
+		go mounts.wait()
 	}
 
 	// Create handlers
 	handler := handlers.NewHandler(mfs, trafficMonitor)
 	handler.SetVersionInfo(Version, GitCommit, BuildTime)
+	handler.AddReadinessCheck(mounts.health)
 	pluginHandler := handlers.NewPluginHandler(mfs)
 
 	// Setup routes
 	mux := http.NewServeMux()
 	handler.SetupRoutes(mux)
 	pluginHandler.SetupRoutes(mux)
+	if prefix := cfg.Server.GatewayPrefix; prefix != "" {
+		prefix = "/" + strings.Trim(prefix, "/")
+		mux.Handle(prefix+"/", http.StripPrefix(prefix, httpgw.New(mfs)))
+		log.Infof("Serving files over HTTP under %s/", prefix)
+	}
+	if prefix := cfg.Server.WebDAVPrefix; prefix != "" {
+		prefix = "/" + strings.Trim(prefix, "/")
+		mux.Handle(prefix+"/", webdav.NewHandler(mfs, prefix))
+		log.Infof("Serving files over WebDAV under %s/", prefix)
+	}
+
+	// Wrap with authentication and logging middleware
+	if len(cfg.Server.AuthTokens) > 0 {
+		log.Infof("Authenticating requests with %d bearer token(s)", len(cfg.Server.AuthTokens))
+	}
+	loggedMux := handlers.LoggingMiddleware(handlers.TimeoutMiddleware(handlers.AuthMiddleware(cfg.Server.AuthTokens, mux)))
+	if tracer != nil {
+		loggedMux = handlers.TracingMiddleware(tracer, loggedMux)
+	}
+	if addr := cfg.Server.S3Address; addr != "" {
+		s3Handler := handlers.LoggingMiddleware(s3gw.New(mfs))
+		go func() {
+			log.Infof("Serving the S3 read API on %s", addr)
+			if err := http.ListenAndServe(addr, s3Handler); err != nil {
+				log.Fatalf("S3 gateway failed: %v", err)
+			}
+		}()
+	}
 
-	// Wrap with logging middleware
-	loggedMux := handlers.LoggingMiddleware(mux)
 	// Start server
 	log.Infof("Starting AGFS server on %s", serverAddr)
 
@@ -396,3 +493,12 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// toRateLimit converts a rate limit from the config file to a pool rate limit
+func toRateLimit(cfg config.RateLimitConfig) api.RateLimit {
+	return api.RateLimit{
+		OpsPerSec: cfg.OpsPerSec,
+		Burst:     cfg.Burst,
+		MaxWait:   time.Duration(cfg.MaxWaitMs) * time.Millisecond,
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	log "github.com/sirupsen/logrus"
+)
+
+// mountResult is the outcome of setting up a configured mount
+type mountResult struct {
+	plugin   string
+	instance string
+	path     string
+	err      error // nil = mounted
+}
+
+// startupMounts collects the outcome of the configured mounts, which are set
+// up concurrently, to report which came up and which didn't
+type startupMounts struct {
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	results  []mountResult
+	mounting map[string]mountResult // Mounts in progress by path
+}
+
+// begin records a mount set up in the background, until finish is called
+// for it
+func (s *startupMounts) begin(pluginName, instanceName, mountPath string) {
+	s.wg.Add(1)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.mounting == nil {
+		s.mounting = make(map[string]mountResult)
+	}
+	s.mounting[mountPath] = mountResult{plugin: pluginName, instance: instanceName, path: mountPath}
+}
+
+// finish records the outcome of a mount begun in the background
+func (s *startupMounts) finish(mountPath string, err error) {
+	s.mu.Lock()
+	r := s.mounting[mountPath]
+	s.mu.Unlock()
+	s.record(r.plugin, r.instance, mountPath, err)
+	s.wg.Done()
+}
+
+// record records the outcome of a mount, logging a failure
+func (s *startupMounts) record(pluginName, instanceName, mountPath string, err error) {
+	if err != nil {
+		log.Errorf("Mount of %s instance '%s' at %s failed: %v", pluginName, instanceName, mountPath, err)
+	} else {
+		log.Infof("%s instance '%s' mounted at %s", pluginName, instanceName, mountPath)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.mounting, mountPath)
+	s.results = append(s.results, mountResult{plugin: pluginName, instance: instanceName, path: mountPath, err: err})
+}
+
+// health reports the configured mounts still in progress and those that
+// failed as not ready, for /readyz; the mounts that came up report their
+// own health
+func (s *startupMounts) health() []mountablefs.MountHealth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var health []mountablefs.MountHealth
+	for _, r := range s.mounting {
+		health = append(health, mountablefs.MountHealth{Path: r.path, Plugin: r.plugin, Error: "mounting"})
+	}
+	for _, r := range s.results {
+		if r.err != nil {
+			health = append(health, mountablefs.MountHealth{Path: r.path, Plugin: r.plugin, Error: r.err.Error()})
+		}
+	}
+	sort.Slice(health, func(i, j int) bool { return health[i].Path < health[j].Path })
+	return health
+}
+
+// wait waits for the mounts in progress, then logs which mounts are up and
+// which aren't, and returns the number that failed
+func (s *startupMounts) wait() int {
+	s.wg.Wait()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sort.Slice(s.results, func(i, j int) bool { return s.results[i].path < s.results[j].path })
+	var up, down []string
+	for _, r := range s.results {
+		if r.err == nil {
+			up = append(up, r.path)
+		} else {
+			down = append(down, fmt.Sprintf("%s (%s instance '%s')", r.path, r.plugin, r.instance))
+		}
+	}
+	if len(down) == 0 {
+		log.Infof("All %d configured mounts are up", len(up))
+		return 0
+	}
+	log.Warnf("Started with %d of %d configured mounts; up: [%s], failed: [%s]",
+		len(up), len(s.results), strings.Join(up, ", "), strings.Join(down, ", "))
+	return len(down)
+}
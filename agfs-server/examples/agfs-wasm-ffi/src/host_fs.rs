@@ -6,6 +6,11 @@
 use crate::types::{Error, FileInfo, Result};
 use std::ffi::CString;
 
+/// Version of the host function ABI this crate is built against. Plugins
+/// declare it with their `plugin_abi_version` export, so hosts older than
+/// it refuse to load them with a clear error.
+pub const ABI_VERSION: u32 = 2;
+
 // Import host functions from the "env" module
 #[link(wasm_import_module = "env")]
 extern "C" {
@@ -19,6 +24,15 @@ extern "C" {
     fn host_fs_remove_all(path: *const u8) -> u32;
     fn host_fs_rename(old_path: *const u8, new_path: *const u8) -> u32;
     fn host_fs_chmod(path: *const u8, mode: u32) -> u32;
+    // ABI version 2
+    fn host_abi_version() -> u32;
+    fn host_fs_supports(name: *const u8) -> u32;
+    fn host_fs_write_at(path: *const u8, data: *const u8, len: u32, offset: i64, flags: u32) -> u64;
+    fn host_fs_truncate(path: *const u8, size: i64) -> u32;
+    fn host_fs_touch(path: *const u8) -> u32;
+    fn host_fs_mkdir_all(path: *const u8, perm: u32) -> u32;
+    fn host_fs_symlink(target: *const u8, link: *const u8) -> u32;
+    fn host_fs_readlink(path: *const u8) -> u64;
 }
 
 /// HostFS provides access to the host filesystem from WASM
@@ -214,6 +228,107 @@ impl HostFS {
     }
 }
 
+/// Host functions added in ABI version 2. Only the functions a plugin calls
+/// are imported, so plugins that don't use them still load on older hosts.
+/// Check `HostFS::supports` before relying on one: operations the host
+/// filesystem doesn't implement fail with a "not supported" error.
+impl HostFS {
+    /// Version of the host function ABI
+    pub fn abi_version() -> u32 {
+        unsafe { host_abi_version() }
+    }
+
+    /// Whether the host function `name` (e.g. "host_fs_truncate") exists and
+    /// is backed by the host filesystem
+    pub fn supports(name: &str) -> bool {
+        let name_c = match CString::new(name) {
+            Ok(name_c) => name_c,
+            Err(_) => return false,
+        };
+        unsafe { host_fs_supports(name_c.as_ptr() as *const u8) == 1 }
+    }
+
+    /// Write data at offset with the given write flags, returning the bytes written
+    pub fn write_at(path: &str, data: &[u8], offset: i64, flags: u32) -> Result<u32> {
+        let path_c = CString::new(path).map_err(|_| Error::InvalidInput("invalid path".to_string()))?;
+
+        unsafe {
+            let result = host_fs_write_at(
+                path_c.as_ptr() as *const u8,
+                data.as_ptr(),
+                data.len() as u32,
+                offset,
+                flags,
+            );
+
+            // Unpack: lower 32 bits = bytes written, upper 32 bits = error pointer
+            let written = (result & 0xFFFFFFFF) as u32;
+            let err_ptr = ((result >> 32) & 0xFFFFFFFF) as u32;
+            if err_ptr != 0 {
+                return Err(Error::Other(read_string_from_ptr(err_ptr)));
+            }
+            Ok(written)
+        }
+    }
+
+    /// Change the size of a file
+    pub fn truncate(path: &str, size: i64) -> Result<()> {
+        let path_c = CString::new(path).map_err(|_| Error::InvalidInput("invalid path".to_string()))?;
+        unsafe { check_err(host_fs_truncate(path_c.as_ptr() as *const u8, size)) }
+    }
+
+    /// Update the modification time of a file, creating it if needed
+    pub fn touch(path: &str) -> Result<()> {
+        let path_c = CString::new(path).map_err(|_| Error::InvalidInput("invalid path".to_string()))?;
+        unsafe { check_err(host_fs_touch(path_c.as_ptr() as *const u8)) }
+    }
+
+    /// Create a directory and any missing parents
+    pub fn mkdir_all(path: &str, perm: u32) -> Result<()> {
+        let path_c = CString::new(path).map_err(|_| Error::InvalidInput("invalid path".to_string()))?;
+        unsafe { check_err(host_fs_mkdir_all(path_c.as_ptr() as *const u8, perm)) }
+    }
+
+    /// Create a symbolic link at link pointing to target
+    pub fn symlink(target: &str, link: &str) -> Result<()> {
+        let target_c = CString::new(target).map_err(|_| Error::InvalidInput("invalid path".to_string()))?;
+        let link_c = CString::new(link).map_err(|_| Error::InvalidInput("invalid path".to_string()))?;
+        unsafe {
+            check_err(host_fs_symlink(
+                target_c.as_ptr() as *const u8,
+                link_c.as_ptr() as *const u8,
+            ))
+        }
+    }
+
+    /// Read the target of a symbolic link
+    pub fn readlink(path: &str) -> Result<String> {
+        let path_c = CString::new(path).map_err(|_| Error::InvalidInput("invalid path".to_string()))?;
+
+        unsafe {
+            let result = host_fs_readlink(path_c.as_ptr() as *const u8);
+
+            // Unpack: lower 32 bits = target pointer, upper 32 bits = error pointer
+            let target_ptr = (result & 0xFFFFFFFF) as u32;
+            let err_ptr = ((result >> 32) & 0xFFFFFFFF) as u32;
+            if err_ptr != 0 {
+                return Err(Error::Other(read_string_from_ptr(err_ptr)));
+            }
+            Ok(read_string_from_ptr(target_ptr))
+        }
+    }
+}
+
+/// Convert an i32 host result (0 or an error message pointer) to a Result.
+/// A result of 1 means the host couldn't write the message.
+unsafe fn check_err(err_ptr: u32) -> Result<()> {
+    match err_ptr {
+        0 => Ok(()),
+        1 => Err(Error::Other("host call failed".to_string())),
+        _ => Err(Error::Other(read_string_from_ptr(err_ptr))),
+    }
+}
+
 /// Read a null-terminated string from a pointer
 unsafe fn read_string_from_ptr(ptr: u32) -> String {
     if ptr == 0 {
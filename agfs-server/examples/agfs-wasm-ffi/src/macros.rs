@@ -20,6 +20,12 @@ macro_rules! export_plugin {
             1
         }
 
+        /// Host ABI version this plugin was built against
+        #[no_mangle]
+        pub extern "C" fn plugin_abi_version() -> u32 {
+            $crate::host_fs::ABI_VERSION
+        }
+
         #[no_mangle]
         pub extern "C" fn plugin_name() -> *mut u8 {
             use $crate::memory::CString;
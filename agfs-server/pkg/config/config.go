@@ -16,8 +16,27 @@ type Config struct {
 
 // ServerConfig contains server-level configuration
 type ServerConfig struct {
-	Address  string `yaml:"address"`
-	LogLevel string `yaml:"log_level"`
+	Address   string `yaml:"address"`
+	LogLevel  string `yaml:"log_level"`
+	LogFormat string `yaml:"log_format"` // text (default) or json
+	TraceFile string `yaml:"trace_file"` // Write OpenTelemetry spans to this file (empty = tracing disabled)
+	// Serve files over plain HTTP under this URL prefix (empty = disabled)
+	GatewayPrefix string `yaml:"gateway_prefix"`
+	// Serve files over WebDAV under this URL prefix (empty = disabled)
+	WebDAVPrefix string `yaml:"webdav_prefix"`
+	// Serve the S3 read API on this address (empty = disabled)
+	S3Address string `yaml:"s3_address"`
+	// Seconds the usage counted by walking a mount is cached (0 = 60)
+	UsageRefreshInterval int `yaml:"usage_refresh_interval"`
+	// Seconds between sweeps for symlinks left behind by gone mounts (0 = 600)
+	SymlinkCompactInterval int `yaml:"symlink_compact_interval"`
+	// Exit at startup if a configured mount fails, instead of serving the
+	// mounts that came up
+	StrictMounts bool `yaml:"strict_mounts"`
+	// Bearer tokens accepted, each mapped to the principal whose mount
+	// namespace it is served in ("" = the shared namespace). Empty = no
+	// authentication.
+	AuthTokens map[string]string `yaml:"auth_tokens"`
 }
 
 // ExternalPluginsConfig contains configuration for external plugins
@@ -33,10 +52,28 @@ type ExternalPluginsConfig struct {
 // WASMPluginConfig contains configuration for WASM plugins
 type WASMPluginConfig struct {
 	InstancePoolSize     int `yaml:"instance_pool_size"`      // Maximum concurrent instances per plugin (default: 10)
+	InstanceBurstMax     int `yaml:"instance_burst_max"`      // Instances allowed in all during bursts, destroyed once idle (0 = no bursts)
 	InstanceMaxLifetime  int `yaml:"instance_max_lifetime"`   // Maximum instance lifetime in seconds (0 = unlimited)
 	InstanceMaxRequests  int `yaml:"instance_max_requests"`   // Maximum requests per instance (0 = unlimited)
 	HealthCheckInterval  int `yaml:"health_check_interval"`   // Health check interval in seconds (0 = disabled)
 	EnablePoolStatistics bool `yaml:"enable_pool_statistics"` // Enable pool statistics collection
+
+	RateLimit        RateLimitConfig            `yaml:"rate_limit"`         // Default rate limit for every WASM plugin
+	PluginRateLimits map[string]RateLimitConfig `yaml:"plugin_rate_limits"` // Per-plugin rate limits, keyed by plugin name
+
+	AffinityInstances   int `yaml:"affinity_instances"`    // Idle instances held for the path they last served (0 = disabled)
+	AffinityIdleTimeout int `yaml:"affinity_idle_timeout"` // Seconds an instance stays held before rejoining the pool (default: 30)
+
+	UnhealthyAfter   int `yaml:"unhealthy_after"`   // Consecutive instantiation failures before a plugin is marked unhealthy (default: 5)
+	RecoveryInterval int `yaml:"recovery_interval"` // Seconds between recovery attempts of an unhealthy plugin (default: 30)
+	UnreadyAfter     int `yaml:"unready_after"`     // Seconds a plugin may have every instance busy before /readyz fails (default: 30)
+}
+
+// RateLimitConfig limits how fast calls are made into a plugin
+type RateLimitConfig struct {
+	OpsPerSec float64 `yaml:"ops_per_sec"` // Sustained calls per second (0 = unlimited)
+	Burst     int     `yaml:"burst"`       // Calls admitted at once (default: ops_per_sec rounded up)
+	MaxWaitMs int     `yaml:"max_wait_ms"` // How long a call may queue before it fails with 429 (0 = fail immediately)
 }
 
 // PluginConfig can be either a single plugin or an array of plugin instances
@@ -46,6 +83,24 @@ type PluginConfig struct {
 	Path    string `yaml:"path"`
 	Config  map[string]interface{} `yaml:"config"`
 
+	// Octal modes reported for files and directories the plugin reports
+	// none for, e.g. "0640" (empty = 0644 and 0755)
+	DefaultFileMode string `yaml:"default_file_mode"`
+	DefaultDirMode  string `yaml:"default_dir_mode"`
+
+	// Move removed files to the mount's .agfs-trash, keeping them for
+	// TrashRetention, e.g. "72h" (empty = 7 days)
+	Trash          bool   `yaml:"trash"`
+	TrashRetention string `yaml:"trash_retention"`
+
+	// What happens to a write overlapping a write to the same file in
+	// progress: "last-writer-wins" (default) or "error"
+	WriteConflicts string `yaml:"write_conflicts"`
+
+	// Mount paths of the plugins this one is initialized after, and whose
+	// file systems it is given if it uses them, e.g. an overlay's layers
+	DependsOn []string `yaml:"depends_on"`
+
 	// For multi-instance plugins (array format)
 	Instances []PluginInstance `yaml:"-"`
 }
@@ -56,6 +111,13 @@ type PluginInstance struct {
 	Enabled bool                   `yaml:"enabled"`
 	Path    string                 `yaml:"path"`
 	Config  map[string]interface{} `yaml:"config"`
+
+	DefaultFileMode string   `yaml:"default_file_mode"`
+	DefaultDirMode  string   `yaml:"default_dir_mode"`
+	Trash           bool     `yaml:"trash"`
+	TrashRetention  string   `yaml:"trash_retention"`
+	WriteConflicts  string   `yaml:"write_conflicts"`
+	DependsOn       []string `yaml:"depends_on"`
 }
 
 // UnmarshalYAML implements custom unmarshaling to support both single plugin and array formats
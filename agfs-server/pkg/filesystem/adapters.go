@@ -1,8 +1,10 @@
 package filesystem
 
 import (
+	"context"
 	"fmt"
 	"io"
+	"path"
 )
 
 // BaseFileSystem provides default implementations for optional interfaces
@@ -10,6 +12,10 @@ import (
 // that simulate advanced features using basic operations
 type BaseFileSystem struct {
 	FS FileSystem
+
+	// RemoveAllWorkers is how many removals RemoveAll runs at once
+	// (0 = DefaultRemoveAllWorkers)
+	RemoveAllWorkers int
 }
 
 // NewBaseFileSystem creates a new BaseFileSystem wrapping the given FileSystem
@@ -129,6 +135,60 @@ func (b *BaseFileSystem) Touch(path string) error {
 	return err
 }
 
+// MkdirAll provides a default implementation of MkdirAller using Stat + Mkdir
+func (b *BaseFileSystem) MkdirAll(path string, perm uint32) error {
+	return mkdirAll(b.FS, NormalizePath(path), perm)
+}
+
+// MkdirAll creates path and any missing parents on fs, using fs's own
+// MkdirAll if it implements MkdirAller
+func MkdirAll(fs FileSystem, path string, perm uint32) error {
+	if m, ok := fs.(MkdirAller); ok {
+		return m.MkdirAll(path, perm)
+	}
+	return mkdirAll(fs, NormalizePath(path), perm)
+}
+
+// WithContext returns fs bound to ctx if it implements ContextBinder, and fs
+// unchanged otherwise
+func WithContext(fs FileSystem, ctx context.Context) FileSystem {
+	if b, ok := fs.(ContextBinder); ok {
+		return b.WithContext(ctx)
+	}
+	return fs
+}
+
+// mkdirAll creates the normalized dir and its missing parents one level at a time
+func mkdirAll(fs FileSystem, dir string, perm uint32) error {
+	if info, err := fs.Stat(dir); err == nil {
+		if info.IsDir {
+			return nil
+		}
+		return NewNotDirectoryError(dir)
+	}
+
+	if parent := path.Dir(dir); parent != dir {
+		if err := mkdirAll(fs, parent, perm); err != nil {
+			return err
+		}
+	}
+
+	if err := fs.Mkdir(dir, perm); err != nil {
+		// Someone else may have created it meanwhile
+		if info, statErr := fs.Stat(dir); statErr == nil && info.IsDir {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// RemoveAll provides a default recursive implementation using
+// RemoveAllParallel, with RemoveAllWorkers workers
+func (b *BaseFileSystem) RemoveAll(path string) error {
+	return RemoveAllParallel(b.FS, path, b.RemoveAllWorkers)
+}
+
 // Sync provides a no-op default implementation
 // Most in-memory or network file systems don't need explicit sync
 func (b *BaseFileSystem) Sync(path string) error {
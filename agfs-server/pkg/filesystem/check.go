@@ -0,0 +1,272 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"sync"
+)
+
+// CheckIssueKind classifies an inconsistency found by Check
+type CheckIssueKind string
+
+const (
+	// CheckDanglingSymlink is a symlink whose target doesn't resolve
+	CheckDanglingSymlink CheckIssueKind = "dangling-symlink"
+
+	// CheckStatMismatch is an entry where Stat disagrees with ReadDir
+	CheckStatMismatch CheckIssueKind = "stat-mismatch"
+
+	// CheckSizeMismatch is a file whose Stat size differs from the bytes readable
+	CheckSizeMismatch CheckIssueKind = "size-mismatch"
+
+	// CheckInvalidMeta is an entry whose Stat metadata misuses a reserved
+	// key, as MetaData.Validate reports
+	CheckInvalidMeta CheckIssueKind = "invalid-meta"
+
+	// CheckError is an operation that failed while walking the tree
+	CheckError CheckIssueKind = "error"
+)
+
+// CheckIssue describes a single inconsistency
+type CheckIssue struct {
+	Path   string
+	Kind   CheckIssueKind
+	Detail string
+}
+
+func (i CheckIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", i.Kind, i.Path, i.Detail)
+}
+
+// CheckOptions configures Check
+type CheckOptions struct {
+	// Context cancels the walk (default context.Background())
+	Context context.Context
+
+	// Concurrency is the number of directories checked in parallel (default 4)
+	Concurrency int
+
+	// VerifySizes reads every regular file to compare its Stat size with the
+	// bytes actually readable. Files without read permission, and paths a
+	// CapabilityProvider reports as read-destructive or streaming, are skipped.
+	// Reads are not side-effect free on every plugin (e.g. queuefs dequeue),
+	// so this is off by default.
+	VerifySizes bool
+}
+
+// CheckReport is the result of Check
+type CheckReport struct {
+	Dirs     int
+	Files    int
+	Symlinks int
+	Issues   []CheckIssue // Sorted by path
+}
+
+// OK reports whether no issues were found
+func (r *CheckReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+// Check walks the tree under root and reports inconsistencies in the file
+// system's view of it: symlinks whose targets don't resolve, entries where
+// Stat and ReadDir disagree or whose metadata misuses a reserved key, and (with VerifySizes) files whose reported size
+// differs from what can be read. Symlinks are not followed.
+//
+// The returned error is non-nil only if root itself can't be checked or the
+// context is cancelled; in the latter case the partial report is returned too.
+func Check(fs FileSystem, root string, opts CheckOptions) (*CheckReport, error) {
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+
+	root = NormalizePath(root)
+	info, err := fs.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("stat %s: %w", root, err)
+	}
+
+	c := &checker{
+		fs:     fs,
+		opts:   opts,
+		sem:    make(chan struct{}, opts.Concurrency),
+		report: &CheckReport{},
+	}
+
+	if info.IsDir {
+		c.report.Dirs++
+		c.wg.Add(1)
+		go c.checkDir(root)
+		c.wg.Wait()
+	} else {
+		c.checkFile(root, info)
+	}
+
+	sort.Slice(c.report.Issues, func(i, j int) bool {
+		if c.report.Issues[i].Path != c.report.Issues[j].Path {
+			return c.report.Issues[i].Path < c.report.Issues[j].Path
+		}
+		return c.report.Issues[i].Kind < c.report.Issues[j].Kind
+	})
+
+	if err := opts.Context.Err(); err != nil {
+		return c.report, err
+	}
+	return c.report, nil
+}
+
+// checker holds the state of a single Check walk
+type checker struct {
+	fs   FileSystem
+	opts CheckOptions
+	sem  chan struct{}
+	wg   sync.WaitGroup
+
+	mu     sync.Mutex
+	report *CheckReport
+}
+
+func (c *checker) addIssue(p string, kind CheckIssueKind, format string, args ...interface{}) {
+	c.mu.Lock()
+	c.report.Issues = append(c.report.Issues, CheckIssue{Path: p, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	c.mu.Unlock()
+}
+
+func (c *checker) count(counter *int) {
+	c.mu.Lock()
+	*counter++
+	c.mu.Unlock()
+}
+
+// checkDir checks the entries of dir and schedules its subdirectories
+func (c *checker) checkDir(dir string) {
+	defer c.wg.Done()
+
+	select {
+	case c.sem <- struct{}{}:
+	case <-c.opts.Context.Done():
+		return
+	}
+
+	var subdirs []string
+	entries, err := c.fs.ReadDir(dir)
+	if err != nil {
+		c.addIssue(dir, CheckError, "readdir failed: %v", err)
+	}
+	for _, entry := range entries {
+		if c.opts.Context.Err() != nil {
+			break
+		}
+		if sub, ok := c.checkEntry(dir, entry); ok {
+			subdirs = append(subdirs, sub)
+		}
+	}
+
+	<-c.sem
+
+	for _, sub := range subdirs {
+		c.wg.Add(1)
+		go c.checkDir(sub)
+	}
+}
+
+// checkEntry compares a ReadDir entry with Stat and returns the child path
+// if it is a directory that should be descended into
+func (c *checker) checkEntry(dir string, entry FileInfo) (string, bool) {
+	p := path.Join(dir, entry.Name)
+
+	if entry.Meta.Type == "symlink" {
+		c.count(&c.report.Symlinks)
+		c.checkSymlink(p)
+		return "", false
+	}
+
+	info, err := c.fs.Stat(p)
+	if err != nil {
+		c.addIssue(p, CheckStatMismatch, "listed by readdir but stat failed: %v", err)
+		return "", false
+	}
+
+	if err := info.Meta.Validate(); err != nil {
+		c.addIssue(p, CheckInvalidMeta, "%v", err)
+	}
+	if info.IsDir != entry.IsDir {
+		c.addIssue(p, CheckStatMismatch, "readdir reports isDir=%v, stat reports isDir=%v", entry.IsDir, info.IsDir)
+	}
+	if info.IsDir {
+		c.count(&c.report.Dirs)
+		return p, true
+	}
+
+	if entry.Size != info.Size {
+		c.addIssue(p, CheckStatMismatch, "readdir reports size %d, stat reports size %d", entry.Size, info.Size)
+	}
+	c.checkFile(p, info)
+	return "", false
+}
+
+// checkSymlink reports the symlink at p if its target doesn't resolve
+func (c *checker) checkSymlink(p string) {
+	symlinker, ok := c.fs.(Symlinker)
+	if !ok {
+		// Without Readlink, Stat following the link is all we can check
+		if _, err := c.fs.Stat(p); err != nil {
+			c.addIssue(p, CheckDanglingSymlink, "stat failed: %v", err)
+		}
+		return
+	}
+
+	target, err := symlinker.Readlink(p)
+	if err != nil {
+		c.addIssue(p, CheckError, "readlink failed: %v", err)
+		return
+	}
+
+	resolved := target
+	if !path.IsAbs(resolved) {
+		resolved = path.Join(path.Dir(p), resolved)
+	}
+	if _, err := c.fs.Stat(resolved); err != nil {
+		c.addIssue(p, CheckDanglingSymlink, "target %s: %v", target, err)
+	}
+}
+
+// checkFile counts a regular file and optionally verifies its size
+func (c *checker) checkFile(p string, info *FileInfo) {
+	c.count(&c.report.Files)
+
+	if !c.opts.VerifySizes || info.Mode&0444 == 0 {
+		return
+	}
+	if provider, ok := c.fs.(CapabilityProvider); ok {
+		caps := provider.GetPathCapabilities(p)
+		if caps.IsReadDestructive || caps.IsBroadcast || caps.SupportsStreamRead {
+			return
+		}
+	}
+
+	r, err := c.fs.Open(p)
+	if err != nil {
+		if errors.Is(err, ErrNotSupported) {
+			return
+		}
+		c.addIssue(p, CheckError, "open failed: %v", err)
+		return
+	}
+	defer r.Close()
+
+	n, err := io.Copy(io.Discard, r)
+	if err != nil {
+		c.addIssue(p, CheckError, "read failed after %d bytes: %v", n, err)
+		return
+	}
+	if n != info.Size {
+		c.addIssue(p, CheckSizeMismatch, "stat reports size %d, read returned %d bytes", info.Size, n)
+	}
+}
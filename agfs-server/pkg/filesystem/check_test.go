@@ -0,0 +1,183 @@
+package filesystem
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+)
+
+// checkTestFS is a fixed tree whose ReadDir and Stat views can disagree
+type checkTestFS struct {
+	dirs    map[string][]FileInfo // ReadDir results
+	stats   map[string]FileInfo   // Stat results
+	content map[string]string     // Readable file content
+	links   map[string]string     // Symlink targets
+}
+
+func (f *checkTestFS) Create(path string) error                  { return ErrNotSupported }
+func (f *checkTestFS) Mkdir(path string, perm uint32) error      { return ErrNotSupported }
+func (f *checkTestFS) Remove(path string) error                  { return ErrNotSupported }
+func (f *checkTestFS) RemoveAll(path string) error               { return ErrNotSupported }
+func (f *checkTestFS) Rename(oldPath, newPath string) error      { return ErrNotSupported }
+func (f *checkTestFS) Chmod(path string, mode uint32) error      { return ErrNotSupported }
+func (f *checkTestFS) Symlink(targetPath, linkPath string) error { return ErrNotSupported }
+
+func (f *checkTestFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+func (f *checkTestFS) Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (f *checkTestFS) ReadDir(path string) ([]FileInfo, error) {
+	entries, ok := f.dirs[path]
+	if !ok {
+		return nil, NewNotFoundError("readdir", path)
+	}
+	return entries, nil
+}
+
+func (f *checkTestFS) Stat(path string) (*FileInfo, error) {
+	info, ok := f.stats[path]
+	if !ok {
+		return nil, NewNotFoundError("stat", path)
+	}
+	return &info, nil
+}
+
+func (f *checkTestFS) Open(path string) (io.ReadCloser, error) {
+	data, ok := f.content[path]
+	if !ok {
+		return nil, NewNotFoundError("open", path)
+	}
+	return io.NopCloser(strings.NewReader(data)), nil
+}
+
+func (f *checkTestFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, ErrNotSupported
+}
+
+func (f *checkTestFS) Readlink(linkPath string) (string, error) {
+	target, ok := f.links[linkPath]
+	if !ok {
+		return "", NewNotFoundError("readlink", linkPath)
+	}
+	return target, nil
+}
+
+func newCheckTestFS() *checkTestFS {
+	return &checkTestFS{
+		dirs: map[string][]FileInfo{
+			"/": {
+				{Name: "ok.txt", Size: 5, Mode: 0644},
+				{Name: "short.txt", Size: 10, Mode: 0644},
+				{Name: "sub", IsDir: true, Mode: 0755},
+				{Name: "good-link", Meta: MetaData{Type: "symlink"}},
+				{Name: "bad-link", Meta: MetaData{Type: "symlink"}},
+			},
+			"/sub": {
+				{Name: "ghost.txt", Size: 1, Mode: 0644},
+				{Name: "confused", IsDir: false, Mode: 0644},
+				{Name: "wronly", Size: 3, Mode: 0222},
+			},
+			"/sub/confused": {},
+		},
+		stats: map[string]FileInfo{
+			"/":             {Name: "/", IsDir: true, Mode: 0755},
+			"/ok.txt":       {Name: "ok.txt", Size: 5, Mode: 0644},
+			"/short.txt":    {Name: "short.txt", Size: 10, Mode: 0644},
+			"/sub":          {Name: "sub", IsDir: true, Mode: 0755},
+			"/sub/confused": {Name: "confused", IsDir: true, Mode: 0755},
+			"/sub/wronly":   {Name: "wronly", Size: 3, Mode: 0222},
+		},
+		content: map[string]string{
+			"/ok.txt":    "hello",
+			"/short.txt": "hello",
+		},
+		links: map[string]string{
+			"/good-link": "sub",
+			"/bad-link":  "/missing",
+		},
+	}
+}
+
+func TestCheck(t *testing.T) {
+	report, err := Check(newCheckTestFS(), "/", CheckOptions{VerifySizes: true, Concurrency: 2})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+
+	if report.Dirs != 3 || report.Files != 3 || report.Symlinks != 2 {
+		t.Errorf("Expected 3 dirs, 3 files, 2 symlinks, got %d, %d, %d", report.Dirs, report.Files, report.Symlinks)
+	}
+
+	expected := []CheckIssue{
+		{Path: "/bad-link", Kind: CheckDanglingSymlink},
+		{Path: "/short.txt", Kind: CheckSizeMismatch},
+		{Path: "/sub/confused", Kind: CheckStatMismatch},
+		{Path: "/sub/ghost.txt", Kind: CheckStatMismatch},
+	}
+	if len(report.Issues) != len(expected) {
+		t.Fatalf("Expected %d issues, got %d: %v", len(expected), len(report.Issues), report.Issues)
+	}
+	for i, want := range expected {
+		got := report.Issues[i]
+		if got.Path != want.Path || got.Kind != want.Kind {
+			t.Errorf("Issue %d: expected %s %s, got %s", i, want.Kind, want.Path, got)
+		}
+	}
+}
+
+func TestCheckInvalidMeta(t *testing.T) {
+	fs := newCheckTestFS()
+	info := fs.stats["/ok.txt"]
+	info.Meta.SetAccessSemantics("sometimes")
+	fs.stats["/ok.txt"] = info
+
+	report, err := Check(fs, "/", CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	found := false
+	for _, issue := range report.Issues {
+		if issue.Path == "/ok.txt" {
+			found = issue.Kind == CheckInvalidMeta
+		}
+	}
+	if !found {
+		t.Errorf("Expected the access hint of /ok.txt reported, got %v", report.Issues)
+	}
+}
+
+func TestCheckWithoutSizes(t *testing.T) {
+	report, err := Check(newCheckTestFS(), "/", CheckOptions{})
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	for _, issue := range report.Issues {
+		if issue.Kind == CheckSizeMismatch {
+			t.Errorf("Unexpected size check without VerifySizes: %s", issue)
+		}
+	}
+}
+
+func TestCheckCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	report, err := Check(newCheckTestFS(), "/", CheckOptions{Context: ctx})
+	if err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if report == nil {
+		t.Fatal("Expected a partial report")
+	}
+}
+
+func TestCheckMissingRoot(t *testing.T) {
+	if _, err := Check(newCheckTestFS(), "/nope", CheckOptions{}); err == nil {
+		t.Error("Expected error for missing root")
+	}
+}
@@ -0,0 +1,120 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Cloner is implemented by file systems that can clone a file copy-on-write,
+// such as with reflinks, so the clone shares its blocks with the original
+// until either is modified. Use the Clone function to fall back to Copy
+// otherwise.
+type Cloner interface {
+	// Clone makes dst a clone of the file src, replacing dst if it exists.
+	// It fails with ErrNotSupported if this src and dst can't be cloned,
+	// such as when they are on different devices, before changing dst.
+	Clone(src, dst string) error
+}
+
+// Clone makes dst a copy of the file src, cloned copy-on-write if fs is a
+// Cloner able to, and copied with Copy otherwise. Either way dst ends up
+// independent of src: modifying one doesn't change the other.
+func Clone(fs FileSystem, src, dst string) error {
+	if cloner, ok := fs.(Cloner); ok {
+		if err := cloner.Clone(src, dst); !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return Copy(fs, src, dst)
+}
+
+// Copy copies the content and mode of the file src to dst, replacing dst if
+// it exists. It reads src through Open and writes dst in chunks, so it works
+// with any file system and any file size.
+func Copy(fs FileSystem, src, dst string) error {
+	info, err := fs.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir {
+		return NewInvalidArgumentError("src", src, "is a directory")
+	}
+	r, err := fs.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if err := importFile(fs, dst, r); err != nil {
+		return fmt.Errorf("copy %s to %s: %w", src, dst, err)
+	}
+	if mode := info.Mode & ModePerm; mode != 0 {
+		if err := fs.Chmod(dst, mode); err != nil && !errors.Is(err, ErrNotSupported) {
+			return err
+		}
+	}
+	return nil
+}
+
+// RangeCopier is implemented by file systems that can copy a range of one
+// file into another without the data leaving them, such as with
+// copy_file_range(2). Use the CopyRange function to fall back to Read and
+// Write otherwise.
+type RangeCopier interface {
+	// CopyRange copies up to length bytes of src from srcOffset to dst at
+	// dstOffset, as CopyRange does, and returns the number copied
+	CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error)
+}
+
+// CopyRange copies up to length bytes of the file src from srcOffset to the
+// existing file dst at dstOffset, overwriting what dst has there and
+// growing it if needed, like copy_file_range(2). It returns the number of
+// bytes copied, fewer than length if src ends first and 0 if srcOffset is at
+// or past its end. Ranges overlapping within the same file fail with an
+// InvalidArgumentError.
+//
+// File systems implementing RangeCopier copy the range themselves; others
+// are read and written in chunks.
+func CopyRange(fs FileSystem, src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	if srcOffset < 0 || dstOffset < 0 || length < 0 {
+		return 0, NewInvalidArgumentError("offset", fmt.Sprintf("%d, %d, %d", srcOffset, dstOffset, length), "negative offset or length")
+	}
+	info, err := fs.Stat(src)
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir {
+		return 0, NewInvalidArgumentError("src", src, "is a directory")
+	}
+	if remaining := info.Size - srcOffset; remaining < length {
+		length = max(remaining, 0)
+	}
+	if length == 0 {
+		return 0, nil
+	}
+	if NormalizePath(src) == NormalizePath(dst) && srcOffset < dstOffset+length && dstOffset < srcOffset+length {
+		return 0, NewInvalidArgumentError("dst_offset", dstOffset, "overlaps the source range")
+	}
+
+	if copier, ok := fs.(RangeCopier); ok {
+		if n, err := copier.CopyRange(src, srcOffset, dst, dstOffset, length); !errors.Is(err, ErrNotSupported) {
+			return n, err
+		}
+	}
+
+	var copied int64
+	for copied < length {
+		data, err := fs.Read(src, srcOffset+copied, min(length-copied, tarChunk))
+		if err != nil && !errors.Is(err, io.EOF) {
+			return copied, err
+		}
+		if len(data) == 0 {
+			break
+		}
+		if _, err := fs.Write(dst, data, dstOffset+copied, WriteFlagNone); err != nil {
+			return copied, err
+		}
+		copied += int64(len(data))
+	}
+	return copied, nil
+}
@@ -0,0 +1,40 @@
+package filesystem
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+)
+
+// sniffLen is how many bytes content type sniffing looks at
+const sniffLen = 512
+
+// KnownContentType returns the MIME type of the file name without reading
+// it: the type its plugin reports under MetaContentType, or else one guessed
+// from its extension. It returns "" if neither gives one.
+func KnownContentType(name string, info *FileInfo) string {
+	if ctype := info.Meta.ContentType(); ctype != "" {
+		return ctype
+	}
+	return mime.TypeByExtension(path.Ext(name))
+}
+
+// ContentType returns the MIME type of the file name like KnownContentType,
+// falling back to sniffing its first bytes with http.DetectContentType, which
+// also detects the charset of text. Files whose content changes as it's
+// read, and files without a size, aren't sniffed, as reading them could
+// consume a queue's message; they are application/octet-stream.
+func ContentType(fs FileSystem, name string, info *FileInfo) string {
+	if ctype := KnownContentType(name, info); ctype != "" {
+		return ctype
+	}
+	if info.Meta.Sequential() || info.Size == 0 {
+		return "application/octet-stream"
+	}
+	data, err := fs.Read(name, 0, sniffLen)
+	if err != nil && err != io.EOF {
+		return "application/octet-stream"
+	}
+	return http.DetectContentType(data)
+}
@@ -3,6 +3,8 @@ package filesystem
 import (
 	"errors"
 	"fmt"
+	"syscall"
+	"time"
 )
 
 // Standard error types for filesystem operations
@@ -26,8 +28,94 @@ var (
 
 	// ErrNotSupported indicates the operation is not supported by this filesystem
 	ErrNotSupported = errors.New("operation not supported")
+
+	// ErrRateLimited indicates the operation was rejected to protect a backend, retry later
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrQuotaExceeded indicates the operation would exceed a storage quota
+	ErrQuotaExceeded = errors.New("quota exceeded")
+
+	// ErrTooManyLinks indicates the operation would exceed a link or nesting limit
+	ErrTooManyLinks = errors.New("too many links")
+
+	// ErrWriteConflict indicates a write overlapped another write to the same file in progress
+	ErrWriteConflict = errors.New("write conflict")
 )
 
+// Kind classifies a filesystem error so callers can branch on its category
+// without matching messages
+type Kind int
+
+const (
+	KindUnknown Kind = iota
+	KindNotFound
+	KindPermissionDenied
+	KindInvalidArgument
+	KindAlreadyExists
+	KindNotDirectory
+	KindNotSupported
+	KindRateLimited
+	KindQuotaExceeded
+	KindTooManyLinks
+	KindWriteConflict
+)
+
+// kinds pairs each kind with its sentinel error, in the order KindOf checks them
+var kinds = []struct {
+	kind  Kind
+	err   error
+	errno syscall.Errno
+}{
+	{KindNotFound, ErrNotFound, syscall.ENOENT},
+	{KindPermissionDenied, ErrPermissionDenied, syscall.EACCES},
+	{KindInvalidArgument, ErrInvalidArgument, syscall.EINVAL},
+	{KindAlreadyExists, ErrAlreadyExists, syscall.EEXIST},
+	{KindNotDirectory, ErrNotDirectory, syscall.ENOTDIR},
+	{KindNotSupported, ErrNotSupported, syscall.ENOTSUP},
+	{KindRateLimited, ErrRateLimited, syscall.EAGAIN},
+	{KindQuotaExceeded, ErrQuotaExceeded, syscall.EDQUOT},
+	{KindTooManyLinks, ErrTooManyLinks, syscall.EMLINK},
+	{KindWriteConflict, ErrWriteConflict, syscall.EBUSY},
+}
+
+// KindOf returns the kind of err, looking through wrapped errors.
+// Errors that match none of the sentinel errors are KindUnknown.
+func KindOf(err error) Kind {
+	if err == nil {
+		return KindUnknown
+	}
+	for _, k := range kinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	return KindUnknown
+}
+
+// Errno returns the errno matching the kind (EIO for KindUnknown)
+func (k Kind) Errno() syscall.Errno {
+	for _, kk := range kinds {
+		if kk.kind == k {
+			return kk.errno
+		}
+	}
+	return syscall.EIO
+}
+
+func (k Kind) String() string {
+	for _, kk := range kinds {
+		if kk.kind == k {
+			return kk.err.Error()
+		}
+	}
+	return "unknown"
+}
+
+// Errno returns the errno matching err's kind (EIO if it has none)
+func Errno(err error) syscall.Errno {
+	return KindOf(err).Errno()
+}
+
 // NotFoundError represents a file or directory not found error with context
 type NotFoundError struct {
 	Path string
@@ -45,6 +133,8 @@ func (e *NotFoundError) Is(target error) bool {
 	return target == ErrNotFound
 }
 
+func (e *NotFoundError) Kind() Kind { return KindNotFound }
+
 // PermissionDeniedError represents a permission error with context
 type PermissionDeniedError struct {
 	Path   string
@@ -66,6 +156,8 @@ func (e *PermissionDeniedError) Is(target error) bool {
 	return target == ErrPermissionDenied
 }
 
+func (e *PermissionDeniedError) Kind() Kind { return KindPermissionDenied }
+
 // InvalidArgumentError represents an invalid argument error with context
 type InvalidArgumentError struct {
 	Name   string // Name of the argument
@@ -84,6 +176,8 @@ func (e *InvalidArgumentError) Is(target error) bool {
 	return target == ErrInvalidArgument
 }
 
+func (e *InvalidArgumentError) Kind() Kind { return KindInvalidArgument }
+
 // AlreadyExistsError represents a resource conflict error
 type AlreadyExistsError struct {
 	Path     string
@@ -101,6 +195,8 @@ func (e *AlreadyExistsError) Is(target error) bool {
 	return target == ErrAlreadyExists
 }
 
+func (e *AlreadyExistsError) Kind() Kind { return KindAlreadyExists }
+
 // NotDirectoryError represents an error when a directory was expected but the path is not a directory
 type NotDirectoryError struct {
 	Path string
@@ -114,6 +210,8 @@ func (e *NotDirectoryError) Is(target error) bool {
 	return target == ErrNotDirectory
 }
 
+func (e *NotDirectoryError) Kind() Kind { return KindNotDirectory }
+
 // NotSupportedError represents an error when an operation is not supported by the filesystem
 type NotSupportedError struct {
 	Path string
@@ -131,6 +229,86 @@ func (e *NotSupportedError) Is(target error) bool {
 	return target == ErrNotSupported
 }
 
+func (e *NotSupportedError) Kind() Kind { return KindNotSupported }
+
+// RateLimitedError represents an operation rejected by a rate limiter
+type RateLimitedError struct {
+	Resource   string        // What is rate limited (e.g., a plugin name)
+	RetryAfter time.Duration // How long until the operation would be admitted
+}
+
+func (e *RateLimitedError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%s: rate limited, retry after %v", e.Resource, e.RetryAfter)
+	}
+	return fmt.Sprintf("%s: rate limited", e.Resource)
+}
+
+func (e *RateLimitedError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+func (e *RateLimitedError) Kind() Kind { return KindRateLimited }
+
+// QuotaExceededError represents an operation that would exceed a storage quota
+type QuotaExceededError struct {
+	Path  string
+	Limit int64 // The quota in bytes (0 = unknown)
+}
+
+func (e *QuotaExceededError) Error() string {
+	if e.Limit > 0 {
+		return fmt.Sprintf("%s: quota exceeded (limit %d bytes)", e.Path, e.Limit)
+	}
+	return fmt.Sprintf("%s: quota exceeded", e.Path)
+}
+
+func (e *QuotaExceededError) Is(target error) bool {
+	return target == ErrQuotaExceeded
+}
+
+func (e *QuotaExceededError) Kind() Kind { return KindQuotaExceeded }
+
+// TooManyLinksError represents an operation that would exceed a link or nesting limit
+type TooManyLinksError struct {
+	Path  string
+	Limit int // The limit that was reached (0 = unknown)
+}
+
+func (e *TooManyLinksError) Error() string {
+	if e.Limit > 0 {
+		return fmt.Sprintf("%s: too many links (limit %d)", e.Path, e.Limit)
+	}
+	return fmt.Sprintf("%s: too many links", e.Path)
+}
+
+func (e *TooManyLinksError) Is(target error) bool {
+	return target == ErrTooManyLinks
+}
+
+func (e *TooManyLinksError) Kind() Kind { return KindTooManyLinks }
+
+// WriteConflictError represents a write rejected because it overlapped a
+// write to the same file still in progress
+type WriteConflictError struct {
+	Path   string
+	Offset int64 // Start of the rejected write (-1 = the whole file)
+	Size   int64
+}
+
+func (e *WriteConflictError) Error() string {
+	if e.Offset >= 0 {
+		return fmt.Sprintf("%s: write conflict at offset %d (%d bytes)", e.Path, e.Offset, e.Size)
+	}
+	return fmt.Sprintf("%s: write conflict", e.Path)
+}
+
+func (e *WriteConflictError) Is(target error) bool {
+	return target == ErrWriteConflict
+}
+
+func (e *WriteConflictError) Kind() Kind { return KindWriteConflict }
+
 // Helper functions to create common errors
 
 // NewNotFoundError creates a new NotFoundError
@@ -162,3 +340,23 @@ func NewNotDirectoryError(path string) error {
 func NewNotSupportedError(op, path string) error {
 	return &NotSupportedError{Op: op, Path: path}
 }
+
+// NewRateLimitedError creates a new RateLimitedError
+func NewRateLimitedError(resource string, retryAfter time.Duration) error {
+	return &RateLimitedError{Resource: resource, RetryAfter: retryAfter}
+}
+
+// NewQuotaExceededError creates a new QuotaExceededError
+func NewQuotaExceededError(path string, limit int64) error {
+	return &QuotaExceededError{Path: path, Limit: limit}
+}
+
+// NewTooManyLinksError creates a new TooManyLinksError
+func NewTooManyLinksError(path string, limit int) error {
+	return &TooManyLinksError{Path: path, Limit: limit}
+}
+
+// NewWriteConflictError creates a new WriteConflictError
+func NewWriteConflictError(path string, offset, size int64) error {
+	return &WriteConflictError{Path: path, Offset: offset, Size: size}
+}
@@ -0,0 +1,66 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"syscall"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	tests := []struct {
+		err      error
+		sentinel error
+		kind     Kind
+		errno    syscall.Errno
+	}{
+		{NewNotFoundError("stat", "/a"), ErrNotFound, KindNotFound, syscall.ENOENT},
+		{NewPermissionDeniedError("write", "/a", "read-only"), ErrPermissionDenied, KindPermissionDenied, syscall.EACCES},
+		{NewInvalidArgumentError("offset", -1, "must not be negative"), ErrInvalidArgument, KindInvalidArgument, syscall.EINVAL},
+		{NewAlreadyExistsError("file", "/a"), ErrAlreadyExists, KindAlreadyExists, syscall.EEXIST},
+		{NewNotDirectoryError("/a"), ErrNotDirectory, KindNotDirectory, syscall.ENOTDIR},
+		{NewNotSupportedError("symlink", "/a"), ErrNotSupported, KindNotSupported, syscall.ENOTSUP},
+		{NewRateLimitedError("s3fs", 0), ErrRateLimited, KindRateLimited, syscall.EAGAIN},
+		{NewQuotaExceededError("/a", 1024), ErrQuotaExceeded, KindQuotaExceeded, syscall.EDQUOT},
+		{NewTooManyLinksError("/a", 40), ErrTooManyLinks, KindTooManyLinks, syscall.EMLINK},
+		{NewWriteConflictError("/a", 0, 4096), ErrWriteConflict, KindWriteConflict, syscall.EBUSY},
+	}
+
+	for _, tt := range tests {
+		wrapped := fmt.Errorf("mount /s3: %w", fmt.Errorf("plugin call: %w", tt.err))
+		for _, err := range []error{tt.err, wrapped} {
+			if !errors.Is(err, tt.sentinel) {
+				t.Errorf("Expected errors.Is(%v, %v)", err, tt.sentinel)
+			}
+			if got := KindOf(err); got != tt.kind {
+				t.Errorf("Expected kind %v for %v, got %v", tt.kind, err, got)
+			}
+			if got := Errno(err); got != tt.errno {
+				t.Errorf("Expected errno %v for %v, got %v", tt.errno, err, got)
+			}
+		}
+		if k, ok := tt.err.(interface{ Kind() Kind }); !ok || k.Kind() != tt.kind {
+			t.Errorf("Expected %T.Kind() to be %v", tt.err, tt.kind)
+		}
+	}
+
+	// errors.As recovers the context of a wrapped error
+	var notFound *NotFoundError
+	if err := fmt.Errorf("read: %w", NewNotFoundError("read", "/b")); !errors.As(err, &notFound) || notFound.Path != "/b" {
+		t.Errorf("Expected errors.As to find NotFoundError for /b, got %v", notFound)
+	}
+}
+
+func TestErrorKindUnknown(t *testing.T) {
+	for _, err := range []error{nil, errors.New("disk on fire"), fmt.Errorf("wrapped: %w", errors.New("boom"))} {
+		if got := KindOf(err); got != KindUnknown {
+			t.Errorf("Expected KindUnknown for %v, got %v", err, got)
+		}
+		if got := Errno(err); got != syscall.EIO {
+			t.Errorf("Expected EIO for %v, got %v", err, got)
+		}
+	}
+	if KindUnknown.String() != "unknown" || KindQuotaExceeded.String() != "quota exceeded" {
+		t.Errorf("Unexpected kind names %q, %q", KindUnknown, KindQuotaExceeded)
+	}
+}
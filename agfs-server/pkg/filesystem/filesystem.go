@@ -1,7 +1,10 @@
 package filesystem
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"os"
 	"time"
 )
 
@@ -48,14 +51,96 @@ type MetaData struct {
 	Content map[string]string // Additional extensible metadata
 }
 
+// MetaAccess is the MetaData.Content key under which a plugin reports how a
+// file's content can be read, so clients like agfs-fuse don't have to guess
+// from the plugin or handle type. Files without it are treated as before.
+const (
+	MetaAccess = "access"
+	// AccessRandom files can be read at any offset, in any number of requests
+	AccessRandom = "random"
+	// AccessStream files produce data as it is read and can't seek
+	AccessStream = "stream"
+	// AccessConsumeOnce files return new content on every read, like a
+	// queue's dequeue file, and must be read whole in a single request
+	AccessConsumeOnce = "consume-once"
+)
+
+// MetaETag is the MetaData.Content key under which a plugin reports a token
+// that changes whenever the file's content or attributes change. Clients
+// revalidate cached data with it (If-None-Match) instead of fetching the data
+// again. Plugins that can't produce one cheaply leave it out, and their files
+// are cached by time only.
+const MetaETag = "etag"
+
+// MetaContentType is the MetaData.Content key under which a plugin reports a
+// file's MIME type, such as "application/json". Gateways serve files without
+// it with a type guessed by ContentType.
+const MetaContentType = "content-type"
+
+// AttrETag returns an etag derived from info's modification time, size and
+// mode, for plugins that update the modification time on every write
+func AttrETag(info *FileInfo) string {
+	return fmt.Sprintf("%x-%x-%o", info.ModTime.UnixNano(), info.Size, info.Mode)
+}
+
+// Mode bits are POSIX permission bits, including the setuid, setgid and
+// sticky bits, not os.FileMode values
+const (
+	ModeSetuid uint32 = 04000
+	ModeSetgid uint32 = 02000
+	ModeSticky uint32 = 01000
+	ModePerm   uint32 = 07777
+)
+
+// ToFileMode converts POSIX permission bits to an os.FileMode
+func ToFileMode(mode uint32) os.FileMode {
+	m := os.FileMode(mode & 0777)
+	if mode&ModeSetuid != 0 {
+		m |= os.ModeSetuid
+	}
+	if mode&ModeSetgid != 0 {
+		m |= os.ModeSetgid
+	}
+	if mode&ModeSticky != 0 {
+		m |= os.ModeSticky
+	}
+	return m
+}
+
+// FromFileMode converts the permission bits of an os.FileMode to POSIX bits
+func FromFileMode(m os.FileMode) uint32 {
+	mode := uint32(m.Perm())
+	if m&os.ModeSetuid != 0 {
+		mode |= ModeSetuid
+	}
+	if m&os.ModeSetgid != 0 {
+		mode |= ModeSetgid
+	}
+	if m&os.ModeSticky != 0 {
+		mode |= ModeSticky
+	}
+	return mode
+}
+
 // FileInfo represents file metadata similar to os.FileInfo
 type FileInfo struct {
 	Name    string
 	Size    int64
-	Mode    uint32
+	Mode    uint32 // Permission bits (ModePerm), preserved as set by Mkdir or Chmod
 	ModTime time.Time
 	IsDir   bool
 	Meta    MetaData // Structured metadata for additional information
+	// Ino identifies the file for as long as it exists, across renames and
+	// shared by its hard links, like a POSIX inode number (0 = unknown).
+	// It need only be unique within the plugin instance; MountableFS makes
+	// it unique across mounts.
+	Ino uint64
+	// Blocks is the number of 512-byte blocks the file has allocated, which
+	// is less than its size for a sparse file, and BlockSize its preferred
+	// I/O size, as in stat(2) (0 = unknown). Clients count ceil(Size/512)
+	// blocks when Blocks is unknown.
+	Blocks    int64
+	BlockSize int64
 }
 
 // FileSystem defines the interface for a POSIX-like file system
@@ -64,12 +149,15 @@ type FileSystem interface {
 	Create(path string) error
 
 	// Mkdir creates a new directory
+	// perm: permission bits, including setuid/setgid/sticky (ModePerm)
 	Mkdir(path string, perm uint32) error
 
 	// Remove removes a file or empty directory
 	Remove(path string) error
 
-	// RemoveAll removes a path and any children it contains
+	// RemoveAll removes a path and, if it is a directory, everything under
+	// it at any depth. Symlinks are removed themselves, never followed.
+	// File systems without a native recursive delete can use RemoveAllWalk.
 	RemoveAll(path string) error
 
 	// Read reads file content with optional offset and size
@@ -94,6 +182,8 @@ type FileSystem interface {
 	Rename(oldPath, newPath string) error
 
 	// Chmod changes file permissions
+	// mode: permission bits, including setuid/setgid/sticky (ModePerm);
+	// Stat and ReadDir must report them back unchanged
 	Chmod(path string, mode uint32) error
 
 	// Open opens a file for reading
@@ -136,6 +226,16 @@ type Toucher interface {
 	Touch(path string) error
 }
 
+// MkdirAller is implemented by file systems that can create a directory
+// along with any missing parents more efficiently than one Mkdir per level.
+// Use the MkdirAll function to fall back to Stat and Mkdir otherwise.
+type MkdirAller interface {
+	// MkdirAll creates path and any missing parents with perm. It succeeds if
+	// path is already a directory and fails with ErrNotDirectory if path or
+	// one of its parents is a file.
+	MkdirAll(path string, perm uint32) error
+}
+
 // Symlinker is implemented by file systems that support symbolic links
 type Symlinker interface {
 	// Symlink creates a symbolic link at linkPath pointing to targetPath
@@ -147,3 +247,12 @@ type Symlinker interface {
 	// Returns the target path and error if the operation fails
 	Readlink(linkPath string) (string, error)
 }
+
+// ContextBinder is implemented by file systems that can stop an operation in
+// progress when the request it serves goes away. WithContext returns a view of
+// the file system whose operations run under ctx; it shares all state with the
+// original. Use the WithContext function to fall back to the file system
+// itself otherwise.
+type ContextBinder interface {
+	WithContext(ctx context.Context) FileSystem
+}
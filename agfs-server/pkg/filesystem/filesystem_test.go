@@ -1,6 +1,8 @@
 package filesystem
 
 import (
+	"io"
+	"os"
 	"testing"
 )
 
@@ -249,3 +251,51 @@ func TestFileInfo(t *testing.T) {
 		t.Errorf("Meta.Content[key]: got %s, want value", info.Meta.Content["key"])
 	}
 }
+
+func TestFileModeConversion(t *testing.T) {
+	tests := []struct {
+		mode     uint32
+		fileMode os.FileMode
+	}{
+		{0644, 0644},
+		{04755, os.ModeSetuid | 0755},
+		{02775, os.ModeSetgid | 0775},
+		{01777, os.ModeSticky | 0777},
+		{07777, os.ModeSetuid | os.ModeSetgid | os.ModeSticky | 0777},
+	}
+
+	for _, tt := range tests {
+		if got := ToFileMode(tt.mode); got != tt.fileMode {
+			t.Errorf("ToFileMode(%o) = %v, want %v", tt.mode, got, tt.fileMode)
+		}
+		if got := FromFileMode(tt.fileMode); got != tt.mode {
+			t.Errorf("FromFileMode(%v) = %o, want %o", tt.fileMode, got, tt.mode)
+		}
+	}
+
+	// Type bits are not permission bits
+	if got := FromFileMode(os.ModeDir | os.ModeSticky | 0755); got != 01755 {
+		t.Errorf("FromFileMode(dir) = %o, want 1755", got)
+	}
+}
+
+func TestChunkedReaderIgnoresSize(t *testing.T) {
+	// A read function that always returns the whole rest of the file
+	content := []byte("more than one chunk of data")
+	calls := 0
+	readFunc := func(path string, offset int64, size int64) ([]byte, error) {
+		calls++
+		return content[offset:], nil
+	}
+
+	data, err := io.ReadAll(NewChunkedReader("/file", readFunc, 4))
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(data) != string(content) {
+		t.Errorf("got %q, want %q", data, content)
+	}
+	if calls != 1 {
+		t.Errorf("got %d reads, want 1", calls)
+	}
+}
@@ -0,0 +1,100 @@
+package filesystem
+
+import (
+	"mime"
+	"strings"
+)
+
+// reservedMetaKeys are the MetaData.Content keys with a meaning clients rely
+// on. Validate checks their values; any other key is free for plugins to use.
+var reservedMetaKeys = []string{MetaAccess, MetaETag, MetaContentType}
+
+// Get returns the value of key in m.Content, or "" if it isn't set
+func (m MetaData) Get(key string) string {
+	return m.Content[key]
+}
+
+// Set sets key in m.Content to value, allocating the map if needed. An empty
+// value removes the key, as "" means unset for every reserved key.
+func (m *MetaData) Set(key, value string) {
+	if value == "" {
+		delete(m.Content, key)
+		return
+	}
+	if m.Content == nil {
+		m.Content = make(map[string]string)
+	}
+	m.Content[key] = value
+}
+
+// ContentType returns the MIME type reported under MetaContentType, or "" if
+// the plugin reports none
+func (m MetaData) ContentType() string {
+	return m.Get(MetaContentType)
+}
+
+// SetContentType sets the MIME type reported under MetaContentType
+func (m *MetaData) SetContentType(ctype string) {
+	m.Set(MetaContentType, ctype)
+}
+
+// ETag returns the etag reported under MetaETag, or "" if the plugin
+// reports none
+func (m MetaData) ETag() string {
+	return m.Get(MetaETag)
+}
+
+// SetETag sets the etag reported under MetaETag
+func (m *MetaData) SetETag(etag string) {
+	m.Set(MetaETag, etag)
+}
+
+// AccessSemantics returns the access hint reported under MetaAccess, one of
+// AccessRandom, AccessStream and AccessConsumeOnce, or "" if the plugin
+// reports none
+func (m MetaData) AccessSemantics() string {
+	return m.Get(MetaAccess)
+}
+
+// SetAccessSemantics sets the access hint reported under MetaAccess
+func (m *MetaData) SetAccessSemantics(access string) {
+	m.Set(MetaAccess, access)
+}
+
+// Sequential reports whether the file's content changes as it's read, so it
+// must be read whole, in a single request, and never sniffed or re-read
+func (m MetaData) Sequential() bool {
+	access := m.AccessSemantics()
+	return access == AccessStream || access == AccessConsumeOnce
+}
+
+// Validate checks the reserved keys of m.Content: an access hint must be
+// one of the Access constants, a content type must parse as a MIME type, and
+// an etag must be usable in a quoted ETag header. Keys that are set must not
+// be empty. Other keys aren't checked.
+func (m MetaData) Validate() error {
+	for _, key := range reservedMetaKeys {
+		value, ok := m.Content[key]
+		if !ok {
+			continue
+		}
+		if value == "" {
+			return NewInvalidArgumentError("meta."+key, nil, "set but empty")
+		}
+		switch key {
+		case MetaAccess:
+			if value != AccessRandom && value != AccessStream && value != AccessConsumeOnce {
+				return NewInvalidArgumentError("meta."+key, value, "not a known access hint")
+			}
+		case MetaContentType:
+			if _, _, err := mime.ParseMediaType(value); err != nil {
+				return NewInvalidArgumentError("meta."+key, value, err.Error())
+			}
+		case MetaETag:
+			if strings.IndexFunc(value, func(r rune) bool { return r == '"' || r < 0x20 || r == 0x7f }) >= 0 {
+				return NewInvalidArgumentError("meta."+key, value, "contains a quote or control character")
+			}
+		}
+	}
+	return nil
+}
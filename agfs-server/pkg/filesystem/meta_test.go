@@ -0,0 +1,58 @@
+package filesystem
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMetaDataAccessors(t *testing.T) {
+	var m MetaData
+	if m.ContentType() != "" || m.ETag() != "" || m.AccessSemantics() != "" || m.Sequential() {
+		t.Errorf("Expected nothing reported by empty metadata, got %+v", m)
+	}
+
+	m.SetContentType("application/json")
+	m.SetETag("abc-1")
+	m.SetAccessSemantics(AccessConsumeOnce)
+	m.Set("owner", "alice")
+	if m.ContentType() != "application/json" || m.ETag() != "abc-1" || m.AccessSemantics() != AccessConsumeOnce || !m.Sequential() {
+		t.Errorf("Expected the values set, got %+v", m)
+	}
+	if m.Content[MetaContentType] != "application/json" || m.Get("owner") != "alice" {
+		t.Errorf("Expected the values in the raw map, got %v", m.Content)
+	}
+
+	m.SetETag("")
+	if _, ok := m.Content[MetaETag]; ok {
+		t.Errorf("Expected an empty value to remove the key, got %v", m.Content)
+	}
+	m.SetAccessSemantics(AccessRandom)
+	if m.Sequential() {
+		t.Error("Expected random access files not to be sequential")
+	}
+}
+
+func TestMetaDataValidate(t *testing.T) {
+	tests := []struct {
+		content map[string]string
+		valid   bool
+	}{
+		{nil, true},
+		{map[string]string{"anything": ""}, true},
+		{map[string]string{MetaAccess: AccessStream, MetaETag: "1-2-644", MetaContentType: "text/plain; charset=utf-8"}, true},
+		{map[string]string{MetaAccess: "sequential"}, false},
+		{map[string]string{MetaAccess: ""}, false},
+		{map[string]string{MetaContentType: "not a type"}, false},
+		{map[string]string{MetaETag: `say "hi"`}, false},
+		{map[string]string{MetaETag: "line\nbreak"}, false},
+	}
+	for _, tt := range tests {
+		err := MetaData{Content: tt.content}.Validate()
+		if (err == nil) != tt.valid {
+			t.Errorf("Validate(%v) = %v, expected valid=%v", tt.content, err, tt.valid)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("Expected an invalid argument error, got %v", err)
+		}
+	}
+}
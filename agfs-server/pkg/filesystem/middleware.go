@@ -0,0 +1,177 @@
+package filesystem
+
+import (
+	"io"
+)
+
+// Middleware decorates a file system with a cross-cutting behavior such as
+// logging, caching or access restrictions. It returns a FileSystem that
+// delegates to fs, usually a struct embedding Wrapper that overrides the
+// operations it changes.
+//
+// A middleware may be applied to a file system for every operation, as
+// MountableFS does to bind it to the request context first, so state that
+// must outlive one operation, such as a cache or a rate limiter, belongs in
+// the closure that creates the middleware rather than in the wrapper.
+type Middleware func(fs FileSystem) FileSystem
+
+// Chain composes middlewares into one. The first is the outermost, so
+// Chain(LogOps(logf), ReadOnly)(fs) logs operations before ReadOnly sees
+// them.
+func Chain(middlewares ...Middleware) Middleware {
+	return func(fs FileSystem) FileSystem {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			fs = middlewares[i](fs)
+		}
+		return fs
+	}
+}
+
+// Wrapper is a FileSystem that delegates every operation to Inner, including
+// those of the optional Toucher, Truncater, MkdirAller, Symlinker, Cloner,
+// RangeCopier, Streamer and HandleFS interfaces. Optional operations Inner
+// doesn't implement fail with ErrNotSupported, so callers must treat that
+// error like a failed type assertion. Middlewares embed it and override the
+// operations they change.
+type Wrapper struct {
+	Inner FileSystem
+}
+
+// Unwrap returns the wrapped file system, for the optional interfaces
+// Wrapper doesn't delegate
+func (w *Wrapper) Unwrap() FileSystem {
+	return w.Inner
+}
+
+func (w *Wrapper) Create(path string) error {
+	return w.Inner.Create(path)
+}
+
+func (w *Wrapper) Mkdir(path string, perm uint32) error {
+	return w.Inner.Mkdir(path, perm)
+}
+
+func (w *Wrapper) Remove(path string) error {
+	return w.Inner.Remove(path)
+}
+
+func (w *Wrapper) RemoveAll(path string) error {
+	return w.Inner.RemoveAll(path)
+}
+
+func (w *Wrapper) Read(path string, offset int64, size int64) ([]byte, error) {
+	return w.Inner.Read(path, offset, size)
+}
+
+func (w *Wrapper) Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error) {
+	return w.Inner.Write(path, data, offset, flags)
+}
+
+func (w *Wrapper) ReadDir(path string) ([]FileInfo, error) {
+	return w.Inner.ReadDir(path)
+}
+
+func (w *Wrapper) Stat(path string) (*FileInfo, error) {
+	return w.Inner.Stat(path)
+}
+
+func (w *Wrapper) Rename(oldPath, newPath string) error {
+	return w.Inner.Rename(oldPath, newPath)
+}
+
+func (w *Wrapper) Chmod(path string, mode uint32) error {
+	return w.Inner.Chmod(path, mode)
+}
+
+func (w *Wrapper) Open(path string) (io.ReadCloser, error) {
+	return w.Inner.Open(path)
+}
+
+func (w *Wrapper) OpenWrite(path string) (io.WriteCloser, error) {
+	return w.Inner.OpenWrite(path)
+}
+
+// Touch implements Toucher
+func (w *Wrapper) Touch(path string) error {
+	if t, ok := w.Inner.(Toucher); ok {
+		return t.Touch(path)
+	}
+	return NewNotSupportedError("touch", path)
+}
+
+// Truncate implements Truncater
+func (w *Wrapper) Truncate(path string, size int64) error {
+	if t, ok := w.Inner.(Truncater); ok {
+		return t.Truncate(path, size)
+	}
+	return NewNotSupportedError("truncate", path)
+}
+
+// MkdirAll implements MkdirAller, falling back to Stat and Mkdir on Inner
+func (w *Wrapper) MkdirAll(path string, perm uint32) error {
+	return MkdirAll(w.Inner, path, perm)
+}
+
+// Symlink implements Symlinker
+func (w *Wrapper) Symlink(targetPath, linkPath string) error {
+	if s, ok := w.Inner.(Symlinker); ok {
+		return s.Symlink(targetPath, linkPath)
+	}
+	return NewNotSupportedError("symlink", linkPath)
+}
+
+// Readlink implements Symlinker
+func (w *Wrapper) Readlink(linkPath string) (string, error) {
+	if s, ok := w.Inner.(Symlinker); ok {
+		return s.Readlink(linkPath)
+	}
+	return "", NewNotSupportedError("readlink", linkPath)
+}
+
+// Clone implements Cloner
+func (w *Wrapper) Clone(src, dst string) error {
+	if c, ok := w.Inner.(Cloner); ok {
+		return c.Clone(src, dst)
+	}
+	return NewNotSupportedError("clone", dst)
+}
+
+// CopyRange implements RangeCopier
+func (w *Wrapper) CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	if c, ok := w.Inner.(RangeCopier); ok {
+		return c.CopyRange(src, srcOffset, dst, dstOffset, length)
+	}
+	return 0, NewNotSupportedError("copyrange", dst)
+}
+
+// OpenStream implements Streamer
+func (w *Wrapper) OpenStream(path string) (StreamReader, error) {
+	if s, ok := w.Inner.(Streamer); ok {
+		return s.OpenStream(path)
+	}
+	return nil, NewNotSupportedError("openstream", path)
+}
+
+// OpenHandle implements HandleFS
+func (w *Wrapper) OpenHandle(path string, flags OpenFlag, mode uint32) (FileHandle, error) {
+	if h, ok := w.Inner.(HandleFS); ok {
+		return h.OpenHandle(path, flags, mode)
+	}
+	return nil, NewNotSupportedError("openhandle", path)
+}
+
+// GetHandle implements HandleFS
+func (w *Wrapper) GetHandle(id int64) (FileHandle, error) {
+	if h, ok := w.Inner.(HandleFS); ok {
+		return h.GetHandle(id)
+	}
+	return nil, ErrNotSupported
+}
+
+// CloseHandle implements HandleFS
+func (w *Wrapper) CloseHandle(id int64) error {
+	if h, ok := w.Inner.(HandleFS); ok {
+		return h.CloseHandle(id)
+	}
+	return ErrNotSupported
+}
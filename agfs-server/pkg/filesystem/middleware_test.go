@@ -0,0 +1,53 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestChainLogsAndRejectsWrites(t *testing.T) {
+	var logged []string
+	logf := func(format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	fs := Chain(LogOps(logf), ReadOnly)(newCheckTestFS())
+
+	if _, err := fs.Write("/ok.txt", []byte("x"), 0, WriteFlagNone); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected write to be denied, got %v", err)
+	}
+	if err := fs.(Toucher).Touch("/ok.txt"); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected touch to be denied, got %v", err)
+	}
+	if _, err := fs.(HandleFS).OpenHandle("/ok.txt", O_RDWR, 0); !errors.Is(err, ErrPermissionDenied) {
+		t.Errorf("Expected a read-write handle to be denied, got %v", err)
+	}
+	info, err := fs.Stat("/ok.txt")
+	if err != nil || info.Size != 5 {
+		t.Errorf("Expected stat to pass through, got %+v, %v", info, err)
+	}
+	if target, err := fs.(Symlinker).Readlink("/good-link"); err != nil || target != "sub" {
+		t.Errorf("Expected readlink to pass through, got %q, %v", target, err)
+	}
+
+	if len(logged) != 5 {
+		t.Fatalf("Expected 5 logged operations, got %d: %v", len(logged), logged)
+	}
+	if !strings.HasPrefix(logged[0], "write /ok.txt") || !strings.Contains(logged[0], "read-only") {
+		t.Errorf("Expected the denied write to be logged, got %q", logged[0])
+	}
+	if !strings.HasPrefix(logged[3], "stat /ok.txt") || strings.Contains(logged[3], ":") {
+		t.Errorf("Expected a successful stat to be logged, got %q", logged[3])
+	}
+}
+
+func TestWrapperNotSupported(t *testing.T) {
+	fs := &Wrapper{Inner: newCheckTestFS()}
+	if err := fs.Truncate("/ok.txt", 0); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected truncate to be unsupported, got %v", err)
+	}
+	if _, err := fs.OpenHandle("/ok.txt", O_RDONLY, 0); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("Expected handles to be unsupported, got %v", err)
+	}
+}
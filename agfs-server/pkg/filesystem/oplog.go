@@ -0,0 +1,174 @@
+package filesystem
+
+import (
+	"io"
+	"time"
+)
+
+// LogOps returns a Middleware reporting every operation, with its path,
+// duration and error, to logf, e.g. log.Printf or logrus.Debugf
+func LogOps(logf func(format string, args ...interface{})) Middleware {
+	return func(fs FileSystem) FileSystem {
+		return &opLogFS{Wrapper: Wrapper{Inner: fs}, logf: logf}
+	}
+}
+
+type opLogFS struct {
+	Wrapper
+	logf func(format string, args ...interface{})
+}
+
+func (l *opLogFS) log(op, path string, start time.Time, err error) {
+	if err != nil {
+		l.logf("%s %s (%v): %v", op, path, time.Since(start), err)
+		return
+	}
+	l.logf("%s %s (%v)", op, path, time.Since(start))
+}
+
+func (l *opLogFS) Create(path string) error {
+	start := time.Now()
+	err := l.Wrapper.Create(path)
+	l.log("create", path, start, err)
+	return err
+}
+
+func (l *opLogFS) Mkdir(path string, perm uint32) error {
+	start := time.Now()
+	err := l.Wrapper.Mkdir(path, perm)
+	l.log("mkdir", path, start, err)
+	return err
+}
+
+func (l *opLogFS) Remove(path string) error {
+	start := time.Now()
+	err := l.Wrapper.Remove(path)
+	l.log("remove", path, start, err)
+	return err
+}
+
+func (l *opLogFS) RemoveAll(path string) error {
+	start := time.Now()
+	err := l.Wrapper.RemoveAll(path)
+	l.log("removeall", path, start, err)
+	return err
+}
+
+func (l *opLogFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	start := time.Now()
+	data, err := l.Wrapper.Read(path, offset, size)
+	l.log("read", path, start, err)
+	return data, err
+}
+
+func (l *opLogFS) Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error) {
+	start := time.Now()
+	n, err := l.Wrapper.Write(path, data, offset, flags)
+	l.log("write", path, start, err)
+	return n, err
+}
+
+func (l *opLogFS) ReadDir(path string) ([]FileInfo, error) {
+	start := time.Now()
+	files, err := l.Wrapper.ReadDir(path)
+	l.log("readdir", path, start, err)
+	return files, err
+}
+
+func (l *opLogFS) Stat(path string) (*FileInfo, error) {
+	start := time.Now()
+	info, err := l.Wrapper.Stat(path)
+	l.log("stat", path, start, err)
+	return info, err
+}
+
+func (l *opLogFS) Rename(oldPath, newPath string) error {
+	start := time.Now()
+	err := l.Wrapper.Rename(oldPath, newPath)
+	l.log("rename", oldPath+" -> "+newPath, start, err)
+	return err
+}
+
+func (l *opLogFS) Chmod(path string, mode uint32) error {
+	start := time.Now()
+	err := l.Wrapper.Chmod(path, mode)
+	l.log("chmod", path, start, err)
+	return err
+}
+
+func (l *opLogFS) Open(path string) (io.ReadCloser, error) {
+	start := time.Now()
+	r, err := l.Wrapper.Open(path)
+	l.log("open", path, start, err)
+	return r, err
+}
+
+func (l *opLogFS) OpenWrite(path string) (io.WriteCloser, error) {
+	start := time.Now()
+	w, err := l.Wrapper.OpenWrite(path)
+	l.log("openwrite", path, start, err)
+	return w, err
+}
+
+func (l *opLogFS) Touch(path string) error {
+	start := time.Now()
+	err := l.Wrapper.Touch(path)
+	l.log("touch", path, start, err)
+	return err
+}
+
+func (l *opLogFS) Truncate(path string, size int64) error {
+	start := time.Now()
+	err := l.Wrapper.Truncate(path, size)
+	l.log("truncate", path, start, err)
+	return err
+}
+
+func (l *opLogFS) MkdirAll(path string, perm uint32) error {
+	start := time.Now()
+	err := l.Wrapper.MkdirAll(path, perm)
+	l.log("mkdirall", path, start, err)
+	return err
+}
+
+func (l *opLogFS) Symlink(targetPath, linkPath string) error {
+	start := time.Now()
+	err := l.Wrapper.Symlink(targetPath, linkPath)
+	l.log("symlink", linkPath, start, err)
+	return err
+}
+
+func (l *opLogFS) Readlink(linkPath string) (string, error) {
+	start := time.Now()
+	target, err := l.Wrapper.Readlink(linkPath)
+	l.log("readlink", linkPath, start, err)
+	return target, err
+}
+
+func (l *opLogFS) Clone(src, dst string) error {
+	start := time.Now()
+	err := l.Wrapper.Clone(src, dst)
+	l.log("clone", dst, start, err)
+	return err
+}
+
+func (l *opLogFS) CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	start := time.Now()
+	n, err := l.Wrapper.CopyRange(src, srcOffset, dst, dstOffset, length)
+	l.log("copyrange", dst, start, err)
+	return n, err
+}
+
+func (l *opLogFS) OpenStream(path string) (StreamReader, error) {
+	start := time.Now()
+	s, err := l.Wrapper.OpenStream(path)
+	l.log("openstream", path, start, err)
+	return s, err
+}
+
+func (l *opLogFS) OpenHandle(path string, flags OpenFlag, mode uint32) (FileHandle, error) {
+	start := time.Now()
+	h, err := l.Wrapper.OpenHandle(path, flags, mode)
+	l.log("openhandle", path, start, err)
+	return h, err
+}
@@ -0,0 +1,260 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// PrefixDirs returns a Middleware presenting the flat keyspace of an object
+// store as a directory tree. The wrapped file system lists every key under
+// root as a file of that directory, named by the key, which may hold "/";
+// the file at root + "/" + key reads and writes it.
+//
+// Directories are the prefixes of keys up to a "/": Stat reports them as
+// directories, ReadDir lists the next level of keys under them, and Mkdir
+// stores an empty marker key ending in "/", as object store consoles do, so
+// an empty directory survives until it is removed. Removing or renaming a
+// directory removes or renames every key under it. Every directory
+// operation lists the whole keyspace, so it suits small keyspaces or
+// stores that list keys cheaply.
+func PrefixDirs(root string) Middleware {
+	root = NormalizePath(root)
+	return func(fs FileSystem) FileSystem {
+		return &prefixDirsFS{Wrapper: Wrapper{Inner: fs}, root: root}
+	}
+}
+
+type prefixDirsFS struct {
+	Wrapper
+	root string
+}
+
+// key returns the key of p, or false if p isn't under root. The key of root
+// itself is empty.
+func (p *prefixDirsFS) key(name string) (string, bool) {
+	name = NormalizePath(name)
+	if name == p.root {
+		return "", true
+	}
+	prefix := p.root
+	if prefix != "/" {
+		prefix += "/"
+	}
+	if !strings.HasPrefix(name, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(name, prefix), true
+}
+
+// keyPath returns the path of the file holding key, keeping the trailing
+// "/" of directory markers
+func (p *prefixDirsFS) keyPath(key string) string {
+	if p.root == "/" {
+		return "/" + key
+	}
+	return p.root + "/" + key
+}
+
+// keys lists the keys starting with prefix, with their file info
+func (p *prefixDirsFS) keys(prefix string) ([]FileInfo, error) {
+	entries, err := p.Inner.ReadDir(p.root)
+	if err != nil {
+		return nil, err
+	}
+	var keys []FileInfo
+	for _, e := range entries {
+		if !e.IsDir && strings.HasPrefix(e.Name, prefix) {
+			keys = append(keys, e)
+		}
+	}
+	return keys, nil
+}
+
+// dirPrefix returns the prefix of the keys in the directory of key
+func dirPrefix(key string) string {
+	if key == "" {
+		return ""
+	}
+	return key + "/"
+}
+
+func prefixDirInfo(name string) *FileInfo {
+	return &FileInfo{
+		Name:    name,
+		Mode:    0755,
+		ModTime: time.Now(),
+		IsDir:   true,
+		Meta:    MetaData{Type: "directory"},
+	}
+}
+
+func (p *prefixDirsFS) Stat(name string) (*FileInfo, error) {
+	key, ok := p.key(name)
+	if !ok {
+		return p.Inner.Stat(name)
+	}
+	info, err := p.Inner.Stat(name)
+	if err == nil {
+		return info, nil
+	}
+	if key == "" {
+		// The root is there even when the store doesn't report it
+		return prefixDirInfo(path.Base(p.root)), nil
+	}
+	keys, err := p.keys(dirPrefix(key))
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) == 0 {
+		return nil, NewNotFoundError("stat", name)
+	}
+	return prefixDirInfo(path.Base(key)), nil
+}
+
+func (p *prefixDirsFS) ReadDir(name string) ([]FileInfo, error) {
+	key, ok := p.key(name)
+	if !ok {
+		return p.Inner.ReadDir(name)
+	}
+	prefix := dirPrefix(key)
+	keys, err := p.keys(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if key != "" && len(keys) == 0 {
+		if _, err := p.Inner.Stat(name); err == nil {
+			return nil, NewNotDirectoryError(name)
+		}
+		return nil, NewNotFoundError("readdir", name)
+	}
+
+	// Keys deeper than the next "/" are grouped into one directory
+	var entries []FileInfo
+	dirs := make(map[string]bool)
+	for _, k := range keys {
+		rest := strings.TrimPrefix(k.Name, prefix)
+		if rest == "" {
+			// The directory's own marker
+			continue
+		}
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if !dirs[rest[:i]] {
+				dirs[rest[:i]] = true
+				entries = append(entries, *prefixDirInfo(rest[:i]))
+			}
+			continue
+		}
+		k.Name = rest
+		entries = append(entries, k)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Mkdir stores the directory's marker key, once its parent exists
+func (p *prefixDirsFS) Mkdir(name string, perm uint32) error {
+	key, ok := p.key(name)
+	if !ok {
+		return p.Inner.Mkdir(name, perm)
+	}
+	if key == "" {
+		return NewAlreadyExistsError("directory", name)
+	}
+	if _, err := p.Stat(name); err == nil {
+		return NewAlreadyExistsError("directory", name)
+	}
+	if parent := path.Dir(NormalizePath(name)); parent != p.root {
+		info, err := p.Stat(parent)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir {
+			return NewNotDirectoryError(parent)
+		}
+	}
+	_, err := p.Inner.Write(p.keyPath(key+"/"), nil, 0, WriteFlagCreate|WriteFlagTruncate)
+	return err
+}
+
+// MkdirAll implements MkdirAller through Mkdir, which stores the markers
+func (p *prefixDirsFS) MkdirAll(name string, perm uint32) error {
+	return mkdirAll(p, NormalizePath(name), perm)
+}
+
+// Remove removes a file, or an empty directory's marker
+func (p *prefixDirsFS) Remove(name string) error {
+	key, ok := p.key(name)
+	if !ok || key == "" {
+		return p.Inner.Remove(name)
+	}
+	if _, err := p.Inner.Stat(name); err == nil {
+		return p.Inner.Remove(name)
+	}
+	keys, err := p.keys(key + "/")
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return NewNotFoundError("remove", name)
+	}
+	if len(keys) > 1 || keys[0].Name != key+"/" {
+		return fmt.Errorf("directory not empty: %s", name)
+	}
+	return p.Inner.Remove(p.keyPath(key + "/"))
+}
+
+// RemoveAll removes a file, or every key under a directory
+func (p *prefixDirsFS) RemoveAll(name string) error {
+	key, ok := p.key(name)
+	if !ok {
+		return p.Inner.RemoveAll(name)
+	}
+	if key != "" {
+		if _, err := p.Inner.Stat(name); err == nil {
+			return p.Inner.Remove(name)
+		}
+	}
+	keys, err := p.keys(dirPrefix(key))
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if err := p.Inner.Remove(p.keyPath(k.Name)); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rename renames a file, or every key under a directory
+func (p *prefixDirsFS) Rename(oldPath, newPath string) error {
+	oldKey, oldOK := p.key(oldPath)
+	newKey, newOK := p.key(newPath)
+	if !oldOK || !newOK || oldKey == "" || newKey == "" {
+		return p.Inner.Rename(oldPath, newPath)
+	}
+	if _, err := p.Inner.Stat(oldPath); err == nil {
+		return p.Inner.Rename(oldPath, newPath)
+	}
+	keys, err := p.keys(oldKey + "/")
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return NewNotFoundError("rename", oldPath)
+	}
+	if strings.HasPrefix(newKey+"/", oldKey+"/") {
+		return NewInvalidArgumentError("newPath", newPath, "cannot move a directory into itself")
+	}
+	for _, k := range keys {
+		renamed := newKey + "/" + strings.TrimPrefix(k.Name, oldKey+"/")
+		if err := p.Inner.Rename(p.keyPath(k.Name), p.keyPath(renamed)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
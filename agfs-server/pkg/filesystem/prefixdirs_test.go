@@ -0,0 +1,167 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// flatFS is an object store: a flat keyspace listed whole under /objects
+type flatFS struct {
+	Wrapper
+	objects map[string]string
+}
+
+func (f *flatFS) key(path string) (string, error) {
+	if !strings.HasPrefix(path, "/objects/") {
+		return "", NewNotFoundError("key", path)
+	}
+	return strings.TrimPrefix(path, "/objects/"), nil
+}
+
+func (f *flatFS) Stat(path string) (*FileInfo, error) {
+	if path == "/" {
+		return &FileInfo{Name: "/", IsDir: true, Mode: 0755}, nil
+	}
+	key, err := f.key(path)
+	if err != nil {
+		return nil, err
+	}
+	data, ok := f.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("no such key: %s", key)
+	}
+	return &FileInfo{Name: key, Size: int64(len(data)), Mode: 0644}, nil
+}
+
+func (f *flatFS) ReadDir(path string) ([]FileInfo, error) {
+	var infos []FileInfo
+	for key, data := range f.objects {
+		infos = append(infos, FileInfo{Name: key, Size: int64(len(data)), Mode: 0644})
+	}
+	return infos, nil
+}
+
+func (f *flatFS) Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error) {
+	key, err := f.key(path)
+	if err != nil {
+		return 0, err
+	}
+	f.objects[key] = string(data)
+	return int64(len(data)), nil
+}
+
+func (f *flatFS) Remove(path string) error {
+	key, err := f.key(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.objects[key]; !ok {
+		return NewNotFoundError("remove", path)
+	}
+	delete(f.objects, key)
+	return nil
+}
+
+func (f *flatFS) Rename(oldPath, newPath string) error {
+	oldKey, err := f.key(oldPath)
+	if err != nil {
+		return err
+	}
+	newKey, err := f.key(newPath)
+	if err != nil {
+		return err
+	}
+	f.objects[newKey] = f.objects[oldKey]
+	delete(f.objects, oldKey)
+	return nil
+}
+
+func listNames(t *testing.T, fs FileSystem, path string) []string {
+	t.Helper()
+	entries, err := fs.ReadDir(path)
+	if err != nil {
+		t.Fatalf("ReadDir %s failed: %v", path, err)
+	}
+	var names []string
+	for _, e := range entries {
+		name := e.Name
+		if e.IsDir {
+			name += "/"
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestPrefixDirsTree(t *testing.T) {
+	flat := &flatFS{objects: map[string]string{
+		"top.txt":           "1",
+		"logs/a.log":        "2",
+		"logs/2024/b.log":   "3",
+		"logs/2024/c.log":   "4",
+		"data/deep/x/y.bin": "5",
+	}}
+	fs := PrefixDirs("/objects")(flat)
+
+	for dir, want := range map[string][]string{
+		"/objects":             {"data/", "logs/", "top.txt"},
+		"/objects/logs":        {"2024/", "a.log"},
+		"/objects/logs/2024":   {"b.log", "c.log"},
+		"/objects/data/deep/x": {"y.bin"},
+	} {
+		if got := listNames(t, fs, dir); !reflect.DeepEqual(got, want) {
+			t.Errorf("Expected %s to list %v, got %v", dir, want, got)
+		}
+	}
+	if info, err := fs.Stat("/objects/data/deep"); err != nil || !info.IsDir || info.Name != "deep" {
+		t.Errorf("Expected a prefix to stat as a directory, got %+v, %v", info, err)
+	}
+	if info, err := fs.Stat("/objects/logs/a.log"); err != nil || info.IsDir {
+		t.Errorf("Expected a key to stat as a file, got %+v, %v", info, err)
+	}
+	if _, err := fs.Stat("/objects/lo"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a partial prefix not found, got %v", err)
+	}
+	if _, err := fs.ReadDir("/objects/top.txt"); !errors.Is(err, ErrNotDirectory) {
+		t.Errorf("Expected listing a key to fail, got %v", err)
+	}
+
+	// Empty directories are kept by a marker key
+	if err := MkdirAll(fs, "/objects/new/sub", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	if _, ok := flat.objects["new/sub/"]; !ok {
+		t.Errorf("Expected a marker key, got %v", flat.objects)
+	}
+	if got := listNames(t, fs, "/objects/new/sub"); len(got) != 0 {
+		t.Errorf("Expected an empty directory, got %v", got)
+	}
+	if err := fs.Mkdir("/objects/missing/sub", 0755); err == nil {
+		t.Error("Expected mkdir without a parent to fail")
+	}
+	if err := fs.Remove("/objects/new"); err == nil {
+		t.Error("Expected removing a directory with entries to fail")
+	}
+	if err := fs.Remove("/objects/new/sub"); err != nil {
+		t.Errorf("Remove of an empty directory failed: %v", err)
+	}
+
+	// Directory operations apply to every key under the prefix
+	if err := fs.Rename("/objects/logs", "/objects/archive"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if got := listNames(t, fs, "/objects/archive/2024"); !reflect.DeepEqual(got, []string{"b.log", "c.log"}) {
+		t.Errorf("Expected the keys renamed, got %v", got)
+	}
+	if err := fs.RemoveAll("/objects/archive"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	for key := range flat.objects {
+		if strings.HasPrefix(key, "archive/") || strings.HasPrefix(key, "logs/") {
+			t.Errorf("Expected %s removed", key)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package filesystem
+
+import "io"
+
+// ReadFunc is a function that reads size bytes of path at offset.
+// This is typically a FileSystem's Read method.
+type ReadFunc func(path string, offset int64, size int64) ([]byte, error)
+
+// ChunkedReader is a generic io.ReadCloser that reads a file one chunk at a
+// time with a read function, so only one chunk is held in memory.
+// This is useful for filesystem implementations that don't support streaming reads.
+type ChunkedReader struct {
+	path      string
+	readFunc  ReadFunc
+	chunkSize int64
+	offset    int64
+	buf       []byte
+	eof       bool
+}
+
+// NewChunkedReader creates a new ChunkedReader that reads path from the
+// beginning in chunks of chunkSize bytes using the provided read function.
+func NewChunkedReader(path string, readFunc ReadFunc, chunkSize int64) *ChunkedReader {
+	return &ChunkedReader{
+		path:      path,
+		readFunc:  readFunc,
+		chunkSize: chunkSize,
+	}
+}
+
+// Read copies buffered data into p, reading the next chunk when the buffer is empty.
+func (r *ChunkedReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.fill(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// fill reads the next chunk into the buffer
+func (r *ChunkedReader) fill() error {
+	data, err := r.readFunc(r.path, r.offset, r.chunkSize)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	r.buf = data
+	r.offset += int64(len(data))
+
+	// A short chunk is the end of the file. A chunk larger than asked for
+	// means the read function ignores size and returned the rest of the file.
+	if err == io.EOF || int64(len(data)) != r.chunkSize {
+		r.eof = true
+	}
+	return nil
+}
+
+// Close releases the buffered chunk.
+func (r *ChunkedReader) Close() error {
+	r.buf = nil
+	r.eof = true
+	return nil
+}
+
+// Ensure ChunkedReader implements io.ReadCloser
+var _ io.ReadCloser = (*ChunkedReader)(nil)
@@ -0,0 +1,84 @@
+package filesystem
+
+import (
+	"io"
+)
+
+// ReadOnly is a Middleware rejecting every operation that would modify fs
+// with a PermissionDeniedError. Reads, listings and read-only handles pass
+// through.
+func ReadOnly(fs FileSystem) FileSystem {
+	return &readOnlyFS{Wrapper{Inner: fs}}
+}
+
+type readOnlyFS struct {
+	Wrapper
+}
+
+func denyWrite(op, path string) error {
+	return NewPermissionDeniedError(op, path, "read-only")
+}
+
+func (r *readOnlyFS) Create(path string) error {
+	return denyWrite("create", path)
+}
+
+func (r *readOnlyFS) Mkdir(path string, perm uint32) error {
+	return denyWrite("mkdir", path)
+}
+
+func (r *readOnlyFS) Remove(path string) error {
+	return denyWrite("remove", path)
+}
+
+func (r *readOnlyFS) RemoveAll(path string) error {
+	return denyWrite("removeall", path)
+}
+
+func (r *readOnlyFS) Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error) {
+	return 0, denyWrite("write", path)
+}
+
+func (r *readOnlyFS) Rename(oldPath, newPath string) error {
+	return denyWrite("rename", oldPath)
+}
+
+func (r *readOnlyFS) Chmod(path string, mode uint32) error {
+	return denyWrite("chmod", path)
+}
+
+func (r *readOnlyFS) OpenWrite(path string) (io.WriteCloser, error) {
+	return nil, denyWrite("openwrite", path)
+}
+
+func (r *readOnlyFS) Touch(path string) error {
+	return denyWrite("touch", path)
+}
+
+func (r *readOnlyFS) Truncate(path string, size int64) error {
+	return denyWrite("truncate", path)
+}
+
+func (r *readOnlyFS) MkdirAll(path string, perm uint32) error {
+	return denyWrite("mkdir", path)
+}
+
+func (r *readOnlyFS) Symlink(targetPath, linkPath string) error {
+	return denyWrite("symlink", linkPath)
+}
+
+func (r *readOnlyFS) Clone(src, dst string) error {
+	return denyWrite("clone", dst)
+}
+
+func (r *readOnlyFS) CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	return 0, denyWrite("copyrange", dst)
+}
+
+// OpenHandle only opens handles for reading
+func (r *readOnlyFS) OpenHandle(path string, flags OpenFlag, mode uint32) (FileHandle, error) {
+	if flags&(O_WRONLY|O_RDWR|O_APPEND|O_CREATE|O_TRUNC) != 0 {
+		return nil, denyWrite("open", path)
+	}
+	return r.Wrapper.OpenHandle(path, flags, mode)
+}
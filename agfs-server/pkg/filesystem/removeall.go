@@ -0,0 +1,143 @@
+package filesystem
+
+import (
+	"errors"
+	"path"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultRemoveAllWorkers is how many removals RemoveAllWalk runs at once.
+// Deleting a wide tree from a remote backend is bound by round trips, so a
+// few calls in flight go much faster than one.
+const DefaultRemoveAllWorkers = 8
+
+// RemoveAllWalk removes name and everything under it with ReadDir and Remove,
+// deepest entries first, running up to DefaultRemoveAllWorkers calls at once.
+// Entries ReadDir reports as symlinks are removed without being descended
+// into, as is name itself if fs can Readlink it.
+func RemoveAllWalk(fs FileSystem, name string) error {
+	return RemoveAllParallel(fs, name, DefaultRemoveAllWorkers)
+}
+
+// RemoveAllParallel is RemoveAllWalk with up to workers ReadDir and Remove
+// calls in flight (0 = DefaultRemoveAllWorkers, 1 = one at a time), so fs
+// must be safe for concurrent use. Files are removed concurrently, across
+// directories too, and a directory only once everything in it is gone.
+//
+// The first failure stops new calls: those in flight finish, and every
+// failure is returned, joined. A directory is never removed after one of its
+// entries failed to be, so what's left is a subtree of what was there.
+func RemoveAllParallel(fs FileSystem, name string, workers int) error {
+	if workers <= 0 {
+		workers = DefaultRemoveAllWorkers
+	}
+	name = NormalizePath(name)
+	if symlinker, ok := fs.(Symlinker); ok {
+		if _, err := symlinker.Readlink(name); err == nil {
+			return fs.Remove(name)
+		}
+	}
+
+	info, err := fs.Stat(name)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir {
+		return fs.Remove(name)
+	}
+
+	r := &remover{fs: fs, sem: make(chan struct{}, workers)}
+	r.removeDir(name)
+	switch len(r.errs) {
+	case 0:
+		return nil
+	case 1:
+		return r.errs[0]
+	default:
+		return errors.Join(r.errs...)
+	}
+}
+
+// remover holds the state of a single RemoveAllParallel
+type remover struct {
+	fs  FileSystem
+	sem chan struct{} // Holds a slot per call in flight
+
+	failed atomic.Bool // Set on the first failure, to stop new calls
+	mu     sync.Mutex
+	errs   []error
+}
+
+// fail records err and stops new calls
+func (r *remover) fail(err error) {
+	r.mu.Lock()
+	r.errs = append(r.errs, err)
+	r.mu.Unlock()
+	r.failed.Store(true)
+}
+
+// call runs op in a worker slot, reporting whether it succeeded. It doesn't
+// run op once the removal failed.
+func (r *remover) call(op func() error) bool {
+	if r.failed.Load() {
+		return false
+	}
+	r.sem <- struct{}{}
+	defer func() { <-r.sem }()
+	if r.failed.Load() {
+		return false
+	}
+	if err := op(); err != nil {
+		r.fail(err)
+		return false
+	}
+	return true
+}
+
+// removeDir removes everything under dir and then dir, reporting whether it
+// succeeded. Files are removed by goroutines started as slots free up, so a
+// wide directory doesn't start one per entry at once; subdirectories get a
+// goroutine each, which only holds a slot while calling fs.
+func (r *remover) removeDir(dir string) bool {
+	var entries []FileInfo
+	if !r.call(func() (err error) {
+		entries, err = r.fs.ReadDir(dir)
+		return err
+	}) {
+		return false
+	}
+
+	var wg sync.WaitGroup
+	var ok atomic.Bool
+	ok.Store(true)
+	for _, entry := range entries {
+		if r.failed.Load() {
+			ok.Store(false)
+			break
+		}
+		child := path.Join(dir, entry.Name)
+		wg.Add(1)
+		if entry.IsDir && entry.Meta.Type != "symlink" {
+			go func() {
+				defer wg.Done()
+				if !r.removeDir(child) {
+					ok.Store(false)
+				}
+			}()
+			continue
+		}
+		r.sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-r.sem }()
+			if err := r.fs.Remove(child); err != nil {
+				r.fail(err)
+				ok.Store(false)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return ok.Load() && r.call(func() error { return r.fs.Remove(dir) })
+}
@@ -0,0 +1,202 @@
+package filesystem
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// removeTestFS is a tree safe for concurrent use whose Remove fails for the
+// paths in fail and can take a while, like a remote backend
+type removeTestFS struct {
+	mu    sync.Mutex
+	dirs  map[string]bool // Every path, true for directories
+	fail  map[string]bool
+	delay time.Duration
+
+	inFlight, maxInFlight atomic.Int32
+}
+
+// newRemoveTestFS returns a tree under /t of width files and directories
+// per directory, depth levels deep
+func newRemoveTestFS(width, depth int) *removeTestFS {
+	f := &removeTestFS{dirs: map[string]bool{"/": true}, fail: map[string]bool{}}
+	var fill func(dir string, level int)
+	fill = func(dir string, level int) {
+		f.dirs[dir] = true
+		for i := 0; i < width; i++ {
+			f.dirs[fmt.Sprintf("%s/f%d", dir, i)] = false
+			if level < depth {
+				fill(fmt.Sprintf("%s/d%d", dir, i), level+1)
+			}
+		}
+	}
+	fill("/t", 1)
+	return f
+}
+
+func (f *removeTestFS) Create(path string) error             { return ErrNotSupported }
+func (f *removeTestFS) Mkdir(path string, perm uint32) error { return ErrNotSupported }
+func (f *removeTestFS) RemoveAll(path string) error          { return RemoveAllWalk(f, path) }
+func (f *removeTestFS) Rename(oldPath, newPath string) error { return ErrNotSupported }
+func (f *removeTestFS) Chmod(path string, mode uint32) error { return ErrNotSupported }
+
+func (f *removeTestFS) Read(path string, offset int64, size int64) ([]byte, error) {
+	return nil, ErrNotSupported
+}
+
+func (f *removeTestFS) Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (f *removeTestFS) Open(path string) (io.ReadCloser, error)       { return nil, ErrNotSupported }
+func (f *removeTestFS) OpenWrite(path string) (io.WriteCloser, error) { return nil, ErrNotSupported }
+
+func (f *removeTestFS) Remove(p string) error {
+	n := f.inFlight.Add(1)
+	for prev := f.maxInFlight.Load(); n > prev && !f.maxInFlight.CompareAndSwap(prev, n); prev = f.maxInFlight.Load() {
+	}
+	defer f.inFlight.Add(-1)
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	isDir, ok := f.dirs[p]
+	if !ok {
+		return NewNotFoundError("remove", p)
+	}
+	if f.fail[p] {
+		return NewPermissionDeniedError("remove", p, "test failure")
+	}
+	if isDir {
+		for other := range f.dirs {
+			if strings.HasPrefix(other, p+"/") {
+				return fmt.Errorf("directory not empty: %s", p)
+			}
+		}
+	}
+	delete(f.dirs, p)
+	return nil
+}
+
+func (f *removeTestFS) ReadDir(p string) ([]FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var entries []FileInfo
+	for other, isDir := range f.dirs {
+		if other != "/" && path.Dir(other) == p {
+			entries = append(entries, FileInfo{Name: path.Base(other), IsDir: isDir})
+		}
+	}
+	return entries, nil
+}
+
+func (f *removeTestFS) Stat(p string) (*FileInfo, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	isDir, ok := f.dirs[p]
+	if !ok {
+		return nil, NewNotFoundError("stat", p)
+	}
+	return &FileInfo{Name: path.Base(p), IsDir: isDir}, nil
+}
+
+// paths returns the paths left in the tree, sorted
+func (f *removeTestFS) paths() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var paths []string
+	for p := range f.dirs {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestRemoveAllParallel(t *testing.T) {
+	for _, workers := range []int{1, 4} {
+		f := newRemoveTestFS(4, 3)
+		f.delay = time.Millisecond
+		if err := RemoveAllParallel(f, "/t", workers); err != nil {
+			t.Fatalf("RemoveAllParallel with %d workers failed: %v", workers, err)
+		}
+		if paths := f.paths(); len(paths) != 1 {
+			t.Errorf("Expected only the root to be left, got %v", paths)
+		}
+		if max := f.maxInFlight.Load(); max > int32(workers) {
+			t.Errorf("Expected at most %d removals at once, got %d", workers, max)
+		}
+		if workers > 1 && f.maxInFlight.Load() < 2 {
+			t.Errorf("Expected removals to run concurrently with %d workers", workers)
+		}
+	}
+}
+
+func TestRemoveAllParallelFailure(t *testing.T) {
+	f := newRemoveTestFS(4, 2)
+	f.fail["/t/d1/f2"] = true
+	f.fail["/t/d3/f0"] = true
+
+	err := RemoveAllParallel(f, "/t", 4)
+	if !errors.Is(err, ErrPermissionDenied) {
+		t.Fatalf("Expected the failure to be reported, got %v", err)
+	}
+
+	// Whatever is left is a subtree: every path left has its parent, and
+	// the failed files and the directories leading to them are kept
+	left := make(map[string]bool)
+	for _, p := range f.paths() {
+		left[p] = true
+	}
+	for p := range left {
+		if p != "/" && !left[path.Dir(p)] {
+			t.Errorf("%s was left without its parent", p)
+		}
+	}
+	for _, p := range []string{"/t", "/t/d1", "/t/d1/f2"} {
+		if !left[p] {
+			t.Errorf("Expected %s to be kept", p)
+		}
+	}
+	if left["/t/d3"] && !left["/t/d3/f0"] {
+		t.Error("Expected /t/d3 to be kept only with its failed file")
+	}
+}
+
+func TestRemoveAllParallelFile(t *testing.T) {
+	f := newRemoveTestFS(1, 1)
+	if err := RemoveAllParallel(f, "/t/f0", 0); err != nil {
+		t.Fatalf("RemoveAllParallel of a file failed: %v", err)
+	}
+	if _, err := f.Stat("/t/f0"); err == nil {
+		t.Error("Expected the file to be removed")
+	}
+	if err := RemoveAllParallel(f, "/missing", 0); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing path to fail with not found, got %v", err)
+	}
+}
+
+// BenchmarkRemoveAllWide deletes a wide tree from a backend where every
+// call takes 100µs, one at a time and with the default workers
+func BenchmarkRemoveAllWide(b *testing.B) {
+	for _, workers := range []int{1, DefaultRemoveAllWorkers} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				f := newRemoveTestFS(30, 2)
+				f.delay = 100 * time.Microsecond
+				b.StartTimer()
+				if err := RemoveAllParallel(f, "/t", workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+package filesystem
+
+import "time"
+
+// SnapshotInfo describes a snapshot of a directory
+type SnapshotInfo struct {
+	Name    string    `json:"name"`
+	Path    string    `json:"path"` // The directory the snapshot is of
+	Created time.Time `json:"created"`
+}
+
+// Snapshotter is implemented by file systems that can take cheap
+// point-in-time copies of a directory, such as versioned object stores
+type Snapshotter interface {
+	// Snapshot takes a snapshot named name of the directory at path. It
+	// fails with an AlreadyExistsError if the directory has one by that
+	// name.
+	Snapshot(path, name string) error
+
+	// ListSnapshots returns the snapshots of the directory at path
+	ListSnapshots(path string) ([]SnapshotInfo, error)
+
+	// SnapshotFS returns a file system reading the snapshot name of the
+	// directory at path, with the directory as its root, or a
+	// NotFoundError if there is no such snapshot
+	SnapshotFS(path, name string) (FileSystem, error)
+}
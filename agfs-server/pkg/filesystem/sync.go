@@ -0,0 +1,541 @@
+package filesystem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// syncCheckpointEvery is the number of files Sync copies between writes of
+// its checkpoint
+const syncCheckpointEvery = 100
+
+// SyncOptions configures Sync
+type SyncOptions struct {
+	// Context cancels the sync (default context.Background())
+	Context context.Context
+
+	// Source and Dest are the roots of the trees synced in src and dst
+	// (default "/")
+	Source string
+	Dest   string
+
+	// Checkpoint is the path in dst of a file recording the files synced so
+	// far, written as the sync progresses and when it stops, so a sync
+	// interrupted and run again doesn't copy them again (empty = none).
+	// It is kept once the sync completes, so later syncs of the same trees
+	// use it too.
+	Checkpoint string
+
+	// DryRun reports what would be copied and deleted without changing dst
+	DryRun bool
+
+	// Delete deletes the paths under Dest that aren't under Source, and
+	// replaces files with directories and the other way round
+	Delete bool
+
+	// Concurrency is the number of files copied in parallel (default 4)
+	Concurrency int
+}
+
+// SyncFailure is a path Sync couldn't sync
+type SyncFailure struct {
+	Path string
+	Err  error
+}
+
+func (f SyncFailure) String() string {
+	return fmt.Sprintf("%s: %v", f.Path, f.Err)
+}
+
+// SyncReport is the result of Sync. Paths are paths in dst.
+type SyncReport struct {
+	Copied   []string      // Files and symlinks copied, or to copy with DryRun; sorted
+	Deleted  []string      // Paths deleted, or to delete with DryRun; sorted
+	UpToDate int           // Files and symlinks that were already in sync
+	Bytes    int64         // Bytes copied
+	Failed   []SyncFailure // Sorted by path
+}
+
+// OK reports whether every path was synced
+func (r *SyncReport) OK() bool {
+	return len(r.Failed) == 0
+}
+
+// syncEntry is a file of a sync checkpoint: the fingerprints of the source
+// and the copy when they were last in sync
+type syncEntry struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+}
+
+// syncCheckpoint is the file SyncOptions.Checkpoint names
+type syncCheckpoint struct {
+	Source string               `json:"source"`
+	Files  map[string]syncEntry `json:"files"` // By path relative to the roots
+}
+
+// Sync makes the tree under opts.Dest in dst a copy of the tree under
+// opts.Source in src, copying only the files that differ. A file is in sync
+// if the checkpoint records it with the etags (see MetaETag), or sizes,
+// modification times and modes when there are none, both copies have now;
+// files the checkpoint doesn't know are in sync if they have the same size
+// and dst's is no older. Directories are created and symlinks recreated as
+// needed, and permission bits are preserved where both file systems report
+// them. Files whose reads have side effects or stream, as a
+// CapabilityProvider reports, aren't copied.
+//
+// Paths that can't be synced are reported as failures without stopping the
+// sync, and nothing is deleted under a source directory that couldn't be
+// read. The returned error is non-nil only if the sync stops early, because
+// Source can't be read, the checkpoint can't be written or the context is
+// done; the report is returned with it.
+func Sync(src, dst FileSystem, opts SyncOptions) (*SyncReport, error) {
+	if opts.Context == nil {
+		opts.Context = context.Background()
+	}
+	if opts.Source == "" {
+		opts.Source = "/"
+	}
+	if opts.Dest == "" {
+		opts.Dest = "/"
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	s := &syncer{
+		src:     WithContext(src, opts.Context),
+		dst:     WithContext(dst, opts.Context),
+		opts:    opts,
+		srcRoot: NormalizePath(opts.Source),
+		dstRoot: NormalizePath(opts.Dest),
+		seen:    make(map[string]bool),
+		partial: make(map[string]bool),
+		report:  &SyncReport{},
+	}
+	if opts.Checkpoint != "" {
+		s.checkpoint = NormalizePath(opts.Checkpoint)
+	}
+	s.load()
+
+	files := make(chan syncFile)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for f := range files {
+				s.syncFile(f)
+			}
+		}()
+	}
+	err := WalkContext(opts.Context, s.src, s.srcRoot, func(p string, info *FileInfo, err error) error {
+		rel := strings.Trim(strings.TrimPrefix(p, s.srcRoot), "/")
+		target := path.Join(s.dstRoot, rel)
+		if err != nil {
+			if info == nil {
+				return fmt.Errorf("stat %s: %w", p, err)
+			}
+			s.partial[rel] = true
+			s.fail(target, err)
+			return nil
+		}
+		s.seen[rel] = true
+		if target == s.checkpoint {
+			return nil
+		}
+
+		switch {
+		case info.Meta.Type == "symlink":
+			s.syncSymlink(p, target)
+		case info.IsDir:
+			if err := s.syncDir(target, info); err != nil {
+				s.partial[rel] = true
+				s.fail(target, err)
+				return SkipDir
+			}
+		default:
+			select {
+			case files <- syncFile{src: p, dst: target, rel: rel, info: info}:
+			case <-opts.Context.Done():
+				return opts.Context.Err()
+			}
+		}
+		return nil
+	})
+	close(files)
+	wg.Wait()
+
+	if err == nil {
+		err = opts.Context.Err()
+	}
+	if err == nil && opts.Delete {
+		err = s.deleteExtraneous()
+	}
+	if err == nil {
+		// Forget the files no longer there
+		for rel := range s.cp.Files {
+			if !s.seen[rel] {
+				delete(s.cp.Files, rel)
+			}
+		}
+	}
+	s.mu.Lock()
+	if saveErr := s.save(); saveErr != nil && err == nil {
+		err = saveErr
+	}
+	s.mu.Unlock()
+
+	sort.Strings(s.report.Copied)
+	sort.Strings(s.report.Deleted)
+	sort.Slice(s.report.Failed, func(i, j int) bool { return s.report.Failed[i].Path < s.report.Failed[j].Path })
+	return s.report, err
+}
+
+// syncFile is a file of the source tree to sync
+type syncFile struct {
+	src, dst string
+	rel      string // Path relative to the roots
+	info     *FileInfo
+}
+
+// syncer holds the state of a single Sync
+type syncer struct {
+	src, dst         FileSystem
+	opts             SyncOptions
+	srcRoot, dstRoot string
+	checkpoint       string // Path of the checkpoint in dst ("" = none)
+
+	// seen holds the paths of the source tree relative to the roots, and
+	// partial those of its directories that couldn't be read whole; both
+	// are only used by the walk
+	seen    map[string]bool
+	partial map[string]bool
+
+	mu     sync.Mutex
+	cp     syncCheckpoint
+	copied int // Files copied since the checkpoint was last written
+	report *SyncReport
+}
+
+func (s *syncer) fail(p string, err error) {
+	s.mu.Lock()
+	s.report.Failed = append(s.report.Failed, SyncFailure{Path: p, Err: err})
+	s.mu.Unlock()
+}
+
+// syncDir creates the directory target if needed and gives it the mode of
+// info
+func (s *syncer) syncDir(target string, info *FileInfo) error {
+	// Like MkdirAll, take a path that can't be stat'ed as missing
+	if existing, err := Lstat(s.dst, target); err == nil {
+		if existing.IsDir && existing.Meta.Type != "symlink" {
+			return s.chmod(target, info, existing)
+		}
+		if err := s.replace(target, existing); err != nil {
+			return err
+		}
+	}
+	if s.opts.DryRun {
+		return nil
+	}
+	mode := info.Mode & ModePerm
+	if mode == 0 {
+		mode = 0755
+	}
+	if target == s.dstRoot {
+		return MkdirAll(s.dst, target, mode)
+	}
+	return s.dst.Mkdir(target, mode)
+}
+
+// syncFile copies f unless its copy is in sync
+func (s *syncer) syncFile(f syncFile) {
+	if s.opts.Context.Err() != nil {
+		return
+	}
+	if provider, ok := s.src.(CapabilityProvider); ok {
+		caps := provider.GetPathCapabilities(f.src)
+		if caps.IsReadDestructive || caps.IsBroadcast || caps.SupportsStreamRead {
+			s.fail(f.dst, NewNotSupportedError("sync", f.src))
+			return
+		}
+	}
+
+	existing, err := Lstat(s.dst, f.dst)
+	if err != nil {
+		existing = nil
+	} else if existing.IsDir || existing.Meta.Type == "symlink" {
+		if err := s.replace(f.dst, existing); err != nil {
+			s.fail(f.dst, err)
+			return
+		}
+		existing = nil
+	}
+
+	srcPrint := fingerprint(f.info)
+	if existing != nil && s.inSync(f.rel, srcPrint, f.info, existing) {
+		if err := s.chmod(f.dst, f.info, existing); err != nil {
+			s.fail(f.dst, err)
+			return
+		}
+		s.synced(f.rel, srcPrint, f.dst, false)
+		return
+	}
+	if s.opts.DryRun {
+		s.mu.Lock()
+		s.report.Copied = append(s.report.Copied, f.dst)
+		s.mu.Unlock()
+		return
+	}
+
+	n, err := s.copyFile(f)
+	s.mu.Lock()
+	s.report.Bytes += n
+	s.mu.Unlock()
+	if err != nil {
+		s.fail(f.dst, err)
+		return
+	}
+	s.synced(f.rel, srcPrint, f.dst, true)
+}
+
+// copyFile copies the content and mode of f, returning the bytes copied
+func (s *syncer) copyFile(f syncFile) (int64, error) {
+	r, err := s.src.Open(f.src)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	cr := &countingReader{r: r}
+	if err := importFile(s.dst, f.dst, cr); err != nil {
+		return cr.n, err
+	}
+	if mode := f.info.Mode & ModePerm; mode != 0 {
+		if err := s.dst.Chmod(f.dst, mode); err != nil && !errors.Is(err, ErrNotSupported) {
+			return cr.n, err
+		}
+	}
+	return cr.n, nil
+}
+
+// inSync reports whether existing, the copy of the file rel in dst, is in
+// sync with the source file info, whose fingerprint is srcPrint
+func (s *syncer) inSync(rel, srcPrint string, info, existing *FileInfo) bool {
+	s.mu.Lock()
+	entry, ok := s.cp.Files[rel]
+	s.mu.Unlock()
+	if ok {
+		return entry.Src == srcPrint && entry.Dst == fingerprint(existing)
+	}
+	return existing.Size == info.Size && !info.ModTime.After(existing.ModTime)
+}
+
+// synced records the file rel as in sync with its copy target, writing the
+// checkpoint every syncCheckpointEvery files copied
+func (s *syncer) synced(rel, srcPrint, target string, copied bool) {
+	var dstPrint string
+	if !s.opts.DryRun && s.checkpoint != "" {
+		info, err := s.dst.Stat(target)
+		if err != nil {
+			s.fail(target, err)
+			return
+		}
+		dstPrint = fingerprint(info)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !copied {
+		s.report.UpToDate++
+	} else {
+		s.report.Copied = append(s.report.Copied, target)
+		s.copied++
+	}
+	if dstPrint == "" {
+		return
+	}
+	s.cp.Files[rel] = syncEntry{Src: srcPrint, Dst: dstPrint}
+	if s.copied >= syncCheckpointEvery {
+		if err := s.save(); err != nil {
+			s.report.Failed = append(s.report.Failed, SyncFailure{Path: s.checkpoint, Err: err})
+		}
+	}
+}
+
+// syncSymlink makes target a symlink to where the symlink p points
+func (s *syncer) syncSymlink(p, target string) {
+	srcLinker, ok := s.src.(Symlinker)
+	dstLinker, dstOK := s.dst.(Symlinker)
+	if !ok || !dstOK {
+		s.fail(target, NewNotSupportedError("symlink", target))
+		return
+	}
+	link, err := srcLinker.Readlink(p)
+	if err != nil {
+		s.fail(target, err)
+		return
+	}
+	if existing, err := dstLinker.Readlink(target); err == nil && existing == link {
+		s.mu.Lock()
+		s.report.UpToDate++
+		s.mu.Unlock()
+		return
+	}
+
+	if existing, err := Lstat(s.dst, target); err == nil {
+		if err := s.replace(target, existing); err != nil {
+			s.fail(target, err)
+			return
+		}
+	}
+	if !s.opts.DryRun {
+		if err := dstLinker.Symlink(link, target); err != nil {
+			s.fail(target, err)
+			return
+		}
+	}
+	s.mu.Lock()
+	s.report.Copied = append(s.report.Copied, target)
+	s.mu.Unlock()
+}
+
+// replace deletes existing, which is in the way of a path of another type
+// at target, if opts.Delete allows
+func (s *syncer) replace(target string, existing *FileInfo) error {
+	isDir := existing.IsDir && existing.Meta.Type != "symlink"
+	if !s.opts.Delete {
+		switch {
+		case isDir:
+			return NewAlreadyExistsError("directory", target)
+		case existing.Meta.Type == "symlink":
+			return NewAlreadyExistsError("symlink", target)
+		default:
+			return NewAlreadyExistsError("file", target)
+		}
+	}
+	return s.delete(target, isDir)
+}
+
+// delete deletes target, recursively if it's a directory
+func (s *syncer) delete(target string, isDir bool) error {
+	if !s.opts.DryRun {
+		var err error
+		if isDir {
+			err = s.dst.RemoveAll(target)
+		} else {
+			err = s.dst.Remove(target)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	s.mu.Lock()
+	s.report.Deleted = append(s.report.Deleted, target)
+	s.mu.Unlock()
+	return nil
+}
+
+// deleteExtraneous deletes the paths under dstRoot that aren't under
+// srcRoot, except under source directories that couldn't be read
+func (s *syncer) deleteExtraneous() error {
+	return WalkContext(s.opts.Context, s.dst, s.dstRoot, func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			if info == nil {
+				// The destination root isn't there in a dry run
+				return nil
+			}
+			s.fail(p, err)
+			return nil
+		}
+		rel := strings.Trim(strings.TrimPrefix(p, s.dstRoot), "/")
+		isDir := info.IsDir && info.Meta.Type != "symlink"
+		switch {
+		case p == s.checkpoint || (s.seen[rel] && !s.partial[rel]):
+			return nil
+		case s.seen[rel]:
+			return SkipDir
+		}
+		if err := s.delete(p, isDir); err != nil {
+			s.fail(p, err)
+		}
+		if isDir {
+			return SkipDir
+		}
+		return nil
+	})
+}
+
+// chmod gives target, whose info is existing, the permission bits of info
+// if both report them and they differ
+func (s *syncer) chmod(target string, info, existing *FileInfo) error {
+	mode := info.Mode & ModePerm
+	if s.opts.DryRun || mode == 0 || existing.Mode&ModePerm == 0 || existing.Mode&ModePerm == mode {
+		return nil
+	}
+	if err := s.dst.Chmod(target, mode); err != nil && !errors.Is(err, ErrNotSupported) {
+		return err
+	}
+	return nil
+}
+
+// load reads the checkpoint, starting afresh if there is none, it can't be
+// read or it was written for another source. That only costs copying files
+// again.
+func (s *syncer) load() {
+	s.cp = syncCheckpoint{Source: s.srcRoot, Files: make(map[string]syncEntry)}
+	if s.checkpoint == "" {
+		return
+	}
+	data, err := s.dst.Read(s.checkpoint, 0, -1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return
+	}
+	var cp syncCheckpoint
+	if json.Unmarshal(data, &cp) == nil && cp.Source == s.srcRoot && cp.Files != nil {
+		s.cp = cp
+	}
+}
+
+// save writes the checkpoint. The caller holds mu.
+func (s *syncer) save() error {
+	if s.checkpoint == "" || s.opts.DryRun {
+		return nil
+	}
+	s.copied = 0
+	data, err := json.Marshal(&s.cp)
+	if err != nil {
+		return err
+	}
+	if _, err := s.dst.Write(s.checkpoint, data, 0, WriteFlagCreate|WriteFlagTruncate); err != nil {
+		return fmt.Errorf("write checkpoint %s: %w", s.checkpoint, err)
+	}
+	return nil
+}
+
+// fingerprint identifies the content of the file info describes: its etag,
+// or one made of its modification time, size and mode if it has none
+func fingerprint(info *FileInfo) string {
+	if etag := info.Meta.Content[MetaETag]; etag != "" {
+		return etag
+	}
+	return AttrETag(info)
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
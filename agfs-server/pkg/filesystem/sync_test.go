@@ -0,0 +1,183 @@
+package filesystem_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+)
+
+// newSyncTestFS returns a file system on a new temporary directory, and the
+// directory
+func newSyncTestFS(t *testing.T) (*localfs.LocalFS, string) {
+	t.Helper()
+	dir := t.TempDir()
+	fs, err := localfs.NewLocalFS(dir)
+	if err != nil {
+		t.Fatalf("NewLocalFS failed: %v", err)
+	}
+	return fs, dir
+}
+
+// openCountingFS counts the files opened for reading
+type openCountingFS struct {
+	filesystem.FileSystem
+	mu     sync.Mutex
+	opened []string
+}
+
+func (f *openCountingFS) Open(path string) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.opened = append(f.opened, path)
+	f.mu.Unlock()
+	return f.FileSystem.Open(path)
+}
+
+// cancellingFS cancels a context once it has been written n files
+type cancellingFS struct {
+	filesystem.FileSystem
+	skip   string // Not counted
+	n      int
+	cancel context.CancelFunc
+}
+
+func (f *cancellingFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	n, err := f.FileSystem.Write(path, data, offset, flags)
+	if path != f.skip && offset == 0 {
+		if f.n--; f.n == 0 {
+			f.cancel()
+		}
+	}
+	return n, err
+}
+
+func TestSyncResumes(t *testing.T) {
+	src, srcDir := newSyncTestFS(t)
+	dst, _ := newSyncTestFS(t)
+	if err := filesystem.MkdirAll(src, "/data/sub", 0750); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		p := fmt.Sprintf("/data/f%d", i)
+		if i >= 5 {
+			p = fmt.Sprintf("/data/sub/f%d", i)
+		}
+		if _, err := src.Write(p, []byte(p), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts := filesystem.SyncOptions{Source: "/data", Dest: "/copy", Checkpoint: "/checkpoint", Concurrency: 1}
+
+	// Interrupt the sync once 4 files are copied
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opts.Context = ctx
+	report, err := filesystem.Sync(src, &cancellingFS{FileSystem: dst, skip: "/checkpoint", n: 4, cancel: cancel}, opts)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected the sync cancelled, got %v", err)
+	}
+	if len(report.Copied) != 4 {
+		t.Fatalf("Expected 4 files copied before the interruption, got %v", report.Copied)
+	}
+	done := report.Copied
+
+	// Resuming copies the others only
+	opts.Context = nil
+	counting := &openCountingFS{FileSystem: src}
+	report, err = filesystem.Sync(counting, dst, opts)
+	if err != nil || !report.OK() {
+		t.Fatalf("Sync failed: %v, %v", err, report.Failed)
+	}
+	if len(report.Copied) != 6 || report.UpToDate != 4 {
+		t.Errorf("Expected 6 files copied and 4 up to date, got %v and %d", report.Copied, report.UpToDate)
+	}
+	for _, p := range done {
+		for _, opened := range counting.opened {
+			if "/copy"+opened[len("/data"):] == p {
+				t.Errorf("Expected %s not copied again", p)
+			}
+		}
+	}
+	if got, want := tarTree(t, dst, "/copy"), tarTree(t, src, "/data"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the copy\n%q\ngot\n%q", want, got)
+	}
+
+	// A change the sizes and times don't tell is found by the checkpoint
+	if _, err := src.Write("/data/f0", []byte("/DATA/F0"), 0, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filepath.Join(srcDir, "data", "f0"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	report, err = filesystem.Sync(src, dst, opts)
+	if err != nil || !reflect.DeepEqual(report.Copied, []string{"/copy/f0"}) || report.UpToDate != 9 {
+		t.Fatalf("Expected only /copy/f0 copied, got %v, %v", report.Copied, err)
+	}
+	if data, _ := dst.Read("/copy/f0", 0, -1); string(data) != "/DATA/F0" {
+		t.Errorf("Expected the changed content copied, got %q", data)
+	}
+}
+
+func TestSyncDelete(t *testing.T) {
+	src, _ := newSyncTestFS(t)
+	dst, _ := newSyncTestFS(t)
+	for _, p := range []string{"/keep", "/dir/file"} {
+		if err := filesystem.MkdirAll(src, "/dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := src.Write(p, []byte(p), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := src.Symlink("keep", "/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := filesystem.MkdirAll(dst, "/old/deep", 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"/stale", "/old/deep/file", "/dir/extra"} {
+		if err := filesystem.MkdirAll(dst, "/dir", 0755); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := dst.Write(p, []byte(p), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := tarTree(t, dst, "/")
+
+	opts := filesystem.SyncOptions{Delete: true, DryRun: true}
+	report, err := filesystem.Sync(src, dst, opts)
+	if err != nil || !report.OK() {
+		t.Fatalf("Sync failed: %v, %v", err, report.Failed)
+	}
+	wantCopied := []string{"/dir/file", "/keep", "/link"}
+	wantDeleted := []string{"/dir/extra", "/old", "/stale"}
+	if !reflect.DeepEqual(report.Copied, wantCopied) || !reflect.DeepEqual(report.Deleted, wantDeleted) {
+		t.Errorf("Expected %v copied and %v deleted, got %v and %v", wantCopied, wantDeleted, report.Copied, report.Deleted)
+	}
+	if after := tarTree(t, dst, "/"); !reflect.DeepEqual(after, before) {
+		t.Errorf("Expected a dry run to change nothing, got\n%q", after)
+	}
+
+	opts.DryRun = false
+	report, err = filesystem.Sync(src, dst, opts)
+	if err != nil || !report.OK() {
+		t.Fatalf("Sync failed: %v, %v", err, report.Failed)
+	}
+	if !reflect.DeepEqual(report.Copied, wantCopied) || !reflect.DeepEqual(report.Deleted, wantDeleted) {
+		t.Errorf("Expected %v copied and %v deleted, got %v and %v", wantCopied, wantDeleted, report.Copied, report.Deleted)
+	}
+	if got, want := tarTree(t, dst, "/"), tarTree(t, src, "/"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the copy\n%q\ngot\n%q", want, got)
+	}
+}
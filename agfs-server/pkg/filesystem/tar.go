@@ -0,0 +1,210 @@
+package filesystem
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// tarChunk is the size of the writes TarImport and Copy make
+const tarChunk = 1024 * 1024
+
+// TarExport writes the tree under root to w as a tar archive, with names
+// relative to root. Modes and modification times are kept, and symlinks are
+// stored as symlinks, never followed. It only uses Walk, Read and Readlink,
+// so it works with any file system.
+//
+// Files are streamed at the size Stat reports, except for those reporting
+// none, which are read whole to find it. A file changing size during the
+// export fails it.
+func TarExport(fs FileSystem, root string, w io.Writer) error {
+	root = NormalizePath(root)
+	tw := tar.NewWriter(w)
+	err := Walk(fs, root, func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root && info.IsDir {
+			return nil
+		}
+		name := path.Base(p)
+		if p != root {
+			name = strings.TrimPrefix(p, strings.TrimSuffix(root, "/")+"/")
+		}
+		return exportEntry(fs, tw, p, name, info)
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// exportEntry writes the tar entry of the path p, named name in the archive
+func exportEntry(fs FileSystem, tw *tar.Writer, p, name string, info *FileInfo) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    int64(info.Mode & ModePerm),
+		ModTime: info.ModTime,
+		Format:  tar.FormatPAX,
+	}
+	switch {
+	case info.Meta.Type == "symlink":
+		symlinker, ok := fs.(Symlinker)
+		if !ok {
+			return fmt.Errorf("%s: %w", p, ErrNotSupported)
+		}
+		target, err := symlinker.Readlink(p)
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = target
+		hdr.Mode = 0777
+		return tw.WriteHeader(hdr)
+	case info.IsDir:
+		hdr.Typeflag = tar.TypeDir
+		hdr.Name += "/"
+		return tw.WriteHeader(hdr)
+	}
+
+	hdr.Typeflag = tar.TypeReg
+	if info.Size <= 0 {
+		data, err := fs.Read(p, 0, -1)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+		hdr.Size = int64(len(data))
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	}
+
+	hdr.Size = info.Size
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	r, err := fs.Open(p)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if n, err := io.CopyN(tw, r, info.Size); err != nil {
+		if errors.Is(err, io.EOF) {
+			return fmt.Errorf("%s: file shrank to %d bytes during export", p, n)
+		}
+		return err
+	}
+	return nil
+}
+
+// TarImport creates the entries of the tar archive r under root, which is
+// created if missing, replacing files and symlinks that exist. It keeps the modes the
+// archive records for files and directories, and creates symlinks as
+// symlinks, which needs a Symlinker. Modification times aren't restored.
+//
+// Names are taken relative to root, and can't escape it. Hard links and
+// special files aren't supported and fail the import.
+func TarImport(fs FileSystem, root string, r io.Reader) error {
+	root = NormalizePath(root)
+	if err := MkdirAll(fs, root, 0755); err != nil {
+		return err
+	}
+	// Directories are chmodded last, so read-only ones can still be filled
+	dirModes := make(map[string]uint32)
+	var dirs []string
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		p := path.Join(root, path.Clean("/"+hdr.Name))
+		mode := uint32(hdr.Mode) & ModePerm
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := MkdirAll(fs, p, 0755); err != nil {
+				return err
+			}
+			if mode == 0 {
+				continue
+			}
+			if _, ok := dirModes[p]; !ok {
+				dirs = append(dirs, p)
+			}
+			dirModes[p] = mode
+		case tar.TypeSymlink:
+			symlinker, ok := fs.(Symlinker)
+			if !ok {
+				return fmt.Errorf("%s: %w", hdr.Name, ErrNotSupported)
+			}
+			if err := MkdirAll(fs, path.Dir(p), 0755); err != nil {
+				return err
+			}
+			if info, err := Lstat(fs, p); err == nil && !info.IsDir {
+				if err := fs.Remove(p); err != nil {
+					return err
+				}
+			}
+			if err := symlinker.Symlink(hdr.Linkname, p); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := MkdirAll(fs, path.Dir(p), 0755); err != nil {
+				return err
+			}
+			if err := importFile(fs, p, tr); err != nil {
+				return err
+			}
+			if mode != 0 {
+				if err := fs.Chmod(p, mode); err != nil {
+					return err
+				}
+			}
+		case tar.TypeXGlobalHeader:
+		default:
+			return fmt.Errorf("%s: unsupported tar entry type %q: %w", hdr.Name, hdr.Typeflag, ErrNotSupported)
+		}
+	}
+
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := fs.Chmod(dirs[i], dirModes[dirs[i]]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importFile writes the content of r to p in chunks, replacing the file
+func importFile(fs FileSystem, p string, r io.Reader) error {
+	buf := make([]byte, tarChunk)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 || offset == 0 {
+			flags := WriteFlagCreate
+			if offset == 0 {
+				flags |= WriteFlagTruncate
+			}
+			if _, werr := fs.Write(p, buf[:n], offset, flags); werr != nil {
+				return werr
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package filesystem_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+)
+
+// newTarTestFS returns a file system on a new temporary directory
+func newTarTestFS(t *testing.T) *localfs.LocalFS {
+	t.Helper()
+	fs, err := localfs.NewLocalFS(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalFS failed: %v", err)
+	}
+	return fs
+}
+
+// tarTree describes the tree under root, an entry per line
+func tarTree(t *testing.T, fs filesystem.FileSystem, root string) []string {
+	t.Helper()
+	var tree []string
+	err := filesystem.Walk(fs, root, func(p string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := strings.TrimPrefix(p, root)
+		switch {
+		case info.Meta.Type == "symlink":
+			target, err := fs.(filesystem.Symlinker).Readlink(p)
+			if err != nil {
+				return err
+			}
+			tree = append(tree, fmt.Sprintf("%s -> %s", name, target))
+		case info.IsDir:
+			tree = append(tree, fmt.Sprintf("%s/ %o", name, info.Mode))
+		default:
+			data, err := fs.Read(p, 0, -1)
+			if err != nil && !errors.Is(err, io.EOF) {
+				return err
+			}
+			tree = append(tree, fmt.Sprintf("%s %o %q", name, info.Mode, data))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk %s failed: %v", root, err)
+	}
+	return tree
+}
+
+func TestTarRoundTrip(t *testing.T) {
+	src := newTarTestFS(t)
+	for _, dir := range []string{"/src/a/b", "/src/empty", "/src/locked"} {
+		if err := filesystem.MkdirAll(src, dir, 0755); err != nil {
+			t.Fatalf("MkdirAll %s failed: %v", dir, err)
+		}
+	}
+	big := bytes.Repeat([]byte("0123456789"), 300*1024)
+	for name, data := range map[string][]byte{
+		"/src/top.txt":       []byte("top\n"),
+		"/src/a/b/deep.txt":  []byte("deep\n"),
+		"/src/a/big":         big,
+		"/src/a/zero":        nil,
+		"/src/locked/in.txt": []byte("in\n"),
+		"/src/a/b/script.sh": []byte("#!/bin/sh\n"),
+	} {
+		if _, err := src.Write(name, data, 0, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	for p, mode := range map[string]uint32{"/src/a/b/script.sh": 0755, "/src/top.txt": 0600, "/src/locked": 0500} {
+		if err := src.Chmod(p, mode); err != nil {
+			t.Fatalf("Chmod %s failed: %v", p, err)
+		}
+	}
+	t.Cleanup(func() { src.Chmod("/src/locked", 0755) })
+	for link, target := range map[string]string{
+		"/src/link":     "a/b/deep.txt",
+		"/src/a/up":     "..",
+		"/src/dangling": "/nowhere",
+	} {
+		if err := src.Symlink(target, link); err != nil {
+			t.Fatalf("Symlink %s failed: %v", link, err)
+		}
+	}
+
+	var archive bytes.Buffer
+	if err := filesystem.TarExport(src, "/src", &archive); err != nil {
+		t.Fatalf("TarExport failed: %v", err)
+	}
+
+	// Symlinks are archived as such, with the times of the files
+	tr := tar.NewReader(bytes.NewReader(archive.Bytes()))
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Reading the archive failed: %v", err)
+		}
+		names = append(names, hdr.Name)
+		if hdr.Name == "a/up" && (hdr.Typeflag != tar.TypeSymlink || hdr.Linkname != "..") {
+			t.Errorf("Expected a/up archived as a symlink to .., got %+v", hdr)
+		}
+		if hdr.Name == "top.txt" && time.Since(hdr.ModTime) > time.Hour {
+			t.Errorf("Expected the modification time of top.txt, got %v", hdr.ModTime)
+		}
+	}
+	if !sort.StringsAreSorted(names) || len(names) != 13 {
+		t.Errorf("Expected the 13 entries in walk order, got %q", names)
+	}
+
+	dst := newTarTestFS(t)
+	if err := filesystem.TarImport(dst, "/restored/here", bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("TarImport failed: %v", err)
+	}
+	t.Cleanup(func() { dst.Chmod("/restored/here/locked", 0755) })
+	want, got := tarTree(t, src, "/src"), tarTree(t, dst, "/restored/here")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Imported tree differs:\n got %q\nwant %q", got, want)
+	}
+
+	// Importing again replaces the files and symlinks
+	if err := dst.Chmod("/restored/here/locked", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dst.Write("/restored/here/top.txt", []byte("changed and longer\n"), 0, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatal(err)
+	}
+	if err := dst.Remove("/restored/here/link"); err != nil {
+		t.Fatal(err)
+	}
+	if err := filesystem.TarImport(dst, "/restored/here", bytes.NewReader(archive.Bytes())); err != nil {
+		t.Fatalf("Importing again failed: %v", err)
+	}
+	if got := tarTree(t, dst, "/restored/here"); !reflect.DeepEqual(got, want) {
+		t.Errorf("Imported tree differs:\n got %q\nwant %q", got, want)
+	}
+}
+
+func TestTarExportFile(t *testing.T) {
+	fs := newTarTestFS(t)
+	if _, err := fs.Write("/one.txt", []byte("one\n"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	if err := filesystem.TarExport(fs, "/one.txt", &archive); err != nil {
+		t.Fatalf("TarExport failed: %v", err)
+	}
+	hdr, err := tar.NewReader(&archive).Next()
+	if err != nil || hdr.Name != "one.txt" || hdr.Size != 4 {
+		t.Errorf("Expected one.txt alone, got %+v (%v)", hdr, err)
+	}
+
+	if err := filesystem.TarExport(fs, "/missing", io.Discard); err == nil {
+		t.Errorf("Expected a missing root to fail, got %v", err)
+	}
+}
+
+func TestTarImportRejects(t *testing.T) {
+	fs := newTarTestFS(t)
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for _, hdr := range []*tar.Header{
+		{Name: "../../escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 0},
+		{Name: "hard", Typeflag: tar.TypeLink, Linkname: "escape.txt"},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	err := filesystem.TarImport(fs, "/root", &archive)
+	if !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected a hard link to be unsupported, got %v", err)
+	}
+	if _, err := fs.Stat("/root/escape.txt"); err != nil {
+		t.Errorf("Expected the escaping name kept under root: %v", err)
+	}
+}
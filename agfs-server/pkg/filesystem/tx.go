@@ -0,0 +1,38 @@
+package filesystem
+
+import "errors"
+
+// ErrTxDone is returned by the operations of a transaction already
+// committed or rolled back
+var ErrTxDone = errors.New("transaction already committed or rolled back")
+
+// Tx is a transaction of a Transactor. Operations staged on it change
+// nothing until Commit, which applies them all at once: other readers see
+// either none of them or every one. If any staged operation fails at
+// Commit, none are applied.
+type Tx interface {
+	// Write stages writing data to path, as FileSystem.Write does
+	Write(path string, data []byte, offset int64, flags WriteFlag) (int64, error)
+
+	// Remove stages removing a file or empty directory, as
+	// FileSystem.Remove does
+	Remove(path string) error
+
+	// Rename stages renaming oldPath to newPath, as FileSystem.Rename does
+	Rename(oldPath, newPath string) error
+
+	// Commit applies the staged operations in order, atomically. It fails
+	// with the error of the first operation that fails, leaving the file
+	// system as it was.
+	Commit() error
+
+	// Rollback discards the staged operations
+	Rollback() error
+}
+
+// Transactor is implemented by file systems that can apply several changes
+// atomically, such as renaming a file while updating an index of it
+type Transactor interface {
+	// BeginTx starts a transaction
+	BeginTx() (Tx, error)
+}
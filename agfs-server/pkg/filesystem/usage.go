@@ -0,0 +1,17 @@
+package filesystem
+
+// Usage is how much a file system holds
+type Usage struct {
+	Bytes       int64 `json:"bytes"`       // Total size of the files
+	Files       int64 `json:"files"`       // Regular files
+	Directories int64 `json:"directories"` // Directories, the root included
+	Symlinks    int64 `json:"symlinks"`    // Symlinks, counted as links rather than their targets
+	Inodes      int64 `json:"inodes"`      // Files, directories and symlinks
+}
+
+// UsageReporter is implemented by file systems that know how much they hold
+// without being walked, as statfs does. They may leave the counts they don't
+// know at 0.
+type UsageReporter interface {
+	Usage() (*Usage, error)
+}
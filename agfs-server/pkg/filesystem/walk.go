@@ -0,0 +1,133 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"path"
+	"sort"
+)
+
+// SkipDir can be returned by a WalkFunc to skip the directory it was called
+// for. Returned for a file, it skips the remaining entries of the file's
+// directory.
+var SkipDir = errors.New("skip this directory")
+
+// SkipAll can be returned by a WalkFunc to stop the walk without an error
+var SkipAll = errors.New("skip everything and stop the walk")
+
+// WalkFunc is called by Walk for each path visited, with the path's info
+// as Lstat reports it. Symlinks are passed to it but never descended into.
+//
+// If a directory can't be read, fn is called a second time for it with the
+// ReadDir error; if root can't be read, fn is called with a nil info. Any
+// error fn returns other than SkipDir and SkipAll stops the walk and is
+// returned by Walk.
+type WalkFunc func(path string, info *FileInfo, err error) error
+
+// Walk walks the tree under root in lexical order, calling fn for root and
+// every path below it. It only uses ReadDir, Stat and Readlink, so it works
+// with any file system.
+func Walk(fs FileSystem, root string, fn WalkFunc) error {
+	return WalkContext(context.Background(), fs, root, fn)
+}
+
+// WalkContext is Walk, stopping with ctx's error once ctx is done. File
+// systems implementing ContextBinder also abort the operation in progress.
+func WalkContext(ctx context.Context, fs FileSystem, root string, fn WalkFunc) error {
+	w := &walker{ctx: ctx, fs: WithContext(fs, ctx), fn: fn, visited: make(map[string]bool)}
+	root = NormalizePath(root)
+
+	var err error
+	info, lerr := Lstat(w.fs, root)
+	if lerr != nil {
+		err = fn(root, nil, lerr)
+	} else {
+		err = w.walk(root, info)
+	}
+	if err == SkipDir || err == SkipAll {
+		return nil
+	}
+	return err
+}
+
+// Lstat returns the info of name without following it if it's a symlink.
+// File systems report symlinks with a Meta.Type of "symlink"; for those that
+// report the target instead, a name Readlink resolves is a symlink too.
+func Lstat(fs FileSystem, name string) (*FileInfo, error) {
+	if symlinker, ok := fs.(Symlinker); ok {
+		if target, err := symlinker.Readlink(name); err == nil {
+			return &FileInfo{
+				Name: path.Base(name),
+				Size: int64(len(target)),
+				Mode: 0777,
+				Meta: MetaData{Type: "symlink"},
+			}, nil
+		}
+	}
+	return fs.Stat(name)
+}
+
+// walker holds the state of a single Walk
+type walker struct {
+	ctx context.Context
+	fs  FileSystem
+	fn  WalkFunc
+
+	// visited holds the directories already walked, so a backend that lists
+	// a directory inside itself can't send the walk round in a loop
+	visited map[string]bool
+}
+
+func (w *walker) walk(p string, info *FileInfo) error {
+	if err := w.ctx.Err(); err != nil {
+		return err
+	}
+	if !info.IsDir || info.Meta.Type == "symlink" {
+		return w.fn(p, info, nil)
+	}
+	if w.visited[p] {
+		return nil
+	}
+	w.visited[p] = true
+
+	if err := w.fn(p, info, nil); err != nil {
+		return err
+	}
+
+	entries, err := w.fs.ReadDir(p)
+	if err != nil {
+		if err := w.fn(p, info, err); err != nil && err != SkipDir {
+			return err
+		}
+		return nil
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Name == "" || entry.Name == "." || entry.Name == ".." {
+			continue
+		}
+		child := path.Join(p, entry.Name)
+
+		// A directory entry may be a symlink the backend lists as its target
+		if entry.IsDir && entry.Meta.Type != "symlink" {
+			if _, ok := w.fs.(Symlinker); ok {
+				if linfo, err := Lstat(w.fs, child); err == nil && linfo.Meta.Type == "symlink" {
+					entry = linfo
+				}
+			}
+		}
+
+		if err := w.walk(child, entry); err != nil {
+			if err == SkipDir {
+				if entry.IsDir && entry.Meta.Type != "symlink" {
+					continue
+				}
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package filesystem
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newWalkTestFS returns a tree with a subtree to prune and symlink cycles:
+// /a/loop is listed as the directory it points to, /a/up as a symlink
+func newWalkTestFS() *checkTestFS {
+	return &checkTestFS{
+		dirs: map[string][]FileInfo{
+			"/": {
+				{Name: "b", IsDir: true},
+				{Name: "a", IsDir: true},
+				{Name: "z.txt"},
+			},
+			"/a": {
+				{Name: "1.txt"},
+				{Name: "loop", IsDir: true},
+				{Name: "up", IsDir: true, Meta: MetaData{Type: "symlink"}},
+				{Name: "..", IsDir: true},
+			},
+			"/a/loop": {{Name: "1.txt"}, {Name: "loop", IsDir: true}},
+			"/b": {
+				{Name: "skip", IsDir: true},
+				{Name: "2.txt"},
+			},
+			"/b/skip": {{Name: "deep.txt"}},
+		},
+		stats: map[string]FileInfo{
+			"/": {Name: "/", IsDir: true},
+		},
+		links: map[string]string{
+			"/a/loop": "/a",
+			"/a/up":   "..",
+		},
+	}
+}
+
+func TestWalkPrunesAndSkipsSymlinks(t *testing.T) {
+	var visited []string
+	err := Walk(newWalkTestFS(), "/", func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, p)
+		if p == "/b/skip" {
+			return SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+
+	// The cycles through /a/loop and /a/up are reported but not followed
+	expected := []string{"/", "/a", "/a/1.txt", "/a/loop", "/a/up", "/b", "/b/2.txt", "/b/skip", "/z.txt"}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Expected %v, got %v", expected, visited)
+	}
+}
+
+func TestWalkSkipDirOnFileSkipsSiblings(t *testing.T) {
+	var visited []string
+	err := Walk(newWalkTestFS(), "/", func(p string, info *FileInfo, err error) error {
+		visited = append(visited, p)
+		if p == "/a/1.txt" {
+			return SkipDir
+		}
+		if p == "/b" {
+			return SkipAll
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	expected := []string{"/", "/a", "/a/1.txt", "/b"}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Expected %v, got %v", expected, visited)
+	}
+}
+
+func TestWalkErrors(t *testing.T) {
+	fs := newWalkTestFS()
+	delete(fs.dirs, "/b")
+
+	var readDirErr error
+	err := Walk(fs, "/", func(p string, info *FileInfo, err error) error {
+		if err != nil {
+			readDirErr = err
+			return SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the walk to go on past an unreadable directory, got %v", err)
+	}
+	if !errors.Is(readDirErr, ErrNotFound) {
+		t.Errorf("Expected the ReadDir error to be passed to fn, got %v", readDirErr)
+	}
+
+	errStop := errors.New("stop")
+	if err := Walk(fs, "/", func(p string, info *FileInfo, err error) error { return errStop }); err != errStop {
+		t.Errorf("Expected fn's error to stop the walk, got %v", err)
+	}
+
+	if err := Walk(fs, "/missing", func(p string, info *FileInfo, err error) error { return err }); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Expected a missing root to be reported, got %v", err)
+	}
+}
+
+func TestWalkContextCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	err := WalkContext(ctx, newWalkTestFS(), "/", func(p string, info *FileInfo, err error) error {
+		calls++
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the walk to stop after the first call, got %d calls", calls)
+	}
+}
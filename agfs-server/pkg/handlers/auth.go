@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// AuthMiddleware authenticates requests by bearer token, tokens mapping
+// each token accepted to the principal it authenticates. Each request is
+// served in the mount namespace of its principal, see
+// mountablefs.WithPrincipal; a token mapped to an empty principal is served
+// in the shared namespace, as administration needs. Requests without a
+// known token fail with 401, except health checks and probes, which are
+// served in the namespace of their token if they carry a known one. Without
+// tokens nothing is authenticated and every request is served in the shared
+// namespace.
+func AuthMiddleware(tokens map[string]string, next http.Handler) http.Handler {
+	if len(tokens) == 0 {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/v1/health" || r.URL.Path == "/healthz" || r.URL.Path == "/readyz" {
+			if principal, ok := authenticate(tokens, r); ok {
+				r = r.WithContext(mountablefs.WithPrincipal(r.Context(), principal))
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		principal, ok := authenticate(tokens, r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="agfs"`)
+			writeError(w, http.StatusUnauthorized, "missing or unknown bearer token")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(mountablefs.WithPrincipal(r.Context(), principal)))
+	})
+}
+
+// authenticate returns the principal of the bearer token of r. Every token
+// is compared in constant time, so the time taken doesn't tell how close a
+// guess is.
+func authenticate(tokens map[string]string, r *http.Request) (string, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return "", false
+	}
+	principal, found := "", false
+	for known, p := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(known)) == 1 {
+			principal, found = p, true
+		}
+	}
+	return principal, found
+}
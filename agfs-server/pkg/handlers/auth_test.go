@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newAuthServer(t *testing.T, tokens map[string]string) *httptest.Server {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	if err := mfs.MountPlugin("memfs", "/shared", map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to mount memfs: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	NewHandler(mfs, NewTrafficMonitor()).SetupRoutes(mux)
+	NewPluginHandler(mfs).SetupRoutes(mux)
+	server := httptest.NewServer(AuthMiddleware(tokens, mux))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func authRequest(t *testing.T, method, url, token, body string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	server := newAuthServer(t, map[string]string{"a-token": "alice", "b-token": "bob", "root-token": ""})
+
+	for _, token := range []string{"", "wrong"} {
+		resp := authRequest(t, http.MethodGet, server.URL+"/api/v1/mounts", token, "")
+		if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+			t.Errorf("Expected 401 with a challenge for token %q, got %d", token, resp.StatusCode)
+		}
+	}
+	if resp := authRequest(t, http.MethodGet, server.URL+"/api/v1/health", "", ""); resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected health checks unauthenticated, got %d", resp.StatusCode)
+	}
+
+	// Each principal mounts in its own namespace
+	for token, path := range map[string]string{"a-token": "/alice", "b-token": "/bob"} {
+		body := `{"fstype": "memfs", "path": "` + path + `", "config": {}}`
+		if resp := authRequest(t, http.MethodPost, server.URL+"/api/v1/mount", token, body); resp.StatusCode != http.StatusOK {
+			t.Fatalf("Mount of %s failed with %d", path, resp.StatusCode)
+		}
+	}
+
+	for token, want := range map[string]string{
+		"a-token":    "/alice /shared",
+		"b-token":    "/bob /shared",
+		"root-token": "/shared",
+	} {
+		resp := authRequest(t, http.MethodGet, server.URL+"/api/v1/mounts", token, "")
+		var list struct {
+			Mounts []struct {
+				Path string `json:"path"`
+			} `json:"mounts"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatalf("Failed to decode mounts: %v", err)
+		}
+		var paths []string
+		for _, m := range list.Mounts {
+			paths = append(paths, m.Path)
+		}
+		sort.Strings(paths)
+		if got := strings.Join(paths, " "); got != want {
+			t.Errorf("Expected the mounts %q for %s, got %q", want, token, got)
+		}
+	}
+
+	if resp := authRequest(t, http.MethodGet, server.URL+"/api/v1/stat?path=/alice", "b-token", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected alice's mount not found for bob, got %d", resp.StatusCode)
+	}
+}
+
+func TestAuthNamespacedCapabilities(t *testing.T) {
+	server := newAuthServer(t, map[string]string{"a-token": "alice", "b-token": "bob"})
+	body := `{"fstype": "memfs", "path": "/alice", "config": {}}`
+	if resp := authRequest(t, http.MethodPost, server.URL+"/api/v1/mount", "a-token", body); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Mount failed with %d", resp.StatusCode)
+	}
+
+	for token, want := range map[string]bool{"a-token": true, "b-token": false} {
+		resp := authRequest(t, http.MethodGet, server.URL+"/api/v1/capabilities", token, "")
+		var caps CapabilitiesResponse
+		if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+			t.Fatalf("Failed to decode capabilities: %v", err)
+		}
+		if _, ok := caps.Mounts["/alice"]; ok != want {
+			t.Errorf("Expected /alice listed for %s: %v, got %v", token, want, caps.Mounts)
+		}
+		if _, ok := caps.Mounts["/shared"]; !ok {
+			t.Errorf("Expected /shared listed for %s, got %v", token, caps.Mounts)
+		}
+	}
+}
+
+func TestAuthNamespacedReadiness(t *testing.T) {
+	server := newAuthServer(t, map[string]string{"a-token": "alice", "b-token": "bob"})
+	body := `{"fstype": "memfs", "path": "/alice", "config": {}}`
+	if resp := authRequest(t, http.MethodPost, server.URL+"/api/v1/mount", "a-token", body); resp.StatusCode != http.StatusOK {
+		t.Fatalf("Mount failed with %d", resp.StatusCode)
+	}
+
+	for token, want := range map[string]string{
+		"a-token": "/alice /shared",
+		"b-token": "/shared",
+		"":        "/shared",
+		"wrong":   "/shared",
+	} {
+		resp := authRequest(t, http.MethodGet, server.URL+"/readyz", token, "")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected ready for %q, got %d", token, resp.StatusCode)
+		}
+		var ready ReadinessResponse
+		if err := json.NewDecoder(resp.Body).Decode(&ready); err != nil {
+			t.Fatalf("Failed to decode readiness: %v", err)
+		}
+		var paths []string
+		for _, m := range ready.Mounts {
+			paths = append(paths, m.Path)
+		}
+		sort.Strings(paths)
+		if got := strings.Join(paths, " "); got != want {
+			t.Errorf("Expected the mounts %q for %q, got %q", want, token, got)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// MaxBatchOps is the most operations a batch request may carry
+const MaxBatchOps = 1024
+
+// BatchOp is an operation of a batch request. Params are the query
+// parameters and Body the JSON body of the operation's endpoint.
+type BatchOp struct {
+	Op     string            `json:"op"`
+	Params map[string]string `json:"params,omitempty"`
+	Body   json.RawMessage   `json:"body,omitempty"`
+}
+
+// BatchRequest is the body of POST /batch
+type BatchRequest struct {
+	Ops []BatchOp `json:"ops"`
+}
+
+// BatchResult is the outcome of an operation of a batch request: the status
+// and JSON body its endpoint would have answered with
+type BatchResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// BatchResponse is the response of POST /batch, with a result per operation
+// in the order of the request
+type BatchResponse struct {
+	Results []BatchResult `json:"results"`
+}
+
+// batchRoute is the endpoint an operation of a batch request runs as
+type batchRoute struct {
+	method string
+	handle func(h *Handler, w http.ResponseWriter, r *http.Request)
+}
+
+// batchRoutes are the operations a batch request may carry, by name. They
+// are the metadata operations, whose requests and responses are small;
+// reads and writes have endpoints of their own.
+var batchRoutes = map[string]batchRoute{
+	"stat":     {http.MethodGet, (*Handler).Stat},
+	"list":     {http.MethodGet, (*Handler).ListDirectory},
+	"readlink": {http.MethodGet, (*Handler).Readlink},
+	"create":   {http.MethodPost, (*Handler).CreateFile},
+	"mkdir":    {http.MethodPost, (*Handler).CreateDirectory},
+	"remove":   {http.MethodDelete, (*Handler).Delete},
+	"rename":   {http.MethodPost, (*Handler).Rename},
+	"chmod":    {http.MethodPost, (*Handler).Chmod},
+	"truncate": {http.MethodPost, (*Handler).Truncate},
+	"touch":    {http.MethodPost, (*Handler).Touch},
+	"symlink":  {http.MethodPost, (*Handler).Symlink},
+}
+
+// Batch handles POST /batch, running the operations of the request one
+// after the other, in order, as their own endpoints would. An operation
+// failing doesn't stop the following ones; its result carries the error.
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) {
+	var req BatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Ops) > MaxBatchOps {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("too many operations: %d (max %d)", len(req.Ops), MaxBatchOps))
+		return
+	}
+	for _, op := range req.Ops {
+		if _, ok := batchRoutes[op.Op]; !ok {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("unsupported batch operation: %q", op.Op))
+			return
+		}
+	}
+
+	response := BatchResponse{Results: make([]BatchResult, 0, len(req.Ops))}
+	for _, op := range req.Ops {
+		response.Results = append(response.Results, h.runBatchOp(r, op))
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// runBatchOp runs op as a request to its endpoint
+func (h *Handler) runBatchOp(r *http.Request, op BatchOp) BatchResult {
+	route := batchRoutes[op.Op]
+	query := url.Values{}
+	for k, v := range op.Params {
+		query.Set(k, v)
+	}
+	sub, err := http.NewRequestWithContext(r.Context(), route.method, "/?"+query.Encode(), bytes.NewReader(op.Body))
+	if err != nil {
+		return batchError(http.StatusBadRequest, err.Error())
+	}
+	sub.Header.Set("Content-Type", "application/json")
+
+	rec := &batchRecorder{header: make(http.Header)}
+	route.handle(h, rec, sub)
+	body := bytes.TrimSpace(rec.body.Bytes())
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	return BatchResult{Status: rec.status, Body: body}
+}
+
+func batchError(status int, message string) BatchResult {
+	body, _ := json.Marshal(ErrorResponse{Error: message})
+	return BatchResult{Status: status, Body: body}
+}
+
+// batchRecorder is the response writer of an operation of a batch request
+type batchRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *batchRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *batchRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *batchRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// postBatch sends ops as a batch request and decodes the response
+func postBatch(t *testing.T, url string, ops ...BatchOp) (int, BatchResponse) {
+	t.Helper()
+	body, _ := json.Marshal(BatchRequest{Ops: ops})
+	resp, err := http.Post(url+"/api/v1/batch", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Batch failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var batch BatchResponse
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatalf("Failed to decode batch response: %v", err)
+		}
+	}
+	return resp.StatusCode, batch
+}
+
+func TestBatch(t *testing.T) {
+	server := newTestServer(t)
+	status, batch := postBatch(t, server.URL,
+		BatchOp{Op: "mkdir", Params: map[string]string{"path": "/mem/dir"}},
+		BatchOp{Op: "create", Params: map[string]string{"path": "/mem/dir/a"}},
+		BatchOp{Op: "stat", Params: map[string]string{"path": "/mem/missing"}},
+		BatchOp{Op: "rename", Params: map[string]string{"path": "/mem/dir/a"}, Body: json.RawMessage(`{"newPath":"/mem/dir/b"}`)},
+		BatchOp{Op: "stat", Params: map[string]string{"path": "/mem/dir/b"}},
+		BatchOp{Op: "list", Params: map[string]string{"path": "/mem/dir"}},
+	)
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+
+	// Operations run in order, and a failure doesn't stop the following ones
+	want := []int{http.StatusCreated, http.StatusCreated, http.StatusNotFound, http.StatusOK, http.StatusOK, http.StatusOK}
+	if len(batch.Results) != len(want) {
+		t.Fatalf("Expected %d results, got %d", len(want), len(batch.Results))
+	}
+	for i, result := range batch.Results {
+		if result.Status != want[i] {
+			t.Errorf("Expected operation %d to answer %d, got %d: %s", i, want[i], result.Status, result.Body)
+		}
+	}
+	var info FileInfoResponse
+	if err := json.Unmarshal(batch.Results[4].Body, &info); err != nil || info.Name != "b" {
+		t.Errorf("Expected the stat of the renamed file, got %s (%v)", batch.Results[4].Body, err)
+	}
+	var list ListResponse
+	if err := json.Unmarshal(batch.Results[5].Body, &list); err != nil || len(list.Files) != 1 {
+		t.Errorf("Expected a listing of one file, got %s (%v)", batch.Results[5].Body, err)
+	}
+}
+
+func TestBatchRejected(t *testing.T) {
+	server := newTestServer(t)
+	if status, _ := postBatch(t, server.URL, BatchOp{Op: "read", Params: map[string]string{"path": "/mem/a"}}); status != http.StatusBadRequest {
+		t.Errorf("Expected an unsupported operation to be rejected, got %d", status)
+	}
+	ops := make([]BatchOp, MaxBatchOps+1)
+	for i := range ops {
+		ops[i] = BatchOp{Op: "stat", Params: map[string]string{"path": "/mem"}}
+	}
+	if status, _ := postBatch(t, server.URL, ops...); status != http.StatusBadRequest {
+		t.Errorf("Expected too many operations to be rejected, got %d", status)
+	}
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"errors"
+	"testing"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+func TestClone(t *testing.T) {
+	server := newTestServer(t)
+	client := agfs.NewClient(server.URL)
+
+	if _, err := client.Write("/mem/src", []byte("original")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := client.Clone("/mem/src", "/mem/dst"); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if _, err := client.Write("/mem/dst", []byte("modified")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	for p, want := range map[string]string{"/mem/src": "original", "/mem/dst": "modified"} {
+		if data, err := client.Read(p, 0, -1); err != nil || string(data) != want {
+			t.Errorf("Expected %s to read %q, got %q, %v", p, want, data, err)
+		}
+	}
+
+	if err := client.Clone("/mem/missing", "/mem/other"); !errors.Is(err, agfs.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	info, err := client.ServerInfo(t.Context())
+	if err != nil || !info.Supports(agfs.FeatureClone) {
+		t.Errorf("Expected the clone feature advertised, got %+v, %v", info, err)
+	}
+}
+
+func TestCopyRange(t *testing.T) {
+	server := newTestServer(t)
+	client := agfs.NewClient(server.URL)
+
+	if _, err := client.Write("/mem/src", []byte("original")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := client.Create("/mem/dst"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if n, err := client.CopyRange("/mem/src", 4, "/mem/dst", 2, 100); err != nil || n != 4 {
+		t.Fatalf("Expected 4 bytes copied, got %d, %v", n, err)
+	}
+	if data, err := client.Read("/mem/dst", 0, -1); err != nil || string(data) != "\x00\x00inal" {
+		t.Errorf("Expected the range at offset 2, got %q, %v", data, err)
+	}
+
+	if _, err := client.CopyRange("/mem/src", 0, "/mem/src", 2, 4); !errors.Is(err, agfs.ErrInvalidArgument) {
+		t.Errorf("Expected overlapping ranges to be rejected, got %v", err)
+	}
+	if _, err := client.CopyRange("/mem/missing", 0, "/mem/dst", 0, 4); !errors.Is(err, agfs.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	info, err := client.ServerInfo(t.Context())
+	if err != nil || !info.Supports(agfs.FeatureCopyRange) {
+		t.Errorf("Expected the copyrange feature advertised, got %+v, %v", info, err)
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestETagRevalidation(t *testing.T) {
+	server := newTestServer(t)
+
+	write := func(data string) {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/files?path=/mem/f", strings.NewReader(data))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		resp.Body.Close()
+	}
+	get := func(endpoint, etag string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+endpoint, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", endpoint, err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	write("one")
+	resp := get("/api/v1/stat?path=/mem/f", "")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || etag == "" {
+		t.Fatalf("Expected a stat with an ETag, got %d %q", resp.StatusCode, etag)
+	}
+
+	for _, endpoint := range []string{"/api/v1/stat?path=/mem/f", "/api/v1/files?path=/mem/f"} {
+		if resp := get(endpoint, etag); resp.StatusCode != http.StatusNotModified {
+			t.Errorf("%s: expected 304 for an unchanged file, got %d", endpoint, resp.StatusCode)
+		}
+		if resp := get(endpoint, `"other", W/`+etag); resp.StatusCode != http.StatusNotModified {
+			t.Errorf("%s: expected a weak match in a list to match, got %d", endpoint, resp.StatusCode)
+		}
+	}
+
+	write("two!")
+	for _, endpoint := range []string{"/api/v1/stat?path=/mem/f", "/api/v1/files?path=/mem/f"} {
+		resp := get(endpoint, etag)
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected 200 for a changed file, got %d", endpoint, resp.StatusCode)
+		}
+		if got := resp.Header.Get("ETag"); got == "" || got == etag {
+			t.Errorf("%s: expected the new ETag, got %q", endpoint, got)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// eventsKeepAlive is how often an idle event stream gets a comment, so
+// clients can tell a quiet stream from a dead connection
+const eventsKeepAlive = 15 * time.Second
+
+// changeSubscriber is implemented by file systems that report the changes
+// made through them
+type changeSubscriber interface {
+	Subscribe(prefix string) *mountablefs.Subscription
+}
+
+// Events handles GET /events?path=<prefix>, streaming the changes made under
+// prefix (default "/") as server-sent events until the client goes away.
+// Each event is a JSON ChangeEvent. An event with op "reset" for the prefix
+// means events were dropped because the client fell behind, so everything
+// under it must be treated as changed.
+func (h *Handler) Events(w http.ResponseWriter, r *http.Request) {
+	subscriber, ok := h.fsFor(r).(changeSubscriber)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "change events not supported")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	prefix := r.URL.Query().Get("path")
+	if prefix == "" {
+		prefix = "/"
+	}
+
+	sub := subscriber.Subscribe(prefix)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // Keep proxies from buffering events
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventsKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-sub.C:
+			if sub.Lost() {
+				if err := writeEvent(w, mountablefs.ChangeEvent{Op: mountablefs.ChangeReset, Path: prefix}); err != nil {
+					return
+				}
+			}
+			if err := writeEvent(w, event); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes event as a server-sent event
+func writeEvent(w http.ResponseWriter, event mountablefs.ChangeEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: change\ndata: %s\n\n", data)
+	return err
+}
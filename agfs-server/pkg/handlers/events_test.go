@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+func TestEvents(t *testing.T) {
+	server := newTestServer(t)
+	resp, err := http.Get(server.URL + "/api/v1/events?path=/mem/dir")
+	if err != nil {
+		t.Fatalf("Subscribe failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("Expected an event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	for _, path := range []string{"/mem/other", "/mem/dir"} {
+		req, _ := http.NewRequest(http.MethodPost, server.URL+"/api/v1/directories?path="+path, nil)
+		r, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Mkdir failed: %v", err)
+		}
+		r.Body.Close()
+	}
+
+	events := make(chan mountablefs.ChangeEvent)
+	go func() {
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+				var event mountablefs.ChangeEvent
+				json.Unmarshal([]byte(data), &event)
+				events <- event
+			}
+		}
+	}()
+	select {
+	case event := <-events:
+		if event != (mountablefs.ChangeEvent{Op: "mkdir", Path: "/mem/dir"}) {
+			t.Errorf("Expected the mkdir of /mem/dir only, got %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the change event")
+	}
+}
+
+func TestEventsAdvertised(t *testing.T) {
+	server := newTestServer(t)
+	resp, err := http.Get(server.URL + "/api/v1/capabilities")
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var caps CapabilitiesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		t.Fatalf("Failed to decode capabilities: %v", err)
+	}
+	found := false
+	for _, f := range caps.Features {
+		found = found || f == "events"
+	}
+	if !found {
+		t.Errorf("Expected the events feature to be advertised, got %v", caps.Features)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	plugin := memfs.NewMemFSPlugin()
+	if err := plugin.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	if err := mfs.Mount("/mem", plugin); err != nil {
+		t.Fatalf("Failed to mount memfs: %v", err)
+	}
+
+	h := NewHandler(mfs, NewTrafficMonitor())
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// race sends the same POST from n goroutines at once and counts the status codes
+func race(t *testing.T, url string, n int) map[int]int {
+	t.Helper()
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	statuses := make(map[int]int)
+	start := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			resp, err := http.Post(url, "application/json", nil)
+			if err != nil {
+				t.Errorf("Request failed: %v", err)
+				return
+			}
+			resp.Body.Close()
+			mu.Lock()
+			statuses[resp.StatusCode]++
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+	return statuses
+}
+
+func TestCreateFileExclusiveRace(t *testing.T) {
+	server := newTestServer(t)
+
+	statuses := race(t, server.URL+"/api/v1/files?path=/mem/lock&exclusive=true", 20)
+	if statuses[http.StatusCreated] != 1 || statuses[http.StatusConflict] != 19 {
+		t.Errorf("Expected 1 created and 19 conflicts, got %v", statuses)
+	}
+}
+
+func TestOpenHandleExclusiveRace(t *testing.T) {
+	server := newTestServer(t)
+
+	// O_WRONLY|O_CREATE|O_EXCL
+	statuses := race(t, server.URL+"/api/v1/handles/open?path=/mem/lock&flags=49", 20)
+	if statuses[http.StatusOK]+statuses[http.StatusCreated] != 1 || statuses[http.StatusConflict] != 19 {
+		t.Errorf("Expected 1 opened and 19 conflicts, got %v", statuses)
+	}
+}
+
+func TestCreateFileNonExclusive(t *testing.T) {
+	server := newTestServer(t)
+
+	for i := 0; i < 2; i++ {
+		resp, err := http.Post(server.URL+"/api/v1/files?path=/mem/file", "application/json", nil)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusConflict {
+			t.Errorf("Expected plain create of an existing file not to conflict")
+		}
+	}
+}
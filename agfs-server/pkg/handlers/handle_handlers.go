@@ -82,9 +82,9 @@ func parseOpenFlags(flagStr string) (filesystem.OpenFlag, error) {
 }
 
 
-// getHandleFS checks if the filesystem supports HandleFS and returns it
-func (h *Handler) getHandleFS() (filesystem.HandleFS, error) {
-	handleFS, ok := h.fs.(filesystem.HandleFS)
+// getHandleFS checks if the filesystem of r supports HandleFS and returns it
+func (h *Handler) getHandleFS(r *http.Request) (filesystem.HandleFS, error) {
+	handleFS, ok := h.fsFor(r).(filesystem.HandleFS)
 	if !ok {
 		return nil, fmt.Errorf("filesystem does not support file handles")
 	}
@@ -93,7 +93,7 @@ func (h *Handler) getHandleFS() (filesystem.HandleFS, error) {
 
 // OpenHandle handles POST /api/v1/handles/open?path=<path>&flags=<flags>&mode=<mode>
 func (h *Handler) OpenHandle(w http.ResponseWriter, r *http.Request) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -144,7 +144,7 @@ func (h *Handler) OpenHandle(w http.ResponseWriter, r *http.Request) {
 
 // GetHandle handles GET /api/v1/handles/<id>
 func (h *Handler) GetHandle(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -178,7 +178,7 @@ func (h *Handler) GetHandle(w http.ResponseWriter, r *http.Request, handleIDStr
 
 // CloseHandle handles DELETE /api/v1/handles/<id>
 func (h *Handler) CloseHandle(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -201,7 +201,7 @@ func (h *Handler) CloseHandle(w http.ResponseWriter, r *http.Request, handleIDSt
 
 // HandleRead handles GET /api/v1/handles/<id>/read?offset=<offset>&size=<size>
 func (h *Handler) HandleRead(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -251,7 +251,8 @@ func (h *Handler) HandleRead(w http.ResponseWriter, r *http.Request, handleIDStr
 		buf := make([]byte, size)
 		n, err = handle.ReadAt(buf, offset)
 		if err != nil && err != io.EOF {
-			writeError(w, http.StatusInternalServerError, err.Error())
+			// Non-seekable handles report NotSupported (501)
+			writeError(w, mapErrorToStatus(err), err.Error())
 			return
 		}
 		data = buf[:n]
@@ -277,9 +278,9 @@ func (h *Handler) HandleRead(w http.ResponseWriter, r *http.Request, handleIDStr
 	w.Write(data)
 }
 
-// HandleWrite handles PUT /api/v1/handles/<id>/write?offset=<offset>
+// HandleWrite handles PUT /api/v1/handles/<id>/write?offset=<offset>&sync=<true>
 func (h *Handler) HandleWrite(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -332,6 +333,14 @@ func (h *Handler) HandleWrite(w http.ResponseWriter, r *http.Request, handleIDSt
 		}
 	}
 
+	// A durable write is acknowledged once synced
+	if r.URL.Query().Get("sync") == "true" {
+		if err := handle.Sync(); err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
 	response := HandleWriteResponse{
 		BytesWritten: n,
 	}
@@ -340,7 +349,7 @@ func (h *Handler) HandleWrite(w http.ResponseWriter, r *http.Request, handleIDSt
 
 // HandleSeek handles POST /api/v1/handles/<id>/seek?offset=<offset>&whence=<0|1|2>
 func (h *Handler) HandleSeek(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -395,7 +404,7 @@ func (h *Handler) HandleSeek(w http.ResponseWriter, r *http.Request, handleIDStr
 
 // HandleSync handles POST /api/v1/handles/<id>/sync
 func (h *Handler) HandleSync(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -424,7 +433,7 @@ func (h *Handler) HandleSync(w http.ResponseWriter, r *http.Request, handleIDStr
 
 // HandleStat handles GET /api/v1/handles/<id>/stat
 func (h *Handler) HandleStat(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
@@ -451,12 +460,15 @@ func (h *Handler) HandleStat(w http.ResponseWriter, r *http.Request, handleIDStr
 	}
 
 	response := FileInfoResponse{
-		Name:    info.Name,
-		Size:    info.Size,
-		Mode:    info.Mode,
-		ModTime: info.ModTime.Format(time.RFC3339Nano),
-		IsDir:   info.IsDir,
-		Meta:    info.Meta,
+		Name:      info.Name,
+		Size:      info.Size,
+		Mode:      info.Mode,
+		ModTime:   info.ModTime.Format(time.RFC3339Nano),
+		IsDir:     info.IsDir,
+		Meta:      info.Meta,
+		Ino:       info.Ino,
+		Blocks:    info.Blocks,
+		BlockSize: info.BlockSize,
 	}
 
 	writeJSON(w, http.StatusOK, response)
@@ -465,7 +477,7 @@ func (h *Handler) HandleStat(w http.ResponseWriter, r *http.Request, handleIDStr
 // HandleStream handles GET /api/v1/handles/<id>/stream - streaming read
 // Uses chunked transfer encoding for continuous data streaming
 func (h *Handler) HandleStream(w http.ResponseWriter, r *http.Request, handleIDStr string) {
-	handleFS, err := h.getHandleFS()
+	handleFS, err := h.getHandleFS(r)
 	if err != nil {
 		writeError(w, http.StatusNotImplemented, err.Error())
 		return
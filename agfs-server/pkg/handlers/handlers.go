@@ -3,6 +3,7 @@ package handlers
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"crypto/md5"
 	"encoding/hex"
 	"encoding/json"
@@ -13,6 +14,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -30,6 +32,16 @@ type Handler struct {
 	gitCommit      string
 	buildTime      string
 	trafficMonitor *TrafficMonitor
+
+	// locks holds the advisory locks taken by clients
+	locks *LockTable
+
+	// idempotency remembers writes applied with an idempotency key
+	idempotency *IdempotencyCache
+
+	// readiness reports mounts /readyz checks besides those of the file
+	// system, see AddReadinessCheck
+	readiness []func() []mountablefs.MountHealth
 }
 
 // NewHandler creates a new Handler
@@ -40,9 +52,17 @@ func NewHandler(fs filesystem.FileSystem, trafficMonitor *TrafficMonitor) *Handl
 		gitCommit:      "unknown",
 		buildTime:      "unknown",
 		trafficMonitor: trafficMonitor,
+		locks:          NewLockTable(DefaultLockTTL),
+		idempotency:    NewIdempotencyCache(DefaultIdempotencyWindow),
 	}
 }
 
+// fsFor returns the file system bound to r's context, so plugins that support
+// it stop working on a request once the client has gone away
+func (h *Handler) fsFor(r *http.Request) filesystem.FileSystem {
+	return filesystem.WithContext(h.fs, r.Context())
+}
+
 // SetVersionInfo sets the version information for the handler
 func (h *Handler) SetVersionInfo(version, gitCommit, buildTime string) {
 	h.version = version
@@ -62,17 +82,21 @@ type SuccessResponse struct {
 
 // FileInfoResponse represents file info response
 type FileInfoResponse struct {
-	Name    string              `json:"name"`
-	Size    int64               `json:"size"`
-	Mode    uint32              `json:"mode"`
-	ModTime string              `json:"modTime"`
-	IsDir   bool                `json:"isDir"`
-	Meta    filesystem.MetaData `json:"meta,omitempty"` // Structured metadata
+	Name      string              `json:"name"`
+	Size      int64               `json:"size"`
+	Mode      uint32              `json:"mode"`
+	ModTime   string              `json:"modTime"`
+	IsDir     bool                `json:"isDir"`
+	Meta      filesystem.MetaData `json:"meta,omitempty"`      // Structured metadata
+	Ino       uint64              `json:"ino,omitempty"`       // Stable file identifier, if the plugin has one
+	Blocks    int64               `json:"blocks,omitempty"`    // 512-byte blocks allocated, if the plugin knows
+	BlockSize int64               `json:"blockSize,omitempty"` // Preferred I/O size, if the plugin knows
 }
 
 // ListResponse represents directory listing response
 type ListResponse struct {
 	Files []FileInfoResponse `json:"files"`
+	Next  string             `json:"next,omitempty"` // Cursor of the next page, if the listing was cut short by limit
 }
 
 // WriteRequest represents a write request
@@ -85,6 +109,24 @@ type RenameRequest struct {
 	NewPath string `json:"newPath"`
 }
 
+// CloneRequest represents a clone request
+type CloneRequest struct {
+	NewPath string `json:"newPath"` // Path of the clone
+}
+
+// CopyRangeRequest represents a copy range request
+type CopyRangeRequest struct {
+	Offset    int64  `json:"offset"`    // Offset in the source file
+	DstPath   string `json:"dstPath"`   // File to copy to, which must exist
+	DstOffset int64  `json:"dstOffset"` // Offset in the destination file
+	Length    int64  `json:"length"`    // Bytes to copy at most
+}
+
+// CopyRangeResponse represents a copy range response
+type CopyRangeResponse struct {
+	Copied int64 `json:"copied"` // Bytes copied, fewer than asked if the source ended first
+}
+
 // ChmodRequest represents a chmod request
 type ChmodRequest struct {
 	Mode uint32 `json:"mode"`
@@ -125,25 +167,38 @@ func writeError(w http.ResponseWriter, status int, message string) {
 
 // mapErrorToStatus maps filesystem errors to HTTP status codes
 func mapErrorToStatus(err error) int {
-	if errors.Is(err, filesystem.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+	if errors.Is(err, os.ErrNotExist) {
 		return http.StatusNotFound
 	}
-	if errors.Is(err, filesystem.ErrPermissionDenied) {
-		return http.StatusForbidden
+	if errors.Is(err, os.ErrExist) {
+		return http.StatusConflict
 	}
-	if errors.Is(err, filesystem.ErrInvalidArgument) {
-		return http.StatusBadRequest
+	if errors.Is(err, context.DeadlineExceeded) {
+		// The request ran out of the time its client waits, see TimeoutMiddleware
+		return http.StatusGatewayTimeout
 	}
-	if errors.Is(err, filesystem.ErrAlreadyExists) {
+	switch filesystem.KindOf(err) {
+	case filesystem.KindNotFound:
+		return http.StatusNotFound
+	case filesystem.KindPermissionDenied:
+		return http.StatusForbidden
+	case filesystem.KindInvalidArgument:
+		return http.StatusBadRequest
+	case filesystem.KindAlreadyExists:
 		return http.StatusConflict
-	}
-	if errors.Is(err, filesystem.ErrNotSupported) {
+	case filesystem.KindNotSupported:
 		return http.StatusNotImplemented
+	case filesystem.KindRateLimited:
+		return http.StatusTooManyRequests
+	case filesystem.KindQuotaExceeded:
+		return http.StatusInsufficientStorage
+	case filesystem.KindWriteConflict:
+		return http.StatusLocked
 	}
 	return http.StatusInternalServerError
 }
 
-// CreateFile handles POST /files?path=<path>
+// CreateFile handles POST /files?path=<path>&exclusive=<bool>
 func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -151,7 +206,16 @@ func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.fs.Create(path); err != nil {
+	fs := h.fsFor(r)
+	var err error
+	if r.URL.Query().Get("exclusive") == "true" {
+		// The plugin, or the mount for plugins that can't, fails the
+		// create atomically if the file exists
+		_, err = fs.Write(path, []byte{}, 0, filesystem.WriteFlagCreate|filesystem.WriteFlagExclusive)
+	} else {
+		err = fs.Create(path)
+	}
+	if err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -160,7 +224,7 @@ func (h *Handler) CreateFile(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusCreated, SuccessResponse{Message: "file created"})
 }
 
-// CreateDirectory handles POST /directories?path=<path>&mode=<mode>
+// CreateDirectory handles POST /directories?path=<path>&mode=<mode>&parents=<bool>
 func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -179,7 +243,14 @@ func (h *Handler) CreateDirectory(w http.ResponseWriter, r *http.Request) {
 		mode = uint32(m)
 	}
 
-	if err := h.fs.Mkdir(path, mode); err != nil {
+	fs := h.fsFor(r)
+	mkdir := fs.Mkdir
+	if r.URL.Query().Get("parents") == "true" {
+		mkdir = func(path string, perm uint32) error {
+			return filesystem.MkdirAll(fs, path, perm)
+		}
+	}
+	if err := mkdir(path, mode); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -225,7 +296,14 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	data, err := h.fs.Read(path, offset, size)
+	// Revalidation of a cached copy, answered without reading the file
+	if r.Header.Get("If-None-Match") != "" {
+		if info, err := h.fsFor(r).Stat(path); err == nil && notModified(w, r, info) {
+			return
+		}
+	}
+
+	data, err := h.fsFor(r).Read(path, offset, size)
 	if err != nil {
 		// Check if it's EOF (reached end of file)
 		if err == io.EOF {
@@ -254,7 +332,8 @@ func (h *Handler) ReadFile(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// WriteFile handles PUT /files?path=<path>
+// WriteFile handles PUT /files?path=<path>&flags=<sync>&idempotency_key=<key>.
+// A write retried with the key of one already applied isn't applied again.
 func (h *Handler) WriteFile(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -276,7 +355,41 @@ func (h *Handler) WriteFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Use default flags: create if not exists, truncate (like the old behavior)
-	bytesWritten, err := h.fs.Write(path, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+	flags := filesystem.WriteFlagCreate | filesystem.WriteFlagTruncate
+	for _, flag := range strings.Split(r.URL.Query().Get("flags"), ",") {
+		if flag == "sync" {
+			flags |= filesystem.WriteFlagSync
+		}
+	}
+	write := func() (string, error) {
+		bytesWritten, err := h.fsFor(r).Write(path, data, -1, flags)
+		if err != nil {
+			return "", err
+		}
+		log.Debugf("[handler] WriteFile success: path=%s, written=%d", path, bytesWritten)
+		return fmt.Sprintf("Written %d bytes", bytesWritten), nil
+	}
+
+	var message string
+	if key := r.URL.Query().Get("idempotency_key"); key != "" {
+		if len(key) > maxIdempotencyKeyLen {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("idempotency_key must be at most %d bytes", maxIdempotencyKeyLen))
+			return
+		}
+		// Keys sent by different principals never match
+		scope := path
+		if principal := mountablefs.PrincipalFromContext(r.Context()); principal != "" {
+			scope = principal + ":" + path
+		}
+		var replayed bool
+		message, replayed, err = h.idempotency.Do(r.Context(), scope, key, write)
+		if replayed {
+			log.Debugf("[handler] WriteFile replayed: path=%s, key=%s", path, key)
+			w.Header().Set("Idempotent-Replayed", "true")
+		}
+	} else {
+		message, err = write()
+	}
 	if err != nil {
 		log.Errorf("[handler] WriteFile failed: path=%s, err=%v", path, err)
 		status := mapErrorToStatus(err)
@@ -284,9 +397,8 @@ func (h *Handler) WriteFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Debugf("[handler] WriteFile success: path=%s, written=%d", path, bytesWritten)
 	// Return success with bytes written
-	writeJSON(w, http.StatusOK, SuccessResponse{Message: fmt.Sprintf("Written %d bytes", bytesWritten)})
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: message})
 }
 
 // Delete handles DELETE /files?path=<path>&recursive=<true|false>
@@ -301,9 +413,9 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	var err error
 	if recursive {
-		err = h.fs.RemoveAll(path)
+		err = h.fsFor(r).RemoveAll(path)
 	} else {
-		err = h.fs.Remove(path)
+		err = h.fsFor(r).Remove(path)
 	}
 
 	if err != nil {
@@ -315,14 +427,25 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "deleted"})
 }
 
-// ListDirectory handles GET /directories?path=<path>
+// ListDirectory handles GET /directories?path=<path>&limit=<n>&after=<name>.
+// With a limit, entries are listed in name order, at most limit at a time,
+// starting after the name given by the previous page's next cursor.
 func (h *Handler) ListDirectory(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		path = "/"
 	}
+	limit := 0
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n < 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit parameter")
+			return
+		}
+		limit = n
+	}
 
-	files, err := h.fs.ReadDir(path)
+	files, err := h.fsFor(r).ReadDir(path)
 	if err != nil {
 		// Map error to appropriate HTTP status code
 		status := mapErrorToStatus(err)
@@ -331,21 +454,43 @@ func (h *Handler) ListDirectory(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var response ListResponse
+	if limit > 0 {
+		files, response.Next = pageOf(files, r.URL.Query().Get("after"), limit)
+	}
 	for _, f := range files {
 		response.Files = append(response.Files, FileInfoResponse{
-			Name:    f.Name,
-			Size:    f.Size,
-			Mode:    f.Mode,
-			ModTime: f.ModTime.Format(time.RFC3339Nano),
-			IsDir:   f.IsDir,
-			Meta:    f.Meta,
+			Name:      f.Name,
+			Size:      f.Size,
+			Mode:      f.Mode,
+			ModTime:   f.ModTime.Format(time.RFC3339Nano),
+			IsDir:     f.IsDir,
+			Meta:      f.Meta,
+			Ino:       f.Ino,
+			Blocks:    f.Blocks,
+			BlockSize: f.BlockSize,
 		})
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
-// Stat handles GET /stat?path=<path>
+// pageOf returns the first limit files named after after, in name order,
+// and the cursor of the following page ("" if there is none). Names are
+// unique, so files added or removed between pages never make a page repeat
+// or skip the files that stayed.
+func pageOf(files []filesystem.FileInfo, after string, limit int) ([]filesystem.FileInfo, string) {
+	if !sort.SliceIsSorted(files, func(i, j int) bool { return files[i].Name < files[j].Name }) {
+		sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	}
+	start := sort.Search(len(files), func(i int) bool { return files[i].Name > after })
+	files = files[start:]
+	if len(files) <= limit {
+		return files, ""
+	}
+	return files[:limit], files[limit-1].Name
+}
+
+// Stat handles GET and HEAD /stat?path=<path>
 func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
 	if path == "" {
@@ -353,7 +498,7 @@ func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	info, err := h.fs.Stat(path)
+	info, err := h.fsFor(r).Stat(path)
 	if err != nil {
 		status := mapErrorToStatus(err)
 		// "Not found" is expected during cp/mv operations, use debug level
@@ -365,19 +510,44 @@ func (h *Handler) Stat(w http.ResponseWriter, r *http.Request) {
 		writeError(w, status, err.Error())
 		return
 	}
+	if notModified(w, r, info) {
+		return
+	}
 
 	response := FileInfoResponse{
-		Name:    info.Name,
-		Size:    info.Size,
-		Mode:    info.Mode,
-		ModTime: info.ModTime.Format(time.RFC3339Nano),
-		IsDir:   info.IsDir,
-		Meta:    info.Meta,
+		Name:      info.Name,
+		Size:      info.Size,
+		Mode:      info.Mode,
+		ModTime:   info.ModTime.Format(time.RFC3339Nano),
+		IsDir:     info.IsDir,
+		Meta:      info.Meta,
+		Ino:       info.Ino,
+		Blocks:    info.Blocks,
+		BlockSize: info.BlockSize,
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
+// notModified sets the ETag header for a file whose plugin reports an etag,
+// and responds 304 Not Modified if the request's If-None-Match matches it
+func notModified(w http.ResponseWriter, r *http.Request, info *filesystem.FileInfo) bool {
+	etag := info.Meta.ETag()
+	if etag == "" {
+		return false
+	}
+	quoted := strconv.Quote(etag)
+	w.Header().Set("ETag", quoted)
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == quoted || candidate == "*" {
+			w.WriteHeader(http.StatusNotModified)
+			return true
+		}
+	}
+	return false
+}
+
 // Rename handles POST /rename?path=<path>
 func (h *Handler) Rename(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
@@ -397,7 +567,7 @@ func (h *Handler) Rename(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.fs.Rename(path, req.NewPath); err != nil {
+	if err := h.fsFor(r).Rename(path, req.NewPath); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -406,6 +576,66 @@ func (h *Handler) Rename(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, SuccessResponse{Message: "renamed"})
 }
 
+// Clone handles POST /clone?path=<path>, making newPath a copy-on-write
+// clone of the file where the file system supports it and a plain copy
+// otherwise
+func (h *Handler) Clone(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	var req CloneRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.NewPath == "" {
+		writeError(w, http.StatusBadRequest, "newPath is required")
+		return
+	}
+
+	if err := filesystem.Clone(h.fsFor(r), path, req.NewPath); err != nil {
+		status := mapErrorToStatus(err)
+		writeError(w, status, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: "cloned"})
+}
+
+// CopyRange handles POST /copyrange?path=<path>, copying a range of the
+// file into another on the server, like copy_file_range(2)
+func (h *Handler) CopyRange(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	var req CopyRangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	if req.DstPath == "" {
+		writeError(w, http.StatusBadRequest, "dstPath is required")
+		return
+	}
+
+	copied, err := filesystem.CopyRange(h.fsFor(r), path, req.Offset, req.DstPath, req.DstOffset, req.Length)
+	if err != nil {
+		status := mapErrorToStatus(err)
+		writeError(w, status, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CopyRangeResponse{Copied: copied})
+}
+
 // Chmod handles POST /chmod?path=<path>
 func (h *Handler) Chmod(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
@@ -420,7 +650,7 @@ func (h *Handler) Chmod(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.fs.Chmod(path, req.Mode); err != nil {
+	if err := h.fsFor(r).Chmod(path, req.Mode); err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, err.Error())
 		return
@@ -455,9 +685,9 @@ func (h *Handler) Digest(w http.ResponseWriter, r *http.Request) {
 
 	switch req.Algorithm {
 	case "xxh3":
-		digest, err = h.calculateXXH3Digest(req.Path)
+		digest, err = h.calculateXXH3Digest(h.fsFor(r), req.Path)
 	case "md5":
-		digest, err = h.calculateMD5Digest(req.Path)
+		digest, err = h.calculateMD5Digest(h.fsFor(r), req.Path)
 	default:
 		writeError(w, http.StatusBadRequest, "unsupported algorithm: "+req.Algorithm)
 		return
@@ -479,9 +709,9 @@ func (h *Handler) Digest(w http.ResponseWriter, r *http.Request) {
 }
 
 // calculateXXH3Digest calculates XXH3 hash using streaming approach
-func (h *Handler) calculateXXH3Digest(path string) (string, error) {
+func (h *Handler) calculateXXH3Digest(fs filesystem.FileSystem, path string) (string, error) {
 	// Try to open file for streaming
-	reader, err := h.fs.Open(path)
+	reader, err := fs.Open(path)
 	if err != nil {
 		return "", err
 	}
@@ -509,9 +739,9 @@ func (h *Handler) calculateXXH3Digest(path string) (string, error) {
 }
 
 // calculateMD5Digest calculates MD5 hash using streaming approach
-func (h *Handler) calculateMD5Digest(path string) (string, error) {
+func (h *Handler) calculateMD5Digest(fs filesystem.FileSystem, path string) (string, error) {
 	// Try to open file for streaming
-	reader, err := h.fs.Open(path)
+	reader, err := fs.Open(path)
 	if err != nil {
 		return "", err
 	}
@@ -539,8 +769,15 @@ func (h *Handler) calculateMD5Digest(path string) (string, error) {
 
 // CapabilitiesResponse represents the server capabilities
 type CapabilitiesResponse struct {
-	Version  string   `json:"version"`
-	Features []string `json:"features"`
+	Version  string              `json:"version"`
+	Features []string            `json:"features"`
+	Mounts   map[string][]string `json:"mounts,omitempty"` // Operations declared by the plugin at each mount path
+}
+
+// mountCapabilityLister is implemented by file systems that know the
+// capabilities declared by each of their mounts
+type mountCapabilityLister interface {
+	MountCapabilities() map[string][]string
 }
 
 // Capabilities handles GET /capabilities
@@ -548,13 +785,30 @@ func (h *Handler) Capabilities(w http.ResponseWriter, r *http.Request) {
 	response := CapabilitiesResponse{
 		Version: h.version,
 		Features: []string{
-			"handlefs", // File handles for stateful operations
-			"grep",     // Server-side grep
-			"digest",   // Server-side checksums
-			"stream",   // Streaming read
-			"touch",    // Touch/update timestamp
+			"handlefs",  // File handles for stateful operations
+			"grep",      // Server-side grep
+			"digest",    // Server-side checksums
+			"stream",    // Streaming read
+			"touch",     // Touch/update timestamp
+			"locks",     // Advisory byte-range locks
+			"batch",     // Batches of metadata operations
+			"clone",     // Copy-on-write clones of files, or copies
+			"copyrange", // Server-side copies of file ranges
 		},
 	}
+	fs := h.fsFor(r)
+	if _, ok := fs.(changeSubscriber); ok {
+		response.Features = append(response.Features, "events") // Change events
+	}
+	if _, ok := fs.(snapshotTaker); ok {
+		response.Features = append(response.Features, "snapshots") // Snapshots of directories
+	}
+	if _, ok := fs.(transactor); ok {
+		response.Features = append(response.Features, "transactions") // Atomic groups of writes, removals and renames
+	}
+	if lister, ok := fs.(mountCapabilityLister); ok {
+		response.Mounts = lister.MountCapabilities()
+	}
 	writeJSON(w, http.StatusOK, response)
 }
 
@@ -588,7 +842,8 @@ func (h *Handler) Touch(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if filesystem implements efficient Touch
-	if toucher, ok := h.fs.(filesystem.Toucher); ok {
+	fs := h.fsFor(r)
+	if toucher, ok := fs.(filesystem.Toucher); ok {
 		// Use efficient touch implementation
 		err := toucher.Touch(path)
 		if err != nil {
@@ -602,17 +857,17 @@ func (h *Handler) Touch(w http.ResponseWriter, r *http.Request) {
 
 	// Fallback: inefficient implementation for filesystems without Touch
 	// Check if file exists
-	info, err := h.fs.Stat(path)
+	info, err := fs.Stat(path)
 	if err == nil {
 		// File exists - read current content and write it back to update timestamp
 		if !info.IsDir {
-			data, readErr := h.fs.Read(path, 0, -1)
+			data, readErr := fs.Read(path, 0, -1)
 			if readErr != nil {
 				status := mapErrorToStatus(readErr)
 				writeError(w, status, readErr.Error())
 				return
 			}
-			_, writeErr := h.fs.Write(path, data, -1, filesystem.WriteFlagTruncate)
+			_, writeErr := fs.Write(path, data, -1, filesystem.WriteFlagTruncate)
 			if writeErr != nil {
 				status := mapErrorToStatus(writeErr)
 				writeError(w, status, writeErr.Error())
@@ -625,7 +880,7 @@ func (h *Handler) Touch(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// File doesn't exist - create with empty content
-		_, err := h.fs.Write(path, []byte{}, -1, filesystem.WriteFlagCreate)
+		_, err := fs.Write(path, []byte{}, -1, filesystem.WriteFlagCreate)
 		if err != nil {
 			status := mapErrorToStatus(err)
 			writeError(w, status, err.Error())
@@ -656,7 +911,7 @@ func (h *Handler) Symlink(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if filesystem implements Symlinker
-	symlinker, ok := h.fs.(filesystem.Symlinker)
+	symlinker, ok := h.fsFor(r).(filesystem.Symlinker)
 	if !ok {
 		writeError(w, http.StatusNotImplemented, "symlink not supported by this filesystem")
 		return
@@ -680,7 +935,7 @@ func (h *Handler) Readlink(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if filesystem implements Symlinker
-	symlinker, ok := h.fs.(filesystem.Symlinker)
+	symlinker, ok := h.fsFor(r).(filesystem.Symlinker)
 	if !ok {
 		writeError(w, http.StatusNotImplemented, "readlink not supported by this filesystem")
 		return
@@ -722,7 +977,7 @@ func (h *Handler) Truncate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if filesystem supports Truncate
-	truncater, ok := h.fs.(filesystem.Truncater)
+	truncater, ok := h.fsFor(r).(filesystem.Truncater)
 	if !ok {
 		writeError(w, http.StatusNotImplemented, "filesystem does not support truncate")
 		return
@@ -740,6 +995,8 @@ func (h *Handler) Truncate(w http.ResponseWriter, r *http.Request) {
 // SetupRoutes sets up all HTTP routes with /api/v1 prefix
 func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/api/v1/health", h.Health)
+	mux.HandleFunc("/healthz", h.Liveness)
+	mux.HandleFunc("/readyz", h.Readiness)
 	mux.HandleFunc("/api/v1/version", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodGet {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -758,6 +1015,34 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 		h.Capabilities(w, r)
 	})
+	mux.HandleFunc("/api/v1/events", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Events(w, r)
+	})
+	mux.HandleFunc("/api/v1/snapshots", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Snapshots(w, r)
+	})
+	mux.HandleFunc("/api/v1/batch", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Batch(w, r)
+	})
+	mux.HandleFunc("/api/v1/tx", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Transaction(w, r)
+	})
 
 	// Convenience routes (aliases for common operations)
 	mux.HandleFunc("/api/v1/mkdir", func(w http.ResponseWriter, r *http.Request) {
@@ -794,7 +1079,7 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 			if h.trafficMonitor != nil && len(data) > 0 {
 				h.trafficMonitor.RecordWrite(int64(len(data)))
 			}
-			bytesWritten, err := h.fs.Write(path, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
+			bytesWritten, err := h.fsFor(r).Write(path, data, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate)
 			if err != nil {
 				status := mapErrorToStatus(err)
 				writeError(w, status, err.Error())
@@ -829,6 +1114,7 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 
 	// Setup handle routes (file handles for stateful operations)
 	h.SetupHandleRoutes(mux)
+	h.SetupLockRoutes(mux)
 
 	mux.HandleFunc("/api/v1/files", func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -857,7 +1143,8 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 	})
 	mux.HandleFunc("/api/v1/stat", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
+		// HEAD answers whether the file exists without a body
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
 			return
 		}
@@ -870,6 +1157,20 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 		}
 		h.Rename(w, r)
 	})
+	mux.HandleFunc("/api/v1/clone", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.Clone(w, r)
+	})
+	mux.HandleFunc("/api/v1/copyrange", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.CopyRange(w, r)
+	})
 	mux.HandleFunc("/api/v1/chmod", func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
@@ -924,7 +1225,7 @@ func (h *Handler) SetupRoutes(mux *http.ServeMux) {
 // streamFile handles streaming file reads with HTTP chunked transfer encoding
 func (h *Handler) streamFile(w http.ResponseWriter, r *http.Request, path string) {
 	// Check if filesystem supports streaming
-	streamer, ok := h.fs.(filesystem.Streamer)
+	streamer, ok := h.fsFor(r).(filesystem.Streamer)
 	if !ok {
 		writeError(w, http.StatusBadRequest, "streaming not supported for this filesystem")
 		return
@@ -1069,7 +1370,8 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 
 	// Try custom grep first (if filesystem supports it)
 	// This allows plugins like vectorfs to implement their own search logic
-	if cg, ok := h.fs.(interface {
+	fs := h.fsFor(r)
+	if cg, ok := fs.(interface {
 		CustomGrep(string, string, int) ([]mountablefs.CustomGrepResult, error)
 	}); ok {
 		// Set default limit for custom grep if not specified
@@ -1116,7 +1418,7 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check if path exists and get file info
-	info, err := h.fs.Stat(req.Path)
+	info, err := fs.Stat(req.Path)
 	if err != nil {
 		status := mapErrorToStatus(err)
 		writeError(w, status, "failed to stat path: "+err.Error())
@@ -1125,7 +1427,7 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 
 	// Handle stream mode
 	if req.Stream {
-		h.grepStream(w, req.Path, re, info.IsDir, req.Recursive)
+		h.grepStream(w, fs, req.Path, re, info.IsDir, req.Recursive)
 		return
 	}
 
@@ -1135,13 +1437,13 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 	// Search in file or directory
 	if info.IsDir {
 		if req.Recursive {
-			matches, err = h.grepDirectory(req.Path, re)
+			matches, err = h.grepDirectory(fs, req.Path, re)
 		} else {
 			writeError(w, http.StatusBadRequest, "path is a directory, use recursive=true to search")
 			return
 		}
 	} else {
-		matches, err = h.grepFile(req.Path, re)
+		matches, err = h.grepFile(fs, req.Path, re)
 	}
 
 	if err != nil {
@@ -1158,7 +1460,7 @@ func (h *Handler) Grep(w http.ResponseWriter, r *http.Request) {
 }
 
 // grepStream handles streaming grep results as NDJSON
-func (h *Handler) grepStream(w http.ResponseWriter, path string, re *regexp.Regexp, isDir bool, recursive bool) {
+func (h *Handler) grepStream(w http.ResponseWriter, fs filesystem.FileSystem, path string, re *regexp.Regexp, isDir bool, recursive bool) {
 	// Set headers for NDJSON streaming
 	w.Header().Set("Content-Type", "application/x-ndjson")
 	w.Header().Set("Transfer-Encoding", "chunked")
@@ -1196,9 +1498,9 @@ func (h *Handler) grepStream(w http.ResponseWriter, path string, re *regexp.Rege
 			flusher.Flush()
 			return
 		}
-		err = h.grepDirectoryStream(path, re, sendMatch)
+		err = h.grepDirectoryStream(fs, path, re, sendMatch)
 	} else {
-		err = h.grepFileStream(path, re, sendMatch)
+		err = h.grepFileStream(fs, path, re, sendMatch)
 	}
 
 	// Send final summary with count
@@ -1214,9 +1516,9 @@ func (h *Handler) grepStream(w http.ResponseWriter, path string, re *regexp.Rege
 }
 
 // grepFileStream searches for pattern in a single file and calls callback for each match
-func (h *Handler) grepFileStream(path string, re *regexp.Regexp, callback func(GrepMatch) error) error {
+func (h *Handler) grepFileStream(fs filesystem.FileSystem, path string, re *regexp.Regexp, callback func(GrepMatch) error) error {
 	// Read file content
-	data, err := h.fs.Read(path, 0, -1)
+	data, err := fs.Read(path, 0, -1)
 	// io.EOF is normal when reading entire file, only return error for other errors
 	if err != nil && err != io.EOF {
 		return err
@@ -1248,9 +1550,9 @@ func (h *Handler) grepFileStream(path string, re *regexp.Regexp, callback func(G
 }
 
 // grepDirectoryStream recursively searches for pattern in a directory and calls callback for each match
-func (h *Handler) grepDirectoryStream(dirPath string, re *regexp.Regexp, callback func(GrepMatch) error) error {
+func (h *Handler) grepDirectoryStream(fs filesystem.FileSystem, dirPath string, re *regexp.Regexp, callback func(GrepMatch) error) error {
 	// List directory contents
-	entries, err := h.fs.ReadDir(dirPath)
+	entries, err := fs.ReadDir(dirPath)
 	if err != nil {
 		return err
 	}
@@ -1263,14 +1565,14 @@ func (h *Handler) grepDirectoryStream(dirPath string, re *regexp.Regexp, callbac
 
 		if entry.IsDir {
 			// Recursively search subdirectories
-			if err := h.grepDirectoryStream(fullPath, re, callback); err != nil {
+			if err := h.grepDirectoryStream(fs, fullPath, re, callback); err != nil {
 				// Log error but continue searching other files
 				log.Warnf("failed to search directory %s: %v", fullPath, err)
 				continue
 			}
 		} else {
 			// Search in file
-			if err := h.grepFileStream(fullPath, re, callback); err != nil {
+			if err := h.grepFileStream(fs, fullPath, re, callback); err != nil {
 				// Log error but continue searching other files
 				log.Warnf("failed to search file %s: %v", fullPath, err)
 				continue
@@ -1282,9 +1584,9 @@ func (h *Handler) grepDirectoryStream(dirPath string, re *regexp.Regexp, callbac
 }
 
 // grepFile searches for pattern in a single file
-func (h *Handler) grepFile(path string, re *regexp.Regexp) ([]GrepMatch, error) {
+func (h *Handler) grepFile(fs filesystem.FileSystem, path string, re *regexp.Regexp) ([]GrepMatch, error) {
 	// Read file content
-	data, err := h.fs.Read(path, 0, -1)
+	data, err := fs.Read(path, 0, -1)
 	// io.EOF is normal when reading entire file, only return error for other errors
 	if err != nil && err != io.EOF {
 		return nil, err
@@ -1314,11 +1616,11 @@ func (h *Handler) grepFile(path string, re *regexp.Regexp) ([]GrepMatch, error)
 }
 
 // grepDirectory recursively searches for pattern in a directory
-func (h *Handler) grepDirectory(dirPath string, re *regexp.Regexp) ([]GrepMatch, error) {
+func (h *Handler) grepDirectory(fs filesystem.FileSystem, dirPath string, re *regexp.Regexp) ([]GrepMatch, error) {
 	var allMatches []GrepMatch
 
 	// List directory contents
-	entries, err := h.fs.ReadDir(dirPath)
+	entries, err := fs.ReadDir(dirPath)
 	if err != nil {
 		return nil, err
 	}
@@ -1331,7 +1633,7 @@ func (h *Handler) grepDirectory(dirPath string, re *regexp.Regexp) ([]GrepMatch,
 
 		if entry.IsDir {
 			// Recursively search subdirectories
-			subMatches, err := h.grepDirectory(fullPath, re)
+			subMatches, err := h.grepDirectory(fs, fullPath, re)
 			if err != nil {
 				// Log error but continue searching other files
 				log.Warnf("failed to search directory %s: %v", fullPath, err)
@@ -1340,7 +1642,7 @@ func (h *Handler) grepDirectory(dirPath string, re *regexp.Regexp) ([]GrepMatch,
 			allMatches = append(allMatches, subMatches...)
 		} else {
 			// Search in file
-			matches, err := h.grepFile(fullPath, re)
+			matches, err := h.grepFile(fs, fullPath, re)
 			if err != nil {
 				// Log error but continue searching other files
 				log.Warnf("failed to search file %s: %v", fullPath, err)
@@ -1353,14 +1655,26 @@ func (h *Handler) grepDirectory(dirPath string, re *regexp.Regexp) ([]GrepMatch,
 	return allMatches, nil
 }
 
-// LoggingMiddleware logs HTTP requests
+// LoggingMiddleware logs HTTP requests, and those failing with a 5xx status,
+// with their request ID: the X-Request-ID header the client sent, or a new
+// one. The ID is returned in the response's X-Request-ID header and bound to
+// the request's context, see filesystem.WithRequestID, so the log lines of
+// plugins serving the request carry it too.
 func LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r)
+		w.Header().Set(RequestIDHeader, id)
+		entry := log.WithField("request_id", id)
+
 		path := r.URL.Path
 		if r.URL.RawQuery != "" {
 			path += "?" + r.URL.RawQuery
 		}
-		log.Debugf("%s %s", r.Method, path)
-		next.ServeHTTP(w, r)
+		entry.Debugf("%s %s", r.Method, path)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(filesystem.WithRequestID(r.Context(), id)))
+		if rec.status >= 500 {
+			entry.Warnf("%s %s failed with %d", r.Method, path, rec.status)
+		}
 	})
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+)
+
+// mountHealthReporter is implemented by file systems that know whether each
+// of their mounts can serve requests
+type mountHealthReporter interface {
+	MountHealth() []mountablefs.MountHealth
+}
+
+// ReadinessResponse is the body of /readyz
+type ReadinessResponse struct {
+	Status string                    `json:"status"` // "ready" or "not ready"
+	Mounts []mountablefs.MountHealth `json:"mounts"`
+}
+
+// AddReadinessCheck makes /readyz also report the mounts check returns, such
+// as configured mounts that failed to initialize and so aren't mounted
+func (h *Handler) AddReadinessCheck(check func() []mountablefs.MountHealth) {
+	h.readiness = append(h.readiness, check)
+}
+
+// Liveness handles GET /healthz: the server is up as long as it answers
+func (h *Handler) Liveness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// Readiness handles GET /readyz, answering 503 unless every mount is ready,
+// with the health of each mount in the body
+func (h *Handler) Readiness(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	response := ReadinessResponse{Status: "ready", Mounts: []mountablefs.MountHealth{}}
+	if reporter, ok := h.fsFor(r).(mountHealthReporter); ok {
+		response.Mounts = append(response.Mounts, reporter.MountHealth()...)
+	}
+	for _, check := range h.readiness {
+		response.Mounts = append(response.Mounts, check()...)
+	}
+
+	status := http.StatusOK
+	for _, m := range response.Mounts {
+		if !m.Ready {
+			response.Status = "not ready"
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+	writeJSON(w, status, response)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// poolPlugin is a memfs whose health is that of a pool, unhealthy when set
+type poolPlugin struct {
+	*memfs.MemFSPlugin
+	unhealthy atomic.Bool
+}
+
+func (p *poolPlugin) Health() error {
+	if p.unhealthy.Load() {
+		return &api.PoolUnhealthyError{Plugin: "poolfs", Failures: 5, RetryAfter: time.Second}
+	}
+	return nil
+}
+
+func TestReadiness(t *testing.T) {
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	p := &poolPlugin{MemFSPlugin: memfs.NewMemFSPlugin()}
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	if err := mfs.Mount("/pool", p); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	h := NewHandler(mfs, NewTrafficMonitor())
+	var failed []mountablefs.MountHealth
+	h.AddReadinessCheck(func() []mountablefs.MountHealth { return failed })
+	mux := http.NewServeMux()
+	h.SetupRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	probe := func(path string) (int, ReadinessResponse) {
+		t.Helper()
+		resp, err := http.Get(server.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var body ReadinessResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	if status, body := probe("/readyz"); status != http.StatusOK || body.Status != "ready" ||
+		len(body.Mounts) != 1 || !body.Mounts[0].Ready || body.Mounts[0].Path != "/pool" {
+		t.Errorf("Expected ready with the healthy mount, got %d %+v", status, body)
+	}
+
+	// An unhealthy pool makes the server not ready, but still alive
+	p.unhealthy.Store(true)
+	status, body := probe("/readyz")
+	if status != http.StatusServiceUnavailable || body.Status != "not ready" || body.Mounts[0].Ready || body.Mounts[0].Error == "" {
+		t.Errorf("Expected not ready with the pool's error, got %d %+v", status, body)
+	}
+	if status, _ := probe("/healthz"); status != http.StatusOK {
+		t.Errorf("Expected /healthz to stay up, got %d", status)
+	}
+
+	// So does a configured mount that failed
+	p.unhealthy.Store(false)
+	failed = []mountablefs.MountHealth{{Path: "/db", Plugin: "sqlfs", Error: "connection refused"}}
+	if status, body := probe("/readyz"); status != http.StatusServiceUnavailable || len(body.Mounts) != 2 || body.Mounts[1].Path != "/db" {
+		t.Errorf("Expected the failed mount to be reported, got %d %+v", status, body)
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long the server remembers the idempotency
+// key of an applied write. It covers the SDK's retries, which give up after
+// a few seconds of backoff, many times over.
+const DefaultIdempotencyWindow = 5 * time.Minute
+
+// maxIdempotencyKeyLen bounds the keys clients may send, as each is kept
+// for the whole window
+const maxIdempotencyKeyLen = 128
+
+// IdempotencyCache remembers the writes applied with an idempotency key, so
+// that a retry of a write whose response was lost, such as after a timeout,
+// returns the first response instead of applying the write again. Writes
+// that failed are forgotten, so their retries are applied.
+type IdempotencyCache struct {
+	window time.Duration
+
+	mu        sync.Mutex
+	writes    map[string]*idempotentWrite // by path and key
+	nextSweep time.Time
+}
+
+type idempotentWrite struct {
+	done    chan struct{} // closed once the write finished
+	message string        // response of the applied write
+	expires time.Time     // zero while the write is running
+}
+
+func (w *idempotentWrite) expired(now time.Time) bool {
+	return !w.expires.IsZero() && now.After(w.expires)
+}
+
+// NewIdempotencyCache creates a cache remembering applied writes for window
+func NewIdempotencyCache(window time.Duration) *IdempotencyCache {
+	return &IdempotencyCache{
+		window: window,
+		writes: make(map[string]*idempotentWrite),
+	}
+}
+
+// Do applies a write to path with key unless a write with the same key was
+// applied within the window, in which case it returns that write's message
+// and replayed. A retry arriving while the first attempt is still running
+// waits for it; it returns ctx's error if ctx is done first.
+func (c *IdempotencyCache) Do(ctx context.Context, path, key string, apply func() (string, error)) (message string, replayed bool, err error) {
+	id := path + "\x00" + key
+	for {
+		now := time.Now()
+		c.mu.Lock()
+		c.sweepLocked(now)
+		w := c.writes[id]
+		if w != nil && w.expired(now) {
+			delete(c.writes, id)
+			w = nil
+		}
+		if w == nil {
+			w = &idempotentWrite{done: make(chan struct{})}
+			c.writes[id] = w
+			c.mu.Unlock()
+
+			message, err := apply()
+
+			c.mu.Lock()
+			if err != nil {
+				delete(c.writes, id)
+			} else {
+				w.message = message
+				w.expires = time.Now().Add(c.window)
+			}
+			close(w.done)
+			c.mu.Unlock()
+			return message, false, err
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+
+		c.mu.Lock()
+		applied := c.writes[id] == w
+		c.mu.Unlock()
+		if applied {
+			return w.message, true, nil
+		}
+		// The write failed and was forgotten, so apply the retry
+	}
+}
+
+// sweepLocked forgets applied writes older than the window, at most every
+// half window
+func (c *IdempotencyCache) sweepLocked(now time.Time) {
+	if now.Before(c.nextSweep) {
+		return
+	}
+	c.nextSweep = now.Add(c.window / 2)
+	for id, w := range c.writes {
+		if w.expired(now) {
+			delete(c.writes, id)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestWriteFileIdempotencyKey(t *testing.T) {
+	var flags []filesystem.WriteFlag
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	plugin := memfs.NewMemFSPlugin()
+	if err := plugin.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	record := func(fs filesystem.FileSystem) filesystem.FileSystem {
+		return &flagRecorder{Wrapper: filesystem.Wrapper{Inner: fs}, flags: &flags}
+	}
+	if err := mfs.Mount("/mem", plugin, record); err != nil {
+		t.Fatalf("Failed to mount memfs: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewHandler(mfs, NewTrafficMonitor()).SetupRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	writes := []struct {
+		key      string
+		replayed bool
+	}{
+		{"k1", false},
+		{"k1", true},
+		{"k2", false},
+		{"", false},
+		{"", false},
+	}
+	for _, write := range writes {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/files?path=/mem/f&idempotency_key="+write.key, strings.NewReader("data"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+		if replayed := resp.Header.Get("Idempotent-Replayed") == "true"; replayed != write.replayed {
+			t.Errorf("Write with key %q: expected replayed=%v, got %v", write.key, write.replayed, replayed)
+		}
+	}
+
+	if len(flags) != 4 {
+		t.Errorf("Expected the write retried with k1 not to reach the plugin, got %d writes", len(flags))
+	}
+}
+
+func TestIdempotencyCache(t *testing.T) {
+	c := NewIdempotencyCache(50 * time.Millisecond)
+	ctx := context.Background()
+	applied := 0
+	apply := func() (string, error) {
+		applied++
+		return "ok", nil
+	}
+
+	failed := errors.New("failed")
+	if _, _, err := c.Do(ctx, "/f", "k", func() (string, error) { return "", failed }); err != failed {
+		t.Fatalf("Expected the write's error, got %v", err)
+	}
+	if _, replayed, _ := c.Do(ctx, "/f", "k", apply); replayed || applied != 1 {
+		t.Errorf("Expected a retry of a failed write to be applied")
+	}
+	if msg, replayed, _ := c.Do(ctx, "/f", "k", apply); !replayed || msg != "ok" || applied != 1 {
+		t.Errorf("Expected a retry of an applied write to be replayed, got %q, %v", msg, replayed)
+	}
+	if _, replayed, _ := c.Do(ctx, "/g", "k", apply); replayed || applied != 2 {
+		t.Errorf("Expected keys to be scoped by path")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, replayed, _ := c.Do(ctx, "/f", "k", apply); replayed || applied != 3 {
+		t.Errorf("Expected keys to be forgotten after the window")
+	}
+}
+
+func TestIdempotencyCacheConcurrentRetry(t *testing.T) {
+	c := NewIdempotencyCache(time.Minute)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go c.Do(context.Background(), "/f", "k", func() (string, error) {
+		close(started)
+		<-release
+		return "first", nil
+	})
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.Do(ctx, "/f", "k", func() (string, error) { return "second", nil }); err != context.DeadlineExceeded {
+		t.Fatalf("Expected a retry to wait for the running write, got %v", err)
+	}
+
+	close(release)
+	msg, replayed, err := c.Do(context.Background(), "/f", "k", func() (string, error) { return "second", nil })
+	if err != nil || !replayed || msg != "first" {
+		t.Errorf("Expected the first write's response, got %q, %v, %v", msg, replayed, err)
+	}
+}
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// listPage gets a page of /mem/dir of up to limit entries after after
+func listPage(t *testing.T, server string, after string, limit int) ListResponse {
+	t.Helper()
+	resp, err := http.Get(fmt.Sprintf("%s/api/v1/directories?path=/mem/dir&limit=%d&after=%s", server, limit, url.QueryEscape(after)))
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var list ListResponse
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		t.Fatalf("Failed to decode list response: %v", err)
+	}
+	return list
+}
+
+func TestListDirectoryPages(t *testing.T) {
+	server := newTestServer(t)
+	ops := []BatchOp{{Op: "mkdir", Params: map[string]string{"path": "/mem/dir"}}}
+	for _, name := range []string{"e", "a", "d", "b", "c"} {
+		ops = append(ops, BatchOp{Op: "create", Params: map[string]string{"path": "/mem/dir/" + name}})
+	}
+	postBatch(t, server.URL, ops...)
+
+	page := listPage(t, server.URL, "", 2)
+	if len(page.Files) != 2 || page.Files[0].Name != "a" || page.Files[1].Name != "b" || page.Next != "b" {
+		t.Fatalf("Expected a and b with a cursor, got %+v", page)
+	}
+
+	// Entries changing between pages don't repeat or hide the others
+	postBatch(t, server.URL,
+		BatchOp{Op: "create", Params: map[string]string{"path": "/mem/dir/aa"}},
+		BatchOp{Op: "remove", Params: map[string]string{"path": "/mem/dir/c"}},
+	)
+	page = listPage(t, server.URL, page.Next, 2)
+	if len(page.Files) != 2 || page.Files[0].Name != "d" || page.Files[1].Name != "e" || page.Next != "" {
+		t.Fatalf("Expected d and e as the last page, got %+v", page)
+	}
+}
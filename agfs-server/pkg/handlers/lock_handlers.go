@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// maxLockWait caps how long a single blocking lock request waits, so it
+// ends well within client timeouts; clients retry to wait longer
+const maxLockWait = 20 * time.Second
+
+// LockResponse is the response for lock requests. Lock is the conflicting
+// lock, if any.
+type LockResponse struct {
+	Lock *LockInfo `json:"lock,omitempty"`
+	TTL  int       `json:"ttl"` // Seconds the session's locks outlive its last request
+}
+
+// LockConflictResponse is the 423 response for a lock that couldn't be set
+type LockConflictResponse struct {
+	Error string    `json:"error"`
+	Lock  *LockInfo `json:"lock"`
+}
+
+// LockRenewResponse is the response for session renewal
+type LockRenewResponse struct {
+	Locks int `json:"locks"` // Locks the session holds
+	TTL   int `json:"ttl"`
+}
+
+// lockRequest holds the parameters shared by the lock endpoints
+type lockRequest struct {
+	path    string
+	owner   LockOwner
+	typ     LockType
+	start   int64
+	end     int64
+	waitFor time.Duration
+}
+
+// parseLockRequest parses path, session, owner, type, start, end and wait
+// (milliseconds) from the query
+func parseLockRequest(r *http.Request) (*lockRequest, string) {
+	q := r.URL.Query()
+	req := &lockRequest{
+		path:  q.Get("path"),
+		owner: LockOwner{Session: q.Get("session")},
+		typ:   LockType(q.Get("type")),
+		end:   maxOffset,
+	}
+	if req.path == "" {
+		return nil, "path parameter is required"
+	}
+	if req.owner.Session == "" {
+		return nil, "session parameter is required"
+	}
+	req.path = filesystem.NormalizePath(req.path)
+
+	var err error
+	if s := q.Get("owner"); s != "" {
+		if req.owner.Owner, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return nil, "invalid owner parameter"
+		}
+	}
+	switch req.typ {
+	case LockShared, LockExclusive, LockUnlock:
+	default:
+		return nil, "type must be shared, exclusive or unlock"
+	}
+	if s := q.Get("start"); s != "" {
+		if req.start, err = strconv.ParseInt(s, 10, 64); err != nil || req.start < 0 {
+			return nil, "invalid start parameter"
+		}
+	}
+	if s := q.Get("end"); s != "" {
+		if req.end, err = strconv.ParseInt(s, 10, 64); err != nil || req.end < req.start {
+			return nil, "invalid end parameter"
+		}
+	}
+	if s := q.Get("wait"); s != "" {
+		ms, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || ms < 0 {
+			return nil, "invalid wait parameter"
+		}
+		req.waitFor = min(time.Duration(ms)*time.Millisecond, maxLockWait)
+	}
+	return req, ""
+}
+
+// TestLock handles GET /api/v1/locks?path=<path>&session=<id>&owner=<n>&type=<type>&start=<n>&end=<n>
+// and returns the lock that would conflict, like F_GETLK
+func (h *Handler) TestLock(w http.ResponseWriter, r *http.Request) {
+	req, msg := parseLockRequest(r)
+	if req == nil {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	conflict := h.locks.Test(req.path, req.owner, req.typ, req.start, req.end)
+	writeJSON(w, http.StatusOK, LockResponse{Lock: conflict, TTL: int(h.locks.TTL().Seconds())})
+}
+
+// SetLock handles POST /api/v1/locks?path=<path>&session=<id>&owner=<n>&type=<type>&start=<n>&end=<n>&wait=<ms>
+// It responds 423 with the conflicting lock if the lock isn't free within wait.
+func (h *Handler) SetLock(w http.ResponseWriter, r *http.Request) {
+	req, msg := parseLockRequest(r)
+	if req == nil {
+		writeError(w, http.StatusBadRequest, msg)
+		return
+	}
+	conflict, err := h.locks.Lock(r.Context(), req.path, req.owner, req.typ, req.start, req.end, req.waitFor)
+	if err != nil {
+		// The client went away while waiting
+		writeError(w, http.StatusRequestTimeout, err.Error())
+		return
+	}
+	if conflict != nil {
+		writeJSON(w, http.StatusLocked, LockConflictResponse{Error: "lock held by another owner", Lock: conflict})
+		return
+	}
+	writeJSON(w, http.StatusOK, LockResponse{TTL: int(h.locks.TTL().Seconds())})
+}
+
+// ReleaseLocks handles DELETE /api/v1/locks?session=<id>[&path=<path>&owner=<n>]
+// It releases every lock of the session, or only owner's locks on path.
+func (h *Handler) ReleaseLocks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	session := q.Get("session")
+	if session == "" {
+		writeError(w, http.StatusBadRequest, "session parameter is required")
+		return
+	}
+	path := q.Get("path")
+	var owner uint64
+	if path != "" {
+		path = filesystem.NormalizePath(path)
+		var err error
+		if owner, err = strconv.ParseUint(q.Get("owner"), 10, 64); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid owner parameter")
+			return
+		}
+	}
+	h.locks.Release(session, path, owner)
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: "locks released"})
+}
+
+// RenewLocks handles POST /api/v1/locks/renew?session=<id>
+func (h *Handler) RenewLocks(w http.ResponseWriter, r *http.Request) {
+	session := r.URL.Query().Get("session")
+	if session == "" {
+		writeError(w, http.StatusBadRequest, "session parameter is required")
+		return
+	}
+	n := h.locks.Renew(session)
+	writeJSON(w, http.StatusOK, LockRenewResponse{Locks: n, TTL: int(h.locks.TTL().Seconds())})
+}
+
+// SetupLockRoutes sets up the advisory lock routes
+func (h *Handler) SetupLockRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("/api/v1/locks", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			h.TestLock(w, r)
+		case http.MethodPost:
+			h.SetLock(w, r)
+		case http.MethodDelete:
+			h.ReleaseLocks(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	})
+	mux.HandleFunc("/api/v1/locks/renew", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		h.RenewLocks(w, r)
+	})
+}
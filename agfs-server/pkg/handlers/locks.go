@@ -0,0 +1,256 @@
+package handlers
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// LockType is the type of an advisory byte-range lock
+type LockType string
+
+const (
+	// LockShared locks can be held by several owners at once, like F_RDLCK
+	LockShared LockType = "shared"
+	// LockExclusive locks conflict with every other lock, like F_WRLCK
+	LockExclusive LockType = "exclusive"
+	// LockUnlock releases the range, like F_UNLCK
+	LockUnlock LockType = "unlock"
+)
+
+// DefaultLockTTL is how long a client session's locks outlive its last
+// request. Clients holding locks renew their session well within it, so
+// the locks of a client that disconnected are released once it passes.
+const DefaultLockTTL = 30 * time.Second
+
+// LockOwner identifies the holder of a lock: an owner, such as a process
+// or an open file, within a client session
+type LockOwner struct {
+	Session string
+	Owner   uint64
+}
+
+// LockInfo describes a lock. Start and End are inclusive byte offsets.
+type LockInfo struct {
+	Owner uint64   `json:"owner"`
+	Type  LockType `json:"type"`
+	Start int64    `json:"start"`
+	End   int64    `json:"end"`
+}
+
+type lockRecord struct {
+	owner LockOwner
+	typ   LockType
+	start int64
+	end   int64
+}
+
+func (r *lockRecord) overlaps(start, end int64) bool {
+	return r.start <= end && start <= r.end
+}
+
+func (r *lockRecord) info() *LockInfo {
+	return &LockInfo{Owner: r.owner.Owner, Type: r.typ, Start: r.start, End: r.end}
+}
+
+// LockTable holds the advisory locks taken by clients, with POSIX record
+// lock semantics: an owner's locks never conflict with each other, and
+// setting a lock replaces the owner's locks on the range. Locks are only
+// enforced between lock requests; reads and writes ignore them.
+type LockTable struct {
+	ttl time.Duration
+
+	mu       sync.Mutex
+	locks    map[string][]lockRecord // by path
+	sessions map[string]time.Time    // when each session was last seen
+	changed  chan struct{}           // closed and replaced when locks are released
+}
+
+// NewLockTable creates a lock table expiring the locks of sessions not seen
+// for ttl
+func NewLockTable(ttl time.Duration) *LockTable {
+	return &LockTable{
+		ttl:      ttl,
+		locks:    make(map[string][]lockRecord),
+		sessions: make(map[string]time.Time),
+		changed:  make(chan struct{}),
+	}
+}
+
+// TTL returns how long a session's locks outlive its last request
+func (t *LockTable) TTL() time.Duration {
+	return t.ttl
+}
+
+// Test returns a lock of another owner that conflicts with owner taking a
+// lock of type typ on [start, end] of path, or nil if there is none
+func (t *LockTable) Test(path string, owner LockOwner, typ LockType, start, end int64) *LockInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.touchLocked(owner.Session, time.Now())
+	if r := t.conflictLocked(path, owner, typ, start, end); r != nil {
+		return r.info()
+	}
+	return nil
+}
+
+// Lock sets owner's lock of type typ on [start, end] of path, or releases
+// the range with LockUnlock. If another owner holds a conflicting lock,
+// Lock waits up to wait for it to be released and then returns it; it
+// returns ctx's error if ctx is done first.
+func (t *LockTable) Lock(ctx context.Context, path string, owner LockOwner, typ LockType, start, end int64, wait time.Duration) (*LockInfo, error) {
+	deadline := time.Now().Add(wait)
+	for {
+		now := time.Now()
+		t.mu.Lock()
+		t.touchLocked(owner.Session, now)
+		conflict := t.conflictLocked(path, owner, typ, start, end)
+		if conflict == nil {
+			t.setLocked(path, owner, typ, start, end)
+			t.mu.Unlock()
+			return nil, nil
+		}
+		info := conflict.info()
+		// Wake up when the holder's session would expire, and often enough
+		// to keep the waiter's own session alive
+		next := min(t.sessions[conflict.owner.Session].Add(t.ttl).Sub(now), t.ttl/2)
+		changed := t.changed
+		t.mu.Unlock()
+
+		remaining := deadline.Sub(now)
+		if remaining <= 0 {
+			return info, nil
+		}
+		timer := time.NewTimer(min(remaining, max(next, time.Millisecond)))
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+		timer.Stop()
+	}
+}
+
+// Renew keeps session's locks alive and returns how many it holds
+func (t *LockTable) Renew(session string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.touchLocked(session, time.Now())
+
+	n := 0
+	for _, records := range t.locks {
+		for _, r := range records {
+			if r.owner.Session == session {
+				n++
+			}
+		}
+	}
+	if n == 0 {
+		delete(t.sessions, session)
+	}
+	return n
+}
+
+// Release drops every lock of session, or with a path only owner's locks
+// on it
+func (t *LockTable) Release(session, path string, owner uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if path != "" {
+		t.setLocked(path, LockOwner{Session: session, Owner: owner}, LockUnlock, 0, maxOffset)
+		return
+	}
+	t.dropSessionLocked(session)
+	delete(t.sessions, session)
+	t.signalLocked()
+}
+
+// maxOffset is the end of a lock running to the end of the file
+const maxOffset = int64(^uint64(0) >> 1)
+
+// touchLocked records that session was seen at now, and expires the
+// sessions that haven't been
+func (t *LockTable) touchLocked(session string, now time.Time) {
+	expired := false
+	for s, seen := range t.sessions {
+		if s != session && now.Sub(seen) > t.ttl {
+			t.dropSessionLocked(s)
+			delete(t.sessions, s)
+			expired = true
+		}
+	}
+	t.sessions[session] = now
+	if expired {
+		t.signalLocked()
+	}
+}
+
+func (t *LockTable) dropSessionLocked(session string) {
+	for path, records := range t.locks {
+		kept := records[:0]
+		for _, r := range records {
+			if r.owner.Session != session {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) == 0 {
+			delete(t.locks, path)
+		} else {
+			t.locks[path] = kept
+		}
+	}
+}
+
+func (t *LockTable) conflictLocked(path string, owner LockOwner, typ LockType, start, end int64) *lockRecord {
+	if typ == LockUnlock {
+		return nil
+	}
+	for i, r := range t.locks[path] {
+		if r.owner != owner && r.overlaps(start, end) && (typ == LockExclusive || r.typ == LockExclusive) {
+			return &t.locks[path][i]
+		}
+	}
+	return nil
+}
+
+// setLocked replaces owner's locks on [start, end] with a lock of type typ,
+// splitting the locks that only partly overlap the range
+func (t *LockTable) setLocked(path string, owner LockOwner, typ LockType, start, end int64) {
+	var records []lockRecord
+	released := false
+	for _, r := range t.locks[path] {
+		if r.owner != owner || !r.overlaps(start, end) {
+			records = append(records, r)
+			continue
+		}
+		if r.typ == LockExclusive || typ == LockUnlock {
+			released = true
+		}
+		if r.start < start {
+			records = append(records, lockRecord{owner: r.owner, typ: r.typ, start: r.start, end: start - 1})
+		}
+		if r.end > end {
+			records = append(records, lockRecord{owner: r.owner, typ: r.typ, start: end + 1, end: r.end})
+		}
+	}
+	if typ != LockUnlock {
+		records = append(records, lockRecord{owner: owner, typ: typ, start: start, end: end})
+	}
+
+	if len(records) == 0 {
+		delete(t.locks, path)
+	} else {
+		t.locks[path] = records
+	}
+	if released {
+		t.signalLocked()
+	}
+}
+
+// signalLocked wakes up the lock requests waiting for a lock to be released
+func (t *LockTable) signalLocked() {
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
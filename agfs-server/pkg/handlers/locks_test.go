@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestLockTableConflicts(t *testing.T) {
+	table := NewLockTable(time.Minute)
+	a := LockOwner{Session: "a", Owner: 1}
+	b := LockOwner{Session: "b", Owner: 1}
+	ctx := context.Background()
+
+	if conflict, _ := table.Lock(ctx, "/f", a, LockShared, 0, 99, 0); conflict != nil {
+		t.Fatalf("Expected the first lock to be granted, got conflict %+v", conflict)
+	}
+	if conflict, _ := table.Lock(ctx, "/f", b, LockShared, 50, 149, 0); conflict != nil {
+		t.Errorf("Expected shared locks not to conflict, got %+v", conflict)
+	}
+	conflict, _ := table.Lock(ctx, "/f", b, LockExclusive, 90, 200, 0)
+	if conflict == nil || conflict.Start != 0 || conflict.End != 99 {
+		t.Fatalf("Expected a's lock to block an exclusive lock, got %+v", conflict)
+	}
+
+	// Unlocking the middle of a's range splits it, freeing bytes 10-99
+	table.Lock(ctx, "/f", a, LockUnlock, 10, 99, 0)
+	if conflict := table.Test("/f", b, LockExclusive, 10, 49); conflict != nil {
+		t.Errorf("Expected the unlocked range to be free, got %+v", conflict)
+	}
+	if conflict := table.Test("/f", b, LockExclusive, 5, 20); conflict == nil || conflict.End != 9 {
+		t.Errorf("Expected the rest of a's lock to remain, got %+v", conflict)
+	}
+
+	// An owner's own locks never conflict
+	if conflict, _ := table.Lock(ctx, "/f", a, LockExclusive, 0, 9, 0); conflict != nil {
+		t.Errorf("Expected a to upgrade its own lock, got %+v", conflict)
+	}
+}
+
+func TestLockTableWaitsForRelease(t *testing.T) {
+	table := NewLockTable(time.Minute)
+	a := LockOwner{Session: "a", Owner: 1}
+	b := LockOwner{Session: "b", Owner: 2}
+	ctx := context.Background()
+	table.Lock(ctx, "/f", a, LockExclusive, 0, maxOffset, 0)
+
+	done := make(chan *LockInfo, 1)
+	go func() {
+		conflict, _ := table.Lock(ctx, "/f", b, LockExclusive, 0, maxOffset, 10*time.Second)
+		done <- conflict
+	}()
+	time.Sleep(20 * time.Millisecond)
+	table.Release("a", "", 0)
+
+	select {
+	case conflict := <-done:
+		if conflict != nil {
+			t.Errorf("Expected the waiting lock to be granted, got %+v", conflict)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Waiting lock was not granted after the release")
+	}
+
+	// A waiting lock gives up when its request is cancelled
+	cancelled, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	if _, err := table.Lock(cancelled, "/f", a, LockShared, 0, 0, 10*time.Second); err != context.DeadlineExceeded {
+		t.Errorf("Expected the wait to end with the context, got %v", err)
+	}
+}
+
+func TestLockTableExpiresDisconnectedSessions(t *testing.T) {
+	table := NewLockTable(50 * time.Millisecond)
+	a := LockOwner{Session: "a", Owner: 1}
+	b := LockOwner{Session: "b", Owner: 1}
+	ctx := context.Background()
+	table.Lock(ctx, "/f", a, LockExclusive, 0, maxOffset, 0)
+
+	if n := table.Renew("a"); n != 1 {
+		t.Errorf("Expected a to hold 1 lock, got %d", n)
+	}
+
+	// a stops renewing; b's wait outlasts a's session
+	start := time.Now()
+	conflict, err := table.Lock(ctx, "/f", b, LockExclusive, 0, maxOffset, 5*time.Second)
+	if err != nil || conflict != nil {
+		t.Fatalf("Expected the lock of the expired session to be dropped, got %+v, %v", conflict, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the lock shortly after the session expired, took %v", elapsed)
+	}
+	if n := table.Renew("a"); n != 0 {
+		t.Errorf("Expected the expired session to hold no locks, got %d", n)
+	}
+}
+
+func TestLockEndpoints(t *testing.T) {
+	server := newTestServer(t)
+
+	lock := func(session, typ string) *http.Response {
+		resp, err := http.Post(server.URL+"/api/v1/locks?path=/mem/f&owner=7&type="+typ+"&session="+session, "", nil)
+		if err != nil {
+			t.Fatalf("Lock request failed: %v", err)
+		}
+		return resp
+	}
+
+	resp := lock("a", "exclusive")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+
+	resp = lock("b", "shared")
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusLocked {
+		t.Fatalf("Expected 423 for a conflicting lock, got %d", resp.StatusCode)
+	}
+	var conflict LockConflictResponse
+	if err := json.NewDecoder(resp.Body).Decode(&conflict); err != nil {
+		t.Fatalf("Failed to decode conflict: %v", err)
+	}
+	if conflict.Lock == nil || conflict.Lock.Owner != 7 || conflict.Lock.Type != LockExclusive {
+		t.Errorf("Expected the conflicting lock, got %+v", conflict.Lock)
+	}
+
+	resp = lock("b", "bogus")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unknown lock type, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// metaStamper reports the same reserved and plugin keys for every file
+type metaStamper struct {
+	filesystem.Wrapper
+}
+
+func (m *metaStamper) stamp(info *filesystem.FileInfo) {
+	info.Meta.SetContentType("text/csv; charset=utf-8")
+	info.Meta.SetETag("v1-" + info.Name)
+	info.Meta.SetAccessSemantics(filesystem.AccessRandom)
+	info.Meta.Set("owner", "alice")
+}
+
+func (m *metaStamper) Stat(path string) (*filesystem.FileInfo, error) {
+	info, err := m.Inner.Stat(path)
+	if err == nil {
+		m.stamp(info)
+	}
+	return info, err
+}
+
+func (m *metaStamper) ReadDir(path string) ([]filesystem.FileInfo, error) {
+	entries, err := m.Inner.ReadDir(path)
+	for i := range entries {
+		m.stamp(&entries[i])
+	}
+	return entries, err
+}
+
+func TestMetaRoundTrip(t *testing.T) {
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	plugin := memfs.NewMemFSPlugin()
+	if err := plugin.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	stamp := func(fs filesystem.FileSystem) filesystem.FileSystem {
+		return &metaStamper{filesystem.Wrapper{Inner: fs}}
+	}
+	if err := mfs.Mount("/mem", plugin, stamp); err != nil {
+		t.Fatalf("Failed to mount memfs: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewHandler(mfs, NewTrafficMonitor()).SetupRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := agfs.NewClient(server.URL)
+	if _, err := client.Write("/mem/data.csv", []byte("a,b\n")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	want := map[string]string{
+		filesystem.MetaContentType: "text/csv; charset=utf-8",
+		filesystem.MetaETag:        "v1-data.csv",
+		filesystem.MetaAccess:      filesystem.AccessRandom,
+		"owner":                    "alice",
+	}
+
+	info, err := client.Stat("/mem/data.csv")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if !reflect.DeepEqual(info.Meta.Content, want) {
+		t.Errorf("Stat lost metadata: got %v, want %v", info.Meta.Content, want)
+	}
+	if info.Meta.ContentType() != "text/csv; charset=utf-8" || info.ETag() != "v1-data.csv" || info.Access() != agfs.AccessRandom {
+		t.Errorf("Unexpected accessor values for %+v", info.Meta)
+	}
+
+	entries, err := client.ReadDir("/mem")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	found := false
+	for _, entry := range entries {
+		if entry.Name == "data.csv" {
+			found = true
+			if !reflect.DeepEqual(entry.Meta.Content, want) {
+				t.Errorf("ReadDir lost metadata: got %v, want %v", entry.Meta.Content, want)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected data.csv listed, got %+v", entries)
+	}
+}
@@ -28,11 +28,18 @@ func NewPluginHandler(mfs *mountablefs.MountableFS) *PluginHandler {
 	return &PluginHandler{mfs: mfs}
 }
 
+// mfsFor returns the view of the mount namespace of the principal r is
+// made by, so mounts made and listed are the principal's
+func (ph *PluginHandler) mfsFor(r *http.Request) *mountablefs.MountableFS {
+	return ph.mfs.Namespace(mountablefs.PrincipalFromContext(r.Context()))
+}
+
 // MountInfo represents information about a mounted plugin
 type MountInfo struct {
 	Path       string                 `json:"path"`
 	PluginName string                 `json:"pluginName"`
 	Config     map[string]interface{} `json:"config,omitempty"`
+	Usage      *mountablefs.Usage     `json:"usage,omitempty"` // With ?usage=true
 }
 
 // ListMountsResponse represents the response for listing mounts
@@ -40,17 +47,28 @@ type ListMountsResponse struct {
 	Mounts []MountInfo `json:"mounts"`
 }
 
-// ListMounts handles GET /mounts
+// ListMounts handles GET /mounts. With ?usage=true, each mount also reports
+// how much it holds.
 func (ph *PluginHandler) ListMounts(w http.ResponseWriter, r *http.Request) {
-	mounts := ph.mfs.GetMounts()
+	mfs := ph.mfsFor(r)
+	mounts := mfs.GetMounts()
+	withUsage := r.URL.Query().Get("usage") == "true"
 
 	var mountInfos []MountInfo
 	for _, mount := range mounts {
-		mountInfos = append(mountInfos, MountInfo{
+		info := MountInfo{
 			Path:       mount.Path,
 			PluginName: mount.Plugin.Name(),
 			Config:     mount.Config,
-		})
+		}
+		if withUsage {
+			usage, err := mfs.Usage(mount.Path)
+			if err != nil {
+				log.Warnf("Failed to count the usage of %s: %v", mount.Path, err)
+			}
+			info.Usage = usage
+		}
+		mountInfos = append(mountInfos, info)
 	}
 
 	writeJSON(w, http.StatusOK, ListMountsResponse{Mounts: mountInfos})
@@ -74,7 +92,7 @@ func (ph *PluginHandler) Unmount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := ph.mfs.Unmount(req.Path); err != nil {
+	if err := ph.mfsFor(r).Unmount(req.Path); err != nil {
 		writeError(w, http.StatusInternalServerError, err.Error())
 		return
 	}
@@ -87,6 +105,20 @@ type MountRequest struct {
 	FSType string                 `json:"fstype"`
 	Path   string                 `json:"path"`
 	Config map[string]interface{} `json:"config"`
+
+	// Octal modes reported for files and directories the plugin reports
+	// none for, e.g. "0640" (empty = 0644 and 0755)
+	DefaultFileMode string `json:"default_file_mode,omitempty"`
+	DefaultDirMode  string `json:"default_dir_mode,omitempty"`
+
+	// Move removed files to the mount's .agfs-trash, keeping them for
+	// TrashRetention, e.g. "72h" (empty = 7 days)
+	Trash          bool   `json:"trash,omitempty"`
+	TrashRetention string `json:"trash_retention,omitempty"`
+
+	// What happens to a write overlapping a write to the same file in
+	// progress: "last-writer-wins" (default) or "error"
+	WriteConflicts string `json:"write_conflicts,omitempty"`
 }
 
 // Mount handles POST /mount
@@ -107,12 +139,34 @@ func (ph *PluginHandler) Mount(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := ph.mfs.MountPlugin(req.FSType, req.Path, req.Config); err != nil {
+	opts, err := mountablefs.ParseMountOptions(req.DefaultFileMode, req.DefaultDirMode)
+	if err == nil {
+		opts.Trash = req.Trash
+		opts.TrashRetention, err = mountablefs.ParseTrashRetention(req.TrashRetention)
+	}
+	if err == nil {
+		opts.WriteConflicts, err = mountablefs.ParseWriteConflictPolicy(req.WriteConflicts)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := ph.mfsFor(r).MountPluginWithOptions(req.FSType, req.Path, req.Config, opts); err != nil {
 		// First check for typed errors
 		if errors.Is(err, filesystem.ErrAlreadyExists) {
 			writeError(w, http.StatusConflict, err.Error())
 			return
 		}
+		if errors.Is(err, filesystem.ErrNotSupported) {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		var initErr *plugin.PluginInitError
+		if errors.As(err, &initErr) && !initErr.Validated {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
 
 		// For backward compatibility, check string-based errors that aren't typed yet
 		errMsg := err.Error()
@@ -358,7 +412,7 @@ type ListPluginsResponse struct {
 // ListPlugins handles GET /plugins
 func (ph *PluginHandler) ListPlugins(w http.ResponseWriter, r *http.Request) {
 	// Get all mounts
-	mounts := ph.mfs.GetMounts()
+	mounts := ph.mfsFor(r).GetMounts()
 
 	// Build a map of plugin name -> mount info and plugin instance
 	pluginMountsMap := make(map[string][]PluginMountInfo)
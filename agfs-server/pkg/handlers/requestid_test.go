@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+func TestLoggingMiddlewareRequestIDs(t *testing.T) {
+	var seen string
+	handler := LoggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = filesystem.RequestIDFromContext(r.Context())
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	for _, tc := range []struct {
+		sent string
+		kept bool
+	}{
+		{"fuse-1a2b.3", true},
+		{"", false},
+		{"has spaces", false},
+		{"x\ny", false},
+		{strings.Repeat("a", maxRequestIDLength+1), false},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/stat?path=/", nil)
+		if tc.sent != "" {
+			req.Header.Set(RequestIDHeader, tc.sent)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		got := rec.Header().Get(RequestIDHeader)
+		if got == "" || got != seen {
+			t.Errorf("Expected the returned ID %q bound to the context, got %q", got, seen)
+		}
+		if tc.kept != (got == tc.sent) {
+			t.Errorf("Sent %q, got %q back", tc.sent, got)
+		}
+		if rec.Code != http.StatusInternalServerError {
+			t.Errorf("Expected the status passed through, got %d", rec.Code)
+		}
+	}
+}
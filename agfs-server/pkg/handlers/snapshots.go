@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// snapshotTaker is implemented by file systems that take snapshots of their
+// directories
+type snapshotTaker interface {
+	Snapshot(path, name string) error
+	ListSnapshots(path string) ([]filesystem.SnapshotInfo, error)
+}
+
+// SnapshotsResponse is the response of GET /snapshots
+type SnapshotsResponse struct {
+	Snapshots []filesystem.SnapshotInfo `json:"snapshots"`
+}
+
+// Snapshots handles GET /snapshots?path=<dir>, listing the snapshots of a
+// directory, and POST /snapshots?path=<dir>&name=<name>, taking one
+func (h *Handler) Snapshots(w http.ResponseWriter, r *http.Request) {
+	taker, ok := h.fsFor(r).(snapshotTaker)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "snapshots not supported")
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		writeError(w, http.StatusBadRequest, "path parameter is required")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		snapshots, err := taker.ListSnapshots(path)
+		if err != nil {
+			writeError(w, mapErrorToStatus(err), err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, SnapshotsResponse{Snapshots: snapshots})
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		writeError(w, http.StatusBadRequest, "name parameter is required")
+		return
+	}
+	if err := taker.Snapshot(path, name); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, SuccessResponse{Message: "snapshot created"})
+}
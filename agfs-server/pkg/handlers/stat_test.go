@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestStatHead(t *testing.T) {
+	server := newTestServer(t)
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/files?path=/mem/f", strings.NewReader("data"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	resp.Body.Close()
+
+	for path, status := range map[string]int{"/mem/f": http.StatusOK, "/mem/missing": http.StatusNotFound} {
+		resp, err := http.Head(server.URL + "/api/v1/stat?path=" + path)
+		if err != nil {
+			t.Fatalf("HEAD failed: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != status || len(body) != 0 {
+			t.Errorf("Expected HEAD %s to answer %d without a body, got %d with %d bytes", path, status, resp.StatusCode, len(body))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// flagRecorder records the flags of every Write reaching the plugin
+type flagRecorder struct {
+	filesystem.Wrapper
+	flags *[]filesystem.WriteFlag
+}
+
+func (f *flagRecorder) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	*f.flags = append(*f.flags, flags)
+	return f.Wrapper.Write(path, data, offset, flags)
+}
+
+func TestWriteFileSync(t *testing.T) {
+	var flags []filesystem.WriteFlag
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	plugin := memfs.NewMemFSPlugin()
+	if err := plugin.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	record := func(fs filesystem.FileSystem) filesystem.FileSystem {
+		return &flagRecorder{Wrapper: filesystem.Wrapper{Inner: fs}, flags: &flags}
+	}
+	if err := mfs.Mount("/mem", plugin, record); err != nil {
+		t.Fatalf("Failed to mount memfs: %v", err)
+	}
+	mux := http.NewServeMux()
+	NewHandler(mfs, NewTrafficMonitor()).SetupRoutes(mux)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	for _, query := range []string{"", "&flags=sync"} {
+		req, _ := http.NewRequest(http.MethodPut, server.URL+"/api/v1/files?path=/mem/f"+query, strings.NewReader("data"))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", resp.StatusCode)
+		}
+	}
+
+	if len(flags) != 2 || flags[0]&filesystem.WriteFlagSync != 0 || flags[1]&filesystem.WriteFlagSync == 0 {
+		t.Errorf("Expected only the second write to sync, got %v", flags)
+	}
+}
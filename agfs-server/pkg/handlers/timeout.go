@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+// maxTimeoutMargin bounds the time kept back from a request's timeout for
+// its response to reach the client
+const maxTimeoutMargin = time.Second
+
+// TimeoutMiddleware bounds the context of cancellable requests sent with an
+// agfs.TimeoutHeader by the time their client waits, less a margin for the
+// response to reach it. Operations stuck waiting, such as for an instance
+// of a busy WASM plugin, then fail with 504 while the client still listens,
+// instead of carrying on after it gave up.
+func TimeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ms, err := strconv.ParseInt(r.Header.Get(agfs.TimeoutHeader), 10, 64)
+		if err != nil || ms <= 0 || !cancellable(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		timeout := time.Duration(ms) * time.Millisecond
+		margin := timeout / 10
+		if margin > maxTimeoutMargin {
+			margin = maxTimeoutMargin
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout-margin)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// cancellable reports whether r may be given up on part way. Requests that
+// only read leave nothing behind, while a write cut short by its deadline
+// could be left partly applied, so writes run to the end and are only
+// cancelled when their client disconnects.
+func cancellable(r *http.Request) bool {
+	return r.Method == http.MethodGet || r.Method == http.MethodHead
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	agfs "github.com/c4pt0r/agfs/agfs-sdk/go"
+)
+
+func TestTimeoutMiddleware(t *testing.T) {
+	var left time.Duration
+	var bounded bool
+	handler := TimeoutMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var deadline time.Time
+		deadline, bounded = r.Context().Deadline()
+		left = time.Until(deadline)
+	}))
+
+	for _, tc := range []struct {
+		method string
+		header string
+		want   bool
+	}{
+		{http.MethodGet, "", false},
+		{http.MethodGet, "soon", false},
+		{http.MethodGet, "-5", false},
+		// Writes aren't cut short, whatever their client waits
+		{http.MethodPut, "2000", false},
+		{http.MethodDelete, "2000", false},
+		{http.MethodGet, "2000", true},
+	} {
+		req := httptest.NewRequest(tc.method, "/api/v1/stat?path=/", nil)
+		if tc.header != "" {
+			req.Header.Set(agfs.TimeoutHeader, tc.header)
+		}
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if bounded != tc.want {
+			t.Errorf("%s %q: Expected a deadline %v, got %v", tc.method, tc.header, tc.want, bounded)
+		}
+	}
+	// The response is left time to reach the client
+	if left > 1800*time.Millisecond || left < time.Second {
+		t.Errorf("Expected about 1.8s left, got %v", left)
+	}
+
+	err := fmt.Errorf("timeout waiting for available WASM instance: %w", context.DeadlineExceeded)
+	if status := mapErrorToStatus(err); status != http.StatusGatewayTimeout {
+		t.Errorf("Expected a deadline to map to 504, got %d", status)
+	}
+}
@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// transactor is implemented by file systems that run transactions on the
+// mount of a path
+type transactor interface {
+	BeginTx(path string) (filesystem.Tx, error)
+}
+
+// TxOp is an operation of a transaction request. Writes replace the file,
+// creating it if needed, unless Offset is set: they then write at Offset,
+// creating the file but keeping the rest of its content.
+type TxOp struct {
+	Op      string `json:"op"` // "write", "remove" or "rename"
+	Path    string `json:"path"`
+	NewPath string `json:"newPath,omitempty"` // Destination of a rename
+	Data    []byte `json:"data,omitempty"`    // Content of a write, base64 in JSON
+	Offset  *int64 `json:"offset,omitempty"`  // Offset of a write
+}
+
+// TxRequest is the body of POST /tx
+type TxRequest struct {
+	Ops []TxOp `json:"ops"`
+}
+
+// Transaction handles POST /tx, applying the operations of the request, all
+// on the mount of the first one, at once: readers see either none of them
+// or every one, and if any fails none are applied.
+func (h *Handler) Transaction(w http.ResponseWriter, r *http.Request) {
+	t, ok := h.fsFor(r).(transactor)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "transactions not supported")
+		return
+	}
+	var req TxRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Ops) == 0 || len(req.Ops) > MaxBatchOps {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("a transaction takes 1 to %d operations, got %d", MaxBatchOps, len(req.Ops)))
+		return
+	}
+	for i, op := range req.Ops {
+		switch {
+		case op.Op != "write" && op.Op != "remove" && op.Op != "rename":
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("operation %d: unsupported transaction operation: %q", i, op.Op))
+			return
+		case op.Path == "" || (op.Op == "rename" && op.NewPath == ""):
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("operation %d: path is required, and newPath for a rename", i))
+			return
+		}
+	}
+
+	tx, err := t.BeginTx(req.Ops[0].Path)
+	if err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	for i, op := range req.Ops {
+		if err := stageTxOp(tx, op); err != nil {
+			tx.Rollback()
+			writeError(w, mapErrorToStatus(err), fmt.Sprintf("operation %d: %v", i, err))
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		writeError(w, mapErrorToStatus(err), err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, SuccessResponse{Message: "committed"})
+}
+
+// stageTxOp stages op on tx
+func stageTxOp(tx filesystem.Tx, op TxOp) error {
+	switch op.Op {
+	case "remove":
+		return tx.Remove(op.Path)
+	case "rename":
+		return tx.Rename(op.Path, op.NewPath)
+	}
+	offset, flags := int64(-1), filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate
+	if op.Offset != nil {
+		offset, flags = *op.Offset, filesystem.WriteFlagCreate
+	}
+	_, err := tx.Write(op.Path, op.Data, offset, flags)
+	return err
+}
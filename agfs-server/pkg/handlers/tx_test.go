@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// postTx sends ops as a transaction request and returns its status
+func postTx(t *testing.T, url string, ops ...TxOp) int {
+	t.Helper()
+	body, _ := json.Marshal(TxRequest{Ops: ops})
+	resp, err := http.Post(url+"/api/v1/tx", "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Transaction failed: %v", err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestTransaction(t *testing.T) {
+	server := newTestServer(t)
+	status, _ := postBatch(t, server.URL,
+		BatchOp{Op: "create", Params: map[string]string{"path": "/mem/data"}},
+		BatchOp{Op: "create", Params: map[string]string{"path": "/mem/index"}},
+	)
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	read := func(path string) (int, string) {
+		resp, err := http.Get(server.URL + "/api/v1/files?path=" + path)
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		defer resp.Body.Close()
+		var buf bytes.Buffer
+		buf.ReadFrom(resp.Body)
+		return resp.StatusCode, buf.String()
+	}
+
+	// A failing operation leaves everything as it was
+	status = postTx(t, server.URL,
+		TxOp{Op: "rename", Path: "/mem/data", NewPath: "/mem/data.v2"},
+		TxOp{Op: "write", Path: "/mem/index", Data: []byte("data.v2")},
+		TxOp{Op: "remove", Path: "/mem/missing"},
+	)
+	if status != http.StatusNotFound {
+		t.Errorf("Expected 404 for the missing file, got %d", status)
+	}
+	if status, _ := read("/mem/data"); status != http.StatusOK {
+		t.Errorf("Expected /mem/data kept, got %d", status)
+	}
+	if _, index := read("/mem/index"); index != "" {
+		t.Errorf("Expected the index unchanged, got %q", index)
+	}
+
+	status = postTx(t, server.URL,
+		TxOp{Op: "rename", Path: "/mem/data", NewPath: "/mem/data.v2"},
+		TxOp{Op: "write", Path: "/mem/index", Data: []byte("data.v2")},
+	)
+	if status != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", status)
+	}
+	if status, _ := read("/mem/data.v2"); status != http.StatusOK {
+		t.Errorf("Expected /mem/data renamed, got %d", status)
+	}
+	if _, index := read("/mem/index"); index != "data.v2" {
+		t.Errorf("Expected the index written, got %q", index)
+	}
+
+	if status := postTx(t, server.URL, TxOp{Op: "chmod", Path: "/mem/index"}); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an unsupported operation, got %d", status)
+	}
+}
@@ -0,0 +1,300 @@
+// Package httpgw serves the files of a filesystem.FileSystem over plain HTTP,
+// so browsers and web apps can fetch them by URL without the agfs API
+package httpgw
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultChunkSize is how much of a file is read from the file system per
+// Read call while streaming it to the client
+const DefaultChunkSize = 256 * 1024
+
+// Gateway is an http.Handler serving GET and HEAD requests for the files
+// of a file system, with the URL path as the file path. Files are streamed
+// in chunks with support for single byte ranges; directories are listed as
+// HTML, or as JSON when the client accepts application/json.
+type Gateway struct {
+	fs        filesystem.FileSystem
+	chunkSize int64
+}
+
+// New creates a gateway serving fs
+func New(fs filesystem.FileSystem) *Gateway {
+	return &Gateway{fs: fs, chunkSize: DefaultChunkSize}
+}
+
+// DirEntry is an entry of a JSON directory listing
+type DirEntry struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    uint32    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	fs := filesystem.WithContext(g.fs, r.Context())
+	name := path.Clean("/" + r.URL.Path)
+	info, err := fs.Stat(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !info.ModTime.IsZero() {
+		w.Header().Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+		if notModified(r, info.ModTime) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if info.IsDir {
+		// Directory URLs end in a slash so relative links in the listing work
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(name)+"/", http.StatusMovedPermanently)
+			return
+		}
+		g.serveDir(w, r, fs, name)
+		return
+	}
+	g.serveFile(w, r, fs, name, info)
+}
+
+// notModified reports whether r's If-Modified-Since is at or after modTime
+func notModified(r *http.Request, modTime time.Time) bool {
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have second precision
+	return !modTime.Truncate(time.Second).After(t)
+}
+
+// serveDir lists the directory name as JSON or HTML
+func (g *Gateway) serveDir(w http.ResponseWriter, r *http.Request, fs filesystem.FileSystem, name string) {
+	infos, err := fs.ReadDir(name)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+
+	entries := make([]DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = DirEntry{
+			Name:    info.Name,
+			Size:    info.Size,
+			Mode:    info.Mode,
+			ModTime: info.ModTime,
+			IsDir:   info.IsDir,
+		}
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodHead {
+			return
+		}
+		if err := json.NewEncoder(w).Encode(entries); err != nil {
+			log.Warnf("httpgw: failed to write listing of %s: %v", name, err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := listingTemplate.Execute(w, listing{Path: name, Entries: entries}); err != nil {
+		log.Warnf("httpgw: failed to write listing of %s: %v", name, err)
+	}
+}
+
+type listing struct {
+	Path    string
+	Entries []DirEntry
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+	"href": func(e DirEntry) string {
+		if e.IsDir {
+			return url.PathEscape(e.Name) + "/"
+		}
+		return url.PathEscape(e.Name)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{href .}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td><td>{{if not .IsDir}}{{.Size}}{{end}}</td><td>{{.ModTime.UTC.Format "2006-01-02 15:04:05"}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// serveFile streams the file name, or the byte range the client asked for
+func (g *Gateway) serveFile(w http.ResponseWriter, r *http.Request, fs filesystem.FileSystem, name string, info *filesystem.FileInfo) {
+	// Files whose content changes as it's read, and files without a size,
+	// are read with a single whole-file Read: another Read could consume a
+	// second message from a queue
+	sequential := info.Meta.Sequential() || info.Size == 0
+
+	w.Header().Set("Content-Type", filesystem.ContentType(fs, name, info))
+	if sequential {
+		if r.Method == http.MethodHead {
+			return
+		}
+		data, err := fs.Read(name, 0, -1)
+		if err != nil && err != io.EOF {
+			writeError(w, err)
+			return
+		}
+		w.Write(data)
+		return
+	}
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	offset, length := int64(0), info.Size
+	status := http.StatusOK
+	if header := r.Header.Get("Range"); header != "" {
+		start, n, ok, err := ParseRange(header, info.Size)
+		if err != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", info.Size))
+			http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		if ok {
+			offset, length = start, n
+			status = http.StatusPartialContent
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, info.Size))
+		}
+	}
+
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	g.copy(w, fs, name, offset, length)
+}
+
+// copy writes length bytes of name from offset to w, one chunk at a time.
+// Once the response has started, errors can only be logged.
+func (g *Gateway) copy(w io.Writer, fs filesystem.FileSystem, name string, offset, length int64) {
+	for length > 0 {
+		size := min(g.chunkSize, length)
+		data, err := fs.Read(name, offset, size)
+		if len(data) > 0 {
+			if _, werr := w.Write(data); werr != nil {
+				return
+			}
+			offset += int64(len(data))
+			length -= int64(len(data))
+		}
+		if err == io.EOF || (err == nil && len(data) == 0) {
+			return
+		}
+		if err != nil {
+			log.Warnf("httpgw: read of %s failed at offset %d: %v", name, offset, err)
+			return
+		}
+	}
+}
+
+// ErrUnsatisfiable is returned for ranges outside the file
+var ErrUnsatisfiable = errors.New("requested range not satisfiable")
+
+// ParseRange parses a Range header for a file of size bytes. It returns the
+// start and length of a single byte range, ok=false for headers it doesn't
+// handle (other units or multiple ranges), which are served as a full
+// response, and ErrUnsatisfiable for ranges outside the file.
+func ParseRange(header string, size int64) (start, length int64, ok bool, err error) {
+	spec, found := strings.CutPrefix(header, "bytes=")
+	if !found || strings.Contains(spec, ",") {
+		return 0, 0, false, nil
+	}
+	first, last, found := strings.Cut(strings.TrimSpace(spec), "-")
+	if !found {
+		return 0, 0, false, nil
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, perr := strconv.ParseInt(last, 10, 64)
+		if perr != nil || n <= 0 {
+			return 0, 0, false, ErrUnsatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, true, nil
+	}
+
+	start, perr := strconv.ParseInt(first, 10, 64)
+	if perr != nil || start < 0 || start >= size {
+		return 0, 0, false, ErrUnsatisfiable
+	}
+	end := size - 1
+	if last != "" {
+		end, perr = strconv.ParseInt(last, 10, 64)
+		if perr != nil || end < start {
+			return 0, 0, false, ErrUnsatisfiable
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true, nil
+}
+
+// writeError maps a filesystem error to an HTTP status
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	if errors.Is(err, os.ErrNotExist) {
+		status = http.StatusNotFound
+	}
+	switch filesystem.KindOf(err) {
+	case filesystem.KindNotFound:
+		status = http.StatusNotFound
+	case filesystem.KindPermissionDenied:
+		status = http.StatusForbidden
+	case filesystem.KindInvalidArgument, filesystem.KindNotDirectory:
+		status = http.StatusBadRequest
+	case filesystem.KindNotSupported:
+		status = http.StatusNotImplemented
+	case filesystem.KindRateLimited:
+		status = http.StatusTooManyRequests
+	}
+	http.Error(w, err.Error(), status)
+}
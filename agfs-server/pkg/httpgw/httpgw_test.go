@@ -0,0 +1,234 @@
+package httpgw
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+const content = "0123456789abcdefghij"
+
+func newTestGateway(t *testing.T) *httptest.Server {
+	t.Helper()
+	fs := memfs.NewMemoryFS()
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	for name, data := range map[string]string{"/file": content, "/dir/page.html": "<p>hi</p>", "/dir/b": "x"} {
+		if _, err := fs.Write(name, []byte(data), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	g := New(fs)
+	g.chunkSize = 7 // exercise multi-chunk copies
+	srv := httptest.NewServer(g)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, srv *httptest.Server, method, path string, header map[string]string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Reading body failed: %v", err)
+	}
+	return resp, string(body)
+}
+
+func TestGatewayServesFile(t *testing.T) {
+	srv := newTestGateway(t)
+
+	resp, body := get(t, srv, http.MethodGet, "/file", nil)
+	if resp.StatusCode != http.StatusOK || body != content {
+		t.Fatalf("Expected 200 with the whole file, got %d %q", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Accept-Ranges") != "bytes" {
+		t.Errorf("Expected Accept-Ranges: bytes, got %q", resp.Header.Get("Accept-Ranges"))
+	}
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("Expected a sniffed text/plain content type, got %q", ct)
+	}
+
+	resp, body = get(t, srv, http.MethodHead, "/file", nil)
+	if resp.StatusCode != http.StatusOK || body != "" || resp.ContentLength != int64(len(content)) {
+		t.Errorf("Expected HEAD to report the length without a body, got %d %d %q", resp.StatusCode, resp.ContentLength, body)
+	}
+
+	resp, _ = get(t, srv, http.MethodGet, "/dir/page.html", nil)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Expected text/html from the extension, got %q", ct)
+	}
+}
+
+func TestGatewayRangeRequests(t *testing.T) {
+	srv := newTestGateway(t)
+
+	tests := []struct {
+		rng          string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=2-5", http.StatusPartialContent, "2345", "bytes 2-5/20"},
+		{"bytes=15-", http.StatusPartialContent, "fghij", "bytes 15-19/20"},
+		{"bytes=-3", http.StatusPartialContent, "hij", "bytes 17-19/20"},
+		{"bytes=5-100", http.StatusPartialContent, content[5:], "bytes 5-19/20"},
+		{"bytes=0-1,4-5", http.StatusOK, content, ""},
+		{"bytes=20-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */20"},
+	}
+	for _, tt := range tests {
+		resp, body := get(t, srv, http.MethodGet, "/file", map[string]string{"Range": tt.rng})
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: expected status %d, got %d", tt.rng, tt.status, resp.StatusCode)
+			continue
+		}
+		if tt.status != http.StatusRequestedRangeNotSatisfiable && body != tt.body {
+			t.Errorf("%s: expected body %q, got %q", tt.rng, tt.body, body)
+		}
+		if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+			t.Errorf("%s: expected Content-Range %q, got %q", tt.rng, tt.contentRange, got)
+		}
+	}
+}
+
+func TestGatewayNotFound(t *testing.T) {
+	for _, err := range []error{
+		filesystem.NewNotFoundError("stat", "/x"),
+		fmt.Errorf("lookup failed: %w", filesystem.NewNotFoundError("stat", "/x")),
+		os.ErrNotExist,
+	} {
+		g := New(statErrorFS{err: err})
+		rec := httptest.NewRecorder()
+		g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/x", nil))
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("Expected %q to map to 404, got %d", err, rec.Code)
+		}
+	}
+
+	g := New(statErrorFS{err: filesystem.NewPermissionDeniedError("stat", "/x", "denied")})
+	rec := httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/x", nil))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected a permission error to map to 403, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	g.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/x", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for POST, got %d", rec.Code)
+	}
+}
+
+// statErrorFS fails every Stat with err
+type statErrorFS struct {
+	filesystem.FileSystem
+	err error
+}
+
+func (fs statErrorFS) Stat(path string) (*filesystem.FileInfo, error) {
+	return nil, fs.err
+}
+
+func TestGatewayIfModifiedSince(t *testing.T) {
+	srv := newTestGateway(t)
+
+	resp, _ := get(t, srv, http.MethodGet, "/file", nil)
+	lastModified := resp.Header.Get("Last-Modified")
+	if lastModified == "" {
+		t.Fatal("Expected a Last-Modified header")
+	}
+
+	resp, body := get(t, srv, http.MethodGet, "/file", map[string]string{"If-Modified-Since": lastModified})
+	if resp.StatusCode != http.StatusNotModified || body != "" {
+		t.Errorf("Expected 304 for an unchanged file, got %d %q", resp.StatusCode, body)
+	}
+
+	earlier := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	resp, _ = get(t, srv, http.MethodGet, "/file", map[string]string{"If-Modified-Since": earlier})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 for a file modified since, got %d", resp.StatusCode)
+	}
+}
+
+func TestGatewayDirectoryListing(t *testing.T) {
+	srv := newTestGateway(t)
+
+	resp, body := get(t, srv, http.MethodGet, "/dir/", map[string]string{"Accept": "application/json"})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var entries []DirEntry
+	if err := json.Unmarshal([]byte(body), &entries); err != nil {
+		t.Fatalf("Expected a JSON listing, got %q: %v", body, err)
+	}
+	if len(entries) != 2 || entries[0].Name != "b" || entries[1].Name != "page.html" {
+		t.Errorf("Expected entries b and page.html, got %+v", entries)
+	}
+
+	resp, body = get(t, srv, http.MethodGet, "/dir/", nil)
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") || !strings.Contains(body, `<a href="page.html">`) {
+		t.Errorf("Expected an HTML listing linking page.html, got %q", body)
+	}
+
+	// Without the trailing slash the client is redirected to the listing
+	resp, _ = get(t, srv, http.MethodGet, "/dir", nil)
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/dir/" {
+		t.Errorf("Expected a redirect to /dir/, ended at %s with %d", resp.Request.URL.Path, resp.StatusCode)
+	}
+}
+
+// typedFS reports a content type for the files in types
+type typedFS struct {
+	filesystem.FileSystem
+	types map[string]string
+}
+
+func (fs typedFS) Stat(name string) (*filesystem.FileInfo, error) {
+	info, err := fs.FileSystem.Stat(name)
+	if err == nil && fs.types[name] != "" {
+		info.Meta.Content = map[string]string{filesystem.MetaContentType: fs.types[name]}
+	}
+	return info, err
+}
+
+func TestGatewayPluginContentType(t *testing.T) {
+	fs := memfs.NewMemoryFS()
+	for _, name := range []string{"/typed.html", "/untyped"} {
+		if _, err := fs.Write(name, []byte("<p>hi</p>"), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	srv := httptest.NewServer(New(typedFS{FileSystem: fs, types: map[string]string{"/typed.html": "application/x-custom"}}))
+	t.Cleanup(srv.Close)
+
+	// The plugin's type wins over the extension and the content
+	resp, _ := get(t, srv, http.MethodGet, "/typed.html", nil)
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-custom" {
+		t.Errorf("Expected the plugin's content type, got %q", ct)
+	}
+	resp, _ = get(t, srv, http.MethodGet, "/untyped", nil)
+	if ct := resp.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Expected a sniffed content type with its charset, got %q", ct)
+	}
+}
@@ -0,0 +1,87 @@
+package mountablefs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultCapabilityRefreshInterval is how long the capabilities reported by
+// a mount's file system are cached unless SetCapabilityRefreshInterval says
+// otherwise
+const DefaultCapabilityRefreshInterval = 30 * time.Second
+
+// capsCache holds the capabilities last reported by the file system of a
+// mount implementing plugin.CapabilityReporter
+type capsCache struct {
+	path     string
+	reporter plugin.CapabilityReporter
+	state    *mountState
+
+	mu         sync.Mutex
+	caps       plugin.CapabilitySet
+	updated    time.Time // Zero until the first report
+	refreshing bool
+}
+
+// SetCapabilityRefreshInterval sets how long the capabilities reported by
+// the file systems of mounts are cached before they are asked again
+// (0 = DefaultCapabilityRefreshInterval)
+func (mfs *MountableFS) SetCapabilityRefreshInterval(d time.Duration) {
+	mfs.capsRefresh.Store(int64(d))
+}
+
+// newCapsCache returns the cache of the capabilities reported by the file
+// system of mount, starting from those its plugin declared, or nil if the
+// file system doesn't report any
+func (mfs *MountableFS) newCapsCache(mount *MountPoint) *capsCache {
+	reporter, ok := mount.Plugin.GetFileSystem().(plugin.CapabilityReporter)
+	if !ok {
+		return nil
+	}
+	return &capsCache{path: mount.Path, reporter: reporter, state: mfs.mountState, caps: mount.Capabilities}
+}
+
+// capabilities returns the operations the mount supports: those its file
+// system last reported if it implements plugin.CapabilityReporter, and those
+// its plugin declared otherwise
+func (m *MountPoint) capabilities() plugin.CapabilitySet {
+	if m.caps == nil {
+		return m.Capabilities
+	}
+	return m.caps.get()
+}
+
+// get returns the cached capabilities, asking the file system again if they
+// are out of date. Operations running while they are asked use the previous
+// ones, and a failed report keeps them until the next refresh.
+func (c *capsCache) get() plugin.CapabilitySet {
+	interval := time.Duration(c.state.capsRefresh.Load())
+	if interval <= 0 {
+		interval = DefaultCapabilityRefreshInterval
+	}
+
+	c.mu.Lock()
+	if c.refreshing || (!c.updated.IsZero() && c.state.now().Sub(c.updated) < interval) {
+		caps := c.caps
+		c.mu.Unlock()
+		return caps
+	}
+	c.refreshing = true
+	c.mu.Unlock()
+
+	caps, err := c.reporter.Capabilities()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+	c.updated = c.state.now()
+	if err != nil {
+		log.Warnf("Failed to refresh the capabilities of %s, keeping the previous ones: %v", c.path, err)
+		return c.caps
+	}
+	c.caps = caps
+	return caps
+}
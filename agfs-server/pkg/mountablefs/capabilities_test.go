@@ -0,0 +1,185 @@
+package mountablefs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// renameCountingFS counts the Rename calls that reach the plugin
+type renameCountingFS struct {
+	filesystem.FileSystem
+	renames int
+}
+
+func (fs *renameCountingFS) Rename(oldPath, newPath string) error {
+	fs.renames++
+	return fs.FileSystem.Rename(oldPath, newPath)
+}
+
+// noRenamePlugin is a memfs plugin that declares no rename support
+type noRenamePlugin struct {
+	plugin.ServicePlugin
+	fs *renameCountingFS
+}
+
+func (p *noRenamePlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *noRenamePlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().Without(plugin.CapabilityRename)
+}
+
+func newNoRenamePlugin(t *testing.T) *noRenamePlugin {
+	t.Helper()
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	return &noRenamePlugin{ServicePlugin: p, fs: &renameCountingFS{FileSystem: p.GetFileSystem()}}
+}
+
+func TestUndeclaredRenameFailsFast(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := newNoRenamePlugin(t)
+	if err := mfs.Mount("/mnt", p); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if _, err := mfs.Write("/mnt/a", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	err := mfs.Rename("/mnt/a", "/mnt/b")
+	if !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Fatalf("Expected a not supported error, got %v", err)
+	}
+	if p.fs.renames != 0 {
+		t.Errorf("Expected the plugin not to be called, got %d renames", p.fs.renames)
+	}
+	if _, err := mfs.Stat("/mnt/a"); err != nil {
+		t.Errorf("Expected /mnt/a to be untouched, got %v", err)
+	}
+}
+
+func TestMountCapabilities(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	mem := memfs.NewMemFSPlugin()
+	if err := mem.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	if err := mfs.Mount("/mem", mem); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.Mount("/norename", newNoRenamePlugin(t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+
+	caps := mfs.MountCapabilities()
+	has := func(mount, c string) bool {
+		for _, name := range caps[mount] {
+			if name == c {
+				return true
+			}
+		}
+		return false
+	}
+	if !has("/mem", "handles") || !has("/mem", "truncate") || !has("/mem", "rename") {
+		t.Errorf("Expected memfs to declare handles, truncate and rename, got %v", caps["/mem"])
+	}
+	if has("/norename", "rename") || !has("/norename", "write") {
+		t.Errorf("Expected /norename to declare write but not rename, got %v", caps["/norename"])
+	}
+}
+
+// proxyPlugin is a memfs whose file system reports the capabilities of a
+// backend that changes
+type proxyPlugin struct {
+	*memfs.MemFSPlugin
+	fs *reportingFS
+}
+
+func (p *proxyPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+type reportingFS struct {
+	filesystem.FileSystem
+	mu    sync.Mutex
+	caps  plugin.CapabilitySet
+	err   error
+	asked int
+}
+
+func (f *reportingFS) Capabilities() (plugin.CapabilitySet, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.asked++
+	return f.caps, f.err
+}
+
+func (f *reportingFS) report(caps plugin.CapabilitySet, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.caps, f.err = caps, err
+}
+
+func TestMountCapabilitiesRefresh(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	clock := time.Unix(1700000000, 0)
+	mfs.now = func() time.Time { return clock }
+	mfs.SetCapabilityRefreshInterval(time.Minute)
+
+	mem := newMemFS(t)
+	fs := &reportingFS{FileSystem: mem.GetFileSystem(), caps: plugin.BaselineCapabilities()}
+	if err := mfs.Mount("/proxy", &proxyPlugin{MemFSPlugin: mem, fs: fs}); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	write := func() error {
+		_, err := mfs.Write("/proxy/f", []byte("data"), 0, filesystem.WriteFlagCreate)
+		return err
+	}
+	if err := write(); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// The reported set replaces the declared one, which has truncate
+	if err := mfs.Truncate("/proxy/f", 1); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected truncate unsupported as reported, got %v", err)
+	}
+
+	// The backend turns read-only: the mount follows once the interval is up
+	fs.report(plugin.BaselineCapabilities().Without(plugin.CapabilityWrite), nil)
+	if err := write(); err != nil {
+		t.Errorf("Expected the cached capabilities used within the interval, got %v", err)
+	}
+	clock = clock.Add(time.Minute)
+	if err := write(); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected write unsupported after a refresh, got %v", err)
+	}
+	if caps := mfs.MountCapabilities()["/proxy"]; len(caps) != 6 {
+		t.Errorf("Expected the refreshed capabilities listed, got %v", caps)
+	}
+
+	// A failed report keeps the previous capabilities until the next refresh
+	fs.report(nil, errors.New("backend down"))
+	clock = clock.Add(time.Minute)
+	if err := write(); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected the previous capabilities kept, got %v", err)
+	}
+	asked := fs.asked
+	write()
+	if fs.asked != asked {
+		t.Error("Expected a failed report not to be retried before the interval")
+	}
+	fs.report(plugin.BaselineCapabilities(), nil)
+	clock = clock.Add(time.Minute)
+	if err := write(); err != nil {
+		t.Errorf("Expected write supported again, got %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package mountablefs
+
+import (
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// ChangeReset is the Op of the event a subscription reports for its prefix
+// once it dropped events because its reader fell behind. Everything under
+// the prefix must then be treated as changed.
+const ChangeReset = "reset"
+
+// subscriptionBuffer is how many events a subscription holds before it
+// drops them and reports a ChangeReset instead
+const subscriptionBuffer = 256
+
+// ChangeEvent reports that a path was changed through a MountableFS. Op is
+// the operation that changed it, such as "write", "remove" or "rename"; a
+// rename reports both the old and the new path. Removals and renames of a
+// directory change everything under it.
+type ChangeEvent struct {
+	Op   string `json:"op"`
+	Path string `json:"path"`
+}
+
+// Subscription receives the changes made under a path, from Subscribe
+type Subscription struct {
+	// C receives the changes, in the order they were made
+	C <-chan ChangeEvent
+
+	prefix string
+	c      chan ChangeEvent
+	lost   atomic.Bool // Events were dropped since Lost was last called
+	hub    *changeHub
+	ns     *namespace // Namespace subscribed from (nil = shared)
+}
+
+// Lost reports whether events were dropped since it was last called, as C
+// was full. The reader should then treat everything under the prefix as
+// changed.
+func (s *Subscription) Lost() bool {
+	return s.lost.Swap(false)
+}
+
+// Close stops the subscription. C isn't closed, as events may still be
+// sent to it concurrently.
+func (s *Subscription) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		s.hub.active.Add(-1)
+	}
+}
+
+// matches reports whether a change of p may change something under the
+// subscription's prefix: p is below the prefix, or one of its parents
+func (s *Subscription) matches(p string) bool {
+	if s.prefix == "/" || p == s.prefix || strings.HasPrefix(p, s.prefix+"/") {
+		return true
+	}
+	return p == "/" || strings.HasPrefix(s.prefix, p+"/")
+}
+
+// changeHub delivers the changes made through a MountableFS to its
+// subscriptions
+type changeHub struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+
+	// active counts the subscriptions, so operations skip reporting
+	// changes while there are none
+	active atomic.Int32
+}
+
+// notify reports a change of paths made in namespace ns to the
+// subscriptions under them, without waiting for slow readers. Changes in
+// the shared namespace reach every namespace, others only their own.
+func (h *changeHub) notify(ns *namespace, op string, paths ...string) {
+	if h.active.Load() == 0 {
+		return
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, p := range paths {
+		event := ChangeEvent{Op: op, Path: filesystem.NormalizePath(p)}
+		for s := range h.subs {
+			if ns != nil && s.ns != ns || !s.matches(event.Path) {
+				continue
+			}
+			select {
+			case s.c <- event:
+			default:
+				s.lost.Store(true)
+			}
+		}
+	}
+}
+
+// Subscribe reports the changes made through mfs under prefix, or the whole
+// tree for "/", until the subscription is closed. Changes made to a
+// plugin's data by other means, such as another process writing to a local
+// directory, aren't seen, nor are those of another namespace's mounts and
+// symlinks.
+func (mfs *MountableFS) Subscribe(prefix string) *Subscription {
+	c := make(chan ChangeEvent, subscriptionBuffer)
+	s := &Subscription{C: c, c: c, prefix: filesystem.NormalizePath(prefix), hub: &mfs.changes, ns: mfs.ns}
+
+	mfs.changes.mu.Lock()
+	defer mfs.changes.mu.Unlock()
+	if mfs.changes.subs == nil {
+		mfs.changes.subs = make(map[*Subscription]struct{})
+	}
+	mfs.changes.subs[s] = struct{}{}
+	mfs.changes.active.Add(1)
+	return s
+}
+
+// notifyingFS reports the changes made through a mount's file system. It
+// only wraps the file system while there are subscriptions.
+type notifyingFS struct {
+	filesystem.Wrapper
+	hub       *changeHub
+	mountPath string
+	ns        *namespace
+}
+
+// watch wraps fs of mount to report its changes, if anyone is subscribed
+func (mfs *MountableFS) watch(mount *MountPoint, fs filesystem.FileSystem) filesystem.FileSystem {
+	if mfs.changes.active.Load() == 0 {
+		return fs
+	}
+	return &notifyingFS{Wrapper: filesystem.Wrapper{Inner: fs}, hub: &mfs.changes, mountPath: mount.Path, ns: mount.ns}
+}
+
+// changed reports a change of relPaths if err is nil, and returns err
+func (n *notifyingFS) changed(err error, op string, relPaths ...string) error {
+	if err != nil {
+		return err
+	}
+	paths := make([]string, len(relPaths))
+	for i, p := range relPaths {
+		paths[i] = path.Join(n.mountPath, p)
+	}
+	n.hub.notify(n.ns, op, paths...)
+	return nil
+}
+
+func (n *notifyingFS) Create(path string) error {
+	return n.changed(n.Inner.Create(path), "create", path)
+}
+
+func (n *notifyingFS) Mkdir(path string, perm uint32) error {
+	return n.changed(n.Inner.Mkdir(path, perm), "mkdir", path)
+}
+
+func (n *notifyingFS) Remove(path string) error {
+	return n.changed(n.Inner.Remove(path), "remove", path)
+}
+
+func (n *notifyingFS) RemoveAll(path string) error {
+	return n.changed(n.Inner.RemoveAll(path), "remove", path)
+}
+
+func (n *notifyingFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	written, err := n.Inner.Write(path, data, offset, flags)
+	return written, n.changed(err, "write", path)
+}
+
+func (n *notifyingFS) Rename(oldPath, newPath string) error {
+	return n.changed(n.Inner.Rename(oldPath, newPath), "rename", oldPath, newPath)
+}
+
+func (n *notifyingFS) Chmod(path string, mode uint32) error {
+	return n.changed(n.Inner.Chmod(path, mode), "chmod", path)
+}
+
+func (n *notifyingFS) OpenWrite(path string) (io.WriteCloser, error) {
+	w, err := n.Inner.OpenWrite(path)
+	if err != nil {
+		return nil, err
+	}
+	return &notifyingWriter{WriteCloser: w, fs: n, path: path}, nil
+}
+
+func (n *notifyingFS) Touch(path string) error {
+	return n.changed(n.Wrapper.Touch(path), "touch", path)
+}
+
+func (n *notifyingFS) Truncate(path string, size int64) error {
+	return n.changed(n.Wrapper.Truncate(path, size), "truncate", path)
+}
+
+func (n *notifyingFS) MkdirAll(path string, perm uint32) error {
+	return n.changed(n.Wrapper.MkdirAll(path, perm), "mkdir", path)
+}
+
+func (n *notifyingFS) Clone(src, dst string) error {
+	return n.changed(n.Wrapper.Clone(src, dst), "write", dst)
+}
+
+func (n *notifyingFS) CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	copied, err := n.Wrapper.CopyRange(src, srcOffset, dst, dstOffset, length)
+	return copied, n.changed(err, "write", dst)
+}
+
+// notifyingWriter reports a streaming write once it is closed, as the data
+// may not be visible before
+type notifyingWriter struct {
+	io.WriteCloser
+	fs   *notifyingFS
+	path string
+}
+
+func (w *notifyingWriter) Close() error {
+	return w.fs.changed(w.WriteCloser.Close(), "write", w.path)
+}
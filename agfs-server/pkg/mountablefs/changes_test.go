@@ -0,0 +1,102 @@
+package mountablefs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+// drain returns the events received so far
+func drain(s *Subscription) []ChangeEvent {
+	var events []ChangeEvent
+	for {
+		select {
+		case e := <-s.C:
+			events = append(events, e)
+		default:
+			return events
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/mnt", testBackends()["memfs"](t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.Mkdir("/mnt/b", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	s := mfs.Subscribe("/mnt/a")
+	defer s.Close()
+	if err := mfs.Mkdir("/mnt/a", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	if _, err := mfs.Write("/mnt/a/f", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := mfs.Write("/mnt/b/f", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := mfs.Rename("/mnt/a/f", "/mnt/b/g"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := mfs.Remove("/mnt/a/missing"); err == nil {
+		t.Fatal("Expected Remove of a missing file to fail")
+	}
+	if err := mfs.RemoveAll("/mnt"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+
+	// Changes outside the prefix and failed operations aren't reported,
+	// the removal of a parent is
+	expected := []ChangeEvent{
+		{"mkdir", "/mnt/a"},
+		{"write", "/mnt/a/f"},
+		{"rename", "/mnt/a/f"},
+		{"remove", "/mnt"},
+	}
+	events := drain(s)
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, events)
+	}
+	for i := range expected {
+		if events[i] != expected[i] {
+			t.Errorf("Expected event %d to be %v, got %v", i, expected[i], events[i])
+		}
+	}
+
+	s.Close()
+	if _, err := mfs.Write("/mnt/a", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if events := drain(s); len(events) != 0 {
+		t.Errorf("Expected no events after Close, got %v", events)
+	}
+}
+
+func TestSubscribeLost(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/mnt", testBackends()["memfs"](t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	s := mfs.Subscribe("/")
+	defer s.Close()
+
+	for i := 0; i <= subscriptionBuffer; i++ {
+		if _, err := mfs.Write("/mnt/f", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if events := drain(s); len(events) != subscriptionBuffer {
+		t.Errorf("Expected the buffer to fill up with %d events, got %d", subscriptionBuffer, len(events))
+	}
+	if !s.Lost() {
+		t.Error("Expected the subscription to report lost events")
+	}
+	if s.Lost() {
+		t.Error("Expected Lost to be reset once reported")
+	}
+}
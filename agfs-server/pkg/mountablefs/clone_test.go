@@ -0,0 +1,234 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// cloningFS is a memfs counting the clones and range copies it makes, which
+// it makes by copying, or failing with ErrNotSupported if unsupported is set
+type cloningFS struct {
+	filesystem.FileSystem
+	clones      int
+	rangeCopies int
+	unsupported bool
+}
+
+func (fs *cloningFS) Clone(src, dst string) error {
+	if fs.unsupported {
+		return filesystem.NewNotSupportedError("clone", dst)
+	}
+	fs.clones++
+	return filesystem.Copy(fs.FileSystem, src, dst)
+}
+
+func (fs *cloningFS) CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	if fs.unsupported {
+		return 0, filesystem.NewNotSupportedError("copyrange", dst)
+	}
+	fs.rangeCopies++
+	return filesystem.CopyRange(fs.FileSystem, src, srcOffset, dst, dstOffset, length)
+}
+
+// cloningPlugin is a memfs plugin whose file system is a cloningFS,
+// declaring the given capabilities
+type cloningPlugin struct {
+	plugin.ServicePlugin
+	fs   *cloningFS
+	caps plugin.CapabilitySet
+}
+
+func (p *cloningPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *cloningPlugin) Capabilities() plugin.CapabilitySet {
+	return p.caps
+}
+
+func newCloningPlugin(t *testing.T, caps plugin.CapabilitySet) *cloningPlugin {
+	t.Helper()
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	return &cloningPlugin{ServicePlugin: p, fs: &cloningFS{FileSystem: p.GetFileSystem()}, caps: caps}
+}
+
+// checkIndependentCopy checks that dst has the content of src, and that
+// writing to dst leaves src as it was
+func checkIndependentCopy(t *testing.T, mfs *MountableFS, src, dst string) {
+	t.Helper()
+	data, err := mfs.Read(dst, 0, -1)
+	if (err != nil && err != io.EOF) || string(data) != "original" {
+		t.Fatalf("Expected %s to read \"original\", got %q, %v", dst, data, err)
+	}
+	if _, err := mfs.Write(dst, []byte("modified"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write %s failed: %v", dst, err)
+	}
+	if data, _ := mfs.Read(src, 0, -1); string(data) != "original" {
+		t.Errorf("Expected %s unchanged by writing its copy, got %q", src, data)
+	}
+}
+
+func TestClone(t *testing.T) {
+	withClone := plugin.BaselineCapabilities().With(plugin.CapabilityClone)
+	tests := []struct {
+		name        string
+		caps        plugin.CapabilitySet
+		unsupported bool
+		wantClones  int
+	}{
+		{"supported", withClone, false, 1},
+		{"unsupported for these files", withClone, true, 0},
+		{"undeclared", plugin.BaselineCapabilities(), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			p := newCloningPlugin(t, tt.caps)
+			p.fs.unsupported = tt.unsupported
+			if err := mfs.Mount("/mnt", p); err != nil {
+				t.Fatalf("Failed to mount: %v", err)
+			}
+			if _, err := mfs.Write("/mnt/src", []byte("original"), 0, filesystem.WriteFlagCreate); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if err := mfs.Chmod("/mnt/src", 0600); err != nil {
+				t.Fatalf("Chmod failed: %v", err)
+			}
+
+			if err := mfs.Clone("/mnt/src", "/mnt/dst"); err != nil {
+				t.Fatalf("Clone failed: %v", err)
+			}
+			if p.fs.clones != tt.wantClones {
+				t.Errorf("Expected %d clones by the plugin, got %d", tt.wantClones, p.fs.clones)
+			}
+			if info, err := mfs.Stat("/mnt/dst"); err != nil || info.Mode != 0600 {
+				t.Errorf("Expected the clone to keep mode 0600, got %+v, %v", info, err)
+			}
+			checkIndependentCopy(t, mfs, "/mnt/src", "/mnt/dst")
+		})
+	}
+}
+
+func TestCloneAcrossMounts(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	withClone := plugin.BaselineCapabilities().With(plugin.CapabilityClone)
+	a, b := newCloningPlugin(t, withClone), newCloningPlugin(t, withClone)
+	if err := mfs.Mount("/a", a); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.Mount("/b", b); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if _, err := mfs.Write("/a/src", []byte("original"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if err := mfs.Clone("/a/src", "/b/dst"); err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if a.fs.clones+b.fs.clones != 0 {
+		t.Errorf("Expected files on different mounts to be copied, got %d clones", a.fs.clones+b.fs.clones)
+	}
+	checkIndependentCopy(t, mfs, "/a/src", "/b/dst")
+
+	if err := mfs.Clone("/a", "/b/dir"); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Expected cloning a directory to fail with ErrInvalidArgument, got %v", err)
+	}
+	if err := mfs.Clone("/a/missing", "/b/missing"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected cloning a missing file to fail with ErrNotFound, got %v", err)
+	}
+}
+
+func TestCopyRange(t *testing.T) {
+	withCopyRange := plugin.BaselineCapabilities().With(plugin.CapabilityCopyRange)
+	tests := []struct {
+		name            string
+		caps            plugin.CapabilitySet
+		unsupported     bool
+		wantRangeCopies int
+	}{
+		{"supported", withCopyRange, false, 2},
+		{"unsupported for these files", withCopyRange, true, 0},
+		{"undeclared", plugin.BaselineCapabilities(), false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			p := newCloningPlugin(t, tt.caps)
+			p.fs.unsupported = tt.unsupported
+			if err := mfs.Mount("/mnt", p); err != nil {
+				t.Fatalf("Failed to mount: %v", err)
+			}
+			for name, data := range map[string]string{"/mnt/src": "original", "/mnt/dst": "xyz"} {
+				if _, err := mfs.Write(name, []byte(data), 0, filesystem.WriteFlagCreate); err != nil {
+					t.Fatalf("Write failed: %v", err)
+				}
+			}
+
+			// The copy grows dst, and stops at the end of src
+			if n, err := filesystem.CopyRange(mfs, "/mnt/src", 2, "/mnt/dst", 1, 100); err != nil || n != 6 {
+				t.Fatalf("Expected 6 bytes copied, got %d, %v", n, err)
+			}
+			if data, err := mfs.Read("/mnt/dst", 0, -1); (err != nil && err != io.EOF) || string(data) != "xiginal" {
+				t.Errorf("Expected \"xiginal\", got %q, %v", data, err)
+			}
+			if n, err := filesystem.CopyRange(mfs, "/mnt/src", 0, "/mnt/src", 4, 4); err != nil || n != 4 {
+				t.Fatalf("Expected the adjacent range copied, got %d, %v", n, err)
+			}
+			if p.fs.rangeCopies != tt.wantRangeCopies {
+				t.Errorf("Expected %d range copies by the plugin, got %d", tt.wantRangeCopies, p.fs.rangeCopies)
+			}
+			if data, _ := mfs.Read("/mnt/src", 0, -1); string(data) != "origorig" {
+				t.Errorf("Expected \"origorig\", got %q", data)
+			}
+		})
+	}
+}
+
+func TestCopyRangeEdges(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	a, b := newCloningPlugin(t, plugin.BaselineCapabilities().With(plugin.CapabilityCopyRange)), newCloningPlugin(t, plugin.BaselineCapabilities())
+	if err := mfs.Mount("/a", a); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.Mount("/b", b); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if _, err := mfs.Write("/a/src", []byte("original"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := mfs.Create("/b/dst"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	// Across mounts the range is read and written
+	if n, err := filesystem.CopyRange(mfs, "/a/src", 0, "/b/dst", 0, 8); err != nil || n != 8 {
+		t.Fatalf("Expected 8 bytes copied, got %d, %v", n, err)
+	}
+	if a.fs.rangeCopies != 0 {
+		t.Errorf("Expected files on different mounts to be copied, got %d range copies", a.fs.rangeCopies)
+	}
+	checkIndependentCopy(t, mfs, "/a/src", "/b/dst")
+
+	if n, err := filesystem.CopyRange(mfs, "/a/src", 8, "/b/dst", 0, 8); err != nil || n != 0 {
+		t.Errorf("Expected nothing copied from the end of the file, got %d, %v", n, err)
+	}
+	if _, err := filesystem.CopyRange(mfs, "/a/src", 0, "/a/src", 2, 4); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Expected overlapping ranges to fail with ErrInvalidArgument, got %v", err)
+	}
+	if _, err := filesystem.CopyRange(mfs, "/a/src", -1, "/b/dst", 0, 4); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Expected a negative offset to fail with ErrInvalidArgument, got %v", err)
+	}
+	if _, err := filesystem.CopyRange(mfs, "/a/missing", 0, "/b/dst", 0, 4); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected a missing file to fail with ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package mountablefs
+
+import (
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// createLocks serializes the exclusive creates of each path of a mount whose
+// plugin doesn't declare plugin.CapabilityExclusiveCreate
+type createLocks struct {
+	mu    sync.Mutex
+	paths map[string]*createLock
+}
+
+// createLock is the lock of a path, dropped once no create holds or waits
+// for it
+type createLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+func newCreateLocks() *createLocks {
+	return &createLocks{paths: make(map[string]*createLock)}
+}
+
+// lock locks path, returning the function unlocking it
+func (c *createLocks) lock(path string) func() {
+	c.mu.Lock()
+	l, ok := c.paths[path]
+	if !ok {
+		l = &createLock{}
+		c.paths[path] = l
+	}
+	l.refs++
+	c.mu.Unlock()
+
+	l.mu.Lock()
+	return func() {
+		l.mu.Unlock()
+		c.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(c.paths, path)
+		}
+		c.mu.Unlock()
+	}
+}
+
+// exclusive runs create, which creates relPath without failing if it
+// exists, unless relPath exists. Plugins declaring
+// plugin.CapabilityExclusiveCreate check that themselves, atomically, and
+// aren't asked to emulate it. For the others the check is made here, under
+// a lock of the path on the mount: exclusive creates of the path can't both
+// succeed, but a plain create racing one may still see it succeed on a file
+// it just created.
+func (m *MountPoint) exclusive(fs filesystem.FileSystem, relPath, path string, create func() error) error {
+	if m.creates != nil {
+		defer m.creates.lock(relPath)()
+	}
+	if _, err := fs.Stat(relPath); err == nil {
+		return filesystem.NewAlreadyExistsError("file", path)
+	}
+	return create()
+}
+
+// emulatesExclusive reports whether exclusive creates on the mount are
+// checked by exclusive rather than by the plugin
+func (m *MountPoint) emulatesExclusive() bool {
+	return !m.capabilities().Has(plugin.CapabilityExclusiveCreate)
+}
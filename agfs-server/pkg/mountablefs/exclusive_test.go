@@ -0,0 +1,99 @@
+package mountablefs
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// laxFS is a memfs that ignores the exclusive flags, like backends that
+// can't check them
+type laxFS struct {
+	*memfs.MemoryFS
+}
+
+func (fs *laxFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return fs.MemoryFS.Write(path, data, offset, flags&^filesystem.WriteFlagExclusive)
+}
+
+func (fs *laxFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint32) (filesystem.FileHandle, error) {
+	return fs.MemoryFS.OpenHandle(path, flags&^filesystem.O_EXCL, mode)
+}
+
+// laxPlugin serves a laxFS, declaring no exclusive-create support
+type laxPlugin struct {
+	*memfs.MemFSPlugin
+	fs *laxFS
+}
+
+func (p *laxPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *laxPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityHandles)
+}
+
+func TestExclusiveCreateEmulated(t *testing.T) {
+	mem := newMemFS(t)
+	lax := &laxPlugin{MemFSPlugin: mem, fs: &laxFS{MemoryFS: mem.GetFileSystem().(*memfs.MemoryFS)}}
+
+	tests := []struct {
+		name   string
+		create func(mfs *MountableFS, path string) error
+	}{
+		{"Write", func(mfs *MountableFS, path string) error {
+			_, err := mfs.Write(path, []byte{}, 0, filesystem.WriteFlagCreate|filesystem.WriteFlagExclusive)
+			return err
+		}},
+		{"OpenHandle", func(mfs *MountableFS, path string) error {
+			h, err := mfs.OpenHandle(path, filesystem.O_WRONLY|filesystem.O_CREATE|filesystem.O_EXCL, 0644)
+			if err == nil {
+				h.Close()
+			}
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			if err := mfs.Mount("/lax", lax); err != nil {
+				t.Fatalf("Failed to mount: %v", err)
+			}
+			path := "/lax/" + tt.name
+
+			var mu sync.Mutex
+			var wg sync.WaitGroup
+			created, exists := 0, 0
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := tt.create(mfs, path)
+					mu.Lock()
+					defer mu.Unlock()
+					switch {
+					case err == nil:
+						created++
+					case errors.Is(err, filesystem.ErrAlreadyExists):
+						exists++
+					default:
+						t.Errorf("Unexpected error: %v", err)
+					}
+				}()
+			}
+			wg.Wait()
+			if created != 1 || exists != 19 {
+				t.Errorf("Expected 1 create and 19 already exists, got %d and %d", created, exists)
+			}
+			if n := len(mfs.GetMounts()[0].creates.paths); n != 0 {
+				t.Errorf("Expected the path locks dropped, got %d", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package mountablefs
+
+import (
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// MountHealth is whether the plugin mounted at Path can serve requests
+type MountHealth struct {
+	Path   string `json:"path"`
+	Plugin string `json:"plugin"`
+	Ready  bool   `json:"ready"`
+	Error  string `json:"error,omitempty"` // Why the mount isn't ready
+}
+
+// MountHealth returns the health of every mount, ordered by path. Plugins
+// implementing plugin.HealthReporter report their own, such as WASM plugins
+// whose pool is unhealthy or saturated; the others are ready once mounted,
+// as their Initialize succeeded.
+func (mfs *MountableFS) MountHealth() []MountHealth {
+	mounts := mfs.GetMounts()
+	health := make([]MountHealth, 0, len(mounts))
+	for _, mount := range mounts {
+		h := MountHealth{Path: mount.Path, Plugin: mount.Plugin.Name(), Ready: true}
+		if reporter, ok := mount.Plugin.(plugin.HealthReporter); ok {
+			if err := reporter.Health(); err != nil {
+				h.Ready = false
+				h.Error = err.Error()
+			}
+		}
+		health = append(health, h)
+	}
+	return health
+}
@@ -0,0 +1,47 @@
+package mountablefs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestMountIno(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	for _, path := range []string{"/a", "/b"} {
+		p := memfs.NewMemFSPlugin()
+		if err := p.Initialize(map[string]interface{}{}); err != nil {
+			t.Fatalf("Failed to initialize plugin: %v", err)
+		}
+		if err := mfs.Mount(path, p); err != nil {
+			t.Fatalf("Mount failed: %v", err)
+		}
+		// Both plugins number their first file alike
+		if err := mfs.Create(path + "/file"); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+	}
+
+	a, err := mfs.Stat("/a/file")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	b, err := mfs.Stat("/b/file")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if a.Ino == 0 || a.Ino == b.Ino {
+		t.Errorf("Expected distinct inode numbers across mounts, got %d and %d", a.Ino, b.Ino)
+	}
+
+	entries, err := mfs.ReadDir("/a")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, e := range entries {
+		if e.Name == "file" && e.Ino != a.Ino {
+			t.Errorf("Expected ReadDir to report inode %d like Stat, got %d", a.Ino, e.Ino)
+		}
+	}
+}
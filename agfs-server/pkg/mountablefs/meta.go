@@ -0,0 +1,159 @@
+package mountablefs
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// MetaDirName is the virtual directory at every mount point that describes
+// the mounted plugin. The name is reserved: plugin files by that name are
+// hidden, and it can't be created, written or removed.
+const MetaDirName = ".agfs"
+
+// MetaValueVirtual is the Meta.Type of the entries of the .agfs directory
+const MetaValueVirtual = "virtual"
+
+// Files of the .agfs directory
+const (
+	MetaFileReadme = "readme"      // The plugin's GetReadme
+	MetaFileConfig = "config.json" // The plugin's GetConfigParams as JSON
+)
+
+var metaFiles = []string{MetaFileReadme, MetaFileConfig}
+
+// metaPath reports whether path, with symlinks resolved, is the .agfs
+// directory of a mount or below it. name is the path within .agfs, empty
+// for the directory itself.
+func (mfs *MountableFS) metaPath(path string) (mount *MountPoint, name string, ok bool) {
+	mount, relPath, found := mfs.findMount(path)
+	if !found {
+		return nil, "", false
+	}
+	rest, ok := strings.CutPrefix(relPath, "/"+MetaDirName)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return nil, "", false
+	}
+	return mount, strings.TrimPrefix(rest, "/"), true
+}
+
+// reserved fails op on path if it is in the .agfs directory of a mount or
+// in the snapshots of a directory
+func (mfs *MountableFS) reserved(op, path string) error {
+	path = filesystem.NormalizePath(path)
+	if _, _, ok := mfs.metaPath(path); ok {
+		return filesystem.NewPermissionDeniedError(op, path, MetaDirName+" is reserved for plugin metadata")
+	}
+	if _, ok := mfs.snapshotPath(path); ok {
+		return filesystem.NewPermissionDeniedError(op, path, "snapshots are read-only")
+	}
+	return nil
+}
+
+// metaContent returns the content of file name of the .agfs directory
+func metaContent(mount *MountPoint, name, path string) ([]byte, error) {
+	switch name {
+	case MetaFileReadme:
+		return []byte(mount.Plugin.GetReadme()), nil
+	case MetaFileConfig:
+		params := mount.Plugin.GetConfigParams()
+		if params == nil {
+			params = []plugin.ConfigParameter{}
+		}
+		data, err := json.MarshalIndent(params, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	}
+	return nil, filesystem.NewNotFoundError("read", path)
+}
+
+func metaDirInfo() filesystem.FileInfo {
+	return filesystem.FileInfo{
+		Name:    MetaDirName,
+		Mode:    0555,
+		ModTime: time.Now(),
+		IsDir:   true,
+		Meta:    filesystem.MetaData{Type: MetaValueVirtual},
+	}
+}
+
+// metaStat returns the attributes of name in the .agfs directory of mount
+func metaStat(mount *MountPoint, name, path string) (*filesystem.FileInfo, error) {
+	if name == "" {
+		info := metaDirInfo()
+		return &info, nil
+	}
+	data, err := metaContent(mount, name, path)
+	if err != nil {
+		return nil, filesystem.NewNotFoundError("stat", path)
+	}
+	return &filesystem.FileInfo{
+		Name:    name,
+		Size:    int64(len(data)),
+		Mode:    0444,
+		ModTime: time.Now(),
+		Meta:    filesystem.MetaData{Type: MetaValueVirtual},
+	}, nil
+}
+
+// metaReadDir lists name in the .agfs directory of mount
+func metaReadDir(mount *MountPoint, name, path string) ([]filesystem.FileInfo, error) {
+	if name != "" {
+		if _, err := metaContent(mount, name, path); err == nil {
+			return nil, filesystem.NewNotDirectoryError(path)
+		}
+		return nil, filesystem.NewNotFoundError("readdir", path)
+	}
+	infos := make([]filesystem.FileInfo, 0, len(metaFiles))
+	for _, file := range metaFiles {
+		info, err := metaStat(mount, file, path)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, *info)
+	}
+	return infos, nil
+}
+
+// metaRead reads name in the .agfs directory of mount
+func metaRead(mount *MountPoint, name, path string, offset, size int64) ([]byte, error) {
+	if name == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	data, err := metaContent(mount, name, path)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.ApplyRangeRead(data, offset, size)
+}
+
+// metaOpen opens name in the .agfs directory of mount for reading
+func metaOpen(mount *MountPoint, name, path string) (io.ReadCloser, error) {
+	if name == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	data, err := metaContent(mount, name, path)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// withMetaDir replaces any plugin entry named .agfs in the listing of a
+// mount point with the virtual directory
+func withMetaDir(infos []filesystem.FileInfo) []filesystem.FileInfo {
+	out := make([]filesystem.FileInfo, 0, len(infos)+1)
+	for _, info := range infos {
+		if info.Name != MetaDirName {
+			out = append(out, info)
+		}
+	}
+	return append(out, metaDirInfo())
+}
@@ -0,0 +1,127 @@
+package mountablefs
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestMetaDirFiles(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	if err := mfs.Mount("/mem", p); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+
+	data, err := mfs.Read("/mem/.agfs/readme", 0, -1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		t.Fatalf("Read readme failed: %v", err)
+	}
+	if string(data) != p.GetReadme() {
+		t.Errorf("Expected the plugin README, got %q", data)
+	}
+
+	r, err := mfs.Open("/mem/.agfs/config.json")
+	if err != nil {
+		t.Fatalf("Open config.json failed: %v", err)
+	}
+	defer r.Close()
+	var params []plugin.ConfigParameter
+	if err := json.NewDecoder(r).Decode(&params); err != nil {
+		t.Fatalf("config.json is not a parameter list: %v", err)
+	}
+	if len(params) != len(p.GetConfigParams()) {
+		t.Errorf("Expected %d parameters, got %d", len(p.GetConfigParams()), len(params))
+	}
+
+	info, err := mfs.Stat("/mem/.agfs/readme")
+	if err != nil {
+		t.Fatalf("Stat readme failed: %v", err)
+	}
+	if info.Size != int64(len(p.GetReadme())) || info.Meta.Type != MetaValueVirtual {
+		t.Errorf("Unexpected readme stat: %+v", info)
+	}
+
+	entries, err := mfs.ReadDir("/mem/.agfs")
+	if err != nil {
+		t.Fatalf("ReadDir .agfs failed: %v", err)
+	}
+	if len(entries) != 2 || entries[0].Name != MetaFileReadme || entries[1].Name != MetaFileConfig {
+		t.Errorf("Expected readme and config.json, got %+v", entries)
+	}
+
+	if _, err := mfs.Read("/mem/.agfs/missing", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for an unknown .agfs file, got %v", err)
+	}
+	if _, err := mfs.Read("/mem/.agfs", 0, -1); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Expected reading .agfs itself to fail, got %v", err)
+	}
+}
+
+func TestMetaDirListedAndReserved(t *testing.T) {
+	dir := t.TempDir()
+	// A real .agfs directory is hidden behind the virtual one
+	if err := os.MkdirAll(filepath.Join(dir, ".agfs", "real"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p := localfs.NewLocalFSPlugin()
+	if err := p.Initialize(map[string]interface{}{"local_dir": dir}); err != nil {
+		t.Fatalf("Failed to initialize localfs: %v", err)
+	}
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/local", p); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+
+	entries, err := mfs.ReadDir("/local")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.Name == MetaDirName {
+			count++
+			if !entry.IsDir || entry.Meta.Type != MetaValueVirtual {
+				t.Errorf("Expected the virtual .agfs directory, got %+v", entry)
+			}
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected .agfs to be listed once, got %d", count)
+	}
+	if _, err := mfs.Stat("/local/.agfs/real"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected the real .agfs to be hidden, got %v", err)
+	}
+
+	reserved := map[string]error{
+		"create":  mfs.Create("/local/.agfs/new"),
+		"mkdir":   mfs.Mkdir("/local/.agfs", 0755),
+		"remove":  mfs.Remove("/local/.agfs/readme"),
+		"rename":  mfs.Rename("/local/.agfs/readme", "/local/readme"),
+		"chmod":   mfs.Chmod("/local/.agfs/readme", 0777),
+		"symlink": mfs.Symlink("/local", "/local/.agfs/link"),
+	}
+	_, reserved["write"] = mfs.Write("/local/.agfs/readme", []byte("x"), 0, filesystem.WriteFlagNone)
+	for op, err := range reserved {
+		if !errors.Is(err, filesystem.ErrPermissionDenied) {
+			t.Errorf("Expected %s in .agfs to be denied, got %v", op, err)
+		}
+	}
+
+	// Names that only start with .agfs are ordinary files
+	if err := mfs.Create("/local/.agfsx"); err != nil {
+		t.Errorf("Expected .agfsx to be created, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package mountablefs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestMountMiddleware(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize plugin: %v", err)
+	}
+	if err := p.GetFileSystem().Create("/file.txt"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	var ops int
+	counter := filesystem.LogOps(func(format string, args ...interface{}) { ops++ })
+	if err := mfs.Mount("/ro", p, counter, filesystem.ReadOnly); err != nil {
+		t.Fatalf("Mount failed: %v", err)
+	}
+
+	if _, err := mfs.Write("/ro/file.txt", []byte("data"), 0, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Expected write to be denied, got %v", err)
+	}
+	if err := mfs.Touch("/ro/new.txt"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Expected touch to be denied, got %v", err)
+	}
+	if _, err := mfs.OpenHandle("/ro/file.txt", filesystem.O_WRONLY, 0); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Expected a write handle to be denied, got %v", err)
+	}
+	if _, err := mfs.Stat("/ro/file.txt"); err != nil {
+		t.Errorf("Expected stat to succeed, got %v", err)
+	}
+	h, err := mfs.OpenHandle("/ro/file.txt", filesystem.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("Expected a read handle, got %v", err)
+	}
+	h.Close()
+	if ops != 5 {
+		t.Errorf("Expected the 5 operations to reach the middleware, got %d", ops)
+	}
+}
@@ -0,0 +1,58 @@
+package mountablefs
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+func TestMkdirAll(t *testing.T) {
+	for name, newPlugin := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			if err := mfs.Mount("/mnt", newPlugin(t)); err != nil {
+				t.Fatalf("Failed to mount: %v", err)
+			}
+
+			// Part of the path already exists
+			if err := mfs.Mkdir("/mnt/a", 0755); err != nil {
+				t.Fatalf("Mkdir failed: %v", err)
+			}
+			if err := mfs.MkdirAll("/mnt/a/b/c", 0750); err != nil {
+				t.Fatalf("MkdirAll failed: %v", err)
+			}
+			for _, dir := range []string{"/mnt/a/b", "/mnt/a/b/c"} {
+				info, err := mfs.Stat(dir)
+				if err != nil || !info.IsDir {
+					t.Fatalf("Expected %s to be a directory, got %+v, %v", dir, info, err)
+				}
+			}
+
+			// The leaf already exists as a directory, and so do mount points
+			for _, dir := range []string{"/mnt/a/b/c", "/mnt", "/"} {
+				if err := mfs.MkdirAll(dir, 0755); err != nil {
+					t.Errorf("Expected MkdirAll of existing %s to succeed, got %v", dir, err)
+				}
+			}
+
+			// A file in the way, as the leaf or as a parent
+			if err := mfs.Create("/mnt/a/file"); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			for _, dir := range []string{"/mnt/a/file", "/mnt/a/file/sub"} {
+				if err := mfs.MkdirAll(dir, 0755); !errors.Is(err, filesystem.ErrNotDirectory) {
+					t.Errorf("Expected ErrNotDirectory for %s, got %v", dir, err)
+				}
+			}
+		})
+	}
+}
+
+func TestMkdirAllOutsideMounts(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.MkdirAll("/nowhere/dir", 0755); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Expected ErrPermissionDenied outside any mount, got %v", err)
+	}
+}
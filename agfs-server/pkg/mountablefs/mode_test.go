@@ -0,0 +1,86 @@
+package mountablefs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/localfs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// testBackends returns constructors for the plugins the cross-backend tests run against
+func testBackends() map[string]func(t *testing.T) plugin.ServicePlugin {
+	return map[string]func(t *testing.T) plugin.ServicePlugin{
+		"mock": func(t *testing.T) plugin.ServicePlugin {
+			return NewMockServicePlugin("mock")
+		},
+		"memfs": func(t *testing.T) plugin.ServicePlugin {
+			p := memfs.NewMemFSPlugin()
+			if err := p.Initialize(map[string]interface{}{}); err != nil {
+				t.Fatalf("Failed to initialize memfs: %v", err)
+			}
+			return p
+		},
+		"localfs": func(t *testing.T) plugin.ServicePlugin {
+			p := localfs.NewLocalFSPlugin()
+			if err := p.Initialize(map[string]interface{}{"local_dir": t.TempDir()}); err != nil {
+				t.Fatalf("Failed to initialize localfs: %v", err)
+			}
+			return p
+		},
+	}
+}
+
+// TestModeSpecialBitsPreserved checks that setuid, setgid and sticky bits set
+// through Mkdir and Chmod are reported unchanged by Stat and ReadDir
+func TestModeSpecialBitsPreserved(t *testing.T) {
+	for name, newPlugin := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			if err := mfs.Mount("/mnt", newPlugin(t)); err != nil {
+				t.Fatalf("Failed to mount: %v", err)
+			}
+
+			// Sticky shared temp directory, created with the bit set
+			if err := mfs.Mkdir("/mnt/tmp", 01777); err != nil {
+				t.Fatalf("Mkdir failed: %v", err)
+			}
+			// setuid executable, set with Chmod
+			if err := mfs.Create("/mnt/tool"); err != nil {
+				t.Fatalf("Create failed: %v", err)
+			}
+			if err := mfs.Chmod("/mnt/tool", 04755); err != nil {
+				t.Fatalf("Chmod failed: %v", err)
+			}
+			// setgid directory, set with Chmod
+			if err := mfs.Mkdir("/mnt/shared", 0755); err != nil {
+				t.Fatalf("Mkdir failed: %v", err)
+			}
+			if err := mfs.Chmod("/mnt/shared", 02775); err != nil {
+				t.Fatalf("Chmod failed: %v", err)
+			}
+
+			want := map[string]uint32{"tmp": 01777, "tool": 04755, "shared": 02775}
+			for file, mode := range want {
+				info, err := mfs.Stat("/mnt/" + file)
+				if err != nil {
+					t.Fatalf("Stat %s failed: %v", file, err)
+				}
+				if info.Mode != mode {
+					t.Errorf("Stat %s: got mode %o, want %o", file, info.Mode, mode)
+				}
+			}
+
+			infos, err := mfs.ReadDir("/mnt")
+			if err != nil {
+				t.Fatalf("ReadDir failed: %v", err)
+			}
+			for _, info := range infos {
+				if mode, ok := want[info.Name]; ok && info.Mode != mode {
+					t.Errorf("ReadDir %s: got mode %o, want %o", info.Name, info.Mode, mode)
+				}
+			}
+		})
+	}
+}
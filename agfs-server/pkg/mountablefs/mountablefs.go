@@ -1,9 +1,14 @@
 package mountablefs
 
 import (
+	"context"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -23,11 +28,126 @@ const (
 	MetaValueMountPoint = "mount-point"
 )
 
+// fallbackReadChunkSize is how much Open reads at a time from plugins that
+// don't support streaming reads
+const fallbackReadChunkSize = 1 << 20
+
 // MountPoint represents a mounted service plugin
 type MountPoint struct {
-	Path   string
-	Plugin plugin.ServicePlugin
-	Config map[string]interface{} // Plugin configuration
+	Path         string
+	Plugin       plugin.ServicePlugin
+	Config       map[string]interface{} // Plugin configuration
+	Capabilities plugin.CapabilitySet   // Operations the plugin declared at mount time, see capabilities
+	Middleware   filesystem.Middleware  // Applied to the plugin's file system on every operation (nil = none)
+	Options      MountOptions           // Applied on top of the plugin
+
+	writes  *writeRanges // Writes in progress, coordinated as Options.WriteConflicts says
+	creates *createLocks // Exclusive creates in progress, see exclusive
+	usage   *usageCache  // Last usage counted by Usage
+	caps    *capsCache   // Capabilities last reported by the file system (nil = Capabilities)
+	ns      *namespace   // Namespace the mount belongs to (nil = shared)
+}
+
+// Modes reported for files and directories whose plugin reports none, unless
+// the mount's options say otherwise
+const (
+	defaultFileMode = 0644
+	defaultDirMode  = 0755
+)
+
+// MountOptions are settings of a mount that MountableFS applies on top of
+// its plugin
+type MountOptions struct {
+	// Modes reported for files and directories the plugin reports mode 0
+	// for, as plugins that don't track permissions do (0 = 0644 and 0755)
+	DefaultFileMode uint32
+	DefaultDirMode  uint32
+
+	// Trash makes Remove and RemoveAll move files to the mount's
+	// TrashDirName, where they are kept for TrashRetention
+	// (0 = DefaultTrashRetention) and can be restored
+	Trash          bool
+	TrashRetention time.Duration
+
+	// WriteConflicts decides what happens to a write overlapping a write to
+	// the same file in progress ("" = WriteConflictLastWriterWins)
+	WriteConflicts WriteConflictPolicy
+}
+
+// ParseMountOptions parses the default modes of a mount given as octal
+// strings, such as "0644", as configuration files and mount requests do.
+// Empty strings leave the defaults unset.
+func ParseMountOptions(fileMode, dirMode string) (MountOptions, error) {
+	var opts MountOptions
+	for _, m := range []struct {
+		name  string
+		value string
+		mode  *uint32
+	}{
+		{"default_file_mode", fileMode, &opts.DefaultFileMode},
+		{"default_dir_mode", dirMode, &opts.DefaultDirMode},
+	} {
+		if m.value == "" {
+			continue
+		}
+		mode, err := strconv.ParseUint(m.value, 8, 32)
+		if err != nil || mode&^uint64(filesystem.ModePerm) != 0 {
+			return opts, filesystem.NewInvalidArgumentError(m.name, m.value, "must be an octal mode such as 0644")
+		}
+		*m.mode = uint32(mode)
+	}
+	return opts, nil
+}
+
+// fileInfo adjusts file info reported by the plugin for the mount: the
+// inode number is made unique across mounts and a mode of 0 is replaced
+// with the mount's default
+func (m *MountPoint) fileInfo(info *filesystem.FileInfo) {
+	info.Ino = m.ino(info.Ino)
+	if info.Mode != 0 {
+		return
+	}
+	switch {
+	case info.IsDir && m.Options.DefaultDirMode != 0:
+		info.Mode = m.Options.DefaultDirMode
+	case info.IsDir:
+		info.Mode = defaultDirMode
+	case m.Options.DefaultFileMode != 0:
+		info.Mode = m.Options.DefaultFileMode
+	default:
+		info.Mode = defaultFileMode
+	}
+}
+
+// wrap applies the mount's middleware chain to fs
+func (m *MountPoint) wrap(fs filesystem.FileSystem) filesystem.FileSystem {
+	if m.Middleware == nil {
+		return fs
+	}
+	return m.Middleware(fs)
+}
+
+// ino makes an inode number reported by the plugin unique across mounts, as
+// plugin instances number their files independently. 0 stays unknown.
+func (m *MountPoint) ino(ino uint64) uint64 {
+	if ino == 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(m.Path))
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], ino)
+	h.Write(b[:])
+	return h.Sum64()
+}
+
+// require fails op on path with a NotSupportedError if the plugin doesn't
+// support c, so it isn't called for operations it can't perform
+func (m *MountPoint) require(c plugin.Capability, op, path string) error {
+	if m.capabilities().Has(c) {
+		return nil
+	}
+	return filesystem.NewNotSupportedError(op, path)
 }
 
 // PluginFactory is a function that creates a new plugin instance
@@ -35,6 +155,16 @@ type PluginFactory func() plugin.ServicePlugin
 
 // MountableFS is a FileSystem that supports mounting service plugins at specific paths
 type MountableFS struct {
+	*mountState
+
+	// ctx is the request context bound by WithContext (nil = none)
+	ctx context.Context
+	// ns is the namespace the view operates in (nil = shared)
+	ns *namespace
+}
+
+// mountState is the state shared by a MountableFS and its WithContext views
+type mountState struct {
 	// mountTree stores the radix tree for mount routing.
 	// We use atomic.Value to store *iradix.Tree to enable lock-free reads.
 	mountTree atomic.Value
@@ -57,29 +187,77 @@ type MountableFS struct {
 	// This allows symlinks to work across all filesystems without backend support
 	symlinks   map[string]string // Key: link path, Value: target path
 	symlinksMu sync.RWMutex
+
+	// Mount namespaces of principals, by principal
+	namespaces   map[string]*namespace
+	namespacesMu sync.Mutex
+
+	// now is the clock that dates trash entries, replaced by tests
+	now      func() time.Time
+	trashSeq atomic.Uint64 // Tells apart entries removed at the same time
+
+	// changes delivers the changes made through the file system to
+	// Subscribe's subscriptions
+	changes changeHub
+
+	// usageRefresh is how long Usage caches the usage of a mount, as a
+	// time.Duration (0 = DefaultUsageRefreshInterval)
+	usageRefresh atomic.Int64
+
+	// capsRefresh is how long the capabilities reported by a mount's file
+	// system are cached, as a time.Duration (0 = DefaultCapabilityRefreshInterval)
+	capsRefresh atomic.Int64
 }
 
 // handleInfo stores information about a handle, including its mount point and local handle
 type handleInfo struct {
 	mount       *MountPoint           // The mount point where this handle was opened
 	localHandle filesystem.FileHandle // The underlying handle from the plugin
+	ns          *namespace            // Namespace the handle was opened in (nil = shared)
 }
 
 // NewMountableFS creates a new mountable file system with the specified WASM pool configuration
 func NewMountableFS(poolConfig api.PoolConfig) *MountableFS {
-	mfs := &MountableFS{
+	mfs := &MountableFS{mountState: &mountState{
 		pluginFactories:    make(map[string]PluginFactory),
 		pluginLoader:       loader.NewPluginLoader(poolConfig),
 		pluginNameCounters: make(map[string]int),
 		handleInfos:        make(map[int64]*handleInfo),
 		symlinks:           make(map[string]string),
-	}
+		namespaces:         make(map[string]*namespace),
+		now:                time.Now,
+	}}
 	mfs.mountTree.Store(iradix.New())
 	// Start global handle IDs from 1
 	mfs.globalHandleID.Store(0)
 	return mfs
 }
 
+// WithContext returns a view of mfs that runs operations on mounted plugins
+// under ctx, so plugins implementing filesystem.ContextBinder stop work for a
+// request that has gone away. The view shares mounts, handles and symlinks
+// with mfs. A view of the shared namespace bound to a context carrying a
+// principal, from WithPrincipal, operates in the principal's namespace.
+func (mfs *MountableFS) WithContext(ctx context.Context) filesystem.FileSystem {
+	view := &MountableFS{mountState: mfs.mountState, ctx: ctx, ns: mfs.ns}
+	if principal := PrincipalFromContext(ctx); principal != "" && mfs.ns == nil {
+		view.ns = mfs.namespace(principal)
+	}
+	return view
+}
+
+// pluginFS returns the file system of mount, bound to the request context if
+// there is one and wrapped in the mount's middlewares. The context is bound
+// first so middlewares don't have to pass it on, and changes are reported
+// last, once the middlewares let them through.
+func (mfs *MountableFS) pluginFS(mount *MountPoint) filesystem.FileSystem {
+	fs := mount.Plugin.GetFileSystem()
+	if mfs.ctx != nil {
+		fs = filesystem.WithContext(fs, mfs.ctx)
+	}
+	return mfs.watch(mount, mount.wrap(fs))
+}
+
 // GetPluginLoader returns the plugin loader instance
 func (mfs *MountableFS) GetPluginLoader() *loader.PluginLoader {
 	return mfs.pluginLoader
@@ -147,8 +325,15 @@ func (mfs *MountableFS) CreatePlugin(name string) plugin.ServicePlugin {
 	return factory()
 }
 
-// Mount mounts a service plugin at the specified path
-func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
+// Mount mounts a service plugin at the specified path. Middlewares wrap its
+// file system for every operation, the first outermost, as with
+// filesystem.Chain.
+func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin, middlewares ...filesystem.Middleware) error {
+	return mfs.MountWithOptions(path, plugin, MountOptions{}, middlewares...)
+}
+
+// MountWithOptions mounts a service plugin like Mount, with mount options
+func (mfs *MountableFS) MountWithOptions(path string, plugin plugin.ServicePlugin, opts MountOptions, middlewares ...filesystem.Middleware) error {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
 
@@ -156,12 +341,15 @@ func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
 	path = filesystem.NormalizePath(path)
 
 	// Load current tree
-	tree := mfs.mountTree.Load().(*iradix.Tree)
+	tree := mfs.table().Load().(*iradix.Tree)
 
 	// Check if path is already mounted
 	if _, exists := tree.Get([]byte(path)); exists {
 		return filesystem.NewAlreadyExistsError("mount", path)
 	}
+	if err := opts.validate(plugin.Capabilities(), path); err != nil {
+		return err
+	}
 
 	// Special handling for plugins that need parent filesystem reference
 	type parentFSSetter interface {
@@ -173,20 +361,37 @@ func (mfs *MountableFS) Mount(path string, plugin plugin.ServicePlugin) error {
 	}
 
 	// Create new tree with added mount
-	newTree, _, _ := tree.Insert([]byte(path), &MountPoint{
-		Path:   path,
-		Plugin: plugin,
-		Config: make(map[string]interface{}),
-	})
+	mount := &MountPoint{
+		Path:         path,
+		Plugin:       plugin,
+		Config:       make(map[string]interface{}),
+		Capabilities: plugin.Capabilities(),
+		Options:      opts,
+		writes:       newWriteRanges(),
+		creates:      newCreateLocks(),
+		usage:        &usageCache{},
+		ns:           mfs.ns,
+	}
+	mount.caps = mfs.newCapsCache(mount)
+	if len(middlewares) > 0 {
+		mount.Middleware = filesystem.Chain(middlewares...)
+	}
+	newTree, _, _ := tree.Insert([]byte(path), mount)
 
 	// Atomically update tree
-	mfs.mountTree.Store(newTree)
+	mfs.table().Store(newTree)
 
 	return nil
 }
 
 // MountPlugin dynamically mounts a plugin at the specified path
 func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[string]interface{}) error {
+	return mfs.MountPluginWithOptions(fstype, path, config, MountOptions{})
+}
+
+// MountPluginWithOptions dynamically mounts a plugin like MountPlugin, with
+// mount options
+func (mfs *MountableFS) MountPluginWithOptions(fstype string, path string, config map[string]interface{}, opts MountOptions) error {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
 
@@ -194,7 +399,7 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 	path = filesystem.NormalizePath(path)
 
 	// Load current tree
-	tree := mfs.mountTree.Load().(*iradix.Tree)
+	tree := mfs.table().Load().(*iradix.Tree)
 
 	// Check if path is already mounted
 	if _, exists := tree.Get([]byte(path)); exists {
@@ -228,6 +433,24 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 		log.Debugf("Set parentFS for plugin %s at %s", fstype, path)
 	}
 
+	// Special handling for plugins composed of other plugins. The factories
+	// are copied, as Initialize runs with mfs.mu held.
+	type pluginFactorySetter interface {
+		SetPluginFactory(func(name string) plugin.ServicePlugin)
+	}
+	if setter, ok := pluginInstance.(pluginFactorySetter); ok {
+		factories := make(map[string]PluginFactory, len(mfs.pluginFactories))
+		for name, f := range mfs.pluginFactories {
+			factories[name] = f
+		}
+		setter.SetPluginFactory(func(name string) plugin.ServicePlugin {
+			if f, ok := factories[name]; ok {
+				return f()
+			}
+			return nil
+		})
+	}
+
 	// Inject mount_path into config
 	configWithPath := make(map[string]interface{})
 	for k, v := range config {
@@ -235,39 +458,57 @@ func (mfs *MountableFS) MountPlugin(fstype string, path string, config map[strin
 	}
 	configWithPath["mount_path"] = path
 
-	// Validate plugin configuration
-	if err := pluginInstance.Validate(configWithPath); err != nil {
-		return fmt.Errorf("failed to validate plugin: %v", err)
+	// Validate and initialize plugin with config
+	if err := plugin.Initialize(pluginInstance, configWithPath); err != nil {
+		return err
 	}
-
-	// Initialize plugin with config
-	if err := pluginInstance.Initialize(configWithPath); err != nil {
-		return fmt.Errorf("failed to initialize plugin: %v", err)
+	if err := opts.validate(pluginInstance.Capabilities(), path); err != nil {
+		pluginInstance.Shutdown()
+		return err
 	}
 
 	// Create new tree with added mount
-	newTree, _, _ := tree.Insert([]byte(path), &MountPoint{
-		Path:   path,
-		Plugin: pluginInstance,
-		Config: config,
-	})
+	mount := &MountPoint{
+		Path:         path,
+		Plugin:       pluginInstance,
+		Config:       config,
+		Capabilities: pluginInstance.Capabilities(),
+		Options:      opts,
+		writes:       newWriteRanges(),
+		creates:      newCreateLocks(),
+		usage:        &usageCache{},
+		ns:           mfs.ns,
+	}
+	mount.caps = mfs.newCapsCache(mount)
+	newTree, _, _ := tree.Insert([]byte(path), mount)
 
 	// Atomically update tree
-	mfs.mountTree.Store(newTree)
+	mfs.table().Store(newTree)
 
 	log.Infof("mounted %s at %s", fstype, path)
 	return nil
 }
 
-// Unmount unmounts a plugin from the specified path
+// Unmount unmounts a plugin from the specified path, dropping the symlinks
+// that were in it
 func (mfs *MountableFS) Unmount(path string) error {
+	if err := mfs.unmount(path); err != nil {
+		return err
+	}
+	// The symlinks in the mount went with it
+	mfs.CompactSymlinks()
+	return nil
+}
+
+// unmount implements Unmount, leaving the symlinks alone
+func (mfs *MountableFS) unmount(path string) error {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
 
 	path = filesystem.NormalizePath(path)
 
 	// Load current tree
-	tree := mfs.mountTree.Load().(*iradix.Tree)
+	tree := mfs.table().Load().(*iradix.Tree)
 
 	val, exists := tree.Get([]byte(path))
 	if !exists {
@@ -284,7 +525,7 @@ func (mfs *MountableFS) Unmount(path string) error {
 	newTree, _, _ := tree.Delete([]byte(path))
 
 	// Atomically update tree
-	mfs.mountTree.Store(newTree)
+	mfs.table().Store(newTree)
 
 	log.Infof("Unmounted plugin at %s", path)
 	return nil
@@ -415,7 +656,7 @@ func (mfs *MountableFS) LoadExternalPluginsFromDirectory(dir string) ([]string,
 // GetMounts returns all mount points
 func (mfs *MountableFS) GetMounts() []*MountPoint {
 	// Lock-free read
-	tree := mfs.mountTree.Load().(*iradix.Tree)
+	tree := mfs.routes()
 
 	var mounts []*MountPoint
 	tree.Root().Walk(func(k []byte, v interface{}) bool {
@@ -425,13 +666,24 @@ func (mfs *MountableFS) GetMounts() []*MountPoint {
 	return mounts
 }
 
+// MountCapabilities returns the capabilities of the plugin at each mount
+// path, as declared or last reported by its file system
+func (mfs *MountableFS) MountCapabilities() map[string][]string {
+	mounts := mfs.GetMounts()
+	caps := make(map[string][]string, len(mounts))
+	for _, mount := range mounts {
+		caps[mount.Path] = mount.capabilities().List()
+	}
+	return caps
+}
+
 // findMount finds the mount point for a given path using lock-free radix tree lookup
 // Returns the mount and the relative path within the mount
 func (mfs *MountableFS) findMount(path string) (*MountPoint, string, bool) {
 	path = filesystem.NormalizePath(path)
 
 	// Lock-free read
-	tree := mfs.mountTree.Load().(*iradix.Tree)
+	tree := mfs.routes()
 
 	// LongestPrefix match
 	k, v, found := tree.Root().LongestPrefix([]byte(path))
@@ -474,11 +726,17 @@ func (mfs *MountableFS) Create(path string) error {
 	if err != nil {
 		return err
 	}
+	if err := mfs.reserved("create", resolved); err != nil {
+		return err
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Create(relPath)
+		if err := mount.require(plugin.CapabilityCreate, "create", path); err != nil {
+			return err
+		}
+		return mfs.pluginFS(mount).Create(relPath)
 	}
 	return filesystem.NewPermissionDeniedError("create", path, "not allowed to create file in rootfs, use mount instead")
 }
@@ -489,11 +747,43 @@ func (mfs *MountableFS) Mkdir(path string, perm uint32) error {
 	if err != nil {
 		return err
 	}
+	if err := mfs.reserved("mkdir", resolved); err != nil {
+		return err
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Mkdir(relPath, perm)
+		if err := mount.require(plugin.CapabilityMkdir, "mkdir", path); err != nil {
+			return err
+		}
+		return mfs.pluginFS(mount).Mkdir(relPath, perm)
+	}
+	return filesystem.NewPermissionDeniedError("mkdir", path, "not allowed to create directory in rootfs, use mount instead")
+}
+
+// MkdirAll implements filesystem.MkdirAller, creating path and any missing
+// parents within the mount it belongs to
+func (mfs *MountableFS) MkdirAll(path string, perm uint32) error {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if err := mfs.reserved("mkdir", resolved); err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if found {
+		if err := mount.require(plugin.CapabilityMkdir, "mkdir", path); err != nil {
+			return err
+		}
+		return filesystem.MkdirAll(mfs.pluginFS(mount), relPath, perm)
+	}
+
+	// Mount points and their parents already exist as directories
+	if info, err := mfs.Stat(resolved); err == nil && info.IsDir {
+		return nil
 	}
 	return filesystem.NewPermissionDeniedError("mkdir", path, "not allowed to create directory in rootfs, use mount instead")
 }
@@ -502,10 +792,11 @@ func (mfs *MountableFS) Remove(path string) error {
 	// Check if it's a symlink first - remove the symlink itself, not the target
 	path = filesystem.NormalizePath(path)
 	mfs.symlinksMu.Lock()
-	if _, exists := mfs.symlinks[path]; exists {
-		delete(mfs.symlinks, path)
+	if _, exists := mfs.links()[path]; exists {
+		delete(mfs.links(), path)
 		mfs.symlinksMu.Unlock()
 		log.Infof("Removed symlink: %s", path)
+		mfs.changes.notify(mfs.ns, "remove", path)
 		return nil
 	}
 	mfs.symlinksMu.Unlock()
@@ -515,22 +806,80 @@ func (mfs *MountableFS) Remove(path string) error {
 	if err != nil {
 		return err
 	}
+	if err := mfs.reserved("remove", resolved); err != nil {
+		return err
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Remove(relPath)
+		if err := mount.require(plugin.CapabilityRemove, "remove", path); err != nil {
+			return err
+		}
+		if mount.Options.Trash && !inTrash(relPath) {
+			return mfs.removeToTrash(mount, relPath, false)
+		}
+		return mfs.pluginFS(mount).Remove(relPath)
 	}
 	return filesystem.NewNotFoundError("remove", path)
 }
 
+// RemoveAll removes path and everything under it. A symlink is removed
+// itself rather than what it points to, and the virtual symlinks under a
+// removed directory go with it.
 func (mfs *MountableFS) RemoveAll(path string) error {
-	mount, relPath, found := mfs.findMount(path)
+	path = filesystem.NormalizePath(path)
+	mfs.symlinksMu.Lock()
+	if _, exists := mfs.links()[path]; exists {
+		delete(mfs.links(), path)
+		mfs.symlinksMu.Unlock()
+		log.Infof("Removed symlink: %s", path)
+		mfs.changes.notify(mfs.ns, "remove", path)
+		return nil
+	}
+	mfs.symlinksMu.Unlock()
 
-	if found {
-		return mount.Plugin.GetFileSystem().RemoveAll(relPath)
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if err := mfs.reserved("removeall", resolved); err != nil {
+		return err
+	}
+
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.NewNotFoundError("removeall", path)
+	}
+	if err := mount.require(plugin.CapabilityRemove, "removeall", path); err != nil {
+		return err
+	}
+	if mount.Options.Trash && !inTrash(relPath) {
+		err = mfs.removeToTrash(mount, relPath, true)
+	} else {
+		err = mfs.pluginFS(mount).RemoveAll(relPath)
+	}
+	if err != nil {
+		return err
+	}
+
+	mfs.removeSymlinksUnder(path)
+	if resolved != path {
+		mfs.removeSymlinksUnder(resolved)
+	}
+	return nil
+}
+
+// removeSymlinksUnder drops the virtual symlinks below dir
+func (mfs *MountableFS) removeSymlinksUnder(dir string) {
+	prefix := strings.TrimSuffix(dir, "/") + "/"
+	mfs.symlinksMu.Lock()
+	defer mfs.symlinksMu.Unlock()
+	for linkPath := range mfs.links() {
+		if strings.HasPrefix(linkPath, prefix) {
+			delete(mfs.links(), linkPath)
+		}
 	}
-	return filesystem.NewNotFoundError("removeall", path)
 }
 
 func (mfs *MountableFS) Read(path string, offset int64, size int64) ([]byte, error) {
@@ -539,11 +888,20 @@ func (mfs *MountableFS) Read(path string, offset int64, size int64) ([]byte, err
 	if err != nil {
 		return nil, err
 	}
+	if mount, name, ok := mfs.metaPath(resolved); ok {
+		return metaRead(mount, name, path, offset, size)
+	}
+	if ref, ok := mfs.snapshotPath(resolved); ok {
+		return snapshotRead(ref, path, offset, size)
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Read(relPath, offset, size)
+		if err := mount.require(plugin.CapabilityRead, "read", path); err != nil {
+			return nil, err
+		}
+		return mfs.pluginFS(mount).Read(relPath, offset, size)
 	}
 	return nil, filesystem.NewNotFoundError("read", path)
 }
@@ -554,11 +912,31 @@ func (mfs *MountableFS) Write(path string, data []byte, offset int64, flags file
 	if err != nil {
 		return 0, err
 	}
+	if err := mfs.reserved("write", resolved); err != nil {
+		return 0, err
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Write(relPath, data, offset, flags)
+		if err := mount.require(plugin.CapabilityWrite, "write", path); err != nil {
+			return 0, err
+		}
+		done, err := mount.beginWrite(relPath, path, offset, len(data), flags&filesystem.WriteFlagAppend != 0)
+		if err != nil {
+			return 0, err
+		}
+		defer done()
+		fs := mfs.pluginFS(mount)
+		if flags&filesystem.WriteFlagExclusive != 0 && mount.emulatesExclusive() {
+			var n int64
+			err := mount.exclusive(fs, relPath, path, func() (err error) {
+				n, err = fs.Write(relPath, data, offset, flags&^filesystem.WriteFlagExclusive)
+				return err
+			})
+			return n, err
+		}
+		return fs.Write(relPath, data, offset, flags)
 	}
 	return 0, filesystem.NewNotFoundError("write", path)
 }
@@ -572,19 +950,31 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	if mount, name, ok := mfs.metaPath(resolved); ok {
+		return metaReadDir(mount, name, path)
+	}
+	if ref, ok := mfs.snapshotPath(resolved); ok {
+		return snapshotReadDir(ref, path)
+	}
 
 	// 1. Check if we are listing a directory inside a mount
 	mount, relPath, found := mfs.findMount(resolved)
 	if found {
 		// Get contents from the mounted filesystem
-		infos, err := mount.Plugin.GetFileSystem().ReadDir(relPath)
+		infos, err := mfs.pluginFS(mount).ReadDir(relPath)
 		if err != nil {
 			return nil, err
 		}
+		for i := range infos {
+			mount.fileInfo(&infos[i])
+		}
+		if relPath == "/" {
+			infos = withMetaDir(infos)
+		}
 
 		// Also check for any nested mounts directly under this path
 		// e.g. mounted at /mnt, and we have /mnt/foo mounted
-		tree := mfs.mountTree.Load().(*iradix.Tree)
+		tree := mfs.routes()
 
 		// We want to find all mounts that are strictly children of `path`
 		// e.g. path="/mnt", mount="/mnt/foo" -> prefix match "/mnt/"
@@ -628,7 +1018,7 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 		// Add symlinks that are direct children of this path
 		mfs.symlinksMu.RLock()
-		for linkPath := range mfs.symlinks {
+		for linkPath := range mfs.links() {
 			linkPath = filesystem.NormalizePath(linkPath)
 			// Check if this symlink is a direct child of the current path
 			linkDir := filesystem.NormalizePath(filepath.Dir(linkPath))
@@ -670,7 +1060,7 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	}
 
 	// 2. We are not in a mount, so we are listing the virtual root or intermediate directories
-	tree := mfs.mountTree.Load().(*iradix.Tree)
+	tree := mfs.routes()
 	var infos []filesystem.FileInfo
 	seenDirs := make(map[string]bool)
 
@@ -711,7 +1101,7 @@ func (mfs *MountableFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 	// Add symlinks that are direct children of this virtual directory
 	mfs.symlinksMu.RLock()
-	for linkPath := range mfs.symlinks {
+	for linkPath := range mfs.links() {
 		linkPath = filesystem.NormalizePath(linkPath)
 		linkDir := filesystem.NormalizePath(filepath.Dir(linkPath))
 		if linkDir == path {
@@ -774,7 +1164,7 @@ func (mfs *MountableFS) Stat(path string) (*filesystem.FileInfo, error) {
 
 	// Check if path is a symlink (before resolving)
 	mfs.symlinksMu.RLock()
-	targetPath, isSymlink := mfs.symlinks[path]
+	targetPath, isSymlink := mfs.links()[path]
 	mfs.symlinksMu.RUnlock()
 
 	if isSymlink {
@@ -815,14 +1205,21 @@ func (mfs *MountableFS) statWithoutSymlinkCheck(path string) (*filesystem.FileIn
 	if err != nil {
 		return nil, err
 	}
+	if mount, name, ok := mfs.metaPath(resolved); ok {
+		return metaStat(mount, name, path)
+	}
+	if ref, ok := mfs.snapshotPath(resolved); ok {
+		return snapshotStat(ref, path)
+	}
 
 	// Check if path is a mount point or within a mount
 	mount, relPath, found := mfs.findMount(resolved)
 	if found {
-		stat, err := mount.Plugin.GetFileSystem().Stat(relPath)
+		stat, err := mfs.pluginFS(mount).Stat(relPath)
 		if err != nil {
 			return nil, err
 		}
+		mount.fileInfo(stat)
 
 		// Fix name if querying the mount point itself
 		if path == mount.Path && stat.Name == "/" {
@@ -841,7 +1238,7 @@ func (mfs *MountableFS) statWithoutSymlinkCheck(path string) (*filesystem.FileIn
 
 	// Check if path is a parent directory of any mount points
 	// e.g. /mnt when /mnt/foo exists
-	tree := mfs.mountTree.Load().(*iradix.Tree)
+	tree := mfs.routes()
 	prefix := path
 	if !strings.HasSuffix(prefix, "/") {
 		prefix += "/"
@@ -877,6 +1274,13 @@ func (mfs *MountableFS) statWithoutSymlinkCheck(path string) (*filesystem.FileIn
 }
 
 func (mfs *MountableFS) Rename(oldPath, newPath string) error {
+	if err := mfs.reserved("rename", oldPath); err != nil {
+		return err
+	}
+	if err := mfs.reserved("rename", newPath); err != nil {
+		return err
+	}
+
 	// findMount is now lock-free
 	oldMount, oldRelPath, oldFound := mfs.findMount(oldPath)
 	newMount, newRelPath, newFound := mfs.findMount(newPath)
@@ -885,36 +1289,111 @@ func (mfs *MountableFS) Rename(oldPath, newPath string) error {
 		if oldMount != newMount {
 			return fmt.Errorf("cannot rename across different mounts")
 		}
-		return oldMount.Plugin.GetFileSystem().Rename(oldRelPath, newRelPath)
+		if err := oldMount.require(plugin.CapabilityRename, "rename", oldPath); err != nil {
+			return err
+		}
+		return mfs.pluginFS(oldMount).Rename(oldRelPath, newRelPath)
 	}
 
 	return fmt.Errorf("cannot rename: paths not in same mounted filesystem")
 }
 
+// Clone implements filesystem.Cloner. Files within a mount whose plugin
+// declares plugin.CapabilityClone are cloned by the plugin; any other pair of
+// files, including ones on different mounts, is copied with filesystem.Copy.
+func (mfs *MountableFS) Clone(src, dst string) error {
+	resolvedSrc, err := mfs.resolvePath(src)
+	if err != nil {
+		return err
+	}
+	resolvedDst, err := mfs.resolvePath(dst)
+	if err != nil {
+		return err
+	}
+	if err := mfs.reserved("clone", resolvedDst); err != nil {
+		return err
+	}
+
+	// Snapshots and metadata files are only copied, as their plugin doesn't
+	// see them under those paths
+	srcMount, srcRelPath, srcFound := mfs.findMount(resolvedSrc)
+	dstMount, dstRelPath, dstFound := mfs.findMount(resolvedDst)
+	if srcFound && dstFound && srcMount == dstMount && srcMount.capabilities().Has(plugin.CapabilityClone) &&
+		mfs.reserved("clone", resolvedSrc) == nil {
+		if cloner, ok := mfs.pluginFS(srcMount).(filesystem.Cloner); ok {
+			if err := cloner.Clone(srcRelPath, dstRelPath); !errors.Is(err, filesystem.ErrNotSupported) {
+				return err
+			}
+		}
+	}
+	return filesystem.Copy(mfs, resolvedSrc, resolvedDst)
+}
+
+// CopyRange implements filesystem.RangeCopier for files within a mount
+// whose plugin declares plugin.CapabilityCopyRange. It fails with
+// ErrNotSupported for any other pair of files, which filesystem.CopyRange
+// then copies through Read and Write.
+func (mfs *MountableFS) CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	resolvedSrc, err := mfs.resolvePath(src)
+	if err != nil {
+		return 0, err
+	}
+	resolvedDst, err := mfs.resolvePath(dst)
+	if err != nil {
+		return 0, err
+	}
+	if err := mfs.reserved("copyrange", resolvedDst); err != nil {
+		return 0, err
+	}
+
+	srcMount, srcRelPath, srcFound := mfs.findMount(resolvedSrc)
+	dstMount, dstRelPath, dstFound := mfs.findMount(resolvedDst)
+	if srcFound && dstFound && srcMount == dstMount && srcMount.capabilities().Has(plugin.CapabilityCopyRange) &&
+		mfs.reserved("copyrange", resolvedSrc) == nil {
+		if copier, ok := mfs.pluginFS(srcMount).(filesystem.RangeCopier); ok {
+			return copier.CopyRange(srcRelPath, srcOffset, dstRelPath, dstOffset, length)
+		}
+	}
+	return 0, filesystem.NewNotSupportedError("copyrange", dst)
+}
+
 func (mfs *MountableFS) Chmod(path string, mode uint32) error {
 	// Resolve symlinks in all path components
 	resolved, err := mfs.resolvePath(path)
 	if err != nil {
 		return err
 	}
+	if err := mfs.reserved("chmod", resolved); err != nil {
+		return err
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
 	if found {
-		return mount.Plugin.GetFileSystem().Chmod(relPath, mode)
+		if err := mount.require(plugin.CapabilityChmod, "chmod", path); err != nil {
+			return err
+		}
+		return mfs.pluginFS(mount).Chmod(relPath, mode)
 	}
 	return filesystem.NewNotFoundError("chmod", path)
 }
 
 // Truncate implements filesystem.Truncater interface
 func (mfs *MountableFS) Truncate(path string, size int64) error {
+	if err := mfs.reserved("truncate", path); err != nil {
+		return err
+	}
+
 	mount, relPath, found := mfs.findMount(path)
 
 	if !found {
 		return filesystem.NewNotFoundError("truncate", path)
 	}
+	if err := mount.require(plugin.CapabilityTruncate, "truncate", path); err != nil {
+		return err
+	}
 
-	fs := mount.Plugin.GetFileSystem()
+	fs := mfs.pluginFS(mount)
 	if truncater, ok := fs.(filesystem.Truncater); ok {
 		return truncater.Truncate(relPath, size)
 	}
@@ -923,12 +1402,19 @@ func (mfs *MountableFS) Truncate(path string, size int64) error {
 
 // Touch implements filesystem.Toucher interface
 func (mfs *MountableFS) Touch(path string) error {
+	if err := mfs.reserved("touch", path); err != nil {
+		return err
+	}
+
 	mount, relPath, found := mfs.findMount(path)
 
 	if found {
-		fs := mount.Plugin.GetFileSystem()
+		fs := mfs.pluginFS(mount)
 		if toucher, ok := fs.(filesystem.Toucher); ok {
-			return toucher.Touch(relPath)
+			// Middlewares implement Touch whether the plugin does or not
+			if err := toucher.Touch(relPath); !errors.Is(err, filesystem.ErrNotSupported) {
+				return err
+			}
 		}
 		info, err := fs.Stat(relPath)
 		if err == nil {
@@ -949,45 +1435,98 @@ func (mfs *MountableFS) Touch(path string) error {
 	return filesystem.NewNotFoundError("touch", path)
 }
 
+// Open opens path for streaming reads. Plugins without a streaming Open are
+// read through Read one chunk at a time.
 func (mfs *MountableFS) Open(path string) (io.ReadCloser, error) {
 	// Resolve symlinks in all path components
 	resolved, err := mfs.resolvePath(path)
 	if err != nil {
 		return nil, err
 	}
+	if mount, name, ok := mfs.metaPath(resolved); ok {
+		return metaOpen(mount, name, path)
+	}
+	if ref, ok := mfs.snapshotPath(resolved); ok {
+		return snapshotOpen(ref, path)
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
-	if found {
-		return mount.Plugin.GetFileSystem().Open(relPath)
+	if !found {
+		return nil, filesystem.NewNotFoundError("open", path)
+	}
+	if err := mount.require(plugin.CapabilityRead, "open", path); err != nil {
+		return nil, err
 	}
-	return nil, filesystem.NewNotFoundError("open", path)
+
+	fs := mfs.pluginFS(mount)
+	reader, err := fs.Open(relPath)
+	if !errors.Is(err, filesystem.ErrNotSupported) {
+		return reader, err
+	}
+
+	// The plugin only implements Read, read the file a chunk at a time
+	info, err := fs.Stat(relPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	return filesystem.NewChunkedReader(relPath, fs.Read, fallbackReadChunkSize), nil
 }
 
+// OpenWrite opens path for streaming writes. For plugins without a streaming
+// OpenWrite the data is buffered and written with Write on Close.
 func (mfs *MountableFS) OpenWrite(path string) (io.WriteCloser, error) {
 	// Resolve symlinks in all path components
 	resolved, err := mfs.resolvePath(path)
 	if err != nil {
 		return nil, err
 	}
+	if err := mfs.reserved("openwrite", resolved); err != nil {
+		return nil, err
+	}
 
 	mount, relPath, found := mfs.findMount(resolved)
 
-	if found {
-		return mount.Plugin.GetFileSystem().OpenWrite(relPath)
+	if !found {
+		return nil, filesystem.NewNotFoundError("openwrite", path)
+	}
+	if err := mount.require(plugin.CapabilityWrite, "openwrite", path); err != nil {
+		return nil, err
+	}
+
+	fs := mfs.pluginFS(mount)
+	writer, err := fs.OpenWrite(relPath)
+	if !errors.Is(err, filesystem.ErrNotSupported) {
+		return writer, err
 	}
-	return nil, filesystem.NewNotFoundError("openwrite", path)
+
+	// The plugin only implements Write, write the file in one go on Close
+	return filesystem.NewBufferedWriter(relPath, fs.Write), nil
 }
 
 // OpenStream implements filesystem.Streamer interface
 func (mfs *MountableFS) OpenStream(path string) (filesystem.StreamReader, error) {
+	// The .agfs files and snapshots are served by Read only
+	if _, _, ok := mfs.metaPath(filesystem.NormalizePath(path)); ok {
+		return nil, filesystem.NewNotSupportedError("openstream", path)
+	}
+	if _, ok := mfs.snapshotPath(filesystem.NormalizePath(path)); ok {
+		return nil, filesystem.NewNotSupportedError("openstream", path)
+	}
+
 	mount, relPath, found := mfs.findMount(path)
 
 	if !found {
 		return nil, filesystem.NewNotFoundError("openstream", path)
 	}
+	if err := mount.require(plugin.CapabilityStream, "openstream", path); err != nil {
+		return nil, err
+	}
 
-	fs := mount.Plugin.GetFileSystem()
+	fs := mount.wrap(mount.Plugin.GetFileSystem())
 	if streamer, ok := fs.(filesystem.Streamer); ok {
 		log.Debugf("[mountablefs] OpenStream: found streamer for path %s (relPath: %s, fs type: %T)", path, relPath, fs)
 		return streamer.OpenStream(relPath)
@@ -1000,6 +1539,14 @@ func (mfs *MountableFS) OpenStream(path string) (filesystem.StreamReader, error)
 // GetStream tries to get a stream from the underlying filesystem if it supports streaming
 // Deprecated: Use OpenStream instead
 func (mfs *MountableFS) GetStream(path string) (interface{}, error) {
+	// The .agfs files and snapshots are served by Read only
+	if _, _, ok := mfs.metaPath(filesystem.NormalizePath(path)); ok {
+		return nil, filesystem.NewNotSupportedError("getstream", path)
+	}
+	if _, ok := mfs.snapshotPath(filesystem.NormalizePath(path)); ok {
+		return nil, filesystem.NewNotSupportedError("getstream", path)
+	}
+
 	mount, relPath, found := mfs.findMount(path)
 
 	if !found {
@@ -1027,23 +1574,46 @@ func (mfs *MountableFS) GetStream(path string) (interface{}, error) {
 // OpenHandle opens a file and returns a handle for stateful operations
 // This delegates to the underlying filesystem if it supports HandleFS
 func (mfs *MountableFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uint32) (filesystem.FileHandle, error) {
+	// The .agfs files and snapshots are served by Read only
+	if _, _, ok := mfs.metaPath(filesystem.NormalizePath(path)); ok {
+		return nil, filesystem.NewNotSupportedError("openhandle", path)
+	}
+	if _, ok := mfs.snapshotPath(filesystem.NormalizePath(path)); ok {
+		return nil, filesystem.NewNotSupportedError("openhandle", path)
+	}
+
 	mount, relPath, found := mfs.findMount(path)
 
 	if !found {
 		return nil, filesystem.NewNotFoundError("openhandle", path)
 	}
+	if err := mount.require(plugin.CapabilityHandles, "openhandle", path); err != nil {
+		return nil, err
+	}
 
-	fs := mount.Plugin.GetFileSystem()
+	fs := mount.wrap(mount.Plugin.GetFileSystem())
 	handleFS, ok := fs.(filesystem.HandleFS)
 	if !ok {
 		return nil, filesystem.NewNotSupportedError("openhandle", path)
 	}
 
 	// Open handle in the underlying filesystem
-	localHandle, err := handleFS.OpenHandle(relPath, flags, mode)
+	var localHandle filesystem.FileHandle
+	var err error
+	if flags&filesystem.O_CREATE != 0 && flags&filesystem.O_EXCL != 0 && mount.emulatesExclusive() {
+		err = mount.exclusive(fs, relPath, path, func() (err error) {
+			localHandle, err = handleFS.OpenHandle(relPath, flags&^filesystem.O_EXCL, mode)
+			return err
+		})
+	} else {
+		localHandle, err = handleFS.OpenHandle(relPath, flags, mode)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if flags&(filesystem.O_CREATE|filesystem.O_TRUNC) != 0 {
+		mfs.changes.notify(mount.ns, "write", path)
+	}
 
 	// Generate a globally unique handle ID
 	globalID := mfs.globalHandleID.Add(1)
@@ -1053,6 +1623,7 @@ func (mfs *MountableFS) OpenHandle(path string, flags filesystem.OpenFlag, mode
 	mfs.handleInfos[globalID] = &handleInfo{
 		mount:       mount,
 		localHandle: localHandle,
+		ns:          mfs.ns,
 	}
 	mfs.handleInfosMu.Unlock()
 
@@ -1060,8 +1631,9 @@ func (mfs *MountableFS) OpenHandle(path string, flags filesystem.OpenFlag, mode
 	return &globalFileHandle{
 		globalID:    globalID,
 		localHandle: localHandle,
-		mountPath:   mount.Path,
+		mount:       mount,
 		fullPath:    path,
+		changes:     &mfs.changes,
 	}, nil
 }
 
@@ -1072,7 +1644,8 @@ func (mfs *MountableFS) GetHandle(id int64) (filesystem.FileHandle, error) {
 	info, found := mfs.handleInfos[id]
 	mfs.handleInfosMu.RUnlock()
 
-	if !found {
+	// Handles of another namespace don't exist in this one
+	if !found || info.ns != mfs.ns {
 		return nil, filesystem.ErrNotFound
 	}
 
@@ -1080,8 +1653,9 @@ func (mfs *MountableFS) GetHandle(id int64) (filesystem.FileHandle, error) {
 	return &globalFileHandle{
 		globalID:    id,
 		localHandle: info.localHandle,
-		mountPath:   info.mount.Path,
+		mount:       info.mount,
 		fullPath:    info.mount.Path + info.localHandle.Path(),
+		changes:     &mfs.changes,
 	}, nil
 }
 
@@ -1092,7 +1666,8 @@ func (mfs *MountableFS) CloseHandle(id int64) error {
 	info, found := mfs.handleInfos[id]
 	mfs.handleInfosMu.RUnlock()
 
-	if !found {
+	// Handles of another namespace don't exist in this one
+	if !found || info.ns != mfs.ns {
 		return filesystem.ErrNotFound
 	}
 
@@ -1113,8 +1688,9 @@ func (mfs *MountableFS) CloseHandle(id int64) error {
 type globalFileHandle struct {
 	globalID    int64                 // Globally unique ID assigned by MountableFS
 	localHandle filesystem.FileHandle // Underlying handle from the plugin
-	mountPath   string                // Mount path for this handle
+	mount       *MountPoint           // Mount point of this handle
 	fullPath    string                // Full path including mount point
+	changes     *changeHub            // Reports the writes through the handle
 }
 
 // ID returns the globally unique handle ID
@@ -1137,14 +1713,33 @@ func (h *globalFileHandle) ReadAt(buf []byte, offset int64) (int, error) {
 	return h.localHandle.ReadAt(buf, offset)
 }
 
-// Write delegates to the underlying handle
+// Write delegates to the underlying handle. The mount doesn't track the
+// handle's position, so the write is coordinated as covering the whole file.
 func (h *globalFileHandle) Write(data []byte) (int, error) {
-	return h.localHandle.Write(data)
+	done, err := h.mount.beginWrite(h.localHandle.Path(), h.fullPath, -1, len(data), false)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	n, err := h.localHandle.Write(data)
+	if err == nil {
+		h.changes.notify(h.mount.ns, "write", h.fullPath)
+	}
+	return n, err
 }
 
 // WriteAt delegates to the underlying handle
 func (h *globalFileHandle) WriteAt(data []byte, offset int64) (int, error) {
-	return h.localHandle.WriteAt(data, offset)
+	done, err := h.mount.beginWrite(h.localHandle.Path(), h.fullPath, offset, len(data), h.localHandle.Flags()&filesystem.O_APPEND != 0)
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	n, err := h.localHandle.WriteAt(data, offset)
+	if err == nil {
+		h.changes.notify(h.mount.ns, "write", h.fullPath)
+	}
+	return n, err
 }
 
 // Seek delegates to the underlying handle
@@ -1178,7 +1773,7 @@ func (mfs *MountableFS) resolveSymlink(path string) (string, bool) {
 	path = filesystem.NormalizePath(path)
 
 	mfs.symlinksMu.RLock()
-	target, isLink := mfs.symlinks[path]
+	target, isLink := mfs.links()[path]
 	mfs.symlinksMu.RUnlock()
 
 	if isLink {
@@ -1255,10 +1850,13 @@ func (mfs *MountableFS) resolvePath(path string) (string, error) {
 // Creates a virtual symlink at the mountablefs layer without requiring backend support
 func (mfs *MountableFS) Symlink(targetPath, linkPath string) error {
 	linkPath = filesystem.NormalizePath(linkPath)
+	if err := mfs.reserved("symlink", linkPath); err != nil {
+		return err
+	}
 
 	// Check if link path already exists (as a file/directory or symlink)
 	mfs.symlinksMu.RLock()
-	_, exists := mfs.symlinks[linkPath]
+	_, exists := mfs.links()[linkPath]
 	mfs.symlinksMu.RUnlock()
 
 	if exists {
@@ -1288,10 +1886,11 @@ func (mfs *MountableFS) Symlink(targetPath, linkPath string) error {
 
 	// Store the symlink mapping
 	mfs.symlinksMu.Lock()
-	mfs.symlinks[linkPath] = targetPath
+	mfs.links()[linkPath] = targetPath
 	mfs.symlinksMu.Unlock()
 
 	log.Infof("Created symlink: %s -> %s", linkPath, targetPath)
+	mfs.changes.notify(mfs.ns, "symlink", linkPath)
 	return nil
 }
 
@@ -1301,7 +1900,7 @@ func (mfs *MountableFS) Readlink(linkPath string) (string, error) {
 	linkPath = filesystem.NormalizePath(linkPath)
 
 	mfs.symlinksMu.RLock()
-	target, exists := mfs.symlinks[linkPath]
+	target, exists := mfs.links()[linkPath]
 	mfs.symlinksMu.RUnlock()
 
 	if !exists {
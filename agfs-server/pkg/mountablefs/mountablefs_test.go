@@ -3,6 +3,7 @@ package mountablefs
 import (
 	"io"
 	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
@@ -12,6 +13,7 @@ import (
 
 // MockPlugin implements plugin.ServicePlugin for testing
 type MockPlugin struct {
+	plugin.BasePlugin
 	name string
 }
 
@@ -159,7 +161,8 @@ func TestMountableFSRouting(t *testing.T) {
 // MockFS implements filesystem.FileSystem for testing symlink functionality
 type MockFS struct {
 	files map[string]*MockFile // path -> file content
-	dirs  map[string]bool      // path -> isDir
+	dirs  map[string]uint32    // path -> dir mode
+	reads atomic.Int64         // number of Read calls
 	mu    sync.RWMutex
 }
 
@@ -171,7 +174,7 @@ type MockFile struct {
 func NewMockFS() *MockFS {
 	return &MockFS{
 		files: make(map[string]*MockFile),
-		dirs:  make(map[string]bool),
+		dirs:  make(map[string]uint32),
 	}
 }
 
@@ -192,10 +195,10 @@ func (m *MockFS) Mkdir(path string, perm uint32) error {
 	defer m.mu.Unlock()
 
 	path = filesystem.NormalizePath(path)
-	if m.dirs[path] {
+	if _, exists := m.dirs[path]; exists {
 		return filesystem.NewAlreadyExistsError("mkdir", path)
 	}
-	m.dirs[path] = true
+	m.dirs[path] = perm
 	return nil
 }
 
@@ -208,7 +211,7 @@ func (m *MockFS) Remove(path string) error {
 		delete(m.files, path)
 		return nil
 	}
-	if m.dirs[path] {
+	if _, exists := m.dirs[path]; exists {
 		delete(m.dirs, path)
 		return nil
 	}
@@ -216,7 +219,7 @@ func (m *MockFS) Remove(path string) error {
 }
 
 func (m *MockFS) RemoveAll(path string) error {
-	return m.Remove(path)
+	return filesystem.RemoveAllWalk(m, path)
 }
 
 func (m *MockFS) Read(path string, offset int64, size int64) ([]byte, error) {
@@ -228,7 +231,18 @@ func (m *MockFS) Read(path string, offset int64, size int64) ([]byte, error) {
 	if !exists {
 		return nil, filesystem.NewNotFoundError("read", path)
 	}
-	return file.content, nil
+	m.reads.Add(1)
+	if size < 0 {
+		return file.content, nil
+	}
+	if offset >= int64(len(file.content)) {
+		return nil, io.EOF
+	}
+	end := offset + size
+	if end >= int64(len(file.content)) {
+		return file.content[offset:], io.EOF
+	}
+	return file.content[offset:end], nil
 }
 
 func (m *MockFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
@@ -254,7 +268,7 @@ func (m *MockFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	defer m.mu.RUnlock()
 
 	path = filesystem.NormalizePath(path)
-	if !m.dirs[path] && path != "/" {
+	if _, exists := m.dirs[path]; !exists && path != "/" {
 		return nil, filesystem.NewNotFoundError("readdir", path)
 	}
 
@@ -273,7 +287,7 @@ func (m *MockFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		}
 	}
 	// Add subdirectories
-	for d := range m.dirs {
+	for d, mode := range m.dirs {
 		if d == path {
 			continue
 		}
@@ -283,7 +297,7 @@ func (m *MockFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			infos = append(infos, filesystem.FileInfo{
 				Name:  name,
 				Size:  0,
-				Mode:  0755,
+				Mode:  mode,
 				IsDir: true,
 			})
 		}
@@ -325,12 +339,12 @@ func (m *MockFS) Stat(path string) (*filesystem.FileInfo, error) {
 		}, nil
 	}
 
-	if m.dirs[path] {
+	if mode, exists := m.dirs[path]; exists {
 		name := path[lastSlash(path)+1:]
 		return &filesystem.FileInfo{
 			Name:  name,
 			Size:  0,
-			Mode:  0755,
+			Mode:  mode,
 			IsDir: true,
 		}, nil
 	}
@@ -351,6 +365,10 @@ func (m *MockFS) Chmod(path string, mode uint32) error {
 		file.mode = mode
 		return nil
 	}
+	if _, exists := m.dirs[path]; exists {
+		m.dirs[path] = mode
+		return nil
+	}
 	return filesystem.NewNotFoundError("chmod", path)
 }
 
@@ -364,6 +382,7 @@ func (m *MockFS) OpenWrite(path string) (io.WriteCloser, error) {
 
 // MockServicePlugin wraps a MockFS as a ServicePlugin
 type MockServicePlugin struct {
+	plugin.BasePlugin
 	fs   *MockFS
 	name string
 }
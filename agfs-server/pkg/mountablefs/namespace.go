@@ -0,0 +1,127 @@
+package mountablefs
+
+import (
+	"context"
+	"sync/atomic"
+
+	iradix "github.com/hashicorp/go-immutable-radix"
+)
+
+// principalKey is the context key of the authenticated principal
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the authenticated principal
+// a request is made by. WithContext views of a MountableFS made with it
+// operate in the principal's namespace.
+func WithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal carried by ctx, or "" if there
+// is none
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// namespace is the mount table of a principal. Its mounts are layered over
+// the shared mounts of the MountableFS, a mount of the namespace hiding a
+// shared mount at the same path. Symlinks, handles and change events of a
+// namespace are its own too.
+type namespace struct {
+	principal string
+
+	// mountTree holds the mounts of the namespace only, like
+	// mountState.mountTree
+	mountTree atomic.Value
+	// routes caches the shared and own mounts merged, as *nsRoutes
+	routes atomic.Value
+
+	symlinks map[string]string // Guarded by mountState.symlinksMu
+}
+
+// nsRoutes is a namespace's mount tree merged with the shared one, along
+// with the trees it was merged from
+type nsRoutes struct {
+	base, own *iradix.Tree
+	tree      *iradix.Tree
+}
+
+// Namespace returns the view of mfs in the namespace of principal: Mount
+// and Unmount change the principal's own mount table, and paths are routed
+// to its mounts first, then to the shared mounts of mfs, which every
+// namespace sees. Another namespace's mounts, symlinks, handles and change
+// events aren't visible. An empty principal is the shared namespace,
+// where mounts are visible to all. Namespaces are created on first use and
+// live as long as mfs.
+func (mfs *MountableFS) Namespace(principal string) *MountableFS {
+	view := &MountableFS{mountState: mfs.mountState, ctx: mfs.ctx}
+	if principal != "" {
+		view.ns = mfs.namespace(principal)
+	}
+	return view
+}
+
+// Principal returns the principal whose namespace mfs is a view of, or ""
+// for the shared namespace
+func (mfs *MountableFS) Principal() string {
+	if mfs.ns == nil {
+		return ""
+	}
+	return mfs.ns.principal
+}
+
+// namespace returns the namespace of principal, creating it if needed
+func (mfs *MountableFS) namespace(principal string) *namespace {
+	mfs.namespacesMu.Lock()
+	defer mfs.namespacesMu.Unlock()
+	ns, ok := mfs.namespaces[principal]
+	if !ok {
+		ns = &namespace{principal: principal, symlinks: make(map[string]string)}
+		ns.mountTree.Store(iradix.New())
+		mfs.namespaces[principal] = ns
+	}
+	return ns
+}
+
+// table returns the mount tree Mount and Unmount change: the namespace's
+// own for a namespace view, the shared one otherwise
+func (mfs *MountableFS) table() *atomic.Value {
+	if mfs.ns != nil {
+		return &mfs.ns.mountTree
+	}
+	return &mfs.mountTree
+}
+
+// routes returns the mount tree paths are routed with: the shared mounts,
+// overlaid with the namespace's own for a namespace view
+func (mfs *MountableFS) routes() *iradix.Tree {
+	base := mfs.mountTree.Load().(*iradix.Tree)
+	if mfs.ns == nil {
+		return base
+	}
+	own := mfs.ns.mountTree.Load().(*iradix.Tree)
+	if r, ok := mfs.ns.routes.Load().(*nsRoutes); ok && r.base == base && r.own == own {
+		return r.tree
+	}
+
+	// The trees are immutable, so a merge of both is valid as long as
+	// neither is replaced
+	txn := base.Txn()
+	own.Root().Walk(func(k []byte, v interface{}) bool {
+		txn.Insert(k, v)
+		return false
+	})
+	r := &nsRoutes{base: base, own: own, tree: txn.Commit()}
+	mfs.ns.routes.Store(r)
+	return r.tree
+}
+
+// links returns the symlinks of the view's namespace. The caller holds
+// symlinksMu.
+func (mfs *MountableFS) links() map[string]string {
+	if mfs.ns != nil {
+		return mfs.ns.symlinks
+	}
+	return mfs.symlinks
+}
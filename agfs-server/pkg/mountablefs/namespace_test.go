@@ -0,0 +1,145 @@
+package mountablefs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newMemFS(t *testing.T) *memfs.MemFSPlugin {
+	t.Helper()
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	return p
+}
+
+func mountPaths(mfs *MountableFS) []string {
+	var paths []string
+	for _, mount := range mfs.GetMounts() {
+		paths = append(paths, mount.Path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func TestNamespaces(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/shared", newMemFS(t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	alice, bob := mfs.Namespace("alice"), mfs.Namespace("bob")
+	if err := alice.Mount("/home", newMemFS(t)); err != nil {
+		t.Fatalf("Failed to mount in alice's namespace: %v", err)
+	}
+	// Bob mounts at the same path without conflicting
+	if err := bob.Mount("/home", newMemFS(t)); err != nil {
+		t.Fatalf("Failed to mount in bob's namespace: %v", err)
+	}
+	if err := alice.Mount("/private", newMemFS(t)); err != nil {
+		t.Fatalf("Failed to mount in alice's namespace: %v", err)
+	}
+
+	for view, want := range map[*MountableFS]string{mfs: "[/shared]", alice: "[/home /private /shared]", bob: "[/home /shared]"} {
+		if got := mountPaths(view); fmt.Sprint(got) != want {
+			t.Errorf("Expected %s to see the mounts %s, got %v", view.Principal(), want, got)
+		}
+	}
+
+	// Each sees its own /home, and both the shared mount
+	if _, err := alice.Write("/home/f", []byte("alice"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := bob.Stat("/home/f"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected alice's file invisible to bob, got %v", err)
+	}
+	if _, err := bob.Stat("/private"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected alice's mount invisible to bob, got %v", err)
+	}
+	if _, err := alice.Write("/shared/f", []byte("both"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if data, err := bob.Read("/shared/f", 0, -1); (err != nil && err != io.EOF) || string(data) != "both" {
+		t.Errorf("Expected the shared mount visible to bob, got %q, %v", data, err)
+	}
+
+	// A namespace can neither unmount the shared mounts nor another's
+	if err := bob.Unmount("/shared"); err == nil {
+		t.Error("Expected bob's unmount of the shared mount to fail")
+	}
+	if err := bob.Unmount("/private"); err == nil {
+		t.Error("Expected bob's unmount of alice's mount to fail")
+	}
+	if err := alice.Unmount("/private"); err != nil {
+		t.Errorf("Unmount failed: %v", err)
+	}
+
+	// Handles and symlinks stay in the namespace they were made in
+	h, err := alice.OpenHandle("/home/f", filesystem.O_RDONLY, 0)
+	if err != nil {
+		t.Fatalf("OpenHandle failed: %v", err)
+	}
+	if _, err := bob.GetHandle(h.ID()); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected alice's handle invisible to bob, got %v", err)
+	}
+	if err := bob.CloseHandle(h.ID()); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected bob unable to close alice's handle, got %v", err)
+	}
+	if err := alice.CloseHandle(h.ID()); err != nil {
+		t.Errorf("CloseHandle failed: %v", err)
+	}
+	if err := alice.Symlink("/home/f", "/shared/link"); err != nil {
+		t.Fatalf("Symlink failed: %v", err)
+	}
+	if _, err := bob.Readlink("/shared/link"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected alice's symlink invisible to bob, got %v", err)
+	}
+}
+
+func TestNamespaceFromContext(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Namespace("alice").Mount("/home", newMemFS(t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+
+	fs := mfs.WithContext(WithPrincipal(context.Background(), "alice"))
+	if _, err := fs.Stat("/home"); err != nil {
+		t.Errorf("Expected alice's mount in a view bound to her principal, got %v", err)
+	}
+	for _, ctx := range []context.Context{context.Background(), WithPrincipal(context.Background(), "bob")} {
+		if _, err := mfs.WithContext(ctx).Stat("/home"); !errors.Is(err, filesystem.ErrNotFound) {
+			t.Errorf("Expected alice's mount invisible to %q, got %v", PrincipalFromContext(ctx), err)
+		}
+	}
+}
+
+func TestNamespaceChanges(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/shared", newMemFS(t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	alice, bob := mfs.Namespace("alice"), mfs.Namespace("bob")
+	if err := alice.Mount("/home", newMemFS(t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	sub := bob.Subscribe("/")
+	defer sub.Close()
+
+	if _, err := alice.Write("/home/f", []byte("private"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := alice.Write("/shared/f", []byte("public"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if event := <-sub.C; event.Path != "/shared/f" {
+		t.Errorf("Expected only the change of the shared mount reported to bob, got %+v", event)
+	}
+}
@@ -0,0 +1,70 @@
+package mountablefs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+func TestMountDefaultModes(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	for path, opts := range map[string]MountOptions{
+		"/plain":      {},
+		"/restricted": {DefaultFileMode: 0600, DefaultDirMode: 0700},
+	} {
+		p := NewMockServicePlugin("mock")
+		// A plugin that doesn't track permissions reports mode 0
+		p.fs.files["/file"] = &MockFile{content: []byte("data")}
+		p.fs.dirs["/dir"] = 0
+		p.fs.files["/kept"] = &MockFile{mode: 0640}
+		if err := mfs.MountWithOptions(path, p, opts); err != nil {
+			t.Fatalf("Mount failed: %v", err)
+		}
+	}
+
+	expected := map[string]uint32{
+		"/plain/file":      0644,
+		"/plain/dir":       0755,
+		"/plain/kept":      0640,
+		"/restricted/file": 0600,
+		"/restricted/dir":  0700,
+		"/restricted/kept": 0640,
+	}
+	for path, mode := range expected {
+		info, err := mfs.Stat(path)
+		if err != nil {
+			t.Fatalf("Stat %s failed: %v", path, err)
+		}
+		if info.Mode != mode {
+			t.Errorf("Expected Stat of %s to report mode %o, got %o", path, mode, info.Mode)
+		}
+	}
+
+	for _, dir := range []string{"/plain", "/restricted"} {
+		entries, err := mfs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("ReadDir %s failed: %v", dir, err)
+		}
+		for _, e := range entries {
+			if mode, ok := expected[dir+"/"+e.Name]; ok && e.Mode != mode {
+				t.Errorf("Expected ReadDir of %s to report mode %o for %s, got %o", dir, mode, e.Name, e.Mode)
+			}
+		}
+	}
+}
+
+func TestParseMountOptions(t *testing.T) {
+	opts, err := ParseMountOptions("0640", "")
+	if err != nil {
+		t.Fatalf("ParseMountOptions failed: %v", err)
+	}
+	if opts != (MountOptions{DefaultFileMode: 0640}) {
+		t.Errorf("Expected file mode 0640 and no dir mode, got %+v", opts)
+	}
+
+	for _, mode := range []string{"rw-r--r--", "0999", "100644"} {
+		if _, err := ParseMountOptions("", mode); err == nil {
+			t.Errorf("Expected %q to be rejected", mode)
+		}
+	}
+}
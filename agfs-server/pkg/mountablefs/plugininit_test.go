@@ -0,0 +1,97 @@
+package mountablefs
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+var errBackendDown = errors.New("connection refused")
+
+// failingPlugin is a plugin whose Initialize fails, after Validate rejects
+// configurations without "dsn" if strict
+type failingPlugin struct {
+	MockPlugin
+	strict bool
+}
+
+func (p *failingPlugin) Validate(cfg map[string]interface{}) error {
+	if _, ok := cfg["dsn"]; p.strict && !ok {
+		return errors.New("dsn is required")
+	}
+	return nil
+}
+
+func (p *failingPlugin) Initialize(cfg map[string]interface{}) error {
+	return errBackendDown
+}
+
+func (p *failingPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{Name: "dsn", Type: "string", Required: true},
+		{Name: "timeout", Type: "int", Required: false},
+	}
+}
+
+func TestMountPluginInitFailure(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		strict    bool
+		config    map[string]interface{}
+		validated bool
+		provided  []string
+		missing   []string
+	}{
+		{
+			name:      "initialize",
+			config:    map[string]interface{}{"timeout": 5, "dsn": "user:secret@tcp(db)/x"},
+			validated: true,
+			provided:  []string{"dsn", "timeout"},
+		},
+		{
+			name:     "validate",
+			strict:   true,
+			config:   map[string]interface{}{"timeout": 5},
+			provided: []string{"timeout"},
+			missing:  []string{"dsn"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			mfs.RegisterPluginFactory("failfs", func() plugin.ServicePlugin {
+				return &failingPlugin{MockPlugin: MockPlugin{name: "failfs"}, strict: tc.strict}
+			})
+
+			err := mfs.MountPluginWithOptions("failfs", "/db", tc.config, MountOptions{})
+			var initErr *plugin.PluginInitError
+			if !errors.As(err, &initErr) {
+				t.Fatalf("Expected a PluginInitError, got %v", err)
+			}
+			if initErr.Plugin != "failfs" || initErr.MountPath != "/db" || initErr.Validated != tc.validated {
+				t.Errorf("Expected failfs at /db (validated=%v), got %+v", tc.validated, initErr)
+			}
+			if !reflect.DeepEqual(initErr.Provided, tc.provided) || !reflect.DeepEqual(initErr.Missing, tc.missing) {
+				t.Errorf("Expected provided %q and missing %q, got %q and %q",
+					tc.provided, tc.missing, initErr.Provided, initErr.Missing)
+			}
+			if tc.validated && !errors.Is(err, errBackendDown) {
+				t.Errorf("Expected the plugin's error wrapped, got %v", err)
+			}
+			msg := err.Error()
+			if !strings.Contains(msg, "failfs") || !strings.Contains(msg, "/db") {
+				t.Errorf("Expected the plugin and mount path in %q", msg)
+			}
+			if strings.Contains(msg, "secret") {
+				t.Errorf("Expected no parameter values in %q", msg)
+			}
+
+			if mounts := mfs.GetMounts(); len(mounts) != 0 {
+				t.Errorf("Expected nothing mounted, got %d mounts", len(mounts))
+			}
+		})
+	}
+}
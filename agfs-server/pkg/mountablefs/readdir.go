@@ -0,0 +1,133 @@
+package mountablefs
+
+import (
+	"path"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Meta.Content keys of the entries ReadDirWithOptions dereferences
+const (
+	// MetaKeyLinkTarget is the target of a symlink listed with its target's
+	// attributes, or of a broken one
+	MetaKeyLinkTarget = "link_target"
+
+	// MetaKeyBrokenLink is "true" on a symlink whose target doesn't exist or
+	// can't be reached, which is listed as the link itself
+	MetaKeyBrokenLink = "broken_link"
+)
+
+// Kinds of entries ReadDirOptions.Types selects
+const (
+	EntryFile    = "file"
+	EntryDir     = "dir"
+	EntrySymlink = "symlink"
+)
+
+// ReadDirOptions change what ReadDirWithOptions lists. The zero value lists
+// what ReadDir does.
+type ReadDirOptions struct {
+	// Dereference lists symlinks with the attributes of their targets, as
+	// ls -L does, noting the target under MetaKeyLinkTarget. Broken links
+	// are listed as they are, marked with MetaKeyBrokenLink, rather than
+	// failing the listing.
+	Dereference bool
+
+	// SkipHidden leaves out entries whose name starts with a dot
+	SkipHidden bool
+
+	// SkipVirtual leaves out the entries the server makes up rather than a
+	// plugin stores: the .agfs directory of a mount and what is in it
+	SkipVirtual bool
+
+	// Types, if set, keeps only entries of these kinds (EntryFile, EntryDir,
+	// EntrySymlink), judged after dereferencing, so a link to a directory
+	// is a directory with Dereference and a symlink without
+	Types []string
+}
+
+// ReadDirWithOptions lists path like ReadDir, changed by opts
+func (mfs *MountableFS) ReadDirWithOptions(dirPath string, opts ReadDirOptions) ([]filesystem.FileInfo, error) {
+	infos, err := mfs.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	dirPath = filesystem.NormalizePath(dirPath)
+
+	kept := infos[:0]
+	for _, info := range infos {
+		if opts.SkipHidden && strings.HasPrefix(info.Name, ".") {
+			continue
+		}
+		if opts.SkipVirtual && info.Meta.Type == MetaValueVirtual {
+			continue
+		}
+		if opts.Dereference && info.Meta.Type == "symlink" {
+			info = mfs.dereference(path.Join(dirPath, info.Name), info)
+		}
+		if len(opts.Types) > 0 && !hasEntryType(opts.Types, entryType(info)) {
+			continue
+		}
+		kept = append(kept, info)
+	}
+	return kept, nil
+}
+
+// dereference returns the attributes of the target of the symlink at
+// linkPath listed as link, under the link's name, or link marked broken if
+// the target can't be reached
+func (mfs *MountableFS) dereference(linkPath string, link filesystem.FileInfo) filesystem.FileInfo {
+	target, _ := mfs.Readlink(linkPath)
+
+	var info *filesystem.FileInfo
+	resolved, err := mfs.resolvePath(linkPath)
+	if err == nil {
+		info, err = mfs.statWithoutSymlinkCheck(resolved)
+	}
+	if err != nil || info.Meta.Type == "symlink" {
+		link.Meta.Content = withMeta(link.Meta.Content, MetaKeyBrokenLink, "true")
+		if target != "" {
+			link.Meta.Content[MetaKeyLinkTarget] = target
+		}
+		return link
+	}
+
+	deref := *info
+	deref.Name = link.Name
+	if target != "" {
+		deref.Meta.Content = withMeta(deref.Meta.Content, MetaKeyLinkTarget, target)
+	}
+	return deref
+}
+
+// withMeta returns a copy of content with key set to value, leaving content,
+// which the plugin may share between listings, untouched
+func withMeta(content map[string]string, key, value string) map[string]string {
+	copied := make(map[string]string, len(content)+1)
+	for k, v := range content {
+		copied[k] = v
+	}
+	copied[key] = value
+	return copied
+}
+
+// entryType returns the kind of an entry, for ReadDirOptions.Types
+func entryType(info filesystem.FileInfo) string {
+	switch {
+	case info.Meta.Type == "symlink":
+		return EntrySymlink
+	case info.IsDir:
+		return EntryDir
+	}
+	return EntryFile
+}
+
+func hasEntryType(types []string, t string) bool {
+	for _, want := range types {
+		if want == t {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package mountablefs
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestReadDirWithOptions(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	if err := mfs.Mount("/mem", p); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	// memfs starts with a README
+	if err := mfs.Remove("/mem/README"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	for name, data := range map[string]string{"/mem/file": "hello", "/mem/.hidden": "x"} {
+		if _, err := mfs.Write(name, []byte(data), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	if err := mfs.Mkdir("/mem/dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	for link, target := range map[string]string{
+		"/mem/link":    "/mem/file",
+		"/mem/dirlink": "dir",
+		"/mem/broken":  "/mem/missing",
+		"/mem/loop":    "/mem/loop",
+	} {
+		if err := mfs.Symlink(target, link); err != nil {
+			t.Fatalf("Symlink %s failed: %v", link, err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		opts ReadDirOptions
+		want string // Sorted name:kind pairs
+	}{
+		{"default", ReadDirOptions{},
+			".agfs:dir .hidden:file broken:symlink dir:dir dirlink:symlink file:file link:symlink loop:symlink"},
+		{"skip hidden", ReadDirOptions{SkipHidden: true},
+			"broken:symlink dir:dir dirlink:symlink file:file link:symlink loop:symlink"},
+		{"skip virtual", ReadDirOptions{SkipVirtual: true},
+			".hidden:file broken:symlink dir:dir dirlink:symlink file:file link:symlink loop:symlink"},
+		{"dereference", ReadDirOptions{Dereference: true},
+			".agfs:dir .hidden:file broken:symlink dir:dir dirlink:dir file:file link:file loop:symlink"},
+		{"dereference skip hidden", ReadDirOptions{Dereference: true, SkipHidden: true},
+			"broken:symlink dir:dir dirlink:dir file:file link:file loop:symlink"},
+		{"files", ReadDirOptions{Types: []string{EntryFile}},
+			".hidden:file file:file"},
+		{"dereferenced files", ReadDirOptions{Dereference: true, Types: []string{EntryFile}},
+			".hidden:file file:file link:file"},
+		{"dirs", ReadDirOptions{SkipVirtual: true, Types: []string{EntryDir}},
+			"dir:dir"},
+		{"dereferenced dirs", ReadDirOptions{Dereference: true, SkipVirtual: true, Types: []string{EntryDir}},
+			"dir:dir dirlink:dir"},
+		{"symlinks", ReadDirOptions{Types: []string{EntrySymlink}},
+			"broken:symlink dirlink:symlink link:symlink loop:symlink"},
+		{"dereferenced symlinks", ReadDirOptions{Dereference: true, Types: []string{EntrySymlink}},
+			"broken:symlink loop:symlink"},
+		{"everything filtered", ReadDirOptions{Dereference: true, SkipHidden: true, SkipVirtual: true, Types: []string{EntryFile, EntryDir}},
+			"dir:dir dirlink:dir file:file link:file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			infos, err := mfs.ReadDirWithOptions("/mem", tt.opts)
+			if err != nil {
+				t.Fatalf("ReadDirWithOptions failed: %v", err)
+			}
+			var got []string
+			for _, info := range infos {
+				got = append(got, info.Name+":"+entryType(info))
+
+				broken := info.Meta.Content[MetaKeyBrokenLink] == "true"
+				if wantBroken := tt.opts.Dereference && (info.Name == "broken" || info.Name == "loop"); broken != wantBroken {
+					t.Errorf("Expected %s broken=%v, got %+v", info.Name, wantBroken, info)
+				}
+				if !tt.opts.Dereference && info.Meta.Content[MetaKeyLinkTarget] != "" {
+					t.Errorf("Expected no link target on %s without Dereference, got %+v", info.Name, info)
+				}
+			}
+			sort.Strings(got)
+			if strings.Join(got, " ") != tt.want {
+				t.Errorf("Expected %q, got %q", tt.want, strings.Join(got, " "))
+			}
+		})
+	}
+
+	infos, err := mfs.ReadDirWithOptions("/mem", ReadDirOptions{Dereference: true})
+	if err != nil {
+		t.Fatalf("ReadDirWithOptions failed: %v", err)
+	}
+	byName := make(map[string]int)
+	for i, info := range infos {
+		byName[info.Name] = i
+	}
+	if link := infos[byName["link"]]; link.Size != 5 || link.Meta.Content[MetaKeyLinkTarget] != "/mem/file" {
+		t.Errorf("Expected link with the attributes of its target, got %+v", link)
+	}
+	if broken := infos[byName["broken"]]; broken.Meta.Content[MetaKeyLinkTarget] != "/mem/missing" {
+		t.Errorf("Expected the broken link to keep its target, got %+v", broken)
+	}
+
+	// Dereferencing leaves what ReadDir reports untouched
+	plain, err := mfs.ReadDir("/mem")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, info := range plain {
+		if info.Meta.Content[MetaKeyBrokenLink] != "" || info.Meta.Content[MetaKeyLinkTarget] != "" {
+			t.Errorf("Expected ReadDir entries without dereference keys, got %+v", info)
+		}
+	}
+
+	if _, err := mfs.ReadDirWithOptions("/mem/missing", ReadDirOptions{Dereference: true}); err == nil {
+		t.Error("Expected listing a missing directory to fail")
+	}
+}
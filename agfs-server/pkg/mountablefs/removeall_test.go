@@ -0,0 +1,95 @@
+package mountablefs
+
+import (
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+// TestRemoveAllRecursive checks that RemoveAll removes a multi-level tree and
+// the symlinks in it without following them
+func TestRemoveAllRecursive(t *testing.T) {
+	for name, newPlugin := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			if err := mfs.Mount("/mnt", newPlugin(t)); err != nil {
+				t.Fatalf("Failed to mount: %v", err)
+			}
+
+			for _, dir := range []string{"/mnt/t/a/b/c", "/mnt/t/d", "/mnt/keep"} {
+				if err := mfs.MkdirAll(dir, 0755); err != nil {
+					t.Fatalf("MkdirAll %s failed: %v", dir, err)
+				}
+			}
+			for _, file := range []string{"/mnt/t/top", "/mnt/t/a/b/mid", "/mnt/t/a/b/c/leaf", "/mnt/keep/file"} {
+				if _, err := mfs.Write(file, []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+					t.Fatalf("Write %s failed: %v", file, err)
+				}
+			}
+			// Links out of the tree, to a file and a directory, must not be followed
+			if err := mfs.Symlink("/mnt/keep", "/mnt/t/a/dirlink"); err != nil {
+				t.Fatalf("Symlink failed: %v", err)
+			}
+			if err := mfs.Symlink("/mnt/keep/file", "/mnt/t/a/b/c/filelink"); err != nil {
+				t.Fatalf("Symlink failed: %v", err)
+			}
+
+			if err := mfs.RemoveAll("/mnt/t"); err != nil {
+				t.Fatalf("RemoveAll failed: %v", err)
+			}
+
+			for _, p := range []string{"/mnt/t", "/mnt/t/a/b/c/leaf", "/mnt/t/d"} {
+				if _, err := mfs.Stat(p); err == nil {
+					t.Errorf("Expected %s to be removed", p)
+				}
+			}
+			for _, link := range []string{"/mnt/t/a/dirlink", "/mnt/t/a/b/c/filelink"} {
+				if _, err := mfs.Readlink(link); err == nil {
+					t.Errorf("Expected symlink %s to be removed", link)
+				}
+			}
+			if data, err := mfs.Read("/mnt/keep/file", 0, -1); (err != nil && err != io.EOF) || string(data) != "data" {
+				t.Errorf("Expected symlink target to survive, got %q, %v", data, err)
+			}
+
+			// The tree can be recreated without stale symlinks in the way
+			if err := mfs.MkdirAll("/mnt/t/a", 0755); err != nil {
+				t.Fatalf("MkdirAll after RemoveAll failed: %v", err)
+			}
+			if err := mfs.Symlink("/mnt/keep", "/mnt/t/a/dirlink"); err != nil {
+				t.Errorf("Expected symlink to be recreatable, got %v", err)
+			}
+		})
+	}
+}
+
+// TestRemoveAllSymlink checks that RemoveAll on a symlink removes only the link
+func TestRemoveAllSymlink(t *testing.T) {
+	for name, newPlugin := range testBackends() {
+		t.Run(name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			if err := mfs.Mount("/mnt", newPlugin(t)); err != nil {
+				t.Fatalf("Failed to mount: %v", err)
+			}
+
+			if err := mfs.MkdirAll("/mnt/dir/sub", 0755); err != nil {
+				t.Fatalf("MkdirAll failed: %v", err)
+			}
+			if err := mfs.Symlink("/mnt/dir", "/mnt/link"); err != nil {
+				t.Fatalf("Symlink failed: %v", err)
+			}
+
+			if err := mfs.RemoveAll("/mnt/link"); err != nil {
+				t.Fatalf("RemoveAll failed: %v", err)
+			}
+			if _, err := mfs.Readlink("/mnt/link"); err == nil {
+				t.Error("Expected symlink to be removed")
+			}
+			if info, err := mfs.Stat("/mnt/dir/sub"); err != nil || !info.IsDir {
+				t.Errorf("Expected symlink target to survive, got %+v, %v", info, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package mountablefs
+
+import (
+	"io"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// SnapshotDirName is the virtual directory through which the snapshots of a
+// directory are read, as <dir>/.snapshots/<name>, on mounts whose plugin
+// declares plugin.CapabilitySnapshot. It isn't listed in its directory, and
+// nothing under it can be written.
+const SnapshotDirName = ".snapshots"
+
+// snapshotRef is a path in the .snapshots directory of a directory
+type snapshotRef struct {
+	mount *MountPoint
+	dir   string // The directory, in the mount
+	name  string // The snapshot, empty for .snapshots itself
+	rest  string // The path in the snapshot
+}
+
+// snapshotPath reports whether path, with symlinks resolved, is in the
+// .snapshots directory of a directory of a mount that can take snapshots
+func (mfs *MountableFS) snapshotPath(path string) (*snapshotRef, bool) {
+	mount, relPath, found := mfs.findMount(path)
+	if !found || !mount.capabilities().Has(plugin.CapabilitySnapshot) {
+		return nil, false
+	}
+	parts := strings.Split(strings.Trim(relPath, "/"), "/")
+	for i, part := range parts {
+		if part != SnapshotDirName {
+			continue
+		}
+		ref := &snapshotRef{mount: mount, dir: "/" + strings.Join(parts[:i], "/"), rest: "/"}
+		if i+1 < len(parts) {
+			ref.name = parts[i+1]
+		}
+		if i+2 < len(parts) {
+			ref.rest = "/" + strings.Join(parts[i+2:], "/")
+		}
+		return ref, true
+	}
+	return nil, false
+}
+
+// snapshotter returns the plugin file system of m as a Snapshotter, failing
+// op on path with a NotSupportedError if it can't take snapshots
+func (m *MountPoint) snapshotter(op, path string) (filesystem.Snapshotter, error) {
+	if err := m.require(plugin.CapabilitySnapshot, op, path); err != nil {
+		return nil, err
+	}
+	s, ok := m.Plugin.GetFileSystem().(filesystem.Snapshotter)
+	if !ok {
+		return nil, filesystem.NewNotSupportedError(op, path)
+	}
+	return s, nil
+}
+
+// Snapshot takes a snapshot named name of the directory at path, which can
+// then be read under its .snapshots directory. Plugins that can't take
+// snapshots fail with a NotSupportedError.
+func (mfs *MountableFS) Snapshot(path, name string) error {
+	if name == "" || name == "." || name == ".." || strings.Contains(name, "/") {
+		return filesystem.NewInvalidArgumentError("name", name, "must be a single path component")
+	}
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return err
+	}
+	if err := mfs.reserved("snapshot", resolved); err != nil {
+		return err
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return filesystem.NewNotFoundError("snapshot", path)
+	}
+	s, err := mount.snapshotter("snapshot", path)
+	if err != nil {
+		return err
+	}
+	return s.Snapshot(relPath, name)
+}
+
+// ListSnapshots returns the snapshots of the directory at path. Plugins that
+// can't take snapshots fail with a NotSupportedError.
+func (mfs *MountableFS) ListSnapshots(path string) ([]filesystem.SnapshotInfo, error) {
+	resolved, err := mfs.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+	mount, relPath, found := mfs.findMount(resolved)
+	if !found {
+		return nil, filesystem.NewNotFoundError("listsnapshots", path)
+	}
+	s, err := mount.snapshotter("listsnapshots", path)
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := s.ListSnapshots(relPath)
+	if err != nil {
+		return nil, err
+	}
+	// Report the directories as they are addressed through mfs
+	for i := range snapshots {
+		snapshots[i].Path = filesystem.NormalizePath(mount.Path + snapshots[i].Path)
+	}
+	return snapshots, nil
+}
+
+// fs returns the file system of the snapshot ref is in
+func (ref *snapshotRef) fs(path string) (filesystem.FileSystem, error) {
+	s, err := ref.mount.snapshotter("snapshot", path)
+	if err != nil {
+		return nil, err
+	}
+	return s.SnapshotFS(ref.dir, ref.name)
+}
+
+// fileInfo adjusts file info of the snapshot as the mount does for live
+// files. Inode numbers are dropped, as a snapshot reports those of the
+// files it was taken of.
+func (ref *snapshotRef) fileInfo(info *filesystem.FileInfo) {
+	info.Ino = 0
+	ref.mount.fileInfo(info)
+}
+
+// snapshotStat returns the attributes of the path ref is
+func snapshotStat(ref *snapshotRef, path string) (*filesystem.FileInfo, error) {
+	if ref.name == "" {
+		if _, err := snapshotReadDir(ref, path); err != nil {
+			return nil, err
+		}
+		return &filesystem.FileInfo{
+			Name:  SnapshotDirName,
+			Mode:  0555,
+			IsDir: true,
+			Meta:  filesystem.MetaData{Type: MetaValueVirtual},
+		}, nil
+	}
+	fs, err := ref.fs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := fs.Stat(ref.rest)
+	if err != nil {
+		return nil, err
+	}
+	ref.fileInfo(info)
+	if ref.rest == "/" {
+		info.Name = ref.name
+	}
+	return info, nil
+}
+
+// snapshotReadDir lists the path ref is: the snapshots of the directory for
+// .snapshots itself
+func snapshotReadDir(ref *snapshotRef, path string) ([]filesystem.FileInfo, error) {
+	if ref.name == "" {
+		s, err := ref.mount.snapshotter("readdir", path)
+		if err != nil {
+			return nil, err
+		}
+		snapshots, err := s.ListSnapshots(ref.dir)
+		if err != nil {
+			return nil, err
+		}
+		infos := make([]filesystem.FileInfo, 0, len(snapshots))
+		for _, snapshot := range snapshots {
+			infos = append(infos, filesystem.FileInfo{
+				Name:    snapshot.Name,
+				Mode:    0555,
+				ModTime: snapshot.Created,
+				IsDir:   true,
+				Meta:    filesystem.MetaData{Type: MetaValueVirtual},
+			})
+		}
+		return infos, nil
+	}
+	fs, err := ref.fs(path)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := fs.ReadDir(ref.rest)
+	if err != nil {
+		return nil, err
+	}
+	for i := range infos {
+		ref.fileInfo(&infos[i])
+	}
+	return infos, nil
+}
+
+// snapshotRead reads the file ref is
+func snapshotRead(ref *snapshotRef, path string, offset, size int64) ([]byte, error) {
+	if ref.name == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	fs, err := ref.fs(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Read(ref.rest, offset, size)
+}
+
+// snapshotOpen opens the file ref is for reading
+func snapshotOpen(ref *snapshotRef, path string) (io.ReadCloser, error) {
+	if ref.name == "" {
+		return nil, filesystem.NewInvalidArgumentError("path", path, "is a directory")
+	}
+	fs, err := ref.fs(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.Open(ref.rest)
+}
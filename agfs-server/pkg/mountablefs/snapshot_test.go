@@ -0,0 +1,123 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+func TestSnapshot(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/mnt", testBackends()["memfs"](t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.MkdirAll("/mnt/d/sub", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	for file, data := range map[string]string{"/mnt/d/f": "v1", "/mnt/d/sub/g": "kept"} {
+		if _, err := mfs.Write(file, []byte(data), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", file, err)
+		}
+	}
+
+	if err := mfs.Snapshot("/mnt/d", "s1"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+
+	// Change the live tree every way the snapshot could notice
+	if _, err := mfs.Write("/mnt/d/f", []byte("v2"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := mfs.RemoveAll("/mnt/d/sub"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	if err := mfs.Create("/mnt/d/new"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	read := func(path string) string {
+		t.Helper()
+		data, err := mfs.Read(path, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read %s failed: %v", path, err)
+		}
+		return string(data)
+	}
+	if got := read("/mnt/d/.snapshots/s1/f"); got != "v1" {
+		t.Errorf("Expected the snapshot to keep %q, got %q", "v1", got)
+	}
+	if got := read("/mnt/d/.snapshots/s1/sub/g"); got != "kept" {
+		t.Errorf("Expected the snapshot to keep the removed file, got %q", got)
+	}
+	if got := read("/mnt/d/f"); got != "v2" {
+		t.Errorf("Expected the live file to change, got %q", got)
+	}
+
+	entries, err := mfs.ReadDir("/mnt/d/.snapshots/s1")
+	if err != nil {
+		t.Fatalf("ReadDir of the snapshot failed: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "f" || names[1] != "sub" {
+		t.Errorf("Expected the snapshot to hold f and sub, got %v", names)
+	}
+	if info, err := mfs.Stat("/mnt/d/.snapshots/s1"); err != nil || !info.IsDir || info.Name != "s1" {
+		t.Errorf("Expected the snapshot to be a directory named s1, got %+v (%v)", info, err)
+	}
+
+	snapshots, err := mfs.ListSnapshots("/mnt/d")
+	if err != nil || len(snapshots) != 1 || snapshots[0].Name != "s1" || snapshots[0].Path != "/mnt/d" {
+		t.Errorf("Expected snapshot s1 of /mnt/d, got %+v (%v)", snapshots, err)
+	}
+	if entries, err := mfs.ReadDir("/mnt/d/.snapshots"); err != nil || len(entries) != 1 || entries[0].Name != "s1" {
+		t.Errorf("Expected .snapshots to list s1, got %+v (%v)", entries, err)
+	}
+	// .snapshots isn't listed with the live files
+	if entries, _ := mfs.ReadDir("/mnt/d"); len(entries) != 2 {
+		t.Errorf("Expected f and new in /mnt/d, got %+v", entries)
+	}
+
+	// Snapshots are read-only
+	if _, err := mfs.Write("/mnt/d/.snapshots/s1/f", []byte("x"), 0, filesystem.WriteFlagNone); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Expected writing a snapshot to be denied, got %v", err)
+	}
+	if err := mfs.Remove("/mnt/d/.snapshots/s1/f"); !errors.Is(err, filesystem.ErrPermissionDenied) {
+		t.Errorf("Expected removing from a snapshot to be denied, got %v", err)
+	}
+
+	if err := mfs.Snapshot("/mnt/d", "s1"); !errors.Is(err, filesystem.ErrAlreadyExists) {
+		t.Errorf("Expected a second s1 to fail with ErrAlreadyExists, got %v", err)
+	}
+	if err := mfs.Snapshot("/mnt/d", "a/b"); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Expected a name with a slash to fail with ErrInvalidArgument, got %v", err)
+	}
+	if _, err := mfs.Read("/mnt/d/.snapshots/missing/f", 0, -1); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound for a missing snapshot, got %v", err)
+	}
+}
+
+func TestSnapshotNotSupported(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/mnt", testBackends()["localfs"](t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.Snapshot("/mnt", "s1"); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+	if _, err := mfs.ListSnapshots("/mnt"); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+
+	// Without snapshots, .snapshots is an ordinary name
+	if err := mfs.Mkdir("/mnt/.snapshots", 0755); err != nil {
+		t.Errorf("Expected .snapshots to be created, got %v", err)
+	}
+}
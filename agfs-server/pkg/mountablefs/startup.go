@@ -0,0 +1,173 @@
+package mountablefs
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// PlannedMount is a plugin for MountAll to initialize and mount
+type PlannedMount struct {
+	Path    string
+	Plugin  plugin.ServicePlugin
+	Config  map[string]interface{}
+	Options MountOptions
+	// DependsOn are the mount paths whose plugins must be mounted before
+	// this one is initialized, either planned alongside it or mounted already
+	DependsOn []string
+}
+
+// DependencyCycleError is reported by MountAll for mounts that depend on
+// themselves through others
+type DependencyCycleError struct {
+	Cycle []string // Mount paths, starting and ending with the same one
+}
+
+func (e *DependencyCycleError) Error() string {
+	return "mount dependency cycle: " + strings.Join(e.Cycle, " -> ")
+}
+
+// MountAll initializes and mounts planned, each mount once the mounts it
+// depends on are up and the others concurrently, calling report with the
+// outcome of each. A plugin implementing
+//
+//	SetDependencies(map[string]filesystem.FileSystem)
+//
+// is given the file systems of its dependencies by mount path before its
+// Initialize. A mount whose dependency failed fails too, as do the mounts of
+// a dependency cycle, with a DependencyCycleError naming it. MountAll
+// returns once every mount is done.
+func (mfs *MountableFS) MountAll(planned []PlannedMount, report func(PlannedMount, error)) {
+	index, failed := mfs.checkDependencies(planned)
+
+	done := make([]chan struct{}, len(planned))
+	errs := make([]error, len(planned))
+	for i := range planned {
+		done[i] = make(chan struct{})
+	}
+
+	var wg sync.WaitGroup
+	for i := range planned {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := failed[i]
+			if err == nil {
+				err = mfs.mountPlanned(planned[i], func(dep string) error {
+					j, ok := index[dep]
+					if !ok {
+						return nil // Mounted already
+					}
+					<-done[j]
+					if errs[j] != nil {
+						return fmt.Errorf("dependency %s failed to mount", dep)
+					}
+					return nil
+				})
+			}
+			errs[i] = err
+			close(done[i])
+			report(planned[i], err)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// mountPlanned waits for the dependencies of m, then initializes and mounts it
+func (mfs *MountableFS) mountPlanned(m PlannedMount, wait func(dep string) error) error {
+	deps := make(map[string]filesystem.FileSystem, len(m.DependsOn))
+	for _, dep := range m.DependsOn {
+		dep = filesystem.NormalizePath(dep)
+		if err := wait(dep); err != nil {
+			return err
+		}
+		v, ok := mfs.routes().Get([]byte(dep))
+		if !ok {
+			return fmt.Errorf("dependency %s is not mounted", dep)
+		}
+		deps[dep] = v.(*MountPoint).Plugin.GetFileSystem()
+	}
+
+	type dependencySetter interface {
+		SetDependencies(map[string]filesystem.FileSystem)
+	}
+	if setter, ok := m.Plugin.(dependencySetter); ok {
+		setter.SetDependencies(deps)
+	}
+
+	configWithPath := make(map[string]interface{}, len(m.Config)+1)
+	for k, v := range m.Config {
+		configWithPath[k] = v
+	}
+	configWithPath["mount_path"] = m.Path
+	if err := plugin.Initialize(m.Plugin, configWithPath); err != nil {
+		return err
+	}
+	return mfs.MountWithOptions(m.Path, m.Plugin, m.Options)
+}
+
+// checkDependencies returns the index of each planned mount by path, and
+// the error of each, by index, that can't be mounted: its path is planned
+// twice, or it depends on a path neither planned nor mounted or on itself
+func (mfs *MountableFS) checkDependencies(planned []PlannedMount) (map[string]int, map[int]error) {
+	index := make(map[string]int, len(planned))
+	failed := make(map[int]error)
+	for i, m := range planned {
+		path := filesystem.NormalizePath(m.Path)
+		if _, dup := index[path]; dup {
+			failed[i] = filesystem.NewAlreadyExistsError("mount", path)
+			continue
+		}
+		index[path] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(planned))
+	var stack []string
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		stack = append(stack, filesystem.NormalizePath(planned[i].Path))
+		for _, dep := range planned[i].DependsOn {
+			dep = filesystem.NormalizePath(dep)
+			j, ok := index[dep]
+			if !ok {
+				if _, mounted := mfs.routes().Get([]byte(dep)); !mounted {
+					failed[i] = fmt.Errorf("depends on %s, which is neither mounted nor configured", dep)
+				}
+				continue
+			}
+			switch state[j] {
+			case visiting:
+				// Every mount on the stack from dep on is in the cycle
+				start := len(stack) - 1
+				for stack[start] != dep {
+					start--
+				}
+				cycle := append(append([]string{}, stack[start:]...), dep)
+				for _, p := range cycle {
+					if _, ok := failed[index[p]]; !ok {
+						failed[index[p]] = &DependencyCycleError{Cycle: cycle}
+					}
+				}
+			case unvisited:
+				visit(j)
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+	}
+	for i, m := range planned {
+		if state[i] == unvisited && index[filesystem.NormalizePath(m.Path)] == i {
+			visit(i)
+		}
+	}
+	return index, failed
+}
@@ -0,0 +1,128 @@
+package mountablefs
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// layeredPlugin is a memfs recording the order plugins are initialized in
+// and the dependencies it was given
+type layeredPlugin struct {
+	*memfs.MemFSPlugin
+	path  string
+	order *[]string
+	mu    *sync.Mutex
+	deps  map[string]filesystem.FileSystem
+}
+
+func (p *layeredPlugin) SetDependencies(deps map[string]filesystem.FileSystem) {
+	p.deps = deps
+}
+
+func (p *layeredPlugin) Initialize(cfg map[string]interface{}) error {
+	p.mu.Lock()
+	*p.order = append(*p.order, p.path)
+	p.mu.Unlock()
+	return p.MemFSPlugin.Initialize(cfg)
+}
+
+func planLayers(deps map[string][]string) ([]PlannedMount, map[string]*layeredPlugin, *[]string) {
+	order := &[]string{}
+	mu := &sync.Mutex{}
+	plugins := make(map[string]*layeredPlugin)
+	paths := make([]string, 0, len(deps))
+	for p := range deps {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var planned []PlannedMount
+	for _, p := range paths {
+		plugins[p] = &layeredPlugin{MemFSPlugin: memfs.NewMemFSPlugin(), path: p, order: order, mu: mu}
+		planned = append(planned, PlannedMount{Path: p, Plugin: plugins[p], DependsOn: deps[p]})
+	}
+	return planned, plugins, order
+}
+
+func TestMountAllDependencyOrder(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	// /a on top of /b on top of /c, listed the other way round
+	planned, plugins, order := planLayers(map[string][]string{
+		"/a": {"/b"},
+		"/b": {"/c"},
+		"/c": nil,
+		"/d": {"/c"},
+	})
+
+	var mu sync.Mutex
+	failed := make(map[string]error)
+	mfs.MountAll(planned, func(m PlannedMount, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			failed[m.Path] = err
+		}
+	})
+	if len(failed) > 0 {
+		t.Fatalf("Expected every mount to come up, got %v", failed)
+	}
+
+	pos := make(map[string]int)
+	for i, p := range *order {
+		pos[p] = i
+	}
+	if len(pos) != 4 || pos["/c"] > pos["/b"] || pos["/b"] > pos["/a"] || pos["/c"] > pos["/d"] {
+		t.Errorf("Expected dependencies to be initialized first, got %v", *order)
+	}
+	if fs := plugins["/a"].deps["/b"]; fs == nil || fs != plugins["/b"].GetFileSystem() || len(plugins["/a"].deps) != 1 {
+		t.Errorf("Expected /a to be given the file system of /b, got %v", plugins["/a"].deps)
+	}
+	if len(mfs.GetMounts()) != 4 {
+		t.Errorf("Expected 4 mounts, got %d", len(mfs.GetMounts()))
+	}
+}
+
+func TestMountAllDependencyCycle(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	planned, _, order := planLayers(map[string][]string{
+		"/a": {"/b"},
+		"/b": {"/c"},
+		"/c": {"/a"},
+		"/d": {"/a"},
+		"/e": nil,
+		"/f": {"/missing"},
+	})
+
+	var mu sync.Mutex
+	errs := make(map[string]error)
+	mfs.MountAll(planned, func(m PlannedMount, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs[m.Path] = err
+	})
+
+	for _, p := range []string{"/a", "/b", "/c"} {
+		var cycleErr *DependencyCycleError
+		if !errors.As(errs[p], &cycleErr) || len(cycleErr.Cycle) != 4 || cycleErr.Cycle[0] != cycleErr.Cycle[3] {
+			t.Errorf("Expected %s to fail with the cycle, got %v", p, errs[p])
+		}
+	}
+	if want := "mount dependency cycle: /a -> /b -> /c -> /a"; errs["/a"] == nil || errs["/a"].Error() != want {
+		t.Errorf("Expected %q, got %v", want, errs["/a"])
+	}
+	if errs["/d"] == nil || errs["/f"] == nil {
+		t.Errorf("Expected the dependents of the cycle and of a missing mount to fail, got %v and %v", errs["/d"], errs["/f"])
+	}
+	if errs["/e"] != nil {
+		t.Errorf("Expected /e to mount, got %v", errs["/e"])
+	}
+	if !reflect.DeepEqual(*order, []string{"/e"}) {
+		t.Errorf("Expected only /e to be initialized, got %v", *order)
+	}
+}
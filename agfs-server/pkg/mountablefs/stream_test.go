@@ -0,0 +1,109 @@
+package mountablefs
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func TestOpenWriteNative(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	plugin := memfs.NewMemFSPlugin()
+	if err := plugin.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	if err := mfs.Mount("/mem", plugin); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.Symlink("/mem/data.txt", "/link.txt"); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	w, err := mfs.OpenWrite("/link.txt")
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	io.WriteString(w, "hello ")
+	io.WriteString(w, "world")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := mfs.Open("/mem/data.txt")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if string(data) != "hello world" {
+		t.Errorf("Expected %q, got %q", "hello world", data)
+	}
+}
+
+func TestOpenWriteFallback(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	mock := NewMockServicePlugin("mock")
+	if err := mfs.Mount("/mock", mock); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+
+	// MockFS only implements Read and Write
+	content := bytes.Repeat([]byte("0123456789abcdef"), (fallbackReadChunkSize*5/2)/16)
+	w, err := mfs.OpenWrite("/mock/big.bin")
+	if err != nil {
+		t.Fatalf("OpenWrite failed: %v", err)
+	}
+	if _, err := io.Copy(w, bytes.NewReader(content)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := mfs.Stat("/mock/big.bin"); err == nil {
+		t.Error("Expected buffered write not to reach the plugin before Close")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := mfs.Open("/mock/big.bin")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(data, content) {
+		t.Errorf("Expected %d bytes back, got %d", len(content), len(data))
+	}
+	// 2.5 chunks are read with 3 calls
+	if reads := mock.fs.reads.Load(); reads != 3 {
+		t.Errorf("Expected 3 chunked reads, got %d", reads)
+	}
+}
+
+func TestOpenFallbackErrors(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	mock := NewMockServicePlugin("mock")
+	if err := mfs.Mount("/mock", mock); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mock.fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	if _, err := mfs.Open("/mock/missing"); err == nil {
+		t.Error("Expected error opening a missing file")
+	}
+	if _, err := mfs.Open("/mock/dir"); err == nil {
+		t.Error("Expected error opening a directory")
+	}
+	if _, err := mfs.Open("/nowhere/file"); err == nil {
+		t.Error("Expected error opening a path outside any mount")
+	}
+}
@@ -0,0 +1,113 @@
+package mountablefs
+
+import (
+	"context"
+	"path"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultSymlinkCompactInterval is how often RunSymlinkCompaction compacts
+// the symlinks unless told otherwise
+const DefaultSymlinkCompactInterval = 10 * time.Minute
+
+// CompactSymlinks drops the symlinks of every namespace whose directory no
+// longer exists because the mount it was in is gone, and returns how many it
+// dropped. A symlink stays while its directory is the root, a live mount or
+// a directory leading to one; where it points doesn't matter, so dangling
+// links the user made are kept. Unmount compacts the symlinks itself, so
+// this only collects links orphaned some other way.
+func (mfs *MountableFS) CompactSymlinks() int {
+	views := []*MountableFS{mfs.Namespace("")}
+	mfs.namespacesMu.Lock()
+	for principal := range mfs.namespaces {
+		views = append(views, &MountableFS{mountState: mfs.mountState, ns: mfs.namespaces[principal]})
+	}
+	mfs.namespacesMu.Unlock()
+
+	removed := 0
+	for _, view := range views {
+		removed += view.compactLinks()
+	}
+	if removed > 0 {
+		log.Infof("Compacted %d orphaned symlink(s)", removed)
+	}
+	return removed
+}
+
+// compactLinks drops the orphaned symlinks of the view's namespace
+func (mfs *MountableFS) compactLinks() int {
+	mfs.symlinksMu.RLock()
+	links := make(map[string]string, len(mfs.links()))
+	for linkPath, target := range mfs.links() {
+		links[linkPath] = target
+	}
+	mfs.symlinksMu.RUnlock()
+
+	// Resolving takes symlinksMu, so the orphans are found without it
+	var orphans []string
+	for linkPath := range links {
+		if mfs.linkOrphaned(linkPath) {
+			orphans = append(orphans, linkPath)
+		}
+	}
+
+	var removed []string
+	mfs.symlinksMu.Lock()
+	for _, linkPath := range orphans {
+		// Skip links replaced meanwhile
+		if target, ok := mfs.links()[linkPath]; ok && target == links[linkPath] {
+			delete(mfs.links(), linkPath)
+			removed = append(removed, linkPath)
+		}
+	}
+	mfs.symlinksMu.Unlock()
+
+	for _, linkPath := range removed {
+		mfs.changes.notify(mfs.ns, "remove", linkPath)
+	}
+	return len(removed)
+}
+
+// linkOrphaned reports whether the directory of the symlink at linkPath is
+// neither the root, in a mount, nor a directory leading to one
+func (mfs *MountableFS) linkOrphaned(linkPath string) bool {
+	dir := path.Dir(linkPath)
+	if dir == "/" {
+		return false
+	}
+	resolved, err := mfs.resolvePath(dir)
+	if err != nil {
+		// A symlink loop isn't evidence of a gone mount
+		return false
+	}
+	if _, _, found := mfs.findMount(resolved); found {
+		return false
+	}
+	leads := false
+	mfs.routes().Root().WalkPrefix([]byte(strings.TrimSuffix(resolved, "/")+"/"), func(k []byte, v interface{}) bool {
+		leads = true
+		return true
+	})
+	return !leads
+}
+
+// RunSymlinkCompaction calls CompactSymlinks every interval (0 =
+// DefaultSymlinkCompactInterval) until ctx is done
+func (mfs *MountableFS) RunSymlinkCompaction(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSymlinkCompactInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			mfs.CompactSymlinks()
+		}
+	}
+}
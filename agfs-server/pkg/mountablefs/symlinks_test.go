@@ -0,0 +1,64 @@
+package mountablefs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+func TestCompactSymlinks(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	for _, p := range []string{"/a", "/b", "/deep/c"} {
+		if err := mfs.Mount(p, NewMockServicePlugin("mock")); err != nil {
+			t.Fatalf("Mount %s failed: %v", p, err)
+		}
+	}
+	alice := mfs.Namespace("alice")
+	for _, link := range []struct {
+		fs         *MountableFS
+		target, at string
+	}{
+		{mfs, "/b", "/a/l1"},
+		{mfs, "/a", "/b/l2"},
+		{mfs, "/a", "/l3"},
+		{mfs, "/a", "/deep/l4"},
+		{alice, "/b", "/a/l5"},
+	} {
+		if err := link.fs.Symlink(link.target, link.at); err != nil {
+			t.Fatalf("Symlink %s failed: %v", link.at, err)
+		}
+	}
+
+	// Unmounting drops the links that were in the mount, in every namespace
+	if err := mfs.Unmount("/a"); err != nil {
+		t.Fatalf("Unmount failed: %v", err)
+	}
+	for _, gone := range []struct {
+		fs   *MountableFS
+		link string
+	}{{mfs, "/a/l1"}, {alice, "/a/l5"}} {
+		if _, err := gone.fs.Readlink(gone.link); err == nil {
+			t.Errorf("Expected %s collected with its mount", gone.link)
+		}
+	}
+	// Links elsewhere survive, even dangling ones
+	for _, kept := range []string{"/b/l2", "/l3", "/deep/l4"} {
+		if _, err := mfs.Readlink(kept); err != nil {
+			t.Errorf("Expected %s kept, got %v", kept, err)
+		}
+	}
+
+	// Links orphaned without Unmount are collected by a compaction
+	if err := mfs.unmount("/b"); err != nil {
+		t.Fatalf("unmount failed: %v", err)
+	}
+	if n := mfs.CompactSymlinks(); n != 1 {
+		t.Errorf("Expected 1 link compacted, got %d", n)
+	}
+	if _, err := mfs.Readlink("/b/l2"); err == nil {
+		t.Error("Expected /b/l2 collected")
+	}
+	if n := mfs.CompactSymlinks(); n != 0 {
+		t.Errorf("Expected nothing left to compact, got %d", n)
+	}
+}
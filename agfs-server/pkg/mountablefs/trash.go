@@ -0,0 +1,204 @@
+package mountablefs
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	log "github.com/sirupsen/logrus"
+)
+
+// TrashDirName is the directory at the root of a mount with trash enabled
+// that Remove and RemoveAll move files to. Each removal gets an entry named
+// after the time of the removal, such as "1700000000000000000-1", under
+// which the removed file keeps its path in the mount.
+const TrashDirName = ".agfs-trash"
+
+// DefaultTrashRetention is how long removed files stay in the trash of a
+// mount whose options don't say
+const DefaultTrashRetention = 7 * 24 * time.Hour
+
+// trashDirMode is the mode of the directories created in the trash, so only
+// the owner sees what was removed
+const trashDirMode = 0700
+
+// ParseTrashRetention parses how long a mount keeps removed files, given as
+// a duration such as "72h" as configuration files and mount requests do.
+// An empty string leaves the default.
+func ParseTrashRetention(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, filesystem.NewInvalidArgumentError("trash_retention", s, "must be a positive duration such as 72h")
+	}
+	return d, nil
+}
+
+// validate checks that a plugin declaring caps can be mounted at path with
+// the options. Trash moves files with Rename, so plugins that can't rename
+// decline it rather than deleting files the caller expects to get back.
+func (o MountOptions) validate(caps plugin.CapabilitySet, path string) error {
+	if o.Trash && !caps.Has(plugin.CapabilityRename) {
+		return filesystem.NewNotSupportedError("trash", path)
+	}
+	return nil
+}
+
+// retention returns how long the mount keeps removed files
+func (m *MountPoint) retention() time.Duration {
+	if m.Options.TrashRetention > 0 {
+		return m.Options.TrashRetention
+	}
+	return DefaultTrashRetention
+}
+
+// inTrash reports whether relPath is the trash of its mount or below it.
+// Removing files there deletes them for good.
+func inTrash(relPath string) bool {
+	rest, ok := strings.CutPrefix(relPath, "/"+TrashDirName)
+	return ok && (rest == "" || rest[0] == '/')
+}
+
+// removeToTrash moves relPath of mount to a new trash entry instead of
+// removing it, failing like Remove on a directory that isn't empty unless
+// all is set. Removing the root of the mount moves each file in it, except
+// the trash itself.
+func (mfs *MountableFS) removeToTrash(mount *MountPoint, relPath string, all bool) error {
+	fs := mfs.pluginFS(mount)
+	info, err := fs.Stat(relPath)
+	if err != nil {
+		return err
+	}
+
+	var entries []filesystem.FileInfo
+	if info.IsDir {
+		if entries, err = fs.ReadDir(relPath); err != nil {
+			return err
+		}
+	}
+	if relPath == "/" {
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			if e.Name != TrashDirName {
+				names = append(names, e.Name)
+			}
+		}
+		if len(names) > 0 && !all {
+			return fmt.Errorf("directory not empty: %s", path.Join(mount.Path, relPath))
+		}
+		for _, name := range names {
+			if err := mfs.moveToTrash(mount, "/"+name); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if len(entries) > 0 && !all {
+		return fmt.Errorf("directory not empty: %s", path.Join(mount.Path, relPath))
+	}
+	return mfs.moveToTrash(mount, relPath)
+}
+
+// moveToTrash renames relPath of mount into a new trash entry, purging the
+// entries older than the mount's retention first
+func (mfs *MountableFS) moveToTrash(mount *MountPoint, relPath string) error {
+	fs := mfs.pluginFS(mount)
+	now := mfs.now()
+	mfs.purgeTrash(fs, mount, now)
+
+	id := fmt.Sprintf("%d-%d", now.UnixNano(), mfs.trashSeq.Add(1))
+	dst := path.Join("/", TrashDirName, id, relPath)
+	if err := filesystem.MkdirAll(fs, path.Dir(dst), trashDirMode); err != nil {
+		return err
+	}
+	if err := fs.Rename(relPath, dst); err != nil {
+		fs.RemoveAll(path.Join("/", TrashDirName, id))
+		return err
+	}
+	log.Debugf("Moved %s to %s", path.Join(mount.Path, relPath), path.Join(mount.Path, dst))
+	return nil
+}
+
+// purgeTrash deletes the trash entries of mount removed longer than its
+// retention before now. Failures are logged, as they mustn't fail the
+// removal that triggered the purge.
+func (mfs *MountableFS) purgeTrash(fs filesystem.FileSystem, mount *MountPoint, now time.Time) {
+	entries, err := fs.ReadDir("/" + TrashDirName)
+	if err != nil {
+		return
+	}
+	cutoff := now.Add(-mount.retention()).UnixNano()
+	for _, e := range entries {
+		stamp, _, _ := strings.Cut(e.Name, "-")
+		removed, err := strconv.ParseInt(stamp, 10, 64)
+		if err != nil || removed > cutoff {
+			continue
+		}
+		if err := fs.RemoveAll("/" + TrashDirName + "/" + e.Name); err != nil {
+			log.Warnf("Failed to purge trash entry %s of %s: %v", e.Name, mount.Path, err)
+		}
+	}
+}
+
+// Restore moves a file or directory in the trash of a mount, given by its
+// full path such as "/mnt/.agfs-trash/1700000000000000000-1/dir/file", back
+// to where it was removed from. It fails if something exists there now.
+func (mfs *MountableFS) Restore(trashedPath string) error {
+	trashedPath = filesystem.NormalizePath(trashedPath)
+	mount, relPath, found := mfs.findMount(trashedPath)
+	if !found || !mount.Options.Trash {
+		return filesystem.NewNotFoundError("restore", trashedPath)
+	}
+	rest, ok := strings.CutPrefix(relPath, "/"+TrashDirName+"/")
+	id, orig, ok2 := strings.Cut(rest, "/")
+	if !ok || !ok2 || orig == "" {
+		return filesystem.NewInvalidArgumentError("path", trashedPath, "must be a file or directory in a trash entry")
+	}
+	orig = "/" + orig
+
+	fs := mfs.pluginFS(mount)
+	if _, err := fs.Stat(relPath); err != nil {
+		return err
+	}
+	if _, err := fs.Stat(orig); err == nil {
+		return filesystem.NewAlreadyExistsError("restore", path.Join(mount.Path, orig))
+	}
+	if err := filesystem.MkdirAll(fs, path.Dir(orig), defaultDirMode); err != nil {
+		return err
+	}
+	if err := fs.Rename(relPath, orig); err != nil {
+		return err
+	}
+
+	// Drop the directories left empty up to the entry
+	entry := path.Join("/", TrashDirName, id)
+	for dir := path.Dir(relPath); strings.HasPrefix(dir, entry); dir = path.Dir(dir) {
+		if err := fs.Remove(dir); err != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// EmptyTrash deletes everything in the trash of the mount at mountPath
+func (mfs *MountableFS) EmptyTrash(mountPath string) error {
+	mountPath = filesystem.NormalizePath(mountPath)
+	mount, relPath, found := mfs.findMount(mountPath)
+	if !found || relPath != "/" {
+		return filesystem.NewNotFoundError("emptytrash", mountPath)
+	}
+	if !mount.Options.Trash {
+		return filesystem.NewInvalidArgumentError("path", mountPath, "trash is not enabled for the mount")
+	}
+	fs := mfs.pluginFS(mount)
+	if _, err := fs.Stat("/" + TrashDirName); err != nil {
+		return nil
+	}
+	return fs.RemoveAll("/" + TrashDirName)
+}
@@ -0,0 +1,172 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+// newTrashFS returns a MountableFS with newPlugin mounted at /mnt with trash
+// enabled, and a clock the test advances
+func newTrashFS(t *testing.T, newPlugin func(t *testing.T) plugin.ServicePlugin) (*MountableFS, *time.Time) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	clock := time.Unix(1700000000, 0)
+	mfs.now = func() time.Time { return clock }
+	if err := mfs.MountWithOptions("/mnt", newPlugin(t), MountOptions{Trash: true, TrashRetention: time.Hour}); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	return mfs, &clock
+}
+
+// trashEntries returns the names of the entries in the trash of /mnt
+func trashEntries(t *testing.T, mfs *MountableFS) []string {
+	if _, err := mfs.Stat("/mnt/" + TrashDirName); err != nil {
+		return nil
+	}
+	entries, err := mfs.ReadDir("/mnt/" + TrashDirName)
+	if err != nil {
+		t.Fatalf("ReadDir of the trash failed: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	return names
+}
+
+func TestTrashRemoveAndRestore(t *testing.T) {
+	for name, newPlugin := range testBackends() {
+		if name == "mock" { // Can't rename
+			continue
+		}
+		t.Run(name, func(t *testing.T) {
+			mfs, _ := newTrashFS(t, newPlugin)
+			if err := mfs.MkdirAll("/mnt/dir/sub", 0755); err != nil {
+				t.Fatalf("MkdirAll failed: %v", err)
+			}
+			for _, file := range []string{"/mnt/file", "/mnt/dir/sub/leaf"} {
+				if _, err := mfs.Write(file, []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+					t.Fatalf("Write %s failed: %v", file, err)
+				}
+			}
+
+			if err := mfs.Remove("/mnt/dir"); err == nil {
+				t.Error("Expected Remove of a directory that isn't empty to fail")
+			}
+			if err := mfs.Remove("/mnt/file"); err != nil {
+				t.Fatalf("Remove failed: %v", err)
+			}
+			if err := mfs.RemoveAll("/mnt/dir"); err != nil {
+				t.Fatalf("RemoveAll failed: %v", err)
+			}
+			for _, path := range []string{"/mnt/file", "/mnt/dir"} {
+				if _, err := mfs.Stat(path); err == nil {
+					t.Errorf("Expected %s to be gone", path)
+				}
+			}
+
+			entries := trashEntries(t, mfs)
+			if len(entries) != 2 {
+				t.Fatalf("Expected 2 trash entries, got %v", entries)
+			}
+			trashed := "/mnt/" + TrashDirName + "/" + entries[0] + "/file"
+			if _, err := mfs.Stat(trashed); err != nil {
+				trashed = "/mnt/" + TrashDirName + "/" + entries[1] + "/file"
+			}
+			if err := mfs.Restore(trashed); err != nil {
+				t.Fatalf("Restore failed: %v", err)
+			}
+			if data, err := mfs.Read("/mnt/file", 0, -1); (err != nil && err != io.EOF) || string(data) != "data" {
+				t.Errorf("Expected the restored file to read data, got %q, %v", data, err)
+			}
+			if entries := trashEntries(t, mfs); len(entries) != 1 {
+				t.Errorf("Expected the restored entry to be dropped, got %v", entries)
+			}
+
+			// Restoring over an existing file fails
+			if _, err := mfs.Write("/mnt/file2", []byte("new"), 0, filesystem.WriteFlagCreate); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if err := mfs.Remove("/mnt/file2"); err != nil {
+				t.Fatalf("Remove failed: %v", err)
+			}
+			if _, err := mfs.Write("/mnt/file2", []byte("newer"), 0, filesystem.WriteFlagCreate); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			for _, entry := range trashEntries(t, mfs) {
+				trashed := "/mnt/" + TrashDirName + "/" + entry + "/file2"
+				if _, err := mfs.Stat(trashed); err == nil {
+					if err := mfs.Restore(trashed); !errors.Is(err, filesystem.ErrAlreadyExists) {
+						t.Errorf("Expected restoring over an existing file to fail, got %v", err)
+					}
+				}
+			}
+
+			// Removing in the trash deletes for good
+			if err := mfs.RemoveAll("/mnt/" + TrashDirName); err != nil {
+				t.Fatalf("RemoveAll of the trash failed: %v", err)
+			}
+			if entries := trashEntries(t, mfs); len(entries) != 0 {
+				t.Errorf("Expected the trash to be deleted, got %v", entries)
+			}
+		})
+	}
+}
+
+func TestTrashPurge(t *testing.T) {
+	mfs, clock := newTrashFS(t, testBackends()["memfs"])
+	for _, file := range []string{"/mnt/old", "/mnt/new"} {
+		if _, err := mfs.Write(file, []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", file, err)
+		}
+	}
+
+	if err := mfs.Remove("/mnt/old"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	*clock = clock.Add(30 * time.Minute)
+	if err := mfs.Remove("/mnt/new"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if entries := trashEntries(t, mfs); len(entries) != 2 {
+		t.Fatalf("Expected 2 trash entries within the retention, got %v", entries)
+	}
+
+	// The next removal purges the entry past the retention
+	*clock = clock.Add(31 * time.Minute)
+	if _, err := mfs.Write("/mnt/next", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := mfs.Remove("/mnt/next"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	entries := trashEntries(t, mfs)
+	if len(entries) != 2 {
+		t.Fatalf("Expected the oldest entry to be purged, got %v", entries)
+	}
+	for _, entry := range entries {
+		if _, err := mfs.Stat("/mnt/" + TrashDirName + "/" + entry + "/old"); err == nil {
+			t.Errorf("Expected old to be purged, found it in %s", entry)
+		}
+	}
+
+	if err := mfs.EmptyTrash("/mnt"); err != nil {
+		t.Fatalf("EmptyTrash failed: %v", err)
+	}
+	if entries := trashEntries(t, mfs); len(entries) != 0 {
+		t.Errorf("Expected an empty trash, got %v", entries)
+	}
+}
+
+func TestTrashRequiresRename(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	err := mfs.MountWithOptions("/mnt", newNoRenamePlugin(t), MountOptions{Trash: true})
+	if !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected trash to be declined for a plugin that can't rename, got %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package mountablefs
+
+import (
+	"path"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// BeginTx starts a transaction on the mount of path, on a plugin declaring
+// plugin.CapabilityTransactions. Its operations take paths as mfs does, all
+// on that mount, and symlinks in them aren't followed. Plugins without
+// transactions fail with a NotSupportedError, as do mounts with middleware,
+// which the transaction would bypass.
+//
+// The staged operations go straight to the plugin at Commit, so they don't
+// wait for conflicting writes as the mount's WriteConflicts says, and
+// removals aren't moved to the trash of a mount keeping one: they fail
+// with a NotSupportedError instead.
+func (mfs *MountableFS) BeginTx(path string) (filesystem.Tx, error) {
+	mount, _, found := mfs.findMount(path)
+	if !found {
+		return nil, filesystem.NewNotFoundError("begintx", path)
+	}
+	if err := mount.require(plugin.CapabilityTransactions, "begintx", path); err != nil {
+		return nil, err
+	}
+	t, ok := mount.Plugin.GetFileSystem().(filesystem.Transactor)
+	if !ok || mount.Middleware != nil {
+		return nil, filesystem.NewNotSupportedError("begintx", path)
+	}
+	tx, err := t.BeginTx()
+	if err != nil {
+		return nil, err
+	}
+	return &mountTx{mfs: mfs, mount: mount, tx: tx}, nil
+}
+
+// mountTx is a transaction of the plugin of a mount, taking paths in mfs
+type mountTx struct {
+	mfs   *MountableFS
+	mount *MountPoint
+	tx    filesystem.Tx
+
+	mu      sync.Mutex
+	changes []ChangeEvent // Reported once committed
+}
+
+// relPath returns path in the mount of the transaction, failing op if it
+// is elsewhere or the mount doesn't support c
+func (t *mountTx) relPath(c plugin.Capability, op, p string) (string, error) {
+	if err := t.mfs.reserved(op, p); err != nil {
+		return "", err
+	}
+	mount, relPath, found := t.mfs.findMount(p)
+	if !found || mount != t.mount {
+		return "", filesystem.NewInvalidArgumentError("path", p, "is not on the mount of the transaction")
+	}
+	if err := mount.require(c, op, p); err != nil {
+		return "", err
+	}
+	return relPath, nil
+}
+
+// staged records the change an operation staged makes once committed
+func (t *mountTx) staged(op string, relPaths ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, p := range relPaths {
+		t.changes = append(t.changes, ChangeEvent{Op: op, Path: path.Join(t.mount.Path, p)})
+	}
+}
+
+func (t *mountTx) Write(p string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	relPath, err := t.relPath(plugin.CapabilityWrite, "write", p)
+	if err != nil {
+		return 0, err
+	}
+	n, err := t.tx.Write(relPath, data, offset, flags)
+	if err == nil {
+		t.staged("write", relPath)
+	}
+	return n, err
+}
+
+func (t *mountTx) Remove(p string) error {
+	relPath, err := t.relPath(plugin.CapabilityRemove, "remove", p)
+	if err != nil {
+		return err
+	}
+	if t.mount.Options.Trash {
+		return filesystem.NewNotSupportedError("remove", p)
+	}
+	if err := t.tx.Remove(relPath); err != nil {
+		return err
+	}
+	t.staged("remove", relPath)
+	return nil
+}
+
+func (t *mountTx) Rename(oldPath, newPath string) error {
+	oldRelPath, err := t.relPath(plugin.CapabilityRename, "rename", oldPath)
+	if err != nil {
+		return err
+	}
+	newRelPath, err := t.relPath(plugin.CapabilityRename, "rename", newPath)
+	if err != nil {
+		return err
+	}
+	if err := t.tx.Rename(oldRelPath, newRelPath); err != nil {
+		return err
+	}
+	t.staged("rename", oldRelPath, newRelPath)
+	return nil
+}
+
+// Commit commits the plugin's transaction, then reports its changes to
+// watchers
+func (t *mountTx) Commit() error {
+	if err := t.tx.Commit(); err != nil {
+		return err
+	}
+	t.mu.Lock()
+	changes := t.changes
+	t.changes = nil
+	t.mu.Unlock()
+	for _, c := range changes {
+		t.mfs.changes.notify(t.mount.ns, c.Op, c.Path)
+	}
+	return nil
+}
+
+func (t *mountTx) Rollback() error {
+	return t.tx.Rollback()
+}
@@ -0,0 +1,194 @@
+package mountablefs
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+// txTestFS returns a MountableFS with memfs at /mnt holding /mnt/d/data and
+// /mnt/d/index
+func txTestFS(t *testing.T) *MountableFS {
+	t.Helper()
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/mnt", testBackends()["memfs"](t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if err := mfs.Mkdir("/mnt/d", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+	for file, data := range map[string]string{"/mnt/d/data": "v1", "/mnt/d/index": "data"} {
+		if _, err := mfs.Write(file, []byte(data), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", file, err)
+		}
+	}
+	return mfs
+}
+
+// txTree returns the content of the files in /mnt/d, by name
+func txTree(t *testing.T, mfs *MountableFS) map[string]string {
+	t.Helper()
+	infos, err := mfs.ReadDir("/mnt/d")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	tree := make(map[string]string)
+	for _, info := range infos {
+		if info.IsDir {
+			continue
+		}
+		data, err := mfs.Read("/mnt/d/"+info.Name, 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read %s failed: %v", info.Name, err)
+		}
+		tree[info.Name] = string(data)
+	}
+	return tree
+}
+
+func TestTxCommit(t *testing.T) {
+	mfs := txTestFS(t)
+	tx, err := mfs.BeginTx("/mnt")
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+
+	// Rename with an index update
+	if err := tx.Rename("/mnt/d/data", "/mnt/d/data.v2"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if _, err := tx.Write("/mnt/d/data.v2", []byte("v2"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := tx.Write("/mnt/d/index", []byte("data.v2"), -1, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	before := map[string]string{"data": "v1", "index": "data"}
+	if got := txTree(t, mfs); !reflect.DeepEqual(got, before) {
+		t.Errorf("Expected staged operations to change nothing, got %v", got)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	after := map[string]string{"data.v2": "v2", "index": "data.v2"}
+	if got := txTree(t, mfs); !reflect.DeepEqual(got, after) {
+		t.Errorf("Expected %v once committed, got %v", after, got)
+	}
+
+	if err := tx.Commit(); !errors.Is(err, filesystem.ErrTxDone) {
+		t.Errorf("Expected ErrTxDone committing twice, got %v", err)
+	}
+}
+
+func TestTxRollback(t *testing.T) {
+	mfs := txTestFS(t)
+	tx, err := mfs.BeginTx("/mnt")
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	if err := tx.Remove("/mnt/d/data"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if _, err := tx.Write("/mnt/d/index", []byte(""), -1, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatalf("Rollback failed: %v", err)
+	}
+
+	want := map[string]string{"data": "v1", "index": "data"}
+	if got := txTree(t, mfs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected rollback to leave %v, got %v", want, got)
+	}
+	if err := tx.Commit(); !errors.Is(err, filesystem.ErrTxDone) {
+		t.Errorf("Expected ErrTxDone committing after rollback, got %v", err)
+	}
+}
+
+func TestTxCommitFailureAppliesNothing(t *testing.T) {
+	mfs := txTestFS(t)
+	tx, err := mfs.BeginTx("/mnt")
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	if _, err := tx.Write("/mnt/d/index", []byte("gone"), -1, filesystem.WriteFlagTruncate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	// Applied before the failing removal, and undone
+	if _, err := tx.Write("/mnt/d/data", []byte("V"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := tx.Write("/mnt/d/new", []byte("new"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := tx.Rename("/mnt/d/new", "/mnt/d/renamed"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := tx.Rename("/mnt/d/index", "/mnt/d/renamed.index"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if err := tx.Remove("/mnt/d/missing"); err != nil {
+		t.Fatalf("Remove failed: %v", err)
+	}
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Expected Commit to fail removing a missing file")
+	}
+
+	want := map[string]string{"data": "v1", "index": "data"}
+	if got := txTree(t, mfs); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected a failed commit to leave %v, got %v", want, got)
+	}
+}
+
+func TestTxKeepsSnapshots(t *testing.T) {
+	mfs := txTestFS(t)
+	if err := mfs.Snapshot("/mnt/d", "before"); err != nil {
+		t.Fatalf("Snapshot failed: %v", err)
+	}
+	tx, err := mfs.BeginTx("/mnt")
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	if _, err := tx.Write("/mnt/d/data", []byte("v2"), -1, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	snapshots, err := mfs.ListSnapshots("/mnt/d")
+	if err != nil || len(snapshots) != 1 || snapshots[0].Name != "before" {
+		t.Fatalf("Expected the snapshot kept, got %v, %v", snapshots, err)
+	}
+	if data, err := mfs.Read("/mnt/d/.snapshots/before/data", 0, -1); string(data) != "v1" {
+		t.Errorf("Expected the snapshot unchanged, got %q, %v", data, err)
+	}
+}
+
+func TestTxNotSupported(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/mock", NewMockServicePlugin("mock")); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if _, err := mfs.BeginTx("/mock"); !errors.Is(err, filesystem.ErrNotSupported) {
+		t.Errorf("Expected ErrNotSupported, got %v", err)
+	}
+
+	// Operations must stay on the mount of the transaction
+	if err := mfs.Mount("/mnt", testBackends()["memfs"](t)); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	tx, err := mfs.BeginTx("/mnt")
+	if err != nil {
+		t.Fatalf("BeginTx failed: %v", err)
+	}
+	defer tx.Rollback()
+	if err := tx.Rename("/mnt/a", "/mock/a"); err == nil {
+		t.Error("Expected a rename to another mount to fail")
+	}
+}
@@ -0,0 +1,149 @@
+package mountablefs
+
+import (
+	"sync"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// DefaultUsageRefreshInterval is how long the usage of a mount is cached
+// unless SetUsageRefreshInterval says otherwise
+const DefaultUsageRefreshInterval = time.Minute
+
+// Usage is how much a mount holds, as of Updated
+type Usage struct {
+	filesystem.Usage
+	Native  bool      `json:"native"` // Reported by the plugin rather than counted by walking the mount
+	Updated time.Time `json:"updated"`
+}
+
+// usageCache holds the last usage counted for a mount
+type usageCache struct {
+	mu         sync.Mutex
+	usage      *Usage
+	refreshing chan struct{} // Closed once the refresh in progress is done (nil = none)
+}
+
+// SetUsageRefreshInterval sets how long Usage caches the usage of a mount
+// before counting it again (0 = DefaultUsageRefreshInterval)
+func (mfs *MountableFS) SetUsageRefreshInterval(d time.Duration) {
+	mfs.usageRefresh.Store(int64(d))
+}
+
+// Usage returns how much the mount at mountPath holds, like df. Plugins
+// implementing filesystem.UsageReporter report it; for the others the
+// mount is walked, without descending into mounts nested in it, summing the
+// sizes of its files. The result is cached for the refresh interval.
+func (mfs *MountableFS) Usage(mountPath string) (*Usage, error) {
+	mountPath = filesystem.NormalizePath(mountPath)
+	v, ok := mfs.routes().Get([]byte(mountPath))
+	if !ok {
+		return nil, filesystem.NewNotFoundError("usage", mountPath)
+	}
+	return mfs.mountUsage(v.(*MountPoint), true)
+}
+
+// GetUsageStats returns the usage of every mount by mount path, for
+// serverinfofs. Mounts whose usage is being counted report the previous
+// count, or are left out if there is none, so that reading the usage from
+// a mount being walked doesn't wait for the walk.
+func (mfs *MountableFS) GetUsageStats() interface{} {
+	stats := make(map[string]*Usage)
+	for _, mount := range mfs.GetMounts() {
+		if usage, err := mfs.mountUsage(mount, false); err == nil && usage != nil {
+			stats[mount.Path] = usage
+		}
+	}
+	return stats
+}
+
+// mountUsage returns the usage of mount, counting it again if the cached
+// one is out of date. If another count is in progress, it waits for it if
+// wait is set and returns the cached usage otherwise.
+func (mfs *MountableFS) mountUsage(mount *MountPoint, wait bool) (*Usage, error) {
+	interval := time.Duration(mfs.usageRefresh.Load())
+	if interval <= 0 {
+		interval = DefaultUsageRefreshInterval
+	}
+
+	c := mount.usage
+	if c == nil {
+		return mfs.countUsage(mount)
+	}
+	c.mu.Lock()
+	for {
+		if c.usage != nil && mfs.now().Sub(c.usage.Updated) < interval {
+			usage := c.usage
+			c.mu.Unlock()
+			return usage, nil
+		}
+		if c.refreshing == nil {
+			break
+		}
+		if !wait {
+			usage := c.usage
+			c.mu.Unlock()
+			return usage, nil
+		}
+		refreshing := c.refreshing
+		c.mu.Unlock()
+		<-refreshing
+		c.mu.Lock()
+	}
+	refreshing := make(chan struct{})
+	c.refreshing = refreshing
+	c.mu.Unlock()
+
+	usage, err := mfs.countUsage(mount)
+
+	c.mu.Lock()
+	c.refreshing = nil
+	if err == nil {
+		c.usage = usage
+	}
+	c.mu.Unlock()
+	close(refreshing)
+	return usage, err
+}
+
+// countUsage asks the plugin of mount for its usage or walks the mount
+func (mfs *MountableFS) countUsage(mount *MountPoint) (*Usage, error) {
+	if reporter, ok := mount.Plugin.GetFileSystem().(filesystem.UsageReporter); ok {
+		u, err := reporter.Usage()
+		if err != nil {
+			return nil, err
+		}
+		return &Usage{Usage: *u, Native: true, Updated: mfs.now()}, nil
+	}
+
+	usage := &Usage{Updated: mfs.now()}
+	tree := mfs.routes()
+	err := filesystem.Walk(mfs, mount.Path, func(path string, info *filesystem.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != mount.Path && info.IsDir {
+			// The .agfs directory isn't stored anywhere, and nested mounts
+			// count for themselves
+			if _, nested := tree.Get([]byte(path)); nested || info.Meta.Type == MetaValueVirtual {
+				return filesystem.SkipDir
+			}
+		}
+		switch {
+		case info.Meta.Type == "symlink":
+			usage.Symlinks++
+		case info.IsDir:
+			usage.Directories++
+		default:
+			usage.Files++
+			usage.Bytes += info.Size
+		}
+		usage.Inodes++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
@@ -0,0 +1,136 @@
+package mountablefs
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+)
+
+func TestUsage(t *testing.T) {
+	backends := testBackends()
+	for _, name := range []string{"memfs", "localfs"} {
+		newPlugin := backends[name]
+		t.Run(name, func(t *testing.T) {
+			mfs := NewMountableFS(api.PoolConfig{})
+			clock := time.Unix(1700000000, 0)
+			mfs.now = func() time.Time { return clock }
+			for _, mountPath := range []string{"/mnt", "/mnt/nested"} {
+				if err := mfs.Mount(mountPath, newPlugin(t)); err != nil {
+					t.Fatalf("Failed to mount %s: %v", mountPath, err)
+				}
+			}
+
+			// Plugins may hold files of their own, such as a README
+			base := map[string]filesystem.Usage{}
+			for _, mountPath := range []string{"/mnt", "/mnt/nested"} {
+				usage, err := mfs.Usage(mountPath)
+				if err != nil {
+					t.Fatalf("Usage failed: %v", err)
+				}
+				base[mountPath] = usage.Usage
+			}
+			clock = clock.Add(DefaultUsageRefreshInterval)
+
+			if err := mfs.MkdirAll("/mnt/d/e", 0755); err != nil {
+				t.Fatalf("MkdirAll failed: %v", err)
+			}
+			for file, size := range map[string]int{"/mnt/a": 5, "/mnt/d/b": 10, "/mnt/nested/c": 100} {
+				if _, err := mfs.Write(file, make([]byte, size), 0, filesystem.WriteFlagCreate); err != nil {
+					t.Fatalf("Write %s failed: %v", file, err)
+				}
+			}
+			// Links to a file and a directory count once each, however
+			// much their targets hold
+			if err := mfs.Symlink("/mnt/d/b", "/mnt/link"); err != nil {
+				t.Fatalf("Symlink failed: %v", err)
+			}
+			if err := mfs.Symlink("/mnt/d", "/mnt/dlink"); err != nil {
+				t.Fatalf("Symlink failed: %v", err)
+			}
+
+			usage, err := mfs.Usage("/mnt")
+			if err != nil {
+				t.Fatalf("Usage failed: %v", err)
+			}
+			want := base["/mnt"]
+			want.Bytes += 15
+			want.Files += 2
+			want.Directories += 2
+			want.Symlinks += 2
+			want.Inodes += 6
+			if usage.Usage != want || usage.Native {
+				t.Errorf("Expected %+v counted by walking, got %+v", want, *usage)
+			}
+			nested, err := mfs.Usage("/mnt/nested")
+			if err != nil || nested.Bytes-base["/mnt/nested"].Bytes != 100 || nested.Files-base["/mnt/nested"].Files != 1 {
+				t.Errorf("Expected the nested mount to hold 1 more file of 100 bytes, got %+v (%v)", nested, err)
+			}
+
+			// The count is cached until the refresh interval is over
+			if _, err := mfs.Write("/mnt/f", make([]byte, 20), 0, filesystem.WriteFlagCreate); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+			if usage, _ := mfs.Usage("/mnt"); usage.Usage != want {
+				t.Errorf("Expected the cached count %+v, got %+v", want, usage.Usage)
+			}
+			clock = clock.Add(DefaultUsageRefreshInterval)
+			want.Bytes += 20
+			want.Files++
+			want.Inodes++
+			if usage, _ := mfs.Usage("/mnt"); usage.Usage != want {
+				t.Errorf("Expected a new count of %+v, got %+v", want, usage.Usage)
+			}
+
+			stats := mfs.GetUsageStats().(map[string]*Usage)
+			if len(stats) != 2 || stats["/mnt"].Usage != want {
+				t.Errorf("Expected the usage of both mounts, got %v", stats)
+			}
+		})
+	}
+
+	t.Run("not a mount", func(t *testing.T) {
+		mfs := NewMountableFS(api.PoolConfig{})
+		if _, err := mfs.Usage("/mnt"); !errors.Is(err, filesystem.ErrNotFound) {
+			t.Errorf("Expected a not found error, got %v", err)
+		}
+	})
+}
+
+// usageFS reports its usage without being walked
+type usageFS struct {
+	filesystem.FileSystem
+}
+
+func (fs *usageFS) Usage() (*filesystem.Usage, error) {
+	return &filesystem.Usage{Bytes: 1 << 30, Inodes: 1000}, nil
+}
+
+type usagePlugin struct {
+	plugin.ServicePlugin
+}
+
+func (p *usagePlugin) GetFileSystem() filesystem.FileSystem {
+	return &usageFS{FileSystem: p.ServicePlugin.GetFileSystem()}
+}
+
+func TestUsageNative(t *testing.T) {
+	mfs := NewMountableFS(api.PoolConfig{})
+	if err := mfs.Mount("/mnt", &usagePlugin{ServicePlugin: testBackends()["memfs"](t)}); err != nil {
+		t.Fatalf("Failed to mount: %v", err)
+	}
+	if _, err := mfs.Write("/mnt/a", []byte("data"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	usage, err := mfs.Usage("/mnt")
+	if err != nil {
+		t.Fatalf("Usage failed: %v", err)
+	}
+	if !usage.Native || usage.Bytes != 1<<30 || usage.Inodes != 1000 {
+		t.Errorf("Expected the usage reported by the plugin, got %+v", usage)
+	}
+}
@@ -0,0 +1,132 @@
+package mountablefs
+
+import (
+	"math"
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+)
+
+// WriteConflictPolicy decides what happens to a write that overlaps another
+// write to the same file still in progress, as when two handles write the
+// same range at once
+type WriteConflictPolicy string
+
+const (
+	// WriteConflictLastWriterWins makes the write wait for the overlapping
+	// writes to finish before it is applied, so the range ends up holding
+	// the data of the write applied last rather than a mix of both. It is
+	// the default.
+	WriteConflictLastWriterWins WriteConflictPolicy = "last-writer-wins"
+
+	// WriteConflictFail fails the write with a filesystem.WriteConflictError
+	WriteConflictFail WriteConflictPolicy = "error"
+)
+
+// ParseWriteConflictPolicy parses the write conflict policy of a mount, given
+// as configuration files and mount requests do. An empty string leaves the
+// default.
+func ParseWriteConflictPolicy(s string) (WriteConflictPolicy, error) {
+	switch p := WriteConflictPolicy(s); p {
+	case "", WriteConflictLastWriterWins, WriteConflictFail:
+		return p, nil
+	}
+	return "", filesystem.NewInvalidArgumentError("write_conflicts", s, `must be "last-writer-wins" or "error"`)
+}
+
+// wholeFile is the end of the range of writes that may rewrite all of a file
+const wholeFile = math.MaxInt64
+
+// writeRanges tracks the writes in progress on the files of a mount, by
+// path in the mount
+type writeRanges struct {
+	mu    sync.Mutex
+	files map[string][]*writeRange
+}
+
+// writeRange is a write in progress to [start, end) of a file
+type writeRange struct {
+	start, end int64
+	done       chan struct{} // Closed once the write is done
+}
+
+func newWriteRanges() *writeRanges {
+	return &writeRanges{files: make(map[string][]*writeRange)}
+}
+
+// begin registers a write to [start, end) of path, waiting for the writes in
+// progress it overlaps to finish if wait is set. It returns the function to
+// call once the write is done, or false without registering it if it
+// overlaps a write in progress and wait isn't set.
+func (w *writeRanges) begin(path string, start, end int64, wait bool) (func(), bool) {
+	r := &writeRange{start: start, end: end, done: make(chan struct{})}
+	for {
+		w.mu.Lock()
+		busy := w.overlapping(path, r)
+		if busy == nil {
+			w.files[path] = append(w.files[path], r)
+			w.mu.Unlock()
+			return func() { w.finish(path, r) }, true
+		}
+		w.mu.Unlock()
+
+		if !wait {
+			return nil, false
+		}
+		<-busy.done
+	}
+}
+
+// overlapping returns a write in progress to path overlapping r, or nil. The
+// caller must hold w.mu.
+func (w *writeRanges) overlapping(path string, r *writeRange) *writeRange {
+	for _, other := range w.files[path] {
+		if r.start < other.end && other.start < r.end {
+			return other
+		}
+	}
+	return nil
+}
+
+// finish unregisters r, releasing the writes waiting for it
+func (w *writeRanges) finish(path string, r *writeRange) {
+	w.mu.Lock()
+	writes := w.files[path]
+	for i, other := range writes {
+		if other == r {
+			writes = append(writes[:i], writes[i+1:]...)
+			break
+		}
+	}
+	if len(writes) == 0 {
+		delete(w.files, path)
+	} else {
+		w.files[path] = writes
+	}
+	w.mu.Unlock()
+	close(r.done)
+}
+
+// beginWrite coordinates a write of size bytes at offset to relPath, path in
+// the mount, with the writes to it in progress, as the mount's policy says.
+// Writes without an offset (offset < 0), appends, and every write to a
+// plugin that may rewrite the whole file for an offset write cover the
+// whole file. It returns the function to call once the write is done.
+func (m *MountPoint) beginWrite(relPath, path string, offset int64, size int, appending bool) (func(), error) {
+	if m.writes == nil {
+		return func() {}, nil
+	}
+	start, end := offset, offset+int64(size)
+	if offset < 0 || appending || !m.capabilities().Has(plugin.CapabilityOffsetWrite) {
+		start, end = 0, wholeFile
+	}
+	done, ok := m.writes.begin(relPath, start, end, m.Options.WriteConflicts != WriteConflictFail)
+	if !ok {
+		if appending {
+			offset = -1
+		}
+		return nil, filesystem.NewWriteConflictError(path, offset, int64(size))
+	}
+	return done, nil
+}
@@ -0,0 +1,223 @@
+package mountablefs
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// rewritingFS applies offset writes by rewriting the whole file, as object
+// stores do, pausing between the read and the write so that concurrent
+// writes interleave. It blocks writes starting with the byte block, if set,
+// until a value is sent on release, announcing them on started.
+type rewritingFS struct {
+	filesystem.FileSystem
+	block   byte
+	started chan struct{}
+	release chan struct{}
+}
+
+func (fs *rewritingFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	if fs.block != 0 && len(data) > 0 && data[0] == fs.block {
+		fs.started <- struct{}{}
+		<-fs.release
+	}
+	if offset < 0 {
+		return fs.FileSystem.Write(path, data, offset, flags)
+	}
+	content, err := fs.FileSystem.Read(path, 0, -1)
+	if err != nil && err != io.EOF && !errors.Is(err, filesystem.ErrNotFound) {
+		return 0, err
+	}
+	time.Sleep(100 * time.Microsecond)
+	if end := offset + int64(len(data)); end > int64(len(content)) {
+		content = append(content, make([]byte, end-int64(len(content)))...)
+	}
+	copy(content[offset:], data)
+	if _, err := fs.FileSystem.Write(path, content, -1, filesystem.WriteFlagCreate|filesystem.WriteFlagTruncate); err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// rewritingPlugin is a memfs plugin whose file system is a rewritingFS,
+// declaring the given capabilities
+type rewritingPlugin struct {
+	plugin.ServicePlugin
+	fs   *rewritingFS
+	caps plugin.CapabilitySet
+}
+
+func (p *rewritingPlugin) GetFileSystem() filesystem.FileSystem {
+	return p.fs
+}
+
+func (p *rewritingPlugin) Capabilities() plugin.CapabilitySet {
+	return p.caps
+}
+
+func newRewritingPlugin(t *testing.T, caps plugin.CapabilitySet) *rewritingPlugin {
+	t.Helper()
+	p := memfs.NewMemFSPlugin()
+	if err := p.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	fs := &rewritingFS{FileSystem: p.GetFileSystem(), started: make(chan struct{}), release: make(chan struct{})}
+	return &rewritingPlugin{ServicePlugin: p, fs: fs, caps: caps}
+}
+
+// TestConcurrentDisjointWrites checks that two writers filling alternate
+// blocks of a file don't lose each other's blocks, whether they write
+// through handles of a plugin writing in place or by path to a plugin
+// rewriting the whole file for every write
+func TestConcurrentDisjointWrites(t *testing.T) {
+	const blocks, blockSize = 40, 16
+
+	for _, inPlace := range []bool{true, false} {
+		mfs := NewMountableFS(api.PoolConfig{})
+		var p plugin.ServicePlugin = newRewritingPlugin(t, plugin.BaselineCapabilities())
+		if inPlace {
+			p = memfs.NewMemFSPlugin()
+			if err := p.Initialize(map[string]interface{}{}); err != nil {
+				t.Fatalf("Failed to initialize memfs: %v", err)
+			}
+		}
+		if err := mfs.Mount("/mnt", p); err != nil {
+			t.Fatalf("Failed to mount: %v", err)
+		}
+		if _, err := mfs.Write("/mnt/file", nil, -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		var wg sync.WaitGroup
+		for w := 0; w < 2; w++ {
+			write := func(data []byte, offset int64) error {
+				_, err := mfs.Write("/mnt/file", data, offset, filesystem.WriteFlagNone)
+				return err
+			}
+			if inPlace {
+				h, err := mfs.OpenHandle("/mnt/file", filesystem.O_RDWR, 0)
+				if err != nil {
+					t.Fatalf("OpenHandle failed: %v", err)
+				}
+				defer h.Close()
+				write = func(data []byte, offset int64) error {
+					_, err := h.WriteAt(data, offset)
+					return err
+				}
+			}
+
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				block := bytes.Repeat([]byte{byte('a' + w)}, blockSize)
+				for i := w; i < blocks; i += 2 {
+					if err := write(block, int64(i*blockSize)); err != nil {
+						t.Errorf("Write of block %d failed: %v", i, err)
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		content, err := mfs.Read("/mnt/file", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if len(content) != blocks*blockSize {
+			t.Fatalf("Expected %d bytes with inPlace=%v, got %d", blocks*blockSize, inPlace, len(content))
+		}
+		for i := 0; i < blocks; i++ {
+			want := bytes.Repeat([]byte{byte('a' + i%2)}, blockSize)
+			if got := content[i*blockSize : (i+1)*blockSize]; !bytes.Equal(got, want) {
+				t.Errorf("Block %d lost with inPlace=%v: got %q", i, inPlace, got)
+			}
+		}
+	}
+}
+
+// TestOverlappingWrites checks what happens to a write overlapping one in
+// progress under each policy
+func TestOverlappingWrites(t *testing.T) {
+	for _, policy := range []WriteConflictPolicy{WriteConflictLastWriterWins, WriteConflictFail} {
+		p := newRewritingPlugin(t, plugin.BaselineCapabilities().With(plugin.CapabilityOffsetWrite))
+		mfs := NewMountableFS(api.PoolConfig{})
+		if err := mfs.MountWithOptions("/mnt", p, MountOptions{WriteConflicts: policy}); err != nil {
+			t.Fatalf("Failed to mount: %v", err)
+		}
+		write := func(data string, offset int64) error {
+			_, err := mfs.Write("/mnt/file", []byte(data), offset, filesystem.WriteFlagCreate)
+			return err
+		}
+
+		// The first writer holds [0, 10) until released
+		p.fs.block = 'a'
+		first := make(chan error, 1)
+		go func() {
+			first <- write("aaaaaaaaaa", 0)
+		}()
+		<-p.fs.started
+
+		// A disjoint write goes through meanwhile
+		if err := write("cccccccccc", 20); err != nil {
+			t.Errorf("Disjoint write failed with %s: %v", policy, err)
+		}
+
+		second := make(chan error, 1)
+		go func() {
+			second <- write("bbbbbbbbbb", 5)
+		}()
+
+		var want string
+		switch policy {
+		case WriteConflictFail:
+			if err := <-second; !errors.Is(err, filesystem.ErrWriteConflict) {
+				t.Errorf("Expected the overlapping write to fail with ErrWriteConflict, got %v", err)
+			}
+			want = "aaaaaaaaaa" + "\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" + "cccccccccc"
+		case WriteConflictLastWriterWins:
+			select {
+			case err := <-second:
+				t.Errorf("Expected the overlapping write to wait for the first, it returned %v", err)
+			case <-time.After(20 * time.Millisecond):
+			}
+			want = "aaaaabbbbbbbbbb" + "\x00\x00\x00\x00\x00" + "cccccccccc"
+		}
+		p.fs.release <- struct{}{}
+		if err := <-first; err != nil {
+			t.Errorf("First write failed with %s: %v", policy, err)
+		}
+		if policy == WriteConflictLastWriterWins {
+			if err := <-second; err != nil {
+				t.Errorf("Overlapping write failed with %s: %v", policy, err)
+			}
+		}
+
+		content, err := mfs.Read("/mnt/file", 0, -1)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(content) != want {
+			t.Errorf("Expected %q with %s, got %q", want, policy, content)
+		}
+	}
+}
+
+func TestParseWriteConflictPolicy(t *testing.T) {
+	for _, s := range []string{"", "last-writer-wins", "error"} {
+		if p, err := ParseWriteConflictPolicy(s); err != nil || string(p) != s {
+			t.Errorf("Expected %q to parse, got %q (%v)", s, p, err)
+		}
+	}
+	if _, err := ParseWriteConflictPolicy("first-writer-wins"); !errors.Is(err, filesystem.ErrInvalidArgument) {
+		t.Errorf("Expected an invalid argument error, got %v", err)
+	}
+}
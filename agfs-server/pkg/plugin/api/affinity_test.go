@@ -0,0 +1,131 @@
+package api
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAcquireForAffinityHit(t *testing.T) {
+	pool := newTestPool(t, "sessionfs", PoolConfig{
+		MaxInstances:         4,
+		EnableStatistics:     true,
+		AffinityMaxInstances: 2,
+		AffinityIdleTimeout:  time.Minute,
+	})
+
+	a, err := pool.AcquireFor("session-a")
+	if err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+	b, err := pool.AcquireFor("session-b")
+	if err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+	pool.ReleaseFor("session-a", a)
+	pool.ReleaseFor("session-b", b)
+
+	for i := 0; i < 3; i++ {
+		got, err := pool.AcquireFor("session-a")
+		if err != nil {
+			t.Fatalf("AcquireFor failed: %v", err)
+		}
+		if got != a {
+			t.Fatalf("Expected session-a to get its instance back on call %d", i)
+		}
+		pool.ReleaseFor("session-a", got)
+	}
+	if got, _ := pool.AcquireFor("session-b"); got != b {
+		t.Error("Expected session-b to get its instance back")
+	}
+
+	stats := pool.GetStats()
+	if stats.AffinityHits != 4 || stats.AffinityMisses != 2 {
+		t.Errorf("Expected 4 hits and 2 misses, got %d and %d", stats.AffinityHits, stats.AffinityMisses)
+	}
+}
+
+func TestAcquireForFallback(t *testing.T) {
+	pool := newTestPool(t, "sessionfs", PoolConfig{
+		MaxInstances:         2,
+		AcquireTimeout:       100 * time.Millisecond,
+		AffinityMaxInstances: 1,
+		AffinityIdleTimeout:  time.Minute,
+	})
+
+	a, err := pool.AcquireFor("session-a")
+	if err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+	b, err := pool.AcquireFor("session-b")
+	if err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+
+	// Only one instance may be parked; the other goes back to the pool
+	pool.ReleaseFor("session-a", a)
+	pool.ReleaseFor("session-b", b)
+	got, err := pool.AcquireFor("session-b")
+	if err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+	if got != b {
+		t.Fatal("Expected session-b to be served from the general pool")
+	}
+	pool.Release(got)
+
+	// With the pool exhausted, a plain Acquire reclaims the parked instance
+	// instead of timing out
+	other, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	reclaimed, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Expected the parked instance to be reclaimed, got %v", err)
+	}
+	if reclaimed != a && other != a {
+		t.Error("Expected session-a's parked instance to be handed out")
+	}
+	pool.Release(other)
+	pool.Release(reclaimed)
+
+	// session-a's instance is gone from the holding area
+	pool.ReleaseFor("session-c", a)
+	got, err = pool.AcquireFor("session-a")
+	if err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+	if got == a {
+		t.Error("Expected session-a to lose its affinity after its instance was reclaimed")
+	}
+}
+
+func TestAcquireForIdleTimeout(t *testing.T) {
+	pool := newTestPool(t, "sessionfs", PoolConfig{
+		MaxInstances:         1,
+		EnableStatistics:     true,
+		AffinityMaxInstances: 1,
+		AffinityIdleTimeout:  20 * time.Millisecond,
+	})
+
+	a, err := pool.AcquireFor("session-a")
+	if err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+	pool.ReleaseFor("session-a", a)
+	time.Sleep(100 * time.Millisecond)
+
+	// The expired instance is back in the general pool
+	select {
+	case instance := <-pool.instances:
+		pool.Release(instance)
+	default:
+		t.Fatal("Expected the idle instance to return to the general pool")
+	}
+	if _, err := pool.AcquireFor("session-a"); err != nil {
+		t.Fatalf("AcquireFor failed: %v", err)
+	}
+	if stats := pool.GetStats(); stats.AffinityHits != 0 || stats.AffinityMisses != 2 {
+		t.Errorf("Expected 0 hits and 2 misses, got %d and %d", stats.AffinityHits, stats.AffinityMisses)
+	}
+}
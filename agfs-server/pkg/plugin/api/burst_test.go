@@ -0,0 +1,118 @@
+package api
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolBurst(t *testing.T) {
+	pool := newTestPool(t, "burstfs", PoolConfig{
+		MaxInstances:         2,
+		BurstMax:             4,
+		AcquireTimeout:       20 * time.Millisecond,
+		EnableStatistics:     true,
+		AffinityMaxInstances: 4,
+	})
+
+	// A burst past MaxInstances but under BurstMax is served right away,
+	// concurrently, and never beyond the ceiling
+	var (
+		mu       sync.Mutex
+		held     []*WASMModuleInstance
+		failures int
+		wg       sync.WaitGroup
+	)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := pool.Acquire()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failures++
+				return
+			}
+			held = append(held, instance)
+		}()
+	}
+	wg.Wait()
+	if len(held) != 4 || failures != 2 {
+		t.Fatalf("Expected 4 instances and 2 timeouts at the ceiling, got %d and %d", len(held), failures)
+	}
+	stats := pool.GetStats()
+	if stats.TotalCreated != 2 || stats.BurstCreated != 2 || stats.BurstActive != 2 {
+		t.Errorf("Expected 2 pooled and 2 burst instances, got %+v", stats)
+	}
+
+	// Burst instances are destroyed on release, even when released for a
+	// key, and only the pooled ones stay
+	for i, instance := range held {
+		if i%2 == 0 {
+			pool.ReleaseFor("/file", instance)
+		} else {
+			pool.Release(instance)
+		}
+	}
+	stats = pool.GetStats()
+	if stats.BurstActive != 0 || stats.TotalDestroyed != 0 {
+		t.Errorf("Expected burst instances destroyed apart from the pooled ones, got %+v", stats)
+	}
+	if idle := len(pool.instances) + len(pool.affinity); idle != 2 {
+		t.Errorf("Expected 2 idle instances, got %d", idle)
+	}
+	if pool.currentInstances != 2 || pool.burstInstances != 0 {
+		t.Errorf("Expected 2 instances and no burst ones, got %d and %d", pool.currentInstances, pool.burstInstances)
+	}
+
+	// Once the spike has passed the pool serves from its own instances
+	for i := 0; i < 2; i++ {
+		instance, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		defer pool.Release(instance)
+	}
+	if stats := pool.GetStats(); stats.TotalCreated != 2 || stats.BurstCreated != 2 {
+		t.Errorf("Expected no new instances, got %+v", stats)
+	}
+}
+
+func TestPoolBurstClose(t *testing.T) {
+	pool := newTestPool(t, "burstfs", PoolConfig{MaxInstances: 1, BurstMax: 2, EnableStatistics: true})
+
+	first, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	burst, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Burst Acquire failed: %v", err)
+	}
+	if !burst.burst || first.burst {
+		t.Fatal("Expected only the second instance to be a burst instance")
+	}
+
+	pool.Close()
+	pool.Release(burst)
+	if stats := pool.GetStats(); stats.BurstActive != 0 {
+		t.Errorf("Expected the burst instance destroyed after close, got %+v", stats)
+	}
+	if _, ok, _ := pool.acquireBurst(); ok {
+		t.Error("Expected no burst instance from a closed pool")
+	}
+}
+
+func TestPoolNoBurstByDefault(t *testing.T) {
+	pool := newTestPool(t, "burstfs", PoolConfig{MaxInstances: 1, AcquireTimeout: 10 * time.Millisecond})
+
+	instance, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer pool.Release(instance)
+	if _, err := pool.Acquire(); err == nil {
+		t.Error("Expected the second Acquire to time out without BurstMax")
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/tetratelabs/wazero"
+)
+
+// spinGuest exports spin(), which loops forever
+func spinGuest() []byte {
+	types := wasmSection(1, 1, 0x60, 0, 0)
+	functions := wasmSection(3, 1, 0)
+	exports := wasmSection(7, concat([]byte{1}, wasmName("spin"), []byte{0x00, 0})...)
+	code := wasmSection(10, 1, 7, 0, 0x03, 0x40, 0x0c, 0, 0x0b, 0x0b) // loop br 0 end
+	return concat([]byte("\x00asm\x01\x00\x00\x00"), types, functions, exports, code)
+}
+
+// newSpinPool creates a pool of spinGuest instances whose calls are aborted
+// when their context is done, like the loader's
+func newSpinPool(t *testing.T, config PoolConfig) *WASMInstancePool {
+	t.Helper()
+	ctx := context.Background()
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
+	t.Cleanup(func() { runtime.Close(ctx) })
+
+	compiled, err := runtime.CompileModule(ctx, spinGuest())
+	if err != nil {
+		t.Fatalf("Failed to compile module: %v", err)
+	}
+	pool := NewWASMInstancePool(ctx, runtime, compiled, "spinfs", config, nil)
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestExecuteContextAbortsGuest(t *testing.T) {
+	pool := newSpinPool(t, PoolConfig{MaxInstances: 1, EnableStatistics: true})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var aborted *WASMModuleInstance
+	done := make(chan error, 1)
+	go func() {
+		done <- pool.ExecuteContext(ctx, func(instance *WASMModuleInstance) error {
+			aborted = instance
+			_, err := instance.module.ExportedFunction("spin").Call(ctx)
+			return err
+		})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("Expected the cancelled call to fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected cancelling the context to abort the guest")
+	}
+
+	stats := pool.GetStats()
+	if stats.TotalDestroyed != 1 || stats.CurrentActive != 0 {
+		t.Errorf("Expected the aborted instance to be destroyed, got %+v", stats)
+	}
+
+	// The next call gets a fresh instance, not the aborted one
+	err := pool.Execute(func(instance *WASMModuleInstance) error {
+		if instance == aborted {
+			t.Error("Expected the aborted instance not to be reused")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Execute after abort failed: %v", err)
+	}
+}
+
+func TestExecuteContextStopsWaitingForInstance(t *testing.T) {
+	pool := newSpinPool(t, PoolConfig{MaxInstances: 1, AcquireTimeout: 10 * time.Second})
+
+	held, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer pool.Release(held)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err = pool.ExecuteFSContext(ctx, func(fs filesystem.FileSystem) error {
+		t.Error("Expected the operation not to run")
+		return nil
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the wait to end with the context, took %v", elapsed)
+	}
+}
+
+func TestAcquireContextTimesOut(t *testing.T) {
+	pool := newSpinPool(t, PoolConfig{MaxInstances: 1, AcquireTimeout: 10 * time.Second, EnableStatistics: true})
+
+	held, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+
+	// Waiters whose deadline passes give up promptly
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		instance, err := pool.AcquireContext(ctx)
+		cancel()
+		if instance != nil || !errors.Is(err, ErrAcquireTimeout) || !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected ErrAcquireTimeout, got %v, %v", instance, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Expected the wait to end at the deadline, took %v", elapsed)
+		}
+	}
+	stats := pool.GetStats()
+	if stats.TotalWaits != 3 || stats.FailedRequests != 3 {
+		t.Errorf("Expected 3 waits and failures counted, got %+v", stats)
+	}
+
+	// A waiter giving up as the instance is released leaves it in the pool
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	time.AfterFunc(10*time.Millisecond, func() { pool.Release(held) })
+	if instance, err := pool.AcquireContext(ctx); err == nil {
+		pool.Release(instance)
+	}
+	instance, err := pool.AcquireContext(context.Background())
+	if err != nil {
+		t.Fatalf("Acquire after the timeouts failed: %v", err)
+	}
+	pool.Release(instance)
+	if stats := pool.GetStats(); stats.TotalCreated != 1 || stats.TotalDestroyed != 0 {
+		t.Errorf("Expected the single instance kept, got %+v", stats)
+	}
+}
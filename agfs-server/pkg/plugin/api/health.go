@@ -0,0 +1,120 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// PoolUnhealthyError is returned by Acquire while a pool is unhealthy, after
+// too many of its instances in a row failed to be created
+type PoolUnhealthyError struct {
+	Plugin     string
+	Failures   int           // Consecutive instantiation failures
+	RetryAfter time.Duration // How long until the next recovery attempt
+	Err        error         // The last instantiation failure
+}
+
+func (e *PoolUnhealthyError) Error() string {
+	return fmt.Sprintf("WASM plugin %s is unhealthy after %d consecutive instantiation failures, next retry in %v: %v",
+		e.Plugin, e.Failures, e.RetryAfter, e.Err)
+}
+
+func (e *PoolUnhealthyError) Unwrap() error {
+	return e.Err
+}
+
+// PoolSaturatedError is returned by Health while a pool has been saturated
+// for longer than PoolConfig.UnreadyAfter
+type PoolSaturatedError struct {
+	Plugin  string
+	Waiting int           // Requests waiting for an instance
+	For     time.Duration // How long the pool has been saturated
+}
+
+func (e *PoolSaturatedError) Error() string {
+	return fmt.Sprintf("WASM plugin %s has had every instance busy for %v, %d request(s) waiting",
+		e.Plugin, e.For.Round(time.Millisecond), e.Waiting)
+}
+
+// Health returns a PoolUnhealthyError while the pool is unhealthy, a
+// PoolSaturatedError while it has been saturated for too long, and nil
+// otherwise
+func (p *WASMInstancePool) Health() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.nextRecovery.IsZero() {
+		wait := time.Until(p.nextRecovery)
+		if wait < 0 {
+			wait = 0
+		}
+		return &PoolUnhealthyError{Plugin: p.pluginName, Failures: p.failures, RetryAfter: wait, Err: p.lastFailure}
+	}
+	if !p.saturatedAt.IsZero() && p.waiting > 0 {
+		if d := time.Since(p.saturatedAt); d > p.config.UnreadyAfter {
+			return &PoolSaturatedError{Plugin: p.pluginName, Waiting: p.waiting, For: d}
+		}
+	}
+	return nil
+}
+
+// newInstance creates an instance, tracking consecutive failures. Once
+// UnhealthyAfter attempts in a row failed, the pool is unhealthy: a single
+// attempt is made per RecoveryInterval, and meanwhile callers fail right
+// away instead of each instantiating the plugin again.
+func (p *WASMInstancePool) newInstance() (*WASMModuleInstance, error) {
+	if err := p.admitCreate(); err != nil {
+		return nil, err
+	}
+	instance, err := p.createInstance()
+	p.recordCreate(err)
+	return instance, err
+}
+
+// admitCreate fails with a PoolUnhealthyError unless the pool is healthy or
+// due for a recovery attempt, which the caller then makes
+func (p *WASMInstancePool) admitCreate() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.nextRecovery.IsZero() {
+		return nil
+	}
+	if wait := time.Until(p.nextRecovery); p.recovering || wait > 0 {
+		if wait < 0 {
+			wait = 0
+		}
+		return &PoolUnhealthyError{Plugin: p.pluginName, Failures: p.failures, RetryAfter: wait, Err: p.lastFailure}
+	}
+	p.recovering = true
+	return nil
+}
+
+// recordCreate records the outcome of an attempt to create an instance
+func (p *WASMInstancePool) recordCreate(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.recovering = false
+	if err == nil {
+		if !p.nextRecovery.IsZero() {
+			log.Infof("WASM plugin %s recovered after %d instantiation failures", p.pluginName, p.failures)
+		}
+		p.failures = 0
+		p.lastFailure = nil
+		p.nextRecovery = time.Time{}
+		return
+	}
+
+	p.failures++
+	p.lastFailure = err
+	if p.failures >= p.config.UnhealthyAfter {
+		if p.nextRecovery.IsZero() {
+			log.Warnf("Marking WASM plugin %s unhealthy after %d instantiation failures, retrying every %v: %v",
+				p.pluginName, p.failures, p.config.RecoveryInterval, err)
+		}
+		p.nextRecovery = time.Now().Add(p.config.RecoveryInterval)
+	}
+}
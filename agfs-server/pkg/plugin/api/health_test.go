@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// failingGuest is a guest whose plugin_new traps
+func failingGuest() []byte {
+	types := wasmSection(1, 1, 0x60, 0, 0)
+	functions := wasmSection(3, 1, 0)
+	exports := wasmSection(7, concat([]byte{1}, wasmName("plugin_new"), []byte{0x00, 0})...)
+	code := wasmSection(10, 1, 3, 0, 0x00, 0x0b)
+	return concat([]byte("\x00asm\x01\x00\x00\x00"), types, functions, exports, code)
+}
+
+func TestPoolUnhealthy(t *testing.T) {
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { r.Close(ctx) })
+	failing, err := r.CompileModule(ctx, failingGuest())
+	if err != nil {
+		t.Fatalf("Failed to compile guest: %v", err)
+	}
+	working, err := r.CompileModule(ctx, []byte("\x00asm\x01\x00\x00\x00"))
+	if err != nil {
+		t.Fatalf("Failed to compile guest: %v", err)
+	}
+	pool := NewWASMInstancePool(ctx, r, failing, "brokenfs", PoolConfig{
+		MaxInstances:     2,
+		UnhealthyAfter:   3,
+		RecoveryInterval: 50 * time.Millisecond,
+	}, nil)
+	t.Cleanup(func() { pool.Close() })
+
+	var unhealthy *PoolUnhealthyError
+	for i := 0; i < 3; i++ {
+		if _, err := pool.Acquire(); err == nil || errors.As(err, &unhealthy) {
+			t.Fatalf("Expected attempt %d to fail instantiating, got %v", i, err)
+		}
+	}
+	if _, err := pool.Acquire(); !errors.As(err, &unhealthy) || unhealthy.Failures != 3 || unhealthy.Plugin != "brokenfs" {
+		t.Fatalf("Expected the pool to be unhealthy after 3 failures, got %v", err)
+	}
+	if stats := pool.GetStats(); !stats.Unhealthy || stats.ConsecutiveFailures != 3 {
+		t.Errorf("Expected unhealthy stats, got %+v", stats)
+	}
+	if err := pool.Health(); !errors.As(err, &unhealthy) {
+		t.Errorf("Expected Health to report the pool unhealthy, got %v", err)
+	}
+
+	// A failed recovery attempt keeps the pool unhealthy until the next one
+	time.Sleep(60 * time.Millisecond)
+	if _, err := pool.Acquire(); err == nil || errors.As(err, &unhealthy) {
+		t.Fatalf("Expected a recovery attempt, got %v", err)
+	}
+	pool.compiledModule = working
+	if _, err := pool.Acquire(); !errors.As(err, &unhealthy) {
+		t.Fatalf("Expected the pool to stay unhealthy until the next attempt, got %v", err)
+	}
+
+	// A successful one clears it
+	time.Sleep(60 * time.Millisecond)
+	instance, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Expected the recovery attempt to succeed, got %v", err)
+	}
+	pool.Release(instance)
+	if stats := pool.GetStats(); stats.Unhealthy || stats.ConsecutiveFailures != 0 {
+		t.Errorf("Expected the pool to be healthy again, got %+v", stats)
+	}
+	if err := pool.Health(); err != nil {
+		t.Errorf("Expected Health to report the pool healthy, got %v", err)
+	}
+}
@@ -0,0 +1,278 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/tetratelabs/wazero"
+	wazeroapi "github.com/tetratelabs/wazero/api"
+)
+
+// HostABIVersion is the version of the host functions WASM plugins can import
+// from the "env" module. Versions only add functions, so a guest built
+// against an older version keeps working: it simply never imports the newer
+// ones.
+//
+//	1: host_fs_read, host_fs_write, host_fs_stat, host_fs_readdir,
+//	   host_fs_create, host_fs_mkdir, host_fs_remove, host_fs_remove_all,
+//	   host_fs_rename, host_fs_chmod, host_http_request
+//	2: host_abi_version, host_fs_supports, host_fs_write_at,
+//	   host_fs_truncate, host_fs_touch, host_fs_mkdir_all, host_fs_symlink,
+//	   host_fs_readlink
+//
+// Memory passing convention:
+//   - Paths and other strings are passed as pointers to NUL-terminated UTF-8
+//     in guest memory; byte buffers as a pointer and a length.
+//   - Data returned by the host is allocated with the guest's exported
+//     malloc and owned by the guest afterwards.
+//   - Functions returning i32 return 0 on success, or a pointer to a
+//     NUL-terminated error message (1 if the message couldn't be written).
+//   - Functions returning i64 pack two u32 values: the result (a pointer or,
+//     for host_fs_write_at, a byte count) in the lower 32 bits and an error
+//     message pointer in the upper 32 bits, 0 on success.
+//
+// Operations the host filesystem doesn't implement fail with a "not
+// supported" error; guests can check up front with host_fs_supports.
+//
+// Plugins declare the version they were built against by exporting
+// plugin_abi_version, as a function returning an i32 or as an i32 global.
+// The host refuses to load plugins declaring a newer version than its own,
+// or importing functions their version doesn't have; plugins without the
+// export are taken to need the oldest version providing their imports.
+const HostABIVersion = 2
+
+// MinHostABIVersion is the oldest version plugins may declare
+const MinHostABIVersion = 1
+
+// hostFunction is a host function of the ABI
+type hostFunction struct {
+	since uint32 // ABI version that added it
+
+	// requires checks that the host filesystem implements the optional
+	// interface the function needs (nil = none)
+	requires func(filesystem.FileSystem) bool
+}
+
+// hostFunctions lists every host function in the current ABI
+var hostFunctions = map[string]hostFunction{
+	"host_abi_version":   {since: 2},
+	"host_fs_supports":   {since: 2},
+	"host_fs_read":       {since: 1},
+	"host_fs_write":      {since: 1},
+	"host_fs_write_at":   {since: 2},
+	"host_fs_stat":       {since: 1},
+	"host_fs_readdir":    {since: 1},
+	"host_fs_create":     {since: 1},
+	"host_fs_mkdir":      {since: 1},
+	"host_fs_mkdir_all":  {since: 2},
+	"host_fs_remove":     {since: 1},
+	"host_fs_remove_all": {since: 1},
+	"host_fs_rename":     {since: 1},
+	"host_fs_chmod":      {since: 1},
+	"host_http_request":  {since: 1},
+	"host_fs_truncate": {since: 2, requires: func(fs filesystem.FileSystem) bool {
+		_, ok := fs.(filesystem.Truncater)
+		return ok
+	}},
+	"host_fs_touch": {since: 2, requires: func(fs filesystem.FileSystem) bool {
+		_, ok := fs.(filesystem.Toucher)
+		return ok
+	}},
+	"host_fs_symlink":  {since: 2, requires: supportsSymlinks},
+	"host_fs_readlink": {since: 2, requires: supportsSymlinks},
+}
+
+func supportsSymlinks(fs filesystem.FileSystem) bool {
+	_, ok := fs.(filesystem.Symlinker)
+	return ok
+}
+
+// HostFSSupports reports whether the host function named by the string at
+// params[0] exists and can succeed with fs: 1 if so, 0 otherwise. File
+// system functions are never supported without a host filesystem.
+func HostFSSupports(ctx context.Context, mod wazeroapi.Module, params []uint64, fs filesystem.FileSystem) []uint64 {
+	name, ok := readStringFromMemory(mod, uint32(params[0]))
+	if !ok {
+		return []uint64{0}
+	}
+	fn, exists := hostFunctions[name]
+	if !exists {
+		return []uint64{0}
+	}
+	if fs == nil && name != "host_abi_version" && name != "host_fs_supports" && name != "host_http_request" {
+		return []uint64{0}
+	}
+	if fn.requires != nil && !fn.requires(fs) {
+		return []uint64{0}
+	}
+	return []uint64{1}
+}
+
+// importedABIVersion returns the oldest ABI version providing every host
+// function compiled imports, failing if the host doesn't provide one of them
+func importedABIVersion(compiled wazero.CompiledModule) (uint32, error) {
+	version := uint32(MinHostABIVersion)
+	for _, def := range compiled.ImportedFunctions() {
+		module, name, _ := def.Import()
+		if module != "env" {
+			continue
+		}
+		fn, ok := hostFunctions[name]
+		if !ok {
+			return 0, fmt.Errorf("plugin imports host function %s, which this host (ABI version %d) doesn't provide: it needs a newer AGFS server", name, HostABIVersion)
+		}
+		if fn.since > version {
+			version = fn.since
+		}
+	}
+	return version, nil
+}
+
+// CheckHostImports checks, before compiled is instantiated, that the host
+// provides every host function it imports, so a plugin built for a newer
+// host fails with a clear error rather than an unresolved import
+func CheckHostImports(compiled wazero.CompiledModule) error {
+	_, err := importedABIVersion(compiled)
+	return err
+}
+
+// NegotiateABI returns the host ABI version module, an instance of
+// compiled, was built against: the version it declares with its
+// plugin_abi_version export, or the oldest providing its imports if it
+// declares none. It fails if the plugin requires a newer version than the
+// host's, or imports host functions newer than the version it declares,
+// as the host and the plugin would then disagree on the ABI.
+func NegotiateABI(ctx context.Context, compiled wazero.CompiledModule, module wazeroapi.Module) (uint32, error) {
+	imported, err := importedABIVersion(compiled)
+	if err != nil {
+		return 0, err
+	}
+
+	var declared uint32
+	if fn := module.ExportedFunction("plugin_abi_version"); fn != nil {
+		results, err := fn.Call(ctx)
+		if err != nil || len(results) == 0 {
+			return 0, fmt.Errorf("failed to call plugin_abi_version: %v", err)
+		}
+		declared = uint32(results[0])
+	} else if global := module.ExportedGlobal("plugin_abi_version"); global != nil {
+		declared = uint32(global.Get())
+	} else {
+		return imported, nil
+	}
+
+	switch {
+	case declared > HostABIVersion:
+		return 0, fmt.Errorf("plugin requires host ABI version %d, this host provides version %d: it needs a newer AGFS server", declared, HostABIVersion)
+	case declared < MinHostABIVersion:
+		return 0, fmt.Errorf("plugin declares host ABI version %d, the oldest supported is %d", declared, MinHostABIVersion)
+	case declared < imported:
+		return 0, fmt.Errorf("plugin declares host ABI version %d but imports host functions of version %d", declared, imported)
+	}
+	return declared, nil
+}
+
+// InstantiateHostModule registers the host functions in the "env" module of
+// r, backed by fs. fs may be nil, in which case every file system function
+// fails.
+func InstantiateHostModule(ctx context.Context, r wazero.Runtime, fs filesystem.FileSystem) error {
+	_, err := r.NewHostModuleBuilder("env").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context) uint32 {
+			return HostABIVersion
+		}).
+		Export("host_abi_version").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, namePtr uint32) uint32 {
+			return uint32(HostFSSupports(ctx, mod, []uint64{uint64(namePtr)}, fs)[0])
+		}).
+		Export("host_fs_supports").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32, offset, size int64) uint64 {
+			return HostFSRead(ctx, mod, []uint64{uint64(pathPtr), uint64(offset), uint64(size)}, fs)[0]
+		}).
+		Export("host_fs_read").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, dataPtr, dataLen uint32) uint64 {
+			return HostFSWrite(ctx, mod, []uint64{uint64(pathPtr), uint64(dataPtr), uint64(dataLen)}, fs)[0]
+		}).
+		Export("host_fs_write").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, dataPtr, dataLen uint32, offset int64, flags uint32) uint64 {
+			return HostFSWriteAt(ctx, mod, []uint64{uint64(pathPtr), uint64(dataPtr), uint64(dataLen), uint64(offset), uint64(flags)}, fs)[0]
+		}).
+		Export("host_fs_write_at").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint64 {
+			return HostFSStat(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0]
+		}).
+		Export("host_fs_stat").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint64 {
+			return HostFSReadDir(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0]
+		}).
+		Export("host_fs_readdir").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint32 {
+			return uint32(HostFSCreate(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0])
+		}).
+		Export("host_fs_create").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, perm uint32) uint32 {
+			return uint32(HostFSMkdir(ctx, mod, []uint64{uint64(pathPtr), uint64(perm)}, fs)[0])
+		}).
+		Export("host_fs_mkdir").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, perm uint32) uint32 {
+			return uint32(HostFSMkdirAll(ctx, mod, []uint64{uint64(pathPtr), uint64(perm)}, fs)[0])
+		}).
+		Export("host_fs_mkdir_all").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint32 {
+			return uint32(HostFSRemove(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0])
+		}).
+		Export("host_fs_remove").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint32 {
+			return uint32(HostFSRemoveAll(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0])
+		}).
+		Export("host_fs_remove_all").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, oldPathPtr, newPathPtr uint32) uint32 {
+			return uint32(HostFSRename(ctx, mod, []uint64{uint64(oldPathPtr), uint64(newPathPtr)}, fs)[0])
+		}).
+		Export("host_fs_rename").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, mode uint32) uint32 {
+			return uint32(HostFSChmod(ctx, mod, []uint64{uint64(pathPtr), uint64(mode)}, fs)[0])
+		}).
+		Export("host_fs_chmod").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32, size int64) uint32 {
+			return uint32(HostFSTruncate(ctx, mod, []uint64{uint64(pathPtr), uint64(size)}, fs)[0])
+		}).
+		Export("host_fs_truncate").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint32 {
+			return uint32(HostFSTouch(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0])
+		}).
+		Export("host_fs_touch").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, targetPtr, linkPtr uint32) uint32 {
+			return uint32(HostFSSymlink(ctx, mod, []uint64{uint64(targetPtr), uint64(linkPtr)}, fs)[0])
+		}).
+		Export("host_fs_symlink").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint64 {
+			return HostFSReadlink(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0]
+		}).
+		Export("host_fs_readlink").
+		NewFunctionBuilder().
+		WithFunc(func(ctx context.Context, mod wazeroapi.Module, requestPtr uint32) uint64 {
+			return HostHTTPRequest(ctx, mod, []uint64{uint64(requestPtr)})[0]
+		}).
+		Export("host_http_request").
+		Instantiate(ctx)
+	return err
+}
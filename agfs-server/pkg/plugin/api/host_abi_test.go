@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+	"github.com/tetratelabs/wazero"
+)
+
+// wasmSection encodes a module section with the given id and contents
+func wasmSection(id byte, contents ...byte) []byte {
+	return append([]byte{id, byte(len(contents))}, contents...)
+}
+
+// wasmName encodes a length-prefixed name
+func wasmName(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func concat(parts ...[]byte) []byte {
+	var out []byte
+	for _, p := range parts {
+		out = append(out, p...)
+	}
+	return out
+}
+
+// truncateGuest is a guest that imports host_abi_version, host_fs_supports
+// and host_fs_truncate, and exports a function calling each of them:
+// version(), supports() asking about host_fs_truncate, and truncate()
+// truncating /file to 3 bytes
+func truncateGuest() []byte {
+	const (
+		i32 = 0x7f
+		i64 = 0x7e
+	)
+	types := wasmSection(1, concat(
+		[]byte{3},
+		[]byte{0x60, 2, i32, i64, 1, i32}, // 0: (path, size) -> err
+		[]byte{0x60, 0, 1, i32},           // 1: () -> i32
+		[]byte{0x60, 1, i32, 1, i32},      // 2: (name) -> i32
+	)...)
+	imports := wasmSection(2, concat(
+		[]byte{3},
+		wasmName("env"), wasmName("host_abi_version"), []byte{0x00, 1},
+		wasmName("env"), wasmName("host_fs_supports"), []byte{0x00, 2},
+		wasmName("env"), wasmName("host_fs_truncate"), []byte{0x00, 0},
+	)...)
+	functions := wasmSection(3, 3, 1, 1, 1)
+	memory := wasmSection(5, 1, 0x00, 1)
+	exports := wasmSection(7, concat(
+		[]byte{4},
+		wasmName("memory"), []byte{0x02, 0},
+		wasmName("version"), []byte{0x00, 3},
+		wasmName("supports"), []byte{0x00, 4},
+		wasmName("truncate"), []byte{0x00, 5},
+	)...)
+	code := wasmSection(10, concat(
+		[]byte{3},
+		[]byte{4, 0, 0x10, 0, 0x0b},                   // call host_abi_version
+		[]byte{6, 0, 0x41, 32, 0x10, 1, 0x0b},         // host_fs_supports(32)
+		[]byte{8, 0, 0x41, 8, 0x42, 3, 0x10, 2, 0x0b}, // host_fs_truncate(8, 3)
+	)...)
+	data := wasmSection(11, concat(
+		[]byte{2},
+		[]byte{0x00, 0x41, 8, 0x0b}, wasmName("/file\x00"),
+		[]byte{0x00, 0x41, 32, 0x0b}, wasmName("host_fs_truncate\x00"),
+	)...)
+	return concat([]byte("\x00asm\x01\x00\x00\x00"), types, imports, functions, memory, exports, code, data)
+}
+
+// runGuest instantiates truncateGuest against fs and calls the named export
+func runGuest(t *testing.T, fs filesystem.FileSystem, name string) uint32 {
+	t.Helper()
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { r.Close(ctx) })
+
+	if err := InstantiateHostModule(ctx, r, fs); err != nil {
+		t.Fatalf("Failed to instantiate host module: %v", err)
+	}
+	mod, err := r.Instantiate(ctx, truncateGuest())
+	if err != nil {
+		t.Fatalf("Failed to instantiate guest: %v", err)
+	}
+	results, err := mod.ExportedFunction(name).Call(ctx)
+	if err != nil {
+		t.Fatalf("Calling %s failed: %v", name, err)
+	}
+	return uint32(results[0])
+}
+
+// baseFS hides every optional interface of the wrapped file system
+type baseFS struct {
+	filesystem.FileSystem
+}
+
+func TestHostABITruncate(t *testing.T) {
+	mfs := memfs.NewMemoryFS()
+	if _, err := mfs.Write("/file", []byte("hello world"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if v := runGuest(t, mfs, "version"); v != HostABIVersion {
+		t.Errorf("Expected ABI version %d, got %d", HostABIVersion, v)
+	}
+	if ok := runGuest(t, mfs, "supports"); ok != 1 {
+		t.Errorf("Expected host_fs_truncate to be supported, got %d", ok)
+	}
+	if errPtr := runGuest(t, mfs, "truncate"); errPtr != 0 {
+		t.Fatalf("Expected truncate to succeed, got error pointer %d", errPtr)
+	}
+
+	data, err := mfs.Read("/file", 0, -1)
+	if err != nil && err != io.EOF {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(data) != "hel" {
+		t.Errorf("Expected the guest to truncate the file to %q, got %q", "hel", data)
+	}
+}
+
+func TestHostABINotSupported(t *testing.T) {
+	mfs := memfs.NewMemoryFS()
+	if _, err := mfs.Write("/file", []byte("hello world"), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	fs := baseFS{mfs}
+
+	if ok := runGuest(t, fs, "supports"); ok != 0 {
+		t.Errorf("Expected host_fs_truncate to be unsupported, got %d", ok)
+	}
+	if errPtr := runGuest(t, fs, "truncate"); errPtr == 0 {
+		t.Error("Expected truncate to fail without a Truncater")
+	}
+	if ok := runGuest(t, nil, "supports"); ok != 0 {
+		t.Errorf("Expected host_fs_truncate to be unsupported without a host filesystem, got %d", ok)
+	}
+
+	if info, err := mfs.Stat("/file"); err != nil || info.Size != 11 {
+		t.Errorf("Expected the file to be untouched, got %+v, %v", info, err)
+	}
+}
+
+// abiGuest is a guest declaring the host ABI version declared, with a
+// plugin_abi_version function or, if global, an i32 global (0 = declaring
+// none), and importing the named host functions, each taking and returning
+// i32s: host_abi_version takes none, the others one
+func abiGuest(declared byte, global bool, imports ...string) []byte {
+	const i32 = 0x7f
+	types := wasmSection(1, concat(
+		[]byte{2},
+		[]byte{0x60, 0, 1, i32},      // 0: () -> i32
+		[]byte{0x60, 1, i32, 1, i32}, // 1: (i32) -> i32
+	)...)
+	importList := []byte{byte(len(imports))}
+	for _, name := range imports {
+		typ := byte(1)
+		if name == "host_abi_version" {
+			typ = 0
+		}
+		importList = concat(importList, wasmName("env"), wasmName(name), []byte{0x00, typ})
+	}
+	sections := [][]byte{[]byte("\x00asm\x01\x00\x00\x00"), types, wasmSection(2, importList...)}
+	switch {
+	case declared == 0:
+	case global:
+		sections = append(sections,
+			wasmSection(6, 1, i32, 0, 0x41, declared, 0x0b),
+			wasmSection(7, concat([]byte{1}, wasmName("plugin_abi_version"), []byte{0x03, 0})...))
+	default:
+		sections = append(sections,
+			wasmSection(3, 1, 0),
+			wasmSection(7, concat([]byte{1}, wasmName("plugin_abi_version"), []byte{0x00, byte(len(imports))})...),
+			wasmSection(10, 1, 4, 0, 0x41, declared, 0x0b))
+	}
+	return concat(sections...)
+}
+
+// negotiateABIGuest checks and instantiates guest as the loader does, then
+// returns the ABI version of an instance acquired from a pool given the
+// version negotiated with it
+func negotiateABIGuest(t *testing.T, guest []byte) (uint32, error) {
+	t.Helper()
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { r.Close(ctx) })
+	if err := InstantiateHostModule(ctx, r, nil); err != nil {
+		t.Fatalf("Failed to instantiate host module: %v", err)
+	}
+	compiled, err := r.CompileModule(ctx, guest)
+	if err != nil {
+		t.Fatalf("Failed to compile guest: %v", err)
+	}
+	if err := CheckHostImports(compiled); err != nil {
+		return 0, err
+	}
+	module, err := r.InstantiateModule(ctx, compiled, wazero.NewModuleConfig())
+	if err != nil {
+		t.Fatalf("Failed to instantiate guest: %v", err)
+	}
+	version, err := NegotiateABI(ctx, compiled, module)
+	module.Close(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	pool := NewWASMInstancePool(ctx, r, compiled, "abifs", PoolConfig{MaxInstances: 1, ABIVersion: version}, nil)
+	t.Cleanup(func() { pool.Close() })
+	instance, err := pool.Acquire()
+	if err != nil {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	defer pool.Release(instance)
+	return instance.ABIVersion(), nil
+}
+
+func TestNegotiateABI(t *testing.T) {
+	tests := []struct {
+		name  string
+		guest []byte
+		want  uint32
+	}{
+		{"declared older", abiGuest(1, false, "host_fs_create"), 1},
+		{"declared current", abiGuest(2, false, "host_fs_create"), 2},
+		{"declared as a global", abiGuest(1, true, "host_fs_create"), 1},
+		{"undeclared", abiGuest(0, false, "host_fs_create"), 1},
+		{"undeclared with newer imports", abiGuest(0, false, "host_fs_create", "host_abi_version"), 2},
+	}
+	for _, tt := range tests {
+		v, err := negotiateABIGuest(t, tt.guest)
+		if err != nil {
+			t.Errorf("%s: NegotiateABI failed: %v", tt.name, err)
+			continue
+		}
+		if v != tt.want {
+			t.Errorf("%s: Expected ABI version %d, got %d", tt.name, tt.want, v)
+		}
+	}
+}
+
+func TestNegotiateABIErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		guest []byte
+		want  string
+	}{
+		{"declared newer", abiGuest(HostABIVersion+1, false), "requires host ABI version 3, this host provides version 2"},
+		{"declared newer as a global", abiGuest(HostABIVersion+1, true), "requires host ABI version 3"},
+		{"declared older than its imports", abiGuest(1, false, "host_abi_version"), "declares host ABI version 1 but imports host functions of version 2"},
+		{"unknown import", abiGuest(0, false, "host_fs_frobnicate"), "imports host function host_fs_frobnicate, which this host (ABI version 2) doesn't provide"},
+	}
+	for _, tt := range tests {
+		_, err := negotiateABIGuest(t, tt.guest)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Expected an error containing %q, got %v", tt.name, tt.want, err)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	log "github.com/sirupsen/logrus"
@@ -338,3 +339,189 @@ func HostFSChmod(ctx context.Context, mod wazeroapi.Module, params []uint64, fs
 
 	return []uint64{0}
 }
+
+// hostError writes err's message to guest memory and returns its pointer, or
+// 1 if the message can't be written, so the guest still sees a failure
+func hostError(mod wazeroapi.Module, err error) uint32 {
+	errPtr, _, werr := writeStringToMemory(mod, err.Error())
+	if werr != nil || errPtr == 0 {
+		return 1
+	}
+	return errPtr
+}
+
+// errNoHostFS is returned to guests when the plugin was loaded without a host filesystem
+var errNoHostFS = errors.New("no host filesystem provided")
+
+func HostFSWriteAt(ctx context.Context, mod wazeroapi.Module, params []uint64, fs filesystem.FileSystem) []uint64 {
+	pathPtr := uint32(params[0])
+	dataPtr := uint32(params[1])
+	dataLen := uint32(params[2])
+	offset := int64(params[3])
+	flags := filesystem.WriteFlag(params[4])
+
+	path, ok := readStringFromMemory(mod, pathPtr)
+	if !ok {
+		return []uint64{1 << 32}
+	}
+	data, ok := mod.Memory().Read(dataPtr, dataLen)
+	if !ok {
+		return []uint64{1 << 32}
+	}
+
+	log.Debugf("host_fs_write_at: path=%s, dataLen=%d, offset=%d, flags=%d", path, dataLen, offset, flags)
+
+	if fs == nil {
+		return []uint64{uint64(hostError(mod, errNoHostFS)) << 32}
+	}
+
+	written, err := fs.Write(path, data, offset, flags)
+	if err != nil {
+		log.Errorf("host_fs_write_at: error writing file: %v", err)
+		return []uint64{uint64(hostError(mod, err)) << 32}
+	}
+
+	// Pack: lower 32 bits = bytes written, upper 32 bits = 0 (no error)
+	return []uint64{uint64(uint32(written))}
+}
+
+func HostFSTruncate(ctx context.Context, mod wazeroapi.Module, params []uint64, fs filesystem.FileSystem) []uint64 {
+	pathPtr := uint32(params[0])
+	size := int64(params[1])
+
+	path, ok := readStringFromMemory(mod, pathPtr)
+	if !ok {
+		return []uint64{1}
+	}
+
+	log.Debugf("host_fs_truncate: path=%s, size=%d", path, size)
+
+	if fs == nil {
+		return []uint64{uint64(hostError(mod, errNoHostFS))}
+	}
+	truncater, ok := fs.(filesystem.Truncater)
+	if !ok {
+		return []uint64{uint64(hostError(mod, filesystem.NewNotSupportedError("truncate", path)))}
+	}
+
+	if err := truncater.Truncate(path, size); err != nil {
+		log.Errorf("host_fs_truncate: error truncating: %v", err)
+		return []uint64{uint64(hostError(mod, err))}
+	}
+
+	return []uint64{0}
+}
+
+func HostFSTouch(ctx context.Context, mod wazeroapi.Module, params []uint64, fs filesystem.FileSystem) []uint64 {
+	pathPtr := uint32(params[0])
+
+	path, ok := readStringFromMemory(mod, pathPtr)
+	if !ok {
+		return []uint64{1}
+	}
+
+	log.Debugf("host_fs_touch: path=%s", path)
+
+	if fs == nil {
+		return []uint64{uint64(hostError(mod, errNoHostFS))}
+	}
+	toucher, ok := fs.(filesystem.Toucher)
+	if !ok {
+		return []uint64{uint64(hostError(mod, filesystem.NewNotSupportedError("touch", path)))}
+	}
+
+	if err := toucher.Touch(path); err != nil {
+		log.Errorf("host_fs_touch: error touching: %v", err)
+		return []uint64{uint64(hostError(mod, err))}
+	}
+
+	return []uint64{0}
+}
+
+func HostFSMkdirAll(ctx context.Context, mod wazeroapi.Module, params []uint64, fs filesystem.FileSystem) []uint64 {
+	pathPtr := uint32(params[0])
+	perm := uint32(params[1])
+
+	path, ok := readStringFromMemory(mod, pathPtr)
+	if !ok {
+		return []uint64{1}
+	}
+
+	log.Debugf("host_fs_mkdir_all: path=%s, perm=%o", path, perm)
+
+	if fs == nil {
+		return []uint64{uint64(hostError(mod, errNoHostFS))}
+	}
+
+	if err := filesystem.MkdirAll(fs, path, perm); err != nil {
+		log.Errorf("host_fs_mkdir_all: error creating directories: %v", err)
+		return []uint64{uint64(hostError(mod, err))}
+	}
+
+	return []uint64{0}
+}
+
+func HostFSSymlink(ctx context.Context, mod wazeroapi.Module, params []uint64, fs filesystem.FileSystem) []uint64 {
+	targetPtr := uint32(params[0])
+	linkPtr := uint32(params[1])
+
+	target, ok := readStringFromMemory(mod, targetPtr)
+	if !ok {
+		return []uint64{1}
+	}
+	link, ok := readStringFromMemory(mod, linkPtr)
+	if !ok {
+		return []uint64{1}
+	}
+
+	log.Debugf("host_fs_symlink: target=%s, link=%s", target, link)
+
+	if fs == nil {
+		return []uint64{uint64(hostError(mod, errNoHostFS))}
+	}
+	symlinker, ok := fs.(filesystem.Symlinker)
+	if !ok {
+		return []uint64{uint64(hostError(mod, filesystem.NewNotSupportedError("symlink", link)))}
+	}
+
+	if err := symlinker.Symlink(target, link); err != nil {
+		log.Errorf("host_fs_symlink: error creating symlink: %v", err)
+		return []uint64{uint64(hostError(mod, err))}
+	}
+
+	return []uint64{0}
+}
+
+func HostFSReadlink(ctx context.Context, mod wazeroapi.Module, params []uint64, fs filesystem.FileSystem) []uint64 {
+	pathPtr := uint32(params[0])
+
+	path, ok := readStringFromMemory(mod, pathPtr)
+	if !ok {
+		return []uint64{1 << 32}
+	}
+
+	log.Debugf("host_fs_readlink: path=%s", path)
+
+	if fs == nil {
+		return []uint64{uint64(hostError(mod, errNoHostFS)) << 32}
+	}
+	symlinker, ok := fs.(filesystem.Symlinker)
+	if !ok {
+		return []uint64{uint64(hostError(mod, filesystem.NewNotSupportedError("readlink", path))) << 32}
+	}
+
+	target, err := symlinker.Readlink(path)
+	if err != nil {
+		log.Errorf("host_fs_readlink: error reading symlink: %v", err)
+		return []uint64{uint64(hostError(mod, err)) << 32}
+	}
+
+	targetPtr, _, err := writeStringToMemory(mod, target)
+	if err != nil {
+		log.Errorf("host_fs_readlink: failed to write target to memory: %v", err)
+		return []uint64{1 << 32}
+	}
+
+	// Pack: lower 32 bits = target pointer, upper 32 bits = 0 (no error)
+	return []uint64{uint64(targetPtr)}
+}
@@ -3,11 +3,14 @@ package api
 import (
 	"fmt"
 	"unsafe"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
 )
 
 // ExternalPlugin represents a dynamically loaded plugin from a shared library
 // This bridges the C-compatible API with Go's ServicePlugin interface
 type ExternalPlugin struct {
+	plugin.BasePlugin
 	libHandle   uintptr
 	pluginPtr   unsafe.Pointer
 	name        string
@@ -0,0 +1,74 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// pluginLogWriter turns every line a plugin writes to stdout or stderr into a
+// log entry, so plugin output follows the server's log level and format
+type pluginLogWriter struct {
+	entry *log.Entry
+	level log.Level
+	mu    sync.Mutex
+	buf   []byte
+
+	// requestID holds the ID of the request the plugin is serving, as a
+	// string, which entries are tagged with (nil = none)
+	requestID *atomic.Value
+}
+
+// NewPluginLogWriter returns a writer that logs each line written to it at
+// level, tagged with the plugin and stream name. Use it as a WASM module's
+// stdout and stderr.
+func NewPluginLogWriter(logger *log.Logger, pluginName, stream string, level log.Level) io.Writer {
+	return newPluginLogWriter(logger, pluginName, stream, level, nil)
+}
+
+// newPluginLogWriter is NewPluginLogWriter, also tagging each entry with the
+// request ID in requestID, if any
+func newPluginLogWriter(logger *log.Logger, pluginName, stream string, level log.Level, requestID *atomic.Value) *pluginLogWriter {
+	return &pluginLogWriter{
+		entry:     logger.WithFields(log.Fields{"plugin": pluginName, "stream": stream}),
+		level:     level,
+		requestID: requestID,
+	}
+}
+
+// log logs line, with the current request ID
+func (w *pluginLogWriter) log(line string) {
+	entry := w.entry
+	if w.requestID != nil {
+		if id, _ := w.requestID.Load().(string); id != "" {
+			entry = entry.WithField("request_id", id)
+		}
+	}
+	entry.Log(w.level, line)
+}
+
+func (w *pluginLogWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := bytes.TrimRight(w.buf[:i], "\r"); len(line) > 0 {
+			w.log(string(line))
+		}
+		w.buf = w.buf[i+1:]
+	}
+	// Don't let a plugin that never writes a newline grow the buffer forever
+	if len(w.buf) >= 64*1024 {
+		w.log(string(w.buf))
+		w.buf = w.buf[:0]
+	}
+	return len(p), nil
+}
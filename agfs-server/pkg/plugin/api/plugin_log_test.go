@@ -0,0 +1,76 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func TestPluginLogWriterLogsLines(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.JSONFormatter{})
+
+	w := NewPluginLogWriter(logger, "hellofs", "stderr", log.WarnLevel)
+	w.Write([]byte("first line\nsecond "))
+	w.Write([]byte("line\r\n\n"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %q", len(lines), buf.String())
+	}
+	for i, want := range []string{"first line", "second line"} {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("Invalid JSON entry %q: %v", lines[i], err)
+		}
+		if entry["msg"] != want || entry["plugin"] != "hellofs" || entry["stream"] != "stderr" || entry["level"] != "warning" {
+			t.Errorf("Unexpected entry %v", entry)
+		}
+	}
+}
+
+func TestPluginLogWriterHonorsLevel(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetLevel(log.WarnLevel)
+
+	NewPluginLogWriter(logger, "hellofs", "stdout", log.InfoLevel).Write([]byte("hidden\n"))
+	if buf.Len() != 0 {
+		t.Errorf("Expected info output to be dropped at warn level, got %q", buf.String())
+	}
+}
+
+func TestPluginLogWriterTagsRequests(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.SetOutput(&buf)
+	logger.SetFormatter(&log.JSONFormatter{})
+
+	requestID := new(atomic.Value)
+	w := newPluginLogWriter(logger, "hellofs", "stdout", log.InfoLevel, requestID)
+	requestID.Store("5f1c0a93")
+	w.Write([]byte("serving\n"))
+	requestID.Store("")
+	w.Write([]byte("idle\n"))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 entries, got %d: %q", len(lines), buf.String())
+	}
+	for i, want := range []interface{}{"5f1c0a93", nil} {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &entry); err != nil {
+			t.Fatalf("Invalid JSON entry %q: %v", lines[i], err)
+		}
+		if entry["request_id"] != want {
+			t.Errorf("Expected the request ID %v in %v", want, entry)
+		}
+	}
+}
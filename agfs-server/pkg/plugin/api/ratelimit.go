@@ -0,0 +1,64 @@
+package api
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// RateLimit paces calls into a plugin with a token bucket
+type RateLimit struct {
+	OpsPerSec float64       // Sustained calls per second (0 = unlimited)
+	Burst     int           // Calls admitted at once on top of the rate (default: OpsPerSec rounded up, at least 1)
+	MaxWait   time.Duration // How long a call may wait for its turn before it is rejected (0 = reject immediately)
+}
+
+// tokenBucket admits calls at rate per second with bursts of up to burst
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// newTokenBucket returns a full bucket for limit, or nil if limit is unlimited
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.OpsPerSec <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.OpsPerSec))
+	}
+	return &tokenBucket{
+		rate:   limit.OpsPerSec,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// reserve takes a token and returns how long the caller must wait before
+// proceeding. If the wait would exceed maxWait no token is taken, ok is false
+// and wait is how long until a token would be available.
+func (b *tokenBucket) reserve(maxWait time.Duration) (wait time.Duration, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	// Tokens go negative for callers already queued behind a reservation
+	if b.tokens < 1 {
+		wait = time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+		if wait > maxWait {
+			return wait, false
+		}
+	}
+	b.tokens--
+	return wait, true
+}
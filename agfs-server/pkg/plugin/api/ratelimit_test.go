@@ -0,0 +1,154 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/tetratelabs/wazero"
+)
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	if b := newTokenBucket(RateLimit{}); b != nil {
+		t.Errorf("Expected nil bucket without a rate, got %+v", b)
+	}
+}
+
+func TestTokenBucketPacing(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := newTokenBucket(RateLimit{OpsPerSec: 10, Burst: 2})
+	b.now = func() time.Time { return now }
+	b.last = now
+
+	// The burst is admitted immediately
+	for i := 0; i < 2; i++ {
+		if wait, ok := b.reserve(0); !ok || wait != 0 {
+			t.Fatalf("Expected call %d admitted without waiting, got wait=%v ok=%v", i, wait, ok)
+		}
+	}
+
+	// Without a max wait the next call is rejected and no token is taken
+	if wait, ok := b.reserve(0); ok || wait != 100*time.Millisecond {
+		t.Errorf("Expected rejection with retry after 100ms, got wait=%v ok=%v", wait, ok)
+	}
+
+	// Queued callers wait one interval more than the caller before them
+	for i, want := range []time.Duration{100 * time.Millisecond, 200 * time.Millisecond} {
+		if wait, ok := b.reserve(time.Second); !ok || wait != want {
+			t.Errorf("Expected queued call %d to wait %v, got wait=%v ok=%v", i, want, wait, ok)
+		}
+	}
+	if _, ok := b.reserve(250 * time.Millisecond); ok {
+		t.Error("Expected rejection when the queue exceeds the max wait")
+	}
+
+	// Tokens refill at the configured rate, up to the burst
+	now = now.Add(10 * time.Second)
+	for i := 0; i < 2; i++ {
+		if wait, ok := b.reserve(0); !ok || wait != 0 {
+			t.Fatalf("Expected call %d admitted after refill, got wait=%v ok=%v", i, wait, ok)
+		}
+	}
+	if _, ok := b.reserve(0); ok {
+		t.Error("Expected refill to be capped at the burst")
+	}
+}
+
+func TestTokenBucketDefaultBurst(t *testing.T) {
+	b := newTokenBucket(RateLimit{OpsPerSec: 2.5})
+	if b.burst != 3 {
+		t.Errorf("Expected default burst 3, got %v", b.burst)
+	}
+	b = newTokenBucket(RateLimit{OpsPerSec: 0.5})
+	if b.burst != 1 {
+		t.Errorf("Expected default burst 1, got %v", b.burst)
+	}
+}
+
+// newTestPool returns a pool over an empty WASM module
+func newTestPool(t *testing.T, pluginName string, config PoolConfig) *WASMInstancePool {
+	t.Helper()
+	ctx := context.Background()
+	runtime := wazero.NewRuntime(ctx)
+	t.Cleanup(func() { runtime.Close(ctx) })
+
+	compiled, err := runtime.CompileModule(ctx, []byte("\x00asm\x01\x00\x00\x00"))
+	if err != nil {
+		t.Fatalf("Failed to compile module: %v", err)
+	}
+	pool := NewWASMInstancePool(ctx, runtime, compiled, pluginName, config, nil)
+	t.Cleanup(func() { pool.Close() })
+	return pool
+}
+
+func TestPoolRateLimitPacesCalls(t *testing.T) {
+	pool := newTestPool(t, "slowfs", PoolConfig{
+		MaxInstances:     4,
+		RateLimit:        RateLimit{OpsPerSec: 1000},
+		PluginRateLimits: map[string]RateLimit{"slowfs": {OpsPerSec: 50, Burst: 5, MaxWait: time.Second}},
+	})
+
+	// 15 calls at 50/sec with a burst of 5 take at least 10 intervals of 20ms
+	const calls = 15
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- pool.Execute(func(*WASMModuleInstance) error { return nil })
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			t.Errorf("Expected every call to be admitted, got %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 180*time.Millisecond {
+		t.Errorf("Expected calls to be paced over at least 180ms, took %v", elapsed)
+	}
+	stats := pool.GetStats()
+	if stats.RateLimitWaits != calls-5 {
+		t.Errorf("Expected %d delayed calls, got %d", calls-5, stats.RateLimitWaits)
+	}
+	if stats.RateLimited != 0 {
+		t.Errorf("Expected no rejected calls, got %d", stats.RateLimited)
+	}
+}
+
+func TestPoolRateLimitRejects(t *testing.T) {
+	pool := newTestPool(t, "s3fs", PoolConfig{
+		MaxInstances: 2,
+		RateLimit:    RateLimit{OpsPerSec: 1, Burst: 3},
+	})
+
+	var rejected int
+	for i := 0; i < 10; i++ {
+		err := pool.ExecuteFS(func(filesystem.FileSystem) error { return nil })
+		if err == nil {
+			continue
+		}
+		rejected++
+		if !errors.Is(err, filesystem.ErrRateLimited) {
+			t.Fatalf("Expected ErrRateLimited, got %v", err)
+		}
+		var rle *filesystem.RateLimitedError
+		if !errors.As(err, &rle) || rle.Resource != "s3fs" || rle.RetryAfter <= 0 {
+			t.Errorf("Expected rate limit error for s3fs with a retry delay, got %v", err)
+		}
+	}
+
+	if rejected != 7 {
+		t.Errorf("Expected 7 rejected calls, got %d", rejected)
+	}
+	if stats := pool.GetStats(); stats.RateLimited != 7 {
+		t.Errorf("Expected 7 rate limited calls in stats, got %d", stats.RateLimited)
+	}
+}
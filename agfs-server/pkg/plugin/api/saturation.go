@@ -0,0 +1,73 @@
+package api
+
+import (
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// SaturationEvent reports a pool becoming saturated, with every instance busy
+// and a request waiting for one, or recovering once no request waits any more
+type SaturationEvent struct {
+	Plugin    string
+	Saturated bool          // true when saturation starts, false on recovery
+	Duration  time.Duration // Length of the saturation episode, set on recovery
+}
+
+// Utilization returns the fraction of MaxInstances busy serving a request,
+// between 0 and 1. Idle instances, including those parked for a key, don't
+// count.
+func (p *WASMInstancePool) Utilization() float64 {
+	p.mu.Lock()
+	busy := p.currentInstances - len(p.instances) - len(p.affinity)
+	p.mu.Unlock()
+
+	if busy <= 0 {
+		return 0
+	}
+	return float64(busy) / float64(p.config.MaxInstances)
+}
+
+// startWaiting records a request waiting for an instance, starting a
+// saturation episode if none is under way
+func (p *WASMInstancePool) startWaiting() {
+	p.mu.Lock()
+	p.waiting++
+	start := p.saturatedAt.IsZero()
+	if start {
+		p.saturatedAt = time.Now()
+	}
+	p.mu.Unlock()
+
+	if start {
+		log.Debugf("WASM pool for %s is saturated", p.pluginName)
+		if p.config.OnSaturation != nil {
+			p.config.OnSaturation(SaturationEvent{Plugin: p.pluginName, Saturated: true})
+		}
+	}
+}
+
+// stopWaiting records a request done waiting, with or without an instance
+func (p *WASMInstancePool) stopWaiting() {
+	p.mu.Lock()
+	p.waiting--
+	p.mu.Unlock()
+}
+
+// checkRecovered ends the saturation episode once an instance was freed and
+// no request waits any more
+func (p *WASMInstancePool) checkRecovered() {
+	p.mu.Lock()
+	if p.saturatedAt.IsZero() || p.waiting > 0 {
+		p.mu.Unlock()
+		return
+	}
+	duration := time.Since(p.saturatedAt)
+	p.saturatedAt = time.Time{}
+	p.mu.Unlock()
+
+	log.Debugf("WASM pool for %s recovered after %v of saturation", p.pluginName, duration)
+	if p.config.OnSaturation != nil {
+		p.config.OnSaturation(SaturationEvent{Plugin: p.pluginName, Duration: duration})
+	}
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPoolSaturationEvents(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []SaturationEvent
+	)
+	pool := newTestPool(t, "busyfs", PoolConfig{
+		MaxInstances:     2,
+		EnableStatistics: true,
+		OnSaturation: func(event SaturationEvent) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		},
+	})
+
+	// Exhaust the pool, then queue a burst of requests behind it
+	held := make([]*WASMModuleInstance, 2)
+	for i := range held {
+		instance, err := pool.Acquire()
+		if err != nil {
+			t.Fatalf("Acquire failed: %v", err)
+		}
+		held[i] = instance
+	}
+	if u := pool.Utilization(); u != 1 {
+		t.Errorf("Expected a utilization of 1 with every instance busy, got %g", u)
+	}
+
+	const burst = 8
+	var wg sync.WaitGroup
+	for i := 0; i < burst; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			instance, err := pool.Acquire()
+			if err != nil {
+				t.Errorf("Acquire failed: %v", err)
+				return
+			}
+			time.Sleep(time.Millisecond)
+			pool.Release(instance)
+		}()
+	}
+	for pool.GetStats().TotalWaits < burst {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	for _, instance := range held {
+		pool.Release(instance)
+	}
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 {
+		t.Fatalf("Expected one saturation and one recovery, got %+v", events)
+	}
+	if !events[0].Saturated || events[0].Plugin != "busyfs" {
+		t.Errorf("Expected the saturation of busyfs first, got %+v", events[0])
+	}
+	if events[1].Saturated || events[1].Duration < 10*time.Millisecond {
+		t.Errorf("Expected a recovery after at least 10ms, got %+v", events[1])
+	}
+	if u := pool.Utilization(); u != 0 {
+		t.Errorf("Expected a utilization of 0 once idle, got %g", u)
+	}
+}
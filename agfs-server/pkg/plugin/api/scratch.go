@@ -0,0 +1,125 @@
+package api
+
+import (
+	"context"
+
+	wazeroapi "github.com/tetratelabs/wazero/api"
+)
+
+const (
+	// minScratchSize is the initial size of an instance's scratch region
+	minScratchSize = 4096
+
+	// maxScratchSize bounds the scratch region; larger arguments get guest
+	// memory of their own
+	maxScratchSize = 64 * 1024
+)
+
+// scratchMemory is a region of guest memory an instance keeps to pass the
+// arguments of its calls, such as paths, saving the guest a malloc and a free
+// per argument. Arguments are carved from the region for the duration of a
+// call and only borrowed by the guest, which never frees them; the region
+// is handed out again once every argument taken from it was released.
+// Instances are single-threaded, so it needs no locking.
+type scratchMemory struct {
+	module wazeroapi.Module
+	ptr    uint32 // Region allocated with the guest's malloc (0 = none yet)
+	size   uint32
+	used   uint32 // Bytes handed out since the region was last empty
+	live   int    // Arguments handed out and not released yet
+
+	mallocs int64 // Guest mallocs made for the region
+}
+
+func newScratchMemory(module wazeroapi.Module) *scratchMemory {
+	return &scratchMemory{module: module}
+}
+
+// alloc returns n bytes of the region, growing it if it is empty and too
+// small. It reports false if the arguments of the current call fill it or
+// n is too large, in which case the caller allocates guest memory itself.
+func (s *scratchMemory) alloc(n uint32) (uint32, bool) {
+	if s == nil || n == 0 || n > maxScratchSize {
+		return 0, false
+	}
+	n = (n + 7) &^ 7 // Keep arguments 8-byte aligned
+	if s.used+n > s.size {
+		if s.live > 0 || !s.grow(n) {
+			return 0, false
+		}
+	}
+	ptr := s.ptr + s.used
+	s.used += n
+	s.live++
+	return ptr, true
+}
+
+// grow replaces the empty region by one of at least n bytes
+func (s *scratchMemory) grow(n uint32) bool {
+	size := s.size * 2
+	if size < minScratchSize {
+		size = minScratchSize
+	}
+	for size < n {
+		size *= 2
+	}
+	if size > maxScratchSize {
+		size = maxScratchSize
+	}
+
+	malloc := s.module.ExportedFunction("malloc")
+	if malloc == nil {
+		return false
+	}
+	results, err := malloc.Call(context.Background(), uint64(size))
+	if err != nil || len(results) == 0 || uint32(results[0]) == 0 {
+		return false
+	}
+	s.mallocs++
+	if s.ptr != 0 {
+		freeWASMMemory(s.module, s.ptr, s.size)
+	}
+	s.ptr, s.size, s.used = uint32(results[0]), size, 0
+	return true
+}
+
+// owns reports whether ptr was handed out from the region
+func (s *scratchMemory) owns(ptr uint32) bool {
+	return s != nil && s.ptr != 0 && ptr >= s.ptr && ptr < s.ptr+s.size
+}
+
+// release gives an argument back, emptying the region once the last one of
+// the call is released
+func (s *scratchMemory) release() {
+	if s.live--; s.live == 0 {
+		s.used = 0
+	}
+}
+
+// writeArg writes data to guest memory as an argument of a call, to the
+// scratch region if it fits, or else like writeBytesToMemoryWithBuffer.
+// Arguments must be released with freeArg once the call returned.
+func (wfs *WASMFileSystem) writeArg(data []byte, bufInfo *SharedBufferInfo) (ptr uint32, size uint32, err error) {
+	size = uint32(len(data))
+	if ptr, ok := wfs.scratch.alloc(size); ok {
+		if wfs.module.Memory().Write(ptr, data) {
+			return ptr, size, nil
+		}
+		wfs.scratch.release()
+	}
+	return writeBytesToMemoryWithBuffer(wfs.module, data, bufInfo)
+}
+
+// writeStringArg writes s, NUL-terminated, like writeArg
+func (wfs *WASMFileSystem) writeStringArg(s string, bufInfo *SharedBufferInfo) (ptr uint32, size uint32, err error) {
+	return wfs.writeArg(append([]byte(s), 0), bufInfo)
+}
+
+// freeArg releases an argument written with writeArg
+func (wfs *WASMFileSystem) freeArg(ptr uint32, size uint32, bufInfo *SharedBufferInfo) {
+	if wfs.scratch.owns(ptr) {
+		wfs.scratch.release()
+		return
+	}
+	freeWASMMemoryWithBuffer(wfs.module, ptr, size, bufInfo)
+}
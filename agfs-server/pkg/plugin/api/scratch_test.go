@@ -0,0 +1,167 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tetratelabs/wazero"
+)
+
+// statGuest is a guest whose fs_stat reports a 4-byte file named "file" for
+// any path, and whose malloc counts its calls in the exported global
+// mallocs. malloc hands out the same region every time, which is enough for
+// the single argument of a stat; free does nothing.
+func statGuest() []byte {
+	const (
+		i32 = 0x7f
+		i64 = 0x7e
+	)
+	types := wasmSection(1, concat(
+		[]byte{3},
+		[]byte{0x60, 1, i32, 1, i32}, // 0: malloc(size) -> ptr
+		[]byte{0x60, 2, i32, i32, 0}, // 1: free(ptr, size)
+		[]byte{0x60, 1, i32, 1, i64}, // 2: fs_stat(path) -> packed
+	)...)
+	functions := wasmSection(3, 3, 0, 1, 2)
+	memory := wasmSection(5, 1, 0x00, 1)
+	globals := wasmSection(6, 1, i32, 1, 0x41, 0, 0x0b) // mallocs = 0
+	exports := wasmSection(7, concat(
+		[]byte{5},
+		wasmName("memory"), []byte{0x02, 0},
+		wasmName("malloc"), []byte{0x00, 0},
+		wasmName("free"), []byte{0x00, 1},
+		wasmName("fs_stat"), []byte{0x00, 2},
+		wasmName("mallocs"), []byte{0x03, 0},
+	)...)
+	code := wasmSection(10, concat(
+		[]byte{3},
+		// mallocs++; return 1024
+		[]byte{12, 0, 0x23, 0, 0x41, 1, 0x6a, 0x24, 0, 0x41, 0x80, 0x08, 0x0b},
+		[]byte{2, 0, 0x0b},
+		// return the JSON at 64, no error
+		[]byte{5, 0, 0x42, 0xc0, 0x00, 0x0b},
+	)...)
+	data := wasmSection(11, concat(
+		[]byte{1},
+		[]byte{0x00, 0x41, 0xc0, 0x00, 0x0b}, wasmName(`{"name":"file","size":4}`+"\x00"),
+	)...)
+	return concat([]byte("\x00asm\x01\x00\x00\x00"), types, functions, memory, globals, exports, code, data)
+}
+
+// newStatGuest returns an instance of statGuest
+func newStatGuest(tb testing.TB) *WASMModuleInstance {
+	tb.Helper()
+	ctx := context.Background()
+	r := wazero.NewRuntime(ctx)
+	tb.Cleanup(func() { r.Close(ctx) })
+	compiled, err := r.CompileModule(ctx, statGuest())
+	if err != nil {
+		tb.Fatalf("Failed to compile guest: %v", err)
+	}
+	pool := NewWASMInstancePool(ctx, r, compiled, "statfs", PoolConfig{MaxInstances: 1}, nil)
+	tb.Cleanup(func() { pool.Close() })
+	instance, err := pool.Acquire()
+	if err != nil {
+		tb.Fatalf("Acquire failed: %v", err)
+	}
+	return instance
+}
+
+// guestMallocs returns how many times the guest's malloc was called
+func guestMallocs(instance *WASMModuleInstance) uint64 {
+	return instance.module.ExportedGlobal("mallocs").Get()
+}
+
+func TestScratchStat(t *testing.T) {
+	instance := newStatGuest(t)
+	for i := 0; i < 100; i++ {
+		info, err := instance.fileSystem.Stat("/some/file")
+		if err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+		if info.Name != "file" || info.Size != 4 {
+			t.Fatalf("Expected the guest's file info, got %+v", info)
+		}
+	}
+	if n := guestMallocs(instance); n != 1 {
+		t.Errorf("Expected the scratch region to be the only guest malloc, got %d", n)
+	}
+
+	// Without a scratch region every call allocates its argument
+	instance.fileSystem.scratch = nil
+	before := guestMallocs(instance)
+	for i := 0; i < 10; i++ {
+		if _, err := instance.fileSystem.Stat("/some/file"); err != nil {
+			t.Fatalf("Stat failed: %v", err)
+		}
+	}
+	if n := guestMallocs(instance) - before; n != 10 {
+		t.Errorf("Expected a guest malloc per call without scratch, got %d", n)
+	}
+}
+
+func TestScratchNotAliased(t *testing.T) {
+	s := newScratchMemory(newStatGuest(t).module)
+
+	// Arguments of the same call never overlap, and aren't reused until
+	// the last one is released
+	a, ok := s.alloc(10)
+	if !ok {
+		t.Fatal("Expected the first argument to fit")
+	}
+	b, ok := s.alloc(100)
+	if !ok || b < a+10 {
+		t.Fatalf("Expected a second argument after the first, got %d and %d (%v)", a, b, ok)
+	}
+	s.release()
+	if c, ok := s.alloc(10); !ok || c <= b {
+		t.Errorf("Expected the region not to be reused while an argument is live, got %d after %d", c, b)
+	} else {
+		s.release()
+	}
+	s.release()
+	if c, ok := s.alloc(10); !ok || c != a {
+		t.Errorf("Expected the region to be reused once empty, got %d, want %d", c, a)
+	} else {
+		s.release()
+	}
+
+	// The region grows when empty, and large arguments don't use it
+	if _, ok := s.alloc(minScratchSize * 2); !ok {
+		t.Error("Expected the empty region to grow")
+	} else {
+		s.release()
+	}
+	if _, ok := s.alloc(maxScratchSize + 1); ok {
+		t.Error("Expected an argument beyond the maximum not to use the region")
+	}
+	s.alloc(8)
+	if _, ok := s.alloc(s.size); ok {
+		t.Error("Expected the region not to grow while an argument is live")
+	}
+}
+
+// BenchmarkScratchStat runs small Stat calls in a tight loop, reporting the
+// guest mallocs made per call
+func BenchmarkScratchStat(b *testing.B) {
+	for _, scratch := range []bool{true, false} {
+		name := "scratch"
+		if !scratch {
+			name = "malloc"
+		}
+		b.Run(name, func(b *testing.B) {
+			instance := newStatGuest(b)
+			if !scratch {
+				instance.fileSystem.scratch = nil
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := instance.fileSystem.Stat("/some/file"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(guestMallocs(instance))/float64(b.N), "guest-mallocs/op")
+		})
+	}
+}
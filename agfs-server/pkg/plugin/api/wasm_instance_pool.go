@@ -2,14 +2,19 @@ package api
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
 	log "github.com/sirupsen/logrus"
 	"github.com/tetratelabs/wazero"
 	wazeroapi "github.com/tetratelabs/wazero/api"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // PoolConfig contains configuration for the instance pool
@@ -20,6 +25,43 @@ type PoolConfig struct {
 	HealthCheckInterval time.Duration // Health check interval (0 = disabled)
 	AcquireTimeout      time.Duration // Timeout for acquiring instance (0 = unlimited, default 30s)
 	EnableStatistics    bool          // Enable statistics collection
+	Tracer              trace.Tracer  // Records a span per Execute call (nil = tracing disabled)
+	ABIVersion          uint32        // Host ABI version negotiated with the plugin at load, see NegotiateABI
+
+	// RateLimit paces calls into every plugin without an entry in
+	// PluginRateLimits, which is keyed by plugin name
+	RateLimit        RateLimit
+	PluginRateLimits map[string]RateLimit
+
+	// AffinityMaxInstances bounds how many idle instances AcquireFor keeps
+	// parked for their key (0 = affinity disabled). Parked instances return
+	// to the general pool after AffinityIdleTimeout (default 30s).
+	AffinityMaxInstances int
+	AffinityIdleTimeout  time.Duration
+
+	// After UnhealthyAfter consecutive failures to create an instance
+	// (default 5), the pool is unhealthy: Acquire fails right away rather
+	// than create one, except for a recovery attempt every RecoveryInterval
+	// (default 30s)
+	UnhealthyAfter   int
+	RecoveryInterval time.Duration
+
+	// BurstMax, if above MaxInstances, lets a request that finds every
+	// instance busy create a temporary instance instead of waiting, as long
+	// as fewer than BurstMax instances exist in all. Burst instances are
+	// destroyed when released rather than pooled, so a spike doesn't grow
+	// the pool for good.
+	BurstMax int
+
+	// Health reports the pool not ready once it has been saturated, every
+	// instance busy and requests waiting, for longer than UnreadyAfter
+	// (default 30s)
+	UnreadyAfter time.Duration
+
+	// OnSaturation, if set, is called when every instance is busy and a
+	// request has to wait, and again when the pool recovers. It is called
+	// synchronously on the request's path and must not block.
+	OnSaturation func(SaturationEvent)
 }
 
 // WASMInstancePool manages a pool of WASM module instances for concurrent access
@@ -32,9 +74,26 @@ type WASMInstancePool struct {
 	config           PoolConfig
 	instances        chan *WASMModuleInstance
 	currentInstances int
+	burstInstances   int // burst instances in use, guarded by mu
 	mu               sync.Mutex
 	stats            PoolStats
+	statsMu          sync.Mutex
 	closed           bool
+	limiter          *tokenBucket // nil = unlimited
+	maxRateWait      time.Duration
+	affinity         map[string]*parkedInstance // idle instances held for a key, guarded by mu
+	waiting          int                        // requests waiting for an instance, guarded by mu
+	saturatedAt      time.Time                  // start of the saturation episode (zero = none), guarded by mu
+	failures         int                        // consecutive instantiation failures, guarded by mu
+	lastFailure      error                      // the last of those, guarded by mu
+	nextRecovery     time.Time                  // next recovery attempt while unhealthy (zero = healthy), guarded by mu
+	recovering       bool                       // a recovery attempt is under way, guarded by mu
+}
+
+// parkedInstance is an idle instance held for the key it last served
+type parkedInstance struct {
+	instance *WASMModuleInstance
+	timer    *time.Timer
 }
 
 // PoolStats tracks pool usage statistics
@@ -45,7 +104,15 @@ type PoolStats struct {
 	TotalWaits     int64
 	TotalRequests  int64
 	FailedRequests int64
-	mu             sync.Mutex
+	RateLimitWaits int64 // Requests delayed by the rate limiter
+	RateLimited    int64 // Requests rejected by the rate limiter
+	AffinityHits   int64 // AcquireFor calls served by the instance parked for their key
+	AffinityMisses int64 // AcquireFor calls served from the general pool
+	BurstCreated   int64 // Burst instances created, not counted in TotalCreated
+	BurstActive    int64 // Burst instances in use, not counted in CurrentActive
+
+	Unhealthy           bool  // Instances fail to be created, see PoolConfig.UnhealthyAfter
+	ConsecutiveFailures int64 // Instantiation failures since the last success
 }
 
 // SharedBufferInfo holds information about shared memory buffers
@@ -61,9 +128,20 @@ type WASMModuleInstance struct {
 	module       wazeroapi.Module
 	fileSystem   *WASMFileSystem
 	sharedBuffer SharedBufferInfo
+	abiVersion   uint32 // Host ABI version negotiated with the plugin
 	createdAt    time.Time
 	requestCount int64 // Number of requests handled by this instance
+	burst        bool  // Created beyond MaxInstances, destroyed on release
 	mu           sync.Mutex
+
+	// requestID holds the ID of the request the instance is serving, which
+	// the plugin's output is logged with (nil = not tracked)
+	requestID *atomic.Value
+}
+
+// ABIVersion returns the host ABI version negotiated with the plugin
+func (i *WASMModuleInstance) ABIVersion() uint32 {
+	return i.abiVersion
 }
 
 // NewWASMInstancePool creates a new WASM instance pool with configuration
@@ -77,6 +155,26 @@ func NewWASMInstancePool(ctx context.Context, runtime wazero.Runtime, compiledMo
 	if config.AcquireTimeout == 0 {
 		config.AcquireTimeout = 30 * time.Second // default to 30 second timeout
 	}
+	if config.AffinityMaxInstances > 0 && config.AffinityIdleTimeout <= 0 {
+		config.AffinityIdleTimeout = 30 * time.Second
+	}
+	if config.BurstMax < config.MaxInstances {
+		config.BurstMax = config.MaxInstances
+	}
+	if config.UnhealthyAfter <= 0 {
+		config.UnhealthyAfter = 5
+	}
+	if config.RecoveryInterval <= 0 {
+		config.RecoveryInterval = 30 * time.Second
+	}
+	if config.UnreadyAfter <= 0 {
+		config.UnreadyAfter = 30 * time.Second
+	}
+
+	rateLimit, ok := config.PluginRateLimits[pluginName]
+	if !ok {
+		rateLimit = config.RateLimit
+	}
 
 	pool := &WASMInstancePool{
 		ctx:            ctx,
@@ -86,10 +184,20 @@ func NewWASMInstancePool(ctx context.Context, runtime wazero.Runtime, compiledMo
 		pluginName:     pluginName,
 		config:         config,
 		instances:      make(chan *WASMModuleInstance, config.MaxInstances),
+		limiter:        newTokenBucket(rateLimit),
+		maxRateWait:    rateLimit.MaxWait,
+		affinity:       make(map[string]*parkedInstance),
 	}
 
 	log.Infof("Created WASM instance pool for %s (max_instances=%d, max_lifetime=%v, max_requests=%d)",
 		pluginName, config.MaxInstances, config.InstanceMaxLifetime, config.InstanceMaxRequests)
+	if config.BurstMax > config.MaxInstances {
+		log.Infof("Allowing %s up to %d instances during bursts", pluginName, config.BurstMax)
+	}
+	if pool.limiter != nil {
+		log.Infof("Rate limiting %s to %g ops/sec (burst %g, max wait %v)",
+			pluginName, pool.limiter.rate, pool.limiter.burst, rateLimit.MaxWait)
+	}
 
 	// Start health check goroutine if enabled
 	if config.HealthCheckInterval > 0 {
@@ -128,23 +236,204 @@ func (p *WASMInstancePool) performHealthCheck() {
 		p.pluginName, p.currentInstances, p.config.MaxInstances)
 }
 
-// Acquire gets an instance from the pool or creates a new one if available
+// Acquire gets an instance from the pool or creates a new one if available.
+// With a rate limit, it first waits up to the configured maximum for its turn
+// and fails with a filesystem.RateLimitedError if it would have to wait longer.
 func (p *WASMInstancePool) Acquire() (*WASMModuleInstance, error) {
+	return p.acquireFor(context.Background(), "")
+}
+
+// AcquireContext is like Acquire, but gives up waiting for an instance once
+// ctx is done. When ctx's deadline passes first it fails with
+// ErrAcquireTimeout, like a wait running out AcquireTimeout.
+func (p *WASMInstancePool) AcquireContext(ctx context.Context) (*WASMModuleInstance, error) {
+	return p.acquireFor(ctx, "")
+}
+
+// ErrAcquireTimeout is returned when no instance became available in time.
+// It matches context.DeadlineExceeded, so callers handle it like their own
+// deadline passing.
+var ErrAcquireTimeout = fmt.Errorf("timeout waiting for available WASM instance: %w", context.DeadlineExceeded)
+
+// admit checks that the pool is open and applies the rate limit, giving up
+// when ctx is done
+func (p *WASMInstancePool) admit(ctx context.Context) error {
 	// Check if pool is closed
 	p.mu.Lock()
 	if p.closed {
 		p.mu.Unlock()
-		return nil, fmt.Errorf("instance pool is closed")
+		return fmt.Errorf("instance pool is closed")
 	}
 	p.mu.Unlock()
 
 	// Increment request counter if statistics enabled
 	if p.config.EnableStatistics {
-		p.stats.mu.Lock()
+		p.statsMu.Lock()
 		p.stats.TotalRequests++
-		p.stats.mu.Unlock()
+		p.statsMu.Unlock()
+	}
+
+	if p.limiter != nil {
+		wait, ok := p.limiter.reserve(p.maxRateWait)
+		if !ok {
+			p.statsMu.Lock()
+			p.stats.RateLimited++
+			p.statsMu.Unlock()
+			return filesystem.NewRateLimitedError(p.pluginName, wait)
+		}
+		if wait > 0 {
+			p.statsMu.Lock()
+			p.stats.RateLimitWaits++
+			p.statsMu.Unlock()
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			}
+		}
+	}
+
+	return nil
+}
+
+// AcquireFor is like Acquire, but prefers the instance last released for key
+// with ReleaseFor, so a plugin that keeps per-session state (an open cursor,
+// a transaction) sees the same session's calls on the same instance. When
+// that instance is busy, gone or due for recycling, it falls back to the
+// general pool. Without affinity configured it is Acquire.
+func (p *WASMInstancePool) AcquireFor(key string) (*WASMModuleInstance, error) {
+	return p.acquireFor(context.Background(), key)
+}
+
+// acquireFor implements AcquireFor, giving up when ctx is done
+func (p *WASMInstancePool) acquireFor(ctx context.Context, key string) (*WASMModuleInstance, error) {
+	if err := p.admit(ctx); err != nil {
+		return nil, err
+	}
+	if key == "" || p.config.AffinityMaxInstances <= 0 {
+		return p.acquire(ctx)
+	}
+
+	if instance := p.unpark(key); instance != nil {
+		if !p.shouldRecycleInstance(instance) {
+			instance.mu.Lock()
+			instance.requestCount++
+			instance.mu.Unlock()
+
+			if p.config.EnableStatistics {
+				p.statsMu.Lock()
+				p.stats.AffinityHits++
+				p.statsMu.Unlock()
+			}
+			return instance, nil
+		}
+		log.Debugf("Recycling expired WASM instance for %s", p.pluginName)
+		p.discardInstance(instance)
 	}
 
+	if p.config.EnableStatistics {
+		p.statsMu.Lock()
+		p.stats.AffinityMisses++
+		p.statsMu.Unlock()
+	}
+	return p.acquire(ctx)
+}
+
+// ReleaseFor returns an instance acquired for key. If no other instance is
+// parked for key and fewer than AffinityMaxInstances are parked, it is held
+// for key until the next AcquireFor or the idle timeout; otherwise it goes
+// back to the general pool like Release.
+func (p *WASMInstancePool) ReleaseFor(key string, instance *WASMModuleInstance) {
+	if instance == nil {
+		return
+	}
+	if key == "" || p.config.AffinityMaxInstances <= 0 || instance.burst {
+		p.Release(instance)
+		return
+	}
+
+	p.mu.Lock()
+	_, taken := p.affinity[key]
+	if p.closed || taken || len(p.affinity) >= p.config.AffinityMaxInstances {
+		p.mu.Unlock()
+		p.Release(instance)
+		return
+	}
+	parked := &parkedInstance{instance: instance}
+	parked.timer = time.AfterFunc(p.config.AffinityIdleTimeout, func() {
+		p.expireParked(key, parked)
+	})
+	p.affinity[key] = parked
+	p.mu.Unlock()
+
+	log.Debugf("Parked WASM instance for %s under key %q", p.pluginName, key)
+}
+
+// unpark removes and returns the instance parked for key, or nil
+func (p *WASMInstancePool) unpark(key string) *WASMModuleInstance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	parked, ok := p.affinity[key]
+	if !ok {
+		return nil
+	}
+	parked.timer.Stop()
+	delete(p.affinity, key)
+	return parked.instance
+}
+
+// unparkAny removes and returns any parked instance, or nil if none is parked
+func (p *WASMInstancePool) unparkAny() *WASMModuleInstance {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for key, parked := range p.affinity {
+		parked.timer.Stop()
+		delete(p.affinity, key)
+		return parked.instance
+	}
+	return nil
+}
+
+// expireParked returns an instance that stayed parked for the idle timeout to
+// the general pool, unless it was unparked in the meantime
+func (p *WASMInstancePool) expireParked(key string, parked *parkedInstance) {
+	p.mu.Lock()
+	if p.affinity[key] != parked {
+		p.mu.Unlock()
+		return
+	}
+	delete(p.affinity, key)
+	p.mu.Unlock()
+
+	log.Debugf("Affinity for key %q of %s expired", key, p.pluginName)
+	p.Release(parked.instance)
+}
+
+// discardInstance destroys an instance taken out of the pool and frees its slot
+func (p *WASMInstancePool) discardInstance(instance *WASMModuleInstance) {
+	if instance.burst {
+		p.releaseBurst(instance)
+		return
+	}
+	p.destroyInstance(instance)
+
+	p.mu.Lock()
+	p.currentInstances--
+	p.mu.Unlock()
+
+	if p.config.EnableStatistics {
+		p.statsMu.Lock()
+		p.stats.TotalDestroyed++
+		p.stats.CurrentActive--
+		p.statsMu.Unlock()
+	}
+	p.checkRecovered()
+}
+
+// acquire gets an instance once the request has been admitted
+func (p *WASMInstancePool) acquire(ctx context.Context) (*WASMModuleInstance, error) {
 	// Try to get an existing instance from the pool
 	select {
 	case instance := <-p.instances:
@@ -158,14 +447,14 @@ func (p *WASMInstancePool) Acquire() (*WASMModuleInstance, error) {
 			p.mu.Unlock()
 
 			if p.config.EnableStatistics {
-				p.stats.mu.Lock()
+				p.statsMu.Lock()
 				p.stats.TotalDestroyed++
 				p.stats.CurrentActive--
-				p.stats.mu.Unlock()
+				p.statsMu.Unlock()
 			}
 
 			// Create a new instance to replace the recycled one
-			return p.Acquire()
+			return p.acquire(ctx)
 		}
 
 		log.Debugf("Reusing WASM instance from pool for %s", p.pluginName)
@@ -183,32 +472,33 @@ func (p *WASMInstancePool) Acquire() (*WASMModuleInstance, error) {
 		if canCreate {
 			p.currentInstances++
 		}
+		total := p.currentInstances
 		p.mu.Unlock()
 
 		if canCreate {
-			instance, err := p.createInstance()
+			instance, err := p.newInstance()
 			if err != nil {
 				p.mu.Lock()
 				p.currentInstances--
 				p.mu.Unlock()
 
 				if p.config.EnableStatistics {
-					p.stats.mu.Lock()
+					p.statsMu.Lock()
 					p.stats.FailedRequests++
-					p.stats.mu.Unlock()
+					p.statsMu.Unlock()
 				}
 				return nil, err
 			}
 
 			if p.config.EnableStatistics {
-				p.stats.mu.Lock()
+				p.statsMu.Lock()
 				p.stats.TotalCreated++
 				p.stats.CurrentActive++
-				p.stats.mu.Unlock()
+				p.statsMu.Unlock()
 			}
 
 			log.Debugf("Created new WASM instance for %s (total: %d/%d)",
-				p.pluginName, p.currentInstances, p.config.MaxInstances)
+				p.pluginName, total, p.config.MaxInstances)
 
 			// Increment request count for this instance
 			instance.mu.Lock()
@@ -218,26 +508,66 @@ func (p *WASMInstancePool) Acquire() (*WASMModuleInstance, error) {
 			return instance, nil
 		}
 
+		// Pool is full: instances parked for a key are idle, so take one
+		// of those before waiting
+		if instance := p.unparkAny(); instance != nil {
+			if p.shouldRecycleInstance(instance) {
+				log.Debugf("Recycling expired WASM instance for %s", p.pluginName)
+				p.discardInstance(instance)
+				return p.acquire(ctx)
+			}
+			log.Debugf("Reclaimed parked WASM instance for %s", p.pluginName)
+			instance.mu.Lock()
+			instance.requestCount++
+			instance.mu.Unlock()
+			return instance, nil
+		}
+
+		// Pool is full: create a temporary instance if the burst ceiling
+		// allows, rather than wait
+		if instance, ok, err := p.acquireBurst(); ok {
+			return instance, err
+		}
+
 		// Pool is full, wait for an available instance
 		log.Debugf("WASM pool full for %s, waiting for available instance...", p.pluginName)
 		if p.config.EnableStatistics {
-			p.stats.mu.Lock()
+			p.statsMu.Lock()
 			p.stats.TotalWaits++
-			p.stats.mu.Unlock()
+			p.statsMu.Unlock()
 		}
+		p.startWaiting()
 
 		// Wait with timeout to prevent deadlock
 		var instance *WASMModuleInstance
+		timer := time.NewTimer(p.config.AcquireTimeout)
 		select {
 		case instance = <-p.instances:
-			// Got an instance
-		case <-time.After(p.config.AcquireTimeout):
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+		p.stopWaiting()
+		if instance != nil && ctx.Err() != nil {
+			// The caller gave up as the instance arrived: hand it to the
+			// next waiter rather than start a call ctx would abort, which
+			// destroys the instance
+			p.Release(instance)
+			instance = nil
+		}
+		if instance == nil {
 			if p.config.EnableStatistics {
-				p.stats.mu.Lock()
+				p.statsMu.Lock()
 				p.stats.FailedRequests++
-				p.stats.mu.Unlock()
+				p.statsMu.Unlock()
+			}
+			switch {
+			case ctx.Err() == nil:
+				return nil, fmt.Errorf("%w after %v", ErrAcquireTimeout, p.config.AcquireTimeout)
+			case errors.Is(ctx.Err(), context.DeadlineExceeded):
+				return nil, ErrAcquireTimeout
 			}
-			return nil, fmt.Errorf("timeout waiting for available WASM instance after %v", p.config.AcquireTimeout)
+			return nil, ctx.Err()
 		}
 
 		// Check if instance needs to be recycled
@@ -250,14 +580,14 @@ func (p *WASMInstancePool) Acquire() (*WASMModuleInstance, error) {
 			p.mu.Unlock()
 
 			if p.config.EnableStatistics {
-				p.stats.mu.Lock()
+				p.statsMu.Lock()
 				p.stats.TotalDestroyed++
 				p.stats.CurrentActive--
-				p.stats.mu.Unlock()
+				p.statsMu.Unlock()
 			}
 
 			// Create a new instance to replace the recycled one
-			return p.Acquire()
+			return p.acquire(ctx)
 		}
 
 		// Increment request count for this instance
@@ -297,6 +627,10 @@ func (p *WASMInstancePool) Release(instance *WASMModuleInstance) {
 	if instance == nil {
 		return
 	}
+	if instance.burst {
+		p.releaseBurst(instance)
+		return
+	}
 
 	// Try to return to pool, if pool is full, destroy the instance
 	select {
@@ -311,17 +645,77 @@ func (p *WASMInstancePool) Release(instance *WASMModuleInstance) {
 		p.currentInstances--
 		p.mu.Unlock()
 
-		p.stats.mu.Lock()
+		p.statsMu.Lock()
 		p.stats.TotalDestroyed++
 		p.stats.CurrentActive--
-		p.stats.mu.Unlock()
+		p.statsMu.Unlock()
+	}
+	p.checkRecovered()
+}
+
+// acquireBurst creates a burst instance if fewer than BurstMax instances
+// exist, reporting whether it tried. The ceiling is checked and the slot
+// taken under p.mu, so concurrent bursts can't overshoot it.
+func (p *WASMInstancePool) acquireBurst() (*WASMModuleInstance, bool, error) {
+	p.mu.Lock()
+	if p.closed || p.currentInstances+p.burstInstances >= p.config.BurstMax {
+		p.mu.Unlock()
+		return nil, false, nil
+	}
+	p.burstInstances++
+	p.mu.Unlock()
+
+	instance, err := p.newInstance()
+	if err != nil {
+		p.mu.Lock()
+		p.burstInstances--
+		p.mu.Unlock()
+
+		if p.config.EnableStatistics {
+			p.statsMu.Lock()
+			p.stats.FailedRequests++
+			p.statsMu.Unlock()
+		}
+		return nil, true, err
+	}
+	instance.burst = true
+	instance.requestCount++
+
+	if p.config.EnableStatistics {
+		p.statsMu.Lock()
+		p.stats.BurstCreated++
+		p.stats.BurstActive++
+		p.statsMu.Unlock()
+	}
+	log.Debugf("Created burst WASM instance for %s beyond %d instances", p.pluginName, p.config.MaxInstances)
+	return instance, true, nil
+}
+
+// releaseBurst destroys a burst instance and frees its slot under BurstMax
+func (p *WASMInstancePool) releaseBurst(instance *WASMModuleInstance) {
+	p.destroyInstance(instance)
+
+	p.mu.Lock()
+	p.burstInstances--
+	p.mu.Unlock()
+
+	if p.config.EnableStatistics {
+		p.statsMu.Lock()
+		p.stats.BurstActive--
+		p.statsMu.Unlock()
 	}
+	log.Debugf("Destroyed burst WASM instance for %s", p.pluginName)
+	p.checkRecovered()
 }
 
 // createInstance creates a new WASM module instance
 func (p *WASMInstancePool) createInstance() (*WASMModuleInstance, error) {
 	// Instantiate the compiled module
-	module, err := p.runtime.InstantiateModule(p.ctx, p.compiledModule, wazero.NewModuleConfig())
+	requestID := new(atomic.Value)
+	config := wazero.NewModuleConfig().
+		WithStdout(newPluginLogWriter(log.StandardLogger(), p.pluginName, "stdout", log.InfoLevel, requestID)).
+		WithStderr(newPluginLogWriter(log.StandardLogger(), p.pluginName, "stderr", log.WarnLevel, requestID))
+	module, err := p.runtime.InstantiateModule(p.ctx, p.compiledModule, config)
 	if err != nil {
 		return nil, fmt.Errorf("failed to instantiate WASM module: %w", err)
 	}
@@ -339,12 +733,15 @@ func (p *WASMInstancePool) createInstance() (*WASMModuleInstance, error) {
 
 	instance := &WASMModuleInstance{
 		module:       module,
+		requestID:    requestID,
 		createdAt:    time.Now(),
 		sharedBuffer: sharedBuffer,
+		abiVersion:   p.config.ABIVersion,
 		fileSystem: &WASMFileSystem{
 			ctx:          p.ctx,
 			module:       module,
 			sharedBuffer: &sharedBuffer,
+			scratch:      newScratchMemory(module),
 			mu:           nil, // No mutex needed - each instance is single-threaded
 		},
 	}
@@ -424,8 +821,22 @@ func (p *WASMInstancePool) Close() error {
 		return nil
 	}
 	p.closed = true
+	parked := p.affinity
+	p.affinity = make(map[string]*parkedInstance)
+	bursting := p.burstInstances
 	p.mu.Unlock()
 
+	// Burst instances in use are never pooled, they are destroyed when
+	// released
+	if bursting > 0 {
+		log.Debugf("Closing WASM instance pool for %s with %d burst instances in use", p.pluginName, bursting)
+	}
+
+	for _, pi := range parked {
+		pi.timer.Stop()
+		p.destroyInstance(pi.instance)
+	}
+
 	// Close all instances in the pool
 	close(p.instances)
 	for instance := range p.instances {
@@ -436,32 +847,120 @@ func (p *WASMInstancePool) Close() error {
 	return nil
 }
 
-// GetStats returns the current pool statistics
+// GetStats returns the current pool statistics. Rate limiter counters and
+// the health of the pool are tracked even when statistics are disabled.
 func (p *WASMInstancePool) GetStats() PoolStats {
-	p.stats.mu.Lock()
-	defer p.stats.mu.Unlock()
-	return p.stats
+	p.statsMu.Lock()
+	stats := p.stats
+	p.statsMu.Unlock()
+
+	p.mu.Lock()
+	stats.Unhealthy = !p.nextRecovery.IsZero()
+	stats.ConsecutiveFailures = int64(p.failures)
+	p.mu.Unlock()
+	return stats
 }
 
 // Execute executes a function with an instance from the pool
 // This is a convenience method that handles acquire/release automatically
 func (p *WASMInstancePool) Execute(fn func(*WASMModuleInstance) error) error {
-	instance, err := p.Acquire()
+	return p.execute(context.Background(), "Execute", "", fn)
+}
+
+// ExecuteContext is like Execute, but stops waiting for an instance when ctx
+// is done. fn should make its guest calls with ctx, so that wazero aborts the
+// guest if ctx is cancelled mid-call; the instance is then destroyed rather
+// than returned to the pool.
+func (p *WASMInstancePool) ExecuteContext(ctx context.Context, fn func(*WASMModuleInstance) error) error {
+	return p.execute(ctx, "Execute", "", fn)
+}
+
+// ExecuteFS executes a filesystem operation with an instance from the pool
+func (p *WASMInstancePool) ExecuteFS(fn func(filesystem.FileSystem) error) error {
+	return p.executeFS(context.Background(), "", fn)
+}
+
+// ExecuteFSContext is like ExecuteFS, but runs the operation under ctx, with
+// the same cancellation behavior as ExecuteContext
+func (p *WASMInstancePool) ExecuteFSContext(ctx context.Context, fn func(filesystem.FileSystem) error) error {
+	return p.executeFS(ctx, "", fn)
+}
+
+// ExecuteFSFor is like ExecuteFS, but acquires and releases the instance with
+// AcquireFor and ReleaseFor so calls with the same key share an instance
+func (p *WASMInstancePool) ExecuteFSFor(key string, fn func(filesystem.FileSystem) error) error {
+	return p.executeFS(context.Background(), key, fn)
+}
+
+// executeFS runs a filesystem operation for key under ctx
+func (p *WASMInstancePool) executeFS(ctx context.Context, key string, fn func(filesystem.FileSystem) error) error {
+	return p.execute(ctx, "ExecuteFS", key, func(instance *WASMModuleInstance) error {
+		return fn(instance.fileSystem)
+	})
+}
+
+// execute acquires an instance for key, runs fn on it under ctx and releases
+// it, inside a span when tracing is enabled
+func (p *WASMInstancePool) execute(ctx context.Context, op, key string, fn func(*WASMModuleInstance) error) error {
+	if p.config.Tracer != nil {
+		return p.executeTraced(ctx, op, key, fn)
+	}
+
+	instance, err := p.acquireFor(ctx, key)
 	if err != nil {
 		return err
 	}
-	defer p.Release(instance)
+	return p.run(ctx, key, instance, fn)
+}
+
+// run calls fn with instance's filesystem bound to ctx, then releases the
+// instance for key. If ctx ended during the call, wazero may have aborted the
+// guest part way through, so the instance is destroyed instead.
+func (p *WASMInstancePool) run(ctx context.Context, key string, instance *WASMModuleInstance, fn func(*WASMModuleInstance) error) error {
+	instance.fileSystem.ctx = ctx
+	if instance.requestID != nil {
+		instance.requestID.Store(filesystem.RequestIDFromContext(ctx))
+	}
+	err := fn(instance)
+	instance.fileSystem.ctx = p.ctx
+	if instance.requestID != nil {
+		instance.requestID.Store("")
+	}
 
-	return fn(instance)
+	if ctx.Err() != nil {
+		log.Debugf("Destroying WASM instance for %s after its request was cancelled", p.pluginName)
+		p.discardInstance(instance)
+		if err == nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+	p.ReleaseFor(key, instance)
+	return err
 }
 
-// ExecuteFS executes a filesystem operation with an instance from the pool
-func (p *WASMInstancePool) ExecuteFS(fn func(filesystem.FileSystem) error) error {
-	instance, err := p.Acquire()
+// executeTraced runs fn like execute inside a span, with a child span for
+// the time spent acquiring an instance
+func (p *WASMInstancePool) executeTraced(ctx context.Context, op, key string, fn func(*WASMModuleInstance) error) error {
+	ctx, span := p.config.Tracer.Start(ctx, "wasm."+op,
+		trace.WithAttributes(attribute.String("agfs.plugin", p.pluginName)))
+	defer span.End()
+
+	_, acquireSpan := p.config.Tracer.Start(ctx, "wasm.acquire")
+	instance, err := p.acquireFor(ctx, key)
 	if err != nil {
+		acquireSpan.RecordError(err)
+		acquireSpan.SetStatus(codes.Error, err.Error())
+		acquireSpan.End()
+		span.SetStatus(codes.Error, err.Error())
 		return err
 	}
-	defer p.Release(instance)
+	acquireSpan.End()
 
-	return fn(instance.fileSystem)
+	if err := p.run(ctx, key, instance, fn); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	return nil
 }
@@ -24,7 +24,14 @@ type WASMPlugin struct {
 // PooledWASMFileSystem implements filesystem.FileSystem using an instance pool
 type PooledWASMFileSystem struct {
 	pool *WASMInstancePool
+	ctx  context.Context // Request context bound by WithContext (nil = none)
 
+	*pooledHandles
+}
+
+// pooledHandles is the handle state shared by a PooledWASMFileSystem and its
+// WithContext views
+type pooledHandles struct {
 	// Handle management: maps handle ID to the handle object
 	// This ensures handle operations use the same handle instance with its bound WASM instance
 	handles      map[int64]*PooledWASMFileHandle
@@ -38,6 +45,7 @@ type WASMFileSystem struct {
 	ctx          context.Context
 	module       wazeroapi.Module
 	sharedBuffer *SharedBufferInfo // Shared memory buffer info (can be nil)
+	scratch      *scratchMemory    // Reused memory for call arguments (can be nil)
 	mu           *sync.Mutex       // Mutex for single instance (can be nil if instance is not shared)
 }
 
@@ -51,9 +59,11 @@ func NewWASMPluginWithPool(pool *WASMInstancePool, name string) (*WASMPlugin, er
 		name:         name,
 		instancePool: pool,
 		fileSystem: &PooledWASMFileSystem{
-			pool:         pool,
-			handles:      make(map[int64]*PooledWASMFileHandle),
-			nextHandleID: 1,
+			pool: pool,
+			pooledHandles: &pooledHandles{
+				handles:      make(map[int64]*PooledWASMFileHandle),
+				nextHandleID: 1,
+			},
 		},
 	}
 
@@ -234,6 +244,36 @@ func (wp *WASMPlugin) GetConfigParams() []plugin.ConfigParameter {
 	return params
 }
 
+// wasmCapabilityExports maps each capability to the export a WASM plugin
+// needs for it
+var wasmCapabilityExports = map[plugin.Capability]string{
+	plugin.CapabilityRead:    "fs_read",
+	plugin.CapabilityWrite:   "fs_write",
+	plugin.CapabilityCreate:  "fs_create",
+	plugin.CapabilityMkdir:   "fs_mkdir",
+	plugin.CapabilityRemove:  "fs_remove",
+	plugin.CapabilityRename:  "fs_rename",
+	plugin.CapabilityChmod:   "fs_chmod",
+	plugin.CapabilityHandles: "handle_open",
+}
+
+// Capabilities returns the operations whose functions the module exports
+func (wp *WASMPlugin) Capabilities() plugin.CapabilitySet {
+	exports := wp.instancePool.compiledModule.ExportedFunctions()
+	caps := plugin.NewCapabilitySet()
+	for c, name := range wasmCapabilityExports {
+		if _, ok := exports[name]; ok {
+			caps[c] = true
+		}
+	}
+	return caps
+}
+
+// Health reports the health of the plugin's instance pool
+func (wp *WASMPlugin) Health() error {
+	return wp.instancePool.Health()
+}
+
 // Shutdown shuts down the plugin
 func (wp *WASMPlugin) Shutdown() error {
 	// Close the instance pool
@@ -241,35 +281,51 @@ func (wp *WASMPlugin) Shutdown() error {
 }
 
 // PooledWASMFileSystem implementation
-// All methods delegate to the instance pool
+// All methods delegate to the instance pool, keyed by path so that with
+// affinity enabled calls on the same file reach the same instance
+
+// WithContext returns a view of pfs whose calls run under ctx: they stop
+// waiting for a busy pool and abort the guest when ctx is cancelled
+func (pfs *PooledWASMFileSystem) WithContext(ctx context.Context) filesystem.FileSystem {
+	return &PooledWASMFileSystem{pool: pfs.pool, ctx: ctx, pooledHandles: pfs.pooledHandles}
+}
+
+// execute runs fn on an instance for path, under the bound request context
+func (pfs *PooledWASMFileSystem) execute(path string, fn func(filesystem.FileSystem) error) error {
+	ctx := pfs.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return pfs.pool.executeFS(ctx, path, fn)
+}
 
 func (pfs *PooledWASMFileSystem) Create(path string) error {
-	return pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	return pfs.execute(path, func(fs filesystem.FileSystem) error {
 		return fs.Create(path)
 	})
 }
 
 func (pfs *PooledWASMFileSystem) Mkdir(path string, perm uint32) error {
-	return pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	return pfs.execute(path, func(fs filesystem.FileSystem) error {
 		return fs.Mkdir(path, perm)
 	})
 }
 
 func (pfs *PooledWASMFileSystem) Remove(path string) error {
-	return pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	return pfs.execute(path, func(fs filesystem.FileSystem) error {
 		return fs.Remove(path)
 	})
 }
 
 func (pfs *PooledWASMFileSystem) RemoveAll(path string) error {
-	return pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	return pfs.execute(path, func(fs filesystem.FileSystem) error {
 		return fs.RemoveAll(path)
 	})
 }
 
 func (pfs *PooledWASMFileSystem) Read(path string, offset int64, size int64) ([]byte, error) {
 	var data []byte
-	err := pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	err := pfs.execute(path, func(fs filesystem.FileSystem) error {
 		var readErr error
 		data, readErr = fs.Read(path, offset, size)
 		return readErr
@@ -279,7 +335,7 @@ func (pfs *PooledWASMFileSystem) Read(path string, offset int64, size int64) ([]
 
 func (pfs *PooledWASMFileSystem) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
 	var bytesWritten int64
-	err := pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	err := pfs.execute(path, func(fs filesystem.FileSystem) error {
 		var writeErr error
 		bytesWritten, writeErr = fs.Write(path, data, offset, flags)
 		return writeErr
@@ -289,7 +345,7 @@ func (pfs *PooledWASMFileSystem) Write(path string, data []byte, offset int64, f
 
 func (pfs *PooledWASMFileSystem) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	var infos []filesystem.FileInfo
-	err := pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	err := pfs.execute(path, func(fs filesystem.FileSystem) error {
 		var readErr error
 		infos, readErr = fs.ReadDir(path)
 		return readErr
@@ -299,7 +355,7 @@ func (pfs *PooledWASMFileSystem) ReadDir(path string) ([]filesystem.FileInfo, er
 
 func (pfs *PooledWASMFileSystem) Stat(path string) (*filesystem.FileInfo, error) {
 	var info *filesystem.FileInfo
-	err := pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	err := pfs.execute(path, func(fs filesystem.FileSystem) error {
 		var statErr error
 		info, statErr = fs.Stat(path)
 		return statErr
@@ -308,20 +364,20 @@ func (pfs *PooledWASMFileSystem) Stat(path string) (*filesystem.FileInfo, error)
 }
 
 func (pfs *PooledWASMFileSystem) Rename(oldPath, newPath string) error {
-	return pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	return pfs.execute(oldPath, func(fs filesystem.FileSystem) error {
 		return fs.Rename(oldPath, newPath)
 	})
 }
 
 func (pfs *PooledWASMFileSystem) Chmod(path string, mode uint32) error {
-	return pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	return pfs.execute(path, func(fs filesystem.FileSystem) error {
 		return fs.Chmod(path, mode)
 	})
 }
 
 func (pfs *PooledWASMFileSystem) Open(path string) (io.ReadCloser, error) {
 	var reader io.ReadCloser
-	err := pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	err := pfs.execute(path, func(fs filesystem.FileSystem) error {
 		var openErr error
 		reader, openErr = fs.Open(path)
 		return openErr
@@ -331,7 +387,7 @@ func (pfs *PooledWASMFileSystem) Open(path string) (io.ReadCloser, error) {
 
 func (pfs *PooledWASMFileSystem) OpenWrite(path string) (io.WriteCloser, error) {
 	var writer io.WriteCloser
-	err := pfs.pool.ExecuteFS(func(fs filesystem.FileSystem) error {
+	err := pfs.execute(path, func(fs filesystem.FileSystem) error {
 		var openErr error
 		writer, openErr = fs.OpenWrite(path)
 		return openErr
@@ -538,11 +594,11 @@ func (wfs *WASMFileSystem) Create(path string) error {
 		return fmt.Errorf("fs_create not implemented")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemoryWithBuffer(wfs.module, path, wfs.sharedBuffer)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, wfs.sharedBuffer)
 	if err != nil {
 		return err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, pathPtr, pathPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(pathPtr, pathPtrSize, wfs.sharedBuffer)
 
 	results, err := createFunc.Call(wfs.ctx, uint64(pathPtr))
 	if err != nil {
@@ -568,11 +624,11 @@ func (wfs *WASMFileSystem) Mkdir(path string, perm uint32) error {
 		return fmt.Errorf("fs_mkdir not implemented")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemory(wfs.module, path)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, nil)
 	if err != nil {
 		return err
 	}
-	defer freeWASMMemory(wfs.module, pathPtr, pathPtrSize)
+	defer wfs.freeArg(pathPtr, pathPtrSize, nil)
 
 	results, err := mkdirFunc.Call(wfs.ctx, uint64(pathPtr), uint64(perm))
 	if err != nil {
@@ -598,11 +654,11 @@ func (wfs *WASMFileSystem) Remove(path string) error {
 		return fmt.Errorf("fs_remove not implemented")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemory(wfs.module, path)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, nil)
 	if err != nil {
 		return err
 	}
-	defer freeWASMMemory(wfs.module, pathPtr, pathPtrSize)
+	defer wfs.freeArg(pathPtr, pathPtrSize, nil)
 
 	results, err := removeFunc.Call(wfs.ctx, uint64(pathPtr))
 	if err != nil {
@@ -629,11 +685,11 @@ func (wfs *WASMFileSystem) RemoveAll(path string) error {
 		return wfs.Remove(path)
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemory(wfs.module, path)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, nil)
 	if err != nil {
 		return err
 	}
-	defer freeWASMMemory(wfs.module, pathPtr, pathPtrSize)
+	defer wfs.freeArg(pathPtr, pathPtrSize, nil)
 
 	results, err := removeAllFunc.Call(wfs.ctx, uint64(pathPtr))
 	if err != nil {
@@ -666,11 +722,11 @@ func (wfs *WASMFileSystem) Read(path string, offset int64, size int64) ([]byte,
 		return nil, fmt.Errorf("fs_read not implemented")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemoryWithBuffer(wfs.module, path, wfs.sharedBuffer)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, wfs.sharedBuffer)
 	if err != nil {
 		return nil, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, pathPtr, pathPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(pathPtr, pathPtrSize, wfs.sharedBuffer)
 
 	results, err := readFunc.Call(wfs.ctx, uint64(pathPtr), uint64(offset), uint64(size))
 	if err != nil {
@@ -708,17 +764,17 @@ func (wfs *WASMFileSystem) Write(path string, data []byte, offset int64, flags f
 		return 0, fmt.Errorf("fs_write not implemented")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemoryWithBuffer(wfs.module, path, wfs.sharedBuffer)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, wfs.sharedBuffer)
 	if err != nil {
 		return 0, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, pathPtr, pathPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(pathPtr, pathPtrSize, wfs.sharedBuffer)
 
-	dataPtr, dataPtrSize, err := writeBytesToMemoryWithBuffer(wfs.module, data, wfs.sharedBuffer)
+	dataPtr, dataPtrSize, err := wfs.writeArg(data, wfs.sharedBuffer)
 	if err != nil {
 		return 0, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, dataPtr, dataPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(dataPtr, dataPtrSize, wfs.sharedBuffer)
 
 	// Call WASM plugin with new signature: fs_write(path, data, len, offset, flags) -> packed u64
 	results, err := writeFunc.Call(wfs.ctx, uint64(pathPtr), uint64(dataPtr), uint64(len(data)), uint64(offset), uint64(flags))
@@ -754,11 +810,11 @@ func (wfs *WASMFileSystem) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("fs_readdir not implemented")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemory(wfs.module, path)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, nil)
 	if err != nil {
 		return nil, err
 	}
-	defer freeWASMMemory(wfs.module, pathPtr, pathPtrSize)
+	defer wfs.freeArg(pathPtr, pathPtrSize, nil)
 
 	results, err := readDirFunc.Call(wfs.ctx, uint64(pathPtr))
 	if err != nil {
@@ -812,12 +868,12 @@ func (wfs *WASMFileSystem) Stat(path string) (*filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("fs_stat not implemented")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemoryWithBuffer(wfs.module, path, wfs.sharedBuffer)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, wfs.sharedBuffer)
 	if err != nil {
 		log.Errorf("Failed to write path to memory: %v", err)
 		return nil, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, pathPtr, pathPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(pathPtr, pathPtrSize, wfs.sharedBuffer)
 
 	log.Debugf("Calling fs_stat WASM function with pathPtr=%d", pathPtr)
 	results, err := statFunc.Call(wfs.ctx, uint64(pathPtr))
@@ -873,17 +929,17 @@ func (wfs *WASMFileSystem) Rename(oldPath, newPath string) error {
 		return fmt.Errorf("fs_rename not implemented")
 	}
 
-	oldPathPtr, oldPathPtrSize, err := writeStringToMemory(wfs.module, oldPath)
+	oldPathPtr, oldPathPtrSize, err := wfs.writeStringArg(oldPath, nil)
 	if err != nil {
 		return err
 	}
-	defer freeWASMMemory(wfs.module, oldPathPtr, oldPathPtrSize)
+	defer wfs.freeArg(oldPathPtr, oldPathPtrSize, nil)
 
-	newPathPtr, newPathPtrSize, err := writeStringToMemory(wfs.module, newPath)
+	newPathPtr, newPathPtrSize, err := wfs.writeStringArg(newPath, nil)
 	if err != nil {
 		return err
 	}
-	defer freeWASMMemory(wfs.module, newPathPtr, newPathPtrSize)
+	defer wfs.freeArg(newPathPtr, newPathPtrSize, nil)
 
 	results, err := renameFunc.Call(wfs.ctx, uint64(oldPathPtr), uint64(newPathPtr))
 	if err != nil {
@@ -910,11 +966,11 @@ func (wfs *WASMFileSystem) Chmod(path string, mode uint32) error {
 		return nil
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemory(wfs.module, path)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, nil)
 	if err != nil {
 		return err
 	}
-	defer freeWASMMemory(wfs.module, pathPtr, pathPtrSize)
+	defer wfs.freeArg(pathPtr, pathPtrSize, nil)
 
 	results, err := chmodFunc.Call(wfs.ctx, uint64(pathPtr), uint64(mode))
 	if err != nil {
@@ -970,11 +1026,11 @@ func (wfs *WASMFileSystem) OpenHandle(path string, flags filesystem.OpenFlag, mo
 		return nil, fmt.Errorf("handle_open not implemented in WASM plugin")
 	}
 
-	pathPtr, pathPtrSize, err := writeStringToMemoryWithBuffer(wfs.module, path, wfs.sharedBuffer)
+	pathPtr, pathPtrSize, err := wfs.writeStringArg(path, wfs.sharedBuffer)
 	if err != nil {
 		return nil, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, pathPtr, pathPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(pathPtr, pathPtrSize, wfs.sharedBuffer)
 
 	results, err := openFunc.Call(wfs.ctx, uint64(pathPtr), uint64(flags), uint64(mode))
 	if err != nil {
@@ -1035,11 +1091,11 @@ func (wfs *WASMFileSystem) handleRead(id int64, buf []byte) (int, error) {
 	}
 
 	// Allocate buffer in WASM memory (can use shared buffer)
-	bufPtr, bufPtrSize, err := writeBytesToMemoryWithBuffer(wfs.module, make([]byte, len(buf)), wfs.sharedBuffer)
+	bufPtr, bufPtrSize, err := wfs.writeArg(make([]byte, len(buf)), wfs.sharedBuffer)
 	if err != nil {
 		return 0, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, bufPtr, bufPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(bufPtr, bufPtrSize, wfs.sharedBuffer)
 
 	results, err := readFunc.Call(wfs.ctx, uint64(id), uint64(bufPtr), uint64(len(buf)))
 	if err != nil {
@@ -1082,11 +1138,11 @@ func (wfs *WASMFileSystem) handleReadAt(id int64, buf []byte, offset int64) (int
 		return 0, fmt.Errorf("handle_read_at not implemented")
 	}
 
-	bufPtr, bufPtrSize, err := writeBytesToMemoryWithBuffer(wfs.module, make([]byte, len(buf)), wfs.sharedBuffer)
+	bufPtr, bufPtrSize, err := wfs.writeArg(make([]byte, len(buf)), wfs.sharedBuffer)
 	if err != nil {
 		return 0, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, bufPtr, bufPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(bufPtr, bufPtrSize, wfs.sharedBuffer)
 
 	results, err := readAtFunc.Call(wfs.ctx, uint64(id), uint64(bufPtr), uint64(len(buf)), uint64(offset))
 	if err != nil {
@@ -1128,11 +1184,11 @@ func (wfs *WASMFileSystem) handleWrite(id int64, data []byte) (int, error) {
 		return 0, fmt.Errorf("handle_write not implemented")
 	}
 
-	dataPtr, dataPtrSize, err := writeBytesToMemoryWithBuffer(wfs.module, data, wfs.sharedBuffer)
+	dataPtr, dataPtrSize, err := wfs.writeArg(data, wfs.sharedBuffer)
 	if err != nil {
 		return 0, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, dataPtr, dataPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(dataPtr, dataPtrSize, wfs.sharedBuffer)
 
 	results, err := writeFunc.Call(wfs.ctx, uint64(id), uint64(dataPtr), uint64(len(data)))
 	if err != nil {
@@ -1166,11 +1222,11 @@ func (wfs *WASMFileSystem) handleWriteAt(id int64, data []byte, offset int64) (i
 		return 0, fmt.Errorf("handle_write_at not implemented")
 	}
 
-	dataPtr, dataPtrSize, err := writeBytesToMemoryWithBuffer(wfs.module, data, wfs.sharedBuffer)
+	dataPtr, dataPtrSize, err := wfs.writeArg(data, wfs.sharedBuffer)
 	if err != nil {
 		return 0, err
 	}
-	defer freeWASMMemoryWithBuffer(wfs.module, dataPtr, dataPtrSize, wfs.sharedBuffer)
+	defer wfs.freeArg(dataPtr, dataPtrSize, wfs.sharedBuffer)
 
 	results, err := writeAtFunc.Call(wfs.ctx, uint64(id), uint64(dataPtr), uint64(len(data)), uint64(offset))
 	if err != nil {
@@ -0,0 +1,119 @@
+package plugin
+
+import "sort"
+
+// Capability names an operation a plugin's file system supports
+type Capability string
+
+const (
+	// Baseline capabilities, backed by the filesystem.FileSystem methods
+	CapabilityRead   Capability = "read"
+	CapabilityWrite  Capability = "write"
+	CapabilityCreate Capability = "create"
+	CapabilityMkdir  Capability = "mkdir"
+	CapabilityRemove Capability = "remove"
+	CapabilityRename Capability = "rename"
+	CapabilityChmod  Capability = "chmod"
+
+	// Optional capabilities, backed by the filesystem extension interfaces
+	CapabilityTruncate     Capability = "truncate"     // filesystem.Truncater
+	CapabilityTouch        Capability = "touch"        // filesystem.Toucher
+	CapabilitySymlink      Capability = "symlink"      // filesystem.Symlinker
+	CapabilityHandles      Capability = "handles"      // filesystem.HandleFS
+	CapabilityStream       Capability = "stream"       // filesystem.Streamer
+	CapabilitySnapshot     Capability = "snapshot"     // filesystem.Snapshotter
+	CapabilityClone        Capability = "clone"        // filesystem.Cloner
+	CapabilityCopyRange    Capability = "copy-range"   // filesystem.RangeCopier
+	CapabilityTransactions Capability = "transactions" // filesystem.Transactor
+
+	// CapabilityOffsetWrite declares that Write at an offset updates that
+	// range in place, so writes to disjoint ranges of a file can run at
+	// once. Plugins without it may rewrite the whole file.
+	CapabilityOffsetWrite Capability = "offset-write"
+
+	// CapabilityExclusiveCreate declares that Write with WriteFlagCreate and
+	// WriteFlagExclusive, and OpenHandle with O_CREATE and O_EXCL, fail with
+	// an already-exists error if the file exists, checking it atomically
+	// with the create. For plugins without it, MountableFS checks first.
+	CapabilityExclusiveCreate Capability = "exclusive-create"
+)
+
+// CapabilitySet is the set of operations a plugin declares support for
+type CapabilitySet map[Capability]bool
+
+// NewCapabilitySet returns a set holding caps
+func NewCapabilitySet(caps ...Capability) CapabilitySet {
+	s := make(CapabilitySet, len(caps))
+	for _, c := range caps {
+		s[c] = true
+	}
+	return s
+}
+
+// BaselineCapabilities returns the set of a plain read/write file system:
+// every operation of filesystem.FileSystem and none of the optional ones
+func BaselineCapabilities() CapabilitySet {
+	return NewCapabilitySet(CapabilityRead, CapabilityWrite, CapabilityCreate,
+		CapabilityMkdir, CapabilityRemove, CapabilityRename, CapabilityChmod)
+}
+
+// Has reports whether c is in the set
+func (s CapabilitySet) Has(c Capability) bool {
+	return s[c]
+}
+
+// With returns a copy of the set with caps added
+func (s CapabilitySet) With(caps ...Capability) CapabilitySet {
+	out := make(CapabilitySet, len(s)+len(caps))
+	for c := range s {
+		out[c] = true
+	}
+	for _, c := range caps {
+		out[c] = true
+	}
+	return out
+}
+
+// Without returns a copy of the set with caps removed
+func (s CapabilitySet) Without(caps ...Capability) CapabilitySet {
+	out := make(CapabilitySet, len(s))
+	for c := range s {
+		out[c] = true
+	}
+	for _, c := range caps {
+		delete(out, c)
+	}
+	return out
+}
+
+// List returns the capability names in the set, sorted
+func (s CapabilitySet) List() []string {
+	names := make([]string, 0, len(s))
+	for c, ok := range s {
+		if ok {
+			names = append(names, string(c))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CapabilityReporter is implemented by file systems whose operations depend
+// on a live backend, such as proxies to backends whose features vary. A
+// mount's file system implementing it is asked for its capabilities again
+// every refresh interval (see MountableFS.SetCapabilityRefreshInterval), in
+// place of the set its plugin declared; file systems without it keep the
+// declared set.
+type CapabilityReporter interface {
+	Capabilities() (CapabilitySet, error)
+}
+
+// BasePlugin provides the default Capabilities for plugins that embed it: the
+// baseline read/write set. Plugins supporting optional operations, or lacking
+// baseline ones, override Capabilities.
+type BasePlugin struct{}
+
+// Capabilities returns BaselineCapabilities
+func (BasePlugin) Capabilities() CapabilitySet {
+	return BaselineCapabilities()
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// PluginInitError reports a plugin that failed to start, with what is
+// needed to fix its configuration: the plugin and mount path, the parameters
+// provided and the required ones missing, and whether Validate accepted the
+// configuration, in which case Initialize is what failed. Parameter values
+// are left out, as they may hold credentials.
+type PluginInitError struct {
+	Plugin    string
+	MountPath string
+	Provided  []string // Names of the parameters provided, sorted
+	Missing   []string // Names of the required parameters not provided, sorted
+	Validated bool     // Validate accepted the configuration
+	Err       error
+}
+
+func (e *PluginInitError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "plugin %s", e.Plugin)
+	if e.MountPath != "" {
+		fmt.Fprintf(&b, " at %s", e.MountPath)
+	}
+	if e.Validated {
+		b.WriteString(": initialization failed")
+	} else {
+		b.WriteString(": invalid configuration")
+	}
+	fmt.Fprintf(&b, " (provided: [%s]", strings.Join(e.Provided, ", "))
+	if len(e.Missing) > 0 {
+		fmt.Fprintf(&b, ", missing required: [%s]", strings.Join(e.Missing, ", "))
+	}
+	fmt.Fprintf(&b, "): %v", e.Err)
+	return b.String()
+}
+
+func (e *PluginInitError) Unwrap() error {
+	return e.Err
+}
+
+// Initialize validates config and initializes p with it, as mounting a
+// plugin does, returning a PluginInitError if either fails. The mount path
+// is taken from the "mount_path" parameter.
+func Initialize(p ServicePlugin, config map[string]interface{}) error {
+	err := p.Validate(config)
+	validated := err == nil
+	if validated {
+		err = p.Initialize(config)
+	}
+	if err == nil {
+		return nil
+	}
+
+	initErr := &PluginInitError{
+		Plugin:    p.Name(),
+		Provided:  []string{},
+		Validated: validated,
+		Err:       err,
+	}
+	if mountPath, ok := config["mount_path"].(string); ok {
+		initErr.MountPath = mountPath
+	}
+	for name := range config {
+		if name != "mount_path" {
+			initErr.Provided = append(initErr.Provided, name)
+		}
+	}
+	sort.Strings(initErr.Provided)
+	for _, param := range p.GetConfigParams() {
+		if _, ok := config[param.Name]; param.Required && !ok {
+			initErr.Missing = append(initErr.Missing, param.Name)
+		}
+	}
+	sort.Strings(initErr.Missing)
+	return initErr
+}
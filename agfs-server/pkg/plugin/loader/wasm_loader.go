@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 
 	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
@@ -12,7 +13,6 @@ import (
 	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
 	log "github.com/sirupsen/logrus"
 	"github.com/tetratelabs/wazero"
-	wazeroapi "github.com/tetratelabs/wazero/api"
 	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
 )
 
@@ -76,9 +76,10 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, poolConfig api.PoolC
 		return nil, fmt.Errorf("failed to read WASM file %s: %w", wasmPath, err)
 	}
 
-	// Create a new WASM runtime
+	// Create a new WASM runtime. Guest calls are aborted when their context is
+	// done, so a cancelled request doesn't keep an instance busy.
 	ctx := context.Background()
-	r := wazero.NewRuntime(ctx)
+	r := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().WithCloseOnContextDone(true))
 
 	// Instantiate WASI
 	if _, err := wasi_snapshot_preview1.Instantiate(ctx, r); err != nil {
@@ -103,64 +104,7 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, poolConfig api.PoolC
 		fs = nil // Will be handled by api functions
 	}
 
-	_, err = r.NewHostModuleBuilder("env").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32, offset, size int64) uint64 {
-				return api.HostFSRead(ctx, mod, []uint64{uint64(pathPtr), uint64(offset), uint64(size)}, fs)[0]
-			}).
-			Export("host_fs_read").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, dataPtr, dataLen uint32) uint64 {
-				return api.HostFSWrite(ctx, mod, []uint64{uint64(pathPtr), uint64(dataPtr), uint64(dataLen)}, fs)[0]
-			}).
-			Export("host_fs_write").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint64 {
-				return api.HostFSStat(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0]
-			}).
-			Export("host_fs_stat").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint64 {
-				return api.HostFSReadDir(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0]
-			}).
-			Export("host_fs_readdir").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint32 {
-				return uint32(api.HostFSCreate(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0])
-			}).
-			Export("host_fs_create").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, perm uint32) uint32 {
-				return uint32(api.HostFSMkdir(ctx, mod, []uint64{uint64(pathPtr), uint64(perm)}, fs)[0])
-			}).
-			Export("host_fs_mkdir").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint32 {
-				return uint32(api.HostFSRemove(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0])
-			}).
-			Export("host_fs_remove").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr uint32) uint32 {
-				return uint32(api.HostFSRemoveAll(ctx, mod, []uint64{uint64(pathPtr)}, fs)[0])
-			}).
-			Export("host_fs_remove_all").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, oldPathPtr, newPathPtr uint32) uint32 {
-				return uint32(api.HostFSRename(ctx, mod, []uint64{uint64(oldPathPtr), uint64(newPathPtr)}, fs)[0])
-			}).
-			Export("host_fs_rename").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, pathPtr, mode uint32) uint32 {
-				return uint32(api.HostFSChmod(ctx, mod, []uint64{uint64(pathPtr), uint64(mode)}, fs)[0])
-			}).
-			Export("host_fs_chmod").
-			NewFunctionBuilder().
-			WithFunc(func(ctx context.Context, mod wazeroapi.Module, requestPtr uint32) uint64 {
-				return api.HostHTTPRequest(ctx, mod, []uint64{uint64(requestPtr)})[0]
-			}).
-			Export("host_http_request").
-			Instantiate(ctx)
-	if err != nil {
+	if err := api.InstantiateHostModule(ctx, r, fs); err != nil {
 		r.Close(ctx)
 		return nil, fmt.Errorf("failed to instantiate host filesystem module: %w", err)
 	}
@@ -171,13 +115,19 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, poolConfig api.PoolC
 		r.Close(ctx)
 		return nil, fmt.Errorf("failed to compile WASM module: %w", err)
 	}
+	if err := api.CheckHostImports(compiledModule); err != nil {
+		r.Close(ctx)
+		return nil, fmt.Errorf("incompatible WASM plugin %s: %w", wasmPath, err)
+	}
 
 	// Instantiate the module without filesystem access
 	// WASM plugins are not allowed to access the local filesystem
+	// Plugin output goes through the server logger so it follows its level and format
+	logName := strings.TrimSuffix(filepath.Base(wasmPath), filepath.Ext(wasmPath))
 	config := wazero.NewModuleConfig().
 		WithName("plugin").
-		WithStdout(os.Stdout). // Enable stdout
-		WithStderr(os.Stderr)  // Enable stderr
+		WithStdout(api.NewPluginLogWriter(log.StandardLogger(), logName, "stdout", log.InfoLevel)).
+		WithStderr(api.NewPluginLogWriter(log.StandardLogger(), logName, "stderr", log.WarnLevel))
 
 	module, err := r.InstantiateModule(ctx, compiledModule, config)
 	if err != nil {
@@ -185,7 +135,14 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, poolConfig api.PoolC
 		return nil, fmt.Errorf("failed to instantiate WASM module: %w", err)
 	}
 
-	log.Infof("Loaded WASM module: %s", wasmPath)
+	abiVersion, err := api.NegotiateABI(ctx, compiledModule, module)
+	if err != nil {
+		module.Close(ctx)
+		r.Close(ctx)
+		return nil, fmt.Errorf("incompatible WASM plugin %s: %w", wasmPath, err)
+	}
+
+	log.Infof("Loaded WASM module: %s (host ABI version %d)", wasmPath, abiVersion)
 
 	// Call plugin_new to initialize and get plugin name
 	pluginName := "wasm-plugin"
@@ -212,7 +169,9 @@ func (wl *WASMPluginLoader) LoadWASMPlugin(wasmPath string, poolConfig api.PoolC
 	// Close the initial module as we'll use the instance pool instead
 	module.Close(ctx)
 
-	// Create instance pool with provided configuration
+	// Create instance pool with provided configuration, its instances
+	// speaking the ABI version negotiated above
+	poolConfig.ABIVersion = abiVersion
 	instancePool := api.NewWASMInstancePool(ctx, r, compiledModule, pluginName, poolConfig, fs)
 
 	// Create WASM plugin wrapper with pool
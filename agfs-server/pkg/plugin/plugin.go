@@ -40,10 +40,23 @@ type ServicePlugin interface {
 	// This provides metadata about what configuration options are available
 	GetConfigParams() []ConfigParameter
 
+	// Capabilities returns the operations this plugin's file system supports
+	// MountableFS fails operations outside this set without calling the plugin
+	// Embed BasePlugin for the baseline read/write set
+	Capabilities() CapabilitySet
+
 	// Shutdown gracefully shuts down the plugin
 	Shutdown() error
 }
 
+// HealthReporter is implemented by plugins that can tell whether they are
+// able to serve requests, such as WASM plugins whose instances fail to be
+// created. Health returns nil when the plugin is ready, and why it isn't
+// otherwise.
+type HealthReporter interface {
+	Health() error
+}
+
 // MountPoint represents a mounted service plugin
 type MountPoint struct {
 	Path   string
@@ -72,6 +72,12 @@ func (p *DevFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	return []plugin.ConfigParameter{}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *DevFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate)
+}
+
 func (p *DevFSPlugin) Shutdown() error {
 	return nil
 }
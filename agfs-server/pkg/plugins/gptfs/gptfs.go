@@ -556,6 +556,12 @@ func (g *Gptfs) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (g *Gptfs) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate)
+}
+
 func (g *Gptfs) Shutdown() error {
 	if g.gptDriver != nil {
 		log.Infof("[gptfs] Shutting down, stopping workers...")
@@ -286,6 +286,12 @@ func (hb *HeartbeatFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (hb *HeartbeatFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTouch)
+}
+
 func (hb *HeartbeatFSPlugin) Shutdown() error {
 	// Stop cleanup goroutine
 	close(hb.stopChan)
@@ -54,6 +54,12 @@ func (p *HelloFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	return []plugin.ConfigParameter{}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *HelloFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate)
+}
+
 func (p *HelloFSPlugin) Shutdown() error {
 	return nil
 }
@@ -866,6 +866,12 @@ func (p *HTTPFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *HTTPFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate)
+}
+
 func (p *HTTPFSPlugin) Shutdown() error {
 	log.Infof("[httpfs] Plugin shutting down (port: %s, path: %s)", p.httpPort, p.agfsPath)
 	if p.fs != nil {
@@ -93,8 +93,9 @@ func (kv *KVFSPlugin) Initialize(config map[string]interface{}) error {
 	return nil
 }
 
+// GetFileSystem presents keys holding "/" as nested directories of /keys
 func (kv *KVFSPlugin) GetFileSystem() filesystem.FileSystem {
-	return &kvFS{plugin: kv}
+	return filesystem.PrefixDirs("/keys")(&kvFS{plugin: kv})
 }
 
 func (kv *KVFSPlugin) GetReadme() string {
@@ -118,6 +119,11 @@ USAGE:
   Rename a key:
     mv /keys/<oldkey> /keys/<newkey>
 
+  Keys holding "/" appear as nested directories:
+    mkdir -p /keys/app/config
+    echo "on" > /keys/app/config/debug    # key "app/config/debug"
+    ls /keys/app
+
 STRUCTURE:
   /keys/     - Directory containing all key-value pairs
   /README    - This file
@@ -145,6 +151,12 @@ func (kv *KVFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	return []plugin.ConfigParameter{}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (kv *KVFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate)
+}
+
 func (kv *KVFSPlugin) Shutdown() error {
 	kv.mu.Lock()
 	defer kv.mu.Unlock()
@@ -308,10 +320,11 @@ func (kvfs *kvFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 		kvfs.plugin.mu.RLock()
 		defer kvfs.plugin.mu.RUnlock()
 
+		// Every key is listed whole, PrefixDirs groups them into directories
 		files := make([]filesystem.FileInfo, 0, len(kvfs.plugin.store))
 		for key, value := range kvfs.plugin.store {
 			files = append(files, filesystem.FileInfo{
-				Name:    filepath.Base(key),
+				Name:    key,
 				Size:    int64(len(value)),
 				Mode:    0644,
 				ModTime: time.Now(),
@@ -0,0 +1,105 @@
+package localfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// Clone implements filesystem.Cloner with reflinks, where the local file
+// system supports them, such as Btrfs and XFS on Linux. Elsewhere it fails
+// with ErrNotSupported, so callers fall back to copying.
+//
+// An existing dst is cloned into in place, so handles open on it see the
+// clone; a dst created for the clone is removed again if the clone fails.
+func (fs *LocalFS) Clone(src, dst string) error {
+	srcLocalPath := fs.resolvePath(src)
+	dstLocalPath := fs.resolvePath(dst)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	in, err := os.Open(srcLocalPath)
+	if os.IsNotExist(err) {
+		return filesystem.NewNotFoundError("clone", src)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", src, err)
+	}
+	if info.IsDir() {
+		return filesystem.NewInvalidArgumentError("src", src, "is a directory")
+	}
+
+	_, statErr := os.Lstat(dstLocalPath)
+	created := os.IsNotExist(statErr)
+	out, err := os.OpenFile(dstLocalPath, os.O_WRONLY|os.O_CREATE, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dst, err)
+	}
+	err = cloneFile(out, in)
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		if created {
+			os.Remove(dstLocalPath)
+		}
+		if errors.Is(err, filesystem.ErrNotSupported) {
+			return filesystem.NewNotSupportedError("clone", dst)
+		}
+		return fmt.Errorf("failed to clone %s to %s: %w", src, dst, err)
+	}
+	return nil
+}
+
+// CopyRange implements filesystem.RangeCopier within the local file system:
+// Go copies between files with copy_file_range(2) where the OS has it, so the
+// data doesn't pass through the server, and file systems that can share the
+// blocks do.
+func (fs *LocalFS) CopyRange(src string, srcOffset int64, dst string, dstOffset, length int64) (int64, error) {
+	srcLocalPath := fs.resolvePath(src)
+	dstLocalPath := fs.resolvePath(dst)
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	in, err := os.Open(srcLocalPath)
+	if os.IsNotExist(err) {
+		return 0, filesystem.NewNotFoundError("copyrange", src)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dstLocalPath, os.O_WRONLY, 0)
+	if os.IsNotExist(err) {
+		return 0, filesystem.NewNotFoundError("copyrange", dst)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open %s: %w", dst, err)
+	}
+	defer out.Close()
+
+	if _, err := in.Seek(srcOffset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek %s: %w", src, err)
+	}
+	if _, err := out.Seek(dstOffset, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to seek %s: %w", dst, err)
+	}
+	n, err := io.Copy(out, io.LimitReader(in, length))
+	if err != nil {
+		return n, fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+	}
+	return n, nil
+}
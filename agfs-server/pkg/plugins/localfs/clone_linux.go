@@ -0,0 +1,21 @@
+package localfs
+
+import (
+	"errors"
+	"os"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"golang.org/x/sys/unix"
+)
+
+// cloneFile makes dst share the blocks of src with the FICLONE ioctl. The
+// errors of file systems without reflinks, or of files on different ones,
+// are reported as ErrNotSupported.
+func cloneFile(dst, src *os.File) error {
+	err := unix.IoctlFileClone(int(dst.Fd()), int(src.Fd()))
+	if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) ||
+		errors.Is(err, unix.ENOTTY) || errors.Is(err, unix.ENOSYS) {
+		return filesystem.ErrNotSupported
+	}
+	return err
+}
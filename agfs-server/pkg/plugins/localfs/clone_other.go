@@ -0,0 +1,14 @@
+//go:build !linux
+
+package localfs
+
+import (
+	"os"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// cloneFile reports ErrNotSupported where reflinks aren't wired up
+func cloneFile(dst, src *os.File) error {
+	return filesystem.ErrNotSupported
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package localfs
+
+import "os"
+
+// fileIno reports no inode number where the OS doesn't expose one
+func fileIno(info os.FileInfo) uint64 {
+	return 0
+}
+
+// fileBlocks reports no block counts where the OS doesn't expose them
+func fileBlocks(info os.FileInfo) (blocks, blockSize int64) {
+	return 0, 0
+}
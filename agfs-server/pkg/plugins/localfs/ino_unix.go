@@ -0,0 +1,26 @@
+//go:build unix
+
+package localfs
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileIno returns the inode number of a file on the local file system
+func fileIno(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// fileBlocks returns the 512-byte blocks a file has allocated on the local
+// file system, fewer than its size for a sparse file, and its preferred I/O
+// size
+func fileBlocks(info os.FileInfo) (blocks, blockSize int64) {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks), int64(st.Blksize)
+	}
+	return 0, 0
+}
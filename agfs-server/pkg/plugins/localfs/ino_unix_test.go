@@ -0,0 +1,53 @@
+//go:build unix
+
+package localfs
+
+import (
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// TestLocalFSBlocks checks that a sparse file reports fewer blocks than its
+// size needs, and a dense one at least as many
+func TestLocalFSBlocks(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+	fs := newTestFS(t, dir)
+
+	const size = 1 << 20
+	if _, err := fs.Write("/dense", make([]byte, size), -1, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := fs.Create("/sparse"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Truncate("/sparse", size); err != nil {
+		t.Fatalf("Truncate failed: %v", err)
+	}
+
+	dense, err := fs.Stat("/dense")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if dense.Blocks < size/512 || dense.BlockSize <= 0 {
+		t.Errorf("Expected the dense file to take at least %d blocks, got %d (block size %d)", size/512, dense.Blocks, dense.BlockSize)
+	}
+	sparse, err := fs.Stat("/sparse")
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if sparse.Size != size || sparse.Blocks >= size/512 {
+		t.Errorf("Expected the %d-byte sparse file to take fewer than %d blocks, got %d", sparse.Size, size/512, sparse.Blocks)
+	}
+
+	files, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	for _, f := range files {
+		if f.Name == "sparse" && f.Blocks != sparse.Blocks {
+			t.Errorf("Expected ReadDir to report the blocks Stat does, got %d and %d", f.Blocks, sparse.Blocks)
+		}
+	}
+}
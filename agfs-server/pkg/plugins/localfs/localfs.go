@@ -109,11 +109,18 @@ func (fs *LocalFS) Mkdir(path string, perm uint32) error {
 	}
 
 	// Create directory
-	err := os.Mkdir(localPath, os.FileMode(perm))
+	err := os.Mkdir(localPath, filesystem.ToFileMode(perm))
 	if err != nil {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	// mkdir(2) ignores setuid/setgid, so set the special bits explicitly
+	if perm&(filesystem.ModeSetuid|filesystem.ModeSetgid|filesystem.ModeSticky) != 0 {
+		if err := os.Chmod(localPath, filesystem.ToFileMode(perm)); err != nil {
+			return fmt.Errorf("failed to set directory mode: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -123,8 +130,8 @@ func (fs *LocalFS) Remove(path string) error {
 	fs.mu.Lock()
 	defer fs.mu.Unlock()
 
-	// Check if exists
-	info, err := os.Stat(localPath)
+	// Check if exists, without following a symlink, which is removed itself
+	info, err := os.Lstat(localPath)
 	if err != nil {
 		if os.IsNotExist(err) {
 			return fmt.Errorf("no such file or directory: %s", path)
@@ -293,7 +300,9 @@ func (fs *LocalFS) Write(path string, data []byte, offset int64, flags filesyste
 	}
 
 	if flags&filesystem.WriteFlagSync != 0 {
-		f.Sync()
+		if err := f.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync: %w", err)
+		}
 	}
 
 	return int64(n), nil
@@ -331,16 +340,25 @@ func (fs *LocalFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			continue
 		}
 
+		// Symlinks are listed as themselves, as Walk and Lstat expect
+		entryType := "local"
+		if entry.Type()&os.ModeSymlink != 0 {
+			entryType = "symlink"
+		}
+		blocks, blockSize := fileBlocks(entryInfo)
 		files = append(files, filesystem.FileInfo{
 			Name:    entry.Name(),
 			Size:    entryInfo.Size(),
-			Mode:    uint32(entryInfo.Mode()),
+			Mode:    filesystem.FromFileMode(entryInfo.Mode()),
 			ModTime: entryInfo.ModTime(),
 			IsDir:   entry.IsDir(),
 			Meta: filesystem.MetaData{
 				Name: PluginName,
-				Type: "local",
+				Type: entryType,
 			},
+			Ino:       fileIno(entryInfo),
+			Blocks:    blocks,
+			BlockSize: blockSize,
 		})
 	}
 
@@ -362,10 +380,11 @@ func (fs *LocalFS) Stat(path string) (*filesystem.FileInfo, error) {
 		return nil, fmt.Errorf("failed to stat: %w", err)
 	}
 
-	return &filesystem.FileInfo{
+	blocks, blockSize := fileBlocks(info)
+	stat := &filesystem.FileInfo{
 		Name:    info.Name(),
 		Size:    info.Size(),
-		Mode:    uint32(info.Mode()),
+		Mode:    filesystem.FromFileMode(info.Mode()),
 		ModTime: info.ModTime(),
 		IsDir:   info.IsDir(),
 		Meta: filesystem.MetaData{
@@ -375,7 +394,12 @@ func (fs *LocalFS) Stat(path string) (*filesystem.FileInfo, error) {
 				"local_path": localPath,
 			},
 		},
-	}, nil
+		Ino:       fileIno(info),
+		Blocks:    blocks,
+		BlockSize: blockSize,
+	}
+	stat.Meta.SetETag(filesystem.AttrETag(stat))
+	return stat, nil
 }
 
 func (fs *LocalFS) Rename(oldPath, newPath string) error {
@@ -417,7 +441,7 @@ func (fs *LocalFS) Chmod(path string, mode uint32) error {
 	}
 
 	// Change permissions
-	err := os.Chmod(localPath, os.FileMode(mode))
+	err := os.Chmod(localPath, filesystem.ToFileMode(mode))
 	if err != nil {
 		return fmt.Errorf("failed to chmod: %w", err)
 	}
@@ -701,6 +725,7 @@ FEATURES:
   - Direct access to local files and directories
   - Preserves file permissions and timestamps
   - Efficient file operations (no copying)
+  - Copy-on-write clones of files where the local file system has reflinks
 
 CONFIGURATION:
 
@@ -812,6 +837,12 @@ func (p *LocalFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *LocalFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate, plugin.CapabilitySymlink, plugin.CapabilityStream, plugin.CapabilityOffsetWrite, plugin.CapabilityClone, plugin.CapabilityCopyRange, plugin.CapabilityExclusiveCreate)
+}
+
 func (p *LocalFSPlugin) Shutdown() error {
 	log.Infof("[localfs] Shutting down")
 	return nil
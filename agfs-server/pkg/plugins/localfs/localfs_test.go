@@ -2,6 +2,7 @@ package localfs
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"os"
 	"path/filepath"
@@ -729,3 +730,70 @@ func TestLocalFSRemoveAll(t *testing.T) {
 		t.Error("Directory should be removed")
 	}
 }
+
+func TestLocalFSClone(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+	fs := newTestFS(t, dir)
+
+	if _, err := fs.Write("/src", []byte("original"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := fs.Write("/dst", []byte("previous"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if err := fs.Clone("/missing", "/other"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	// Without reflinks (e.g. ext4 or tmpfs) dst is left as it was
+	err := fs.Clone("/src", "/dst")
+	if errors.Is(err, filesystem.ErrNotSupported) {
+		if content, _ := readIgnoreEOF(fs, "/dst"); string(content) != "previous" {
+			t.Errorf("Expected dst unchanged by an unsupported clone, got %q", content)
+		}
+		if err := fs.Clone("/src", "/new"); !errors.Is(err, filesystem.ErrNotSupported) {
+			t.Errorf("Expected ErrNotSupported again, got %v", err)
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+			t.Errorf("Expected the dst created for the clone removed, got %v", err)
+		}
+		t.Skipf("No reflinks in %s: %v", dir, err)
+	}
+	if err != nil {
+		t.Fatalf("Clone failed: %v", err)
+	}
+	if _, err := fs.Write("/dst", []byte("modified"), 0, filesystem.WriteFlagNone); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if content, _ := readIgnoreEOF(fs, "/src"); string(content) != "original" {
+		t.Errorf("Expected src unchanged by writing its clone, got %q", content)
+	}
+}
+
+func TestLocalFSCopyRange(t *testing.T) {
+	dir, cleanup := setupTestDir(t)
+	defer cleanup()
+	fs := newTestFS(t, dir)
+
+	if _, err := fs.Write("/src", []byte("original"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := fs.Write("/dst", []byte("xyz"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	if n, err := fs.CopyRange("/src", 2, "/dst", 1, 4); err != nil || n != 4 {
+		t.Fatalf("Expected 4 bytes copied, got %d, %v", n, err)
+	}
+	if content, _ := readIgnoreEOF(fs, "/dst"); string(content) != "xigin" {
+		t.Errorf("Expected the range over the end of dst, got %q", content)
+	}
+	if n, err := fs.CopyRange("/src", 6, "/dst", 0, 10); err != nil || n != 2 {
+		t.Errorf("Expected the copy to stop at the end of src, got %d, %v", n, err)
+	}
+	if _, err := fs.CopyRange("/src", 0, "/missing", 0, 4); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
@@ -81,6 +81,8 @@ FEATURES:
   - File permissions (chmod)
   - File/directory renaming and moving
   - Metadata tracking
+  - Snapshots of directories, read under <dir>/.snapshots/<name>
+  - Transactions applying several writes, removals and renames atomically
 
 USAGE:
   Create a file:
@@ -125,6 +127,12 @@ func (p *MemFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	return []plugin.ConfigParameter{}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *MemFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate, plugin.CapabilityHandles, plugin.CapabilityOffsetWrite, plugin.CapabilitySnapshot, plugin.CapabilityTransactions, plugin.CapabilityExclusiveCreate)
+}
+
 func (p *MemFSPlugin) Shutdown() error {
 	return nil
 }
@@ -27,6 +27,7 @@ type Node struct {
 	Mode     uint32
 	ModTime  time.Time
 	Children map[string]*Node
+	Ino      uint64
 }
 
 // MemoryFS implements FileSystem and HandleFS interfaces with in-memory storage
@@ -39,6 +40,13 @@ type MemoryFS struct {
 	handles      map[int64]*MemoryFileHandle
 	handlesMu    sync.RWMutex
 	nextHandleID int64
+
+	// nextIno numbers nodes as they are created, guarded by mu
+	nextIno uint64
+
+	// snapshots holds the snapshots taken of each directory, by path,
+	// guarded by mu
+	snapshots map[string][]*memSnapshot
 }
 
 // NewMemoryFS creates a new in-memory file system
@@ -55,13 +63,23 @@ func NewMemoryFSWithPlugin(pluginName string) *MemoryFS {
 			Mode:     0755,
 			ModTime:  time.Now(),
 			Children: make(map[string]*Node),
+			Ino:      1,
 		},
 		pluginName:   pluginName,
 		handles:      make(map[int64]*MemoryFileHandle),
 		nextHandleID: 1,
+		nextIno:      2,
 	}
 }
 
+// allocIno returns the inode number of a new node
+// Must be called with mfs.mu held (write lock)
+func (mfs *MemoryFS) allocIno() uint64 {
+	ino := mfs.nextIno
+	mfs.nextIno++
+	return ino
+}
+
 // getNode retrieves a node from the tree
 func (mfs *MemoryFS) getNode(path string) (*Node, error) {
 	path = filesystem.NormalizePath(path)
@@ -79,7 +97,7 @@ func (mfs *MemoryFS) getNode(path string) (*Node, error) {
 		}
 		next, exists := current.Children[part]
 		if !exists {
-			return nil, fmt.Errorf("no such file or directory: %s", path)
+			return nil, filesystem.NewNotFoundError("lookup", path)
 		}
 		current = next
 	}
@@ -131,6 +149,7 @@ func (mfs *MemoryFS) Create(path string) error {
 		Mode:     0644,
 		ModTime:  time.Now(),
 		Children: nil,
+		Ino:      mfs.allocIno(),
 	}
 
 	return nil
@@ -156,6 +175,7 @@ func (mfs *MemoryFS) Mkdir(path string, perm uint32) error {
 		Mode:     perm,
 		ModTime:  time.Now(),
 		Children: make(map[string]*Node),
+		Ino:      mfs.allocIno(),
 	}
 
 	return nil
@@ -165,7 +185,12 @@ func (mfs *MemoryFS) Mkdir(path string, perm uint32) error {
 func (mfs *MemoryFS) Remove(path string) error {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
+	return mfs.remove(path)
+}
 
+// remove removes a file or empty directory
+// Must be called with mfs.mu held (write lock)
+func (mfs *MemoryFS) remove(path string) error {
 	if filesystem.NormalizePath(path) == "/" {
 		return fmt.Errorf("cannot remove root directory")
 	}
@@ -177,7 +202,7 @@ func (mfs *MemoryFS) Remove(path string) error {
 
 	node, exists := parent.Children[name]
 	if !exists {
-		return fmt.Errorf("no such file or directory: %s", path)
+		return filesystem.NewNotFoundError("remove", path)
 	}
 
 	if node.IsDir && len(node.Children) > 0 {
@@ -205,7 +230,7 @@ func (mfs *MemoryFS) RemoveAll(path string) error {
 	}
 
 	if _, exists := parent.Children[name]; !exists {
-		return fmt.Errorf("no such file or directory: %s", path)
+		return filesystem.NewNotFoundError("removeall", path)
 	}
 
 	delete(parent.Children, name)
@@ -233,7 +258,12 @@ func (mfs *MemoryFS) Read(path string, offset int64, size int64) ([]byte, error)
 func (mfs *MemoryFS) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
+	return mfs.write(path, data, offset, flags)
+}
 
+// write writes data to a file as Write does
+// Must be called with mfs.mu held (write lock)
+func (mfs *MemoryFS) write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
 	parent, name, err := mfs.getParentNode(path)
 	if err != nil {
 		if flags&filesystem.WriteFlagCreate == 0 {
@@ -247,7 +277,7 @@ func (mfs *MemoryFS) Write(path string, data []byte, offset int64, flags filesys
 
 	// Handle exclusive flag
 	if exists && flags&filesystem.WriteFlagExclusive != 0 {
-		return 0, fmt.Errorf("file already exists: %s", path)
+		return 0, filesystem.NewAlreadyExistsError("file", path)
 	}
 
 	if !exists {
@@ -262,6 +292,7 @@ func (mfs *MemoryFS) Write(path string, data []byte, offset int64, flags filesys
 			Mode:     0644,
 			ModTime:  time.Now(),
 			Children: nil,
+			Ino:      mfs.allocIno(),
 		}
 		parent.Children[name] = node
 	}
@@ -321,7 +352,7 @@ func (mfs *MemoryFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			metaType = MetaValueDir
 		}
 
-		infos = append(infos, filesystem.FileInfo{
+		info := filesystem.FileInfo{
 			Name:    child.Name,
 			Size:    int64(len(child.Data)),
 			Mode:    child.Mode,
@@ -331,7 +362,11 @@ func (mfs *MemoryFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 				Name: mfs.pluginName,
 				Type: metaType,
 			},
-		})
+			Ino: child.Ino,
+		}
+		// Writes update ModTime and Size, and Chmod the Mode
+		info.Meta.Content = map[string]string{filesystem.MetaETag: filesystem.AttrETag(&info)}
+		infos = append(infos, info)
 	}
 
 	return infos, nil
@@ -352,7 +387,7 @@ func (mfs *MemoryFS) Stat(path string) (*filesystem.FileInfo, error) {
 		metaType = MetaValueDir
 	}
 
-	return &filesystem.FileInfo{
+	info := &filesystem.FileInfo{
 		Name:    node.Name,
 		Size:    int64(len(node.Data)),
 		Mode:    node.Mode,
@@ -362,14 +397,22 @@ func (mfs *MemoryFS) Stat(path string) (*filesystem.FileInfo, error) {
 			Name: mfs.pluginName,
 			Type: metaType,
 		},
-	}, nil
+		Ino: node.Ino,
+	}
+	info.Meta.Content = map[string]string{filesystem.MetaETag: filesystem.AttrETag(info)}
+	return info, nil
 }
 
 // Rename renames/moves a file or directory
 func (mfs *MemoryFS) Rename(oldPath, newPath string) error {
 	mfs.mu.Lock()
 	defer mfs.mu.Unlock()
+	return mfs.rename(oldPath, newPath)
+}
 
+// rename moves oldPath to newPath, which must not exist
+// Must be called with mfs.mu held (write lock)
+func (mfs *MemoryFS) rename(oldPath, newPath string) error {
 	oldParent, oldName, err := mfs.getParentNode(oldPath)
 	if err != nil {
 		return err
@@ -377,7 +420,7 @@ func (mfs *MemoryFS) Rename(oldPath, newPath string) error {
 
 	node, exists := oldParent.Children[oldName]
 	if !exists {
-		return fmt.Errorf("no such file or directory: %s", oldPath)
+		return filesystem.NewNotFoundError("rename", oldPath)
 	}
 
 	newParent, newName, err := mfs.getParentNode(newPath)
@@ -463,7 +506,7 @@ func (m *memoryReadCloser) Close() error {
 // Open opens a file for reading
 func (mfs *MemoryFS) Open(path string) (io.ReadCloser, error) {
 	data, err := mfs.Read(path, 0, -1)
-	if err != nil {
+	if err != nil && err != io.EOF {
 		return nil, err
 	}
 	return &memoryReadCloser{bytes.NewReader(data)}, nil
@@ -760,7 +803,7 @@ func (mfs *MemoryFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uin
 
 	// Handle O_EXCL: fail if file exists
 	if flags&filesystem.O_EXCL != 0 && fileExists {
-		return nil, fmt.Errorf("file already exists: %s", path)
+		return nil, filesystem.NewAlreadyExistsError("file", path)
 	}
 
 	// Handle O_CREATE: create file if it doesn't exist
@@ -776,6 +819,7 @@ func (mfs *MemoryFS) OpenHandle(path string, flags filesystem.OpenFlag, mode uin
 			Mode:     mode,
 			ModTime:  time.Now(),
 			Children: nil,
+			Ino:      mfs.allocIno(),
 		}
 		parent.Children[name] = node
 	} else if !fileExists {
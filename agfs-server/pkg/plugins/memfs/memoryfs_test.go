@@ -1011,3 +1011,37 @@ func TestMemoryFSOpen(t *testing.T) {
 		t.Fatalf("Reader.Close failed: %v", err)
 	}
 }
+
+func TestMemoryFSIno(t *testing.T) {
+	fs := NewMemoryFS()
+	if err := fs.Create("/a"); err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+	if err := fs.Mkdir("/dir", 0755); err != nil {
+		t.Fatalf("Mkdir failed: %v", err)
+	}
+
+	a, _ := fs.Stat("/a")
+	dir, _ := fs.Stat("/dir")
+	root, _ := fs.Stat("/")
+	if a.Ino == 0 || a.Ino == dir.Ino || a.Ino == root.Ino || dir.Ino == root.Ino {
+		t.Fatalf("Expected distinct inode numbers, got %d, %d, %d", a.Ino, dir.Ino, root.Ino)
+	}
+
+	if again, _ := fs.Stat("/a"); again.Ino != a.Ino {
+		t.Errorf("Expected the same inode on every stat, got %d then %d", a.Ino, again.Ino)
+	}
+	entries, _ := fs.ReadDir("/")
+	for _, e := range entries {
+		if e.Name == "a" && e.Ino != a.Ino {
+			t.Errorf("Expected ReadDir to report inode %d, got %d", a.Ino, e.Ino)
+		}
+	}
+
+	if err := fs.Rename("/a", "/dir/b"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if moved, _ := fs.Stat("/dir/b"); moved.Ino != a.Ino {
+		t.Errorf("Expected the inode to survive a rename, got %d, was %d", moved.Ino, a.Ino)
+	}
+}
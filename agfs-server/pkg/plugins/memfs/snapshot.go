@@ -0,0 +1,95 @@
+package memfs
+
+import (
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// memSnapshot is a snapshot of a directory: a copy of its subtree taken
+// when the snapshot was
+type memSnapshot struct {
+	info filesystem.SnapshotInfo
+	root *Node
+}
+
+// copyNode returns a deep copy of n and its children
+func copyNode(n *Node) *Node {
+	c := *n
+	if n.Data != nil {
+		c.Data = append([]byte(nil), n.Data...)
+	}
+	if n.Children != nil {
+		c.Children = make(map[string]*Node, len(n.Children))
+		for name, child := range n.Children {
+			c.Children[name] = copyNode(child)
+		}
+	}
+	return &c
+}
+
+// Snapshot copies the directory at path, so later changes to it don't show
+// in the snapshot
+func (mfs *MemoryFS) Snapshot(path, name string) error {
+	path = filesystem.NormalizePath(path)
+
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	node, err := mfs.getNode(path)
+	if err != nil {
+		return err
+	}
+	if !node.IsDir {
+		return filesystem.NewNotDirectoryError(path)
+	}
+	for _, s := range mfs.snapshots[path] {
+		if s.info.Name == name {
+			return filesystem.NewAlreadyExistsError("snapshot", path+"@"+name)
+		}
+	}
+
+	if mfs.snapshots == nil {
+		mfs.snapshots = make(map[string][]*memSnapshot)
+	}
+	mfs.snapshots[path] = append(mfs.snapshots[path], &memSnapshot{
+		info: filesystem.SnapshotInfo{Name: name, Path: path, Created: time.Now()},
+		root: copyNode(node),
+	})
+	return nil
+}
+
+// ListSnapshots returns the snapshots of the directory at path, oldest first
+func (mfs *MemoryFS) ListSnapshots(path string) ([]filesystem.SnapshotInfo, error) {
+	path = filesystem.NormalizePath(path)
+
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	if _, err := mfs.getNode(path); err != nil {
+		return nil, err
+	}
+	infos := make([]filesystem.SnapshotInfo, 0, len(mfs.snapshots[path]))
+	for _, s := range mfs.snapshots[path] {
+		infos = append(infos, s.info)
+	}
+	return infos, nil
+}
+
+// SnapshotFS returns a read-only MemoryFS over the copy taken by the
+// snapshot
+func (mfs *MemoryFS) SnapshotFS(path, name string) (filesystem.FileSystem, error) {
+	path = filesystem.NormalizePath(path)
+
+	mfs.mu.RLock()
+	defer mfs.mu.RUnlock()
+
+	for _, s := range mfs.snapshots[path] {
+		if s.info.Name == name {
+			fs := NewMemoryFSWithPlugin(mfs.pluginName)
+			fs.root = s.root
+			return filesystem.ReadOnly(fs), nil
+		}
+	}
+	return nil, filesystem.NewNotFoundError("snapshot", path+"@"+name)
+}
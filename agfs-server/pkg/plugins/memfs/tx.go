@@ -0,0 +1,159 @@
+package memfs
+
+import (
+	"sync"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+)
+
+// memTx is a transaction of a MemoryFS: the staged operations are applied
+// to the tree at Commit, recording how to undo each, and undone if one of
+// them fails
+type memTx struct {
+	mfs  *MemoryFS
+	mu   sync.Mutex
+	ops  []func(u *undoLog) error
+	done bool
+}
+
+// BeginTx starts a transaction. Committing it only touches the nodes its
+// operations change, and keeps the snapshots as they are.
+func (mfs *MemoryFS) BeginTx() (filesystem.Tx, error) {
+	return &memTx{mfs: mfs}, nil
+}
+
+// undoLog holds what the operations applied by a Commit changed, to put it
+// back if a later one fails
+type undoLog struct {
+	undo []func()
+}
+
+// entry records the entry name of dir, node or none
+func (u *undoLog) entry(dir *Node, name string) {
+	node, exists := dir.Children[name]
+	u.undo = append(u.undo, func() {
+		if exists {
+			node.Name = name
+			dir.Children[name] = node
+		} else {
+			delete(dir.Children, name)
+		}
+	})
+}
+
+// content records the content of the file node. Only writes at an offset
+// within the data modify it in place, so the data is copied for them alone.
+func (u *undoLog) content(node *Node, inPlace bool) {
+	data, modTime := node.Data, node.ModTime
+	if inPlace {
+		data = append([]byte(nil), data...)
+	}
+	u.undo = append(u.undo, func() {
+		node.Data, node.ModTime = data, modTime
+	})
+}
+
+// rollback undoes the recorded changes, latest first
+func (u *undoLog) rollback() {
+	for i := len(u.undo) - 1; i >= 0; i-- {
+		u.undo[i]()
+	}
+}
+
+// stage adds op to the operations to apply at Commit
+func (tx *memTx) stage(op func(u *undoLog) error) error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return filesystem.ErrTxDone
+	}
+	tx.ops = append(tx.ops, op)
+	return nil
+}
+
+// Write stages a write of data, copied so the caller may reuse it
+func (tx *memTx) Write(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	data = append([]byte(nil), data...)
+	mfs := tx.mfs
+	err := tx.stage(func(u *undoLog) error {
+		if parent, name, err := mfs.getParentNode(path); err == nil {
+			if node, exists := parent.Children[name]; exists && !node.IsDir {
+				u.content(node, offset >= 0 && flags&filesystem.WriteFlagTruncate == 0)
+			}
+			u.entry(parent, name)
+		}
+		_, err := mfs.write(path, data, offset, flags)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(data)), nil
+}
+
+// Remove stages the removal of path
+func (tx *memTx) Remove(path string) error {
+	mfs := tx.mfs
+	return tx.stage(func(u *undoLog) error {
+		if parent, name, err := mfs.getParentNode(path); err == nil {
+			u.entry(parent, name)
+		}
+		return mfs.remove(path)
+	})
+}
+
+// Rename stages the rename of oldPath to newPath
+func (tx *memTx) Rename(oldPath, newPath string) error {
+	mfs := tx.mfs
+	return tx.stage(func(u *undoLog) error {
+		if parent, name, err := mfs.getParentNode(oldPath); err == nil {
+			u.entry(parent, name)
+		}
+		if parent, name, err := mfs.getParentNode(newPath); err == nil {
+			u.entry(parent, name)
+		}
+		return mfs.rename(oldPath, newPath)
+	})
+}
+
+// Commit applies the staged operations, holding the tree's lock throughout
+// so no one sees them half applied. If one fails, those applied before it
+// are undone.
+func (tx *memTx) Commit() error {
+	tx.mu.Lock()
+	if tx.done {
+		tx.mu.Unlock()
+		return filesystem.ErrTxDone
+	}
+	tx.done = true
+	ops := tx.ops
+	tx.ops = nil
+	tx.mu.Unlock()
+
+	mfs := tx.mfs
+	mfs.mu.Lock()
+	defer mfs.mu.Unlock()
+
+	var u undoLog
+	for _, op := range ops {
+		if err := op(&u); err != nil {
+			u.rollback()
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback discards the staged operations
+func (tx *memTx) Rollback() error {
+	tx.mu.Lock()
+	defer tx.mu.Unlock()
+
+	if tx.done {
+		return filesystem.ErrTxDone
+	}
+	tx.done = true
+	tx.ops = nil
+	return nil
+}
@@ -1,6 +1,7 @@
 package proxyfs
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net/url"
@@ -47,27 +48,30 @@ func convertFileInfos(src []agfs.FileInfo) []filesystem.FileInfo {
 type ProxyFS struct {
 	client     atomic.Pointer[agfs.Client]
 	pluginName string
-	baseURL    string // Store base URL for reload
+	baseURL    string              // Store base URL for reload
+	opts       []agfs.ClientOption // And the client options
 }
 
 // NewProxyFS creates a new ProxyFS that redirects to a remote AGFS server
 // baseURL should include the API version, e.g., "http://localhost:8080/api/v1"
-func NewProxyFS(baseURL string, pluginName string) *ProxyFS {
+// opts configure its client, e.g. agfs.WithToken for a server requiring one
+func NewProxyFS(baseURL string, pluginName string, opts ...agfs.ClientOption) *ProxyFS {
 	p := &ProxyFS{
 		pluginName: pluginName,
 		baseURL:    baseURL,
+		opts:       opts,
 	}
-	p.client.Store(agfs.NewClient(baseURL))
+	p.client.Store(agfs.NewClient(baseURL, opts...))
 	return p
 }
 
 // Reload recreates the HTTP client, useful for refreshing connections
 func (p *ProxyFS) Reload() error {
 	// Create a new client to refresh the connection
-	newClient := agfs.NewClient(p.baseURL)
+	newClient := agfs.NewClient(p.baseURL, p.opts...)
 
 	// Test the new connection
-	if err := newClient.Health(); err != nil {
+	if err := newClient.Ping(context.Background()); err != nil {
 		return fmt.Errorf("failed to connect after reload: %w", err)
 	}
 
@@ -110,14 +114,37 @@ func (p *ProxyFS) Write(path string, data []byte, offset int64, flags filesystem
 		}
 		return int64(len(data)), nil
 	}
-	// Note: SDK client doesn't support new Write signature yet
-	// For now, we ignore offset and flags and use the legacy method
-	// TODO: Update SDK to support new Write signature
-	_, err := p.client.Load().Write(path, data)
+	// Whole-file writes use the plain write API, which replaces the file
+	if offset < 0 || (offset == 0 && flags&filesystem.WriteFlagTruncate != 0) {
+		_, err := p.client.Load().Write(path, data)
+		if err != nil {
+			return 0, err
+		}
+		return int64(len(data)), nil
+	}
+	return p.writeAt(path, data, offset, flags)
+}
+
+// writeAt writes data at offset through a file handle, which the plain write
+// API can't do
+func (p *ProxyFS) writeAt(path string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	client := p.client.Load()
+	openFlags := agfs.OpenFlagWriteOnly
+	if flags&filesystem.WriteFlagCreate != 0 {
+		openFlags |= agfs.OpenFlagCreate
+	}
+	if flags&filesystem.WriteFlagTruncate != 0 {
+		openFlags |= agfs.OpenFlagTruncate
+	}
+	handle, err := client.OpenHandle(path, openFlags, 0644)
 	if err != nil {
 		return 0, err
 	}
-	return int64(len(data)), nil
+	n, err := client.WriteHandle(handle, data, offset)
+	if closeErr := client.CloseHandle(handle); err == nil {
+		err = closeErr
+	}
+	return int64(n), err
 }
 
 func (p *ProxyFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
@@ -130,11 +157,15 @@ func (p *ProxyFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 
 	// Add /reload virtual file to root directory listing
 	if path == "/" {
+		modTime := time.Now()
+		if len(files) > 0 {
+			modTime = files[0].ModTime // Use same time as first file
+		}
 		reloadFile := filesystem.FileInfo{
 			Name:    "reload",
 			Size:    0,
-			Mode:    0o200,            // write-only
-			ModTime: files[0].ModTime, // Use same time as first file
+			Mode:    0o200, // write-only
+			ModTime: modTime,
 			IsDir:   false,
 			Meta: filesystem.MetaData{
 				Type: "control",
@@ -194,6 +225,16 @@ func (p *ProxyFS) Chmod(path string, mode uint32) error {
 	return p.client.Load().Chmod(path, mode)
 }
 
+// Symlink implements filesystem.Symlinker
+func (p *ProxyFS) Symlink(targetPath, linkPath string) error {
+	return p.client.Load().Symlink(targetPath, linkPath)
+}
+
+// Readlink implements filesystem.Symlinker
+func (p *ProxyFS) Readlink(linkPath string) (string, error) {
+	return p.client.Load().Readlink(linkPath)
+}
+
 func (p *ProxyFS) Open(path string) (io.ReadCloser, error) {
 	data, err := p.client.Load().Read(path, 0, -1)
 	if err != nil {
@@ -394,7 +435,7 @@ func (p *ProxyFSPlugin) Initialize(config map[string]interface{}) error {
 	}
 
 	// Test connection to remote server with health check
-	if err := p.fs.client.Load().Health(); err != nil {
+	if err := p.fs.client.Load().Ping(context.Background()); err != nil {
 		return fmt.Errorf("failed to connect to remote AGFS server at %s: %w", p.baseURL, err)
 	}
 
@@ -511,6 +552,12 @@ func (p *ProxyFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *ProxyFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilitySymlink, plugin.CapabilityStream)
+}
+
 func (p *ProxyFSPlugin) Shutdown() error {
 	return nil
 }
@@ -362,6 +362,12 @@ func (q *QueueFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (q *QueueFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate, plugin.CapabilityHandles)
+}
+
 func (q *QueueFSPlugin) Shutdown() error {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -723,7 +729,7 @@ func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]fil
 			Mode:    0444, // read-only
 			ModTime: now,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl},
+			Meta:    controlMeta("dequeue"),
 		},
 		{
 			Name:    "peek",
@@ -731,7 +737,7 @@ func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]fil
 			Mode:    0444,            // read-only
 			ModTime: lastEnqueueTime, // Use last enqueue time for poll offset tracking
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl},
+			Meta:    controlMeta("peek"),
 		},
 		{
 			Name:    "size",
@@ -739,7 +745,7 @@ func (qfs *queueFS) getQueueControlFiles(queueName string, now time.Time) ([]fil
 			Mode:    0444, // read-only
 			ModTime: now,
 			IsDir:   false,
-			Meta:    filesystem.MetaData{Name: PluginName, Type: MetaValueQueueStatus},
+			Meta:    controlMeta("size"),
 		},
 		{
 			Name:    "clear",
@@ -850,12 +856,10 @@ func (qfs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
 		mode = 0444
 	}
 
-	fileType := MetaValueQueueControl
 	size := int64(0)
 	modTime := now
 
 	if operation == "size" {
-		fileType = MetaValueQueueStatus
 		queueSize, _ := qfs.plugin.backend.Size(queueName)
 		size = int64(len(strconv.Itoa(queueSize)))
 	} else if operation == "peek" {
@@ -872,10 +876,25 @@ func (qfs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
 		Mode:    mode,
 		ModTime: modTime,
 		IsDir:   false,
-		Meta:    filesystem.MetaData{Name: PluginName, Type: fileType},
+		Meta:    controlMeta(operation),
 	}, nil
 }
 
+// controlMeta returns the metadata of a queue control file. Reads of the
+// files that report queue state change with every read, so they are marked
+// consume-once.
+func controlMeta(operation string) filesystem.MetaData {
+	meta := filesystem.MetaData{Name: PluginName, Type: MetaValueQueueControl}
+	switch operation {
+	case "size":
+		meta.Type = MetaValueQueueStatus
+		fallthrough
+	case "dequeue", "peek":
+		meta.Content = map[string]string{filesystem.MetaAccess: filesystem.AccessConsumeOnce}
+	}
+	return meta
+}
+
 func (qfs *queueFS) Rename(oldPath, newPath string) error {
 	return fmt.Errorf("cannot rename files in queuefs service")
 }
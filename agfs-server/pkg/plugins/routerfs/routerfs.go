@@ -0,0 +1,479 @@
+package routerfs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/config"
+)
+
+const (
+	PluginName = "routerfs" // Name of this plugin
+)
+
+// child is a plugin files are routed to
+type child struct {
+	name   string // "default" or "routes[N]", for errors
+	plugin plugin.ServicePlugin
+	fs     filesystem.FileSystem
+}
+
+// route sends the files matching a glob pattern or a regular expression to
+// a child
+type route struct {
+	pattern string         // Glob pattern, "" for a regular expression
+	regex   *regexp.Regexp // nil for a glob pattern
+	child   *child
+}
+
+// matches reports whether the file at p, a path relative to the mount,
+// matches the route. Glob patterns match the file's name, or its whole path
+// without the leading slash if they have a slash; regular expressions match
+// the path with the slash.
+func (r *route) matches(p string) bool {
+	if r.regex != nil {
+		return r.regex.MatchString(p)
+	}
+	name := path.Base(p)
+	if strings.Contains(r.pattern, "/") {
+		name = strings.TrimPrefix(p, "/")
+	}
+	ok, _ := path.Match(r.pattern, name)
+	return ok
+}
+
+// RouterFSPlugin composes child plugins into a single tree, sending every
+// file to the child of the first route it matches, and the files matching
+// none to the default child. Directories exist in every child, so each can
+// hold files of any directory.
+type RouterFSPlugin struct {
+	factory  func(name string) plugin.ServicePlugin
+	routes   []*route
+	fallback *child
+	children []*child // Routed to, the default last
+}
+
+// NewRouterFSPlugin creates a new RouterFS plugin
+func NewRouterFSPlugin() *RouterFSPlugin {
+	return &RouterFSPlugin{}
+}
+
+// SetPluginFactory sets how the child plugins are created: factory returns
+// a new instance of the plugin called name, or nil if there is none. It
+// must be set before Initialize.
+func (p *RouterFSPlugin) SetPluginFactory(factory func(name string) plugin.ServicePlugin) {
+	p.factory = factory
+}
+
+func (p *RouterFSPlugin) Name() string {
+	return PluginName
+}
+
+func (p *RouterFSPlugin) Validate(cfg map[string]interface{}) error {
+	allowedKeys := []string{"routes", "default", "mount_path"}
+	if err := config.ValidateOnlyKnownKeys(cfg, allowedKeys); err != nil {
+		return err
+	}
+	_, err := parseConfig(cfg)
+	return err
+}
+
+// childConfig is the configuration of a child plugin
+type childConfig struct {
+	plugin string
+	config map[string]interface{}
+}
+
+// routeConfig is the configuration of a route
+type routeConfig struct {
+	childConfig
+	pattern string
+	regex   *regexp.Regexp
+}
+
+// routerConfig is the parsed configuration of the plugin
+type routerConfig struct {
+	routes   []routeConfig
+	fallback childConfig
+}
+
+// parseConfig parses and checks the routes and the default child of cfg
+func parseConfig(cfg map[string]interface{}) (*routerConfig, error) {
+	var rc routerConfig
+	def, ok := cfg["default"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("default is required and must be a map with a plugin")
+	}
+	var err error
+	if rc.fallback, err = parseChild("default", def); err != nil {
+		return nil, err
+	}
+
+	var routes []interface{}
+	switch v := cfg["routes"].(type) {
+	case nil:
+	case []interface{}:
+		routes = v
+	case []map[string]interface{}:
+		for _, r := range v {
+			routes = append(routes, r)
+		}
+	default:
+		return nil, fmt.Errorf("routes must be an array")
+	}
+	for i, item := range routes {
+		name := fmt.Sprintf("routes[%d]", i)
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s must be a map", name)
+		}
+		r := routeConfig{}
+		if r.childConfig, err = parseChild(name, m); err != nil {
+			return nil, err
+		}
+		pattern, hasPattern := m["pattern"].(string)
+		expr, hasRegex := m["regex"].(string)
+		if hasPattern == hasRegex {
+			return nil, fmt.Errorf("%s must have either a pattern or a regex", name)
+		}
+		if hasPattern {
+			if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+				return nil, fmt.Errorf("%s: invalid pattern %q", name, pattern)
+			}
+			r.pattern = pattern
+		} else if r.regex, err = regexp.Compile(expr); err != nil {
+			return nil, fmt.Errorf("%s: invalid regex %q: %w", name, expr, err)
+		}
+		rc.routes = append(rc.routes, r)
+	}
+	return &rc, nil
+}
+
+// parseChild parses the plugin name and configuration of a child
+func parseChild(name string, m map[string]interface{}) (childConfig, error) {
+	c := childConfig{config: map[string]interface{}{}}
+	var ok bool
+	if c.plugin, ok = m["plugin"].(string); !ok || c.plugin == "" {
+		return c, fmt.Errorf("%s: plugin is required", name)
+	}
+	if c.plugin == PluginName {
+		return c, fmt.Errorf("%s: %s can't route to itself", name, PluginName)
+	}
+	switch v := m["config"].(type) {
+	case nil:
+	case map[string]interface{}:
+		c.config = v
+	default:
+		return c, fmt.Errorf("%s: config must be a map", name)
+	}
+	return c, nil
+}
+
+func (p *RouterFSPlugin) Initialize(cfg map[string]interface{}) error {
+	if p.factory == nil {
+		return fmt.Errorf("no plugin factory set")
+	}
+	rc, err := parseConfig(cfg)
+	if err != nil {
+		return err
+	}
+	mountPath, _ := cfg["mount_path"].(string)
+
+	// Children are initialized in order, those done shut down on failure
+	newChild := func(name string, c childConfig) (*child, error) {
+		cp := p.factory(c.plugin)
+		if cp == nil {
+			return nil, fmt.Errorf("%s: unknown plugin %s", name, c.plugin)
+		}
+		childCfg := make(map[string]interface{}, len(c.config)+1)
+		for k, v := range c.config {
+			childCfg[k] = v
+		}
+		childCfg["mount_path"] = mountPath
+		if err := plugin.Initialize(cp, childCfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		ch := &child{name: name, plugin: cp, fs: cp.GetFileSystem()}
+		p.children = append(p.children, ch)
+		return ch, nil
+	}
+	for i, r := range rc.routes {
+		ch, err := newChild(fmt.Sprintf("routes[%d]", i), r.childConfig)
+		if err != nil {
+			p.Shutdown()
+			return err
+		}
+		p.routes = append(p.routes, &route{pattern: r.pattern, regex: r.regex, child: ch})
+	}
+	if p.fallback, err = newChild("default", rc.fallback); err != nil {
+		p.Shutdown()
+		return err
+	}
+	return nil
+}
+
+func (p *RouterFSPlugin) GetFileSystem() filesystem.FileSystem {
+	return &routerFS{plugin: p}
+}
+
+func (p *RouterFSPlugin) GetReadme() string {
+	return `RouterFS Plugin - Route files to plugins by name
+
+This plugin composes other plugins into a single tree. Each file goes to
+the plugin of the first route it matches, or to the default plugin if it
+matches none. Directories are created in every plugin, so files of one
+directory can be spread over several.
+
+CONFIGURATION:
+  routes:   Ordered list of routes, each with
+              pattern: Glob matched against the file name, or against the
+                       whole path (without the leading /) if it has a /
+              regex:   Regular expression matched against the path instead
+              plugin:  Plugin the matching files go to
+              config:  Configuration of that plugin
+  default:  plugin and config of the plugin for the other files
+
+EXAMPLE:
+  routerfs:
+    enabled: true
+    path: /data
+    config:
+      routes:
+        - pattern: "*.log"
+          plugin: localfs
+          config:
+            local_dir: /var/log/agfs
+      default:
+        plugin: memfs
+
+  echo hello > /data/app.log     # stored by localfs
+  echo hello > /data/notes.txt   # stored by memfs
+
+Renaming a file to a name routed to another plugin is not supported.
+`
+}
+
+func (p *RouterFSPlugin) GetConfigParams() []plugin.ConfigParameter {
+	return []plugin.ConfigParameter{
+		{
+			Name:        "routes",
+			Type:        "array",
+			Required:    false,
+			Default:     "",
+			Description: "Ordered routes, each a pattern (glob) or regex with the plugin and config the matching files go to",
+		},
+		{
+			Name:        "default",
+			Type:        "map",
+			Required:    true,
+			Default:     "",
+			Description: "Plugin and config of the files matching no route",
+		},
+	}
+}
+
+// Capabilities returns the baseline operations, which are all routed
+func (p *RouterFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities()
+}
+
+func (p *RouterFSPlugin) Shutdown() error {
+	var firstErr error
+	for _, ch := range p.children {
+		if err := ch.plugin.Shutdown(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %w", ch.name, err)
+		}
+	}
+	return firstErr
+}
+
+// routerFS dispatches the operations of the file system to the children
+type routerFS struct {
+	plugin *RouterFSPlugin
+}
+
+// route returns the child the file at p goes to
+func (r *routerFS) route(p string) *child {
+	for _, rt := range r.plugin.routes {
+		if rt.matches(p) {
+			return rt.child
+		}
+	}
+	return r.plugin.fallback
+}
+
+// isDir reports whether p is a directory in any child
+func (r *routerFS) isDir(p string) bool {
+	for _, ch := range r.plugin.children {
+		if info, err := ch.fs.Stat(p); err == nil && info.IsDir {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *routerFS) Create(p string) error {
+	return r.route(p).fs.Create(p)
+}
+
+// Mkdir creates the directory in every child. The error is the one of the
+// child p would be routed to as a file; the others may have it already.
+func (r *routerFS) Mkdir(p string, perm uint32) error {
+	routed := r.route(p)
+	err := routed.fs.Mkdir(p, perm)
+	for _, ch := range r.plugin.children {
+		if ch != routed {
+			ch.fs.Mkdir(p, perm)
+		}
+	}
+	return err
+}
+
+// Remove removes a file from its child, or an empty directory from every
+// child holding it
+func (r *routerFS) Remove(p string) error {
+	if !r.isDir(p) {
+		return r.route(p).fs.Remove(p)
+	}
+	for _, ch := range r.plugin.children {
+		if _, err := ch.fs.Stat(p); err != nil {
+			continue
+		}
+		if err := ch.fs.Remove(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveAll removes p from every child holding it
+func (r *routerFS) RemoveAll(p string) error {
+	found := false
+	for _, ch := range r.plugin.children {
+		if _, err := ch.fs.Stat(p); err != nil {
+			continue
+		}
+		found = true
+		if err := ch.fs.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return r.route(p).fs.RemoveAll(p)
+	}
+	return nil
+}
+
+func (r *routerFS) Read(p string, offset int64, size int64) ([]byte, error) {
+	return r.route(p).fs.Read(p, offset, size)
+}
+
+func (r *routerFS) Write(p string, data []byte, offset int64, flags filesystem.WriteFlag) (int64, error) {
+	return r.route(p).fs.Write(p, data, offset, flags)
+}
+
+// ReadDir merges the listings of the directory in every child holding it.
+// Files are only listed from the child they are routed to, so files a
+// child holds under a name routed elsewhere stay hidden.
+func (r *routerFS) ReadDir(p string) ([]filesystem.FileInfo, error) {
+	var entries []filesystem.FileInfo
+	seen := make(map[string]bool)
+	var firstErr error
+	found := false
+	for _, ch := range r.plugin.children {
+		list, err := ch.fs.ReadDir(p)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		found = true
+		for _, info := range list {
+			if seen[info.Name] {
+				continue
+			}
+			if !info.IsDir && r.route(path.Join(p, info.Name)) != ch {
+				continue
+			}
+			seen[info.Name] = true
+			entries = append(entries, info)
+		}
+	}
+	if !found {
+		return nil, firstErr
+	}
+	return entries, nil
+}
+
+// Stat returns the file from the child p is routed to, or the directory
+// from any child holding it
+func (r *routerFS) Stat(p string) (*filesystem.FileInfo, error) {
+	routed := r.route(p)
+	info, err := routed.fs.Stat(p)
+	if err == nil {
+		return info, nil
+	}
+	for _, ch := range r.plugin.children {
+		if ch == routed {
+			continue
+		}
+		if other, otherErr := ch.fs.Stat(p); otherErr == nil && other.IsDir {
+			return other, nil
+		}
+	}
+	return nil, err
+}
+
+// Rename renames a file within its child, or a directory in every child
+// holding it. Files can't be renamed to a name routed to another child.
+func (r *routerFS) Rename(oldPath, newPath string) error {
+	if r.isDir(oldPath) {
+		for _, ch := range r.plugin.children {
+			if _, err := ch.fs.Stat(oldPath); err != nil {
+				continue
+			}
+			if err := ch.fs.Rename(oldPath, newPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	routed := r.route(oldPath)
+	if r.route(newPath) != routed {
+		return fmt.Errorf("cannot rename across different routes")
+	}
+	return routed.fs.Rename(oldPath, newPath)
+}
+
+// Chmod changes the mode of a file in its child, or of a directory in every
+// child holding it
+func (r *routerFS) Chmod(p string, mode uint32) error {
+	if !r.isDir(p) {
+		return r.route(p).fs.Chmod(p, mode)
+	}
+	var errs []error
+	for _, ch := range r.plugin.children {
+		if _, err := ch.fs.Stat(p); err == nil {
+			errs = append(errs, ch.fs.Chmod(p, mode))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (r *routerFS) Open(p string) (io.ReadCloser, error) {
+	return r.route(p).fs.Open(p)
+}
+
+func (r *routerFS) OpenWrite(p string) (io.WriteCloser, error) {
+	return r.route(p).fs.OpenWrite(p)
+}
+
+// Ensure RouterFSPlugin implements ServicePlugin
+var _ plugin.ServicePlugin = (*RouterFSPlugin)(nil)
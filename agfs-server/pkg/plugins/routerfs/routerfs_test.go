@@ -0,0 +1,163 @@
+package routerfs
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+// newRouter returns a router of memfs children initialized with cfg, and the
+// file systems of its children in the order they were created
+func newRouter(t *testing.T, cfg map[string]interface{}) (filesystem.FileSystem, []filesystem.FileSystem) {
+	t.Helper()
+	var children []*memfs.MemFSPlugin
+	p := NewRouterFSPlugin()
+	p.SetPluginFactory(func(name string) plugin.ServicePlugin {
+		if name != "memfs" {
+			return nil
+		}
+		child := memfs.NewMemFSPlugin()
+		children = append(children, child)
+		return child
+	})
+	if err := plugin.Initialize(p, cfg); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	t.Cleanup(func() { p.Shutdown() })
+	var fss []filesystem.FileSystem
+	for _, c := range children {
+		fss = append(fss, c.GetFileSystem())
+	}
+	return p.GetFileSystem(), fss
+}
+
+func testConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"routes": []interface{}{
+			map[string]interface{}{"pattern": "*.log", "plugin": "memfs"},
+			map[string]interface{}{"regex": "^/tmp/", "plugin": "memfs"},
+		},
+		"default": map[string]interface{}{"plugin": "memfs"},
+	}
+}
+
+func TestRouterRoutesWrites(t *testing.T) {
+	fs, children := newRouter(t, testConfig())
+	if len(children) != 3 {
+		t.Fatalf("Expected 3 children, got %d", len(children))
+	}
+	logs, tmp, fallback := children[0], children[1], children[2]
+
+	for _, dir := range []string{"/dir", "/tmp"} {
+		if err := fs.Mkdir(dir, 0755); err != nil {
+			t.Fatalf("Mkdir %s failed: %v", dir, err)
+		}
+	}
+	for _, p := range []string{"/dir/a.log", "/dir/b.txt", "/tmp/c.log", "/tmp/d.txt", "/e.txt"} {
+		if _, err := fs.Write(p, []byte(p), 0, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", p, err)
+		}
+	}
+
+	// The first matching route wins, unmatched files go to the default
+	for child, want := range map[filesystem.FileSystem][]string{
+		logs:     {"/dir/a.log", "/tmp/c.log"},
+		tmp:      {"/tmp/d.txt"},
+		fallback: {"/dir/b.txt", "/e.txt"},
+	} {
+		for _, other := range children {
+			for _, p := range want {
+				_, err := other.Stat(p)
+				if other == child && err != nil {
+					t.Errorf("Expected %s in its child, got %v", p, err)
+				}
+				if other != child && err == nil {
+					t.Errorf("Expected %s in a single child", p)
+				}
+			}
+		}
+	}
+	data, err := fs.Read("/dir/a.log", 0, -1)
+	if (err != nil && err != io.EOF) || string(data) != "/dir/a.log" {
+		t.Errorf("Expected /dir/a.log read back, got %q, %v", data, err)
+	}
+
+	// Directories hold the files of every child
+	entries, err := fs.ReadDir("/dir")
+	if err != nil {
+		t.Fatalf("ReadDir failed: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	sort.Strings(names)
+	if len(names) != 2 || names[0] != "a.log" || names[1] != "b.txt" {
+		t.Errorf("Expected a.log and b.txt listed, got %v", names)
+	}
+
+	// Files stay with their child
+	if err := fs.Rename("/dir/b.txt", "/dir/b.log"); err == nil {
+		t.Error("Expected a rename to another route to fail")
+	}
+	if err := fs.Rename("/dir/b.txt", "/dir/c.txt"); err != nil {
+		t.Errorf("Rename failed: %v", err)
+	}
+	if err := fs.RemoveAll("/dir"); err != nil {
+		t.Fatalf("RemoveAll failed: %v", err)
+	}
+	for _, child := range children {
+		if _, err := child.Stat("/dir"); err == nil {
+			t.Error("Expected /dir removed from every child")
+		}
+	}
+}
+
+func TestRouterConfig(t *testing.T) {
+	for name, cfg := range map[string]map[string]interface{}{
+		"no default":   {"routes": []interface{}{}},
+		"bad pattern":  {"routes": []interface{}{map[string]interface{}{"pattern": "[", "plugin": "memfs"}}, "default": map[string]interface{}{"plugin": "memfs"}},
+		"bad regex":    {"routes": []interface{}{map[string]interface{}{"regex": "(", "plugin": "memfs"}}, "default": map[string]interface{}{"plugin": "memfs"}},
+		"both":         {"routes": []interface{}{map[string]interface{}{"pattern": "*", "regex": ".", "plugin": "memfs"}}, "default": map[string]interface{}{"plugin": "memfs"}},
+		"no plugin":    {"routes": []interface{}{map[string]interface{}{"pattern": "*"}}, "default": map[string]interface{}{"plugin": "memfs"}},
+		"itself":       {"default": map[string]interface{}{"plugin": PluginName}},
+		"unknown keys": {"default": map[string]interface{}{"plugin": "memfs"}, "route": []interface{}{}},
+	} {
+		if err := NewRouterFSPlugin().Validate(cfg); err == nil {
+			t.Errorf("%s: Expected the configuration refused", name)
+		}
+	}
+
+	// Children failing to initialize fail the router
+	p := NewRouterFSPlugin()
+	p.SetPluginFactory(func(string) plugin.ServicePlugin { return nil })
+	err := p.Initialize(map[string]interface{}{"default": map[string]interface{}{"plugin": "nosuchfs"}})
+	if err == nil {
+		t.Error("Expected an unknown child plugin to fail")
+	}
+}
+
+func TestRouterMountedByName(t *testing.T) {
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	mfs.RegisterPluginFactory("memfs", func() plugin.ServicePlugin { return memfs.NewMemFSPlugin() })
+	mfs.RegisterPluginFactory(PluginName, func() plugin.ServicePlugin { return NewRouterFSPlugin() })
+	if err := mfs.MountPlugin(PluginName, "/data", testConfig()); err != nil {
+		t.Fatalf("MountPlugin failed: %v", err)
+	}
+	if _, err := mfs.Write("/data/app.log", []byte("log"), 0, filesystem.WriteFlagCreate); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if _, err := mfs.Stat("/data/app.log"); err != nil {
+		t.Errorf("Expected the file written, got %v", err)
+	}
+	if _, err := mfs.Stat("/data/missing.log"); !errors.Is(err, filesystem.ErrNotFound) {
+		t.Errorf("Expected a missing file not found, got %v", err)
+	}
+}
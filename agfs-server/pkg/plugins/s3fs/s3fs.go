@@ -682,6 +682,12 @@ func (p *S3FSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *S3FSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate, plugin.CapabilityStream)
+}
+
 func (p *S3FSPlugin) Shutdown() error {
 	return nil
 }
@@ -18,6 +18,7 @@ type ServerInfoFSPlugin struct {
 	startTime      time.Time
 	version        string
 	trafficMonitor TrafficStatsProvider
+	usageProvider  UsageStatsProvider
 }
 
 // TrafficStatsProvider provides traffic statistics
@@ -25,6 +26,11 @@ type TrafficStatsProvider interface {
 	GetStats() interface{}
 }
 
+// UsageStatsProvider provides the usage of the mounts
+type UsageStatsProvider interface {
+	GetUsageStats() interface{}
+}
+
 // NewServerInfoFSPlugin creates a new ServerInfoFS plugin
 func NewServerInfoFSPlugin() *ServerInfoFSPlugin {
 	return &ServerInfoFSPlugin{
@@ -38,6 +44,11 @@ func (p *ServerInfoFSPlugin) SetTrafficMonitor(tm TrafficStatsProvider) {
 	p.trafficMonitor = tm
 }
 
+// SetUsageProvider sets the provider of the mounts' usage for the plugin
+func (p *ServerInfoFSPlugin) SetUsageProvider(up UsageStatsProvider) {
+	p.usageProvider = up
+}
+
 func (p *ServerInfoFSPlugin) Name() string {
 	return "serverinfofs"
 }
@@ -87,12 +98,16 @@ USAGE:
   View real-time traffic:
     cat /traffic
 
+  View how much each mount holds:
+    cat /usage
+
 FILES:
   /version  - Server version information
   /uptime   - Server uptime since start
   /info     - Complete server information (JSON)
   /stats    - Runtime statistics (goroutines, memory)
   /traffic  - Real-time network traffic statistics
+  /usage    - Usage of each mount (bytes, files, inodes)
   /README   - This file
 
 EXAMPLES:
@@ -124,6 +139,20 @@ EXAMPLES:
     "total_upload_bytes": 536870912,
     "uptime_seconds": 3600
   }
+
+  # View mount usage
+  agfs:/> cat /serverinfofs/usage
+  {
+    "/memfs": {
+      "bytes": 1048576,
+      "files": 12,
+      "directories": 3,
+      "symlinks": 1,
+      "inodes": 16,
+      "native": false,
+      "updated": "2024-01-01T00:00:00Z"
+    }
+  }
 `
 }
 
@@ -131,6 +160,12 @@ func (p *ServerInfoFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	return []plugin.ConfigParameter{}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *ServerInfoFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate)
+}
+
 func (p *ServerInfoFSPlugin) Shutdown() error {
 	return nil
 }
@@ -147,12 +182,13 @@ const (
 	fileVersion    = "/version"
 	fileStats      = "/stats"
 	fileTraffic    = "/traffic"
+	fileUsage      = "/usage"
 	fileReadme     = "/README"
 )
 
 func (fs *serverInfoFS) isValidPath(path string) bool {
 	switch path {
-	case "/", fileServerInfo, fileUptime, fileVersion, fileStats, fileTraffic, fileReadme:
+	case "/", fileServerInfo, fileUptime, fileVersion, fileStats, fileTraffic, fileUsage, fileReadme:
 		return true
 	default:
 		return false
@@ -235,6 +271,17 @@ func (fs *serverInfoFS) Read(path string, offset int64, size int64) ([]byte, err
 			}
 		}
 
+	case fileUsage:
+		if fs.plugin.usageProvider == nil {
+			data = []byte("Usage not available")
+		} else {
+			stats := fs.plugin.usageProvider.GetUsageStats()
+			data, err = json.MarshalIndent(stats, "", "  ")
+			if err != nil {
+				return nil, err
+			}
+		}
+
 	case fileReadme:
 		data = []byte(fs.plugin.GetReadme())
 
@@ -284,6 +331,7 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 	versionData, _ := fs.Read(fileVersion, 0, -1)
 	statsData, _ := fs.Read(fileStats, 0, -1)
 	trafficData, _ := fs.Read(fileTraffic, 0, -1)
+	usageData, _ := fs.Read(fileUsage, 0, -1)
 
 	return []filesystem.FileInfo{
 		{
@@ -334,6 +382,14 @@ func (fs *serverInfoFS) ReadDir(path string) ([]filesystem.FileInfo, error) {
 			IsDir:   false,
 			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "traffic"},
 		},
+		{
+			Name:    "usage",
+			Size:    int64(len(usageData)),
+			Mode:    0444,
+			ModTime: now,
+			IsDir:   false,
+			Meta:    filesystem.MetaData{Name: "serverinfofs", Type: "info"},
+		},
 	}, nil
 }
 
@@ -24,6 +24,7 @@ const (
 
 // SQLFSPlugin provides a database-backed file system
 type SQLFSPlugin struct {
+	plugin.BasePlugin
 	fs      *SQLFS
 	backend DBBackend
 	config  map[string]interface{}
@@ -386,6 +386,12 @@ func (p *SQLFS2Plugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *SQLFS2Plugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate, plugin.CapabilityHandles)
+}
+
 func (p *SQLFS2Plugin) Shutdown() error {
 	if p.sessionManager != nil {
 		p.sessionManager.Stop()
@@ -336,8 +336,9 @@ func (sf *StreamFile) GetInfo() filesystem.FileInfo {
 			Name: PluginName,
 			Type: "stream",
 			Content: map[string]string{
-				"total_written":  fmt.Sprintf("%d", sf.offset),
-				"active_readers": fmt.Sprintf("%d", len(sf.readers)),
+				"total_written":       fmt.Sprintf("%d", sf.offset),
+				"active_readers":      fmt.Sprintf("%d", len(sf.readers)),
+				filesystem.MetaAccess: filesystem.AccessStream,
 			},
 		},
 	}
@@ -724,6 +725,12 @@ func (p *StreamFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *StreamFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate, plugin.CapabilityStream, plugin.CapabilityHandles)
+}
+
 func (p *StreamFSPlugin) Shutdown() error {
 	return nil
 }
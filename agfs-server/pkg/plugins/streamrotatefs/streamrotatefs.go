@@ -480,11 +480,12 @@ func (rsf *RotateStreamFile) GetInfo() filesystem.FileInfo {
 			Name: PluginName,
 			Type: "rotate-stream",
 			Content: map[string]string{
-				"total_written":      fmt.Sprintf("%d", rsf.offset),
-				"active_readers":     fmt.Sprintf("%d", len(rsf.readers)),
-				"current_file_size":  fmt.Sprintf("%d", rsf.currentFileSize),
-				"rotation_file_idx":  fmt.Sprintf("%d", rsf.fileIndex),
-				"rotation_threshold": formatSize(rsf.config.RotationSize),
+				"total_written":       fmt.Sprintf("%d", rsf.offset),
+				"active_readers":      fmt.Sprintf("%d", len(rsf.readers)),
+				"current_file_size":   fmt.Sprintf("%d", rsf.currentFileSize),
+				"rotation_file_idx":   fmt.Sprintf("%d", rsf.fileIndex),
+				"rotation_threshold":  formatSize(rsf.config.RotationSize),
+				filesystem.MetaAccess: filesystem.AccessStream,
 			},
 		},
 	}
@@ -944,6 +945,12 @@ func (p *StreamRotateFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (p *StreamRotateFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityStream)
+}
+
 func (p *StreamRotateFSPlugin) Shutdown() error {
 	return nil
 }
@@ -345,6 +345,12 @@ func (v *VectorFSPlugin) GetConfigParams() []plugin.ConfigParameter {
 	}
 }
 
+// Capabilities returns the baseline operations plus the optional ones this
+// plugin implements
+func (v *VectorFSPlugin) Capabilities() plugin.CapabilitySet {
+	return plugin.BaselineCapabilities().With(plugin.CapabilityTruncate)
+}
+
 func (v *VectorFSPlugin) Shutdown() error {
 	v.mu.Lock()
 	defer v.mu.Unlock()
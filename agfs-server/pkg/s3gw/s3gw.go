@@ -0,0 +1,436 @@
+// Package s3gw serves the mounts of a MountableFS through the read path of
+// the S3 REST API, so tools that speak S3 can read agfs without a new
+// integration. Each top-level directory holding mounts is a bucket, and
+// paths below it are object keys. Requests use path-style addressing
+// (http://host/bucket/key) and are not authenticated.
+package s3gw
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/httpgw"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxKeys is the largest page ListObjects returns, as in S3
+const maxKeys = 1000
+
+// chunkSize is how much of an object is read per Read call while streaming
+// it to the client
+const chunkSize = 256 * 1024
+
+const xmlns = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Gateway is an http.Handler serving ListBuckets, ListObjects (V1 and V2),
+// GetObject, HeadObject and HeadBucket over a MountableFS
+type Gateway struct {
+	mfs *mountablefs.MountableFS
+}
+
+// New creates a gateway serving mfs
+func New(mfs *mountablefs.MountableFS) *Gateway {
+	return &Gateway{mfs: mfs}
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", "only the read-only S3 operations are supported")
+		return
+	}
+	if bucket == "" {
+		g.listBuckets(w, r)
+		return
+	}
+	if !g.hasBucket(bucket) {
+		writeError(w, r, http.StatusNotFound, "NoSuchBucket", "the specified bucket does not exist")
+		return
+	}
+
+	fs := filesystem.WithContext(g.mfs, r.Context())
+	switch {
+	case key != "":
+		g.getObject(w, r, fs, bucket, key)
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+	default:
+		g.listObjects(w, r, fs, bucket)
+	}
+}
+
+// buckets returns the top-level directories holding mounts, sorted
+func (g *Gateway) buckets() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, mount := range g.mfs.GetMounts() {
+		name, _, _ := strings.Cut(strings.TrimPrefix(mount.Path, "/"), "/")
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (g *Gateway) hasBucket(name string) bool {
+	for _, b := range g.buckets() {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name      `xml:"ListAllMyBucketsResult"`
+	Xmlns   string        `xml:"xmlns,attr"`
+	Owner   owner         `xml:"Owner"`
+	Buckets []bucketEntry `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketEntry struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+func (g *Gateway) listBuckets(w http.ResponseWriter, r *http.Request) {
+	result := listAllMyBucketsResult{Xmlns: xmlns, Owner: owner{ID: "agfs", DisplayName: "agfs"}}
+	for _, name := range g.buckets() {
+		var created time.Time
+		if info, err := g.mfs.Stat("/" + name); err == nil {
+			created = info.ModTime
+		}
+		result.Buckets = append(result.Buckets, bucketEntry{Name: name, CreationDate: formatTime(created)})
+	}
+	writeXML(w, r, http.StatusOK, result)
+}
+
+type listBucketResult struct {
+	XMLName        xml.Name       `xml:"ListBucketResult"`
+	Xmlns          string         `xml:"xmlns,attr"`
+	Name           string         `xml:"Name"`
+	Prefix         string         `xml:"Prefix"`
+	Delimiter      string         `xml:"Delimiter,omitempty"`
+	MaxKeys        int            `xml:"MaxKeys"`
+	IsTruncated    bool           `xml:"IsTruncated"`
+	Contents       []objectEntry  `xml:"Contents"`
+	CommonPrefixes []commonPrefix `xml:"CommonPrefixes"`
+
+	// ListObjects (V1)
+	Marker     string `xml:"Marker,omitempty"`
+	NextMarker string `xml:"NextMarker,omitempty"`
+
+	// ListObjectsV2
+	KeyCount              *int   `xml:"KeyCount,omitempty"`
+	ContinuationToken     string `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string `xml:"StartAfter,omitempty"`
+}
+
+type objectEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// object is a key found while listing a bucket: a file, or a common prefix
+// standing for everything under it
+type object struct {
+	key    string
+	info   filesystem.FileInfo
+	prefix bool
+}
+
+// listObjects serves ListObjects and ListObjectsV2. Keys are listed in S3
+// order and paged by the last key returned, which is what V2's
+// continuation token encodes.
+func (g *Gateway) listObjects(w http.ResponseWriter, r *http.Request, fs filesystem.FileSystem, bucket string) {
+	q := r.URL.Query()
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	v2 := q.Get("list-type") == "2"
+
+	limit := maxKeys
+	if s := q.Get("max-keys"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeError(w, r, http.StatusBadRequest, "InvalidArgument", "max-keys must be a non-negative integer")
+			return
+		}
+		limit = min(n, maxKeys)
+	}
+
+	after := q.Get("marker")
+	if v2 {
+		after = q.Get("start-after")
+		if token := q.Get("continuation-token"); token != "" {
+			decoded, err := base64.StdEncoding.DecodeString(token)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, "InvalidArgument", "the continuation token is not valid")
+				return
+			}
+			after = string(decoded)
+		}
+	}
+
+	objects, err := g.collect(fs, bucket, prefix, delimiter)
+	if err != nil {
+		writeFSError(w, r, err)
+		return
+	}
+	start := sort.Search(len(objects), func(i int) bool { return objects[i].key > after })
+	objects = objects[start:]
+
+	result := listBucketResult{
+		Xmlns:     xmlns,
+		Name:      bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   limit,
+	}
+	if len(objects) > limit {
+		objects = objects[:limit]
+		result.IsTruncated = true
+	}
+	for _, o := range objects {
+		if o.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: o.key})
+			continue
+		}
+		result.Contents = append(result.Contents, objectEntry{
+			Key:          o.key,
+			LastModified: formatTime(o.info.ModTime),
+			ETag:         etag(&o.info),
+			Size:         o.info.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	var last string
+	if len(objects) > 0 {
+		last = objects[len(objects)-1].key
+	}
+	if v2 {
+		count := len(objects)
+		result.KeyCount = &count
+		result.ContinuationToken = q.Get("continuation-token")
+		result.StartAfter = q.Get("start-after")
+		if result.IsTruncated {
+			result.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(last))
+		}
+	} else {
+		result.Marker = q.Get("marker")
+		if result.IsTruncated {
+			result.NextMarker = last
+		}
+	}
+	writeXML(w, r, http.StatusOK, result)
+}
+
+// collect returns the files of bucket whose keys start with prefix, sorted
+// by key, with the keys containing delimiter after the prefix rolled up
+// into common prefixes. With the "/" delimiter only the directory holding
+// the prefix is read; otherwise every directory that can hold a match is
+// walked.
+func (g *Gateway) collect(fs filesystem.FileSystem, bucket, prefix, delimiter string) ([]object, error) {
+	// The directory whose entries can match prefix
+	dirKey := ""
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		dirKey = prefix[:i+1]
+	}
+
+	var objects []object
+	seen := make(map[string]bool)
+	var walk func(dirKey string) error
+	walk = func(dirKey string) error {
+		entries, err := fs.ReadDir(path.Join("/", bucket, dirKey))
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			// The .agfs directory describes the plugin, it holds no objects
+			if entry.Meta.Type == mountablefs.MetaValueVirtual {
+				continue
+			}
+			key := dirKey + entry.Name
+			if entry.IsDir {
+				key += "/"
+				// Descend only into directories that can hold a match
+				if !strings.HasPrefix(key, prefix) && !strings.HasPrefix(prefix, key) {
+					continue
+				}
+				if delimiter == "/" && strings.HasPrefix(key, prefix) {
+					objects = append(objects, object{key: key, prefix: true})
+					continue
+				}
+				if err := walk(key); err != nil {
+					return err
+				}
+				continue
+			}
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			if delimiter != "" {
+				if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+					common := key[:len(prefix)+i+len(delimiter)]
+					if !seen[common] {
+						seen[common] = true
+						objects = append(objects, object{key: common, prefix: true})
+					}
+					continue
+				}
+			}
+			objects = append(objects, object{key: key, info: entry})
+		}
+		return nil
+	}
+
+	if err := walk(dirKey); err != nil {
+		// A prefix naming no directory matches nothing
+		if isNotFound(err) && dirKey != "" {
+			return nil, nil
+		}
+		return nil, err
+	}
+	sort.Slice(objects, func(i, j int) bool { return objects[i].key < objects[j].key })
+	return objects, nil
+}
+
+// getObject serves GetObject and HeadObject, with a single byte range
+func (g *Gateway) getObject(w http.ResponseWriter, r *http.Request, fs filesystem.FileSystem, bucket, key string) {
+	name := path.Join("/", bucket, key)
+	info, err := fs.Stat(name)
+	if err == nil && info.IsDir {
+		err = filesystem.NewNotFoundError("stat", name)
+	}
+	if err != nil {
+		writeFSError(w, r, err)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", filesystem.ContentType(fs, name, info))
+	h.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	h.Set("ETag", etag(info))
+	h.Set("Accept-Ranges", "bytes")
+
+	offset, length := int64(0), info.Size
+	status := http.StatusOK
+	if header := r.Header.Get("Range"); header != "" {
+		start, n, ok, err := httpgw.ParseRange(header, info.Size)
+		if err != nil {
+			writeError(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "the requested range is not satisfiable")
+			return
+		}
+		if ok {
+			offset, length = start, n
+			status = http.StatusPartialContent
+			h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, info.Size))
+		}
+	}
+
+	h.Set("Content-Length", strconv.FormatInt(length, 10))
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	for length > 0 {
+		data, err := fs.Read(name, offset, min(chunkSize, length))
+		if len(data) > 0 {
+			if _, werr := w.Write(data); werr != nil {
+				return
+			}
+			offset += int64(len(data))
+			length -= int64(len(data))
+		}
+		if err == io.EOF || (err == nil && len(data) == 0) {
+			return
+		}
+		if err != nil {
+			log.Warnf("s3gw: read of %s failed at offset %d: %v", name, offset, err)
+			return
+		}
+	}
+}
+
+// etag identifies a version of a file by its size and modification time.
+// The dash marks it, like a multipart upload's, as not being an MD5 of the
+// content, so clients don't try to verify it.
+func etag(info *filesystem.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime.UnixNano(), info.Size)
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func isNotFound(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || filesystem.KindOf(err) == filesystem.KindNotFound
+}
+
+type errorResponse struct {
+	XMLName  xml.Name `xml:"Error"`
+	Code     string   `xml:"Code"`
+	Message  string   `xml:"Message"`
+	Resource string   `xml:"Resource"`
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	writeXML(w, r, status, errorResponse{Code: code, Message: message, Resource: r.URL.Path})
+}
+
+// writeFSError maps a filesystem error to an S3 error
+func writeFSError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case isNotFound(err):
+		writeError(w, r, http.StatusNotFound, "NoSuchKey", "the specified key does not exist")
+	case filesystem.KindOf(err) == filesystem.KindPermissionDenied:
+		writeError(w, r, http.StatusForbidden, "AccessDenied", err.Error())
+	case filesystem.KindOf(err) == filesystem.KindNotSupported:
+		writeError(w, r, http.StatusNotImplemented, "NotImplemented", err.Error())
+	case filesystem.KindOf(err) == filesystem.KindRateLimited:
+		writeError(w, r, http.StatusServiceUnavailable, "SlowDown", err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, "InternalError", err.Error())
+	}
+}
+
+// writeXML writes v as the response. HEAD responses, and errors for them,
+// carry no body.
+func writeXML(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	io.WriteString(w, xml.Header)
+	if err := xml.NewEncoder(w).Encode(v); err != nil {
+		log.Warnf("s3gw: failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package s3gw
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/mountablefs"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugin/api"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestGateway(t *testing.T, files map[string]string) *httptest.Server {
+	t.Helper()
+	mfs := mountablefs.NewMountableFS(api.PoolConfig{})
+	plugin := memfs.NewMemFSPlugin()
+	if err := plugin.Initialize(map[string]interface{}{}); err != nil {
+		t.Fatalf("Failed to initialize memfs: %v", err)
+	}
+	if err := mfs.Mount("/data", plugin); err != nil {
+		t.Fatalf("Failed to mount memfs: %v", err)
+	}
+	for name, content := range files {
+		if err := filesystem.MkdirAll(mfs, path.Dir("/data"+name), 0755); err != nil {
+			t.Fatalf("MkdirAll for %s failed: %v", name, err)
+		}
+		if _, err := mfs.Write("/data"+name, []byte(content), -1, filesystem.WriteFlagCreate); err != nil {
+			t.Fatalf("Write %s failed: %v", name, err)
+		}
+	}
+	srv := httptest.NewServer(New(mfs))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func get(t *testing.T, srv *httptest.Server, method, target string, header map[string]string) (*http.Response, []byte) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+target, nil)
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, target, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Reading body failed: %v", err)
+	}
+	return resp, body
+}
+
+func listObjects(t *testing.T, srv *httptest.Server, query url.Values) listBucketResult {
+	t.Helper()
+	resp, body := get(t, srv, http.MethodGet, "/data?"+query.Encode(), nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("ListObjects failed with %d: %s", resp.StatusCode, body)
+	}
+	var result listBucketResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		t.Fatalf("Failed to parse ListObjects response %s: %v", body, err)
+	}
+	return result
+}
+
+func TestListBuckets(t *testing.T) {
+	srv := newTestGateway(t, nil)
+
+	resp, body := get(t, srv, http.MethodGet, "/", nil)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", resp.StatusCode)
+	}
+	var result listAllMyBucketsResult
+	if err := xml.Unmarshal(body, &result); err != nil {
+		t.Fatalf("Failed to parse ListBuckets response %s: %v", body, err)
+	}
+	if len(result.Buckets) != 1 || result.Buckets[0].Name != "data" {
+		t.Errorf("Expected the data bucket, got %+v", result.Buckets)
+	}
+
+	resp, body = get(t, srv, http.MethodGet, "/missing?list-type=2", nil)
+	if resp.StatusCode != http.StatusNotFound || !bytes.Contains(body, []byte("<Code>NoSuchBucket</Code>")) {
+		t.Errorf("Expected NoSuchBucket, got %d %s", resp.StatusCode, body)
+	}
+}
+
+func TestListObjectsV2Pagination(t *testing.T) {
+	srv := newTestGateway(t, map[string]string{
+		"/a.txt":       "a",
+		"/b/1.txt":     "b1",
+		"/b/2.txt":     "b2",
+		"/b/c/3.txt":   "c3",
+		"/b-side.txt":  "bs",
+		"/logs/x.log":  "x",
+		"/zz/deep/y.y": "y",
+	})
+
+	// Without a delimiter every key is listed, in S3 order, two per page
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "max-keys": {"2"}}
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("Pagination did not terminate")
+		}
+		result := listObjects(t, srv, query)
+		if *result.KeyCount != len(result.Contents) || len(result.Contents) > 2 {
+			t.Fatalf("Expected at most 2 keys per page, got %+v", result)
+		}
+		for _, c := range result.Contents {
+			keys = append(keys, c.Key)
+		}
+		if !result.IsTruncated {
+			break
+		}
+		query.Set("continuation-token", result.NextContinuationToken)
+	}
+	// memfs serves a README at its root
+	want := []string{"README", "a.txt", "b-side.txt", "b/1.txt", "b/2.txt", "b/c/3.txt", "logs/x.log", "zz/deep/y.y"}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("Expected keys %v, got %v", want, keys)
+	}
+
+	// With a delimiter, subdirectories roll up into common prefixes
+	result := listObjects(t, srv, url.Values{"list-type": {"2"}, "prefix": {"b"}, "delimiter": {"/"}})
+	if len(result.Contents) != 1 || result.Contents[0].Key != "b-side.txt" || result.Contents[0].Size != 2 {
+		t.Errorf("Expected b-side.txt, got %+v", result.Contents)
+	}
+	if len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0].Prefix != "b/" {
+		t.Errorf("Expected the b/ common prefix, got %+v", result.CommonPrefixes)
+	}
+
+	result = listObjects(t, srv, url.Values{"prefix": {"b/"}, "delimiter": {"/"}, "max-keys": {"2"}})
+	if !result.IsTruncated || result.NextMarker != "b/2.txt" || len(result.Contents) != 2 {
+		t.Fatalf("Expected a truncated V1 page ending at b/2.txt, got %+v", result)
+	}
+	result = listObjects(t, srv, url.Values{"prefix": {"b/"}, "delimiter": {"/"}, "marker": {result.NextMarker}})
+	if result.IsTruncated || len(result.Contents) != 0 || len(result.CommonPrefixes) != 1 || result.CommonPrefixes[0].Prefix != "b/c/" {
+		t.Errorf("Expected only the b/c/ common prefix after the marker, got %+v", result)
+	}
+
+	result = listObjects(t, srv, url.Values{"list-type": {"2"}, "prefix": {"nope/"}})
+	if len(result.Contents) != 0 || result.IsTruncated {
+		t.Errorf("Expected an empty listing for a missing prefix, got %+v", result)
+	}
+}
+
+func TestGetObjectRanges(t *testing.T) {
+	srv := newTestGateway(t, map[string]string{"/dir/obj": "0123456789"})
+
+	resp, body := get(t, srv, http.MethodGet, "/data/dir/obj", nil)
+	if resp.StatusCode != http.StatusOK || string(body) != "0123456789" {
+		t.Fatalf("Expected the whole object, got %d %q", resp.StatusCode, body)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Error("Expected an ETag")
+	}
+
+	resp, body = get(t, srv, http.MethodGet, "/data/dir/obj", map[string]string{"Range": "bytes=3-6"})
+	if resp.StatusCode != http.StatusPartialContent || string(body) != "3456" {
+		t.Errorf("Expected bytes 3-6, got %d %q", resp.StatusCode, body)
+	}
+	if got := resp.Header.Get("Content-Range"); got != "bytes 3-6/10" {
+		t.Errorf("Expected Content-Range bytes 3-6/10, got %q", got)
+	}
+
+	resp, body = get(t, srv, http.MethodGet, "/data/dir/obj", map[string]string{"Range": "bytes=10-"})
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable || !bytes.Contains(body, []byte("<Code>InvalidRange</Code>")) {
+		t.Errorf("Expected InvalidRange, got %d %s", resp.StatusCode, body)
+	}
+
+	resp, body = get(t, srv, http.MethodHead, "/data/dir/obj", nil)
+	if resp.StatusCode != http.StatusOK || len(body) != 0 || resp.ContentLength != 10 || resp.Header.Get("ETag") != etag {
+		t.Errorf("Expected HeadObject to report the object, got %d %d %q", resp.StatusCode, resp.ContentLength, body)
+	}
+
+	for _, key := range []string{"/data/dir/missing", "/data/dir"} {
+		resp, body = get(t, srv, http.MethodGet, key, nil)
+		if resp.StatusCode != http.StatusNotFound || !bytes.Contains(body, []byte("<Code>NoSuchKey</Code>")) {
+			t.Errorf("%s: expected NoSuchKey, got %d %s", key, resp.StatusCode, body)
+		}
+	}
+
+	resp, _ = get(t, srv, http.MethodPut, "/data/dir/obj", nil)
+	if resp.StatusCode != http.StatusNotImplemented {
+		t.Errorf("Expected PUT to be rejected, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,346 @@
+// Package webdav exposes a filesystem.FileSystem over WebDAV, so it can be
+// mounted natively by the file managers of Windows, macOS and Linux
+// without FUSE
+package webdav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/net/webdav"
+)
+
+// NewHandler returns a WebDAV handler serving fs under the URL prefix, with
+// in-memory locks
+func NewHandler(fs filesystem.FileSystem, prefix string) *webdav.Handler {
+	return &webdav.Handler{
+		Prefix:     prefix,
+		FileSystem: NewFileSystem(fs),
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil {
+				log.Debugf("webdav: %s %s: %v", r.Method, r.URL.Path, err)
+			}
+		},
+	}
+}
+
+// FileSystem adapts a filesystem.FileSystem to webdav.FileSystem. Every
+// operation is bound to the request's context.
+type FileSystem struct {
+	fs filesystem.FileSystem
+}
+
+// NewFileSystem creates a WebDAV file system backed by fs
+func NewFileSystem(fs filesystem.FileSystem) *FileSystem {
+	return &FileSystem{fs: fs}
+}
+
+// Ensure FileSystem implements webdav.FileSystem
+var _ webdav.FileSystem = (*FileSystem)(nil)
+
+func (a *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	name = clean(name)
+	return osError("mkdir", name, filesystem.WithContext(a.fs, ctx).Mkdir(name, filesystem.FromFileMode(perm)))
+}
+
+func (a *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	name = clean(name)
+	return osError("remove", name, filesystem.WithContext(a.fs, ctx).RemoveAll(name))
+}
+
+func (a *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldName, newName = clean(oldName), clean(newName)
+	return osError("rename", oldName, filesystem.WithContext(a.fs, ctx).Rename(oldName, newName))
+}
+
+func (a *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	name = clean(name)
+	info, err := filesystem.WithContext(a.fs, ctx).Stat(name)
+	if err != nil {
+		return nil, osError("stat", name, err)
+	}
+	return newFileInfo(name, info), nil
+}
+
+// OpenFile opens name for reading, or for writing when flag has O_WRONLY or
+// O_RDWR. A truncating open writes through OpenWrite, so the new content
+// replaces the file on Close; other writes go to the file at the current
+// offset as they're made.
+func (a *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	name = clean(name)
+	fs := filesystem.WithContext(a.fs, ctx)
+	f := &file{fs: fs, name: name}
+
+	if flag&(os.O_WRONLY|os.O_RDWR) == 0 {
+		info, err := fs.Stat(name)
+		if err != nil {
+			return nil, osError("open", name, err)
+		}
+		f.info = info
+		return f, nil
+	}
+
+	info, err := fs.Stat(name)
+	switch {
+	case err == nil && flag&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case err == nil && info.IsDir:
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	case err != nil && flag&os.O_CREATE == 0:
+		return nil, osError("open", name, err)
+	case err != nil:
+		// Not every plugin reports a missing file as such, so any Stat
+		// failure means create; a real failure surfaces from the write
+		info = nil
+	}
+	f.info = info
+
+	if flag&os.O_TRUNC != 0 || info == nil {
+		w, err := fs.OpenWrite(name)
+		if err != nil {
+			return nil, osError("open", name, err)
+		}
+		f.writer = w
+	}
+	f.writable = true
+	return f, nil
+}
+
+var errIsDir = errors.New("is a directory")
+
+// file is a webdav.File. Regular files are read at the current offset with
+// Read; files whose content changes as it's read (streams, queues) are read
+// whole once, on first use, and served from that copy.
+type file struct {
+	fs   filesystem.FileSystem
+	name string
+	info *filesystem.FileInfo // nil for a file being created
+
+	offset   int64
+	loaded   []byte // content of a sequential file, once read
+	isLoaded bool
+
+	writable bool
+	writer   io.WriteCloser // set for truncating opens
+	written  int64
+
+	entries []filesystem.FileInfo // unread directory entries, once listed
+	listed  bool
+}
+
+func (f *file) Close() error {
+	if f.writer != nil {
+		return osError("close", f.name, f.writer.Close())
+	}
+	return nil
+}
+
+func (f *file) Read(p []byte) (int, error) {
+	if f.writer != nil || f.info == nil {
+		return 0, io.EOF
+	}
+	if f.info.IsDir {
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	}
+	if sequential(f.info) {
+		if err := f.load(); err != nil {
+			return 0, err
+		}
+		if f.offset >= int64(len(f.loaded)) {
+			return 0, io.EOF
+		}
+		n := copy(p, f.loaded[f.offset:])
+		f.offset += int64(n)
+		return n, nil
+	}
+
+	data, err := f.fs.Read(f.name, f.offset, int64(len(p)))
+	n := copy(p, data)
+	f.offset += int64(n)
+	if err != nil && err != io.EOF {
+		return n, osError("read", f.name, err)
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// load reads a sequential file whole, once
+func (f *file) load() error {
+	if f.isLoaded {
+		return nil
+	}
+	data, err := f.fs.Read(f.name, 0, -1)
+	if err != nil && err != io.EOF {
+		return osError("read", f.name, err)
+	}
+	f.loaded, f.isLoaded = data, true
+	return nil
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if f.writer != nil {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	var size int64
+	if f.info != nil {
+		size = f.info.Size
+		if sequential(f.info) {
+			if err := f.load(); err != nil {
+				return 0, err
+			}
+			size = int64(len(f.loaded))
+		}
+	}
+
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += size
+	default:
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	if offset < 0 {
+		return 0, &os.PathError{Op: "seek", Path: f.name, Err: os.ErrInvalid}
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *file) Write(p []byte) (int, error) {
+	if !f.writable {
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	if f.writer != nil {
+		n, err := f.writer.Write(p)
+		f.written += int64(n)
+		return n, osError("write", f.name, err)
+	}
+	n, err := f.fs.Write(f.name, p, f.offset, filesystem.WriteFlagNone)
+	f.offset += n
+	return int(n), osError("write", f.name, err)
+}
+
+// Readdir returns up to count entries, or all remaining ones if count <= 0
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if f.info == nil || !f.info.IsDir {
+		return nil, &os.PathError{Op: "readdir", Path: f.name, Err: filesystem.NewNotDirectoryError(f.name)}
+	}
+	if !f.listed {
+		entries, err := f.fs.ReadDir(f.name)
+		if err != nil {
+			return nil, osError("readdir", f.name, err)
+		}
+		f.entries, f.listed = entries, true
+	}
+
+	n := len(f.entries)
+	if count > 0 {
+		if n == 0 {
+			return nil, io.EOF
+		}
+		n = min(n, count)
+	}
+	infos := make([]os.FileInfo, n)
+	for i := range infos {
+		entry := f.entries[i]
+		infos[i] = newFileInfo(path.Join(f.name, entry.Name), &entry)
+	}
+	f.entries = f.entries[n:]
+	return infos, nil
+}
+
+func (f *file) Stat() (os.FileInfo, error) {
+	// Content written through OpenWrite only lands on Close, so report
+	// what has been written so far rather than the file system's view
+	if f.writer != nil {
+		return &fileInfo{name: path.Base(f.name), info: &filesystem.FileInfo{
+			Name:    path.Base(f.name),
+			Size:    f.written,
+			Mode:    0644,
+			ModTime: time.Now(),
+		}}, nil
+	}
+	if f.info == nil {
+		return nil, &os.PathError{Op: "stat", Path: f.name, Err: os.ErrNotExist}
+	}
+	return newFileInfo(f.name, f.info), nil
+}
+
+// fileInfo is an os.FileInfo for a filesystem.FileInfo
+type fileInfo struct {
+	name string
+	info *filesystem.FileInfo
+}
+
+func newFileInfo(name string, info *filesystem.FileInfo) *fileInfo {
+	return &fileInfo{name: path.Base(name), info: info}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.info.Size }
+func (fi *fileInfo) ModTime() time.Time { return fi.info.ModTime }
+func (fi *fileInfo) IsDir() bool        { return fi.info.IsDir }
+func (fi *fileInfo) Sys() any           { return fi.info }
+
+func (fi *fileInfo) Mode() os.FileMode {
+	mode := filesystem.ToFileMode(fi.info.Mode)
+	if fi.info.IsDir {
+		mode |= os.ModeDir
+	}
+	return mode
+}
+
+// ContentType implements webdav.ContentTyper, preferring the type the
+// plugin reports. PROPFIND otherwise sniffs the first bytes of every file it
+// lists, which would consume a queue's messages.
+func (fi *fileInfo) ContentType(ctx context.Context) (string, error) {
+	if ctype := filesystem.KnownContentType(fi.name, fi.info); ctype != "" {
+		return ctype, nil
+	}
+	if sequential(fi.info) {
+		return "application/octet-stream", nil
+	}
+	return "", webdav.ErrNotImplemented
+}
+
+// sequential reports whether a file's content changes as it's read, so
+// it must be read whole in a single request
+func sequential(info *filesystem.FileInfo) bool {
+	return info.Meta.Sequential()
+}
+
+func clean(name string) string {
+	return path.Clean("/" + name)
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, os.ErrNotExist) || filesystem.KindOf(err) == filesystem.KindNotFound
+}
+
+// osError converts a filesystem error to the os errors the webdav package
+// checks for with os.IsNotExist and os.IsExist, which don't unwrap
+func osError(op, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case isNotExist(err):
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, os.ErrExist) || filesystem.KindOf(err) == filesystem.KindAlreadyExists:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrExist}
+	case errors.Is(err, os.ErrPermission) || filesystem.KindOf(err) == filesystem.KindPermissionDenied:
+		return &os.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return err
+}
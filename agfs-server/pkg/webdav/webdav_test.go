@@ -0,0 +1,181 @@
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/c4pt0r/agfs/agfs-server/pkg/filesystem"
+	"github.com/c4pt0r/agfs/agfs-server/pkg/plugins/memfs"
+)
+
+func newTestServer(t *testing.T, fs filesystem.FileSystem) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(NewHandler(fs, "/dav"))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func do(t *testing.T, srv *httptest.Server, method, path, body string, header map[string]string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest failed: %v", err)
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("Reading body failed: %v", err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestPutGetRoundTrip(t *testing.T) {
+	srv := newTestServer(t, memfs.NewMemoryFS())
+
+	if status, _ := do(t, srv, "PUT", "/dav/notes.txt", "hello webdav", nil); status != http.StatusCreated {
+		t.Fatalf("Expected PUT to create the file, got %d", status)
+	}
+	status, body := do(t, srv, "GET", "/dav/notes.txt", "", nil)
+	if status != http.StatusOK || body != "hello webdav" {
+		t.Fatalf("Expected GET to return the content, got %d %q", status, body)
+	}
+
+	// Overwriting with shorter content must not leave the old tail behind
+	if status, _ := do(t, srv, "PUT", "/dav/notes.txt", "bye", nil); status != http.StatusCreated {
+		t.Fatalf("Expected PUT to overwrite the file, got %d", status)
+	}
+	if _, body := do(t, srv, "GET", "/dav/notes.txt", "", nil); body != "bye" {
+		t.Errorf("Expected the new content, got %q", body)
+	}
+
+	status, body = do(t, srv, "GET", "/dav/notes.txt", "", map[string]string{"Range": "bytes=1-"})
+	if status != http.StatusPartialContent || body != "ye" {
+		t.Errorf("Expected a ranged GET to seek, got %d %q", status, body)
+	}
+}
+
+func TestPropfindListsDirectory(t *testing.T) {
+	srv := newTestServer(t, memfs.NewMemoryFS())
+
+	if status, _ := do(t, srv, "MKCOL", "/dav/docs", "", nil); status != http.StatusCreated {
+		t.Fatalf("Expected MKCOL to create the directory, got %d", status)
+	}
+	for name, data := range map[string]string{"a.txt": "aaa", "b.bin": "bbbbb"} {
+		if status, _ := do(t, srv, "PUT", "/dav/docs/"+name, data, nil); status != http.StatusCreated {
+			t.Fatalf("PUT %s failed with %d", name, status)
+		}
+	}
+
+	status, body := do(t, srv, "PROPFIND", "/dav/docs/", "", map[string]string{"Depth": "1"})
+	if status != http.StatusMultiStatus {
+		t.Fatalf("Expected 207, got %d", status)
+	}
+	for _, want := range []string{
+		"<D:href>/dav/docs/</D:href>",
+		"<D:href>/dav/docs/a.txt</D:href>",
+		"<D:href>/dav/docs/b.bin</D:href>",
+		"<D:getcontentlength>5</D:getcontentlength>",
+		"<D:collection",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected the listing to contain %s, got %s", want, body)
+		}
+	}
+
+	if status, _ := do(t, srv, "MOVE", "/dav/docs/a.txt", "", map[string]string{"Destination": srv.URL + "/dav/docs/c.txt"}); status != http.StatusCreated {
+		t.Fatalf("Expected MOVE to succeed, got %d", status)
+	}
+	if status, body := do(t, srv, "GET", "/dav/docs/c.txt", "", nil); status != http.StatusOK || body != "aaa" {
+		t.Errorf("Expected the moved file, got %d %q", status, body)
+	}
+	if status, _ := do(t, srv, "DELETE", "/dav/docs", "", nil); status != http.StatusNoContent {
+		t.Errorf("Expected DELETE to remove the directory, got %d", status)
+	}
+}
+
+func TestMissingFileIsNotFound(t *testing.T) {
+	for _, err := range []error{
+		filesystem.NewNotFoundError("stat", "/x"),
+		fmt.Errorf("lookup failed: %w", filesystem.NewNotFoundError("stat", "/x")),
+		os.ErrNotExist,
+	} {
+		srv := newTestServer(t, statErrorFS{FileSystem: memfs.NewMemoryFS(), err: err})
+		if status, _ := do(t, srv, "PROPFIND", "/dav/x", "", map[string]string{"Depth": "0"}); status != http.StatusNotFound {
+			t.Errorf("Expected %q to map to 404, got %d", err, status)
+		}
+	}
+
+	err := osError("mkdir", "/x", filesystem.NewAlreadyExistsError("directory", "/x"))
+	if !os.IsExist(err) {
+		t.Errorf("Expected an already exists error to satisfy os.IsExist, got %v", err)
+	}
+}
+
+// statErrorFS fails every Stat with err
+type statErrorFS struct {
+	filesystem.FileSystem
+	err error
+}
+
+func (fs statErrorFS) Stat(path string) (*filesystem.FileInfo, error) {
+	return nil, fs.err
+}
+
+func TestConsumeOnceFileReadOnce(t *testing.T) {
+	fs := &queueFS{FileSystem: memfs.NewMemoryFS()}
+	srv := newTestServer(t, fs)
+
+	status, body := do(t, srv, "GET", "/dav/dequeue", "", nil)
+	if status != http.StatusOK || body != "message 1" {
+		t.Fatalf("Expected the first message, got %d %q", status, body)
+	}
+	if n := fs.reads.Load(); n != 1 {
+		t.Errorf("Expected the file to be read once, got %d reads", n)
+	}
+
+	// Listing the file must not sniff, and so dequeue, its content
+	if status, _ := do(t, srv, "PROPFIND", "/dav/dequeue", "", map[string]string{"Depth": "0"}); status != http.StatusMultiStatus {
+		t.Fatalf("Expected 207, got %d", status)
+	}
+	if n := fs.reads.Load(); n != 1 {
+		t.Errorf("Expected PROPFIND not to read the file, got %d reads", n)
+	}
+}
+
+// queueFS serves /dequeue as a consume-once file returning a new message on
+// every Read
+type queueFS struct {
+	filesystem.FileSystem
+	reads atomic.Int32
+}
+
+func (fs *queueFS) Stat(path string) (*filesystem.FileInfo, error) {
+	if path != "/dequeue" {
+		return fs.FileSystem.Stat(path)
+	}
+	return &filesystem.FileInfo{
+		Name: "dequeue",
+		Mode: 0444,
+		Meta: filesystem.MetaData{Content: map[string]string{filesystem.MetaAccess: filesystem.AccessConsumeOnce}},
+	}, nil
+}
+
+func (fs *queueFS) Read(path string, offset, size int64) ([]byte, error) {
+	if path != "/dequeue" {
+		return fs.FileSystem.Read(path, offset, size)
+	}
+	return []byte(fmt.Sprintf("message %d", fs.reads.Add(1))), nil
+}